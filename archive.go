@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/archive"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+)
+
+// runArchive implements the "archive" command, it packs the files given as
+// arguments into a zip archive and returns the process exit code
+func runArchive(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	output := flags.String("o", "archive.zip", "file to write the zip archive to")
+	volumeSize := flags.Int("volume-size", 0, "split the archive into volumes of this many MB")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: archive [-o archive.zip] [-volume-size MB] file...")
+		return 2
+	}
+
+	var buf bytes.Buffer
+	zipWriter := archive.NewZipWriter(&buf)
+	for _, path := range flags.Args() {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			return 1
+		}
+		if !info.Mode().IsRegular() {
+			fmt.Fprintf(os.Stderr, "%s is not a regular file\n", path)
+			return 1
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			return 1
+		}
+		if err := zipWriter.AddFile(context.Background(), entryName(path), info.ModTime(), data); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to add %s: %v\n", path, err)
+			return 1
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write archive: %v\n", err)
+		return 1
+	}
+	if err := writeArchive(*output, buf.Bytes(), *volumeSize); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// passwordVariable is the environment variable encrypted containers take
+// their password from, so it never shows up in the process list
+const passwordVariable = "ARCHIVE_PASSWORD"
+
+// writeArchive writes data to path, or with a volume size in MB to the
+// volumes path.001, path.002 and so on
+func writeArchive(path string, data []byte, volumeSize int) error {
+	if volumeSize <= 0 {
+		return os.WriteFile(path, data, 0o644)
+	}
+	volumes, err := archive.NewVolumeWriter(path, int64(volumeSize)*1024*1024)
+	if err != nil {
+		return err
+	}
+	if _, err := volumes.Write(data); err != nil {
+		volumes.Close()
+		return err
+	}
+	return volumes.Close()
+}
+
+// entryName keeps relative paths like docs/a.txt as they are and reduces
+// anything pointing outside the current directory to its base name
+func entryName(path string) string {
+	if filepath.IsLocal(path) {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	return filepath.Base(path)
+}
+
+// runTar implements the "tar" command, it writes a directory tree to a
+// .tar.gz and returns the process exit code
+func runTar(args []string) int {
+	flags := flag.NewFlagSet("tar", flag.ContinueOnError)
+	output := flags.String("o", "archive.tar.gz", "file to write the .tar.gz to")
+	skipSymlinks := flags.Bool("skip-symlinks", false, "leave symbolic links out instead of storing them")
+	volumeSize := flags.Int("volume-size", 0, "split the archive into volumes of this many MB")
+	appendTo := flags.Bool("append", false, "append to the existing archive instead of replacing it")
+	incremental := flags.Bool("incremental", false, "with -append, only add files changed since the archive was last written")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || (*appendTo && *volumeSize > 0) {
+		fmt.Fprintln(os.Stderr, "usage: tar [-o archive.tar.gz] [-skip-symlinks] [-volume-size MB | -append [-incremental]] path")
+		return 2
+	}
+
+	options := tarOptions(*skipSymlinks)
+	if *appendTo {
+		info, err := os.Stat(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to append to %s: %v\n", *output, err)
+			return 1
+		}
+		if *incremental {
+			options.ModifiedAfter = info.ModTime()
+		}
+		if err := archive.AppendTarGz(context.Background(), *output, flags.Arg(0), options); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to append %s to %s: %v\n", flags.Arg(0), *output, err)
+			return 1
+		}
+		return 0
+	}
+	data, err := archive.CreateTarGz(context.Background(), flags.Arg(0), options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to archive %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if err := writeArchive(*output, data, *volumeSize); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// runUntar implements the "untar" command, it restores a .tar.gz and returns
+// the process exit code
+func runUntar(args []string) int {
+	flags := flag.NewFlagSet("untar", flag.ContinueOnError)
+	dest := flags.String("C", ".", "directory to restore the archive into")
+	skipSymlinks := flags.Bool("skip-symlinks", false, "ignore symbolic links in the archive")
+	atomic := flags.Bool("atomic", false, "stage the archive and move it into place once all of it was read")
+	onConflict := flags.String("on-conflict", "overwrite", "what -atomic does with paths that exist: overwrite, skip or rename")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: untar [-C dir] [-skip-symlinks] [-atomic [-on-conflict overwrite|skip|rename]] archive.tar.gz")
+		return 2
+	}
+	options := tarOptions(*skipSymlinks)
+	policy, err := archive.ParseCollisionPolicy(*onConflict)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if policy != archive.CollisionOverwrite && !*atomic {
+		fmt.Fprintln(os.Stderr, "-on-conflict needs -atomic")
+		return 2
+	}
+	options.Collisions = policy
+
+	data, err := archive.ReadVolumes(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if !*atomic {
+		if err := archive.ExtractTarGz(context.Background(), data, *dest, options); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to extract %s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		return 0
+	}
+	report, err := archive.ExtractTarGzAtomic(context.Background(), data, *dest, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to extract %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	for _, name := range report.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s, it exists\n", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(report.Renamed)) {
+		fmt.Fprintf(os.Stderr, "extracted %s as %s\n", name, report.Renamed[name])
+	}
+	return 0
+}
+
+func tarOptions(skipSymlinks bool) archive.TarOptions {
+	if skipSymlinks {
+		return archive.TarOptions{Symlinks: archive.SymlinksSkip}
+	}
+	return archive.TarOptions{Symlinks: archive.SymlinksPreserve}
+}
+
+// runContainer implements the "container" command with its create, add, list
+// and extract subcommands, it returns the process exit code
+func runContainer(args []string) int {
+	usage := "usage: container create [-o out." + archive.ContainerExtension + "] [-algorithm name] [-volume-size MB] [-block-size KB] [-encrypt] file[=algorithm]...\n" +
+		"       container add [-algorithm name] file." + archive.ContainerExtension + " file[=algorithm]...\n" +
+		"       container list file." + archive.ContainerExtension + "\n" +
+		"       container extract [-C dir] [-salvage] file." + archive.ContainerExtension + " [name...]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "create":
+		return runContainerCreate(args[1:])
+	case "add":
+		return runContainerAdd(args[1:])
+	case "list":
+		return runContainerList(args[1:])
+	case "extract":
+		return runContainerExtract(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func runContainerCreate(args []string) int {
+	flags := flag.NewFlagSet("container create", flag.ContinueOnError)
+	output := flags.String("o", "container."+archive.ContainerExtension, "file to write the container to")
+	algorithm := flags.String("algorithm", "gzip", "algorithm for files that do not name their own")
+	volumeSize := flags.Int("volume-size", 0, "split the container into volumes of this many MB")
+	blockSize := flags.Int("block-size", 0, "split files into blocks of this many KB with a checksum each, so a damaged block can be skipped")
+	encrypt := flags.Bool("encrypt", false, "encrypt the container with the password in $"+passwordVariable)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no files given")
+		return 2
+	}
+
+	var buf bytes.Buffer
+	containerWriter := archive.NewContainerWriter(&buf)
+	containerWriter.BlockSize = *blockSize << 10
+	if !addContainerFiles(containerWriter, flags.Args(), *algorithm) {
+		return 1
+	}
+	if err := containerWriter.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write container: %v\n", err)
+		return 1
+	}
+	data := buf.Bytes()
+	if *encrypt {
+		sealed, err := encryption.Seal(os.Getenv(passwordVariable), data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encrypt container, is $%s set? %v\n", passwordVariable, err)
+			return 1
+		}
+		data = sealed
+	}
+	if err := writeArchive(*output, data, *volumeSize); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// runContainerAdd appends files to an existing container, files with the name
+// of an entry replace it and the other entries are not rewritten
+func runContainerAdd(args []string) int {
+	flags := flag.NewFlagSet("container add", flag.ContinueOnError)
+	algorithm := flags.String("algorithm", "gzip", "algorithm for files that do not name their own")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: container add [-algorithm name] file."+archive.ContainerExtension+" file[=algorithm]...")
+		return 2
+	}
+
+	file, err := os.OpenFile(flags.Arg(0), os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	containerWriter, err := archive.AppendToContainer(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if !addContainerFiles(containerWriter, flags.Args()[1:], *algorithm) {
+		// cutting off what was appended brings back the container as it was
+		file.Truncate(info.Size())
+		return 1
+	}
+	if err := containerWriter.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write container: %v\n", err)
+		file.Truncate(info.Size())
+		return 1
+	}
+	return 0
+}
+
+// addContainerFiles adds the files named by args, each with its own
+// algorithm or the given one, and reports whether all of them were added
+func addContainerFiles(containerWriter *archive.ContainerWriter, args []string, algorithm string) bool {
+	for _, arg := range args {
+		path, fileAlgorithm := arg, algorithm
+		// report.txt=huffman picks the algorithm of a single file
+		if i := strings.LastIndex(arg, "="); i >= 0 && (compression.IsValidAlgorithm(arg[i+1:]) || arg[i+1:] == archive.ContainerStore) {
+			path, fileAlgorithm = arg[:i], arg[i+1:]
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			return false
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			return false
+		}
+		if err := containerWriter.AddFile(context.Background(), entryName(path), info.ModTime(), fileAlgorithm, data); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to add %s: %v\n", path, err)
+			return false
+		}
+	}
+	return true
+}
+
+func runContainerList(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: container list file."+archive.ContainerExtension)
+		return 2
+	}
+	containerReader, ok := openContainerFile(args[0])
+	if !ok {
+		return 1
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "Size\tCompressed\tAlgorithm\tModified\tName\t")
+	for _, entry := range containerReader.Entries() {
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t\n", entry.Size, entry.CompressedSize, entry.Algorithm, entry.Modified.Format("2006-01-02 15:04"), entry.Name)
+	}
+	writer.Flush()
+	return 0
+}
+
+func runContainerExtract(args []string) int {
+	flags := flag.NewFlagSet("container extract", flag.ContinueOnError)
+	dest := flags.String("C", ".", "directory to extract into")
+	salvage := flags.Bool("salvage", false, "leave out damaged blocks instead of failing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no container given")
+		return 2
+	}
+	containerReader, ok := openContainerFile(flags.Arg(0))
+	if !ok {
+		return 1
+	}
+	names := flags.Args()[1:]
+	if len(names) == 0 {
+		for _, entry := range containerReader.Entries() {
+			names = append(names, entry.Name)
+		}
+	}
+
+	status := 0
+	for _, name := range names {
+		var data []byte
+		var err error
+		if *salvage {
+			var damaged []archive.DamagedBlock
+			data, damaged, err = containerReader.SalvageFile(context.Background(), name)
+			for _, block := range damaged {
+				fmt.Fprintf(os.Stderr, "%s: left out bytes %d to %d: %v\n", name, block.Offset, block.Offset+block.Size, block.Err)
+				status = 1
+			}
+		} else {
+			data, err = containerReader.ReadFile(context.Background(), name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to extract %s: %v\n", name, err)
+			return 1
+		}
+		// entry names are checked to be local when the index is read
+		target := filepath.Join(*dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to extract %s: %v\n", name, err)
+			return 1
+		}
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to extract %s: %v\n", name, err)
+			return 1
+		}
+	}
+	return status
+}
+
+func openContainerFile(path string) (*archive.ContainerReader, bool) {
+	data, err := archive.ReadVolumes(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+		return nil, false
+	}
+	containerReader, err := archive.OpenEncryptedContainer(data, os.Getenv(passwordVariable))
+	if errors.Is(err, encryption.ErrPasswordRequired) {
+		fmt.Fprintf(os.Stderr, "%s is encrypted, set the password in $%s\n", path, passwordVariable)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", path, err)
+		return nil, false
+	}
+	return containerReader, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/bench"
+)
+
+// runBench implements the "bench" command, it returns the process exit code
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	corpora := flags.String("corpus", "random,zeros", "comma-separated corpora to run ("+strings.Join(bench.CorpusNames(), ", ")+")")
+	algorithms := flags.String("algorithms", "", "comma-separated algorithms to run, all supported ones when empty")
+	cacheDir := flags.String("cache-dir", "", "directory downloaded corpora are cached in")
+	size := flags.Int("size", bench.DefaultGeneratedSize, "size in bytes of the generated corpora")
+	enwik8Size := flags.Int("enwik8-size", bench.DefaultEnwik8Size, "number of bytes of enwik8 to use")
+	format := flags.String("format", "table", "output format: table or json")
+	output := flags.String("o", "", "write the results to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format: %s\n", *format)
+		return 2
+	}
+
+	options := bench.CorpusOptions{CacheDir: *cacheDir, GeneratedSize: *size, Enwik8Size: *enwik8Size}
+	var loaded []*bench.Corpus
+	for _, name := range splitList(*corpora) {
+		corpus, err := bench.LoadCorpus(name, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load corpus %s: %v\n", name, err)
+			return 1
+		}
+		loaded = append(loaded, corpus)
+	}
+
+	report := bench.Run(loaded, splitList(*algorithms))
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	var err error
+	if *format == "json" {
+		err = report.WriteJSON(out)
+	} else {
+		err = report.WriteTable(out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write results: %v\n", err)
+		return 1
+	}
+	if len(report.Failed()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+)
+
+// runChecksum implements the "checksum" command, it prints the checksums of
+// files in the tagged format of shasum --tag and returns the process exit
+// code
+func runChecksum(args []string) int {
+	flags := flag.NewFlagSet("checksum", flag.ContinueOnError)
+	list := flags.String("a", "", "algorithms separated by commas, of "+strings.Join(checksum.Algorithms, ", ")+"; all of them by default")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: checksum [-a crc32,sha256] file...")
+		return 2
+	}
+	algorithms, err := checksum.ParseAlgorithms(*list)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		sums, _, err := checksum.Compute(file, algorithms)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		for _, algorithm := range algorithms {
+			fmt.Printf("%s (%s) = %s\n", strings.ToUpper(algorithm), path, sums[algorithm])
+		}
+	}
+	return status
+}
@@ -0,0 +1,146 @@
+//go:build js && wasm
+
+// Command wasm exposes the algorithms to JavaScript, so pages can compress
+// and decompress files in the browser without uploading them. Build it with
+// "make wasm", which also copies the wasm_exec.js loader next to it, and use
+// it from a page as
+//
+//	const go = new Go();
+//	const { instance } = await WebAssembly.instantiateStreaming(fetch("compression.wasm"), go.importObject);
+//	go.run(instance);
+//	const { data, compressionRatio } = await compressionTool.compress("gzip", bytes);
+//	const { data: original } = await compressionTool.decompress(compressionTool.detect(data), data);
+//
+// compress and decompress take a Uint8Array and return promises, so the page
+// stays responsive while they run; they reject with an Error on failure.
+package main
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+func main() {
+	algorithms := compression.GetSupportedAlgorithms()
+	names := make([]any, len(algorithms))
+	for i, algorithm := range algorithms {
+		names[i] = algorithm
+	}
+
+	js.Global().Set("compressionTool", js.ValueOf(map[string]any{
+		"algorithms": names,
+		"compress":   js.FuncOf(compress),
+		"decompress": js.FuncOf(decompress),
+		"detect":     js.FuncOf(detect),
+	}))
+	// the functions are called from JavaScript for as long as the page lives
+	select {}
+}
+
+// compress(algorithm, data, {btype, bfinal}) resolves to {data,
+// originalSize, compressedSize, compressionRatio, peakBufferBytes}
+func compress(this js.Value, args []js.Value) any {
+	return run(args, func(algorithm string, data []byte) (map[string]any, error) {
+		options := compression.Options{Algorithm: algorithm}
+		if len(args) > 2 && args[2].Type() == js.TypeObject {
+			if btype := args[2].Get("btype"); btype.Type() == js.TypeNumber {
+				options.BType = uint32(btype.Int())
+			}
+			if bfinal := args[2].Get("bfinal"); bfinal.Type() == js.TypeNumber {
+				options.Partial = bfinal.Int() == 0
+			}
+		}
+		compressed, stats, err := compression.CompressContext(context.Background(), data, options)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"data":             toJS(compressed),
+			"originalSize":     stats.OriginalSize,
+			"compressedSize":   stats.ProcessedSize,
+			"compressionRatio": stats.CompressionRatio,
+			"peakBufferBytes":  stats.PeakBufferBytes,
+		}, nil
+	})
+}
+
+// decompress(algorithm, data) resolves to {data, originalSize,
+// compressedSize, peakBufferBytes}
+func decompress(this js.Value, args []js.Value) any {
+	return run(args, func(algorithm string, data []byte) (map[string]any, error) {
+		decompressed, stats, err := compression.DecompressContext(context.Background(), data, compression.Options{Algorithm: algorithm})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"data":            toJS(decompressed),
+			"originalSize":    stats.ProcessedSize,
+			"compressedSize":  stats.OriginalSize,
+			"peakBufferBytes": stats.PeakBufferBytes,
+		}, nil
+	})
+}
+
+// detect(data) returns the algorithm data was compressed with, or "" when
+// it is not recognised
+func detect(this js.Value, args []js.Value) any {
+	if len(args) < 1 || !isUint8Array(args[0]) {
+		return ""
+	}
+	return compression.Detect(toGo(args[0]))
+}
+
+// run checks the (algorithm, data) arguments and returns a promise of the
+// result of f, which runs in its own goroutine
+func run(args []js.Value, f func(algorithm string, data []byte) (map[string]any, error)) any {
+	var algorithm string
+	var data []byte
+	var err error
+	switch {
+	case len(args) < 2 || args[0].Type() != js.TypeString || !isUint8Array(args[1]):
+		err = errors.New("expected an algorithm name and a Uint8Array")
+	case !compression.IsValidAlgorithm(args[0].String()):
+		err = errors.New("unsupported algorithm: " + args[0].String())
+	default:
+		// copied before returning, the caller may change the array meanwhile
+		algorithm, data = args[0].String(), toGo(args[1])
+	}
+
+	handler := js.FuncOf(func(this js.Value, promiseArgs []js.Value) any {
+		resolve, reject := promiseArgs[0], promiseArgs[1]
+		if err != nil {
+			reject.Invoke(js.Global().Get("Error").New(err.Error()))
+			return nil
+		}
+		go func() {
+			result, err := f(algorithm, data)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(js.ValueOf(result))
+		}()
+		return nil
+	})
+	defer handler.Release()
+	return js.Global().Get("Promise").New(handler)
+}
+
+func isUint8Array(v js.Value) bool {
+	return v.InstanceOf(js.Global().Get("Uint8Array"))
+}
+
+func toGo(v js.Value) []byte {
+	data := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(data, v)
+	return data
+}
+
+func toJS(data []byte) js.Value {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+	return array
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/naming"
+)
+
+// runCompress implements the "compress" command, it compresses a file with
+// one algorithm and returns the process exit code. gzip output carries the
+// modification time of the file.
+func runCompress(args []string) int {
+	flags := flag.NewFlagSet("compress", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the output to, the input name with the extension of the algorithm by default")
+	name := flags.String("name", "", "template the output is named after next to the input, e.g. {name}.{date}.{algext}, instead of -o")
+	algorithm := flags.String("algorithm", "gzip", "algorithm, of "+strings.Join(compression.GetSupportedAlgorithms(), ", "))
+	sums := flags.String("sums", "", "print these checksums of the input, of "+strings.Join(checksum.Algorithms, ", ")+", computed while compressing")
+	jsonStats := flags.Bool("json", false, "print the stats as a JSON stats record instead")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *output != "" && *name != "" {
+		fmt.Fprintln(os.Stderr, "usage: compress [-o out | -name template] [-algorithm gzip] [-sums crc32,sha256] [-json] file")
+		return 2
+	}
+	var hashes []string
+	if *sums != "" {
+		var err error
+		if hashes, err = checksum.ParseAlgorithms(*sums); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if !compression.IsValidAlgorithm(*algorithm) {
+		fmt.Fprintf(os.Stderr, "unsupported algorithm %s, supported: %v\n", *algorithm, compression.GetSupportedAlgorithms())
+		return 2
+	}
+	if *name != "" {
+		template, err := naming.Parse(*name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-name: %v\n", err)
+			return 2
+		}
+		*output = outputPath(flags.Arg(0), template, *algorithm, "compress")
+	}
+	if *output == "" {
+		*output = flags.Arg(0) + "." + compression.Extension(*algorithm)
+	}
+
+	info, err := os.Stat(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	data, stats, err := compression.CompressFile(flags.Arg(0), compression.Options{Algorithm: *algorithm, ModTime: info.ModTime(), Hashes: hashes})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to compress %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	if *jsonStats {
+		return printStats(stats)
+	}
+	// printed as the checksum command does
+	for _, algorithm := range hashes {
+		fmt.Printf("%s (%s) = %s\n", strings.ToUpper(algorithm), flags.Arg(0), stats.Hashes[algorithm])
+	}
+	return 0
+}
+
+// runDecompress implements the "decompress" command, it decompresses a file
+// and returns the process exit code. The output gets the modification time
+// gzip input carries.
+func runDecompress(args []string) int {
+	flags := flag.NewFlagSet("decompress", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the output to, the input name without the extension of its algorithm by default")
+	name := flags.String("name", "", "template the output is named after next to the input, e.g. {name}_{date}.txt, instead of -o")
+	algorithm := flags.String("algorithm", compression.AutoAlgorithm, "algorithm, "+compression.AutoAlgorithm+" detects it")
+	ignoreChecksums := flags.Bool("ignore-checksums", false, "decode gzip members whose CRC or size does not match, and warn about them")
+	jsonStats := flags.Bool("json", false, "print the stats as a JSON stats record")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *output != "" && *name != "" {
+		fmt.Fprintln(os.Stderr, "usage: decompress [-o out | -name template] [-algorithm auto] [-ignore-checksums] [-json] file")
+		return 2
+	}
+	var template naming.Template
+	if *name != "" {
+		var err error
+		if template, err = naming.Parse(*name); err != nil {
+			fmt.Fprintf(os.Stderr, "-name: %v\n", err)
+			return 2
+		}
+	} else if *output == "" {
+		ext := filepath.Ext(flags.Arg(0))
+		if _, ok := compression.AlgorithmByExtension(ext); !ok {
+			fmt.Fprintf(os.Stderr, "%s has no extension of an algorithm to strip, name the output with -o\n", flags.Arg(0))
+			return 2
+		}
+		*output = strings.TrimSuffix(flags.Arg(0), ext)
+	}
+
+	data, stats, err := compression.DecompressFile(flags.Arg(0), compression.Options{Algorithm: *algorithm, IgnoreChecksums: *ignoreChecksums})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decompress %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	for _, warning := range stats.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), warning)
+	}
+	if *name != "" {
+		// the algorithm is known once it was detected
+		*output = outputPath(flags.Arg(0), template, stats.Algorithm, "decompress")
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	if !stats.ModTime.IsZero() {
+		if err := os.Chtimes(*output, stats.ModTime, stats.ModTime); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set the modification time of %s: %v\n", *output, err)
+			return 1
+		}
+	}
+	if *jsonStats {
+		return printStats(stats)
+	}
+	return 0
+}
+
+// printStats writes the stats of an operation to stdout as their stats
+// record, as the API sends them, and returns the exit code
+func printStats(stats *compression.Stats) int {
+	if err := json.NewEncoder(os.Stdout).Encode(stats.Record()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to print the stats: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// outputPath names the output of an operation on input after template, in
+// the directory of the input
+func outputPath(input string, template naming.Template, algorithm, operation string) string {
+	return filepath.Join(filepath.Dir(input), template.Execute(naming.Fields{
+		Filename:  filepath.Base(input),
+		Algorithm: algorithm,
+		Extension: compression.Extension(algorithm),
+		Operation: operation,
+		Time:      time.Now(),
+	}))
+}
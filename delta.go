@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/delta"
+)
+
+// runDelta implements the "delta" command, it writes the delta from an old
+// to a new file and returns the process exit code
+func runDelta(args []string) int {
+	flags := flag.NewFlagSet("delta", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the delta to, the new file's name with .delta by default")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: delta [-o out.delta] old new")
+		return 2
+	}
+	if *output == "" {
+		*output = flags.Arg(1) + ".delta"
+	}
+
+	oldData, newData, ok := readFiles(flags.Arg(0), flags.Arg(1))
+	if !ok {
+		return 1
+	}
+	deltaData, err := delta.Diff(context.Background(), oldData, newData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to make the delta: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*output, deltaData, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	fmt.Printf("%s: %d bytes for a %d byte file\n", *output, len(deltaData), len(newData))
+	return 0
+}
+
+// runPatch implements the "patch" command, it applies a delta to the old
+// file it was made from and returns the process exit code
+func runPatch(args []string) int {
+	flags := flag.NewFlagSet("patch", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the new file to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 || *output == "" {
+		fmt.Fprintln(os.Stderr, "usage: patch -o new old delta")
+		return 2
+	}
+
+	oldData, deltaData, ok := readFiles(flags.Arg(0), flags.Arg(1))
+	if !ok {
+		return 1
+	}
+	newData, err := delta.Patch(context.Background(), oldData, deltaData, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to apply %s to %s: %v\n", flags.Arg(1), flags.Arg(0), err)
+		return 1
+	}
+	if err := os.WriteFile(*output, newData, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+func readFiles(first, second string) ([]byte, []byte, bool) {
+	firstData, err := os.ReadFile(first)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", first, err)
+		return nil, nil, false
+	}
+	secondData, err := os.ReadFile(second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", second, err)
+		return nil, nil, false
+	}
+	return firstData, secondData, true
+}
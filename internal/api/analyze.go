@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// analyzeHeadSize is how much of the file the analysis looks at, as much as
+// algorithm=auto does
+const analyzeHeadSize = 512
+
+// AnalyzeResponse is the answer of HandleAnalyze
+type AnalyzeResponse struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Signature   string `json:"signature,omitempty"` // e.g. "ELF", or "delimited text" for CSV told by its lines
+	Rule        string `json:"rule"`                // the content type of the policy rule that matched
+	Action      string `json:"action"`              // what algorithm=auto does with the file, e.g. "delta+flate"
+	Algorithm   string `json:"algorithm"`
+	Filter      string `json:"filter,omitempty"`
+	Pipeline    string `json:"pipeline,omitempty"` // a pipeline of PIPELINES with the same action, if any
+}
+
+// HandleAnalyze detects the type of the uploaded "file" from its signature
+// and recommends how to compress it, which is what algorithm=auto would do
+func HandleAnalyze(c *gin.Context) {
+	form, err := newUpload(c, maxFileSize)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	part, err := form.NextFile()
+	if err != nil && err != io.EOF {
+		respondUploadError(c, err)
+		return
+	}
+	if err == io.EOF || part.Field != "file" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "File upload error",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUploadFailed,
+			Message:   "No file provided or file upload failed",
+		})
+		return
+	}
+
+	// only the head is looked at, the rest is counted
+	var head bytes.Buffer
+	size, err := io.Copy(&head, io.LimitReader(part, analyzeHeadSize))
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.Discard, part)
+		size += rest
+	}
+	if err == nil {
+		err = form.Finish()
+	}
+	if uploadErr := form.Err(); uploadErr != nil {
+		err = uploadErr
+	}
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	policy := compression.DefaultPolicy
+	if compressionPolicy != nil {
+		policy = *compressionPolicy
+	}
+	decision := policy.Decide(part.Filename, head.Bytes())
+	options := decision.Options(compression.Options{})
+	response := AnalyzeResponse{
+		Filename:    part.Filename,
+		Size:        size,
+		ContentType: decision.ContentType,
+		Signature:   decision.Signature,
+		Rule:        decision.Rule.ContentType,
+		Action:      decision.Rule.Action,
+		Algorithm:   options.Algorithm,
+		Filter:      options.Filter,
+	}
+	for _, name := range compression.PipelineNames(compressionPipelines) {
+		if pipeline := compressionPipelines[name]; pipeline.Action == decision.Rule.Action {
+			response.Pipeline = name
+			break
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// disabledAlgorithms are the algorithms requests may not use, from
+// DISABLED_ALGORITHMS
+var disabledAlgorithms []string
+
+// AlgorithmAvailability is an algorithm of the registry as HandleAlgorithms
+// lists it, with whether this server lets requests use it
+type AlgorithmAvailability struct {
+	compression.AlgorithmInfo
+	Enabled bool `json:"enabled"`
+}
+
+// algorithmEnabled reports whether requests may use algorithm
+func algorithmEnabled(algorithm string) bool {
+	return !slices.Contains(disabledAlgorithms, algorithm)
+}
+
+// enabledAlgorithms returns the advertised algorithms that are not disabled,
+// in the order of SupportedAlgorithms
+func enabledAlgorithms() []string {
+	var enabled []string
+	for _, algorithm := range compression.GetAdvertisedAlgorithms() {
+		if algorithmEnabled(algorithm) {
+			enabled = append(enabled, algorithm)
+		}
+	}
+	return enabled
+}
+
+// checkAlgorithmEnabled answers requests for a disabled algorithm with 422
+// and the algorithms that are enabled, it returns false when it did
+func checkAlgorithmEnabled(c *gin.Context, algorithm string) bool {
+	if algorithmEnabled(algorithm) {
+		return true
+	}
+	alternatives := enabledAlgorithms()
+	c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+		Error:        "Algorithm disabled",
+		Code:         http.StatusUnprocessableEntity,
+		ErrorCode:    ErrCodeAlgoDisabled,
+		Message:      fmt.Sprintf("%s is disabled on this server, use one of %v", algorithm, alternatives),
+		Alternatives: alternatives,
+	})
+	return false
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/naming"
+	"github.com/adilg123/file-compression-decompression-tool/internal/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+// BatchResult is the progress record of a file of a batch, sent as soon as
+// the file is compressed. The output is kept under Token to be downloaded
+// from Download; when there is no result store, or the output does not fit
+// in it, it is embedded in Data instead.
+type BatchResult struct {
+	Type           string            `json:"type"`  // "result"
+	Index          int               `json:"index"` // of the file in the upload
+	Filename       string            `json:"filename"`
+	Output         string            `json:"output,omitempty"` // the name of the compressed file
+	Algorithm      string            `json:"algorithm,omitempty"`
+	OriginalSize   int64             `json:"original_size"`
+	CompressedSize int64             `json:"compressed_size,omitempty"`
+	DurationMs     float64           `json:"duration_ms"`
+	Checksums      map[string]string `json:"checksums,omitempty"`
+	Token          string            `json:"token,omitempty"`
+	Download       string            `json:"download,omitempty"`
+	Data           []byte            `json:"data,omitempty"` // base64 in JSON
+	Error          string            `json:"error,omitempty"`
+	ErrorCode      ErrorCode         `json:"error_code,omitempty"`
+}
+
+// BatchSummary is the last record of a batch
+type BatchSummary struct {
+	Type       string  `json:"type"` // "summary"
+	Files      int     `json:"files"`
+	Failed     int     `json:"failed"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// HandleBatch compresses every uploaded "files" part on its own and streams
+// a BatchResult per file as NDJSON, in the order the files finish, so a
+// client starts downloading while the slowest file is still being
+// compressed. Index ties a record to its file. Every file waits for a
+// worker of its own, as a request of a single file would. The "algorithm"
+// field applies to all files, auto included, "priority" is batch by default
+// and "name_template" names the outputs as for /compress. A BatchSummary
+// ends the stream.
+func HandleBatch(c *gin.Context) {
+	form, files, ok := readUpload(c, "files")
+	if !ok {
+		return
+	}
+	algorithm := form.DefaultField("algorithm", defaultAlgorithm)
+	if algorithm != compression.AutoAlgorithm && !compression.IsValidAlgorithm(algorithm) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("Supported algorithms: %v or %s", compression.GetSupportedAlgorithms(), compression.AutoAlgorithm),
+		})
+		return
+	}
+	if !checkAlgorithmEnabled(c, algorithm) {
+		return
+	}
+	priority, err := scheduler.ParsePriority(form.DefaultField("priority", "batch"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	nameTemplate, ok := parseNameTemplate(c, form.Field("name_template"), naming.DefaultCompress)
+	if !ok {
+		return
+	}
+
+	// the batch outlasts the server's write timeout
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	start := time.Now()
+	ctx := c.Request.Context()
+	namespace := namespaceOf(c)
+	results := make(chan BatchResult)
+	for i, file := range files["files"] {
+		go func() {
+			results <- compressBatchFile(ctx, i, file, algorithm, priority, nameTemplate, namespace)
+		}()
+	}
+
+	encoder := json.NewEncoder(c.Writer)
+	summary := BatchSummary{Type: "summary", Files: len(files["files"])}
+	for range summary.Files {
+		result := <-results
+		if result.Error != "" {
+			summary.Failed++
+		}
+		// a client that is gone still lets the files finish, nothing waits
+		// on the writes
+		encoder.Encode(result)
+		c.Writer.Flush()
+	}
+	summary.DurationMs = milliseconds(time.Since(start))
+	encoder.Encode(summary)
+}
+
+// compressBatchFile compresses a file of a batch once a worker is free and
+// keeps its output in namespace
+func compressBatchFile(ctx context.Context, index int, file uploadedFile, algorithm string, priority scheduler.Priority, nameTemplate naming.Template, namespace string) BatchResult {
+	result := BatchResult{Type: "result", Index: index, Filename: file.Filename, OriginalSize: int64(len(file.Content))}
+	release, err := workerPool.Acquire(ctx, priority)
+	if err != nil {
+		result.Error, result.ErrorCode = err.Error(), ErrCodeInternal
+		return result
+	}
+	defer release()
+
+	start := time.Now()
+	options := compression.Options{
+		Algorithm: algorithm,
+		Policy:    compressionPolicy,
+		Filename:  file.Filename,
+		Hashes:    checksumAlgorithms,
+	}
+	compressed, stats, err := compression.CompressContext(ctx, file.Content, options)
+	if err == nil && !algorithmEnabled(stats.Algorithm) {
+		err = fmt.Errorf("algorithm=auto picked %s, which is disabled on this server", stats.Algorithm)
+	}
+	if err != nil {
+		result.Error, result.ErrorCode = err.Error(), errorCodeOf(err, ErrCodeInternal)
+		return result
+	}
+	recordJob("compress", stats, start)
+
+	result.Algorithm = stats.Algorithm
+	result.CompressedSize = int64(len(compressed))
+	result.DurationMs = milliseconds(stats.Duration)
+	result.Checksums = stats.Hashes
+	result.Output = nameTemplate.Execute(naming.Fields{
+		Filename:  file.Filename,
+		Algorithm: stats.Algorithm,
+		Extension: compression.Extension(stats.Algorithm),
+		Operation: "compress",
+		Time:      time.Now(),
+	})
+	if token := newResultToken(); token != "" && int64(len(compressed)) <= keepLimit() {
+		keepResult(namespace, token, keptResult{Filename: result.Output, ContentType: compression.MIMEType(stats.Algorithm)}, compressed)
+		result.Token = token
+		result.Download = "/api/v1/results/" + token
+	} else {
+		result.Data = compressed
+	}
+	return result
+}
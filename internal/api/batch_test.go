@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+)
+
+// TestBatch compresses a batch in which one file fails: algorithm=auto picks
+// gzip for the binary file, which is disabled, and flate for the text files
+func TestBatch(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) {
+		cfg.DisabledAlgorithms = "gzip"
+		cfg.ResultStoreSize = 1 << 20
+	})
+	binary := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(binary)
+	files := []formPart{
+		{name: "files", filename: "first.txt", content: []byte(strings.Repeat("the first text file\n", 100))},
+		{name: "files", filename: "binary.bin", content: binary},
+		{name: "files", filename: "last.txt", content: []byte(strings.Repeat("the last text file\n", 100))},
+	}
+
+	rec := serve(router, newFormRequest("/api/v1/batch", append([]formPart{field("algorithm", "auto")}, files...)...))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("batch answered %d: %s", rec.Code, rec.Body)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+
+	// every line is a JSON object of its own, the summary last
+	var lines []string
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != len(files)+1 {
+		t.Fatalf("batch sent %d lines, want a result per file and a summary:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	results := make(map[int]BatchResult)
+	for _, line := range lines[:len(files)] {
+		var result BatchResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil || result.Type != "result" {
+			t.Fatalf("%q is not a result: %v", line, err)
+		}
+		results[result.Index] = result
+	}
+	var summary BatchSummary
+	if err := json.Unmarshal([]byte(lines[len(files)]), &summary); err != nil || summary.Type != "summary" {
+		t.Fatalf("%q is not the summary: %v", lines[len(files)], err)
+	}
+	if summary.Files != len(files) || summary.Failed != 1 {
+		t.Errorf("summary = %+v, want %d files and 1 failed", summary, len(files))
+	}
+
+	if failed := results[1]; failed.Error == "" || failed.ErrorCode == "" || failed.Token != "" || failed.Data != nil {
+		t.Errorf("the binary file compressed with gzip disabled: %+v", failed)
+	}
+
+	// the files around the failure are kept under their tokens
+	for _, index := range []int{0, 2} {
+		result := results[index]
+		if result.Error != "" || result.Algorithm != "flate" || result.Token == "" {
+			t.Errorf("%s: %+v", files[index].filename, result)
+			continue
+		}
+		download := serve(router, httptest.NewRequest(http.MethodGet, result.Download, nil))
+		if download.Code != http.StatusOK {
+			t.Errorf("%s: GET %s answered %d: %s", files[index].filename, result.Download, download.Code, download.Body)
+			continue
+		}
+		if int64(download.Body.Len()) != result.CompressedSize {
+			t.Errorf("%s: downloaded %d bytes, the result says %d", files[index].filename, download.Body.Len(), result.CompressedSize)
+		}
+		original, err := io.ReadAll(flate.NewReader(download.Body))
+		if err != nil || !bytes.Equal(original, files[index].content) {
+			t.Errorf("%s: the download does not inflate to the file: %v", files[index].filename, err)
+		}
+	}
+}
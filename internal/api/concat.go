@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// SplitPayload is a payload of a concatenated stream in a SplitResponse
+type SplitPayload struct {
+	Index int    `json:"index"`
+	Size  int    `json:"size"`
+	Data  []byte `json:"data"` // base64 in JSON
+}
+
+// SplitResponse is the answer of HandleSplit
+type SplitResponse struct {
+	Algorithm string         `json:"algorithm"`
+	Payloads  []SplitPayload `json:"payloads"`
+}
+
+// HandleConcat compresses every uploaded "files" part on its own and
+// appends them, in order, to the uploaded "stream" or to an empty output.
+// The "algorithm" field is gzip, which writes a member per file, or flate,
+// which leaves the stream open for more unless "final" is true.
+func HandleConcat(c *gin.Context) {
+	// the limit applies to all files together
+	form, files, ok := readUpload(c, "files")
+	if !ok {
+		return
+	}
+	options := compression.Options{Algorithm: form.DefaultField("algorithm", "gzip")}
+	if !checkAlgorithmEnabled(c, options.Algorithm) {
+		return
+	}
+	final, err := strconv.ParseBool(form.DefaultField("final", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "final has to be true or false",
+		})
+		return
+	}
+
+	var output bytes.Buffer
+	filename := "concat." + algorithmExtension(options.Algorithm)
+	if stream := files["stream"]; len(stream) > 0 {
+		output.Write(stream[0].Content)
+		filename = stream[0].Filename
+	}
+	writer, err := compression.NewConcatWriter(&output, options)
+	if err != nil {
+		respondConcatError(c, err)
+		return
+	}
+	for _, file := range files["files"] {
+		if _, err := writer.Append(c.Request.Context(), file.Content); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "Compression failed",
+				Code:      http.StatusInternalServerError,
+				ErrorCode: errorCodeOf(err, ErrCodeInternal),
+				Message:   fmt.Sprintf("%s: %v", file.Filename, err),
+			})
+			return
+		}
+	}
+	if final {
+		// writing to a bytes.Buffer does not fail
+		writer.Close()
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Length", strconv.Itoa(output.Len()))
+	c.Header("X-Payloads", strconv.Itoa(writer.Payloads()))
+	c.Data(http.StatusOK, "application/octet-stream", output.Bytes())
+}
+
+// HandleSplit decodes the uploaded "file", made by HandleConcat or by
+// appending .gz files, into its payloads. The "algorithm" field is gzip or
+// flate, gzip by default.
+func HandleSplit(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return
+	}
+	options := compression.Options{Algorithm: form.DefaultField("algorithm", "gzip"), MaxDecodedSize: maxDecodedSize}
+	if !checkAlgorithmEnabled(c, options.Algorithm) {
+		return
+	}
+	payloads, err := compression.SplitPayloads(c.Request.Context(), files["file"][0].Content, options)
+	if err != nil {
+		respondConcatError(c, err)
+		return
+	}
+	response := SplitResponse{Algorithm: options.Algorithm, Payloads: make([]SplitPayload, len(payloads))}
+	for i, payload := range payloads {
+		response.Payloads[i] = SplitPayload{Index: i, Size: len(payload), Data: payload}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// algorithmExtension is the file extension of an algorithm's output, empty
+// for unknown ones
+func algorithmExtension(algorithm string) string {
+	info, _ := compression.AlgorithmByName(algorithm)
+	return info.Extension
+}
+
+// respondConcatError sends the errors of concatenating and splitting
+func respondConcatError(c *gin.Context, err error) {
+	var limit *compression.SizeLimitError
+	switch {
+	case errors.Is(err, compression.ErrConcatUnsupported):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("%v, use gzip or flate", err),
+		})
+	case errors.As(err, &limit):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Decompressed data too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("The output grew past the limit of %d bytes and decompression was stopped", limit.Limit),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid compressed data",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/bench"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+	"github.com/gin-gonic/gin"
+)
+
+// housekeeping sweeps the temporary objects of the server, what it did is
+// part of the runtime statistics
+var housekeeping *janitor.Janitor
+
+// regressionRun is held while the regression corpus runs, one run at a
+// time keeps it from competing with itself for the CPU
+var regressionRun sync.Mutex
+
+// setupDebugRoutes serves the pprof profiles under /debug/pprof, runtime
+// statistics under /debug/runtime and the regression check under
+// /debug/regression, behind the same API key check as the compression
+// endpoints
+func setupDebugRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	debug := router.Group("/debug", auth)
+	{
+		debug.GET("/runtime", HandleRuntimeStats)
+		debug.POST("/regression", HandleRegression)
+
+		debug.GET("/pprof/", gin.WrapF(pprof.Index))
+		debug.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+		debug.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+		debug.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+		debug.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+		debug.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+		// heap, goroutine, allocs, block, mutex and threadcreate
+		debug.GET("/pprof/:profile", func(c *gin.Context) {
+			pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+		})
+	}
+}
+
+// HandleRuntimeStats reports the goroutine count, the heap, the codec
+// operations in progress, which hold their input, output and buffers in
+// memory, and what the janitor keeps on disk and removed
+func HandleRuntimeStats(c *gin.Context) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	active := compression.ActiveOperations()
+	total := int64(0)
+	for _, count := range active {
+		total += count
+	}
+
+	var cleanup []janitor.Stats
+	if housekeeping != nil {
+		cleanup = housekeeping.Stats()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"goroutines": runtime.NumGoroutine(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"heap": gin.H{
+			"alloc_bytes":    memStats.HeapAlloc,
+			"in_use_bytes":   memStats.HeapInuse,
+			"idle_bytes":     memStats.HeapIdle,
+			"released_bytes": memStats.HeapReleased,
+			"sys_bytes":      memStats.HeapSys,
+			"objects":        memStats.HeapObjects,
+		},
+		"gc": gin.H{
+			"cycles":          memStats.NumGC,
+			"pause_total_ms":  float64(memStats.PauseTotalNs) / float64(time.Millisecond),
+			"next_heap_bytes": memStats.NextGC,
+		},
+		"sys_bytes": memStats.Sys,
+		"operations": gin.H{
+			"active":       total,
+			"by_algorithm": active,
+		},
+		"janitor": cleanup,
+	})
+}
+
+// HandleRegression runs the codecs over the regression corpus embedded in
+// the binary and reports how their sizes and durations compare with the
+// recorded baselines. The algorithms query parameter narrows the run, and
+// size_tolerance, time_tolerance and min_time loosen or tighten what counts
+// as a regression.
+func HandleRegression(c *gin.Context) {
+	var algorithms []string
+	for _, algorithm := range strings.Split(c.Query("algorithms"), ",") {
+		if algorithm = strings.TrimSpace(algorithm); algorithm == "" {
+			continue
+		}
+		if !compression.IsValidAlgorithm(algorithm) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid algorithm",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeAlgoUnsupported,
+				Message:   fmt.Sprintf("Algorithm %q is not one of %v", algorithm, compression.GetSupportedAlgorithms()),
+			})
+			return
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+
+	tolerance := bench.DefaultTolerance
+	for name, value := range map[string]*float64{"size_tolerance": &tolerance.Size, "time_tolerance": &tolerance.Time} {
+		if query := c.Query(name); query != "" {
+			parsed, err := strconv.ParseFloat(query, 64)
+			if err != nil || parsed < 0 {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:     "Invalid tolerance",
+					Code:      http.StatusBadRequest,
+					ErrorCode: ErrCodeInvalidRequest,
+					Message:   fmt.Sprintf("%s must be a fraction of 0 or more, e.g. 0.05 for 5%%", name),
+				})
+				return
+			}
+			*value = parsed
+		}
+	}
+	if query := c.Query("min_time"); query != "" {
+		parsed, err := time.ParseDuration(query)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid tolerance",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeInvalidRequest,
+				Message:   "min_time must be a duration such as 5ms",
+			})
+			return
+		}
+		tolerance.MinTime = parsed
+	}
+
+	if !regressionRun.TryLock() {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:     "Regression run in progress",
+			Code:      http.StatusConflict,
+			ErrorCode: ErrCodeConflict,
+			Message:   "Only one regression run at a time, try again once it finished",
+		})
+		return
+	}
+	defer regressionRun.Unlock()
+
+	report, err := bench.RunRegression(algorithms, tolerance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Regression run failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/gin-gonic/gin"
+)
+
+// dictionaryStore holds the dictionaries compress and decompress requests
+// name by dictionary_id, every API key in a namespace of its own held to
+// DICTIONARY_QUOTA. It is set in SetupRoutes.
+var dictionaryStore *dictionary.Store
+
+// HandleUploadDictionary stores the uploaded file as a dictionary under the
+// name field, or the file's name without its extension, replacing the one
+// stored under it before. The names of the built-in dictionaries are taken.
+func HandleUploadDictionary(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return
+	}
+	file := files["file"][0]
+	name := form.DefaultField("name", strings.TrimSuffix(file.Filename, ".dict"))
+	info, err := dictionaryStore.Put(namespaceOf(c), name, file.Content)
+	switch {
+	case err == nil:
+		c.Header("Location", "/api/v1/dictionaries/"+info.Name)
+		c.JSON(http.StatusCreated, info)
+	case errors.Is(err, dictionary.ErrInvalidName), errors.Is(err, dictionary.ErrEmpty), errors.Is(err, dictionary.ErrTooLarge):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid dictionary",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInvalidRequest),
+			Message:   fmt.Sprintf("%v. Names have 1 to 64 letters, digits, '.', '_' or '-', dictionaries 1 to %d bytes", err, dictionary.MaxSize),
+		})
+	case errors.Is(err, dictionary.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Quota exceeded",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("%v. Delete dictionaries that are no longer used to make room", err),
+		})
+	default:
+		respondDictionaryError(c, err)
+	}
+}
+
+// HandleListDictionaries lists the dictionaries stored for the API key and
+// the built-in ones, with what the stored ones take up of its quota
+func HandleListDictionaries(c *gin.Context) {
+	namespace := namespaceOf(c)
+	c.JSON(http.StatusOK, gin.H{"dictionaries": dictionaryStore.List(namespace), "usage": dictionaryStore.Usage(namespace)})
+}
+
+// HandleGetDictionary sends a dictionary as it was uploaded
+func HandleGetDictionary(c *gin.Context) {
+	data, info, err := dictionaryStore.Get(namespaceOf(c), c.Param("id"))
+	if err != nil {
+		respondDictionaryError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.dict", info.Name))
+	c.Header("X-Dictionary-Id", info.ID)
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// HandleDeleteDictionary deletes a dictionary, data compressed with it can
+// then only be decompressed once it is uploaded again
+func HandleDeleteDictionary(c *gin.Context) {
+	if err := dictionaryStore.Delete(namespaceOf(c), c.Param("id")); err != nil {
+		respondDictionaryError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// lookupDictionary returns the dictionary a compress or decompress request
+// names, or nil for none. When it is not found or the algorithm does not
+// take one the error response is already sent and ok is false.
+func lookupDictionary(c *gin.Context, id, algorithm string) (dict []byte, ok bool) {
+	if id == "" {
+		return nil, true
+	}
+	if info, _ := compression.AlgorithmByName(algorithm); algorithm != compression.AutoAlgorithm && !info.Features.Dictionary {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("%s does not support a preset dictionary, use flate or zlib", algorithm),
+		})
+		return nil, false
+	}
+	if dictionaryStore == nil {
+		respondDictionaryError(c, dictionary.ErrNotFound)
+		return nil, false
+	}
+	dict, _, err := dictionaryStore.Get(namespaceOf(c), id)
+	if err != nil {
+		respondDictionaryError(c, err)
+		return nil, false
+	}
+	return dict, true
+}
+
+// respondDictionaryError sends the errors every dictionary endpoint can run into
+func respondDictionaryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, dictionary.ErrNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:     "Dictionary not found",
+			Code:      http.StatusNotFound,
+			ErrorCode: ErrCodeNotFound,
+			Message:   "No dictionary is stored under the name, upload it to POST /api/v1/dictionaries",
+		})
+	case errors.Is(err, dictionary.ErrBuiltin):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:     "Built-in dictionary",
+			Code:      http.StatusConflict,
+			ErrorCode: ErrCodeConflict,
+			Message:   fmt.Sprintf("%v. The built-in dictionaries cannot be replaced or deleted, upload under another name", err),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Dictionary failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+	}
+}
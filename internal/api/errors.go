@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/archive"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/delta"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+	"github.com/adilg123/file-compression-decompression-tool/internal/seekable"
+	"github.com/adilg123/file-compression-decompression-tool/internal/session"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorCode names the kind of failure in the error_code field of an
+// ErrorResponse. Codes are stable, clients branch on them; the error and
+// message fields are for people and may change.
+type ErrorCode string
+
+// The error catalog
+const (
+	ErrCodeInvalidRequest     ErrorCode = "ERR_INVALID_REQUEST"
+	ErrCodeAlgoUnsupported    ErrorCode = "ERR_ALGO_UNSUPPORTED"
+	ErrCodeAlgoDisabled       ErrorCode = "ERR_ALGO_DISABLED"
+	ErrCodeUploadFailed       ErrorCode = "ERR_UPLOAD_FAILED"
+	ErrCodeUploadTimeout      ErrorCode = "ERR_UPLOAD_TIMEOUT"
+	ErrCodeLimitExceeded      ErrorCode = "ERR_LIMIT_EXCEEDED"
+	ErrCodeInputCorrupt       ErrorCode = "ERR_INPUT_CORRUPT"
+	ErrCodeInputTruncated     ErrorCode = "ERR_INPUT_TRUNCATED"
+	ErrCodeFormatUnsupported  ErrorCode = "ERR_FORMAT_UNSUPPORTED"
+	ErrCodeDictionaryMismatch ErrorCode = "ERR_DICTIONARY_MISMATCH"
+	ErrCodePasswordRequired   ErrorCode = "ERR_PASSWORD_REQUIRED"
+	ErrCodeDecryptionFailed   ErrorCode = "ERR_DECRYPTION_FAILED"
+	ErrCodeBaseMismatch       ErrorCode = "ERR_BASE_MISMATCH"
+	ErrCodeUnsafePath         ErrorCode = "ERR_UNSAFE_PATH"
+	ErrCodeNotFound           ErrorCode = "ERR_NOT_FOUND"
+	ErrCodeConflict           ErrorCode = "ERR_CONFLICT"
+	ErrCodeUnauthorized       ErrorCode = "ERR_UNAUTHORIZED"
+	ErrCodeNotEnabled         ErrorCode = "ERR_NOT_ENABLED"
+	ErrCodeInternal           ErrorCode = "ERR_INTERNAL"
+)
+
+// ErrorCodeInfo describes a code of the catalog for GET /api/v1/errors
+type ErrorCodeInfo struct {
+	Code        ErrorCode `json:"code"`
+	Status      int       `json:"status"` // the HTTP status it usually comes with
+	Description string    `json:"description"`
+}
+
+// errorCatalog lists every code the API answers with
+var errorCatalog = []ErrorCodeInfo{
+	{ErrCodeInvalidRequest, http.StatusBadRequest, "A field is missing, malformed or out of range"},
+	{ErrCodeAlgoUnsupported, http.StatusBadRequest, "The algorithm is unknown, or does not support what was asked of it"},
+	{ErrCodeAlgoDisabled, http.StatusUnprocessableEntity, "The algorithm is disabled on this server, alternatives lists the enabled ones"},
+	{ErrCodeUploadFailed, http.StatusBadRequest, "The upload is missing a file or could not be read"},
+	{ErrCodeUploadTimeout, http.StatusRequestTimeout, "The upload stalled"},
+	{ErrCodeLimitExceeded, http.StatusRequestEntityTooLarge, "The input or the output is larger than the service allows, or a stored object would take the API key past its quota"},
+	{ErrCodeInputCorrupt, http.StatusBadRequest, "The data does not decode or fails a checksum"},
+	{ErrCodeInputTruncated, http.StatusBadRequest, "The data ends before the stream does, salvage=true returns what decoded"},
+	{ErrCodeFormatUnsupported, http.StatusBadRequest, "The data is not in the format expected, or in a version or method not supported"},
+	{ErrCodeDictionaryMismatch, http.StatusBadRequest, "The data needs a preset dictionary that was not given, or a different one"},
+	{ErrCodePasswordRequired, http.StatusBadRequest, "The data is encrypted and no password was given"},
+	{ErrCodeDecryptionFailed, http.StatusBadRequest, "The password is wrong or the encrypted data was modified"},
+	{ErrCodeBaseMismatch, http.StatusConflict, "The delta was made from a different old file"},
+	{ErrCodeUnsafePath, http.StatusBadRequest, "A path leaves the directory it has to stay in"},
+	{ErrCodeNotFound, http.StatusNotFound, "The session, result, dictionary, entry or path does not exist"},
+	{ErrCodeConflict, http.StatusConflict, "The resource is busy or not in the state the request expects"},
+	{ErrCodeUnauthorized, http.StatusUnauthorized, "A valid API key is required"},
+	{ErrCodeNotEnabled, http.StatusServiceUnavailable, "The feature is not configured on this server"},
+	{ErrCodeInternal, http.StatusInternalServerError, "The service failed, the request may be retried"},
+}
+
+// errorCodes maps the sentinel errors of the packages the handlers call to
+// codes, the first one err wraps wins
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{io.ErrUnexpectedEOF, ErrCodeInputTruncated},
+	{compression.ErrDictionaryUnsupported, ErrCodeAlgoUnsupported},
+	{compression.ErrPartialUnsupported, ErrCodeAlgoUnsupported},
+	{compression.ErrConcatUnsupported, ErrCodeAlgoUnsupported},
+	{seekable.ErrUnsupportedAlgorithm, ErrCodeAlgoUnsupported},
+	{archive.ErrUnknownAlgorithm, ErrCodeAlgoUnsupported},
+	{encryption.ErrPasswordRequired, ErrCodePasswordRequired},
+	{encryption.ErrDecryptionFailed, ErrCodeDecryptionFailed},
+	{encryption.ErrUnsupportedVersion, ErrCodeFormatUnsupported},
+	{archive.ErrNotContainer, ErrCodeFormatUnsupported},
+	{archive.ErrUnsupportedVersion, ErrCodeFormatUnsupported},
+	{archive.ErrUnknownFormat, ErrCodeFormatUnsupported},
+	{archive.ErrUnsupportedMethod, ErrCodeFormatUnsupported},
+	{archive.ErrNotGzip, ErrCodeFormatUnsupported},
+	{archive.ErrUnsupportedEntry, ErrCodeFormatUnsupported},
+	{seekable.ErrNotSeekable, ErrCodeFormatUnsupported},
+	{delta.ErrNotDelta, ErrCodeFormatUnsupported},
+	{delta.ErrUnsupportedVersion, ErrCodeFormatUnsupported},
+	{delta.ErrBaseMismatch, ErrCodeBaseMismatch},
+	{archive.ErrUnsafePath, ErrCodeUnsafePath},
+	{archive.ErrUnsafeLink, ErrCodeUnsafePath},
+	{archive.ErrTooLarge, ErrCodeLimitExceeded},
+	{dictionary.ErrTooLarge, ErrCodeLimitExceeded},
+	{dictionary.ErrQuotaExceeded, ErrCodeLimitExceeded},
+	{session.ErrTooLarge, ErrCodeLimitExceeded},
+	{seekable.ErrTooManyFrames, ErrCodeLimitExceeded},
+	{archive.ErrEntryNotFound, ErrCodeNotFound},
+	{dictionary.ErrNotFound, ErrCodeNotFound},
+	{session.ErrNotFound, ErrCodeNotFound},
+	{session.ErrBusy, ErrCodeConflict},
+	{session.ErrMixedUpload, ErrCodeConflict},
+	{dictionary.ErrBuiltin, ErrCodeConflict},
+	{session.ErrPartNumber, ErrCodeInvalidRequest},
+	{encryption.ErrInvalidHeader, ErrCodeInputCorrupt},
+	{archive.ErrCorruptContainer, ErrCodeInputCorrupt},
+	{archive.ErrChecksumMismatch, ErrCodeInputCorrupt},
+	{archive.ErrDamagedBlock, ErrCodeInputCorrupt},
+	{archive.ErrCorruptZip, ErrCodeInputCorrupt},
+	{seekable.ErrCorrupt, ErrCodeInputCorrupt},
+	{seekable.ErrChecksumMismatch, ErrCodeInputCorrupt},
+	{delta.ErrCorrupt, ErrCodeInputCorrupt},
+	{delta.ErrChecksumMismatch, ErrCodeInputCorrupt},
+}
+
+// errorCodeOf classifies err by the typed errors it wraps, it returns
+// fallback when it wraps none of them
+func errorCodeOf(err error, fallback ErrorCode) ErrorCode {
+	var limit *compression.SizeLimitError
+	var truncated *compression.TruncatedError
+	var dictErr *compression.DictionaryError
+	var corrupt *compression.CorruptError
+	var offsetErr *session.OffsetError
+	var partsErr *session.PartsError
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &limit), errors.As(err, &maxBytes):
+		return ErrCodeLimitExceeded
+	case errors.As(err, &truncated):
+		return ErrCodeInputTruncated
+	case errors.As(err, &corrupt):
+		return ErrCodeInputCorrupt
+	case errors.As(err, &dictErr):
+		return ErrCodeDictionaryMismatch
+	case errors.As(err, &offsetErr), errors.As(err, &partsErr):
+		return ErrCodeConflict
+	}
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return fallback
+}
+
+// HandleErrorCatalog lists the error codes responses carry
+func HandleErrorCatalog(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"errors": errorCatalog})
+}
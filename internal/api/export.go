@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/archive"
+	"github.com/gin-gonic/gin"
+)
+
+// exportDir is the directory HandleExport serves, set in SetupRoutes
+var exportDir string
+
+// HandleExport streams a .tar.gz of the files and directories of EXPORT_DIR
+// named by the "path" query parameters, all of it without any. The archive
+// is compressed as it is sent, nothing is staged on disk or held in memory.
+// Should it fail half way, the response ends with an X-Compression-Error
+// trailer.
+func HandleExport(c *gin.Context) {
+	// a large export outlasts the server's write timeout, the writes that
+	// send it keep a deadline of their own
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	names := c.QueryArray("path")
+	c.Header("Content-Disposition", "attachment; filename=export.tar.gz")
+	c.Header("Content-Type", "application/gzip")
+	_, err := archive.StreamTarGz(c.Request.Context(), throttledWriter(c, 0), exportDir, names, archive.TarOptions{Symlinks: archive.SymlinksSkip})
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		c.Header(http.TrailerPrefix+"X-Compression-Error", err.Error())
+		return
+	}
+	// nothing was sent yet, the error replaces the archive
+	c.Writer.Header().Del("Content-Disposition")
+	c.Writer.Header().Del("Content-Type")
+	switch {
+	case errors.Is(err, archive.ErrUnsafePath):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid path",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUnsafePath,
+			Message:   err.Error(),
+		})
+	case errors.Is(err, fs.ErrNotExist):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:     "Not found",
+			Code:      http.StatusNotFound,
+			ErrorCode: ErrCodeNotFound,
+			Message:   "A path to export does not exist",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Export failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+	}
+}
@@ -1,212 +1,1327 @@
 package api
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"path"
+	"runtime"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/adilg123/file-compression-decompression-tool/internal/archive"
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/delta"
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+	"github.com/adilg123/file-compression-decompression-tool/internal/experiment"
+	"github.com/adilg123/file-compression-decompression-tool/internal/history"
+	"github.com/adilg123/file-compression-decompression-tool/internal/naming"
+	"github.com/adilg123/file-compression-decompression-tool/internal/seekable"
 	"github.com/gin-gonic/gin"
 )
 
-const maxFileSize = 50 * 1024 * 1024 // 50MB
+// maxFileSize is the upload limit, overridden from config in SetupRoutes
+var maxFileSize int64 = 50 * 1024 * 1024 // 50MB
+
+// maxDecodedSize caps the output of /decompress, 0 for no cap. It is set
+// from config in SetupRoutes.
+var maxDecodedSize int64
+
+// defaultAlgorithm is used when a compress request omits the algorithm
+var defaultAlgorithm string
+
+// compressionPolicy decides how algorithm=auto compresses a file, nil uses
+// compression.DefaultPolicy
+var compressionPolicy *compression.Policy
+
+// compressionExperiment compresses a share of the inputs again with a
+// candidate configuration, nil when EXPERIMENT_CANDIDATE is not set
+var compressionExperiment *experiment.Experiment
+
+// jobHistory records the stats of every compression and decompression, it
+// is nil when STATS_DB is not set
+var jobHistory *history.Store
 
 // CompressRequest represents the compression request payload
 type CompressRequest struct {
-	Algorithm string `form:"algorithm" binding:"required"`
+	Algorithm string `form:"algorithm"`
 	BType     *int   `form:"btype,omitempty"`
 	BFinal    *int   `form:"bfinal,omitempty"`
+	BlockSize int    `form:"block_size"` // the most bytes of input a flate block covers, 0 for no limit
+	Password  string `form:"password"`   // encrypt the compressed output
+	Rate      int64  `form:"rate"`       // send the output at most this many bytes per second
+	StatsOnly bool   `form:"stats_only"` // answer with the stats only, e.g. ?stats_only=true
+	Profile   bool   `form:"profile"`    // answer with the stats and a profile of the input, implies stats_only
+	Pipeline  string `form:"pipeline"`   // a pipeline of PIPELINES, in place of algorithm, btype and bfinal
+	Priority  string `form:"priority"`   // interactive, the default, or batch, which waits for a worker behind it
+
+	// DictionaryID names a dictionary uploaded to /api/v1/dictionaries, or
+	// the built-in json, html or logs, that flate and zlib are primed with
+	DictionaryID string `form:"dictionary_id"`
+
+	// Modified is the RFC 3339 modification time gzip keeps in its MTIME
+	// field, the Last-Modified header of the request is used without it
+	Modified string `form:"modified"`
+
+	// NameTemplate names the download, e.g. "{name}.{date}.{algext}",
+	// naming.DefaultCompress without it
+	NameTemplate string `form:"name_template"`
 }
 
 // DecompressRequest represents the decompression request payload
 type DecompressRequest struct {
-	Algorithm string `form:"algorithm" binding:"required"`
+	Algorithm string `form:"algorithm" binding:"required_without=Pipeline"`
+	Pipeline  string `form:"pipeline"` // the pipeline the data was compressed with, in place of algorithm and filter
+	Salvage   bool   `form:"salvage"`  // return the partial output of truncated input
+	Recover   bool   `form:"recover"`  // skip damaged gzip members, see X-Damaged-Ranges
+	Password  string `form:"password"` // for input that was compressed with a password
+	Filter    string `form:"filter"`   // the filter algorithm=auto applied, see X-Compression-Policy
+	Rate      int64  `form:"rate"`     // send the output at most this many bytes per second
+	Priority  string `form:"priority"` // interactive or batch, as for compression
+
+	// Concurrency is how many gzip members are decoded at once, capped at
+	// the number of CPUs
+	Concurrency int `form:"concurrency"`
+
+	// IgnoreChecksums decodes gzip members whose CRC or size does not match
+	// their trailer, each mismatch comes back in an X-Compression-Warning
+	IgnoreChecksums bool `form:"ignore_checksums"`
+
+	// DictionaryID names the dictionary the data was compressed with
+	DictionaryID string `form:"dictionary_id"`
+
+	// NameTemplate names the download, naming.DefaultDecompress without it
+	NameTemplate string `form:"name_template"`
+}
+
+// StatsResponse is the answer to a compression with stats_only=true, the
+// stats record with its profile when the request asked for it.
+// CompressedSize repeats processed_size for clients from before the schema.
+type StatsResponse struct {
+	compression.StatsRecord
+	CompressedSize int64 `json:"compressed_size"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Error     string    `json:"error"`
+	Code      int       `json:"code"`
+	ErrorCode ErrorCode `json:"error_code"` // from the catalog in errors.go, for clients to branch on
+	Message   string    `json:"message"`
+
+	// Alternatives are the algorithms to use instead of a disabled one
+	Alternatives []string `json:"alternatives,omitempty"`
 }
 
 // SuccessResponse represents a successful operation response
 type SuccessResponse struct {
 	Message          string   `json:"message"`
 	Algorithm        string   `json:"algorithm"`
-	OriginalSize     int      `json:"original_size"`
-	ProcessedSize    int      `json:"processed_size"`
+	OriginalSize     int64    `json:"original_size"`
+	ProcessedSize    int64    `json:"processed_size"`
 	CompressionRatio *float64 `json:"compression_ratio,omitempty"`
 	Filename         string   `json:"filename"`
 }
 
-// HandleCompress handles file compression requests
+// HandleCompress handles file compression requests. The file is handed to
+// the algorithm while it is uploaded, so the fields have to come before it.
 func HandleCompress(c *gin.Context) {
+	form, err := newUpload(c, maxFileSize)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	part, err := form.NextFile()
+	if err != nil && err != io.EOF {
+		respondUploadError(c, err)
+		return
+	}
+	if err == io.EOF || part.Field != "file" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "File upload error",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUploadFailed,
+			Message:   "No file provided or file upload failed",
+		})
+		return
+	}
+
 	var req CompressRequest
-	if err := c.ShouldBind(&req); err != nil {
+	if err := form.Bind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	// a pipeline stands in for these
+	optionsGiven := req.Algorithm != "" || req.BType != nil || req.BFinal != nil
+	if req.Algorithm == "" && req.Pipeline == "" {
+		req.Algorithm = defaultAlgorithm
+	}
+	if req.Rate < 0 {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid request",
-			Code:    http.StatusBadRequest,
-			Message: err.Error(),
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "rate must not be negative",
 		})
 		return
 	}
 
-	// Validate algorithm
-	if !compression.IsValidAlgorithm(req.Algorithm) {
+	// Validate algorithm, auto leaves it to the policy
+	if req.Pipeline == "" && req.Algorithm != compression.AutoAlgorithm && !compression.IsValidAlgorithm(req.Algorithm) {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid algorithm",
-			Code:    http.StatusBadRequest,
-			Message: fmt.Sprintf("Supported algorithms: %v", compression.GetSupportedAlgorithms()),
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("Supported algorithms: %v or %s", compression.GetSupportedAlgorithms(), compression.AutoAlgorithm),
 		})
 		return
 	}
 
-	// Get uploaded file
-	file, header, err := c.Request.FormFile("file")
+	// Prepare compression options
+	options := compression.Options{
+		Algorithm: req.Algorithm,
+		Policy:    compressionPolicy,
+		Filename:  part.Filename,
+	}
+
+	if req.BType != nil {
+		options.BType = uint32(*req.BType)
+	}
+	var ok bool
+	if options.Partial, ok = parseBFinal(c, req.BFinal, req.Algorithm); !ok {
+		return
+	}
+	if options.BlockSize, ok = parseBlockSize(c, req.BlockSize); !ok {
+		return
+	}
+	options, ok = applyPipeline(c, req.Pipeline, options, optionsGiven)
+	if !ok {
+		return
+	}
+	req.Algorithm = options.Algorithm
+	modTime, err := parseModTime(req.Modified, c.GetHeader("Last-Modified"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "File upload error",
-			Code:    http.StatusBadRequest,
-			Message: "No file provided or file upload failed",
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
 		})
 		return
 	}
-	defer file.Close()
+	options.ModTime = modTime
+	dict, ok := lookupDictionary(c, req.DictionaryID, req.Algorithm)
+	if !ok {
+		return
+	}
+	options.Dictionary = dict
+	nameTemplate, ok := parseNameTemplate(c, req.NameTemplate, naming.DefaultCompress)
+	if !ok {
+		return
+	}
 
-	// Check file size
-	if header.Size > maxFileSize {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "File too large",
-			Code:    http.StatusBadRequest,
-			Message: fmt.Sprintf("Maximum file size is %d bytes", maxFileSize),
+	// algorithm=auto is resolved first, the name of the download depends on it
+	input, options := compression.ApplyPolicy(part, options)
+	if !checkAlgorithmEnabled(c, options.Algorithm) {
+		return
+	}
+	filename := nameTemplate.Execute(naming.Fields{
+		Filename:  part.Filename,
+		Algorithm: options.Algorithm,
+		Extension: compression.Extension(options.Algorithm),
+		Operation: "compress",
+		Time:      time.Now(),
+	})
+	if req.Password != "" {
+		filename += ".enc"
+	}
+	header := http.Header{}
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	header.Set("Content-Type", compression.MIMEType(options.Algorithm))
+	if decision := options.Decision(); decision != nil {
+		header.Set("X-Content-Type-Detected", decision.ContentType)
+		header.Set("X-Compression-Policy", decision.Rule.Action)
+		header.Set("X-Compression-Algorithm", options.Algorithm)
+	}
+
+	release, ok := acquireWorker(c, req.Priority)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	streamed := newStreamedInput(form, input)
+	options.Hashes = checksumAlgorithms
+	if req.StatsOnly || req.Profile {
+		// the output is only counted, so there is nothing to encrypt either
+		options.StatsOnly = true
+		options.Profile = req.Profile
+		stats, err := compression.CompressStream(c.Request.Context(), nil, streamed, options)
+		if !respondCompressError(c, form, streamed, err) {
+			recordJob("compress", stats, start)
+			c.JSON(http.StatusOK, StatsResponse{
+				StatsRecord:    stats.Record(),
+				CompressedSize: stats.ProcessedSize,
+			})
+		}
+		return
+	}
+
+	// Compress the file as it arrives. The output is streamed to the client
+	// with the stats as trailers, unless it has to be encrypted as a whole.
+	response := newStreamedResponse(c, throttledWriter(c, req.Rate), streamed)
+	response.header = header
+	var output io.Writer = response
+	var sealed bytes.Buffer
+	if req.Password != "" {
+		output = &sealed
+	}
+	// the output is recorded on its way out to be kept under the token
+	token := newResultToken()
+	recorder := &resultRecorder{overflow: token == ""}
+	if token != "" {
+		header.Set("X-Result-Token", token)
+		recorder.limit = keepLimit()
+		if req.Password == "" {
+			output = io.MultiWriter(response, recorder)
+		}
+	}
+	kept := keptResult{Filename: filename, ContentType: header.Get("Content-Type")}
+	// a sampled input is kept to be compressed again with the candidate of
+	// the experiment, which has no dictionary to compare fairly with
+	var source io.Reader = streamed
+	var sample bytes.Buffer
+	sampled := dict == nil && compressionExperiment.Sample()
+	if sampled {
+		source = io.TeeReader(streamed, &sample)
+	}
+	stats, err := compression.CompressStream(c.Request.Context(), output, source, options)
+	if err == nil && sampled {
+		compressionExperiment.Run(sample.Bytes(), stats)
+	}
+	if response.started {
+		if err != nil {
+			response.fail(err)
+		} else if err := response.finish(stats); err == nil {
+			recordJob("compress", stats, start)
+			if !recorder.overflow {
+				keepResult(namespaceOf(c), token, kept, recorder.buf.Bytes())
+			}
+		}
+		return
+	}
+	if respondCompressError(c, form, streamed, err) {
+		return
+	}
+	recordJob("compress", stats, start)
+	if req.Password == "" {
+		// empty output, nothing was written to start the response
+		keepResult(namespaceOf(c), token, kept, nil)
+		response.finish(stats)
+		return
+	}
+
+	// Encrypt the compressed data when a password is given
+	compressedData, err := encryption.Seal(req.Password, sealed.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Encryption failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
 		})
 		return
 	}
+	for key, values := range header {
+		c.Writer.Header()[key] = values
+	}
+	c.Header("X-Compression-Ratio", strconv.FormatFloat(stats.CompressionRatio, 'f', 2, 64))
+	c.Header("X-Checksum", formatChecksums(stats.Hashes))
+	c.Header("X-Compression-Stats", statsHeader(stats))
+	kept.ContentType = "application/octet-stream"
+	keepResult(namespaceOf(c), token, kept, compressedData)
 
-	// Read file content
-	fileContent, err := io.ReadAll(file)
+	// Send compressed data
+	sendThrottled(c, req.Rate, "application/octet-stream", compressedData)
+}
+
+// parseBFinal reads the bfinal field: 1, as when it is not given, ends the
+// output on a final block, and 0 leaves it a partial stream for more blocks
+// to follow, which algorithm has to support. It answers anything else with
+// 400 and returns false.
+func parseBFinal(c *gin.Context, bfinal *int, algorithm string) (partial bool, ok bool) {
+	if bfinal == nil {
+		return false, true
+	}
+	if *bfinal != 0 && *bfinal != 1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "bfinal must be 1, or 0 for a partial stream",
+		})
+		return false, false
+	}
+	// algorithm=auto is checked once the policy has picked one
+	if info, known := compression.AlgorithmByName(algorithm); known && *bfinal == 0 && !info.Features.Partial {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("bfinal=0 leaves a partial stream, which %s cannot write", algorithm),
+		})
+		return false, false
+	}
+	return *bfinal == 0, true
+}
+
+// parseBlockSize checks the block_size field, it answers a negative size
+// with 400 and returns false
+func parseBlockSize(c *gin.Context, size int) (int, bool) {
+	if size < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "block_size must be a size in bytes, or 0 for no limit",
+		})
+		return 0, false
+	}
+	return size, true
+}
+
+// parseModTime returns the modification time given in the modified field,
+// or in the Last-Modified header when the field is empty. Neither being set
+// gives the zero time.
+func parseModTime(field, lastModified string) (time.Time, error) {
+	if field != "" {
+		modTime, err := time.Parse(time.RFC3339, field)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("modified must be an RFC 3339 time: %w", err)
+		}
+		return modTime, nil
+	}
+	if lastModified != "" {
+		modTime, err := http.ParseTime(lastModified)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Last-Modified header: %w", err)
+		}
+		return modTime, nil
+	}
+	return time.Time{}, nil
+}
+
+// respondCompressError answers a compression that failed before any output
+// was sent, the upload failing takes precedence over the error of the
+// compression it broke off. It returns false when nothing failed.
+func respondCompressError(c *gin.Context, form *upload, streamed *streamedInput, err error) bool {
+	if uploadErr := form.Err(); uploadErr != nil {
+		respondUploadError(c, uploadErr)
+		return true
+	}
+	if streamed.err != nil {
+		respondUploadError(c, streamed.err)
+		return true
+	}
+	if errors.Is(err, compression.ErrDictionaryUnsupported) || errors.Is(err, compression.ErrPartialUnsupported) {
+		// algorithm=auto picked an algorithm without support for them
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInvalidRequest),
+			Message:   err.Error(),
+		})
+		return true
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "File read error",
-			Code:    http.StatusInternalServerError,
-			Message: "Failed to read uploaded file",
+			Error:     "Compression failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errorCodeOf(err, ErrCodeInternal),
+			Message:   err.Error(),
 		})
+		return true
+	}
+	return false
+}
+
+// HandleDecompress handles file decompression requests
+func HandleDecompress(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
 		return
 	}
+	var req DecompressRequest
+	if err := form.Bind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	// a pipeline stands in for the algorithm and the filter
+	if req.Pipeline != "" {
+		options, ok := applyPipeline(c, req.Pipeline, compression.Options{}, req.Algorithm != "" || req.Filter != "")
+		if !ok {
+			return
+		}
+		req.Algorithm, req.Filter = options.Algorithm, options.Filter
+	}
 
-	// Prepare compression options
-	options := compression.Options{
-		Algorithm: req.Algorithm,
+	// Validate algorithm, auto detects it from the first bytes of the file
+	if req.Algorithm != compression.AutoAlgorithm && !compression.IsValidAlgorithm(req.Algorithm) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("Supported algorithms: %v or %s", compression.GetSupportedAlgorithms(), compression.AutoAlgorithm),
+		})
+		return
+	}
+	if req.Filter != "" && req.Filter != compression.FilterLineDelta {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("filter must be %s or empty", compression.FilterLineDelta),
+		})
+		return
 	}
 
-	if req.BType != nil {
-		options.BType = uint32(*req.BType)
+	if req.Concurrency < 0 || req.Rate < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "concurrency and rate must not be negative",
+		})
+		return
+	}
+	if !checkAlgorithmEnabled(c, req.Algorithm) {
+		return
+	}
+	dict, ok := lookupDictionary(c, req.DictionaryID, req.Algorithm)
+	if !ok {
+		return
+	}
+	nameTemplate, ok := parseNameTemplate(c, req.NameTemplate, naming.DefaultDecompress)
+	if !ok {
+		return
+	}
+	file := files["file"][0]
+	fileContent := file.Content
+	var err error
+
+	// deriving the key of encrypted input costs as much memory as decoding,
+	// so it waits for a worker too
+	release, ok := acquireWorker(c, req.Priority)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Encrypted input is authenticated and decrypted before it is decompressed.
+	// Its output is neither cached nor kept, only the password opens it.
+	encrypted := encryption.IsEncrypted(fileContent)
+	if encrypted {
+		fileContent, err = encryption.Open(req.Password, fileContent)
+		if err != nil {
+			respondDecryptionError(c, err)
+			return
+		}
 	}
-	if req.BFinal != nil {
-		options.BFinal = uint32(*req.BFinal)
+	// what auto detects is checked as well, the detection is only paid for
+	// when something is disabled
+	if req.Algorithm == compression.AutoAlgorithm && len(disabledAlgorithms) > 0 && !checkAlgorithmEnabled(c, compression.Detect(fileContent)) {
+		return
 	}
 
-	// Compress the file
-	compressedData, stats, err := compression.Compress(fileContent, options)
-	_ = stats // TODO: use stats (original size, processed size, ratio) or remove from return
+	// The same input decompressed before is sent from the cache
+	key := resultKey(fileContent, req, dict)
+	if decompressedData, stats, ok := cachedResult(key); ok && !encrypted && (maxDecodedSize == 0 || int64(len(decompressedData)) <= maxDecodedSize) {
+		c.Header("X-Cache", "HIT")
+		sendDecompressed(c, req, nameTemplate, file.Filename, decompressedData, stats, true)
+		return
+	}
+
+	// Decompress the file
+	start := time.Now()
+	decompressedData, stats, err := compression.DecompressContext(c.Request.Context(), fileContent, compression.Options{
+		Algorithm:   req.Algorithm,
+		Filter:      req.Filter,
+		Salvage:     req.Salvage,
+		Recover:     req.Recover,
+		Concurrency: min(req.Concurrency, runtime.GOMAXPROCS(0)),
+		Dictionary:  dict,
+
+		MaxDecodedSize:  maxDecodedSize,
+		IgnoreChecksums: req.IgnoreChecksums,
+	})
+	var truncated *compression.TruncatedError
+	if errors.As(err, &truncated) {
+		if !req.Salvage && !req.Recover {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Input is truncated",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeInputTruncated,
+				Message:   fmt.Sprintf("%v. Retry with salvage=true to download the %d bytes that were recovered", err, truncated.Decoded),
+			})
+			return
+		}
+		// salvage mode: send what was recovered and say so in the headers
+		c.Header("X-Truncated", "true")
+		c.Header("X-Decoded-Bytes", strconv.FormatInt(truncated.Decoded, 10))
+		err = nil
+	}
+	var dictErr *compression.DictionaryError
+	if errors.As(err, &dictErr) || errors.Is(err, compression.ErrDictionaryUnsupported) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Wrong dictionary",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeDictionaryMismatch),
+			Message:   err.Error(),
+		})
+		return
+	}
+	// decoding stopped at the limit, nothing of the output was sent
+	var limitErr *compression.SizeLimitError
+	if errors.As(err, &limitErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Decompressed data too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("The output grew past the limit of %d bytes and decompression was stopped", limitErr.Limit),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "Compression failed",
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
+			Error:     "Decompression failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errorCodeOf(err, ErrCodeInternal),
+			Message:   err.Error(),
 		})
 		return
 	}
+	recordJob("decompress", stats, start)
+	if truncated == nil && !encrypted {
+		storeResult(key, decompressedData, stats)
+	}
+	if resultCache != nil && !encrypted {
+		c.Header("X-Cache", "MISS")
+	}
+	sendDecompressed(c, req, nameTemplate, file.Filename, decompressedData, stats, !encrypted)
+}
+
+// sendDecompressed sends the output of a decompression with the headers
+// its stats call for, keeping it under a result token if keep is set
+func sendDecompressed(c *gin.Context, req DecompressRequest, nameTemplate naming.Template, name string, decompressedData []byte, stats *compression.Stats, keep bool) {
+	// e.g. input in a deprecated format, which still decoded
+	for _, warning := range stats.Warnings {
+		c.Writer.Header().Add("X-Compression-Warning", warning)
+	}
+	// what recover mode skipped, as byte ranges of the input
+	for _, damaged := range stats.Damage {
+		c.Writer.Header().Add("X-Damaged-Ranges", fmt.Sprintf("%d-%d", damaged.Offset, damaged.Offset+damaged.Size))
+	}
+	// the time gzip input was stamped with when it was compressed
+	if !stats.ModTime.IsZero() {
+		c.Header("Last-Modified", stats.ModTime.UTC().Format(http.TimeFormat))
+	}
+	c.Header("X-Compression-Stats", statsHeader(stats))
 
 	// Set response headers for file download
-	filename := fmt.Sprintf("%s_compressed.%s", getBaseFilename(header.Filename), getExtensionForAlgorithm(req.Algorithm))
+	filename := nameTemplate.Execute(naming.Fields{
+		Filename:  name,
+		Algorithm: stats.Algorithm,
+		Extension: compression.Extension(stats.Algorithm),
+		Operation: "decompress",
+		Time:      time.Now(),
+	})
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", strconv.Itoa(len(compressedData)))
+	if token := newResultToken(); keep && token != "" && int64(len(decompressedData)) <= keepLimit() {
+		keepResult(namespaceOf(c), token, keptResult{Filename: filename, ContentType: "application/octet-stream"}, decompressedData)
+		c.Header("X-Result-Token", token)
+	}
 
-	// Send compressed data
-	c.Data(http.StatusOK, "application/octet-stream", compressedData)
+	// Send decompressed data
+	sendThrottled(c, req.Rate, "application/octet-stream", decompressedData)
 }
 
-// HandleDecompress handles file decompression requests
-func HandleDecompress(c *gin.Context) {
-	var req DecompressRequest
-	if err := c.ShouldBind(&req); err != nil {
+// HandleArchive packs the uploaded files into a zip archive, compressed with
+// the flate algorithm. Every part of the "files" field becomes one entry,
+// named after the uploaded file.
+func HandleArchive(c *gin.Context) {
+	// the limit applies to all files together
+	_, files, ok := readUpload(c, "files")
+	if !ok {
+		return
+	}
+
+	var archiveData bytes.Buffer
+	zipWriter := archive.NewZipWriter(&archiveData)
+	now := time.Now()
+	for _, file := range files["files"] {
+		err := zipWriter.AddFile(c.Request.Context(), file.Filename, now, file.Content)
+		if errors.Is(err, archive.ErrInvalidName) || errors.Is(err, archive.ErrDuplicateName) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid file name",
+				Code:      http.StatusBadRequest,
+				ErrorCode: errorCodeOf(err, ErrCodeInvalidRequest),
+				Message:   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "Archive failed",
+				Code:      http.StatusInternalServerError,
+				ErrorCode: errorCodeOf(err, ErrCodeInternal),
+				Message:   err.Error(),
+			})
+			return
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Archive failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errorCodeOf(err, ErrCodeInternal),
+			Message:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=archive.zip")
+	c.Header("Content-Length", strconv.Itoa(archiveData.Len()))
+	c.Data(http.StatusOK, "application/zip", archiveData.Bytes())
+}
+
+// HandleArchiveDiff compares the archives uploaded as "old" and "new", zip,
+// tar, tar.gz or container alike, and lists the files that were added,
+// removed or changed with their sizes and CRC-32s
+func HandleArchiveDiff(c *gin.Context) {
+	_, files, ok := readUpload(c, "old", "new")
+	if !ok {
+		return
+	}
+	diff, err := archive.DiffArchives(c.Request.Context(), files["old"][0].Content, files["new"][0].Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid archive",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// HandleCreateContainer packs the uploaded files into a container. The
+// "algorithm" field is either given once for all files or once per file, in
+// the order of the "files" parts. A "block_size" in bytes splits the files
+// into blocks with a checksum each.
+func HandleCreateContainer(c *gin.Context) {
+	// the limit applies to all files together
+	form, uploaded, ok := readUpload(c, "files")
+	if !ok {
+		return
+	}
+	files := uploaded["files"]
+	algorithms := form.Fields("algorithm")
+	switch len(algorithms) {
+	case 0:
+		algorithms = []string{defaultAlgorithm}
+		fallthrough
+	case 1:
+		for len(algorithms) < len(files) {
+			algorithms = append(algorithms, algorithms[0])
+		}
+	case len(files):
+	default:
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid request",
-			Code:    http.StatusBadRequest,
-			Message: err.Error(),
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("Got %d algorithms for %d files, give one for all files or one per file", len(algorithms), len(files)),
 		})
 		return
 	}
+	for _, algorithm := range algorithms {
+		if algorithm != archive.ContainerStore && !compression.IsValidAlgorithm(algorithm) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid algorithm",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeAlgoUnsupported,
+				Message:   fmt.Sprintf("Supported algorithms: %v and %s", compression.GetSupportedAlgorithms(), archive.ContainerStore),
+			})
+			return
+		}
+		if !checkAlgorithmEnabled(c, algorithm) {
+			return
+		}
+	}
 
-	// Validate algorithm
-	if !compression.IsValidAlgorithm(req.Algorithm) {
+	blockSize, err := strconv.Atoi(form.DefaultField("block_size", "0"))
+	if err != nil || blockSize < 0 {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "Invalid algorithm",
-			Code:    http.StatusBadRequest,
-			Message: fmt.Sprintf("Supported algorithms: %v", compression.GetSupportedAlgorithms()),
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "block_size must be a number of bytes",
 		})
 		return
 	}
 
-	// Get uploaded file
-	file, header, err := c.Request.FormFile("file")
+	var containerData bytes.Buffer
+	containerWriter := archive.NewContainerWriter(&containerData)
+	containerWriter.BlockSize = blockSize
+	now := time.Now()
+	for i, file := range files {
+		err := containerWriter.AddFile(c.Request.Context(), file.Filename, now, algorithms[i], file.Content)
+		if errors.Is(err, archive.ErrInvalidName) || errors.Is(err, archive.ErrDuplicateName) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid file name",
+				Code:      http.StatusBadRequest,
+				ErrorCode: errorCodeOf(err, ErrCodeInvalidRequest),
+				Message:   err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "Container failed",
+				Code:      http.StatusInternalServerError,
+				ErrorCode: errorCodeOf(err, ErrCodeInternal),
+				Message:   err.Error(),
+			})
+			return
+		}
+	}
+	if err := containerWriter.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Container failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errorCodeOf(err, ErrCodeInternal),
+			Message:   err.Error(),
+		})
+		return
+	}
+	if password := form.Field("password"); password != "" {
+		sealed, err := encryption.Seal(password, containerData.Bytes())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "Encryption failed",
+				Code:      http.StatusInternalServerError,
+				ErrorCode: ErrCodeInternal,
+				Message:   err.Error(),
+			})
+			return
+		}
+		containerData.Reset()
+		containerData.Write(sealed)
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=container."+archive.ContainerExtension)
+	c.Header("Content-Length", strconv.Itoa(containerData.Len()))
+	c.Data(http.StatusOK, "application/octet-stream", containerData.Bytes())
+}
+
+// HandleListContainer returns the index of an uploaded container
+func HandleListContainer(c *gin.Context) {
+	containerReader, _, ok := openUploadedContainer(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": containerReader.Entries()})
+}
+
+// HandleExtractContainer returns the entry of an uploaded container that is
+// named by the "name" field. With "salvage" damaged blocks are left out
+// instead of failing the request, X-Damaged-Blocks lists their byte ranges.
+func HandleExtractContainer(c *gin.Context) {
+	containerReader, form, ok := openUploadedContainer(c)
+	if !ok {
+		return
+	}
+	name := form.Field("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "The name of the entry to extract is missing",
+		})
+		return
+	}
+
+	salvage, err := strconv.ParseBool(form.DefaultField("salvage", "false"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "File upload error",
-			Code:    http.StatusBadRequest,
-			Message: "No file provided or file upload failed",
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "salvage must be true or false",
 		})
 		return
 	}
-	defer file.Close()
 
-	// Check file size
-	if header.Size > maxFileSize {
+	var data []byte
+	if salvage {
+		var damaged []archive.DamagedBlock
+		data, damaged, err = containerReader.SalvageFile(c.Request.Context(), name)
+		for _, block := range damaged {
+			c.Writer.Header().Add("X-Damaged-Blocks", fmt.Sprintf("%d-%d", block.Offset, block.Offset+block.Size))
+		}
+	} else {
+		data, err = containerReader.ReadFile(c.Request.Context(), name)
+	}
+	if errors.Is(err, archive.ErrEntryNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:     "Entry not found",
+			Code:      http.StatusNotFound,
+			ErrorCode: ErrCodeNotFound,
+			Message:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "File too large",
-			Code:    http.StatusBadRequest,
-			Message: fmt.Sprintf("Maximum file size is %d bytes", maxFileSize),
+			Error:     "Invalid container",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
 		})
 		return
 	}
 
-	// Read file content
-	fileContent, err := io.ReadAll(file)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", path.Base(name)))
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// openUploadedContainer reads the container uploaded as "file" and returns
+// it together with the form for the other fields. When that fails the error
+// response is already sent and ok is false.
+func openUploadedContainer(c *gin.Context) (*archive.ContainerReader, *upload, bool) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return nil, nil, false
+	}
+
+	// deriving the key takes a worker, as it does for /decompress
+	if encryption.IsEncrypted(files["file"][0].Content) {
+		release, ok := acquireWorker(c, form.Field("priority"))
+		if !ok {
+			return nil, nil, false
+		}
+		defer release()
+	}
+	containerReader, err := archive.OpenEncryptedContainer(files["file"][0].Content, form.Field("password"))
+	if errors.Is(err, encryption.ErrPasswordRequired) || errors.Is(err, encryption.ErrDecryptionFailed) {
+		respondDecryptionError(c, err)
+		return nil, nil, false
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "File read error",
-			Code:    http.StatusInternalServerError,
-			Message: "Failed to read uploaded file",
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid container",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return nil, nil, false
+	}
+	return containerReader, form, true
+}
+
+// HandleCreateSeekable compresses the uploaded "file" into a seekable flate
+// or gzip stream of independent frames of "frame_size" bytes
+func HandleCreateSeekable(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return
+	}
+	algorithm := form.DefaultField("algorithm", "gzip")
+	if !checkAlgorithmEnabled(c, algorithm) {
+		return
+	}
+	frameSize, err := strconv.Atoi(form.DefaultField("frame_size", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "frame_size must be a number of bytes",
 		})
 		return
 	}
+	file := files["file"][0]
 
-	// Decompress the file
-	decompressedData, stats, err := compression.Decompress(fileContent, compression.Options{
-		Algorithm: req.Algorithm,
-	})
-	_ = stats // TODO: use stats (original size, processed size, ratio) or remove from return
+	stream, err := seekable.Compress(c.Request.Context(), file.Content, algorithm, frameSize)
+	if errors.Is(err, seekable.ErrUnsupportedAlgorithm) || errors.Is(err, seekable.ErrInvalidFrameSize) || errors.Is(err, seekable.ErrTooManyFrames) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInvalidRequest),
+			Message:   err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "Decompression failed",
-			Code:    http.StatusInternalServerError,
-			Message: err.Error(),
+			Error:     "Compression failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: errorCodeOf(err, ErrCodeInternal),
+			Message:   err.Error(),
 		})
 		return
 	}
 
-	// Set response headers for file download
-	filename := fmt.Sprintf("%s_decompressed.txt", getBaseFilename(header.Filename))
+	filename := fmt.Sprintf("%s_seekable.%s", getBaseFilename(file.Filename), compression.Extension(algorithm))
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "text/plain")
-	c.Header("Content-Length", strconv.Itoa(len(decompressedData)))
+	c.Header("Content-Length", strconv.Itoa(len(stream)))
+	c.Data(http.StatusOK, compression.MIMEType(algorithm), stream)
+}
 
-	// Send decompressed data
-	c.Data(http.StatusOK, "text/plain", decompressedData)
+// HandleReadSeekable returns "length" bytes from "offset" of the content of
+// the uploaded seekable stream, only the frames in that range are
+// decompressed, "concurrency" at a time. The length defaults to the rest of
+// the content.
+func HandleReadSeekable(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(form.DefaultField("offset", "0"), 10, 64)
+	length, lengthErr := strconv.ParseInt(form.DefaultField("length", "-1"), 10, 64)
+	if err != nil || lengthErr != nil || offset < 0 || length < -1 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "offset and length must be positive numbers of bytes",
+		})
+		return
+	}
+	concurrency, err := strconv.Atoi(form.DefaultField("concurrency", "1"))
+	if err != nil || concurrency < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "concurrency must be a positive number of frames",
+		})
+		return
+	}
+	fileContent := files["file"][0].Content
+	reader, err := seekable.NewReader(bytes.NewReader(fileContent), int64(len(fileContent)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid seekable stream",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return
+	}
+	reader.SetConcurrency(min(concurrency, runtime.GOMAXPROCS(0)))
+	if offset > reader.Size() {
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, ErrorResponse{
+			Error:     "Invalid range",
+			Code:      http.StatusRequestedRangeNotSatisfiable,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("offset %d is past the end of the %d bytes of content", offset, reader.Size()),
+		})
+		return
+	}
+	if length == -1 || length > reader.Size()-offset {
+		length = reader.Size() - offset
+	}
+
+	data := make([]byte, length)
+	if _, err := reader.ReadAtContext(c.Request.Context(), data, offset); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Decompression failed",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return
+	}
+	if length > 0 {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, reader.Size()))
+	}
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// HandleDelta returns a delta that turns the uploaded "old" file into the
+// uploaded "new" one
+func HandleDelta(c *gin.Context) {
+	_, files, ok := readUpload(c, "old", "new")
+	if !ok {
+		return
+	}
+	newFile := files["new"][0]
+
+	deltaData, err := delta.Diff(c.Request.Context(), files["old"][0].Content, newFile.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Delta failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.delta", getBaseFilename(newFile.Filename)))
+	c.Header("Content-Length", strconv.Itoa(len(deltaData)))
+	c.Data(http.StatusOK, "application/octet-stream", deltaData)
+}
+
+// HandlePatch applies the uploaded "delta" to the uploaded "old" file and
+// returns the new one
+func HandlePatch(c *gin.Context) {
+	_, files, ok := readUpload(c, "old", "delta")
+	if !ok {
+		return
+	}
+	oldFile := files["old"][0]
+
+	newContent, err := delta.Patch(c.Request.Context(), oldFile.Content, files["delta"][0].Content, maxDecodedSize)
+	var limitErr *compression.SizeLimitError
+	if errors.As(err, &limitErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Patched file too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("The delta makes a file larger than the limit of %d bytes", limitErr.Limit),
+		})
+		return
+	}
+	if errors.Is(err, delta.ErrBaseMismatch) {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:     "Wrong old file",
+			Code:      http.StatusConflict,
+			ErrorCode: ErrCodeBaseMismatch,
+			Message:   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid delta",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", oldFile.Filename))
+	c.Header("Content-Length", strconv.Itoa(len(newContent)))
+	c.Data(http.StatusOK, "application/octet-stream", newContent)
+}
+
+// ChecksumResponse is the answer of HandleChecksum
+type ChecksumResponse struct {
+	Filename  string            `json:"filename"`
+	Size      int64             `json:"size"`
+	Checksums map[string]string `json:"checksums"` // hex, by algorithm
+}
+
+// HandleChecksum computes the checksums of the uploaded "file" while it
+// arrives. The algorithms field lists the ones wanted, separated by commas,
+// all of checksum.Algorithms by default. Like for HandleCompress the fields
+// have to come before the file.
+func HandleChecksum(c *gin.Context) {
+	form, err := newUpload(c, maxFileSize)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	part, err := form.NextFile()
+	if err != nil && err != io.EOF {
+		respondUploadError(c, err)
+		return
+	}
+	if err == io.EOF || part.Field != "file" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "File upload error",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUploadFailed,
+			Message:   "No file provided or file upload failed",
+		})
+		return
+	}
+	algorithms, err := checksum.ParseAlgorithms(form.Field("algorithms"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("%v, supported: %v", err, checksum.Algorithms),
+		})
+		return
+	}
+
+	sums, size, err := checksum.Compute(part, algorithms)
+	if err == nil {
+		err = form.Finish()
+	}
+	if uploadErr := form.Err(); uploadErr != nil {
+		err = uploadErr
+	}
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ChecksumResponse{Filename: part.Filename, Size: size, Checksums: sums})
+}
+
+// HandleStats aggregates the recorded jobs for capacity planning. The
+// optional from and to parameters bound the time range, as RFC 3339 or unix
+// seconds, and algorithm restricts it to one algorithm.
+func HandleStats(c *gin.Context) {
+	if jobHistory == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "Stats not recorded",
+			Code:      http.StatusServiceUnavailable,
+			ErrorCode: ErrCodeNotEnabled,
+			Message:   "Set STATS_DB to record the stats of compressions and decompressions",
+		})
+		return
+	}
+
+	var filter history.Filter
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := c.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid time",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeInvalidRequest,
+				Message:   fmt.Sprintf("%s must be an RFC 3339 time or unix seconds, got %q", bound.name, value),
+			})
+			return
+		}
+		*bound.t = t
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid time range",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "from must not be after to",
+		})
+		return
+	}
+	filter.Algorithm = c.Query("algorithm")
+	if filter.Algorithm != "" && !compression.IsValidAlgorithm(filter.Algorithm) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("Supported algorithms: %v", compression.GetSupportedAlgorithms()),
+		})
+		return
+	}
+
+	aggregate, err := jobHistory.Query(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Stats query failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":              aggregate.Jobs,
+		"jobs_by_algorithm": aggregate.ByAlgorithm,
+		"input_bytes":       aggregate.InputBytes,
+		"output_bytes":      aggregate.OutputBytes,
+		"bytes_saved":       aggregate.BytesSaved,
+		"average_ratio":     aggregate.AverageRatio,
+		"latency_ms": gin.H{
+			"average": milliseconds(aggregate.AverageTime),
+			"p95":     milliseconds(aggregate.P95Time),
+			"max":     milliseconds(aggregate.MaxTime),
+		},
+	})
+}
+
+// recordJob adds a job that finished now to the history. It is written in
+// the background so the response is not held up, failures are only logged.
+func recordJob(operation string, stats *compression.Stats, start time.Time) {
+	if jobHistory == nil || stats == nil {
+		return
+	}
+	job := history.Job{
+		Time:             start,
+		Operation:        operation,
+		Algorithm:        stats.Algorithm,
+		OriginalSize:     stats.OriginalSize,
+		ProcessedSize:    stats.ProcessedSize,
+		CompressionRatio: stats.CompressionRatio,
+		Duration:         time.Since(start),
+		Schema:           compression.StatsSchemaVersion,
+	}
+	go func() {
+		if err := jobHistory.Add(job); err != nil {
+			log.Printf("Failed to record the stats of a %s job: %v", operation, err)
+		}
+	}()
+}
+
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// respondDecryptionError sends the error of opening encrypted input, a
+// missing or wrong password is the client's to fix
+func respondDecryptionError(c *gin.Context, err error) {
+	title := "Decryption failed"
+	if errors.Is(err, encryption.ErrPasswordRequired) {
+		title = "Password required"
+	}
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Error:     title,
+		Code:      http.StatusBadRequest,
+		ErrorCode: errorCodeOf(err, ErrCodeDecryptionFailed),
+		Message:   err.Error(),
+	})
 }
 
 // HandleInfo provides information about supported algorithms
@@ -215,28 +1330,88 @@ func HandleInfo(c *gin.Context) {
 		"service": "File Compression/Decompression Tool",
 		"version": "1.0.0",
 		"algorithms": map[string]interface{}{
-			"supported": compression.GetSupportedAlgorithms(),
-			"descriptions": map[string]string{
-				"huffman": "Huffman coding - lossless data compression using variable-length codes",
-				"lzss":    "Lempel-Ziv-Storer-Szymanski - dictionary-based compression",
-				"flate":   "DEFLATE - combination of LZ77 and Huffman coding",
-				"gzip":    "GZIP - wrapper around DEFLATE with headers and checksums",
-			},
+			"supported":    enabledAlgorithms(),
+			"disabled":     append([]string{}, disabledAlgorithms...),
+			"capabilities": compression.VerifyBinarySupport(),
+			"descriptions": algorithmDescriptions(),
+			"policy":       compressionPolicyRules(),
 		},
 		"limits": map[string]interface{}{
-			"max_file_size": fmt.Sprintf("%d bytes (%.1f MB)", maxFileSize, float64(maxFileSize)/(1024*1024)),
+			"max_file_size":    fmt.Sprintf("%d bytes (%.1f MB)", maxFileSize, float64(maxFileSize)/(1024*1024)),
+			"max_decoded_size": fmt.Sprintf("%d bytes (%.1f MB)", maxDecodedSize, float64(maxDecodedSize)/(1024*1024)),
 		},
 		"endpoints": map[string]interface{}{
-			"compress":   "POST /compress - Upload file for compression",
-			"decompress": "POST /decompress - Upload file for decompression",
-			"info":       "GET /info - Get service information",
-			"health":     "GET /health - Health check",
+			"compress":     "POST /compress - Upload file for compression",
+			"decompress":   "POST /decompress - Upload file for decompression",
+			"archive":      "POST /api/v1/archive, /api/v1/archive/diff - Pack files into a zip archive, compare two archives",
+			"batch":        "POST /api/v1/batch - Compress files one by one, streaming a result per file as NDJSON as each one finishes",
+			"container":    "POST /api/v1/container, /api/v1/container/list, /api/v1/container/extract - Create, list and extract containers",
+			"seekable":     "POST /api/v1/seekable, /api/v1/seekable/read - Create a seekable stream and read a byte range of it",
+			"delta":        "POST /api/v1/delta, /api/v1/patch - Make a delta between two files and apply it",
+			"checksum":     "POST /api/v1/checksum - Compute the CRC-32, CRC-32C, Adler-32, xxHash64 or SHA-256 of a file",
+			"analyze":      "POST /api/v1/analyze - Detect the type of a file and recommend how to compress it",
+			"concat":       "POST /api/v1/concat, /api/v1/split - Append files compressed one at a time to a gzip or flate stream, split it into them again",
+			"stats":        "GET /api/v1/stats - Aggregate the stats of past jobs",
+			"export":       "GET /api/v1/export - Download files of EXPORT_DIR as a tar.gz, when it is set",
+			"import":       "POST /api/v1/import - Extract a tar.gz into IMPORT_DIR atomically, when it is set",
+			"algorithms":   "GET /api/v1/algorithms - List the algorithms with their options and features",
+			"sessions":     "POST /api/v1/sessions, PUT /api/v1/sessions/:id/chunks, PUT /api/v1/sessions/:id/parts/:number, POST /api/v1/sessions/:id/finish - Upload a large input in chunks or parallel parts and compress it",
+			"dictionaries": "POST, GET /api/v1/dictionaries, GET, DELETE /api/v1/dictionaries/:id - Manage the preset dictionaries named by dictionary_id, json, html and logs are built in",
+			"results":      "GET /api/v1/results, GET, DELETE /api/v1/results/:token - List, download again and delete the recent results of the API key",
+			"pipelines":    "GET /api/v1/pipelines - List the pipelines compress and decompress requests may name",
+			"errors":       "GET /api/v1/errors - List the error codes of error responses",
+			"info":         "GET /info - Get service information",
+			"health":       "GET /health - Health check",
+			"ready":        "GET /ready - Readiness check, 503 until the codecs are warmed up",
 		},
 	}
+	if resultCache != nil {
+		info["result_cache"] = resultCache.Stats()
+	}
+	if compressionExperiment != nil {
+		info["experiment"] = compressionExperiment.Stats()
+	}
+	if workerPool != nil {
+		info["workers"] = gin.H{"workers": workerPool.Workers(), "queues": workerPool.Stats()}
+	}
 
 	c.JSON(http.StatusOK, info)
 }
 
+// HandleAlgorithms lists every algorithm with its options, features,
+// capabilities and file extension, as the compression registry has them,
+// and whether it is enabled on this server
+func HandleAlgorithms(c *gin.Context) {
+	var algorithms []AlgorithmAvailability
+	for _, info := range compression.Algorithms() {
+		algorithms = append(algorithms, AlgorithmAvailability{AlgorithmInfo: info, Enabled: algorithmEnabled(info.Name)})
+	}
+	c.JSON(http.StatusOK, gin.H{"algorithms": algorithms})
+}
+
+// algorithmDescriptions maps the algorithms to their descriptions
+func algorithmDescriptions() map[string]string {
+	descriptions := make(map[string]string)
+	for _, algorithm := range compression.GetSupportedAlgorithms() {
+		info, _ := compression.AlgorithmByName(algorithm)
+		descriptions[algorithm] = info.Description
+	}
+	return descriptions
+}
+
+// compressionPolicyRules lists the rules algorithm=auto compresses by
+func compressionPolicyRules() []string {
+	policy := compression.DefaultPolicy
+	if compressionPolicy != nil {
+		policy = *compressionPolicy
+	}
+	rules := make([]string, 0, len(policy.Rules))
+	for _, rule := range policy.Rules {
+		rules = append(rules, rule.ContentType+"="+rule.Action)
+	}
+	return rules
+}
+
 // HandleHealth provides a simple health check endpoint
 func HandleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -245,6 +1420,51 @@ func HandleHealth(c *gin.Context) {
 	})
 }
 
+// ready is set by MarkReady once the codecs are warmed up
+var ready atomic.Bool
+
+// MarkReady opens the readiness gate, /ready answers 200 from then on
+func MarkReady() {
+	ready.Store(true)
+}
+
+// HandleReady answers 503 until the server is warmed up, so load balancers
+// send it traffic only once the first requests do not pay for the warm-up.
+// Requests sent earlier are still served.
+func HandleReady(c *gin.Context) {
+	if !ready.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "warming up",
+			"service": "compression-service",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ready",
+		"service": "compression-service",
+	})
+}
+
+// parseNameTemplate parses the name_template of a request, fallback when it
+// has none. When it is invalid the error response is already sent and ok is
+// false.
+func parseNameTemplate(c *gin.Context, template, fallback string) (naming.Template, bool) {
+	if template == "" {
+		template = fallback
+	}
+	parsed, err := naming.Parse(template)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("name_template: %v. Fields are {name}, {ext}, {alg}, {algext}, {op}, {date} and {time}", err),
+		})
+		return naming.Template{}, false
+	}
+	return parsed, true
+}
+
 // Helper functions
 func getBaseFilename(filename string) string {
 	if filename == "" {
@@ -259,17 +1479,3 @@ func getBaseFilename(filename string) string {
 	}
 	return filename
 }
-
-func getExtensionForAlgorithm(algorithm string) string {
-	extensions := map[string]string{
-		"huffman": "huff",
-		"lzss":    "lzss",
-		"flate":   "flate",
-		"gzip":    "gz",
-	}
-
-	if ext, exists := extensions[algorithm]; exists {
-		return ext
-	}
-	return "compressed"
-}
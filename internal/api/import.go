@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/archive"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// importDir is the directory HandleImport extracts archives into, set in
+// SetupRoutes
+var importDir string
+
+// ImportResponse is the answer of HandleImport
+type ImportResponse struct {
+	Path string `json:"path"`
+	archive.ExtractReport
+}
+
+// HandleImport extracts the uploaded .tar.gz "file" into IMPORT_DIR, or the
+// directory below it named by the "path" field, atomically: nothing is
+// written there before the whole archive decoded. The "on_conflict" field
+// says what happens to paths that exist, overwrite (the default, as for
+// the untar command), skip or rename. Links in the archive are left out.
+func HandleImport(c *gin.Context) {
+	form, files, ok := readUpload(c, "file")
+	if !ok {
+		return
+	}
+	policy, err := archive.ParseCollisionPolicy(form.DefaultField("on_conflict", "overwrite"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	dir := form.DefaultField("path", ".")
+	if !filepath.IsLocal(filepath.FromSlash(dir)) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid path",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUnsafePath,
+			Message:   fmt.Sprintf("path %q leaves the import directory", dir),
+		})
+		return
+	}
+
+	options := compression.Options{Algorithm: "gzip", MaxDecodedSize: maxDecodedSize}
+	tarData, _, err := compression.DecompressContext(c.Request.Context(), files["file"][0].Content, options)
+	var limit *compression.SizeLimitError
+	if errors.As(err, &limit) {
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Decompressed data too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("The archive grew past the limit of %d bytes and decompression was stopped", limit.Limit),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid compressed data",
+			Code:      http.StatusBadRequest,
+			ErrorCode: errorCodeOf(err, ErrCodeInputCorrupt),
+			Message:   err.Error(),
+		})
+		return
+	}
+
+	dest := filepath.Join(importDir, filepath.FromSlash(dir))
+	tarOptions := archive.TarOptions{Symlinks: archive.SymlinksSkip, Collisions: policy}
+	report, err := archive.ExtractTarAtomic(bytes.NewReader(tarData), dest, tarOptions)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, ImportResponse{Path: filepath.ToSlash(filepath.Clean(dir)), ExtractReport: *report})
+	case errors.Is(err, archive.ErrUnsafePath), errors.Is(err, archive.ErrUnsafeLink):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid path",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUnsafePath,
+			Message:   err.Error(),
+		})
+	case errors.Is(err, archive.ErrUnsupportedEntry):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Unsupported archive",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeFormatUnsupported,
+			Message:   err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Import failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+	}
+}
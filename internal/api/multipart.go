@@ -0,0 +1,296 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// uploadIdleTimeout is how long an upload may stall. Every read pushes the
+// deadline back, so a large upload is not cut off by the server's read
+// timeout as long as bytes keep arriving. The write deadline is pushed back
+// with it, a response streamed while the upload is read may have nothing to
+// write for a long while.
+const uploadIdleTimeout = 30 * time.Second
+
+// maxFieldBytes caps what the form fields of a request may add up to, file
+// parts are capped by maxFileSize
+const maxFieldBytes = 1 << 20
+
+var (
+	errNotMultipart     = errors.New("request is not multipart/form-data")
+	errUploadTooLarge   = errors.New("upload is too large")
+	errFieldsTooLarge   = errors.New("form fields are too large")
+	errUploadTimeout    = errors.New("upload stalled")
+	errFieldAfterStream = errors.New("form fields have to come before the file")
+)
+
+// upload reads a multipart/form-data request part by part. Unlike
+// ParseMultipartForm it does not spool the files to memory or disk before
+// the handler gets to see them: fields are collected as they arrive and file
+// parts are handed out as readers that count against the limit while they
+// are read, so an oversized upload is refused once it crosses the limit.
+type upload struct {
+	reader   *multipart.Reader
+	response *http.ResponseController
+	fields   url.Values
+	fieldLen int
+	read     int64 // file bytes read so far, over all parts
+	limit    int64
+	err      error // why reading stopped, if it was the limit or a stall
+}
+
+// uploadPart is a file part of an upload, reading it counts against the
+// limit of the upload
+type uploadPart struct {
+	Field    string
+	Filename string
+	io.Reader
+}
+
+// uploadedFile is a file part that was read whole
+type uploadedFile struct {
+	Field    string
+	Filename string
+	Content  []byte
+}
+
+// newUpload starts reading the multipart body of c, the files may add up to
+// limit bytes. The query parameters count as fields given before the body.
+func newUpload(c *gin.Context, limit int64) (*upload, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotMultipart, err)
+	}
+	return &upload{
+		reader:   reader,
+		response: http.NewResponseController(c.Writer),
+		fields:   c.Request.URL.Query(),
+		limit:    limit,
+	}, nil
+}
+
+// NextFile collects the fields up to the next file part and returns that
+// part, or io.EOF at the end of the form. The part has to be read before
+// NextFile is called again.
+func (u *upload) NextFile() (*uploadPart, error) {
+	for {
+		part, err := u.reader.NextPart()
+		if err != nil {
+			return nil, u.readError(err)
+		}
+		if part.FileName() == "" {
+			if err := u.readField(part); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return &uploadPart{
+			Field:    part.FormName(),
+			Filename: part.FileName(),
+			Reader:   &uploadReader{upload: u, part: part},
+		}, nil
+	}
+}
+
+// ReadAll reads the rest of the form, the files are read whole
+func (u *upload) ReadAll() ([]uploadedFile, error) {
+	var files []uploadedFile
+	for {
+		part, err := u.NextFile()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, uploadedFile{Field: part.Field, Filename: part.Filename, Content: content})
+	}
+}
+
+// Finish reads what follows a file that was streamed, anything but the end
+// of the form would have come too late to be used
+func (u *upload) Finish() error {
+	fields := len(u.fields)
+	part, err := u.NextFile()
+	if err == nil {
+		return fmt.Errorf("%w, %q came after it", errFieldAfterStream, part.Field)
+	}
+	if err != io.EOF {
+		return err
+	}
+	if len(u.fields) > fields {
+		return errFieldAfterStream
+	}
+	return nil
+}
+
+// Bind maps the fields collected so far onto obj like c.ShouldBind does
+func (u *upload) Bind(obj any) error {
+	if err := binding.MapFormWithTag(obj, u.fields, "form"); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// Field returns the first value of a field collected so far
+func (u *upload) Field(name string) string {
+	return u.fields.Get(name)
+}
+
+// DefaultField is Field with a value for fields that were not given, like
+// c.DefaultPostForm
+func (u *upload) DefaultField(name, value string) string {
+	if values, ok := u.fields[name]; ok && len(values) > 0 {
+		return values[0]
+	}
+	return value
+}
+
+// Fields returns every value of a field collected so far
+func (u *upload) Fields(name string) []string {
+	return u.fields[name]
+}
+
+func (u *upload) readField(part *multipart.Part) error {
+	value, err := io.ReadAll(io.LimitReader(&uploadReader{upload: u, part: part, field: true}, int64(maxFieldBytes-u.fieldLen+1)))
+	if err != nil {
+		return err
+	}
+	u.fieldLen += len(value)
+	if u.fieldLen > maxFieldBytes {
+		return errFieldsTooLarge
+	}
+	u.fields.Add(part.FormName(), string(value))
+	return nil
+}
+
+// Err returns errUploadTooLarge or errUploadTimeout once reading ran into
+// them. A codec reading a part may report something else instead, e.g. the
+// request context that the server cancels when the read deadline passes.
+func (u *upload) Err() error {
+	return u.err
+}
+
+// readError tells a stalled client apart from a malformed body
+func (u *upload) readError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		u.err = errUploadTimeout
+		return errUploadTimeout
+	}
+	return err
+}
+
+// uploadReader reads a part, pushing the read and write deadlines back
+// before every read and, for files, counting the bytes against the limit
+type uploadReader struct {
+	upload *upload
+	part   *multipart.Part
+	field  bool
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	u := r.upload
+	// not every ResponseWriter supports deadlines, e.g. in tests
+	deadline := time.Now().Add(uploadIdleTimeout)
+	u.response.SetReadDeadline(deadline)
+	u.response.SetWriteDeadline(deadline)
+	if !r.field {
+		if u.read > u.limit {
+			return 0, errUploadTooLarge
+		}
+		// one byte more than is left tells a file that ends right at the
+		// limit apart from one that goes past it
+		p = p[:min(int64(len(p)), u.limit-u.read+1)]
+	}
+	n, err := r.part.Read(p)
+	if !r.field {
+		u.read += int64(n)
+		if u.read > u.limit {
+			u.err = errUploadTooLarge
+			return n, errUploadTooLarge
+		}
+	}
+	if err != nil && err != io.EOF {
+		err = u.readError(err)
+	}
+	return n, err
+}
+
+// readUpload reads the whole form of c and checks that every field in
+// required has a file. When that fails the error response is already sent
+// and ok is false.
+func readUpload(c *gin.Context, required ...string) (form *upload, files map[string][]uploadedFile, ok bool) {
+	form, err := newUpload(c, maxFileSize)
+	if err != nil {
+		respondUploadError(c, err)
+		return nil, nil, false
+	}
+	all, err := form.ReadAll()
+	if err != nil {
+		respondUploadError(c, err)
+		return nil, nil, false
+	}
+	files = make(map[string][]uploadedFile)
+	for _, file := range all {
+		files[file.Field] = append(files[file.Field], file)
+	}
+	for _, field := range required {
+		if len(files[field]) == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "File upload error",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeUploadFailed,
+				Message:   fmt.Sprintf("No %s provided or file upload failed", field),
+			})
+			return nil, nil, false
+		}
+	}
+	return form, files, true
+}
+
+// respondUploadError sends the errors reading an upload can run into
+func respondUploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "File too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("Maximum file size is %d bytes", maxFileSize),
+		})
+	case errors.Is(err, errFieldsTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Form fields too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("The form fields may add up to %d bytes", maxFieldBytes),
+		})
+	case errors.Is(err, errUploadTimeout):
+		c.JSON(http.StatusRequestTimeout, ErrorResponse{
+			Error:     "Upload timed out",
+			Code:      http.StatusRequestTimeout,
+			ErrorCode: ErrCodeUploadTimeout,
+			Message:   fmt.Sprintf("Nothing arrived for %v", uploadIdleTimeout),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "File upload error",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeUploadFailed,
+			Message:   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+)
+
+// formPart is a part of a multipart form, a file part when it has a filename
+type formPart struct {
+	name     string
+	filename string
+	content  []byte
+}
+
+// field and file make the parts of a form
+func field(name, value string) formPart { return formPart{name: name, content: []byte(value)} }
+func file(name string, content []byte) formPart {
+	return formPart{name: name, filename: name + ".bin", content: content}
+}
+
+// newFormRequest returns a POST of parts, in their order, to path
+func newFormRequest(path string, parts ...formPart) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var w interface{ Write([]byte) (int, error) }
+		if part.filename != "" {
+			w, _ = writer.CreateFormFile(part.name, part.filename)
+		} else {
+			w, _ = writer.CreateFormField(part.name)
+		}
+		w.Write(part.content)
+	}
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// serve sends req to handler and returns the response
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorOf decodes the ErrorResponse of a failed request
+func errorOf(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("the %d response is not an ErrorResponse: %v, %q", rec.Code, err, rec.Body.String())
+	}
+	return response
+}
+
+// TestUploadLimit sends a file right at MAX_FILE_SIZE and one a byte past
+// it to endpoints that stream the file, into a checksum and into a codec,
+// and to one that reads the whole form first
+func TestUploadLimit(t *testing.T) {
+	const limit = 1000
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.MaxFileSize = limit })
+	for _, test := range []struct {
+		path   string
+		fields []formPart
+		status int // at the limit
+	}{
+		{"/api/v1/checksum", nil, http.StatusOK},
+		{"/api/v1/compress", []formPart{field("algorithm", "flate")}, http.StatusOK},
+		{"/api/v1/dictionaries", []formPart{field("name", "limit")}, http.StatusCreated},
+	} {
+		at := serve(router, newFormRequest(test.path, append(test.fields, file("file", bytes.Repeat([]byte("a"), limit)))...))
+		if at.Code != test.status {
+			t.Errorf("%s: a file of %d bytes answered %d, want %d: %s", test.path, limit, at.Code, test.status, at.Body)
+		}
+
+		over := serve(router, newFormRequest(test.path, append(test.fields, file("file", bytes.Repeat([]byte("a"), limit+1)))...))
+		if over.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: a file of %d bytes answered %d, want 413: %s", test.path, limit+1, over.Code, over.Body)
+			continue
+		}
+		if response := errorOf(t, over); response.ErrorCode != ErrCodeLimitExceeded || response.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: %+v", test.path, response)
+		}
+	}
+}
+
+func TestUploadFieldsTooLarge(t *testing.T) {
+	router := newTestRouter(t, nil)
+	half := strings.Repeat("f", maxFieldBytes/2)
+	for name, fields := range map[string][]formPart{
+		"one field":  {field("algorithms", half+half+"f")},
+		"two fields": {field("algorithms", half), field("name", half+"f")},
+	} {
+		rec := serve(router, newFormRequest("/api/v1/checksum", append(fields, file("file", []byte("data")))...))
+		if rec.Code != http.StatusRequestEntityTooLarge || errorOf(t, rec).ErrorCode != ErrCodeLimitExceeded {
+			t.Errorf("%s over %d bytes answered %d: %s", name, maxFieldBytes, rec.Code, rec.Body)
+		}
+	}
+
+	// right at the cap the fields are read
+	rec := serve(router, newFormRequest("/api/v1/checksum", field("algorithms", "crc32"), field("name", half+half[5:]), file("file", []byte("data"))))
+	if rec.Code != http.StatusOK {
+		t.Errorf("fields of %d bytes answered %d: %s", maxFieldBytes, rec.Code, rec.Body)
+	}
+}
+
+// TestFieldAfterStreamedFile checks that a field sent after a file that was
+// handed on while it arrived is refused rather than silently ignored
+func TestFieldAfterStreamedFile(t *testing.T) {
+	router := newTestRouter(t, nil)
+	for path, before := range map[string][]formPart{
+		"/api/v1/checksum": nil,
+		"/api/v1/compress": {field("algorithm", "flate")},
+	} {
+		rec := serve(router, newFormRequest(path, append(before, file("file", []byte("streamed")), field("btype", "1"))...))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s answered %d, want 400: %s", path, rec.Code, rec.Body)
+			continue
+		}
+		if response := errorOf(t, rec); response.ErrorCode != ErrCodeUploadFailed || !strings.Contains(response.Message, "before the file") {
+			t.Errorf("%s: %+v", path, response)
+		}
+	}
+}
+
+func TestMissingPart(t *testing.T) {
+	router := newTestRouter(t, nil)
+	for _, test := range []struct {
+		path  string
+		parts []formPart
+	}{
+		{"/api/v1/checksum", []formPart{field("algorithms", "crc32")}},
+		{"/api/v1/compress", []formPart{field("algorithm", "flate"), file("data", []byte("misnamed"))}},
+		{"/api/v1/dictionaries", []formPart{field("name", "missing")}},
+		{"/api/v1/delta", []formPart{file("new", []byte("the old file is missing"))}},
+	} {
+		rec := serve(router, newFormRequest(test.path, test.parts...))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s answered %d, want 400: %s", test.path, rec.Code, rec.Body)
+			continue
+		}
+		if response := errorOf(t, rec); response.ErrorCode != ErrCodeUploadFailed || !strings.HasPrefix(response.Message, "No ") {
+			t.Errorf("%s: %+v", test.path, response)
+		}
+	}
+
+	// a body that is not a form at all
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/checksum", strings.NewReader("raw"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if rec := serve(router, req); rec.Code != http.StatusBadRequest || errorOf(t, rec).ErrorCode != ErrCodeUploadFailed {
+		t.Errorf("a raw body answered %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// compressionPipelines are the pipelines PIPELINES defines, which requests
+// select by name, set in SetupRoutes
+var compressionPipelines map[string]compression.Pipeline
+
+// PipelineInfo describes a pipeline to clients
+type PipelineInfo struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"` // e.g. "delta+flate btype=2"
+	Algorithm  string `json:"algorithm"`
+	Filter     string `json:"filter,omitempty"`
+}
+
+// HandleListPipelines lists the pipelines requests may name
+func HandleListPipelines(c *gin.Context) {
+	pipelines := []PipelineInfo{}
+	for _, name := range compression.PipelineNames(compressionPipelines) {
+		pipeline := compressionPipelines[name]
+		options := pipeline.Options(compression.Options{})
+		pipelines = append(pipelines, PipelineInfo{
+			Name:       name,
+			Definition: pipeline.String(),
+			Algorithm:  options.Algorithm,
+			Filter:     options.Filter,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"pipelines": pipelines})
+}
+
+// applyPipeline sets up options for the pipeline a request names, it
+// replaces the options given as fields. When the pipeline is unknown or
+// fields it replaces are given as well the error response is already sent
+// and ok is false.
+func applyPipeline(c *gin.Context, name string, options compression.Options, fieldsGiven bool) (compression.Options, bool) {
+	if name == "" {
+		return options, true
+	}
+	pipeline, found := compressionPipelines[name]
+	if !found {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Unknown pipeline",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("Pipeline %q is not defined, use one of %v", name, compression.PipelineNames(compressionPipelines)),
+		})
+		return options, false
+	}
+	if fieldsGiven {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "pipeline replaces algorithm, btype, bfinal and filter, they cannot be given with it",
+		})
+		return options, false
+	}
+	c.Header("X-Compression-Pipeline", name)
+	return pipeline.Options(options), true
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/cache"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+)
+
+// resultCache holds the output of decompressions by the SHA-256 of their
+// input, so the same archive decompressed again is not decoded again. It is
+// set in SetupRoutes, nil when RESULT_CACHE_SIZE is 0.
+var resultCache *cache.Cache
+
+// Frames a cached result is kept in, checksummed since the cache may be on disk
+const (
+	frameResultStats  = 1 // the stats as JSON
+	frameResultOutput = 2 // the decompressed data
+)
+
+// resultKey is the SHA-256 of the compressed data followed by a hash of what
+// else the output depends on: the algorithm asked for, which decides the
+// warnings, the filter and the dictionary. It is empty without a cache.
+func resultKey(data []byte, req DecompressRequest, dict []byte) string {
+	if resultCache == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	variant := sha256.New()
+	fmt.Fprintf(variant, "%s\x00%s\x00", req.Algorithm, req.Filter)
+	variant.Write(dict)
+	return hex.EncodeToString(sum[:]) + "-" + hex.EncodeToString(variant.Sum(nil)[:8])
+}
+
+// cachedResult returns the output and stats a decompression with the key
+// had, if the cache still holds them
+func cachedResult(key string) ([]byte, *compression.Stats, bool) {
+	if resultCache == nil {
+		return nil, nil, false
+	}
+	entry, ok := resultCache.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+	statsFrame, n, err := framing.Parse(entry)
+	if err != nil || statsFrame.Type != frameResultStats {
+		return nil, nil, false
+	}
+	outputFrame, _, err := framing.Parse(entry[n:])
+	if err != nil || outputFrame.Type != frameResultOutput {
+		return nil, nil, false
+	}
+	// entries of another schema version are misses
+	stats, err := compression.ParseStatsRecord(statsFrame.Payload)
+	if err != nil {
+		return nil, nil, false
+	}
+	return outputFrame.Payload, stats, true
+}
+
+// storeResult caches the output of a decompression that went through
+// without damage, a failure to is only logged
+func storeResult(key string, data []byte, stats *compression.Stats) {
+	if resultCache == nil || len(stats.Damage) > 0 || len(stats.ChecksumMismatches) > 0 {
+		return
+	}
+	encoded, err := json.Marshal(stats.Record())
+	if err != nil {
+		log.Printf("Failed to cache a decompression result: %v", err)
+		return
+	}
+	entry := framing.Append(make([]byte, 0, len(encoded)+len(data)+32), frameResultStats, encoded, true)
+	entry = framing.Append(entry, frameResultOutput, data, true)
+	if err := resultCache.Put(key, entry); err != nil {
+		log.Printf("Failed to cache a decompression result: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/cache"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+	"github.com/gin-gonic/gin"
+)
+
+// recentResults keeps the latest outputs of /compress and /decompress under
+// the token sent in X-Result-Token, so a download that broke off can be
+// fetched again without uploading the input again. Every API key keeps its
+// results in a namespace of its own, held to RESULT_STORE_QUOTA. It is set
+// in SetupRoutes, nil when RESULT_STORE_SIZE is 0.
+var recentResults *cache.Cache
+
+// Frames a kept result is stored in
+const (
+	frameKeptHeader = 1 // keptResult as JSON
+	frameKeptOutput = 2 // the output as it was sent
+)
+
+// keptResult is what is needed to send a kept output again as it was sent
+type keptResult struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+// newResultToken returns a token for a result, or "" without a store
+func newResultToken() string {
+	if recentResults == nil {
+		return ""
+	}
+	token := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(token)
+}
+
+// StoredResult describes a kept result in GET /api/v1/results
+type StoredResult struct {
+	Token    string    `json:"token"`
+	Size     int64     `json:"size"` // what it takes up of the quota, a little more than the output
+	Download string    `json:"download"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// keepLimit is the largest output that is kept, the store would drop a
+// larger one right away
+func keepLimit() int64 {
+	stats := recentResults.Stats()
+	if stats.Quota > 0 && stats.Quota < stats.MaxBytes {
+		return stats.Quota
+	}
+	return stats.MaxBytes
+}
+
+// keepResult stores the output sent under token in namespace
+func keepResult(namespace, token string, result keptResult, output []byte) {
+	if token == "" {
+		return
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to keep a result: %v", err)
+		return
+	}
+	entry := framing.Append(make([]byte, 0, len(encoded)+len(output)+32), frameKeptHeader, encoded, false)
+	entry = framing.Append(entry, frameKeptOutput, output, false)
+	if err := recentResults.Put(cache.Key(namespace, token), entry); err != nil {
+		log.Printf("Failed to keep a result: %v", err)
+	}
+}
+
+// resultRecorder collects what is written to it up to a limit, past which
+// it gives up and drops what it has, since the output would not be kept
+type resultRecorder struct {
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (r *resultRecorder) Write(p []byte) (int, error) {
+	if !r.overflow && int64(r.buf.Len()+len(p)) > r.limit {
+		r.overflow = true
+		r.buf = bytes.Buffer{}
+	}
+	if !r.overflow {
+		r.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// HandleGetResult sends a result kept under its token again, as long as
+// newer results have not pushed it out of the store. Only the API key it was
+// made with finds it.
+func HandleGetResult(c *gin.Context) {
+	entry, ok := recentResults.Get(cache.Key(namespaceOf(c), c.Param("token")))
+	var header, output framing.Frame
+	if ok {
+		var n int
+		var err error
+		header, n, err = framing.Parse(entry)
+		if err == nil {
+			output, _, err = framing.Parse(entry[n:])
+		}
+		ok = err == nil && header.Type == frameKeptHeader && output.Type == frameKeptOutput
+	}
+	var result keptResult
+	if !ok || json.Unmarshal(header.Payload, &result) != nil {
+		respondResultNotFound(c)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", result.Filename))
+	c.Data(http.StatusOK, result.ContentType, output.Payload)
+}
+
+// HandleListResults lists the results kept for the API key, most recently
+// used first, with what they take up of its quota. It is only routed with
+// API keys; should a reload have removed them, the shared namespace is not
+// listed either.
+func HandleListResults(c *gin.Context) {
+	namespace := namespaceOf(c)
+	if namespace == "" {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:     "Not found",
+			Code:      http.StatusNotFound,
+			ErrorCode: ErrCodeNotFound,
+			Message:   "Results are only listed for API keys",
+		})
+		return
+	}
+	objects := recentResults.List(namespace)
+	results := make([]StoredResult, 0, len(objects))
+	for _, object := range objects {
+		results = append(results, StoredResult{
+			Token:    object.Name,
+			Size:     object.Size,
+			Download: "/api/v1/results/" + object.Name,
+			LastUsed: object.LastUsed,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "usage": recentResults.Usage(namespace)})
+}
+
+// HandleDeleteResult drops a kept result before newer ones push it out, to
+// make room in the quota of the API key
+func HandleDeleteResult(c *gin.Context) {
+	namespace, token := namespaceOf(c), c.Param("token")
+	found := slices.ContainsFunc(recentResults.List(namespace), func(object janitor.Object) bool { return object.Name == token })
+	if !found {
+		respondResultNotFound(c)
+		return
+	}
+	recentResults.Remove(cache.Key(namespace, token))
+	c.Status(http.StatusNoContent)
+}
+
+// respondResultNotFound answers for a token the API key has no result under
+func respondResultNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, ErrorResponse{
+		Error:     "Result not found",
+		Code:      http.StatusNotFound,
+		ErrorCode: ErrCodeNotFound,
+		Message:   "The token is unknown or the result was dropped to make room for newer ones",
+	})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+)
+
+// compressKept compresses data with flate as key and returns the response
+// and the token the output is kept under
+func compressKept(t *testing.T, router http.Handler, key string, data []byte) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	req := newFormRequest("/api/v1/compress", field("algorithm", "flate"), file("file", data))
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rec := serve(router, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("compress answered %d: %s", rec.Code, rec.Body)
+	}
+	token := rec.Header().Get("X-Result-Token")
+	if token == "" {
+		t.Fatal("compress sent no X-Result-Token")
+	}
+	return rec, token
+}
+
+// getResult requests a kept result, or deletes it, as key
+func getResult(router http.Handler, method, key, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/results/"+token, nil)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	return serve(router, req)
+}
+
+func TestResultDownload(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.ResultStoreSize = 1 << 20 })
+	compressed, token := compressKept(t, router, "", []byte(strings.Repeat("kept for a download that broke off\n", 50)))
+
+	rec := getResult(router, http.MethodGet, "", token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET answered %d: %s", rec.Code, rec.Body)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), compressed.Body.Bytes()) {
+		t.Errorf("the download differs from the output sent")
+	}
+	for _, header := range []string{"Content-Disposition", "Content-Type"} {
+		if got, want := rec.Header().Get(header), compressed.Header().Get(header); got != want {
+			t.Errorf("%s = %q, the output was sent with %q", header, got, want)
+		}
+	}
+
+	if rec := getResult(router, http.MethodDelete, "", token); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE answered %d: %s", rec.Code, rec.Body)
+	}
+	if rec := getResult(router, http.MethodGet, "", token); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE answered %d", rec.Code)
+	}
+}
+
+// TestResultEvicted fills RESULT_STORE_QUOTA with outputs that do not
+// compress, so the oldest is dropped for the newest
+func TestResultEvicted(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) {
+		cfg.ResultStoreSize = 1 << 20
+		cfg.ResultStoreQuota = 2500
+	})
+	random := rand.New(rand.NewSource(1))
+	var tokens []string
+	for range 3 {
+		data := make([]byte, 1000)
+		random.Read(data)
+		_, token := compressKept(t, router, "", data)
+		tokens = append(tokens, token)
+	}
+
+	rec := getResult(router, http.MethodGet, "", tokens[0])
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("the oldest result answered %d past the quota", rec.Code)
+	}
+	if response := errorOf(t, rec); response.ErrorCode != ErrCodeNotFound {
+		t.Errorf("%+v", response)
+	}
+	for _, token := range tokens[1:] {
+		if rec := getResult(router, http.MethodGet, "", token); rec.Code != http.StatusOK {
+			t.Errorf("a newer result answered %d: %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+// TestResultNamespaces checks that a result is only found with the API key
+// it was made with
+func TestResultNamespaces(t *testing.T) {
+	t.Setenv("API_KEYS", "key-a,key-b")
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.ResultStoreSize = 1 << 20 })
+	_, token := compressKept(t, router, "key-a", []byte("a result of key-a"))
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := getResult(router, method, "key-b", token)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s with the other key answered %d: %s", method, rec.Code, rec.Body)
+			continue
+		}
+		if response := errorOf(t, rec); response.ErrorCode != ErrCodeNotFound {
+			t.Errorf("%s with the other key: %+v", method, response)
+		}
+	}
+	if rec := getResult(router, http.MethodGet, "key-a", token); rec.Code != http.StatusOK {
+		t.Errorf("GET with the key it was made with answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// TestResultList checks that the results are only listed per API key, the
+// namespace every client shares without keys is not
+func TestResultList(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.ResultStoreSize = 1 << 20 })
+	compressKept(t, router, "", []byte("a result of the shared namespace"))
+	if rec := serve(router, httptest.NewRequest(http.MethodGet, "/api/v1/results", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("listing without API keys answered %d: %s", rec.Code, rec.Body)
+	}
+
+	t.Setenv("API_KEYS", "key-a")
+	router = newTestRouter(t, func(cfg *config.Config) { cfg.ResultStoreSize = 1 << 20 })
+	_, token := compressKept(t, router, "key-a", []byte("a result of key-a"))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/results", nil)
+	req.Header.Set("X-API-Key", "key-a")
+	rec := serve(router, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), token) {
+		t.Errorf("listing with a key answered %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// TestResultEncrypted checks that what was decrypted is not kept, the token
+// would hand it out without the password
+func TestResultEncrypted(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.ResultStoreSize = 1 << 20 })
+	rec := serve(router, newFormRequest("/api/v1/compress", field("algorithm", "flate"), field("password", "secret"), file("file", []byte("encrypted before it is kept"))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("compress answered %d: %s", rec.Code, rec.Body)
+	}
+	rec = serve(router, newFormRequest("/api/v1/decompress", field("algorithm", "flate"), field("password", "secret"), file("file", rec.Body.Bytes())))
+	if rec.Code != http.StatusOK || rec.Body.String() != "encrypted before it is kept" {
+		t.Fatalf("decompress answered %d: %s", rec.Code, rec.Body)
+	}
+	if token := rec.Header().Get("X-Result-Token"); token != "" {
+		t.Errorf("the decrypted output was kept under %s", token)
+	}
+}
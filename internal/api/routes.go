@@ -3,16 +3,123 @@ package api
 import (
 	"net/http"
 
+	"github.com/adilg123/file-compression-decompression-tool/internal/cache"
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/adilg123/file-compression-decompression-tool/internal/experiment"
+	"github.com/adilg123/file-compression-decompression-tool/internal/history"
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+	"github.com/adilg123/file-compression-decompression-tool/internal/scheduler"
+	"github.com/adilg123/file-compression-decompression-tool/internal/secrets"
+	"github.com/adilg123/file-compression-decompression-tool/internal/session"
+	"github.com/adilg123/file-compression-decompression-tool/internal/throttle"
 	"github.com/gin-gonic/gin"
 )
 
+// NewRouter creates the router with the middleware for the environment of
+// cfg: gin runs in release mode in staging and production, in test mode for
+// tests and in debug mode otherwise, and requests are logged as REQUEST_LOG
+// says. Panics are recovered in every environment.
+func NewRouter(cfg *config.Config) *gin.Engine {
+	switch cfg.Environment {
+	case "staging", "production":
+		gin.SetMode(gin.ReleaseMode)
+	case "test":
+		gin.SetMode(gin.TestMode)
+	default:
+		gin.SetMode(gin.DebugMode)
+	}
+	router := gin.New()
+	switch cfg.RequestLog {
+	case "all":
+		router.Use(gin.Logger())
+	case "errors":
+		router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+			Skip: func(c *gin.Context) bool { return c.Writer.Status() < http.StatusBadRequest },
+		}))
+	}
+	router.Use(gin.Recovery())
+	return router
+}
+
 // SetupRoutes configures all API routes
-func SetupRoutes(router *gin.Engine) {
+func SetupRoutes(router *gin.Engine, cfg *config.Config, keys *secrets.Store, jobs *history.Store, sessions *session.Store, dictionaries *dictionary.Store, results *cache.Cache, cleanup *janitor.Janitor) {
+	maxFileSize = cfg.MaxFileSize
+	maxDecodedSize = cfg.MaxDecodedSize
+	defaultAlgorithm = cfg.DefaultAlgorithm
+	compressionPolicy = nil
+	if cfg.CompressionPolicy != "" {
+		// Validate has checked the rules
+		policy, _ := compression.ParsePolicy(cfg.CompressionPolicy)
+		compressionPolicy = &policy
+	}
+	if cfg.Signatures != "" {
+		// Validate has checked the signatures
+		policy := compression.DefaultPolicy
+		if compressionPolicy != nil {
+			policy = *compressionPolicy
+		}
+		policy.Signatures, _ = compression.ParseSignatures(cfg.Signatures)
+		compressionPolicy = &policy
+	}
+	disabledAlgorithms = nil
+	if cfg.DisabledAlgorithms != "" {
+		// Validate has checked the algorithms
+		disabledAlgorithms, _ = compression.ParseAlgorithms(cfg.DisabledAlgorithms)
+		// algorithm=auto falls through to the rules after the ones for them
+		policy := compression.DefaultPolicy
+		if compressionPolicy != nil {
+			policy = *compressionPolicy
+		}
+		policy = policy.Excluding(disabledAlgorithms)
+		compressionPolicy = &policy
+	}
+	checksumAlgorithms = []string{checksum.CRC32}
+	if cfg.Checksums != "" {
+		// Validate has checked the algorithms
+		checksumAlgorithms, _ = checksum.ParseAlgorithms(cfg.Checksums)
+	}
+	compressionExperiment = nil
+	if cfg.ExperimentCandidate != "" {
+		// Validate has checked the candidate
+		candidate, _ := experiment.ParseCandidate(cfg.ExperimentCandidate)
+		compressionExperiment = experiment.New(candidate, cfg.ExperimentPercent, int(cfg.ExperimentConcurrency))
+	}
+	compressionPipelines = nil
+	if cfg.Pipelines != "" {
+		// Validate has checked the definitions
+		compressionPipelines, _ = compression.ParsePipelines(cfg.Pipelines)
+	}
+	jobHistory = jobs
+	serverLimiter = throttle.NewLimiter(cfg.ThrottleRate)
+	requestRate = cfg.ThrottleRequestRate
+	workerPool = scheduler.New(int(cfg.Workers))
+	uploadSessions = sessions
+	dictionaryStore = dictionaries
+	resultCache = results
+	recentResults = nil
+	if cfg.ResultStoreSize > 0 {
+		// in memory only, which cannot fail
+		recentResults, _ = cache.New(cfg.ResultStoreSize, "")
+		recentResults.SetQuota(cfg.ResultStoreQuota)
+		if cleanup != nil {
+			cleanup.Register("result-store", recentResults, janitor.Policy{TTL: cfg.ResultStoreTTL})
+		}
+	}
+	housekeeping = cleanup
+	exportDir = cfg.ExportDir
+	importDir = cfg.ImportDir
+
+	// Spans for every request, exported when OTLP is configured
+	router.Use(Tracing())
+
 	// CORS middleware for public API access
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -24,21 +131,79 @@ func SetupRoutes(router *gin.Engine) {
 
 	// Health check endpoint
 	router.GET("/health", HandleHealth)
+	router.GET("/ready", HandleReady)
 	
 	// Service information endpoint
 	router.GET("/info", HandleInfo)
 	router.GET("/", HandleInfo) // Root endpoint shows info
 	
+	// Compression endpoints require an API key when keys are configured
+	auth := APIKeyAuth(keys)
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
-		v1.POST("/compress", HandleCompress)
-		v1.POST("/decompress", HandleDecompress)
+		v1.POST("/compress", auth, HandleCompress)
+		v1.POST("/decompress", auth, HandleDecompress)
+		v1.GET("/stream", auth, HandleStream)
+		v1.POST("/archive", auth, HandleArchive)
+		v1.POST("/batch", auth, HandleBatch)
+		v1.POST("/archive/diff", auth, HandleArchiveDiff)
+		v1.POST("/container", auth, HandleCreateContainer)
+		v1.POST("/container/list", auth, HandleListContainer)
+		v1.POST("/container/extract", auth, HandleExtractContainer)
+		v1.POST("/seekable", auth, HandleCreateSeekable)
+		v1.POST("/seekable/read", auth, HandleReadSeekable)
+		v1.POST("/delta", auth, HandleDelta)
+		v1.POST("/patch", auth, HandlePatch)
+		v1.POST("/checksum", auth, HandleChecksum)
+		v1.POST("/analyze", auth, HandleAnalyze)
+		v1.POST("/concat", auth, HandleConcat)
+		v1.POST("/split", auth, HandleSplit)
+		v1.GET("/stats", auth, HandleStats)
+		if sessions != nil {
+			v1.POST("/sessions", auth, HandleCreateSession)
+			v1.PUT("/sessions/:id/chunks", auth, HandleAppendChunk)
+			v1.PUT("/sessions/:id/parts/:number", auth, HandleUploadPart)
+			v1.GET("/sessions/:id/parts", auth, HandleListParts)
+			v1.POST("/sessions/:id/finish", auth, HandleFinishSession)
+			v1.DELETE("/sessions/:id", auth, HandleDeleteSession)
+		}
+		if dictionaries != nil {
+			v1.POST("/dictionaries", auth, HandleUploadDictionary)
+			v1.GET("/dictionaries", auth, HandleListDictionaries)
+			v1.GET("/dictionaries/:id", auth, HandleGetDictionary)
+			v1.DELETE("/dictionaries/:id", auth, HandleDeleteDictionary)
+		}
+		if recentResults != nil {
+			// without API keys everyone shares the namespace, listing it
+			// would hand out the tokens of every other client
+			if keys != nil && keys.Configured(secrets.APIKeys) {
+				v1.GET("/results", auth, HandleListResults)
+			}
+			v1.GET("/results/:token", auth, HandleGetResult)
+			v1.DELETE("/results/:token", auth, HandleDeleteResult)
+		}
+		if exportDir != "" {
+			v1.GET("/export", auth, HandleExport)
+		}
+		if importDir != "" {
+			v1.POST("/import", auth, HandleImport)
+		}
+		v1.GET("/algorithms", HandleAlgorithms)
+		v1.GET("/pipelines", HandleListPipelines)
+		v1.GET("/errors", HandleErrorCatalog)
 		v1.GET("/info", HandleInfo)
 		v1.GET("/health", HandleHealth)
+		v1.GET("/ready", HandleReady)
 	}
 	
 	// Legacy routes for backward compatibility
-	router.POST("/compress", HandleCompress)
-	router.POST("/decompress", HandleDecompress)
+	router.POST("/compress", auth, HandleCompress)
+	router.POST("/decompress", auth, HandleDecompress)
+
+	// Profiling and runtime diagnostics, only when enabled
+	if cfg.DebugEndpoints {
+		setupDebugRoutes(router, auth)
+	}
 }
\ No newline at end of file
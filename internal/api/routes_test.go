@@ -0,0 +1,58 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/adilg123/file-compression-decompression-tool/internal/secrets"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter sets the routes up as main does for GO_ENV=test, with the
+// configuration changed by modify. API keys are read from API_KEYS, which
+// tests set with t.Setenv. The handlers keep their settings in package
+// variables, so tests of this package do not run in parallel.
+func newTestRouter(t *testing.T, modify func(cfg *config.Config)) *gin.Engine {
+	t.Helper()
+	t.Setenv("GO_ENV", "test")
+	cfg := config.Load()
+	if modify != nil {
+		modify(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := secrets.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionaries, err := dictionary.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionaries.SetQuota(cfg.DictionaryQuota)
+	router := NewRouter(cfg)
+	SetupRoutes(router, cfg, keys, nil, nil, dictionaries, nil, nil)
+	return router
+}
+
+func TestGinMode(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+	for _, test := range []struct {
+		environment string
+		mode        string
+	}{
+		{"production", gin.ReleaseMode},
+		{"staging", gin.ReleaseMode},
+		{"test", gin.TestMode},
+	} {
+		t.Setenv("GO_ENV", test.environment)
+		cfg := config.Load()
+		router := NewRouter(cfg)
+		SetupRoutes(router, cfg, nil, nil, nil, nil, nil, nil)
+		if gin.Mode() != test.mode {
+			t.Errorf("GO_ENV=%s runs gin in %s mode, want %s", test.environment, gin.Mode(), test.mode)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/naming"
+	"github.com/adilg123/file-compression-decompression-tool/internal/session"
+	"github.com/gin-gonic/gin"
+)
+
+// uploadSessions holds the inputs uploaded in chunks, set in SetupRoutes
+var uploadSessions *session.Store
+
+// SessionRequest represents the payload opening a session
+type SessionRequest struct {
+	Algorithm string `form:"algorithm" json:"algorithm"`
+	BType     *int   `form:"btype,omitempty" json:"btype,omitempty"`
+	BFinal    *int   `form:"bfinal,omitempty" json:"bfinal,omitempty"`
+	BlockSize int    `form:"block_size" json:"block_size"` // the most bytes of input a flate block covers
+	Filename  string `form:"filename" json:"filename"`     // names the download
+
+	// NameTemplate names the download after a template, as for /compress
+	NameTemplate string `form:"name_template" json:"name_template"`
+}
+
+// HandleCreateSession opens a session that the input is then uploaded to in
+// chunks, for inputs too large for a single request
+func HandleCreateSession(c *gin.Context) {
+	var req SessionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = defaultAlgorithm
+	}
+	if !compression.IsValidAlgorithm(req.Algorithm) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   fmt.Sprintf("Supported algorithms: %v", compression.GetSupportedAlgorithms()),
+		})
+		return
+	}
+	if !checkAlgorithmEnabled(c, req.Algorithm) {
+		return
+	}
+
+	if _, ok := parseNameTemplate(c, req.NameTemplate, naming.DefaultCompress); !ok {
+		return
+	}
+
+	options := compression.Options{Algorithm: req.Algorithm}
+	if req.BType != nil {
+		options.BType = uint32(*req.BType)
+	}
+	var ok bool
+	if options.Partial, ok = parseBFinal(c, req.BFinal, req.Algorithm); !ok {
+		return
+	}
+	if options.BlockSize, ok = parseBlockSize(c, req.BlockSize); !ok {
+		return
+	}
+	s, err := uploadSessions.Create(req.Filename, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Session failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+		return
+	}
+	s.NameTemplate = req.NameTemplate
+
+	c.Header("Location", "/api/v1/sessions/"+s.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"id":                 s.ID,
+		"algorithm":          s.Options.Algorithm,
+		"max_size":           uploadSessions.MaxSize(),
+		"max_chunk_size":     maxFileSize,
+		"expires_after_idle": uploadSessions.TTL().String(),
+	})
+}
+
+// HandleAppendChunk appends the request body to the session's input. The
+// optional offset parameter is where the chunk starts, a chunk that does not
+// start at the end of the input is refused with the size the input has, so
+// clients can retry chunks safely.
+func HandleAppendChunk(c *gin.Context) {
+	offset := int64(-1)
+	if value := c.Query("offset"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid request",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeInvalidRequest,
+				Message:   "offset must be a positive number of bytes",
+			})
+			return
+		}
+		offset = parsed
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxFileSize)
+	size, err := uploadSessions.Append(c.Param("id"), offset, body)
+	var offsetErr *session.OffsetError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "size": size})
+	case errors.As(err, &offsetErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Wrong offset",
+			"code":       http.StatusConflict,
+			"error_code": ErrCodeConflict,
+			"message":    err.Error(),
+			"size":       offsetErr.Size,
+		})
+	case errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Chunk too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("Maximum chunk size is %d bytes", maxFileSize),
+		})
+	case errors.Is(err, session.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Input too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("Maximum size of a session is %d bytes, the chunk was dropped", uploadSessions.MaxSize()),
+		})
+	default:
+		respondSessionError(c, err)
+	}
+}
+
+// HandleUploadPart stores the request body as part number :number of the
+// session's input. Parts can be sent at the same time and in any order, a
+// part sent again replaces the one before, so failed parts can be retried
+// on their own.
+func HandleUploadPart(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil || number < 1 || number > session.MaxParts {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   fmt.Sprintf("part numbers run from 1 to %d", session.MaxParts),
+		})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxFileSize)
+	size, err := uploadSessions.UploadPart(c.Param("id"), number, body)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, session.Part{Number: number, Size: size})
+	case errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Part too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("Maximum part size is %d bytes", maxFileSize),
+		})
+	case errors.Is(err, session.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:     "Input too large",
+			Code:      http.StatusRequestEntityTooLarge,
+			ErrorCode: ErrCodeLimitExceeded,
+			Message:   fmt.Sprintf("Maximum size of a session is %d bytes, the part was dropped", uploadSessions.MaxSize()),
+		})
+	default:
+		respondSessionError(c, err)
+	}
+}
+
+// HandleListParts lists the parts uploaded to a session so far, for
+// clients to find the ones to send again
+func HandleListParts(c *gin.Context) {
+	parts, err := uploadSessions.Parts(c.Param("id"))
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "parts": parts})
+}
+
+// HandleFinishSession compresses the session's input and sends it back,
+// after which the session is gone. When compression fails the session is
+// kept for another attempt. An input uploaded in parts is assembled in the
+// order of their numbers; the optional parts parameter is how many there
+// are, so a last part that never arrived is noticed.
+func HandleFinishSession(c *gin.Context) {
+	// compressing a large input outlasts the server's write timeout
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	count := 0
+	if value := c.Query("parts"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > session.MaxParts {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid request",
+				Code:      http.StatusBadRequest,
+				ErrorCode: ErrCodeInvalidRequest,
+				Message:   fmt.Sprintf("parts must be a number from 1 to %d", session.MaxParts),
+			})
+			return
+		}
+		count = parsed
+	}
+	s, err := uploadSessions.Get(c.Param("id"))
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+	output, err := os.CreateTemp(uploadSessions.Dir(), "compression-session-output-*")
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+	defer func() {
+		output.Close()
+		os.Remove(output.Name())
+	}()
+	start := time.Now()
+	var stats *compression.Stats
+	if count > 0 {
+		stats, err = uploadSessions.Complete(c.Request.Context(), s.ID, count, output)
+	} else {
+		stats, err = uploadSessions.Finish(c.Request.Context(), s.ID, output)
+	}
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+	recordJob("compress", stats, start)
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	// the template was checked when the session was created
+	nameTemplate, _ := naming.Parse(cmp.Or(s.NameTemplate, naming.DefaultCompress))
+	filename := nameTemplate.Execute(naming.Fields{
+		Filename:  s.Name,
+		Algorithm: stats.Algorithm,
+		Extension: compression.Extension(stats.Algorithm),
+		Operation: "compress",
+		Time:      time.Now(),
+	})
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("X-Original-Size", strconv.FormatInt(stats.OriginalSize, 10))
+	c.Header("Content-Type", compression.MIMEType(stats.Algorithm))
+	c.Header("Content-Length", strconv.FormatInt(stats.ProcessedSize, 10))
+	c.Header("X-Compression-Stats", statsHeader(stats))
+	c.Status(http.StatusOK)
+	// a client that is gone or too slow for the context ends the response
+	io.Copy(throttledWriter(c, 0), output)
+}
+
+// HandleDeleteSession drops a session and what was uploaded to it
+func HandleDeleteSession(c *gin.Context) {
+	if err := uploadSessions.Delete(c.Param("id")); err != nil {
+		respondSessionError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// respondSessionError sends the errors every session endpoint can run into
+func respondSessionError(c *gin.Context, err error) {
+	var partsErr *session.PartsError
+	switch {
+	case errors.As(err, &partsErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Wrong parts",
+			"code":       http.StatusConflict,
+			"error_code": ErrCodeConflict,
+			"message":    err.Error(),
+			"missing":    partsErr.Missing,
+			"extra":      partsErr.Extra,
+		})
+	case errors.Is(err, session.ErrMixedUpload):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:     "Wrong upload",
+			Code:      http.StatusConflict,
+			ErrorCode: ErrCodeConflict,
+			Message:   "The input of a session is uploaded either in chunks or in parts, not both",
+		})
+	case errors.Is(err, session.ErrNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:     "Session not found",
+			Code:      http.StatusNotFound,
+			ErrorCode: ErrCodeNotFound,
+			Message:   "The session does not exist, was finished or expired",
+		})
+	case errors.Is(err, session.ErrBusy):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:     "Session busy",
+			Code:      http.StatusConflict,
+			ErrorCode: ErrCodeConflict,
+			Message:   "Another request is using the session, send chunks one after the other and finish once every part is in",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:     "Session failed",
+			Code:      http.StatusInternalServerError,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+	}
+}
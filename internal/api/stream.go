@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+)
+
+// streamedTrailers are the stats a streamed response sends after its body,
+// they are only known once the whole output was written
+const streamedTrailers = "X-Compression-Ratio, X-Checksum, X-Compression-Stats"
+
+// checksumAlgorithms are the checksums X-Checksum lists, set in SetupRoutes
+var checksumAlgorithms = []string{checksum.CRC32}
+
+// streamedInput reads the file a compression is streamed from. At the end of
+// the file it reads the rest of the form, so a field that came too late
+// fails the compression before any output was sent.
+type streamedInput struct {
+	form   *upload
+	reader io.Reader
+	done   bool  // the whole form was read
+	err    error // what reading the upload failed with
+}
+
+func newStreamedInput(form *upload, reader io.Reader) *streamedInput {
+	return &streamedInput{form: form, reader: reader}
+}
+
+func (in *streamedInput) Read(p []byte) (int, error) {
+	n, err := in.reader.Read(p)
+	if err == io.EOF {
+		if finishErr := in.form.Finish(); finishErr != nil {
+			err = finishErr
+		} else {
+			in.done = true
+		}
+	}
+	if err != nil && err != io.EOF {
+		in.err = err
+	}
+	return n, err
+}
+
+// formatChecksums writes the checksums the codec computed of the input as
+// X-Checksum has them, e.g. "crc32=102668b3, sha256=9f86d0..."
+func formatChecksums(sums map[string]string) string {
+	parts := make([]string, 0, len(checksumAlgorithms))
+	for _, algorithm := range checksumAlgorithms {
+		parts = append(parts, algorithm+"="+sums[algorithm])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// statsHeader writes the stats as X-Compression-Stats has them, their
+// StatsRecord in JSON on one line
+func statsHeader(stats *compression.Stats) string {
+	encoded, _ := json.Marshal(stats.Record())
+	return string(encoded)
+}
+
+// streamedResponse sends the output of a compression to the client as it is
+// written, with the stats as trailers. Output written before the whole
+// upload was read is held back: until then the upload may still fail, and
+// the client gets an error response instead of a partial download.
+type streamedResponse struct {
+	c       *gin.Context
+	out     io.Writer // where the body goes, c.Writer or a throttled writer
+	input   *streamedInput
+	header  http.Header // sent along with the output
+	held    bytes.Buffer
+	started bool
+}
+
+func newStreamedResponse(c *gin.Context, out io.Writer, input *streamedInput) *streamedResponse {
+	return &streamedResponse{c: c, out: out, input: input, header: make(http.Header)}
+}
+
+func (r *streamedResponse) Write(p []byte) (int, error) {
+	if !r.input.done {
+		return r.held.Write(p)
+	}
+	if err := r.start(); err != nil {
+		return 0, err
+	}
+	return r.out.Write(p)
+}
+
+// start sends the status, the headers and the output held back so far
+func (r *streamedResponse) start() error {
+	if r.started {
+		return nil
+	}
+	r.started = true
+	for key, values := range r.header {
+		r.c.Writer.Header()[key] = values
+	}
+	r.c.Header("Trailer", streamedTrailers)
+	r.c.Status(http.StatusOK)
+	_, err := r.out.Write(r.held.Bytes())
+	r.held = bytes.Buffer{}
+	return err
+}
+
+// finish ends a response whose compression succeeded with the stats as
+// trailers
+func (r *streamedResponse) finish(stats *compression.Stats) error {
+	if err := r.start(); err != nil {
+		return err
+	}
+	r.c.Header("X-Compression-Ratio", strconv.FormatFloat(stats.CompressionRatio, 'f', 2, 64))
+	r.c.Header("X-Checksum", formatChecksums(stats.Hashes))
+	r.c.Header("X-Compression-Stats", statsHeader(stats))
+	return nil
+}
+
+// fail ends a response whose output was partly sent already. The status
+// cannot change anymore, so the error goes out in an undeclared trailer.
+func (r *streamedResponse) fail(err error) {
+	r.c.Header(http.TrailerPrefix+"X-Compression-Error", err.Error())
+}
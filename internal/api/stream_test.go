@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// postStreamed sends req to a server of router, trailers are only sent
+// over a real connection
+func postStreamed(t *testing.T, router *gin.Engine, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	outgoing, err := http.NewRequest(req.Method, server.URL+req.URL.Path, req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outgoing.Header = req.Header
+	resp, err := http.DefaultClient.Do(outgoing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the trailers are there once the body was read to the end
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestStreamedTrailers(t *testing.T) {
+	router := newTestRouter(t, func(cfg *config.Config) { cfg.Checksums = "crc32,sha256" })
+	var input bytes.Buffer
+	for i := 0; input.Len() < 256<<10; i++ {
+		fmt.Fprintf(&input, "line %d of a file streamed through flate\n", i)
+	}
+
+	resp, body := postStreamed(t, router, newFormRequest("/api/v1/compress", field("algorithm", "flate"), file("file", input.Bytes())))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("compress answered %d: %s", resp.StatusCode, body)
+	}
+	decompressed, err := io.ReadAll(flate.NewReader(bytes.NewReader(body)))
+	if err != nil || !bytes.Equal(decompressed, input.Bytes()) {
+		t.Fatalf("the body does not inflate to the input: %v", err)
+	}
+
+	// the checksums are of the bytes sent, not of the output
+	want := fmt.Sprintf("crc32=%08x, sha256=%x", checksum.SumCRC32(input.Bytes()), sha256.Sum256(input.Bytes()))
+	if got := resp.Trailer.Get("X-Checksum"); got != want {
+		t.Errorf("X-Checksum = %q, want %q", got, want)
+	}
+	var stats compression.StatsRecord
+	if err := json.Unmarshal([]byte(resp.Trailer.Get("X-Compression-Stats")), &stats); err != nil {
+		t.Fatalf("X-Compression-Stats = %q: %v", resp.Trailer.Get("X-Compression-Stats"), err)
+	}
+	if stats.Algorithm != "flate" || stats.OriginalSize != int64(input.Len()) || stats.ProcessedSize != int64(len(body)) {
+		t.Errorf("X-Compression-Stats = %+v, want %d bytes of flate compressed to %d", stats, input.Len(), len(body))
+	}
+	if ratio := resp.Trailer.Get("X-Compression-Ratio"); ratio != strconv.FormatFloat(stats.CompressionRatio, 'f', 2, 64) {
+		t.Errorf("X-Compression-Ratio = %q, stats have %v", ratio, stats.CompressionRatio)
+	}
+	if failure := resp.Trailer.Get("X-Compression-Error"); failure != "" {
+		t.Errorf("X-Compression-Error = %q after a compression that succeeded", failure)
+	}
+}
+
+// TestStreamedError fails a response part way through its body, as a codec
+// that breaks after its output started does. The status has gone out as
+// 200, so the error has to come in a trailer.
+func TestStreamedError(t *testing.T) {
+	router := newTestRouter(t, nil)
+	failure := errors.New("codec failed after its output started")
+	router.POST("/test/stream-error", func(c *gin.Context) {
+		form, err := newUpload(c, maxFileSize)
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		part, err := form.NextFile()
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		streamed := newStreamedInput(form, part)
+		response := newStreamedResponse(c, c.Writer, streamed)
+		if _, err := io.Copy(response, streamed); err != nil {
+			response.fail(err)
+			return
+		}
+		response.Write([]byte(" and then some"))
+		response.fail(failure)
+	})
+
+	resp, body := postStreamed(t, router, newFormRequest("/test/stream-error", file("file", []byte("the start of the output"))))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("answered %d: %s", resp.StatusCode, body)
+	}
+	if string(body) != "the start of the output and then some" {
+		t.Errorf("body = %q, want the output written before the failure", body)
+	}
+	if got := resp.Trailer.Get("X-Compression-Error"); got != failure.Error() {
+		t.Errorf("X-Compression-Error = %q, want %q", got, failure)
+	}
+	for _, trailer := range []string{"X-Compression-Ratio", "X-Checksum", "X-Compression-Stats"} {
+		if value := resp.Trailer.Get(trailer); value != "" {
+			t.Errorf("%s = %q after a failed compression", trailer, value)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+// serverLimiter is shared by every throttled response, nil when the server
+// has no overall limit. Set in SetupRoutes.
+var serverLimiter *throttle.Limiter
+
+// requestRate is the most bytes per second a single response is sent at, 0
+// for no limit
+var requestRate int64
+
+// responseRate returns the rate a response is sent at when the client asked
+// for rate bytes per second, clients may only go below requestRate
+func responseRate(rate int64) int64 {
+	if rate > 0 && (requestRate == 0 || rate < requestRate) {
+		return rate
+	}
+	return requestRate
+}
+
+// sendIdleTimeout is how long a client may stall a throttled response. Every
+// write pushes the deadline back, so a response sent slowly on purpose is
+// not cut off by the server's write timeout while the client keeps reading.
+const sendIdleTimeout = 30 * time.Second
+
+// throttledWriter returns the writer the body of c goes out through, limited
+// to the server's overall rate and to the rate of this response
+func throttledWriter(c *gin.Context, rate int64) io.Writer {
+	w := &deadlineWriter{w: c.Writer, response: http.NewResponseController(c.Writer)}
+	return throttle.NewWriter(c.Request.Context(), w, serverLimiter, throttle.NewLimiter(responseRate(rate)))
+}
+
+// deadlineWriter pushes the write deadline back before every write, the
+// throttle writes a chunk at a time
+type deadlineWriter struct {
+	w        io.Writer
+	response *http.ResponseController
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	// not every ResponseWriter supports deadlines, e.g. in tests
+	w.response.SetWriteDeadline(time.Now().Add(sendIdleTimeout))
+	return w.w.Write(p)
+}
+
+// sendThrottled sends data like c.Data does, written through throttledWriter
+func sendThrottled(c *gin.Context, rate int64, contentType string, data []byte) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Status(http.StatusOK)
+	// a client that is gone or too slow for the context ends the response
+	throttledWriter(c, rate).Write(data)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// StreamRequest is the query GET /api/v1/stream is opened with
+type StreamRequest struct {
+	Mode      string `form:"mode"`      // compress, the default, or decompress
+	Algorithm string `form:"algorithm"` // auto only compresses, a stream is decoded as the algorithm says
+	BType     *int   `form:"btype"`
+	BlockSize int    `form:"block_size"`
+	Priority  string `form:"priority"`
+
+	// DictionaryID names a dictionary uploaded to /api/v1/dictionaries,
+	// for both directions
+	DictionaryID string `form:"dictionary_id"`
+}
+
+// StreamResult is the text message that ends a stream which succeeded, one
+// that failed ends on an ErrorResponse
+type StreamResult struct {
+	Stats compression.StatsRecord `json:"stats"`
+}
+
+// endOfInput is the text message a client ends the input of a stream with
+const endOfInput = "end"
+
+// streamIdleTimeout is how long a stream may wait for the next message,
+// longer than an upload may stall since a client feeds it as it goes
+const streamIdleTimeout = 5 * time.Minute
+
+// HandleStream compresses or decompresses over a WebSocket. Every binary
+// message the client sends is a chunk of the input, handed to the algorithm
+// as it arrives, and every chunk of output goes back as a binary message as
+// soon as the algorithm produces it. The client ends the input with the
+// text message "end", the server answers the rest of the output and a
+// StreamResult, or an ErrorResponse once something failed, and closes the
+// connection. The query is checked before the upgrade, so a bad one is
+// answered with a plain HTTP error.
+func HandleStream(c *gin.Context) {
+	var req StreamRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "compress"
+	}
+	if req.Mode != "compress" && req.Mode != "decompress" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   "mode must be compress or decompress",
+		})
+		return
+	}
+	compress := req.Mode == "compress"
+	if req.Algorithm == "" && compress {
+		req.Algorithm = defaultAlgorithm
+	}
+	if !compression.IsValidAlgorithm(req.Algorithm) && !(compress && req.Algorithm == compression.AutoAlgorithm) {
+		message := fmt.Sprintf("Supported algorithms: %v or %s", compression.GetSupportedAlgorithms(), compression.AutoAlgorithm)
+		if !compress {
+			message = fmt.Sprintf("Supported algorithms: %v, a stream is not detected", compression.GetSupportedAlgorithms())
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid algorithm",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeAlgoUnsupported,
+			Message:   message,
+		})
+		return
+	}
+	if !checkAlgorithmEnabled(c, req.Algorithm) {
+		return
+	}
+	options := compression.Options{
+		Algorithm:      req.Algorithm,
+		Policy:         compressionPolicy,
+		MaxDecodedSize: maxDecodedSize,
+		Hashes:         checksumAlgorithms,
+	}
+	if req.BType != nil {
+		options.BType = uint32(*req.BType)
+	}
+	var ok bool
+	if options.BlockSize, ok = parseBlockSize(c, req.BlockSize); !ok {
+		return
+	}
+	if options.Dictionary, ok = lookupDictionary(c, req.DictionaryID, req.Algorithm); !ok {
+		return
+	}
+	release, ok := acquireWorker(c, req.Priority)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx := c.Request.Context()
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		// the server's timeouts are for requests, a stream lasts as long as
+		// its client keeps sending
+		ws.SetWriteDeadline(time.Time{})
+		start := time.Now()
+		input := &messageReader{ws: ws, limit: maxFileSize}
+		var stats *compression.Stats
+		var err error
+		if compress {
+			stats, err = compression.CompressStream(ctx, ws, input, options)
+		} else {
+			stats, err = compression.DecompressStream(ctx, ws, input, options)
+		}
+		if err == nil {
+			recordJob(req.Mode, stats, start)
+			sendText(ws, StreamResult{Stats: stats.Record()})
+			return
+		}
+		code := errorCodeOf(err, ErrCodeInternal)
+		if input.err != nil {
+			// the input broke off, the answer only reaches a client that
+			// sent something else than a chunk or went past the limit
+			err = input.err
+			code = errorCodeOf(err, ErrCodeUploadFailed)
+		}
+		sendText(ws, ErrorResponse{
+			Error:     "Stream failed",
+			Code:      statusOf(code),
+			ErrorCode: code,
+			Message:   err.Error(),
+		})
+	}}
+	// the client is authenticated by the API key, browsers cannot be held
+	// to an origin they send for every page
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// messageReader reads the binary messages of a WebSocket as one stream,
+// which the text message "end" ends. More than limit bytes fail with an
+// *http.MaxBytesError.
+type messageReader struct {
+	ws      *websocket.Conn
+	pending []byte
+	read    int64
+	limit   int64
+	err     error // what broke the input off, before the end was sent
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.ws.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		var f frame
+		if err := messageCodec.Receive(r.ws, &f); err != nil {
+			r.err = fmt.Errorf("the stream closed before its input was ended: %w", err)
+			continue
+		}
+		if !f.binary {
+			if string(f.data) == endOfInput {
+				return 0, io.EOF
+			}
+			r.err = fmt.Errorf("unknown message %q, the input is ended with %q", f.data, endOfInput)
+			continue
+		}
+		if r.read += int64(len(f.data)); r.read > r.limit {
+			r.err = &http.MaxBytesError{Limit: r.limit}
+			continue
+		}
+		r.pending = f.data
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// frame is a message messageCodec received and whether it was binary
+type frame struct {
+	data   []byte
+	binary bool
+}
+
+// messageCodec receives messages with their frame type, which
+// websocket.Message leaves out
+var messageCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		return nil, 0, errors.New("messageCodec only receives")
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		*v.(*frame) = frame{data: data, binary: payloadType == websocket.BinaryFrame}
+		return nil
+	},
+}
+
+// sendText sends v in JSON as a text message, the binary messages of the
+// output use the connection as an io.Writer
+func sendText(ws *websocket.Conn, v any) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(ws, string(encoded))
+}
+
+// statusOf returns the HTTP status code usually comes with, for the code
+// field of errors that are not sent as an HTTP response
+func statusOf(code ErrorCode) int {
+	for _, info := range errorCatalog {
+		if info.Code == code {
+			return info.Status
+		}
+	}
+	return http.StatusInternalServerError
+}
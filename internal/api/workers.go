@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/scheduler"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"github.com/gin-gonic/gin"
+)
+
+// workerPool bounds the compressions and decompressions running at once,
+// nil when WORKERS is not set. Set in SetupRoutes.
+var workerPool *scheduler.Pool
+
+// acquireWorker waits for a worker for the job of c at the priority the
+// request gave, "interactive" or "batch", and returns the function that
+// gives it back. When it returns false the response has been sent.
+func acquireWorker(c *gin.Context, priority string) (func(), bool) {
+	p, err := scheduler.ParsePriority(priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:     "Invalid request",
+			Code:      http.StatusBadRequest,
+			ErrorCode: ErrCodeInvalidRequest,
+			Message:   err.Error(),
+		})
+		return nil, false
+	}
+	start := time.Now()
+	release, err := workerPool.Acquire(c.Request.Context(), p)
+	if err != nil {
+		// the client is gone, or its deadline passed while it waited
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "No worker available",
+			Code:      http.StatusServiceUnavailable,
+			ErrorCode: ErrCodeInternal,
+			Message:   err.Error(),
+		})
+		return nil, false
+	}
+	if workerPool != nil {
+		telemetry.RecordQueueWait(c.Request.Context(), p.String(), time.Since(start))
+	}
+	return release, true
+}
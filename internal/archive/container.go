@@ -0,0 +1,577 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+)
+
+// The container keeps several files, each compressed with its own algorithm:
+//
+//	header   "CFDC" and a version byte
+//	data     the compressed entries, one after the other
+//	index    uvarint number of entries, then for each of them
+//	           uvarint length and the name
+//	           uvarint length and the algorithm
+//	           uvarint original size, varint modification time in unix seconds
+//	           uint32 CRC-32 of the original content
+//	           uvarint offset and uvarint length of the compressed data
+//	           from version 2 on, uvarint block size, 0 for an entry that is
+//	           not split, else for each block its uvarint compressed length
+//	           and the uint32 CRC-32 of its original content
+//	trailer  uint64 offset of the index, uint32 CRC-32 of the index, "CFDI"
+//
+// Fixed width integers are little-endian. The index comes last so entries are
+// written as they are added, and a reader finds any entry from the trailer
+// without decoding the ones before it. Appending to a container writes the new
+// entries and a new index after the old trailer, so the data before it is
+// never rewritten; replaced entries and the old index are left as unused
+// bytes that no index points to.
+//
+// An entry split into blocks has every block of it compressed on its own, one
+// after the other, so a damaged block can be told apart from the rest and
+// skipped. Containers are written as version 1 unless they have blocks.
+
+// ContainerStore is the algorithm name of entries that are kept uncompressed
+const ContainerStore = "store"
+
+// ContainerExtension is the file extension used for containers
+const ContainerExtension = "cfc"
+
+const (
+	containerMagic         = "CFDC"
+	containerTrailerMagic  = "CFDI"
+	containerVersion       = 1
+	containerBlockVersion  = 2 // the first version with blocks
+	containerHeaderLength  = len(containerMagic) + 1
+	containerTrailerLength = 8 + 4 + len(containerTrailerMagic)
+)
+
+// Errors returned by the container reader and writer
+var (
+	ErrNotContainer       = errors.New("container: not a container")
+	ErrUnsupportedVersion = errors.New("container: unsupported version")
+	ErrCorruptContainer   = errors.New("container: corrupt index")
+	ErrChecksumMismatch   = errors.New("container: checksum mismatch")
+	ErrEntryNotFound      = errors.New("container: no such entry")
+	ErrUnknownAlgorithm   = errors.New("container: unknown algorithm")
+	ErrAppendEncrypted    = errors.New("container: cannot append to an encrypted container")
+	ErrBlocksUnsupported  = errors.New("container: version 1 containers have no blocks")
+	ErrDamagedBlock       = errors.New("container: damaged block")
+)
+
+// ContainerEntry describes one file of a container
+type ContainerEntry struct {
+	Name           string    `json:"name"`
+	Algorithm      string    `json:"algorithm"`
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressed_size"`
+	Modified       time.Time `json:"modified"`
+	CRC32          uint32    `json:"crc32"`
+
+	// BlockSize is the size the entry was split into blocks of, the last
+	// block may be shorter. It is 0 for an entry that is a single block.
+	BlockSize int64            `json:"block_size,omitempty"`
+	Blocks    []ContainerBlock `json:"blocks,omitempty"`
+
+	offset int64
+}
+
+// ContainerBlock is one block of an entry that is split into blocks
+type ContainerBlock struct {
+	CompressedSize int64  `json:"compressed_size"`
+	CRC32          uint32 `json:"crc32"`
+}
+
+// DamagedBlock is a block SalvageFile left out, Offset and Size say which
+// bytes of the original file it held
+type DamagedBlock struct {
+	Index  int   `json:"index"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+	Err    error `json:"-"`
+}
+
+// ContainerWriter writes a container, entries go out as they are added and
+// the index is written by Close
+type ContainerWriter struct {
+	w       io.Writer
+	offset  int64
+	entries []ContainerEntry
+	names   map[string]bool
+	started bool
+	closed  bool
+	replace bool // adding an existing name replaces the entry, set when appending
+	version byte // the version of the container appended to, else set by writeHeader
+
+	// BlockSize splits every entry added from then on into blocks of this
+	// many bytes, each compressed on its own and with a CRC-32 of its own.
+	// 0 keeps every entry in one piece.
+	BlockSize int
+}
+
+// NewContainerWriter returns a ContainerWriter that writes to w
+func NewContainerWriter(w io.Writer) *ContainerWriter {
+	return &ContainerWriter{w: w, names: make(map[string]bool)}
+}
+
+// AddFile compresses data with algorithm, one of the supported algorithms or
+// ContainerStore, and writes it as the entry name. Names follow the same
+// rules as zip entries.
+func (cw *ContainerWriter) AddFile(ctx context.Context, name string, modified time.Time, algorithm string, data []byte) error {
+	if cw.closed {
+		return ErrWriterClosed
+	}
+	if !validEntryName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if cw.names[name] && !cw.replace {
+		return fmt.Errorf("%w: %q", ErrDuplicateName, name)
+	}
+	if algorithm != ContainerStore && !compression.IsValidAlgorithm(algorithm) {
+		return fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	if cw.BlockSize > 0 && cw.version < containerBlockVersion {
+		return ErrBlocksUnsupported
+	}
+
+	entry := ContainerEntry{
+		Name:      name,
+		Algorithm: algorithm,
+		Size:      int64(len(data)),
+		Modified:  modified.Truncate(time.Second),
+		offset:    cw.offset,
+	}
+	var body []byte
+	if cw.BlockSize > 0 {
+		// the checksum of the file is joined from those of its blocks
+		// rather than taken over the data a second time
+		entry.BlockSize = int64(cw.BlockSize)
+		for start := 0; start < len(data); start += cw.BlockSize {
+			block := data[start:min(start+cw.BlockSize, len(data))]
+			compressed, err := compressEntryBody(ctx, algorithm, block)
+			if err != nil {
+				return fmt.Errorf("container: failed to compress %s: %w", name, err)
+			}
+			blockCRC := checksum.SumCRC32(block)
+			entry.CRC32 = checksum.CombineCRC32(entry.CRC32, blockCRC, int64(len(block)))
+			entry.Blocks = append(entry.Blocks, ContainerBlock{CompressedSize: int64(len(compressed)), CRC32: blockCRC})
+			body = append(body, compressed...)
+		}
+	} else {
+		entry.CRC32 = checksum.SumCRC32(data)
+		compressed, err := compressEntryBody(ctx, algorithm, data)
+		if err != nil {
+			return fmt.Errorf("container: failed to compress %s: %w", name, err)
+		}
+		body = compressed
+	}
+	entry.CompressedSize = int64(len(body))
+	if err := cw.write(body); err != nil {
+		return err
+	}
+	if cw.names[name] {
+		cw.entries = slices.DeleteFunc(cw.entries, func(old ContainerEntry) bool { return old.Name == name })
+	}
+	cw.entries = append(cw.entries, entry)
+	cw.names[name] = true
+	return nil
+}
+
+// compressEntryBody compresses data, or the whole of an entry, the way it is
+// kept in the container
+func compressEntryBody(ctx context.Context, algorithm string, data []byte) ([]byte, error) {
+	if algorithm == ContainerStore {
+		return data, nil
+	}
+	compressed, _, err := compression.CompressContext(ctx, data, compression.Options{Algorithm: algorithm})
+	return compressed, err
+}
+
+// AppendToContainer returns a ContainerWriter that adds entries to the
+// container in file, which has to be open for reading and writing. Entries
+// with the name of an existing one replace it, the others are kept as they
+// are. Nothing before the end of the file is changed, if appending fails
+// half way, truncating the file to its old size restores the container.
+func AppendToContainer(file *os.File) (*ContainerWriter, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries, _, version, err := readContainerIndex(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	cw := NewContainerWriter(file)
+	cw.offset, cw.started, cw.replace, cw.version = info.Size(), true, true, version
+	cw.entries = entries
+	for _, entry := range entries {
+		cw.names[entry.Name] = true
+	}
+	return cw, nil
+}
+
+// Close writes the index and the trailer. It does not close the underlying
+// writer.
+func (cw *ContainerWriter) Close() error {
+	if cw.closed {
+		return ErrWriterClosed
+	}
+	cw.closed = true
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	index := binary.AppendUvarint(nil, uint64(len(cw.entries)))
+	for _, entry := range cw.entries {
+		index = binary.AppendUvarint(index, uint64(len(entry.Name)))
+		index = append(index, entry.Name...)
+		index = binary.AppendUvarint(index, uint64(len(entry.Algorithm)))
+		index = append(index, entry.Algorithm...)
+		index = binary.AppendUvarint(index, uint64(entry.Size))
+		index = binary.AppendVarint(index, entry.Modified.Unix())
+		index = binary.LittleEndian.AppendUint32(index, entry.CRC32)
+		index = binary.AppendUvarint(index, uint64(entry.offset))
+		index = binary.AppendUvarint(index, uint64(entry.CompressedSize))
+		if cw.version >= containerBlockVersion {
+			index = binary.AppendUvarint(index, uint64(entry.BlockSize))
+			for _, block := range entry.Blocks {
+				index = binary.AppendUvarint(index, uint64(block.CompressedSize))
+				index = binary.LittleEndian.AppendUint32(index, block.CRC32)
+			}
+		}
+	}
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(cw.offset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, checksum.SumCRC32(index))
+	trailer = append(trailer, containerTrailerMagic...)
+	if err := cw.write(index); err != nil {
+		return err
+	}
+	return cw.write(trailer)
+}
+
+func (cw *ContainerWriter) writeHeader() error {
+	if cw.started {
+		return nil
+	}
+	cw.started = true
+	cw.version = containerVersion
+	if cw.BlockSize > 0 {
+		cw.version = containerBlockVersion
+	}
+	return cw.write(append([]byte(containerMagic), cw.version))
+}
+
+func (cw *ContainerWriter) write(data []byte) error {
+	n, err := cw.w.Write(data)
+	cw.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("container: failed to write: %w", err)
+	}
+	return nil
+}
+
+// ContainerReader gives access to the entries of a container held in memory
+type ContainerReader struct {
+	data    []byte
+	entries []ContainerEntry
+}
+
+// OpenContainer reads the index of the container in data. Encrypted
+// containers need OpenEncryptedContainer.
+func OpenContainer(data []byte) (*ContainerReader, error) {
+	if encryption.IsEncrypted(data) {
+		return nil, encryption.ErrPasswordRequired
+	}
+	entries, _, _, err := readContainerIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerReader{data: data, entries: entries}, nil
+}
+
+// readContainerIndex checks the header and trailer of the container of the
+// given size in r and returns its entries, the offset of its index and its
+// version
+func readContainerIndex(r io.ReaderAt, size int64) ([]ContainerEntry, int64, byte, error) {
+	header := make([]byte, containerHeaderLength)
+	if size < int64(containerHeaderLength+containerTrailerLength) {
+		return nil, 0, 0, ErrNotContainer
+	}
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, 0, 0, err
+	}
+	if encryption.IsEncrypted(header) {
+		return nil, 0, 0, ErrAppendEncrypted
+	}
+	if string(header[:len(containerMagic)]) != containerMagic {
+		return nil, 0, 0, ErrNotContainer
+	}
+	version := header[len(containerMagic)]
+	if version != containerVersion && version != containerBlockVersion {
+		return nil, 0, 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	trailer := make([]byte, containerTrailerLength)
+	if _, err := r.ReadAt(trailer, size-int64(containerTrailerLength)); err != nil {
+		return nil, 0, 0, err
+	}
+	if string(trailer[12:]) != containerTrailerMagic {
+		return nil, 0, 0, fmt.Errorf("%w: trailer is missing, the container may be truncated", ErrCorruptContainer)
+	}
+	indexOffset := binary.LittleEndian.Uint64(trailer)
+	indexEnd := uint64(size) - uint64(containerTrailerLength)
+	if indexOffset < uint64(containerHeaderLength) || indexOffset > indexEnd {
+		return nil, 0, 0, fmt.Errorf("%w: index offset is out of range", ErrCorruptContainer)
+	}
+	index := make([]byte, indexEnd-indexOffset)
+	if _, err := r.ReadAt(index, int64(indexOffset)); err != nil {
+		return nil, 0, 0, err
+	}
+	if checksum.SumCRC32(index) != binary.LittleEndian.Uint32(trailer[8:]) {
+		return nil, 0, 0, fmt.Errorf("%w: index", ErrChecksumMismatch)
+	}
+
+	entries, err := parseContainerIndex(index, int64(indexOffset), version)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return entries, int64(indexOffset), version, nil
+}
+
+// OpenEncryptedContainer decrypts a container sealed with encryption.Seal
+// and reads its index. Unencrypted containers are opened as they are, so
+// callers can pass any password they were given.
+func OpenEncryptedContainer(data []byte, password string) (*ContainerReader, error) {
+	if !encryption.IsEncrypted(data) {
+		return OpenContainer(data)
+	}
+	decrypted, err := encryption.Open(password, data)
+	if err != nil {
+		return nil, err
+	}
+	return OpenContainer(decrypted)
+}
+
+// parseContainerIndex decodes the index of a container of the given version,
+// every entry has to point into the data section that ends at dataEnd
+func parseContainerIndex(index []byte, dataEnd int64, version byte) ([]ContainerEntry, error) {
+	corrupt := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrCorruptContainer, reason)
+	}
+	readUvarint := func() (uint64, bool) {
+		value, n := binary.Uvarint(index)
+		if n <= 0 {
+			return 0, false
+		}
+		index = index[n:]
+		return value, true
+	}
+	readString := func() (string, bool) {
+		length, ok := readUvarint()
+		if !ok || length > uint64(len(index)) {
+			return "", false
+		}
+		value := string(index[:length])
+		index = index[length:]
+		return value, true
+	}
+
+	count, ok := readUvarint()
+	// every entry takes at least 8 bytes of index
+	if !ok || count > uint64(len(index))/8 {
+		return nil, corrupt("invalid entry count")
+	}
+	entries := make([]ContainerEntry, 0, count)
+	names := make(map[string]bool, count)
+	for range count {
+		var entry ContainerEntry
+		var size, offset, compressedSize uint64
+		var modified int64
+		var ok bool
+		if entry.Name, ok = readString(); !ok || !validEntryName(entry.Name) || names[entry.Name] {
+			return nil, corrupt("invalid entry name")
+		}
+		if entry.Algorithm, ok = readString(); !ok {
+			return nil, corrupt("invalid algorithm of " + entry.Name)
+		}
+		if size, ok = readUvarint(); !ok || size > 1<<62 {
+			return nil, corrupt("invalid size of " + entry.Name)
+		}
+		var n int
+		if modified, n = binary.Varint(index); n <= 0 {
+			return nil, corrupt("invalid modification time of " + entry.Name)
+		}
+		index = index[n:]
+		if len(index) < 4 {
+			return nil, corrupt("invalid checksum of " + entry.Name)
+		}
+		entry.CRC32 = binary.LittleEndian.Uint32(index)
+		index = index[4:]
+		offset, ok1 := readUvarint()
+		compressedSize, ok2 := readUvarint()
+		if !ok1 || !ok2 || offset < uint64(containerHeaderLength) || offset > uint64(dataEnd) || compressedSize > uint64(dataEnd)-offset {
+			return nil, corrupt("data of " + entry.Name + " is out of range")
+		}
+		entry.Size, entry.CompressedSize, entry.offset = int64(size), int64(compressedSize), int64(offset)
+		entry.Modified = time.Unix(modified, 0)
+		if version >= containerBlockVersion {
+			blockSize, ok := readUvarint()
+			if !ok || blockSize > 1<<62 {
+				return nil, corrupt("invalid block size of " + entry.Name)
+			}
+			entry.BlockSize = int64(blockSize)
+			blocks := uint64(0)
+			if blockSize > 0 {
+				blocks = (size + blockSize - 1) / blockSize
+			}
+			// every block takes at least 5 bytes of index
+			if blocks > uint64(len(index))/5 {
+				return nil, corrupt("invalid blocks of " + entry.Name)
+			}
+			total := uint64(0)
+			for range blocks {
+				blockCompressedSize, ok := readUvarint()
+				if !ok || len(index) < 4 || blockCompressedSize > compressedSize-total {
+					return nil, corrupt("invalid blocks of " + entry.Name)
+				}
+				total += blockCompressedSize
+				entry.Blocks = append(entry.Blocks, ContainerBlock{CompressedSize: int64(blockCompressedSize), CRC32: binary.LittleEndian.Uint32(index)})
+				index = index[4:]
+			}
+			if total != compressedSize {
+				return nil, corrupt("blocks of " + entry.Name + " do not add up to its data")
+			}
+		}
+		names[entry.Name] = true
+		entries = append(entries, entry)
+	}
+	if len(index) != 0 {
+		return nil, corrupt("trailing bytes after the last entry")
+	}
+	return entries, nil
+}
+
+// Entries returns the entries in the order they were written
+func (cr *ContainerReader) Entries() []ContainerEntry {
+	return append([]ContainerEntry{}, cr.entries...)
+}
+
+// ReadFile decompresses the entry name and checks it against its size and
+// checksum
+func (cr *ContainerReader) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	for _, entry := range cr.entries {
+		if entry.Name == name {
+			return cr.readEntry(ctx, entry)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+}
+
+// SalvageFile reads the entry name like ReadFile, but leaves out the blocks
+// that fail to decompress or do not match their checksum instead of failing.
+// An entry that is not split into blocks is a single block. The blocks left
+// out are returned in damaged.
+func (cr *ContainerReader) SalvageFile(ctx context.Context, name string) (data []byte, damaged []DamagedBlock, err error) {
+	for _, entry := range cr.entries {
+		if entry.Name != name {
+			continue
+		}
+		if !validEntryAlgorithm(entry.Algorithm) {
+			return nil, nil, fmt.Errorf("%w: %s uses %s", ErrUnknownAlgorithm, entry.Name, entry.Algorithm)
+		}
+		if entry.BlockSize == 0 {
+			data, err := cr.readEntry(ctx, entry)
+			if err != nil {
+				return []byte{}, []DamagedBlock{{Index: 0, Offset: 0, Size: entry.Size, Err: err}}, nil
+			}
+			return data, nil, nil
+		}
+		data, damaged := cr.readBlocks(ctx, entry, true)
+		if len(damaged) == 0 && checksum.SumCRC32(data) != entry.CRC32 {
+			return nil, nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, entry.Name)
+		}
+		return data, damaged, nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrEntryNotFound, name)
+}
+
+func (cr *ContainerReader) readEntry(ctx context.Context, entry ContainerEntry) ([]byte, error) {
+	if !validEntryAlgorithm(entry.Algorithm) {
+		return nil, fmt.Errorf("%w: %s uses %s", ErrUnknownAlgorithm, entry.Name, entry.Algorithm)
+	}
+	var data []byte
+	if entry.BlockSize > 0 {
+		var damaged []DamagedBlock
+		if data, damaged = cr.readBlocks(ctx, entry, false); len(damaged) > 0 {
+			return nil, damaged[0].Err
+		}
+	} else {
+		body := cr.data[entry.offset : entry.offset+entry.CompressedSize]
+		decompressed, err := decompressEntryBody(ctx, entry.Algorithm, body)
+		if err != nil {
+			return nil, fmt.Errorf("container: failed to decompress %s: %w", entry.Name, err)
+		}
+		data = decompressed
+	}
+	if int64(len(data)) != entry.Size || checksum.SumCRC32(data) != entry.CRC32 {
+		return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, entry.Name)
+	}
+	return data, nil
+}
+
+// readBlocks decompresses the blocks of entry one after the other and checks
+// each against its checksum. A damaged block ends the read, unless skip is
+// set: then it is left out of data and the read goes on with the next one.
+func (cr *ContainerReader) readBlocks(ctx context.Context, entry ContainerEntry, skip bool) (data []byte, damaged []DamagedBlock) {
+	data = make([]byte, 0, entry.Size)
+	offset := entry.offset
+	for i, block := range entry.Blocks {
+		body := cr.data[offset : offset+block.CompressedSize]
+		offset += block.CompressedSize
+		start := int64(i) * entry.BlockSize
+		size := min(entry.BlockSize, entry.Size-start)
+		decompressed, err := decompressEntryBody(ctx, entry.Algorithm, body)
+		if err == nil && (int64(len(decompressed)) != size || checksum.SumCRC32(decompressed) != block.CRC32) {
+			err = ErrChecksumMismatch
+		}
+		if err != nil {
+			err = fmt.Errorf("%w %d of %s, bytes %d to %d: %w", ErrDamagedBlock, i, entry.Name, start, start+size, err)
+			damaged = append(damaged, DamagedBlock{Index: i, Offset: start, Size: size, Err: err})
+			if !skip {
+				return nil, damaged
+			}
+			continue
+		}
+		data = append(data, decompressed...)
+	}
+	return data, damaged
+}
+
+// decompressEntryBody reverses compressEntryBody
+func decompressEntryBody(ctx context.Context, algorithm string, body []byte) ([]byte, error) {
+	if algorithm == ContainerStore {
+		return bytes.Clone(body), nil
+	}
+	decompressed, _, err := compression.DecompressContext(ctx, body, compression.Options{Algorithm: algorithm})
+	return decompressed, err
+}
+
+func validEntryAlgorithm(algorithm string) bool {
+	return algorithm == ContainerStore || compression.IsValidAlgorithm(algorithm)
+}
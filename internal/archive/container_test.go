@@ -0,0 +1,228 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+)
+
+// TestContainerRoundTrip writes one entry per algorithm and reads them back
+// in a different order than they were written
+func TestContainerRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	modified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	algorithms := []string{"huffman", "lzss", "flate", "gzip", ContainerStore}
+	content := func(algorithm string) []byte {
+		return []byte(strings.Repeat(algorithm+" entry\n", 50))
+	}
+
+	var buf bytes.Buffer
+	cw := NewContainerWriter(&buf)
+	for _, algorithm := range algorithms {
+		if err := cw.AddFile(ctx, "files/"+algorithm, modified, algorithm, content(algorithm)); err != nil {
+			t.Fatalf("AddFile(%s): %v", algorithm, err)
+		}
+	}
+	if err := cw.AddFile(ctx, "x", modified, "zstd", nil); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("AddFile with an unknown algorithm = %v, want ErrUnknownAlgorithm", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	cr, err := OpenContainer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenContainer: %v", err)
+	}
+	entries := cr.Entries()
+	if len(entries) != len(algorithms) {
+		t.Fatalf("container has %d entries, want %d", len(entries), len(algorithms))
+	}
+	for i := len(algorithms) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Algorithm != algorithms[i] || !entry.Modified.Equal(modified) {
+			t.Errorf("entry %s has algorithm %s and time %v", entry.Name, entry.Algorithm, entry.Modified)
+		}
+		data, err := cr.ReadFile(ctx, entry.Name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", entry.Name, err)
+		}
+		if !bytes.Equal(data, content(algorithms[i])) {
+			t.Errorf("ReadFile(%s) does not match what was written", entry.Name)
+		}
+	}
+	if _, err := cr.ReadFile(ctx, "missing"); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("ReadFile(missing) = %v, want ErrEntryNotFound", err)
+	}
+
+	// a flipped bit in stored data is caught by the entry checksum, one in
+	// the index by the index checksum, and a cut off container by the trailer
+	damaged := bytes.Clone(buf.Bytes())
+	damaged[entries[4].offset] ^= 1
+	cr, _ = OpenContainer(damaged)
+	if _, err := cr.ReadFile(ctx, entries[4].Name); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("ReadFile of damaged data = %v, want ErrChecksumMismatch", err)
+	}
+	damaged = bytes.Clone(buf.Bytes())
+	damaged[len(damaged)-containerTrailerLength-1] ^= 1
+	if _, err := OpenContainer(damaged); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("OpenContainer with a damaged index = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := OpenContainer(buf.Bytes()[:buf.Len()-1]); !errors.Is(err, ErrCorruptContainer) {
+		t.Errorf("OpenContainer of a truncated container = %v, want ErrCorruptContainer", err)
+	}
+	if _, err := OpenContainer([]byte("PK\x03\x04 not a container at all")); !errors.Is(err, ErrNotContainer) {
+		t.Errorf("OpenContainer of a zip = %v, want ErrNotContainer", err)
+	}
+}
+
+// TestContainerBlocks damages one block of an entry and checks that the error
+// names it and that salvaging leaves out that block only
+func TestContainerBlocks(t *testing.T) {
+	ctx := context.Background()
+	content := []byte(strings.Repeat("a block of the entry\n", 40))
+	var buf bytes.Buffer
+	cw := NewContainerWriter(&buf)
+	cw.BlockSize = 256
+	if err := cw.AddFile(ctx, "blocks.txt", time.Now(), "lzss", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := OpenContainer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OpenContainer: %v", err)
+	}
+	entry := cr.Entries()[0]
+	if entry.BlockSize != 256 || len(entry.Blocks) != 4 {
+		t.Fatalf("entry has blocks of %d bytes, %d of them, want 4 of 256", entry.BlockSize, len(entry.Blocks))
+	}
+	if data, err := cr.ReadFile(ctx, "blocks.txt"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("ReadFile = %v, does not match what was written", err)
+	}
+
+	damaged := bytes.Clone(buf.Bytes())
+	damaged[entry.offset+entry.Blocks[0].CompressedSize+entry.Blocks[1].CompressedSize/2] ^= 1
+	cr, _ = OpenContainer(damaged)
+	if _, err := cr.ReadFile(ctx, "blocks.txt"); !errors.Is(err, ErrDamagedBlock) || !strings.Contains(err.Error(), "block 1 of") {
+		t.Errorf("ReadFile of a damaged block = %v, want ErrDamagedBlock for block 1", err)
+	}
+	data, blocks, err := cr.SalvageFile(ctx, "blocks.txt")
+	if err != nil {
+		t.Fatalf("SalvageFile: %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].Index != 1 || blocks[0].Offset != 256 || blocks[0].Size != 256 {
+		t.Errorf("SalvageFile reports damaged blocks %+v, want block 1 only", blocks)
+	}
+	if want := append(bytes.Clone(content[:256]), content[512:]...); !bytes.Equal(data, want) {
+		t.Errorf("SalvageFile returned %d bytes, want the %d outside of block 1", len(data), len(want))
+	}
+
+	// version 1 containers have nowhere to record blocks
+	var v1 bytes.Buffer
+	cw = NewContainerWriter(&v1)
+	if err := cw.AddFile(ctx, "a", time.Now(), ContainerStore, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	cw.BlockSize = 256
+	if err := cw.AddFile(ctx, "b", time.Now(), ContainerStore, []byte("b")); !errors.Is(err, ErrBlocksUnsupported) {
+		t.Errorf("AddFile with blocks to a version 1 container = %v, want ErrBlocksUnsupported", err)
+	}
+}
+
+func TestEncryptedContainer(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	cw := NewContainerWriter(&buf)
+	if err := cw.AddFile(ctx, "secret.txt", time.Now(), "huffman", []byte("top secret, top secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encryption.SealWithParams("password", buf.Bytes(), encryption.Params{Time: 1, Memory: 64, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenContainer(sealed); !errors.Is(err, encryption.ErrPasswordRequired) {
+		t.Errorf("OpenContainer of an encrypted container = %v, want ErrPasswordRequired", err)
+	}
+	if _, err := OpenEncryptedContainer(sealed, "wrong"); !errors.Is(err, encryption.ErrDecryptionFailed) {
+		t.Errorf("OpenEncryptedContainer with a wrong password = %v, want ErrDecryptionFailed", err)
+	}
+	cr, err := OpenEncryptedContainer(sealed, "password")
+	if err != nil {
+		t.Fatalf("OpenEncryptedContainer: %v", err)
+	}
+	if data, err := cr.ReadFile(ctx, "secret.txt"); err != nil || string(data) != "top secret, top secret" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+}
+
+// TestAppendToContainer adds one entry and replaces another in a container
+// file, the bytes written before are left as they were
+func TestAppendToContainer(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "backup.cfc")
+	var buf bytes.Buffer
+	cw := NewContainerWriter(&buf)
+	for _, name := range []string{"kept.txt", "changed.txt"} {
+		if err := cw.AddFile(ctx, name, time.Now(), "lzss", []byte("first version of "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cw, err = AppendToContainer(file)
+	if err != nil {
+		t.Fatalf("AppendToContainer: %v", err)
+	}
+	if err := cw.AddFile(ctx, "changed.txt", time.Now(), "huffman", []byte("second version")); err != nil {
+		t.Fatalf("AddFile replacing an entry: %v", err)
+	}
+	if err := cw.AddFile(ctx, "new.txt", time.Now(), ContainerStore, []byte("new")); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, buf.Bytes()) {
+		t.Fatalf("appending rewrote the existing container")
+	}
+	cr, err := OpenContainer(data)
+	if err != nil {
+		t.Fatalf("OpenContainer: %v", err)
+	}
+	if entries := cr.Entries(); len(entries) != 3 {
+		t.Fatalf("container has %d entries, want 3", len(entries))
+	}
+	for name, want := range map[string]string{"kept.txt": "first version of kept.txt", "changed.txt": "second version", "new.txt": "new"} {
+		if got, err := cr.ReadFile(ctx, name); err != nil || string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+)
+
+// DiffEntry is a file that differs between two archives. The fields of the
+// side the file is missing from are left empty.
+type DiffEntry struct {
+	Name        string `json:"name"`
+	OldSize     int64  `json:"old_size,omitempty"`
+	NewSize     int64  `json:"new_size,omitempty"`
+	SizeDelta   int64  `json:"size_delta"`
+	OldChecksum string `json:"old_crc32,omitempty"`
+	NewChecksum string `json:"new_crc32,omitempty"`
+}
+
+// ArchiveDiff lists how the files of one archive differ from those of
+// another, each list is sorted by name
+type ArchiveDiff struct {
+	Added     []DiffEntry `json:"added"`
+	Removed   []DiffEntry `json:"removed"`
+	Changed   []DiffEntry `json:"changed"`
+	Unchanged int         `json:"unchanged"`
+	SizeDelta int64       `json:"size_delta"` // of the content of all files
+}
+
+// diffFile is what DiffArchives compares a file by
+type diffFile struct {
+	size     int64
+	checksum string
+}
+
+// DiffArchives compares the regular files of two archives in any of the
+// formats NewArchiveFS reads, by path, size and CRC-32 of their content. It
+// is meant for checking what an incremental backup added to the one before.
+func DiffArchives(ctx context.Context, oldData, newData []byte) (*ArchiveDiff, error) {
+	oldFiles, err := diffFiles(ctx, oldData)
+	if err != nil {
+		return nil, fmt.Errorf("old archive: %w", err)
+	}
+	newFiles, err := diffFiles(ctx, newData)
+	if err != nil {
+		return nil, fmt.Errorf("new archive: %w", err)
+	}
+
+	diff := &ArchiveDiff{Added: []DiffEntry{}, Removed: []DiffEntry{}, Changed: []DiffEntry{}}
+	for _, name := range slices.Sorted(maps.Keys(newFiles)) {
+		newFile := newFiles[name]
+		oldFile, ok := oldFiles[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, DiffEntry{Name: name, NewSize: newFile.size, SizeDelta: newFile.size, NewChecksum: newFile.checksum})
+		case oldFile != newFile:
+			diff.Changed = append(diff.Changed, DiffEntry{
+				Name:        name,
+				OldSize:     oldFile.size,
+				NewSize:     newFile.size,
+				SizeDelta:   newFile.size - oldFile.size,
+				OldChecksum: oldFile.checksum,
+				NewChecksum: newFile.checksum,
+			})
+		default:
+			diff.Unchanged++
+		}
+		diff.SizeDelta += newFile.size
+	}
+	for _, name := range slices.Sorted(maps.Keys(oldFiles)) {
+		oldFile := oldFiles[name]
+		if _, ok := newFiles[name]; !ok {
+			diff.Removed = append(diff.Removed, DiffEntry{Name: name, OldSize: oldFile.size, SizeDelta: -oldFile.size, OldChecksum: oldFile.checksum})
+		}
+		diff.SizeDelta -= oldFile.size
+	}
+	return diff, nil
+}
+
+// diffFiles reads every regular file of the archive in data
+func diffFiles(ctx context.Context, data []byte) (map[string]diffFile, error) {
+	fsys, err := NewArchiveFS(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]diffFile)
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		content, err := fsys.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files[name] = diffFile{size: int64(len(content)), checksum: fmt.Sprintf("%08x", checksum.SumCRC32(content))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
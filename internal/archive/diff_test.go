@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDiffArchives(t *testing.T) {
+	ctx := context.Background()
+	modified := time.Date(2024, 5, 17, 13, 45, 30, 0, time.UTC)
+	pack := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		cw := NewContainerWriter(&buf)
+		for name, content := range files {
+			if err := cw.AddFile(ctx, name, modified, "gzip", []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	oldData := pack(map[string]string{"same.txt": "unchanged", "edit.txt": "before", "gone.txt": "removed"})
+	var newData bytes.Buffer
+	zw := NewZipWriter(&newData)
+	for name, content := range map[string]string{"same.txt": "unchanged", "edit.txt": "after it changed", "dir/new.txt": "added"} {
+		if err := zw.AddFile(ctx, name, modified, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a container against a zip archive, formats may differ
+	diff, err := DiffArchives(ctx, oldData, newData.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Name != "dir/new.txt" || diff.Added[0].SizeDelta != 5 || diff.Added[0].NewChecksum == "" {
+		t.Errorf("added %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Name != "gone.txt" || diff.Removed[0].SizeDelta != -7 {
+		t.Errorf("removed %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Name != "edit.txt" || diff.Changed[0].SizeDelta != 10 || diff.Changed[0].OldChecksum == diff.Changed[0].NewChecksum {
+		t.Errorf("changed %+v", diff.Changed)
+	}
+	if diff.Unchanged != 1 || diff.SizeDelta != 5-7+10 {
+		t.Errorf("unchanged %d, size delta %d", diff.Unchanged, diff.SizeDelta)
+	}
+
+	diff, err = DiffArchives(ctx, oldData, oldData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 || diff.Unchanged != 3 {
+		t.Errorf("an archive differs from itself: %+v", diff)
+	}
+
+	if _, err := DiffArchives(ctx, oldData, []byte("not an archive")); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("DiffArchives of garbage returned %v", err)
+	}
+}
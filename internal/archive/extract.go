@@ -0,0 +1,327 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// CollisionPolicy says what happens to an extracted entry whose path is
+// already taken in the destination
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite replaces what is in the way. Directories are merged,
+	// a directory in the way of a file is an error rather than removed.
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionSkip keeps what is there and leaves the entry out
+	CollisionSkip
+	// CollisionRename writes the entry under a free name, "name (1).ext"
+	CollisionRename
+)
+
+var collisionNames = map[string]CollisionPolicy{
+	"overwrite": CollisionOverwrite,
+	"skip":      CollisionSkip,
+	"rename":    CollisionRename,
+}
+
+// ParseCollisionPolicy reads a policy by its name: overwrite, skip or rename
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	policy, ok := collisionNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("collision policy %q is not overwrite, skip or rename", name)
+	}
+	return policy, nil
+}
+
+// ExtractReport tells where the entries of an archive went. Paths are slash
+// separated and relative to the destination. A directory that did not exist
+// before is listed alone, its contents came with it.
+type ExtractReport struct {
+	Extracted []string          `json:"extracted"`
+	Skipped   []string          `json:"skipped,omitempty"`
+	Renamed   map[string]string `json:"renamed,omitempty"` // path in the archive to the path written
+}
+
+// ExtractTarAtomic restores a tar archive below dest like ExtractTar, except
+// that nothing shows up in dest before the whole archive was read: it is
+// restored in a staging directory, its files and directories are synced to
+// disk and then renamed into place. A dest that does not exist yet appears
+// at once with a single rename; an existing one has the entries of the
+// archive moved into it one by one, each of them complete, with collisions
+// settled by options.Collisions. A failure while reading the archive, or a
+// directory in dest that a file of the archive would overwrite, leaves dest
+// as it was. The staging directory, named ".extract-*", is next to dest
+// or in it, so renames never cross file systems, and is removed at the end.
+func ExtractTarAtomic(r io.Reader, dest string, options TarOptions) (*ExtractReport, error) {
+	dest = filepath.Clean(dest)
+	_, err := os.Stat(dest)
+	fresh := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !fresh {
+		return nil, err
+	}
+	stageParent := dest
+	if fresh {
+		stageParent = filepath.Dir(dest)
+		if err := os.MkdirAll(stageParent, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	stage, err := os.MkdirTemp(stageParent, ".extract-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+
+	dirs, err := extractTar(r, stage, options, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncDirs(stage); err != nil {
+		return nil, err
+	}
+
+	if fresh {
+		// MkdirTemp made it private, dest gets what MkdirAll would give it
+		if err := os.Chmod(stage, 0o755); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(stage)
+		if err != nil {
+			return nil, err
+		}
+		report := &ExtractReport{Extracted: []string{}}
+		for _, entry := range entries {
+			report.Extracted = append(report.Extracted, entry.Name())
+		}
+		if err := setDirAttributes(dirs); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(stage, dest); err != nil {
+			return nil, err
+		}
+		return report, syncPath(stageParent)
+	}
+
+	m := &merger{
+		stage:   stage,
+		dest:    dest,
+		policy:  options.Collisions,
+		moved:   make(map[string]string),
+		parents: make(map[string]bool),
+		report:  &ExtractReport{Extracted: []string{}},
+	}
+	if err := m.checkDir(""); err != nil {
+		return nil, err
+	}
+	if err := m.mergeDir(""); err != nil {
+		return nil, err
+	}
+	// only the directories that were moved in get the attributes of the
+	// archive, existing ones keep theirs
+	var moved []dirAttributes
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(stage, dir.path)
+		if err != nil {
+			return nil, err
+		}
+		if final, ok := m.finalPath(filepath.ToSlash(rel)); ok {
+			dir.path = filepath.Join(dest, filepath.FromSlash(final))
+			moved = append(moved, dir)
+		}
+	}
+	if err := setDirAttributes(moved); err != nil {
+		return nil, err
+	}
+	for parent := range m.parents {
+		if err := syncPath(parent); err != nil {
+			return nil, err
+		}
+	}
+	return m.report, nil
+}
+
+// ExtractTarGzAtomic decompresses a .tar.gz with the gzip algorithm and
+// restores it below dest with ExtractTarAtomic
+func ExtractTarGzAtomic(ctx context.Context, data []byte, dest string, options TarOptions) (*ExtractReport, error) {
+	tarData, _, err := compression.DecompressContext(ctx, data, compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		return nil, err
+	}
+	return ExtractTarAtomic(bytes.NewReader(tarData), dest, options)
+}
+
+// merger moves a staged tree into an existing directory
+type merger struct {
+	stage, dest string
+	policy      CollisionPolicy
+	moved       map[string]string // staged path to the path it was moved to
+	parents     map[string]bool   // directories whose entries changed, to be synced
+	report      *ExtractReport
+}
+
+// mergeDir moves the entries of the staged directory rel into dest.
+// Directories on both sides are merged, links in dest are never followed:
+// one in the way of an entry collides with it.
+func (m *merger) mergeDir(rel string) error {
+	entries, err := os.ReadDir(filepath.Join(m.stage, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(rel, entry.Name())
+		target := filepath.Join(m.dest, filepath.FromSlash(name))
+		existing, err := os.Lstat(target)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			err = m.move(name, name)
+		case err != nil:
+		case entry.IsDir() && existing.IsDir():
+			err = m.mergeDir(name)
+		default:
+			err = m.collide(name, entry.IsDir(), existing)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDir walks the staged directory rel like mergeDir and fails on the
+// first collision that collide would fail on, so a merge that cannot be
+// finished fails before anything was moved into dest
+func (m *merger) checkDir(rel string) error {
+	if m.policy != CollisionOverwrite {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(m.stage, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(rel, entry.Name())
+		target := filepath.Join(m.dest, filepath.FromSlash(name))
+		existing, err := os.Lstat(target)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return err
+		case entry.IsDir() && existing.IsDir():
+			if err := m.checkDir(name); err != nil {
+				return err
+			}
+		case existing.IsDir():
+			return fmt.Errorf("tar: %s is a directory", target)
+		}
+	}
+	return nil
+}
+
+// collide settles an entry whose path is taken by existing
+func (m *merger) collide(name string, isDir bool, existing fs.FileInfo) error {
+	target := filepath.Join(m.dest, filepath.FromSlash(name))
+	switch m.policy {
+	case CollisionSkip:
+		m.report.Skipped = append(m.report.Skipped, name)
+		return nil
+	case CollisionRename:
+		free, err := freeName(m.dest, name, isDir)
+		if err != nil {
+			return err
+		}
+		if m.report.Renamed == nil {
+			m.report.Renamed = make(map[string]string)
+		}
+		m.report.Renamed[name] = free
+		return m.move(name, free)
+	}
+	if existing.IsDir() {
+		return fmt.Errorf("tar: %s is a directory", target)
+	}
+	if isDir {
+		// a rename replaces files with files only
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	return m.move(name, name)
+}
+
+// move renames the staged entry name to final in dest
+func (m *merger) move(name, final string) error {
+	target := filepath.Join(m.dest, filepath.FromSlash(final))
+	if err := os.Rename(filepath.Join(m.stage, filepath.FromSlash(name)), target); err != nil {
+		return err
+	}
+	m.moved[name] = final
+	m.parents[filepath.Dir(target)] = true
+	m.report.Extracted = append(m.report.Extracted, final)
+	return nil
+}
+
+// finalPath is where the staged path rel ended up, if it was moved by
+// itself or with a directory above it
+func (m *merger) finalPath(rel string) (string, bool) {
+	for p := rel; ; p = path.Dir(p) {
+		if final, ok := m.moved[p]; ok {
+			return final + rel[len(p):], true
+		}
+		if !strings.Contains(p, "/") {
+			return "", false
+		}
+	}
+}
+
+// freeName finds a name for name in dest that is not taken, adding " (1)",
+// " (2)" and so on before the extension of files
+func freeName(dest, name string, isDir bool) (string, error) {
+	ext := ""
+	if base := path.Base(name); !isDir && path.Ext(base) != base {
+		ext = path.Ext(base)
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		_, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(candidate)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// syncDirs syncs every directory of the tree at root, so the entries in
+// them are on disk before they are renamed elsewhere
+func syncDirs(root string) error {
+	return filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return syncPath(p)
+	})
+}
+
+func syncPath(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestExtractTarAtomic restores an archive into a new directory and into
+// one that has some of its paths taken, once per collision policy
+func TestExtractTarAtomic(t *testing.T) {
+	modified := time.Date(2022, 6, 7, 8, 9, 10, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		header tar.Header
+		data   string
+	}{
+		{tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0o750}, ""},
+		{tar.Header{Name: "docs/a.txt", Typeflag: tar.TypeReg, Mode: 0o640}, "new a\n"},
+		{tar.Header{Name: "docs/b.txt", Typeflag: tar.TypeReg, Mode: 0o600}, "new b\n"},
+		{tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0o755}, ""},
+		{tar.Header{Name: "bin/run", Typeflag: tar.TypeReg, Mode: 0o755}, "#!/bin/sh\n"},
+	}
+	for _, entry := range entries {
+		entry.header.ModTime = modified
+		entry.header.Size = int64(len(entry.data))
+		if err := tw.WriteHeader(&entry.header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(entry.data))
+	}
+	tw.Close()
+	archive := buf.Bytes()
+
+	// a new destination appears with everything in it
+	dest := filepath.Join(t.TempDir(), "new")
+	report, err := ExtractTarAtomic(bytes.NewReader(archive), dest, TarOptions{})
+	if err != nil {
+		t.Fatalf("ExtractTarAtomic: %v", err)
+	}
+	if want := []string{"bin", "docs"}; !reflect.DeepEqual(report.Extracted, want) {
+		t.Errorf("extracted %v, want %v", report.Extracted, want)
+	}
+	for name, mode := range map[string]os.FileMode{"docs": 0o750, "docs/b.txt": 0o600, "bin/run": 0o755} {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil || info.Mode().Perm() != mode || !info.ModTime().Equal(modified) {
+			t.Errorf("%s: restored as %v, want mode %v and time %v", name, info, mode, modified)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), ".extract-*")); len(matches) > 0 {
+		t.Errorf("staging directories left behind: %v", matches)
+	}
+
+	tests := []struct {
+		policy  CollisionPolicy
+		files   map[string]string
+		skipped []string
+		renamed map[string]string
+	}{
+		{CollisionOverwrite, map[string]string{"docs/a.txt": "new a\n", "bin/run": "#!/bin/sh\n"}, nil, nil},
+		{CollisionSkip, map[string]string{"docs/a.txt": "old a\n", "bin": "old bin\n"}, []string{"bin", "docs/a.txt"}, nil},
+		{CollisionRename, map[string]string{"docs/a.txt": "old a\n", "docs/a (1).txt": "new a\n", "bin (1)/run": "#!/bin/sh\n"},
+			nil, map[string]string{"docs/a.txt": "docs/a (1).txt", "bin": "bin (1)"}},
+	}
+	for _, test := range tests {
+		dest := t.TempDir()
+		os.Mkdir(filepath.Join(dest, "docs"), 0o700)
+		os.WriteFile(filepath.Join(dest, "docs", "a.txt"), []byte("old a\n"), 0o644)
+		os.WriteFile(filepath.Join(dest, "bin"), []byte("old bin\n"), 0o644)
+
+		report, err := ExtractTarAtomic(bytes.NewReader(archive), dest, TarOptions{Collisions: test.policy})
+		if err != nil {
+			t.Errorf("policy %d: %v", test.policy, err)
+			continue
+		}
+		for name, want := range test.files {
+			if got, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(got) != want {
+				t.Errorf("policy %d: %s holds %q, want %q", test.policy, name, got, want)
+			}
+		}
+		if got, _ := os.ReadFile(filepath.Join(dest, "docs", "b.txt")); string(got) != "new b\n" {
+			t.Errorf("policy %d: docs/b.txt was not merged into the existing directory", test.policy)
+		}
+		if info, _ := os.Stat(filepath.Join(dest, "docs")); info.Mode().Perm() != 0o700 {
+			t.Errorf("policy %d: the existing directory changed its mode to %v", test.policy, info.Mode().Perm())
+		}
+		if !reflect.DeepEqual(report.Skipped, test.skipped) || !reflect.DeepEqual(report.Renamed, test.renamed) {
+			t.Errorf("policy %d: skipped %v and renamed %v, want %v and %v", test.policy, report.Skipped, report.Renamed, test.skipped, test.renamed)
+		}
+	}
+
+	// an archive cut off in its last header leaves the destination untouched
+	dest = t.TempDir()
+	_, err = ExtractTarAtomic(bytes.NewReader(archive[:len(archive)-1024-512-100]), dest, TarOptions{})
+	if err == nil {
+		t.Fatal("a truncated archive was extracted")
+	}
+	if left, _ := os.ReadDir(dest); len(left) > 0 {
+		t.Errorf("a failed extraction left %v", left)
+	}
+
+	// a file that cannot overwrite a directory fails the merge before the
+	// files next to it were overwritten
+	dest = t.TempDir()
+	os.WriteFile(filepath.Join(dest, "a"), []byte("old a\n"), 0o644)
+	os.Mkdir(filepath.Join(dest, "b"), 0o755)
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	for _, name := range []string{"a", "b"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: 6})
+		tw.Write([]byte("new " + name + "\n"))
+	}
+	tw.Close()
+	if _, err := ExtractTarAtomic(&buf, dest, TarOptions{}); err == nil {
+		t.Error("a file overwrote a directory")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "a")); string(got) != "old a\n" {
+		t.Errorf("a failed merge overwrote a with %q", got)
+	}
+
+	if _, err := ParseCollisionPolicy("keep"); err == nil {
+		t.Error("ParseCollisionPolicy accepted an unknown policy")
+	}
+	if policy, err := ParseCollisionPolicy("Rename"); err != nil || policy != CollisionRename {
+		t.Errorf("ParseCollisionPolicy(Rename) = %v, %v", policy, err)
+	}
+}
@@ -0,0 +1,551 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/encryption"
+)
+
+// Errors returned by NewArchiveFS and CompressFS
+var (
+	ErrUnknownFormat     = errors.New("archive: not a zip, tar, tar.gz or container")
+	ErrCorruptZip        = errors.New("zip: corrupt archive")
+	ErrUnsupportedMethod = errors.New("zip: unsupported compression method")
+	ErrNoMatch           = errors.New("archive: pattern matches no files")
+)
+
+// CompressFS writes the files of fsys matched by patterns to w as a zip
+// archive, see ZipWriter. Patterns use the syntax of path.Match, a pattern
+// that matches a directory takes in everything below it, and no patterns at
+// all take in the whole of fsys. Only regular files are written, in the
+// order of their names.
+func CompressFS(ctx context.Context, w io.Writer, fsys fs.FS, patterns ...string) error {
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%w: %s", ErrNoMatch, pattern)
+		}
+		for _, match := range matches {
+			err := fs.WalkDir(fsys, match, func(name string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					names = append(names, name)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	zw := NewZipWriter(w)
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if err := zw.AddFile(ctx, name, info.ModTime(), data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ArchiveFS is a read-only fs.FS over the files of a zip archive, a tar or
+// tar.gz archive or a container. The index is read when it is created, an
+// entry is only decompressed once it is read. Directories missing from the
+// archive are made up from the paths of the files, links and other special
+// entries are left out.
+type ArchiveFS struct {
+	ctx     context.Context
+	entries map[string]*fsEntry
+}
+
+// fsEntry is a file or directory of an ArchiveFS
+type fsEntry struct {
+	name     string // full path, "." for the root
+	size     int64
+	mode     fs.FileMode
+	modTime  time.Time
+	read     func(ctx context.Context) ([]byte, error) // nil for directories
+	children []*fsEntry                                // sorted by name
+}
+
+// NewArchiveFS reads the index of the archive in data, recognising the
+// format from its first bytes. A tar.gz is decompressed whole, since gzip
+// cannot be read from the middle; zip and container entries are decompressed
+// when they are read, with ctx.
+func NewArchiveFS(ctx context.Context, data []byte) (*ArchiveFS, error) {
+	fsys := &ArchiveFS{
+		ctx:     ctx,
+		entries: map[string]*fsEntry{".": {name: ".", mode: fs.ModeDir | 0o755}},
+	}
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		err = fsys.addZip(data)
+	case bytes.HasPrefix(data, []byte(containerMagic)) || encryption.IsEncrypted(data):
+		err = fsys.addContainer(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		var tarData []byte
+		tarData, _, err = compression.DecompressContext(ctx, data, compression.Options{Algorithm: "gzip"})
+		if err == nil {
+			err = fsys.addTar(tarData)
+		}
+	case isTar(data):
+		err = fsys.addTar(data)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range fsys.entries {
+		slices.SortFunc(entry.children, func(a, b *fsEntry) int { return strings.Compare(a.name, b.name) })
+	}
+	return fsys, nil
+}
+
+// isTar looks for the ustar magic of the first header, or the zero blocks of
+// an empty archive
+func isTar(data []byte) bool {
+	if len(data) < blockSize {
+		return false
+	}
+	return bytes.HasPrefix(data[257:], []byte("ustar")) || isZero(data[:blockSize])
+}
+
+// add puts entry into the tree, making up the directories above it. An
+// explicit directory entry replaces a made up one, a later file replaces an
+// earlier one of the same name.
+func (fsys *ArchiveFS) add(entry *fsEntry) error {
+	if !fs.ValidPath(entry.name) || entry.name == "." {
+		return nil
+	}
+	if existing, ok := fsys.entries[entry.name]; ok {
+		if existing.mode.IsDir() != entry.mode.IsDir() {
+			return fmt.Errorf("archive: %s is both a file and a directory", entry.name)
+		}
+		existing.size, existing.mode, existing.modTime, existing.read = entry.size, entry.mode, entry.modTime, entry.read
+		return nil
+	}
+	parentName := path.Dir(entry.name)
+	parent, ok := fsys.entries[parentName]
+	if !ok {
+		parent = &fsEntry{name: parentName, mode: fs.ModeDir | 0o755}
+		if err := fsys.add(parent); err != nil {
+			return err
+		}
+	} else if !parent.mode.IsDir() {
+		return fmt.Errorf("archive: %s is both a file and a directory", parentName)
+	}
+	parent.children = append(parent.children, entry)
+	fsys.entries[entry.name] = entry
+	return nil
+}
+
+func (fsys *ArchiveFS) addContainer(data []byte) error {
+	cr, err := OpenContainer(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range cr.entries {
+		err := fsys.add(&fsEntry{
+			name:    entry.Name,
+			size:    entry.Size,
+			mode:    0o644,
+			modTime: entry.Modified,
+			read: func(ctx context.Context) ([]byte, error) {
+				return cr.readEntry(ctx, entry)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fsys *ArchiveFS) addTar(data []byte) error {
+	for offset := 0; offset < len(data); {
+		// each archive appended by AppendTarGz starts after the zero blocks
+		// that end the one before
+		if offset+blockSize <= len(data) && isZero(data[offset:offset+blockSize]) {
+			offset += blockSize
+			continue
+		}
+		r := bytes.NewReader(data[offset:])
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("tar: failed to read archive: %w", err)
+			}
+			name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+			mode := fs.FileMode(header.Mode) & permMask
+			switch header.Typeflag {
+			case tar.TypeDir:
+				err = fsys.add(&fsEntry{name: name, mode: fs.ModeDir | mode, modTime: header.ModTime})
+			case tar.TypeReg:
+				// the content follows the header, which tar.Reader has just read
+				start := offset + len(data[offset:]) - r.Len()
+				if header.Size > int64(len(data)-start) {
+					return fmt.Errorf("tar: %s runs past the end of the archive", header.Name)
+				}
+				content := data[start : start+int(header.Size)]
+				err = fsys.add(&fsEntry{
+					name:    name,
+					size:    header.Size,
+					mode:    mode,
+					modTime: header.ModTime,
+					read: func(context.Context) ([]byte, error) {
+						return bytes.Clone(content), nil
+					},
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		read := len(data[offset:]) - r.Len()
+		if read == 0 {
+			return fmt.Errorf("tar: failed to read archive: %d stray bytes at the end", len(data)-offset)
+		}
+		offset += read
+	}
+	return nil
+}
+
+func (fsys *ArchiveFS) addZip(data []byte) error {
+	corrupt := func(reason string) error {
+		return fmt.Errorf("%w: %s", ErrCorruptZip, reason)
+	}
+	// the end of central directory record is last, followed by a comment of
+	// at most 65535 bytes
+	end := -1
+	for i := len(data) - zipEndOfDirectoryLength; i >= 0 && i >= len(data)-zipEndOfDirectoryLength-math.MaxUint16; i-- {
+		if binary.LittleEndian.Uint32(data[i:]) == zipEndOfDirectorySignature {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return corrupt("no end of central directory, the archive may be truncated")
+	}
+	count := int(binary.LittleEndian.Uint16(data[end+10:]))
+	offset := int64(binary.LittleEndian.Uint32(data[end+16:]))
+	if count == math.MaxUint16 || offset == math.MaxUint32 {
+		return ErrTooLarge
+	}
+
+	for i := 0; i < count; i++ {
+		if offset+zipCentralHeaderLength > int64(end) || binary.LittleEndian.Uint32(data[offset:]) != zipCentralHeaderSignature {
+			return corrupt("central directory")
+		}
+		header := data[offset : offset+zipCentralHeaderLength]
+		madeBy := binary.LittleEndian.Uint16(header[4:])
+		flags := binary.LittleEndian.Uint16(header[8:])
+		method := binary.LittleEndian.Uint16(header[10:])
+		modTime, modDate := binary.LittleEndian.Uint16(header[12:]), binary.LittleEndian.Uint16(header[14:])
+		crc := binary.LittleEndian.Uint32(header[16:])
+		compressedSize := int64(binary.LittleEndian.Uint32(header[20:]))
+		size := int64(binary.LittleEndian.Uint32(header[24:]))
+		nameLength := int64(binary.LittleEndian.Uint16(header[28:]))
+		variableLength := nameLength + int64(binary.LittleEndian.Uint16(header[30:])) + int64(binary.LittleEndian.Uint16(header[32:]))
+		externalAttributes := binary.LittleEndian.Uint32(header[38:])
+		localOffset := int64(binary.LittleEndian.Uint32(header[42:]))
+		if offset+zipCentralHeaderLength+variableLength > int64(end) {
+			return corrupt("central directory")
+		}
+		name := string(data[offset+zipCentralHeaderLength : offset+zipCentralHeaderLength+nameLength])
+		offset += zipCentralHeaderLength + variableLength
+
+		if compressedSize == math.MaxUint32 || size == math.MaxUint32 || localOffset == math.MaxUint32 {
+			return ErrTooLarge
+		}
+		if localOffset+zipLocalHeaderLength > int64(len(data)) || binary.LittleEndian.Uint32(data[localOffset:]) != zipLocalHeaderSignature {
+			return corrupt("local header of " + name)
+		}
+		local := data[localOffset:]
+		bodyOffset := localOffset + zipLocalHeaderLength + int64(binary.LittleEndian.Uint16(local[26:])) + int64(binary.LittleEndian.Uint16(local[28:]))
+		if bodyOffset+compressedSize > int64(len(data)) {
+			return corrupt(name + " runs past the end of the archive")
+		}
+		body := data[bodyOffset : bodyOffset+compressedSize]
+
+		entry := &fsEntry{name: strings.TrimSuffix(name, "/"), size: size, modTime: fromDOSTime(modTime, modDate)}
+		mode := fs.FileMode(0o644)
+		if madeBy>>8 == 3 { // unix, the upper half of the attributes is the mode
+			mode = fs.FileMode(externalAttributes>>16) & permMask
+		}
+		if strings.HasSuffix(name, "/") {
+			entry.mode = fs.ModeDir | mode | 0o700
+			entry.size = 0
+		} else {
+			entry.mode = mode
+			entry.read = func(ctx context.Context) ([]byte, error) {
+				return readZipEntry(ctx, name, flags, method, crc, size, body)
+			}
+		}
+		if err := fsys.add(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readZipEntry decompresses the body of a zip entry and checks its size and
+// CRC-32
+func readZipEntry(ctx context.Context, name string, flags, method uint16, crc uint32, size int64, body []byte) ([]byte, error) {
+	if flags&1 != 0 {
+		return nil, fmt.Errorf("%w: %s is encrypted", ErrUnsupportedMethod, name)
+	}
+	var data []byte
+	switch method {
+	case MethodStore:
+		data = bytes.Clone(body)
+	case MethodDeflate:
+		decompressed, _, err := compression.DecompressContext(ctx, body, compression.Options{Algorithm: "flate"})
+		if err != nil {
+			return nil, fmt.Errorf("zip: failed to decompress %s: %w", name, err)
+		}
+		data = decompressed
+	default:
+		return nil, fmt.Errorf("%w: %s uses method %d", ErrUnsupportedMethod, name, method)
+	}
+	if int64(len(data)) != size || checksum.SumCRC32(data) != crc {
+		return nil, fmt.Errorf("%w: checksum mismatch of %s", ErrCorruptZip, name)
+	}
+	return data, nil
+}
+
+// fromDOSTime is the inverse of dosTime, the time is taken as UTC
+func fromDOSTime(modTime, modDate uint16) time.Time {
+	return time.Date(int(modDate>>9)+1980, time.Month(modDate>>5&0xf), int(modDate&0x1f),
+		int(modTime>>11), int(modTime>>5&0x3f), int(modTime&0x1f)*2, 0, time.UTC)
+}
+
+// lookup returns the entry name or an *fs.PathError for op
+func (fsys *ArchiveFS) lookup(op, name string) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := fsys.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// Open opens the file or directory name. Files are decompressed on their
+// first Read, Seek or ReadAt.
+func (fsys *ArchiveFS) Open(name string) (fs.File, error) {
+	entry, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.mode.IsDir() {
+		return &fsDir{entry: entry}, nil
+	}
+	return &fsFile{fsys: fsys, entry: entry}, nil
+}
+
+// ReadFile decompresses the file name
+func (fsys *ArchiveFS) ReadFile(name string) ([]byte, error) {
+	entry, err := fsys.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDirectory}
+	}
+	data, err := entry.read(fsys.ctx)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// ReadDir lists the directory name, sorted by file name
+func (fsys *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDirectory}
+	}
+	list := make([]fs.DirEntry, len(entry.children))
+	for i, child := range entry.children {
+		list[i] = fileInfo{child}
+	}
+	return list, nil
+}
+
+// Stat describes name without decompressing it
+func (fsys *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{entry}, nil
+}
+
+var (
+	errIsDirectory  = errors.New("is a directory")
+	errNotDirectory = errors.New("not a directory")
+)
+
+// fileInfo implements fs.FileInfo and fs.DirEntry for an entry
+type fileInfo struct {
+	entry *fsEntry
+}
+
+func (fi fileInfo) Name() string               { return path.Base(fi.entry.name) }
+func (fi fileInfo) Size() int64                { return fi.entry.size }
+func (fi fileInfo) Mode() fs.FileMode          { return fi.entry.mode }
+func (fi fileInfo) ModTime() time.Time         { return fi.entry.modTime }
+func (fi fileInfo) IsDir() bool                { return fi.entry.mode.IsDir() }
+func (fi fileInfo) Sys() any                   { return nil }
+func (fi fileInfo) Type() fs.FileMode          { return fi.entry.mode.Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// fsFile is an open file of an ArchiveFS. It also implements io.Seeker and
+// io.ReaderAt, which http.FileServer uses for ranges.
+type fsFile struct {
+	fsys   *ArchiveFS
+	entry  *fsEntry
+	reader *bytes.Reader // the content, once it is decompressed
+	closed bool
+}
+
+// load decompresses the file on first use
+func (f *fsFile) load(op string) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.entry.name, Err: fs.ErrClosed}
+	}
+	if f.reader == nil {
+		data, err := f.entry.read(f.fsys.ctx)
+		if err != nil {
+			return &fs.PathError{Op: op, Path: f.entry.name, Err: err}
+		}
+		f.reader = bytes.NewReader(data)
+	}
+	return nil
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.entry.name, Err: fs.ErrClosed}
+	}
+	return fileInfo{f.entry}, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if err := f.load("read"); err != nil {
+		return 0, err
+	}
+	return f.reader.Read(p)
+}
+
+func (f *fsFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.load("read"); err != nil {
+		return 0, err
+	}
+	return f.reader.ReadAt(p, off)
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load("seek"); err != nil {
+		return 0, err
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.entry.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	f.reader = nil
+	return nil
+}
+
+// fsDir is an open directory of an ArchiveFS
+type fsDir struct {
+	entry  *fsEntry
+	read   int // children returned by ReadDir so far
+	closed bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.entry.name, Err: fs.ErrClosed}
+	}
+	return fileInfo{d.entry}, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errIsDirectory}
+}
+
+// ReadDir returns the next n children, or all remaining ones when n <= 0
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.entry.name, Err: fs.ErrClosed}
+	}
+	remaining := d.entry.children[d.read:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+	list := make([]fs.DirEntry, len(remaining))
+	for i, child := range remaining {
+		list[i] = fileInfo{child}
+	}
+	d.read += len(remaining)
+	return list, nil
+}
+
+func (d *fsDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.entry.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestArchiveFS packs the same files into each format and checks the
+// ArchiveFS over it with fstest.TestFS
+func TestArchiveFS(t *testing.T) {
+	ctx := context.Background()
+	modified := time.Date(2024, 5, 17, 13, 45, 30, 0, time.UTC)
+	files := fstest.MapFS{
+		"index.html":        {Data: []byte(strings.Repeat("<p>hello</p>\n", 20)), ModTime: modified},
+		"static/app.js":     {Data: []byte("console.log('app')\n"), ModTime: modified},
+		"static/css/a.css":  {Data: []byte("body { margin: 0 }\n"), ModTime: modified},
+		"static/empty.txt":  {ModTime: modified},
+		"templates/x.tmpl":  {Data: []byte("{{.}}"), ModTime: modified},
+		"templates/skip.md": {Data: []byte("not matched"), ModTime: modified},
+	}
+	want := []string{"index.html", "static/app.js", "static/css/a.css", "static/empty.txt", "templates/x.tmpl"}
+
+	var zipData bytes.Buffer
+	if err := CompressFS(ctx, &zipData, files, "*.html", "static", "templates/*.tmpl"); err != nil {
+		t.Fatalf("CompressFS: %v", err)
+	}
+
+	var containerData bytes.Buffer
+	cw := NewContainerWriter(&containerData)
+	tree := filepath.Join(t.TempDir(), "site")
+	for _, name := range want {
+		if err := cw.AddFile(ctx, name, modified, "huffman", files[name].Data); err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(tree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, files[name].Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tarGz, err := CreateTarGz(ctx, tree, TarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, data := range map[string][]byte{"zip": zipData.Bytes(), "container": containerData.Bytes(), "tar.gz": tarGz} {
+		archiveFS, err := NewArchiveFS(ctx, data)
+		if err != nil {
+			t.Fatalf("%s: NewArchiveFS: %v", format, err)
+		}
+		var fsys fs.FS = archiveFS
+		if format == "tar.gz" {
+			// the tar entries start with the name of the directory
+			if fsys, err = fs.Sub(archiveFS, "site"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fstest.TestFS(fsys, want...); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+		if _, err := fs.Stat(fsys, "templates/skip.md"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Stat of a file that was not added = %v", format, err)
+		}
+		for _, name := range want {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil || !bytes.Equal(data, files[name].Data) {
+				t.Errorf("%s: ReadFile(%s) = %q, %v", format, name, data, err)
+			}
+		}
+	}
+
+	if _, err := NewArchiveFS(ctx, []byte("plain text")); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("NewArchiveFS of text = %v, want ErrUnknownFormat", err)
+	}
+	if err := CompressFS(ctx, &zipData, files, "*.png"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("CompressFS with a pattern matching nothing = %v, want ErrNoMatch", err)
+	}
+}
@@ -0,0 +1,484 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// SymlinkPolicy says what happens to symbolic links in a directory tree
+type SymlinkPolicy int
+
+const (
+	// SymlinksPreserve stores links as link entries and recreates them on
+	// extraction, as long as their target stays inside the destination
+	SymlinksPreserve SymlinkPolicy = iota
+	// SymlinksSkip leaves links out when writing and ignores link entries
+	// when extracting
+	SymlinksSkip
+)
+
+// TarOptions control how trees are written to and restored from tar archives
+type TarOptions struct {
+	Symlinks SymlinkPolicy
+	// ModifiedAfter, when set, leaves out files and links that were not
+	// modified after it. Directories are always written, they are cheap and
+	// carry the permissions of the tree.
+	ModifiedAfter time.Time
+	// Collisions says what ExtractTarAtomic does with entries whose path is
+	// taken in the destination. ExtractTar always replaces files.
+	Collisions CollisionPolicy
+}
+
+// Errors returned by the tar functions
+var (
+	ErrUnsafePath       = errors.New("tar: entry path leaves the destination directory")
+	ErrUnsafeLink       = errors.New("tar: link target leaves the destination directory")
+	ErrUnsupportedEntry = errors.New("tar: unsupported entry type")
+	ErrNotGzip          = errors.New("tar: archive is not gzip compressed")
+)
+
+// permMask keeps the permission bits of a mode, setuid, setgid and sticky
+// bits are never written nor restored
+const permMask = 0o777
+
+// WriteTar writes the file or directory tree at root to w. Entry names start
+// with the base name of root, like tar does for "tar cf out.tar dir". Regular
+// files, directories and, depending on the policy, symbolic links are
+// archived with their permissions and modification times; other files such
+// as sockets and devices are left out. Headers are ustar where that is
+// enough, the writer switches to pax records for long names and big files.
+func WriteTar(w io.Writer, root string, options TarOptions) error {
+	root = filepath.Clean(root)
+	tw := tar.NewWriter(w)
+	if err := addTree(tw, filepath.Dir(root), root, options); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addTree writes the tree at root to tw, with entry names relative to base.
+// base itself is never written.
+func addTree(tw *tar.Writer, base, root string, options TarOptions) error {
+	return filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		var link string
+		switch {
+		case info.Mode().IsRegular(), info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if options.Symlinks == SymlinksSkip {
+				return nil
+			}
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		if !info.IsDir() && !options.ModifiedAfter.IsZero() && !info.ModTime().After(options.ModifiedAfter) {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, filepath.ToSlash(link))
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Mode &= permMask
+		// owner names and ids mean nothing on the machine the tree is restored on
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.ModTime = info.ModTime().Truncate(time.Second)
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("tar: failed to write header of %s: %w", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileTo(tw, file)
+	})
+}
+
+func copyFileTo(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ExtractTar restores the archive read from r below dest, which is created
+// if needed. Entries are only ever written inside dest: names that climb out
+// of it, links pointing outside of it and entries below a link are rejected,
+// and hard links and device files are not supported. Permissions and
+// modification times are restored, ownership is not. Reading goes on past
+// the end of archive blocks, so archives that had more written after them by
+// AppendTarGz are restored completely, later entries replacing earlier ones.
+func ExtractTar(r io.Reader, dest string, options TarOptions) error {
+	dirs, err := extractTar(r, dest, options, false)
+	if err != nil {
+		return err
+	}
+	return setDirAttributes(dirs)
+}
+
+// dirAttributes are the attributes of a restored directory, they are set
+// once everything below it is written: a read-only directory could not be
+// filled and writing touches the time
+type dirAttributes struct {
+	path    string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// setDirAttributes sets the attributes of directories, the deepest first
+func setDirAttributes(dirs []dirAttributes) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar restores the entries of a tar archive below dest and returns
+// the directories whose attributes are still to be set. With sync set files
+// are flushed to disk as they are written.
+func extractTar(r io.Reader, dest string, options TarOptions, sync bool) ([]dirAttributes, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	var dirs []dirAttributes
+
+	br := bufio.NewReader(r)
+	tr := tar.NewReader(br)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			more, err := skipZeroBlocks(br)
+			if err != nil {
+				return nil, fmt.Errorf("tar: failed to read archive: %w", err)
+			}
+			if !more {
+				break
+			}
+			tr = tar.NewReader(br)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar: failed to read archive: %w", err)
+		}
+
+		name := strings.TrimSuffix(header.Name, "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, header.Name)
+		}
+		if err := checkNoLinkParents(dest, name); err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		mode := fs.FileMode(header.Mode) & permMask
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				return nil, fmt.Errorf("%w: %s is a link", ErrUnsafePath, header.Name)
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, dirAttributes{target, mode, header.ModTime})
+		case tar.TypeReg:
+			if err := replaceExisting(target); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return nil, err
+			}
+			if err := writeFileFrom(tr, target, mode, sync); err != nil {
+				return nil, err
+			}
+			if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			if options.Symlinks == SymlinksSkip {
+				continue
+			}
+			linkTarget := path.Join(path.Dir(name), header.Linkname)
+			if path.IsAbs(header.Linkname) || !filepath.IsLocal(filepath.FromSlash(linkTarget)) || climbsOutOfName(header.Linkname) {
+				return nil, fmt.Errorf("%w: %s -> %s", ErrUnsafeLink, header.Name, header.Linkname)
+			}
+			if err := replaceExisting(target); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return nil, err
+			}
+			if err := os.Symlink(filepath.FromSlash(header.Linkname), target); err != nil {
+				return nil, err
+			}
+		case tar.TypeXGlobalHeader:
+			// pax global records carry nothing that is restored
+		default:
+			return nil, fmt.Errorf("%w: %s has type %q", ErrUnsupportedEntry, header.Name, header.Typeflag)
+		}
+	}
+
+	return dirs, nil
+}
+
+// skipZeroBlocks skips the zero blocks that end a tar archive and pad it to
+// its record size, and reports whether anything follows them
+func skipZeroBlocks(br *bufio.Reader) (bool, error) {
+	for {
+		block, err := br.Peek(blockSize)
+		if len(block) == 0 && err == io.EOF {
+			return false, nil
+		}
+		if len(block) < blockSize {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return false, err
+		}
+		if !isZero(block) {
+			return true, nil
+		}
+		br.Discard(blockSize)
+	}
+}
+
+// blockSize is the size of tar headers and the unit data is padded to
+const blockSize = 512
+
+func isZero(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNoLinkParents fails if any directory between dest and name is a
+// symbolic link, following one could write outside of dest even though both
+// the link and the name look local
+func checkNoLinkParents(dest, name string) error {
+	current := dest
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below the link %s", ErrUnsafePath, name, current)
+		}
+	}
+	return nil
+}
+
+// climbsOutOfName reports whether linkname climbs back out of a name it
+// went down into, such as l/.. does. Lexically l/.. is the directory of the
+// link, but if l is itself a link, restored before or after this one, it is
+// the parent of wherever l leads. Leading .. only climb through the real
+// directories above the link, so a target without such a step stays inside
+// dest through any chain of links.
+func climbsOutOfName(linkname string) bool {
+	down := false
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if down {
+				return true
+			}
+		default:
+			down = true
+		}
+	}
+	return false
+}
+
+// replaceExisting removes a file or link that is in the way of a new entry,
+// so it is replaced rather than written through
+func replaceExisting(target string) error {
+	info, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("tar: %s is a directory", target)
+	}
+	return os.Remove(target)
+}
+
+func writeFileFrom(r io.Reader, target string, mode fs.FileMode, sync bool) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if sync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// the umask may have dropped bits of the mode OpenFile was given
+	return os.Chmod(target, mode)
+}
+
+// CreateTarGz archives the tree at root and compresses it with the gzip
+// algorithm, the result is a .tar.gz that gunzip and tar understand
+func CreateTarGz(ctx context.Context, root string, options TarOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, root, options); err != nil {
+		return nil, err
+	}
+	compressed, _, err := compression.CompressContext(ctx, buf.Bytes(), compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		return nil, err
+	}
+	return compressed, nil
+}
+
+// StreamTarGz writes a .tar.gz of the files and directories of dir that
+// names lists, all of dir when there are none, to w. The archive is
+// compressed with the gzip algorithm as it is being written, so neither the
+// tar nor the compressed archive is ever held in memory whole. Entry names
+// are relative to dir; names that are not local to it fail with
+// ErrUnsafePath, and names that do not exist fail before anything was
+// written.
+func StreamTarGz(ctx context.Context, w io.Writer, dir string, names []string, options TarOptions) (*compression.Stats, error) {
+	if len(names) == 0 {
+		names = []string{"."}
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]string, len(names))
+	for i, name := range names {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+		roots[i] = filepath.Join(dir, filepath.FromSlash(name))
+		if _, err := os.Lstat(roots[i]); err != nil {
+			return nil, err
+		}
+		// a link among the directories above the name may lead out of dir
+		parent, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.Dir(filepath.FromSlash(name))))
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(realDir, parent); err != nil || !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		for _, root := range roots {
+			if err := addTree(tw, dir, root, options); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	stats, err := compression.CompressStream(ctx, w, pr, compression.Options{Algorithm: "gzip"})
+	// stops the tar writer if compression gave up before reading everything
+	pr.CloseWithError(io.ErrClosedPipe)
+	return stats, err
+}
+
+// ExtractTarGz decompresses a .tar.gz with the gzip algorithm and restores it
+// below dest
+func ExtractTarGz(ctx context.Context, data []byte, dest string, options TarOptions) error {
+	tarData, _, err := compression.DecompressContext(ctx, data, compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		return err
+	}
+	return ExtractTar(bytes.NewReader(tarData), dest, options)
+}
+
+// AppendTarGz archives the tree at root and appends it to the .tar.gz at
+// archivePath as another gzip member, the existing members are not read nor
+// rewritten. With options.ModifiedAfter set to the time of the last backup
+// only what changed since is added. ExtractTarGz restores the result with
+// the later copies of a file winning; gunzip reads it as one stream, GNU tar
+// needs --ignore-zeros (tar -xizf) to go past the first end of archive.
+func AppendTarGz(ctx context.Context, archivePath, root string, options TarOptions) error {
+	file, err := os.OpenFile(archivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(file, magic); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return fmt.Errorf("%w: %s", ErrNotGzip, archivePath)
+	}
+
+	member, err := CreateTarGz(ctx, root, options)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := file.Write(member); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,249 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTarGzRoundTrip archives a small tree with a nested directory, an
+// executable and a link, checks that compress/gzip reads the result and
+// restores it somewhere else
+func TestTarGzRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "tree")
+	modified := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	files := map[string]struct {
+		data string
+		mode os.FileMode
+	}{
+		"readme.txt":     {"hello tar\n", 0o644},
+		"bin/run.sh":     {"#!/bin/sh\necho hi\n", 0o755},
+		"bin/deep/empty": {"", 0o600},
+	}
+	for name, file := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file.data), file.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, file.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("bin/run.sh", filepath.Join(src, "run")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := CreateTarGz(context.Background(), src, TarOptions{})
+	if err != nil {
+		t.Fatalf("CreateTarGz: %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("compress/gzip rejected the archive: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		t.Fatalf("compress/gzip rejected the archive: %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := ExtractTarGz(context.Background(), data, dest, TarOptions{}); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
+	}
+	for name, file := range files {
+		path := filepath.Join(dest, "tree", filepath.FromSlash(name))
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(got) != file.data {
+			t.Errorf("%s: restored %q, want %q", name, got, file.data)
+		}
+		info, _ := os.Stat(path)
+		if info.Mode().Perm() != file.mode || !info.ModTime().Equal(modified) {
+			t.Errorf("%s: restored with mode %v and time %v, want %v and %v", name, info.Mode().Perm(), info.ModTime(), file.mode, modified)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "tree", "run")); err != nil || link != "bin/run.sh" {
+		t.Errorf("link restored as %q, %v", link, err)
+	}
+
+	// skipping links leaves it out on both sides
+	dest = t.TempDir()
+	if err := ExtractTarGz(context.Background(), data, dest, TarOptions{Symlinks: SymlinksSkip}); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "tree", "run")); !os.IsNotExist(err) {
+		t.Errorf("link was restored although links are skipped: %v", err)
+	}
+}
+
+func TestExtractTarRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		want    error
+	}{
+		{"parent path", []*tar.Header{{Name: "../evil", Typeflag: tar.TypeReg}}, ErrUnsafePath},
+		{"absolute path", []*tar.Header{{Name: "/tmp/evil", Typeflag: tar.TypeReg}}, ErrUnsafePath},
+		{"link outside", []*tar.Header{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "../outside"}}, ErrUnsafeLink},
+		{"absolute link", []*tar.Header{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}, ErrUnsafeLink},
+		{"write through link", []*tar.Header{
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "l/x", Typeflag: tar.TypeReg},
+		}, ErrUnsafePath},
+		{"chain of links", []*tar.Header{
+			{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "d/l2", Typeflag: tar.TypeSymlink, Linkname: "l/.."},
+		}, ErrUnsafeLink},
+		{"chain through a later link", []*tar.Header{
+			{Name: "d/l2", Typeflag: tar.TypeSymlink, Linkname: "l/.."},
+			{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		}, ErrUnsafeLink},
+		{"hard link", []*tar.Header{{Name: "h", Typeflag: tar.TypeLink, Linkname: "x"}}, ErrUnsupportedEntry},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, header := range test.headers {
+			header.Mode = 0o644
+			if err := tw.WriteHeader(header); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		tw.Close()
+		if err := ExtractTar(&buf, t.TempDir(), TarOptions{}); !errors.Is(err, test.want) {
+			t.Errorf("%s: ExtractTar = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// TestAppendTarGz appends only the changed file of a tree and checks that
+// extraction restores the newer copy and keeps the untouched file
+func TestAppendTarGz(t *testing.T) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "backup")
+	old := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, data := range map[string]string{"kept.txt": "kept\n", "changed.txt": "first\n"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(src, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+	data, err := CreateTarGz(ctx, src, TarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := filepath.Join(src, "changed.txt")
+	if err := os.WriteFile(changed, []byte("second\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendTarGz(ctx, archivePath, src, TarOptions{ModifiedAfter: old}); err != nil {
+		t.Fatalf("AppendTarGz: %v", err)
+	}
+	appended, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(appended, data) {
+		t.Fatalf("appending rewrote the existing archive")
+	}
+
+	dest := t.TempDir()
+	if err := ExtractTarGz(ctx, appended, dest, TarOptions{}); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
+	}
+	for name, want := range map[string]string{"kept.txt": "kept\n", "changed.txt": "second\n"} {
+		if got, err := os.ReadFile(filepath.Join(dest, "backup", name)); err != nil || string(got) != want {
+			t.Errorf("%s: restored %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if err := AppendTarGz(ctx, changed, src, TarOptions{}); !errors.Is(err, ErrNotGzip) {
+		t.Errorf("AppendTarGz to a text file = %v, want ErrNotGzip", err)
+	}
+}
+
+// TestStreamTarGz exports a selection of a directory and all of it, and
+// checks that names outside of it are refused before anything is written
+func TestStreamTarGz(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "first result\n", "jobs/b.txt": "second result\n", "jobs/c.txt": "third result\n"}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"jobs/b.txt", "a.txt"}, []string{"a.txt", "jobs/b.txt"}},
+		{nil, []string{"a.txt", "jobs/b.txt", "jobs/c.txt"}},
+	} {
+		var buf bytes.Buffer
+		stats, err := StreamTarGz(ctx, &buf, dir, test.names, TarOptions{})
+		if err != nil {
+			t.Fatalf("StreamTarGz(%q): %v", test.names, err)
+		}
+		if stats.ProcessedSize != int64(buf.Len()) {
+			t.Errorf("StreamTarGz(%q) reported %d bytes and wrote %d", test.names, stats.ProcessedSize, buf.Len())
+		}
+		dest := t.TempDir()
+		if err := ExtractTarGz(ctx, buf.Bytes(), dest, TarOptions{}); err != nil {
+			t.Fatalf("ExtractTarGz: %v", err)
+		}
+		for _, name := range test.want {
+			if got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); err != nil || string(got) != files[name] {
+				t.Errorf("StreamTarGz(%q): %s restored as %q, %v", test.names, name, got, err)
+			}
+		}
+		if test.names != nil {
+			if _, err := os.Stat(filepath.Join(dest, "jobs", "c.txt")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("StreamTarGz(%q) exported a file that was not asked for", test.names)
+			}
+		}
+	}
+
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "outside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "outside", "secret.txt"), []byte("not exported"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, names := range [][]string{{"../escape"}, {"/etc/passwd"}, {"missing.txt"}, {"outside/secret.txt"}} {
+		var buf bytes.Buffer
+		if _, err := StreamTarGz(ctx, &buf, dir, names, TarOptions{}); err == nil || buf.Len() > 0 {
+			t.Errorf("StreamTarGz(%q) wrote %d bytes and returned %v", names, buf.Len(), err)
+		}
+	}
+}
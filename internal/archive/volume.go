@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidVolumeSize is returned for volume sizes that are not positive
+var ErrInvalidVolumeSize = errors.New("volume size has to be positive")
+
+// VolumeName returns the name of volume n, counting from 1, of the archive
+// base: archive.zip.001, archive.zip.002 and so on
+func VolumeName(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// VolumeWriter splits what is written to it into files of at most size
+// bytes named after VolumeName. Volumes are only created once there is data
+// for them, so an archive that fits into one volume still gets the .001
+// suffix and nothing more.
+type VolumeWriter struct {
+	base    string
+	size    int64
+	current *os.File
+	written int64 // bytes in the current volume
+	volumes []string
+}
+
+// NewVolumeWriter returns a VolumeWriter for the archive base
+func NewVolumeWriter(base string, size int64) (*VolumeWriter, error) {
+	if size <= 0 {
+		return nil, ErrInvalidVolumeSize
+	}
+	return &VolumeWriter{base: base, size: size}, nil
+}
+
+func (vw *VolumeWriter) Write(data []byte) (int, error) {
+	total := 0
+	for len(data) > 0 {
+		if vw.current == nil || vw.written == vw.size {
+			if err := vw.nextVolume(); err != nil {
+				return total, err
+			}
+		}
+		chunk := data[:min(int64(len(data)), vw.size-vw.written)]
+		n, err := vw.current.Write(chunk)
+		total += n
+		vw.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		data = data[n:]
+	}
+	return total, nil
+}
+
+func (vw *VolumeWriter) nextVolume() error {
+	if vw.current != nil {
+		if err := vw.current.Close(); err != nil {
+			return err
+		}
+	}
+	name := VolumeName(vw.base, len(vw.volumes)+1)
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	vw.current, vw.written = file, 0
+	vw.volumes = append(vw.volumes, name)
+	return nil
+}
+
+// Close closes the last volume and removes volumes left behind by an earlier,
+// longer archive of the same name, which a reader would otherwise append
+func (vw *VolumeWriter) Close() error {
+	if vw.current == nil {
+		// an empty archive still gets its first volume
+		if err := vw.nextVolume(); err != nil {
+			return err
+		}
+	}
+	if err := vw.current.Close(); err != nil {
+		return err
+	}
+	for n := len(vw.volumes) + 1; ; n++ {
+		err := os.Remove(VolumeName(vw.base, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Volumes returns the names of the volumes written so far
+func (vw *VolumeWriter) Volumes() []string {
+	return append([]string{}, vw.volumes...)
+}
+
+// ReadVolumes reads an archive that may be split into volumes. path is either
+// the archive itself, its base name or any of its volumes; when volumes exist
+// they are joined in order starting from the first one.
+func ReadVolumes(path string) ([]byte, error) {
+	base := path
+	if ext := filepath.Ext(path); len(ext) >= 4 && isDigits(ext[1:]) {
+		base = strings.TrimSuffix(path, ext)
+	}
+	if _, err := os.Stat(VolumeName(base, 1)); err != nil {
+		// not split, read it as a single file
+		return os.ReadFile(path)
+	}
+
+	var data []byte
+	for n := 1; ; n++ {
+		volume, err := os.ReadFile(VolumeName(base, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, volume...)
+	}
+}
+
+func isDigits(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
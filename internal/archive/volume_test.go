@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumesRoundTrip(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "archive.zip")
+	data := bytes.Repeat([]byte("0123456789"), 25)
+
+	// a stale third volume from an earlier, longer archive has to go
+	if err := os.WriteFile(VolumeName(base, 3), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vw, err := NewVolumeWriter(base, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written in pieces that do not line up with the volume size
+	for i := 0; i < len(data); i += 30 {
+		if _, err := vw.Write(data[i:min(i+30, len(data))]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := vw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if volumes := vw.Volumes(); len(volumes) != 3 {
+		t.Fatalf("wrote volumes %v, want 3 of them", volumes)
+	}
+	for n, want := range []int64{100, 100, 50} {
+		info, err := os.Stat(VolumeName(base, n+1))
+		if err != nil || info.Size() != want {
+			t.Errorf("volume %d: %v, want %d bytes", n+1, err, want)
+		}
+	}
+	for _, path := range []string{base, VolumeName(base, 1), VolumeName(base, 2)} {
+		joined, err := ReadVolumes(path)
+		if err != nil {
+			t.Fatalf("ReadVolumes(%s): %v", path, err)
+		}
+		if !bytes.Equal(joined, data) {
+			t.Errorf("ReadVolumes(%s) returned %d bytes that do not match the %d written", path, len(joined), len(data))
+		}
+	}
+
+	// the same archive without volumes is read as it is
+	if err := os.WriteFile(base+".single", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if single, err := ReadVolumes(base + ".single"); err != nil || !bytes.Equal(single, data) {
+		t.Errorf("ReadVolumes of an archive that is not split: %v", err)
+	}
+}
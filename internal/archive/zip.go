@@ -0,0 +1,222 @@
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// Compression methods of a zip entry
+const (
+	MethodStore   uint16 = 0
+	MethodDeflate uint16 = 8
+)
+
+const (
+	zipLocalHeaderSignature    = 0x04034b50
+	zipCentralHeaderSignature  = 0x02014b50
+	zipEndOfDirectorySignature = 0x06054b50
+	zipLocalHeaderLength       = 30
+	zipCentralHeaderLength     = 46
+	zipEndOfDirectoryLength    = 22
+	zipVersionNeeded           = 20        // 2.0, deflate
+	zipVersionMadeBy           = 3<<8 | 20 // unix, so the external attributes carry a file mode
+	zipFlagUTF8                = 1 << 11
+	zipRegularFileMode         = 0o100644
+)
+
+// Errors returned by ZipWriter
+var (
+	ErrInvalidName   = errors.New("zip: invalid entry name")
+	ErrDuplicateName = errors.New("zip: duplicate entry name")
+	ErrTooLarge      = errors.New("zip: archive needs zip64, which is not supported")
+	ErrWriterClosed  = errors.New("zip: writer is closed")
+)
+
+// zipEntry is what the central directory needs to know about a written entry
+type zipEntry struct {
+	name             string
+	method           uint16
+	modTime, modDate uint16
+	crc              uint32
+	compressedSize   uint64
+	size             uint64
+	offset           uint64
+}
+
+// ZipWriter writes a zip archive whose entries are compressed with the
+// flate algorithm of this module. Entries are buffered whole, so each one
+// is written with its sizes and CRC-32 already in the local header.
+type ZipWriter struct {
+	w       io.Writer
+	offset  uint64
+	entries []zipEntry
+	names   map[string]bool
+	closed  bool
+}
+
+// NewZipWriter returns a ZipWriter that writes the archive to w
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{w: w, names: make(map[string]bool)}
+}
+
+// AddFile compresses data and writes it as the entry name. Entries that
+// deflate does not make smaller are stored as they are. Names use forward
+// slashes and have to stay inside the archive, "../x" or "/x" are rejected.
+func (zw *ZipWriter) AddFile(ctx context.Context, name string, modified time.Time, data []byte) error {
+	if zw.closed {
+		return ErrWriterClosed
+	}
+	if !validEntryName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if zw.names[name] {
+		return fmt.Errorf("%w: %q", ErrDuplicateName, name)
+	}
+	if len(zw.entries) == math.MaxUint16 {
+		return ErrTooLarge
+	}
+
+	method, body, err := compressEntry(ctx, data)
+	if err != nil {
+		return fmt.Errorf("zip: failed to compress %s: %w", name, err)
+	}
+	entry := zipEntry{
+		name:           name,
+		method:         method,
+		crc:            checksum.SumCRC32(data),
+		compressedSize: uint64(len(body)),
+		size:           uint64(len(data)),
+		offset:         zw.offset,
+	}
+	entry.modTime, entry.modDate = dosTime(modified)
+	if entry.size > math.MaxUint32 || entry.compressedSize > math.MaxUint32 || entry.offset > math.MaxUint32 {
+		return ErrTooLarge
+	}
+
+	header := make([]byte, zipLocalHeaderLength, zipLocalHeaderLength+len(name))
+	binary.LittleEndian.PutUint32(header[0:], zipLocalHeaderSignature)
+	binary.LittleEndian.PutUint16(header[4:], zipVersionNeeded)
+	binary.LittleEndian.PutUint16(header[6:], zipFlagUTF8)
+	binary.LittleEndian.PutUint16(header[8:], entry.method)
+	binary.LittleEndian.PutUint16(header[10:], entry.modTime)
+	binary.LittleEndian.PutUint16(header[12:], entry.modDate)
+	binary.LittleEndian.PutUint32(header[14:], entry.crc)
+	binary.LittleEndian.PutUint32(header[18:], uint32(entry.compressedSize))
+	binary.LittleEndian.PutUint32(header[22:], uint32(entry.size))
+	binary.LittleEndian.PutUint16(header[26:], uint16(len(name)))
+	// header[28:30] is the extra field length, there is none
+	header = append(header, name...)
+	if err := zw.write(header); err != nil {
+		return err
+	}
+	if err := zw.write(body); err != nil {
+		return err
+	}
+	zw.entries = append(zw.entries, entry)
+	zw.names[name] = true
+	return nil
+}
+
+// Close writes the central directory. It does not close the underlying writer.
+func (zw *ZipWriter) Close() error {
+	if zw.closed {
+		return ErrWriterClosed
+	}
+	zw.closed = true
+
+	directoryOffset := zw.offset
+	for _, entry := range zw.entries {
+		header := make([]byte, zipCentralHeaderLength, zipCentralHeaderLength+len(entry.name))
+		binary.LittleEndian.PutUint32(header[0:], zipCentralHeaderSignature)
+		binary.LittleEndian.PutUint16(header[4:], zipVersionMadeBy)
+		binary.LittleEndian.PutUint16(header[6:], zipVersionNeeded)
+		binary.LittleEndian.PutUint16(header[8:], zipFlagUTF8)
+		binary.LittleEndian.PutUint16(header[10:], entry.method)
+		binary.LittleEndian.PutUint16(header[12:], entry.modTime)
+		binary.LittleEndian.PutUint16(header[14:], entry.modDate)
+		binary.LittleEndian.PutUint32(header[16:], entry.crc)
+		binary.LittleEndian.PutUint32(header[20:], uint32(entry.compressedSize))
+		binary.LittleEndian.PutUint32(header[24:], uint32(entry.size))
+		binary.LittleEndian.PutUint16(header[28:], uint16(len(entry.name)))
+		// extra field, comment, disk number and internal attributes stay zero
+		binary.LittleEndian.PutUint32(header[38:], zipRegularFileMode<<16)
+		binary.LittleEndian.PutUint32(header[42:], uint32(entry.offset))
+		header = append(header, entry.name...)
+		if err := zw.write(header); err != nil {
+			return err
+		}
+	}
+	directorySize := zw.offset - directoryOffset
+	if zw.offset > math.MaxUint32 {
+		return ErrTooLarge
+	}
+
+	end := make([]byte, zipEndOfDirectoryLength)
+	binary.LittleEndian.PutUint32(end[0:], zipEndOfDirectorySignature)
+	binary.LittleEndian.PutUint16(end[8:], uint16(len(zw.entries)))
+	binary.LittleEndian.PutUint16(end[10:], uint16(len(zw.entries)))
+	binary.LittleEndian.PutUint32(end[12:], uint32(directorySize))
+	binary.LittleEndian.PutUint32(end[16:], uint32(directoryOffset))
+	return zw.write(end)
+}
+
+func (zw *ZipWriter) write(data []byte) error {
+	n, err := zw.w.Write(data)
+	zw.offset += uint64(n)
+	if err != nil {
+		return fmt.Errorf("zip: failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// compressEntry deflates data with the internal flate implementation and
+// falls back to storing it when that does not save anything, as it happens
+// for empty and already compressed files
+func compressEntry(ctx context.Context, data []byte) (uint16, []byte, error) {
+	if len(data) == 0 {
+		return MethodStore, data, nil
+	}
+	compressed, _, err := compression.CompressContext(ctx, data, compression.Options{Algorithm: "flate"})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(compressed) >= len(data) {
+		return MethodStore, data, nil
+	}
+	return MethodDeflate, compressed, nil
+}
+
+// validEntryName reports whether name is a relative slash-separated path
+// that does not climb out of the directory the archive is extracted into
+func validEntryName(name string) bool {
+	if name == "" || len(name) > math.MaxUint16 || strings.ContainsAny(name, "\\\x00") {
+		return false
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return false
+	}
+	return path.Clean(name) == name && name != "." && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// dosTime converts t to the MS-DOS time and date fields of a zip header,
+// which count from 1980 in two second steps
+func dosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	} else if t.Year() > 2107 {
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, t.Location())
+	}
+	modTime := uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	modDate := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	return modTime, modDate
+}
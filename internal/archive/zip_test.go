@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestZipReadableByStdlib writes an archive with a compressible, an
+// incompressible and an empty entry and reads it back with archive/zip
+func TestZipReadableByStdlib(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	files := []struct {
+		name   string
+		data   []byte
+		method uint16
+	}{
+		{"docs/readme.txt", []byte(strings.Repeat("hello, zip world\n", 200)), MethodDeflate},
+		{"random.bin", random, MethodStore},
+		{"empty", nil, MethodStore},
+	}
+	modified := time.Date(2024, 5, 17, 13, 45, 30, 0, time.UTC)
+
+	var buf bytes.Buffer
+	zw := NewZipWriter(&buf)
+	for _, file := range files {
+		if err := zw.AddFile(context.Background(), file.name, modified, file.data); err != nil {
+			t.Fatalf("AddFile(%s): %v", file.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("archive/zip rejected the archive: %v", err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), len(files))
+	}
+	for i, file := range files {
+		entry := zr.File[i]
+		if entry.Name != file.name || entry.Method != file.method {
+			t.Errorf("entry %d is %s with method %d, want %s with method %d", i, entry.Name, entry.Method, file.name, file.method)
+		}
+		if !entry.Modified.Equal(modified) {
+			t.Errorf("%s: modified %v, want %v", entry.Name, entry.Modified, modified)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			t.Fatalf("%s: Open: %v", entry.Name, err)
+		}
+		// reading to the end also checks the CRC-32
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", entry.Name, err)
+		}
+		if !bytes.Equal(data, file.data) {
+			t.Errorf("%s: read %d bytes that do not match the %d written", entry.Name, len(data), len(file.data))
+		}
+	}
+}
+
+func TestZipRejectsBadNames(t *testing.T) {
+	zw := NewZipWriter(io.Discard)
+	for _, name := range []string{"", ".", "..", "../x", "/etc/passwd", "a/../b", "a\\b", "dir/"} {
+		if err := zw.AddFile(context.Background(), name, time.Now(), []byte("x")); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("AddFile(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+	if err := zw.AddFile(context.Background(), "a", time.Now(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.AddFile(context.Background(), "a", time.Now(), []byte("y")); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("second AddFile(a) = %v, want ErrDuplicateName", err)
+	}
+}
@@ -0,0 +1,152 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// Result is the outcome of running one algorithm over one corpus file
+type Result struct {
+	Corpus              string        `json:"corpus"`
+	File                string        `json:"file"`
+	Algorithm           string        `json:"algorithm"`
+	OriginalSize        int           `json:"original_size"`
+	CompressedSize      int           `json:"compressed_size"`
+	Ratio               float64       `json:"ratio"`
+	CompressDuration    time.Duration `json:"compress_ns"`
+	DecompressDuration  time.Duration `json:"decompress_ns"`
+	CompressMBps        float64       `json:"compress_mbps"`
+	DecompressMBps      float64       `json:"decompress_mbps"`
+	CompressPeakBytes   int64         `json:"compress_peak_bytes"` // most bytes the codec held in buffers
+	DecompressPeakBytes int64         `json:"decompress_peak_bytes"`
+	RoundTrip           bool          `json:"round_trip"`
+	Error               string        `json:"error,omitempty"`
+}
+
+// Report collects the results of a benchmark run together with the
+// environment it ran in
+type Report struct {
+	StartedAt time.Time `json:"started_at"`
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	NumCPU    int       `json:"num_cpu"`
+	Results   []Result  `json:"results"`
+}
+
+// Run compresses and decompresses every file of every corpus with each of
+// the algorithms, defaulting to all supported ones. A failing or panicking
+// algorithm is recorded in its result and does not stop the run.
+func Run(corpora []*Corpus, algorithms []string) *Report {
+	if len(algorithms) == 0 {
+		algorithms = compression.GetSupportedAlgorithms()
+	}
+	report := &Report{
+		StartedAt: time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+	}
+	for _, corpus := range corpora {
+		for _, file := range corpus.Files {
+			for _, algorithm := range algorithms {
+				result := runOne(file.Data, algorithm)
+				result.Corpus, result.File = corpus.Name, file.Name
+				report.Results = append(report.Results, result)
+			}
+		}
+	}
+	return report
+}
+
+func runOne(data []byte, algorithm string) (result Result) {
+	result.Algorithm = algorithm
+	result.OriginalSize = len(data)
+	defer func() {
+		if r := recover(); r != nil {
+			result.RoundTrip = false
+			result.Error = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+
+	options := compression.Options{Algorithm: algorithm}
+	start := time.Now()
+	compressed, stats, err := compression.Compress(data, options)
+	result.CompressDuration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.CompressPeakBytes = stats.PeakBufferBytes
+	result.CompressedSize = len(compressed)
+	if len(data) > 0 {
+		result.Ratio = float64(len(compressed)) / float64(len(data))
+	}
+	result.CompressMBps = throughput(len(data), result.CompressDuration)
+
+	start = time.Now()
+	decompressed, stats, err := compression.Decompress(compressed, options)
+	result.DecompressDuration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.DecompressPeakBytes = stats.PeakBufferBytes
+	result.DecompressMBps = throughput(len(data), result.DecompressDuration)
+	result.RoundTrip = bytes.Equal(decompressed, data)
+	if !result.RoundTrip {
+		result.Error = "decompressed output does not match the input"
+	}
+	return result
+}
+
+func throughput(size int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / elapsed.Seconds()
+}
+
+// Failed returns the results that did not round-trip
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if !result.RoundTrip {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteTable writes the results as an aligned, human-readable table
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CORPUS\tFILE\tALGORITHM\tSIZE\tCOMPRESSED\tRATIO\tCOMP MB/s\tDECOMP MB/s\tCOMP PEAK\tDECOMP PEAK\tOK")
+	for _, result := range r.Results {
+		status := "yes"
+		if !result.RoundTrip {
+			status = "no: " + result.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.3f\t%.2f\t%.2f\t%d\t%d\t%s\n",
+			result.Corpus, result.File, result.Algorithm,
+			result.OriginalSize, result.CompressedSize, result.Ratio,
+			result.CompressMBps, result.DecompressMBps,
+			result.CompressPeakBytes, result.DecompressPeakBytes, status)
+	}
+	return tw.Flush()
+}
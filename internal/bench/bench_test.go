@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestGeneratedCorpora is the regression check over the corpora that need no
+// download: every algorithm is binary safe, so each has to compress random
+// bytes and zeros without an error and reproduce them exactly.
+func TestGeneratedCorpora(t *testing.T) {
+	options := CorpusOptions{GeneratedSize: 8 * 1024}
+	var corpora []*Corpus
+	for _, name := range []string{"random", "zeros"} {
+		corpus, err := LoadCorpus(name, options)
+		if err != nil {
+			t.Fatalf("LoadCorpus(%q): %v", name, err)
+		}
+		corpora = append(corpora, corpus)
+	}
+
+	algorithms := []string{"huffman", "lzss", "flate", "gzip"}
+	report := Run(corpora, algorithms)
+	if len(report.Results) != len(corpora)*len(algorithms) {
+		t.Fatalf("got %d results, want %d", len(report.Results), len(corpora)*len(algorithms))
+	}
+	for _, result := range report.Results {
+		if result.Error != "" {
+			t.Errorf("%s/%s with %s: %s", result.Corpus, result.File, result.Algorithm, result.Error)
+		}
+	}
+}
+
+// TestRegressionCorpus compares every algorithm with the embedded baselines.
+// Durations depend on the machine the tests run on, so only the sizes are
+// held to them here. Record new baselines with
+// "go run . regress -update-baselines internal/bench/baselines.json" after a
+// change that is meant to alter the output.
+func TestRegressionCorpus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs every algorithm over the regression corpus")
+	}
+	report, err := RunRegression(nil, Tolerance{Time: math.Inf(1)})
+	if err != nil {
+		t.Fatalf("RunRegression: %v", err)
+	}
+	for _, delta := range report.Deltas {
+		if delta.Regressed {
+			t.Errorf("%s with %s: %v", delta.File, delta.Algorithm, delta.Reasons)
+		} else if !delta.HasBaseline {
+			t.Logf("%s with %s has no baseline", delta.File, delta.Algorithm)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	report := &Report{Results: []Result{
+		{File: "a", Algorithm: "flate", CompressedSize: 100, CompressDuration: 10 * time.Millisecond, RoundTrip: true},
+		{File: "a", Algorithm: "gzip", CompressedSize: 110, CompressDuration: 10 * time.Millisecond, RoundTrip: true},
+		{File: "a", Algorithm: "zlib", CompressedSize: 100, CompressDuration: 40 * time.Millisecond, RoundTrip: true},
+		{File: "b", Algorithm: "flate", CompressedSize: 50, RoundTrip: true},
+		{File: "a", Algorithm: "lzss", Error: "decompressed output does not match the input"},
+	}}
+	baselines := NewBaselines(&Report{Results: []Result{
+		{File: "a", Algorithm: "flate", CompressedSize: 100, CompressDuration: 10 * time.Millisecond, RoundTrip: true},
+		{File: "a", Algorithm: "gzip", CompressedSize: 100, CompressDuration: 10 * time.Millisecond, RoundTrip: true},
+		{File: "a", Algorithm: "zlib", CompressedSize: 100, CompressDuration: 10 * time.Millisecond, RoundTrip: true},
+		{File: "a", Algorithm: "lzss", CompressedSize: 100, RoundTrip: true},
+	}})
+
+	regression := Compare(report, baselines, DefaultTolerance)
+	regressed := map[string]bool{}
+	for _, delta := range regression.Deltas {
+		regressed[delta.File+"/"+delta.Algorithm] = delta.Regressed
+	}
+	want := map[string]bool{"a/flate": false, "a/gzip": true, "a/zlib": true, "b/flate": false, "a/lzss": true}
+	for key, wantRegressed := range want {
+		if regressed[key] != wantRegressed {
+			t.Errorf("%s regressed = %v, want %v", key, regressed[key], wantRegressed)
+		}
+	}
+	if regression.Regressions != 3 || !regression.Deltas[0].Regressed {
+		t.Errorf("got %d regressions, first %+v", regression.Regressions, regression.Deltas[0])
+	}
+}
@@ -0,0 +1,221 @@
+// Package bench runs the registered compression algorithms over standard
+// corpora and reports sizes, throughput and round-trip results in a
+// machine-readable form. It backs the "bench" command of the service binary
+// and the regression tests.
+package bench
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	canterburyURL = "https://corpus.canterbury.ac.nz/resources/cantrbry.tar.gz"
+	enwik8URL     = "http://mattmahoney.net/dc/enwik8.zip"
+
+	// DefaultGeneratedSize is the size of the random and zeros corpora
+	DefaultGeneratedSize = 64 * 1024
+	// DefaultEnwik8Size is how much of enwik8 is used, the full 100MB takes far too long
+	DefaultEnwik8Size = 1024 * 1024
+)
+
+// File is a single input of a corpus
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Corpus is a named set of inputs that are benchmarked together
+type Corpus struct {
+	Name  string
+	Files []File
+}
+
+// Size returns the total number of bytes in the corpus
+func (c *Corpus) Size() int {
+	total := 0
+	for _, file := range c.Files {
+		total += len(file.Data)
+	}
+	return total
+}
+
+// CorpusOptions controls where downloaded corpora are cached and how large
+// the generated or truncated ones are
+type CorpusOptions struct {
+	CacheDir      string
+	GeneratedSize int
+	Enwik8Size    int
+}
+
+func (o CorpusOptions) withDefaults() CorpusOptions {
+	if o.CacheDir == "" {
+		o.CacheDir = filepath.Join(os.TempDir(), "compression-bench")
+	}
+	if o.GeneratedSize <= 0 {
+		o.GeneratedSize = DefaultGeneratedSize
+	}
+	if o.Enwik8Size <= 0 {
+		o.Enwik8Size = DefaultEnwik8Size
+	}
+	return o
+}
+
+// corpusLoaders maps corpus names to the function producing them
+var corpusLoaders = map[string]func(options CorpusOptions) (*Corpus, error){
+	"canterbury": loadCanterbury,
+	"enwik8":     loadEnwik8,
+	"random":     generateRandom,
+	"zeros":      generateZeros,
+}
+
+// CorpusNames returns the names of all known corpora
+func CorpusNames() []string {
+	names := make([]string, 0, len(corpusLoaders))
+	for name := range corpusLoaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadCorpus returns the named corpus, downloading it into the cache
+// directory first if it is not there yet
+func LoadCorpus(name string, options CorpusOptions) (*Corpus, error) {
+	loader, ok := corpusLoaders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown corpus: %s (known: %s)", name, strings.Join(CorpusNames(), ", "))
+	}
+	return loader(options.withDefaults())
+}
+
+func generateRandom(options CorpusOptions) (*Corpus, error) {
+	// fixed seed, results have to be comparable between runs
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, options.GeneratedSize)
+	rnd.Read(data)
+	return &Corpus{Name: "random", Files: []File{{Name: "random.bin", Data: data}}}, nil
+}
+
+func generateZeros(options CorpusOptions) (*Corpus, error) {
+	return &Corpus{Name: "zeros", Files: []File{{Name: "zeros.bin", Data: make([]byte, options.GeneratedSize)}}}, nil
+}
+
+func loadCanterbury(options CorpusOptions) (*Corpus, error) {
+	path, err := fetch(canterburyURL, options.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer gzipReader.Close()
+
+	corpus := &Corpus{Name: "canterbury"}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %w", header.Name, path, err)
+		}
+		corpus.Files = append(corpus.Files, File{Name: filepath.Base(header.Name), Data: data})
+	}
+	sort.Slice(corpus.Files, func(i, j int) bool {
+		return corpus.Files[i].Name < corpus.Files[j].Name
+	})
+	return corpus, nil
+}
+
+func loadEnwik8(options CorpusOptions) (*Corpus, error) {
+	path, err := fetch(enwik8URL, options.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer archive.Close()
+	for _, entry := range archive.File {
+		if entry.Name != "enwik8" {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		data, err := io.ReadAll(io.LimitReader(content, int64(options.Enwik8Size)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read enwik8 from %s: %w", path, err)
+		}
+		return &Corpus{Name: "enwik8", Files: []File{{Name: "enwik8", Data: data}}}, nil
+	}
+	return nil, fmt.Errorf("%s does not contain enwik8", path)
+}
+
+// fetch downloads url into dir unless a previous run already did, and returns
+// the path of the local copy
+func fetch(url, dir string) (string, error) {
+	path := filepath.Join(dir, filepath.Base(url))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	// write to a temporary file first so an interrupted download is not cached
+	tmp, err := os.CreateTemp(dir, filepath.Base(url)+".*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// The regression corpus is small, mixed input embedded in the binary, so the
+// regression check runs wherever the service does, without downloads
+//
+//go:embed regression
+var regressionFiles embed.FS
+
+// The baselines the regression corpus is compared against, recorded with
+// the "regress -update-baselines" command
+//
+//go:embed baselines.json
+var baselinesJSON []byte
+
+// BaselinesPath is where the embedded baselines live in the source tree
+const BaselinesPath = "internal/bench/baselines.json"
+
+// RegressionCorpus returns the corpus embedded in the binary
+func RegressionCorpus() (*Corpus, error) {
+	entries, err := fs.ReadDir(regressionFiles, "regression")
+	if err != nil {
+		return nil, err
+	}
+	corpus := &Corpus{Name: "regression"}
+	for _, entry := range entries {
+		data, err := regressionFiles.ReadFile(path.Join("regression", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		corpus.Files = append(corpus.Files, File{Name: entry.Name(), Data: data})
+	}
+	return corpus, nil
+}
+
+// Baseline is what an algorithm did on a file of the regression corpus when
+// the baselines were recorded
+type Baseline struct {
+	CompressedSize     int           `json:"compressed_size"`
+	CompressDuration   time.Duration `json:"compress_ns"`
+	DecompressDuration time.Duration `json:"decompress_ns"`
+}
+
+// Baselines are the results a regression run is compared against, with the
+// environment they were recorded in, since durations only compare on
+// similar machines
+type Baselines struct {
+	RecordedAt time.Time                      `json:"recorded_at"`
+	GoVersion  string                         `json:"go_version"`
+	GOOS       string                         `json:"goos"`
+	GOARCH     string                         `json:"goarch"`
+	NumCPU     int                            `json:"num_cpu"`
+	Files      map[string]map[string]Baseline `json:"files"` // by file, then by algorithm
+}
+
+// LoadBaselines returns the baselines embedded in the binary
+func LoadBaselines() (*Baselines, error) {
+	var baselines Baselines
+	if err := json.Unmarshal(baselinesJSON, &baselines); err != nil {
+		return nil, fmt.Errorf("failed to read the embedded baselines: %w", err)
+	}
+	return &baselines, nil
+}
+
+// NewBaselines records the results of a report as baselines, leaving out
+// those that did not round-trip
+func NewBaselines(report *Report) *Baselines {
+	baselines := &Baselines{
+		RecordedAt: report.StartedAt,
+		GoVersion:  report.GoVersion,
+		GOOS:       report.GOOS,
+		GOARCH:     report.GOARCH,
+		NumCPU:     report.NumCPU,
+		Files:      make(map[string]map[string]Baseline),
+	}
+	for _, result := range report.Results {
+		if !result.RoundTrip {
+			continue
+		}
+		if baselines.Files[result.File] == nil {
+			baselines.Files[result.File] = make(map[string]Baseline)
+		}
+		baselines.Files[result.File][result.Algorithm] = Baseline{
+			CompressedSize:     result.CompressedSize,
+			CompressDuration:   result.CompressDuration,
+			DecompressDuration: result.DecompressDuration,
+		}
+	}
+	return baselines
+}
+
+// WriteJSON writes the baselines as indented JSON
+func (b *Baselines) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(b)
+}
+
+// Tolerance is how far a result may fall behind its baseline before it
+// counts as a regression
+type Tolerance struct {
+	Size float64 `json:"size"` // fraction the compressed size may grow by
+	Time float64 `json:"time"` // fraction either duration may grow by
+	// MinTime is how much longer than its baseline a duration has to be on
+	// top of Time, so the noise of very short runs is not reported
+	MinTime time.Duration `json:"min_time_ns"`
+}
+
+// DefaultTolerance allows no growth of the output, whose size does not
+// depend on the machine, and durations up to twice their baseline
+var DefaultTolerance = Tolerance{Size: 0, Time: 1, MinTime: 5 * time.Millisecond}
+
+// Delta compares a result with its baseline. Changes are relative, 0.05 is
+// 5% more than the baseline.
+type Delta struct {
+	File                 string   `json:"file"`
+	Algorithm            string   `json:"algorithm"`
+	HasBaseline          bool     `json:"has_baseline"` // false for files or algorithms added since the baselines
+	CompressedSize       int      `json:"compressed_size"`
+	BaselineSize         int      `json:"baseline_size"`
+	SizeChange           float64  `json:"size_change"`
+	CompressTimeChange   float64  `json:"compress_time_change"`
+	DecompressTimeChange float64  `json:"decompress_time_change"`
+	Regressed            bool     `json:"regressed"`
+	Reasons              []string `json:"reasons,omitempty"`
+}
+
+// RegressionReport is a run over the regression corpus compared with the
+// baselines
+type RegressionReport struct {
+	Report              *Report   `json:"report"`
+	Tolerance           Tolerance `json:"tolerance"`
+	BaselinesRecordedAt time.Time `json:"baselines_recorded_at"`
+	Deltas              []Delta   `json:"deltas"`
+	Regressions         int       `json:"regressions"`
+}
+
+// RunRegression runs the algorithms, all supported ones when none are
+// given, over the embedded corpus and compares the results with the
+// embedded baselines
+func RunRegression(algorithms []string, tolerance Tolerance) (*RegressionReport, error) {
+	corpus, err := RegressionCorpus()
+	if err != nil {
+		return nil, err
+	}
+	baselines, err := LoadBaselines()
+	if err != nil {
+		return nil, err
+	}
+	return Compare(Run([]*Corpus{corpus}, algorithms), baselines, tolerance), nil
+}
+
+// Compare compares every result of a report with its baseline. A result
+// regressed when it did not round-trip or grew past the tolerance, one
+// without a baseline only when it did not round-trip.
+func Compare(report *Report, baselines *Baselines, tolerance Tolerance) *RegressionReport {
+	regression := &RegressionReport{Report: report, Tolerance: tolerance, BaselinesRecordedAt: baselines.RecordedAt}
+	for _, result := range report.Results {
+		delta := Delta{File: result.File, Algorithm: result.Algorithm, CompressedSize: result.CompressedSize}
+		if !result.RoundTrip {
+			delta.Reasons = append(delta.Reasons, "does not round-trip: "+result.Error)
+		}
+		baseline, ok := baselines.Files[result.File][result.Algorithm]
+		if ok && result.RoundTrip {
+			delta.HasBaseline = true
+			delta.BaselineSize = baseline.CompressedSize
+			delta.SizeChange = change(float64(result.CompressedSize), float64(baseline.CompressedSize))
+			delta.CompressTimeChange = change(float64(result.CompressDuration), float64(baseline.CompressDuration))
+			delta.DecompressTimeChange = change(float64(result.DecompressDuration), float64(baseline.DecompressDuration))
+			if delta.SizeChange > tolerance.Size {
+				delta.Reasons = append(delta.Reasons, fmt.Sprintf("output %d bytes, %+.1f%% on the baseline", result.CompressedSize, 100*delta.SizeChange))
+			}
+			if delta.CompressTimeChange > tolerance.Time && result.CompressDuration-baseline.CompressDuration > tolerance.MinTime {
+				delta.Reasons = append(delta.Reasons, fmt.Sprintf("compression took %s, %+.0f%% on the baseline", result.CompressDuration, 100*delta.CompressTimeChange))
+			}
+			if delta.DecompressTimeChange > tolerance.Time && result.DecompressDuration-baseline.DecompressDuration > tolerance.MinTime {
+				delta.Reasons = append(delta.Reasons, fmt.Sprintf("decompression took %s, %+.0f%% on the baseline", result.DecompressDuration, 100*delta.DecompressTimeChange))
+			}
+		}
+		delta.Regressed = len(delta.Reasons) > 0
+		if delta.Regressed {
+			regression.Regressions++
+		}
+		regression.Deltas = append(regression.Deltas, delta)
+	}
+	sort.SliceStable(regression.Deltas, func(i, j int) bool {
+		return regression.Deltas[i].Regressed && !regression.Deltas[j].Regressed
+	})
+	return regression
+}
+
+// change is how much more value is than baseline, relative to baseline
+func change(value, baseline float64) float64 {
+	if baseline == 0 {
+		return 0
+	}
+	return value/baseline - 1
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *RegressionReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteTable writes the deltas as an aligned, human-readable table,
+// regressions first
+func (r *RegressionReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tALGORITHM\tCOMPRESSED\tBASELINE\tSIZE\tCOMP TIME\tDECOMP TIME\tSTATUS")
+	for _, delta := range r.Deltas {
+		status := "ok"
+		switch {
+		case delta.Regressed:
+			status = "regressed: " + delta.Reasons[0]
+			for _, reason := range delta.Reasons[1:] {
+				status += "; " + reason
+			}
+		case !delta.HasBaseline:
+			status = "no baseline"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%+.1f%%\t%+.0f%%\t%+.0f%%\t%s\n",
+			delta.File, delta.Algorithm, delta.CompressedSize, delta.BaselineSize,
+			100*delta.SizeChange, 100*delta.CompressTimeChange, 100*delta.DecompressTimeChange, status)
+	}
+	fmt.Fprintf(tw, "\n%d of %d results regressed, baselines recorded %s\n",
+		r.Regressions, len(r.Deltas), r.BaselinesRecordedAt.Format(time.RFC3339))
+	return tw.Flush()
+}
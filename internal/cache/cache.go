@@ -0,0 +1,361 @@
+// Package cache keeps results that are expensive to compute again, such as
+// the output of a decompression, in a least-recently-used cache bounded by
+// the bytes it holds. The values are held in memory, or in a directory when
+// the cache has one, so a large budget does not cost memory and the cache
+// outlives a restart. A key may start with a namespace, see Key, which the
+// cache holds to a quota of its own besides the budget of the whole cache.
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+)
+
+// validKey is what keys are made of, they name the files of a cache on disk
+// and their namespace the directory the files are in
+var validKey = regexp.MustCompile(`^(?:[0-9A-Za-z_-]{1,64}/)?[0-9A-Za-z_-]{1,128}$`)
+
+// validNamespace is what the directories of namespaces are named
+var validNamespace = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+
+// ErrInvalidKey is returned by Put for keys that could not name a file
+var ErrInvalidKey = errors.New("cache: invalid key")
+
+// Stats tells how the cache is doing
+type Stats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+	Quota    int64 `json:"quota,omitempty"` // bytes per namespace, 0 for none
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+// Usage is what a namespace takes up of its quota
+type Usage struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Quota   int64 `json:"quota,omitempty"` // 0 for none
+}
+
+type entry struct {
+	key      string
+	size     int64
+	space    string // the namespace of the key
+	value    []byte // nil when the value is in a file
+	lastUsed time.Time
+}
+
+// Cache maps keys to values and drops the least recently used values once
+// they take up more than the budget, or the values of a namespace once they
+// take up more than its quota. A value larger than the whole budget or the
+// quota is not kept.
+type Cache struct {
+	maxBytes int64
+	dir      string
+
+	lock    sync.Mutex
+	size    int64
+	quota   int64
+	used    map[string]int64 // bytes by namespace
+	order   *list.List       // of *entry, most recently used first
+	entries map[string]*list.Element
+
+	hits, misses atomic.Int64
+}
+
+// New returns a cache holding up to maxBytes of values. With a dir they are
+// kept there, and the values a previous cache left in it are taken over.
+func New(maxBytes int64, dir string) (*Cache, error) {
+	c := &Cache{maxBytes: maxBytes, dir: dir, used: make(map[string]int64), order: list.New(), entries: make(map[string]*list.Element)}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cache: %w", err)
+	}
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	var scan func(namespace string) error
+	scan = func(namespace string) error {
+		files, err := os.ReadDir(filepath.Join(dir, namespace))
+		if err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		for _, file := range files {
+			if file.IsDir() && namespace == "" && validNamespace.MatchString(file.Name()) {
+				if err := scan(file.Name()); err != nil {
+					return err
+				}
+				continue
+			}
+			info, err := file.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			key := Key(namespace, file.Name())
+			if !validKey.MatchString(key) {
+				// what a write that did not finish left behind
+				os.Remove(c.path(key))
+				continue
+			}
+			existing = append(existing, found{key, info.Size(), info.ModTime()})
+		}
+		return nil
+	}
+	if err := scan(""); err != nil {
+		return nil, err
+	}
+	// the most recently written are the ones kept when the budget shrank
+	slices.SortFunc(existing, func(a, b found) int { return b.modTime.Compare(a.modTime) })
+	for _, f := range existing {
+		if c.size+f.size > maxBytes {
+			os.Remove(c.path(f.key))
+			continue
+		}
+		e := &entry{key: f.key, size: f.size, space: namespaceOf(f.key), lastUsed: f.modTime}
+		c.entries[f.key] = c.order.PushBack(e)
+		c.size += f.size
+		c.used[e.space] += f.size
+	}
+	return c, nil
+}
+
+// Key returns key in namespace, for Get, Put and Remove. Keys of the empty
+// namespace are left as they are.
+func Key(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + "/" + key
+}
+
+// namespaceOf returns the namespace key starts with
+func namespaceOf(key string) string {
+	namespace, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+// SetQuota limits the bytes the values of each namespace may take up
+// together, 0 for no limit. A namespace past it drops its least recently
+// used values on its next Put.
+func (c *Cache) SetQuota(quota int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.quota = quota
+}
+
+// Get returns the value of key and marks it as recently used
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.lock.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	e := element.Value.(*entry)
+	e.lastUsed = time.Now()
+	c.lock.Unlock()
+	if e.value != nil {
+		c.hits.Add(1)
+		return e.value, true
+	}
+
+	value, err := os.ReadFile(c.path(key))
+	if err != nil || int64(len(value)) != e.size {
+		// the file is gone or was changed under the cache
+		c.lock.Lock()
+		if c.entries[key] == element {
+			c.remove(element)
+		}
+		c.lock.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return value, true
+}
+
+// Put stores value under key, replacing what was stored under it, and drops
+// the least recently used values to make room. The cache keeps value, it
+// must not be modified afterwards.
+func (c *Cache) Put(key string, value []byte) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	size := int64(len(value))
+	if size > c.maxBytes || c.overQuota(size) {
+		return nil
+	}
+	e := &entry{key: key, size: size, space: namespaceOf(key), value: value, lastUsed: time.Now()}
+	if c.dir != "" {
+		if err := c.write(key, value); err != nil {
+			return err
+		}
+		e.value = nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		// the file was replaced already, only the entry goes
+		c.size -= element.Value.(*entry).size
+		c.used[e.space] -= element.Value.(*entry).size
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	if c.quota > 0 {
+		// the namespace makes room among its own values first
+		for element := c.order.Back(); element != nil && c.used[e.space]+size > c.quota; {
+			previous := element.Prev()
+			if element.Value.(*entry).space == e.space {
+				c.remove(element)
+			}
+			element = previous
+		}
+	}
+	for c.size+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(e)
+	c.size += size
+	c.used[e.space] += size
+	return nil
+}
+
+// overQuota reports whether a value of size is larger than the quota
+func (c *Cache) overQuota(size int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.quota > 0 && size > c.quota
+}
+
+// write replaces the file of key through a temporary file, whose name is no
+// valid key, so a write that fails part way is never taken for a value
+func (c *Cache) write(key string, value []byte) error {
+	dir, name := filepath.Split(c.path(key))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	_, err = file.Write(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("cache: %w", err)
+	}
+	return nil
+}
+
+// remove drops an entry and its file, the lock has to be held
+func (c *Cache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if c.used[e.space] -= e.size; c.used[e.space] == 0 {
+		delete(c.used, e.space)
+	}
+	if c.dir != "" {
+		os.Remove(c.path(e.key))
+	}
+}
+
+// Objects lists the values with when they were last put or got, so a
+// janitor.Janitor can drop those that went unused for a while
+func (c *Cache) Objects() ([]janitor.Object, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	objects := make([]janitor.Object, 0, len(c.entries))
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		e := element.Value.(*entry)
+		objects = append(objects, janitor.Object{Name: e.key, Size: e.size, LastUsed: e.lastUsed})
+	}
+	return objects, nil
+}
+
+// List returns the values of namespace as Objects does, named by their key
+// without the namespace
+func (c *Cache) List(namespace string) []janitor.Object {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	objects := []janitor.Object{}
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		if e := element.Value.(*entry); e.space == namespace {
+			name := strings.TrimPrefix(e.key, Key(namespace, ""))
+			objects = append(objects, janitor.Object{Name: name, Size: e.size, LastUsed: e.lastUsed})
+		}
+	}
+	return objects
+}
+
+// Usage returns how many values namespace holds and what they take up of
+// the quota
+func (c *Cache) Usage(namespace string) Usage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	usage := Usage{Bytes: c.used[namespace], Quota: c.quota}
+	for _, element := range c.entries {
+		if element.Value.(*entry).space == namespace {
+			usage.Entries++
+		}
+	}
+	return usage
+}
+
+// Remove drops the value of key, if it is still there
+func (c *Cache) Remove(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Stats returns the number of values, the bytes they take up and how often
+// Get found what it was asked for
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return Stats{
+		Entries:  len(c.entries),
+		Bytes:    c.size,
+		MaxBytes: c.maxBytes,
+		Quota:    c.quota,
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+	}
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEviction(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		c, err := New(10, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Put("a", []byte("aaaa"))
+		c.Put("b", []byte("bbbb"))
+		// a is used, so b is the one dropped for c
+		if value, ok := c.Get("a"); !ok || !bytes.Equal(value, []byte("aaaa")) {
+			t.Errorf("dir %q: Get(a) = %q, %v", dir, value, ok)
+		}
+		c.Put("c", []byte("cccc"))
+		if _, ok := c.Get("b"); ok {
+			t.Errorf("dir %q: b was not evicted", dir)
+		}
+		if _, ok := c.Get("c"); !ok {
+			t.Errorf("dir %q: c is missing", dir)
+		}
+		// larger than the whole budget, it is not kept and drops nothing
+		c.Put("d", make([]byte, 11))
+		if _, ok := c.Get("d"); ok {
+			t.Errorf("dir %q: a value over the budget was kept", dir)
+		}
+		// replacing a value does not count it twice
+		c.Put("a", []byte("AAAAAA"))
+		if stats := c.Stats(); stats.Entries != 2 || stats.Bytes != 10 || stats.Hits != 2 || stats.Misses != 2 {
+			t.Errorf("dir %q: Stats = %+v", dir, stats)
+		}
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(100, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("e3b0c442-1", []byte("kept on disk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("../escape", []byte("x")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put with a path for a key: %v", err)
+	}
+
+	// a new cache takes over the values, as far as its budget goes
+	reopened, err := New(100, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := reopened.Get("e3b0c442-1"); !ok || string(value) != "kept on disk" {
+		t.Errorf("Get after reopening = %q, %v", value, ok)
+	}
+	smaller, err := New(5, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := smaller.Get("e3b0c442-1"); ok {
+		t.Error("a value over the budget of the new cache was taken over")
+	}
+}
+
+func TestObjects(t *testing.T) {
+	c, err := New(100, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", []byte("aaaa"))
+	c.Put("b", []byte("bb"))
+	c.Get("a")
+	// most recently used first
+	objects, _ := c.Objects()
+	if len(objects) != 2 || objects[0].Name != "a" || objects[0].Size != 4 || objects[1].Name != "b" ||
+		objects[0].LastUsed.Before(objects[1].LastUsed) {
+		t.Errorf("Objects = %+v", objects)
+	}
+	if err := c.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("a was not removed")
+	}
+	if err := c.Remove("a"); err != nil {
+		t.Errorf("removing a again: %v", err)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != 2 {
+		t.Errorf("Stats after Remove = %+v", stats)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(100, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetQuota(10)
+	c.Put(Key("key-a", "1"), []byte("aaaa"))
+	c.Put(Key("key-b", "1"), []byte("bbbbbbbb"))
+	c.Put(Key("key-a", "2"), []byte("aaaa"))
+	if _, ok := c.Get(Key("key-b", "2")); ok {
+		t.Error("Get found a key that was put in another namespace")
+	}
+
+	// key-a drops its own least recently used value, not the one of key-b
+	c.Put(Key("key-a", "3"), []byte("aaaa"))
+	if _, ok := c.Get(Key("key-a", "1")); ok {
+		t.Error("key-a went past its quota")
+	}
+	if _, ok := c.Get(Key("key-b", "1")); !ok {
+		t.Error("key-b lost a value to the quota of key-a")
+	}
+	// larger than the quota, it is not kept
+	c.Put(Key("key-a", "4"), make([]byte, 11))
+	if usage := c.Usage("key-a"); usage.Entries != 2 || usage.Bytes != 8 || usage.Quota != 10 {
+		t.Errorf("Usage = %+v", usage)
+	}
+	if list := c.List("key-a"); len(list) != 2 || list[0].Name != "3" || list[1].Name != "2" {
+		t.Errorf("List = %+v", list)
+	}
+
+	// the namespaces are directories, taken over by a new cache
+	reopened, err := New(100, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := reopened.Get(Key("key-b", "1")); !ok || string(value) != "bbbbbbbb" {
+		t.Errorf("Get after reopening = %q, %v", value, ok)
+	}
+	if usage := reopened.Usage("key-a"); usage.Entries != 2 || usage.Bytes != 8 {
+		t.Errorf("Usage after reopening = %+v", usage)
+	}
+}
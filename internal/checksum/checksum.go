@@ -0,0 +1,145 @@
+// Package checksum computes the checksums the service writes and verifies:
+// CRC-32 for gzip trailers, containers, seekable frames and deltas, Adler-32
+// for zlib trailers, and CRC-32C, xxHash64 and SHA-256 for clients that want
+// a faster or a stronger check of their files.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum/crc"
+)
+
+// The algorithms New knows
+const (
+	CRC32    = "crc32"
+	CRC32C   = "crc32c"
+	Adler32  = "adler32"
+	XXHash64 = "xxhash64"
+	SHA256   = "sha256"
+)
+
+// Algorithms lists every algorithm, the cheap ones first
+var Algorithms = []string{CRC32, CRC32C, Adler32, XXHash64, SHA256}
+
+// New returns a hash for one of Algorithms
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case CRC32:
+		return NewCRC32(), nil
+	case CRC32C:
+		return crc.New(crc.Castagnoli), nil
+	case Adler32:
+		return NewAdler32(), nil
+	case XXHash64:
+		return NewXXHash64(), nil
+	case SHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+}
+
+// NewCRC32 returns the IEEE CRC-32 of gzip, zip and the service's own formats
+func NewCRC32() hash.Hash32 {
+	return crc.New(crc.IEEE)
+}
+
+// NewAdler32 returns the Adler-32 of zlib
+func NewAdler32() hash.Hash32 {
+	return adler32.New()
+}
+
+// SumCRC32 returns the IEEE CRC-32 of data
+func SumCRC32(data []byte) uint32 {
+	return crc.Checksum(data, crc.IEEE)
+}
+
+// SumAdler32 returns the Adler-32 of data, which is also how zlib identifies
+// a preset dictionary
+func SumAdler32(data []byte) uint32 {
+	return adler32.Checksum(data)
+}
+
+// CombineCRC32 returns the IEEE CRC-32 of two runs joined together from the
+// CRC-32 of each and the length of the second
+func CombineCRC32(crc1, crc2 uint32, len2 int64) uint32 {
+	return crc.Combine(crc.IEEE, crc1, crc2, len2)
+}
+
+// ParseAlgorithms splits a comma-separated list of algorithms, an empty list
+// gives all of them
+func ParseAlgorithms(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return Algorithms, nil
+	}
+	var algorithms []string
+	for _, algorithm := range strings.Split(list, ",") {
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if _, err := New(algorithm); err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms, nil
+}
+
+// Sums is a set of hashes fed the same data
+type Sums struct {
+	algorithms []string
+	hashes     []hash.Hash
+	size       int64
+}
+
+// NewSums returns hashes for algorithms, which have to be valid
+func NewSums(algorithms []string) (*Sums, error) {
+	sums := &Sums{algorithms: algorithms}
+	for _, algorithm := range algorithms {
+		h, err := New(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		sums.hashes = append(sums.hashes, h)
+	}
+	return sums, nil
+}
+
+func (s *Sums) Write(p []byte) (int, error) {
+	for _, h := range s.hashes {
+		h.Write(p)
+	}
+	s.size += int64(len(p))
+	return len(p), nil
+}
+
+// Size returns the number of bytes written
+func (s *Sums) Size() int64 {
+	return s.size
+}
+
+// Hex returns every checksum in hex, by algorithm
+func (s *Sums) Hex() map[string]string {
+	sums := make(map[string]string, len(s.hashes))
+	for i, h := range s.hashes {
+		sums[s.algorithms[i]] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// Compute reads r to the end and returns its checksums in hex along with its
+// size
+func Compute(r io.Reader, algorithms []string) (map[string]string, int64, error) {
+	sums, err := NewSums(algorithms)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.Copy(sums, r); err != nil {
+		return nil, 0, err
+	}
+	return sums.Hex(), sums.Size(), nil
+}
@@ -0,0 +1,82 @@
+package checksum
+
+import (
+	"bytes"
+	"hash/crc32"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	// values printed by the reference implementation, xxhsum -H1
+	vectors := map[string]string{
+		"":    "ef46db3751d8e999",
+		"a":   "d24ec4f1a98c6e5b",
+		"abc": "44bc2cf5ad770999",
+		"Nobody inspects the spammish repetition": "fbcea83c8a378bf1",
+	}
+	for input, want := range vectors {
+		sums, _, err := Compute(strings.NewReader(input), []string{XXHash64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sums[XXHash64] != want {
+			t.Errorf("xxhash64(%q) = %s, want %s", input, sums[XXHash64], want)
+		}
+	}
+
+	// the sum does not depend on how the input is split into writes
+	data := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 30)
+	whole := NewXXHash64()
+	whole.Write(data)
+	for _, size := range []int{1, 7, 31, 32, 33, 100} {
+		split := NewXXHash64()
+		for rest := data; len(rest) > 0; rest = rest[min(size, len(rest)):] {
+			split.Write(rest[:min(size, len(rest))])
+		}
+		if split.Sum64() != whole.Sum64() {
+			t.Errorf("writes of %d bytes gave %x, want %x", size, split.Sum64(), whole.Sum64())
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	sums, size, err := Compute(strings.NewReader("123456789"), Algorithms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the check values of the CRC catalogue and of RFC 1950
+	want := map[string]string{
+		CRC32:   "cbf43926",
+		CRC32C:  "e3069283",
+		Adler32: "091e01de",
+		SHA256:  "15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225",
+	}
+	for algorithm, sum := range want {
+		if sums[algorithm] != sum {
+			t.Errorf("%s(123456789) = %s, want %s", algorithm, sums[algorithm], sum)
+		}
+	}
+	if size != 9 {
+		t.Errorf("size = %d, want 9", size)
+	}
+	if _, err := ParseAlgorithms("crc32, md5"); err == nil {
+		t.Error("ParseAlgorithms accepted md5")
+	}
+}
+
+// TestCombineCRC32 checks the CRC-32 of joined runs against hashing them
+// joined, as the CRC of gzip chunks compressed apart is merged
+func TestCombineCRC32(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, sizes := range [][2]int{{0, 0}, {0, 5}, {5, 0}, {1, 1}, {1000, 3}, {3, 1 << 16}, {1 << 16, 1<<20 + 7}} {
+		a, b := make([]byte, sizes[0]), make([]byte, sizes[1])
+		random.Read(a)
+		random.Read(b)
+		want := crc32.ChecksumIEEE(append(append([]byte{}, a...), b...))
+		if got := CombineCRC32(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), int64(len(b))); got != want {
+			t.Errorf("%d and %d bytes combined to %08x, want %08x", len(a), len(b), got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package crc computes CRC-32 checksums incrementally and joins the checksums
+// of runs computed apart, so chunks hashed on different goroutines can share
+// one checksum without being hashed again. The IEEE and Castagnoli
+// polynomials go through hash/crc32, which uses the CPU's CRC or carry-less
+// multiply instructions where it has them and slicing-by-8 tables elsewhere;
+// any other polynomial is sliced by 8 here.
+package crc
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+)
+
+// Table is a polynomial with what it takes to checksum and combine with it
+type Table struct {
+	poly     uint32
+	std      *crc32.Table // the tables hash/crc32 accelerates, nil for others
+	slicing8 *[8][256]uint32
+	x2n      [32]uint32 // x2n[n] holds x^(2^n) mod poly
+}
+
+// The polynomials hash/crc32 accelerates
+var (
+	IEEE       = newTable(crc32.IEEE, crc32.IEEETable)
+	Castagnoli = newTable(crc32.Castagnoli, crc32.MakeTable(crc32.Castagnoli))
+)
+
+// MakeTable returns the Table of a polynomial in reversed bit order, one of
+// IEEE and Castagnoli for theirs
+func MakeTable(poly uint32) *Table {
+	switch poly {
+	case crc32.IEEE:
+		return IEEE
+	case crc32.Castagnoli:
+		return Castagnoli
+	}
+	return newTable(poly, nil)
+}
+
+func newTable(poly uint32, std *crc32.Table) *Table {
+	tab := &Table{poly: poly, std: std}
+	if std == nil {
+		tab.slicing8 = new([8][256]uint32)
+		for i := range 256 {
+			crc := uint32(i)
+			for range 8 {
+				if crc&1 == 1 {
+					crc = crc>>1 ^ poly
+				} else {
+					crc >>= 1
+				}
+			}
+			tab.slicing8[0][i] = crc
+		}
+		for i := range 256 {
+			crc := tab.slicing8[0][i]
+			for k := 1; k < 8; k++ {
+				crc = tab.slicing8[0][crc&0xff] ^ crc>>8
+				tab.slicing8[k][i] = crc
+			}
+		}
+	}
+	p := uint32(1) << 30 // x^1
+	tab.x2n[0] = p
+	for n := 1; n < len(tab.x2n); n++ {
+		p = tab.multModP(p, p)
+		tab.x2n[n] = p
+	}
+	return tab
+}
+
+// Update returns crc updated with the bytes of p
+func Update(crc uint32, tab *Table, p []byte) uint32 {
+	if tab.std != nil {
+		return crc32.Update(crc, tab.std, p)
+	}
+	t := tab.slicing8
+	crc = ^crc
+	for len(p) >= 8 {
+		crc ^= binary.LittleEndian.Uint32(p)
+		crc = t[0][p[7]] ^ t[1][p[6]] ^ t[2][p[5]] ^ t[3][p[4]] ^
+			t[4][crc>>24] ^ t[5][crc>>16&0xff] ^ t[6][crc>>8&0xff] ^ t[7][crc&0xff]
+		p = p[8:]
+	}
+	for _, b := range p {
+		crc = t[0][byte(crc)^b] ^ crc>>8
+	}
+	return ^crc
+}
+
+// Checksum returns the CRC-32 of data
+func Checksum(data []byte, tab *Table) uint32 {
+	return Update(0, tab, data)
+}
+
+// Combine returns the CRC-32 of two runs of bytes joined together, given the
+// CRC of each run and the length of the second one
+func Combine(tab *Table, crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+	// shifting crc1 over len2 zero bytes is a multiplication by x^(8*len2)
+	return tab.multModP(tab.x2nModP(len2, 3), crc1) ^ crc2
+}
+
+// multModP multiplies two polynomials modulo the polynomial of tab
+func (tab *Table) multModP(a, b uint32) uint32 {
+	m := uint32(1) << 31
+	p := uint32(0)
+	for {
+		if a&m != 0 {
+			p ^= b
+			if a&(m-1) == 0 {
+				break
+			}
+		}
+		m >>= 1
+		if b&1 != 0 {
+			b = b>>1 ^ tab.poly
+		} else {
+			b >>= 1
+		}
+	}
+	return p
+}
+
+// x2nModP returns x^(n * 2^k) mod the polynomial of tab
+func (tab *Table) x2nModP(n int64, k uint) uint32 {
+	p := uint32(1) << 31 // x^0
+	for n != 0 {
+		if n&1 != 0 {
+			p = tab.multModP(tab.x2n[k&31], p)
+		}
+		n >>= 1
+		k++
+	}
+	return p
+}
+
+// Implementation names how data is checksummed with tab on this CPU
+func Implementation(tab *Table) string {
+	if tab.std != nil {
+		if name := hardware(tab); name != "" {
+			return name
+		}
+	}
+	return "slicing-by-8"
+}
+
+// Digest is a running CRC-32 that implements hash.Hash32
+type Digest struct {
+	tab  *Table
+	crc  uint32
+	size int64
+}
+
+var _ hash.Hash32 = (*Digest)(nil)
+
+// New returns a Digest with the polynomial of tab
+func New(tab *Table) *Digest {
+	return &Digest{tab: tab}
+}
+
+func (d *Digest) Write(p []byte) (int, error) {
+	d.crc = Update(d.crc, d.tab, p)
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+// Append adds a run of n bytes whose CRC-32 was computed elsewhere, as if it
+// had been written
+func (d *Digest) Append(crc uint32, n int64) {
+	d.crc = Combine(d.tab, d.crc, crc, n)
+	d.size += n
+}
+
+// Len returns the number of bytes written and appended
+func (d *Digest) Len() int64 { return d.size }
+
+func (d *Digest) Sum32() uint32 { return d.crc }
+
+func (d *Digest) Sum(b []byte) []byte { return binary.BigEndian.AppendUint32(b, d.crc) }
+
+func (d *Digest) Reset() { d.crc, d.size = 0, 0 }
+
+func (d *Digest) Size() int { return crc32.Size }
+
+func (d *Digest) BlockSize() int { return 1 }
@@ -0,0 +1,15 @@
+package crc
+
+import "golang.org/x/sys/cpu"
+
+// hardware names the instructions hash/crc32 uses for tab, the same checks
+// it makes itself
+func hardware(tab *Table) string {
+	switch {
+	case tab == IEEE && cpu.X86.HasPCLMULQDQ && cpu.X86.HasSSE41:
+		return "pclmulqdq"
+	case tab == Castagnoli && cpu.X86.HasSSE42:
+		return "sse4.2"
+	}
+	return ""
+}
@@ -0,0 +1,12 @@
+package crc
+
+import "golang.org/x/sys/cpu"
+
+// hardware names the instructions hash/crc32 uses for tab, the same checks
+// it makes itself
+func hardware(tab *Table) string {
+	if cpu.ARM64.HasCRC32 {
+		return "arm64 crc32"
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package crc
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	data := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50)
+	for _, poly := range []uint32{crc32.IEEE, crc32.Castagnoli, crc32.Koopman} {
+		tab := MakeTable(poly)
+		want := crc32.MakeTable(poly)
+		for _, n := range []int{0, 1, 7, 8, 9, 63, 64, 1000, len(data)} {
+			if got, want := Checksum(data[:n], tab), crc32.Checksum(data[:n], want); got != want {
+				t.Errorf("%s: checksum of %d bytes %08x, want %08x", Implementation(tab), n, got, want)
+			}
+		}
+	}
+	if MakeTable(crc32.IEEE) != IEEE || Implementation(MakeTable(crc32.Koopman)) != "slicing-by-8" {
+		t.Error("the tables hash/crc32 accelerates are not shared")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 40)
+	for _, tab := range []*Table{IEEE, Castagnoli, MakeTable(crc32.Koopman)} {
+		whole := Checksum(data, tab)
+		for _, split := range []int{0, 1, 100, 777, len(data)} {
+			first, second := Checksum(data[:split], tab), Checksum(data[split:], tab)
+			if got := Combine(tab, first, second, int64(len(data)-split)); got != whole {
+				t.Errorf("split at %d combined to %08x, want %08x", split, got, whole)
+			}
+		}
+
+		// a digest fed by writes and by runs hashed elsewhere
+		d := New(tab)
+		d.Write(data[:10])
+		d.Append(Checksum(data[10:500], tab), 490)
+		d.Write(data[500:])
+		if d.Sum32() != whole || d.Len() != int64(len(data)) {
+			t.Errorf("digest summed %08x over %d bytes, want %08x", d.Sum32(), d.Len(), whole)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// The primes of xxHash64, variables so that the arithmetic on them wraps
+// around instead of overflowing as constant expressions
+var (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 is the 64-bit xxHash with a seed of 0. The input is consumed in
+// 32 byte stripes by four lanes, what is left of a stripe waits in buf.
+type xxHash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	buf            [32]byte
+	n              int
+}
+
+// NewXXHash64 returns a 64-bit xxHash, its sum is big-endian like the
+// reference implementation prints it
+func NewXXHash64() hash.Hash64 {
+	h := new(xxHash64)
+	h.Reset()
+	return h
+}
+
+func (h *xxHash64) Reset() {
+	h.v1 = prime1 + prime2
+	h.v2 = prime2
+	h.v3 = 0
+	h.v4 = -prime1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxHash64) Size() int      { return 8 }
+func (h *xxHash64) BlockSize() int { return 32 }
+
+func (h *xxHash64) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(written)
+	if h.n > 0 {
+		filled := copy(h.buf[h.n:], p)
+		h.n += filled
+		p = p[filled:]
+		if h.n < len(h.buf) {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+func (h *xxHash64) stripe(p []byte) {
+	h.v1 = round(h.v1, binary.LittleEndian.Uint64(p))
+	h.v2 = round(h.v2, binary.LittleEndian.Uint64(p[8:]))
+	h.v3 = round(h.v3, binary.LittleEndian.Uint64(p[16:]))
+	h.v4 = round(h.v4, binary.LittleEndian.Uint64(p[24:]))
+}
+
+func (h *xxHash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func (h *xxHash64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) + bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		sum = mergeRound(sum, h.v1)
+		sum = mergeRound(sum, h.v2)
+		sum = mergeRound(sum, h.v3)
+		sum = mergeRound(sum, h.v4)
+	} else {
+		sum = prime5
+	}
+	sum += h.total
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= round(0, binary.LittleEndian.Uint64(p))
+		sum = bits.RotateLeft64(sum, 27)*prime1 + prime4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p)) * prime1
+		sum = bits.RotateLeft64(sum, 23)*prime2 + prime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum ^= uint64(b) * prime5
+		sum = bits.RotateLeft64(sum, 11) * prime1
+	}
+
+	sum ^= sum >> 33
+	sum *= prime2
+	sum ^= sum >> 29
+	sum *= prime3
+	sum ^= sum >> 32
+	return sum
+}
+
+func round(acc, input uint64) uint64 {
+	acc += input * prime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime1
+}
+
+func mergeRound(acc, val uint64) uint64 {
+	acc ^= round(0, val)
+	return acc*prime1 + prime4
+}
@@ -0,0 +1,260 @@
+package compression
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/lzss"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/zlib"
+)
+
+// OptionSchema describes an option an algorithm takes, under the name the
+// API and the form fields use
+type OptionSchema struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"` // "integer", "boolean" or "string"
+	Operation   string   `json:"operation"`
+	Min         *int     `json:"min,omitempty"`
+	Max         *int     `json:"max,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Default     any      `json:"default"`
+	Description string   `json:"description"`
+}
+
+// Features lists what an algorithm supports beyond compressing and
+// decompressing a whole input
+type Features struct {
+	Streaming  bool `json:"streaming"`  // output comes out before the input ends
+	Dictionary bool `json:"dictionary"` // a preset dictionary can prime it
+	Partial    bool `json:"partial"`    // output can stop short of a final block, for streams continued elsewhere
+	Levels     bool `json:"levels"`     // it trades speed for ratio by level
+	Salvage    bool `json:"salvage"`    // truncated input decodes as far as it goes
+	Parallel   bool `json:"parallel"`   // decompression uses several goroutines
+	Concat     bool `json:"concat"`     // payloads compressed one at a time can be appended and split apart again
+}
+
+// Magic is the bytes the output of an algorithm starts with, it is written
+// out in hex
+type Magic []byte
+
+func (m Magic) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(m)), nil
+}
+
+// AlgorithmInfo is what the registry knows about an algorithm
+type AlgorithmInfo struct {
+	Name         string         `json:"name"`
+	Description  string         `json:"description"`
+	Extension    string         `json:"extension"`
+	Magic        Magic          `json:"magic,omitempty"` // none for flate, which has no header
+	MIMEType     string         `json:"mime_type"`
+	Options      []OptionSchema `json:"options"`
+	Features     Features       `json:"features"`
+	Capabilities Capabilities   `json:"capabilities"`
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// options every algorithm takes
+var commonOptions = []OptionSchema{
+	{Name: "filter", Type: "string", Operation: "decompress", Values: []string{FilterLineDelta}, Default: "",
+		Description: "filter the compressed data was passed through, algorithm=auto reports it in X-Compression-Policy"},
+	{Name: "salvage", Type: "boolean", Operation: "decompress", Default: false,
+		Description: "return what was decoded from truncated input"},
+	{Name: "recover", Type: "boolean", Operation: "decompress", Default: false,
+		Description: "skip damaged gzip members and go on with the next one, salvage the other algorithms; X-Damaged-Ranges lists what was skipped"},
+}
+
+// options of the deflate based algorithms
+var deflateOptions = []OptionSchema{
+	{Name: "btype", Type: "integer", Operation: "compress", Min: intPtr(1), Max: intPtr(3), Default: 3,
+		Description: "block type, 1 for fixed and 2 for dynamic Huffman codes, 3 for whichever of stored, fixed and dynamic is smallest per block"},
+	{Name: "block_size", Type: "integer", Operation: "compress", Min: intPtr(0), Default: 0,
+		Description: "the most bytes of input a block covers, 0 leaves blocks to the splitter, up to 64K tokens each"},
+}
+
+// bfinalOption is taken by flate alone, gzip and zlib always end on a final block
+var bfinalOption = OptionSchema{Name: "bfinal", Type: "integer", Operation: "compress", Min: intPtr(0), Max: intPtr(1), Default: 1,
+	Description: "BFINAL bit of the last block, 0 leaves the output a partial stream for more blocks to follow"}
+
+// registeredAlgorithm is an algorithm of the registry along with the factory
+// of its codecs
+type registeredAlgorithm struct {
+	AlgorithmInfo
+	factory AlgorithmFactory
+}
+
+// registry is every algorithm there is, in the order they are listed. The
+// capabilities are as declared, VerifyBinarySupport fills in Verified.
+var registry = []registeredAlgorithm{
+	{
+		AlgorithmInfo: AlgorithmInfo{
+			Name:         "huffman",
+			Description:  "Huffman coding - lossless data compression using variable-length codes",
+			Extension:    "huff",
+			Magic:        huffman.Magic,
+			MIMEType:     "application/octet-stream",
+			Features:     Features{Salvage: true},
+			Capabilities: Capabilities{Format: "huffman (custom container)", BinarySafe: true},
+		},
+		factory: &HuffmanFactory{},
+	},
+	{
+		AlgorithmInfo: AlgorithmInfo{
+			Name:         "lzss",
+			Description:  "Lempel-Ziv-Storer-Szymanski - dictionary-based compression",
+			Extension:    "lzss",
+			Magic:        lzss.Magic,
+			MIMEType:     "application/octet-stream",
+			Features:     Features{Salvage: true},
+			Capabilities: Capabilities{Format: "lzss (custom container)", BinarySafe: true},
+		},
+		factory: &LZSSFactory{},
+	},
+	{
+		AlgorithmInfo: AlgorithmInfo{
+			Name:         "flate",
+			Description:  "DEFLATE - combination of LZ77 and Huffman coding",
+			Extension:    "flate",
+			MIMEType:     "application/octet-stream",
+			Options:      append(append([]OptionSchema{}, deflateOptions...), bfinalOption),
+			Features:     Features{Streaming: true, Dictionary: true, Partial: true, Salvage: true, Concat: true},
+			Capabilities: Capabilities{Format: "raw deflate (RFC 1951)", BinarySafe: true, Interoperable: true},
+		},
+		factory: &FlateFactory{},
+	},
+	{
+		AlgorithmInfo: AlgorithmInfo{
+			Name:        "gzip",
+			Description: "GZIP - wrapper around DEFLATE with headers and checksums",
+			Extension:   "gz",
+			Magic:       gzip.Magic,
+			MIMEType:    "application/gzip",
+			Options: append(append([]OptionSchema{}, deflateOptions...), OptionSchema{
+				Name: "concurrency", Type: "integer", Operation: "decompress", Min: intPtr(0), Default: 0,
+				Description: "gzip members decoded at once, capped at the number of CPUs",
+			}),
+			Features:     Features{Streaming: true, Salvage: true, Parallel: true, Concat: true},
+			Capabilities: Capabilities{Format: "gzip (RFC 1952)", BinarySafe: true, Checksum: true, Interoperable: true},
+		},
+		factory: &GzipFactory{},
+	},
+	{
+		AlgorithmInfo: AlgorithmInfo{
+			Name:         "zlib",
+			Description:  "ZLIB - wrapper around DEFLATE with a short header and an Adler-32 checksum",
+			Extension:    "zz",
+			Magic:        zlib.Magic,
+			MIMEType:     "application/zlib",
+			Options:      deflateOptions,
+			Features:     Features{Streaming: true, Dictionary: true, Salvage: true},
+			Capabilities: Capabilities{Format: "zlib (RFC 1950)", BinarySafe: true, Checksum: true, Interoperable: true},
+		},
+		factory: &ZlibFactory{},
+	},
+}
+
+// lookupAlgorithm returns the entry of the registry for name
+func lookupAlgorithm(name string) (registeredAlgorithm, bool) {
+	for _, entry := range registry {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return registeredAlgorithm{}, false
+}
+
+// factoryFor returns the factory of an algorithm, or an error for names the
+// registry does not know
+func factoryFor(algorithm string) (AlgorithmFactory, error) {
+	entry, ok := lookupAlgorithm(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+	return entry.factory, nil
+}
+
+// algorithmNames returns the names of the registry in its order
+func algorithmNames() []string {
+	names := make([]string, len(registry))
+	for i, entry := range registry {
+		names[i] = entry.Name
+	}
+	return names
+}
+
+// Algorithms returns what the registry knows about every algorithm, in the
+// order of SupportedAlgorithms
+func Algorithms() []AlgorithmInfo {
+	capabilities := VerifyBinarySupport()
+	algorithms := make([]AlgorithmInfo, 0, len(SupportedAlgorithms))
+	for _, name := range SupportedAlgorithms {
+		info, _ := AlgorithmByName(name)
+		info.Capabilities = capabilities[name]
+		algorithms = append(algorithms, info)
+	}
+	return algorithms
+}
+
+// AlgorithmByName returns what the registry knows about an algorithm, with
+// its capabilities as declared, before the self-test verified them
+func AlgorithmByName(name string) (AlgorithmInfo, bool) {
+	entry, ok := lookupAlgorithm(name)
+	if !ok {
+		return AlgorithmInfo{}, false
+	}
+	info := entry.AlgorithmInfo
+	info.Options = append(append([]OptionSchema{}, info.Options...), commonOptions...)
+	return info, true
+}
+
+// AlgorithmByExtension returns the algorithm whose output has the file
+// extension ext, with or without its leading dot
+func AlgorithmByExtension(ext string) (AlgorithmInfo, bool) {
+	ext = strings.TrimPrefix(ext, ".")
+	for _, name := range SupportedAlgorithms {
+		if info, _ := AlgorithmByName(name); ext != "" && strings.EqualFold(info.Extension, ext) {
+			return info, true
+		}
+	}
+	return AlgorithmInfo{}, false
+}
+
+// MIMEType returns the media type of the output of an algorithm, or
+// application/octet-stream for names the registry does not know
+func MIMEType(algorithm string) string {
+	if info, ok := AlgorithmByName(algorithm); ok && info.MIMEType != "" {
+		return info.MIMEType
+	}
+	return "application/octet-stream"
+}
+
+// Extension returns the file extension for the output of an algorithm, or
+// "compressed" for names the registry does not know
+func Extension(algorithm string) string {
+	if info, ok := AlgorithmByName(algorithm); ok && info.Extension != "" {
+		return info.Extension
+	}
+	return "compressed"
+}
+
+// ParseAlgorithms reads a list of algorithm names separated by commas, such
+// as "lzss,huffman", every one of them has to be in the registry
+func ParseAlgorithms(spec string) ([]string, error) {
+	var algorithms []string
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		if !IsValidAlgorithm(name) {
+			return nil, fmt.Errorf("algorithm %q is not one of %v", name, SupportedAlgorithms)
+		}
+		algorithms = append(algorithms, name)
+	}
+	return algorithms, nil
+}
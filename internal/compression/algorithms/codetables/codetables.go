@@ -0,0 +1,118 @@
+// Package codetables holds the alphabets of RFC 1951 whose codes are
+// followed by extra bits: match lengths, match distances and the code
+// lengths of dynamic block headers. The deflate encoder and decoder share
+// them, so the two directions cannot drift apart, and every table is built
+// once when the package is loaded.
+package codetables
+
+const (
+	MinLength   = 3     // the shortest match
+	MaxLength   = 258   // the longest match
+	MaxDistance = 32768 // the farthest a match reaches back
+)
+
+// Code is a single code of an alphabet: the smallest value it stands for
+// and the number of extra bits that follow it to select the exact value
+type Code struct {
+	Base      int
+	ExtraBits int
+}
+
+// Table is a dense table of consecutive codes starting at FirstSymbol
+type Table struct {
+	FirstSymbol int
+	Codes       []Code
+
+	// symbols maps every value to its code, nil for tables whose codes
+	// overlap and are only decoded
+	symbols []uint16
+}
+
+// Length holds the length codes 257 to 285 of the literal/length alphabet
+var Length = newTable(257, []Code{
+	{ExtraBits: 0, Base: 3}, {ExtraBits: 0, Base: 4}, {ExtraBits: 0, Base: 5}, {ExtraBits: 0, Base: 6}, {ExtraBits: 0, Base: 7}, {ExtraBits: 0, Base: 8}, {ExtraBits: 0, Base: 9}, {ExtraBits: 0, Base: 10}, // 257-264
+	{ExtraBits: 1, Base: 11}, {ExtraBits: 1, Base: 13}, {ExtraBits: 1, Base: 15}, {ExtraBits: 1, Base: 17}, // 265-268
+	{ExtraBits: 2, Base: 19}, {ExtraBits: 2, Base: 23}, {ExtraBits: 2, Base: 27}, {ExtraBits: 2, Base: 31}, // 269-272
+	{ExtraBits: 3, Base: 35}, {ExtraBits: 3, Base: 43}, {ExtraBits: 3, Base: 51}, {ExtraBits: 3, Base: 59}, // 273-276
+	{ExtraBits: 4, Base: 67}, {ExtraBits: 4, Base: 83}, {ExtraBits: 4, Base: 99}, {ExtraBits: 4, Base: 115}, // 277-280
+	{ExtraBits: 5, Base: 131}, {ExtraBits: 5, Base: 163}, {ExtraBits: 5, Base: 195}, {ExtraBits: 5, Base: 227}, // 281-284
+	{ExtraBits: 0, Base: 258}, // 285
+}, MaxLength)
+
+// Distance holds the distance codes 0 to 29
+var Distance = newTable(0, []Code{
+	{ExtraBits: 0, Base: 1}, {ExtraBits: 0, Base: 2}, {ExtraBits: 0, Base: 3}, {ExtraBits: 0, Base: 4}, // 0-3
+	{ExtraBits: 1, Base: 5}, {ExtraBits: 1, Base: 7}, {ExtraBits: 2, Base: 9}, {ExtraBits: 2, Base: 13}, // 4-7
+	{ExtraBits: 3, Base: 17}, {ExtraBits: 3, Base: 25}, {ExtraBits: 4, Base: 33}, {ExtraBits: 4, Base: 49}, // 8-11
+	{ExtraBits: 5, Base: 65}, {ExtraBits: 5, Base: 97}, {ExtraBits: 6, Base: 129}, {ExtraBits: 6, Base: 193}, // 12-15
+	{ExtraBits: 7, Base: 257}, {ExtraBits: 7, Base: 385}, {ExtraBits: 8, Base: 513}, {ExtraBits: 8, Base: 769}, // 16-19
+	{ExtraBits: 9, Base: 1025}, {ExtraBits: 9, Base: 1537}, {ExtraBits: 10, Base: 2049}, {ExtraBits: 10, Base: 3073}, // 20-23
+	{ExtraBits: 11, Base: 4097}, {ExtraBits: 11, Base: 6145}, {ExtraBits: 12, Base: 8193}, {ExtraBits: 12, Base: 12289}, // 24-27
+	{ExtraBits: 13, Base: 16385}, {ExtraBits: 13, Base: 24577}, // 28-29
+}, MaxDistance)
+
+// CodeLength holds the code-length alphabet: 0-15 are literal lengths, 16
+// repeats the previous length 3 to 6 times and 17/18 emit runs of zeros. A
+// run of zeros fits more than one code, so the encoder picks the codes
+// itself and Encode finds none.
+var CodeLength = &Table{
+	FirstSymbol: 0,
+	Codes: []Code{
+		{ExtraBits: 0, Base: 0}, {ExtraBits: 0, Base: 1}, {ExtraBits: 0, Base: 2}, {ExtraBits: 0, Base: 3},
+		{ExtraBits: 0, Base: 4}, {ExtraBits: 0, Base: 5}, {ExtraBits: 0, Base: 6}, {ExtraBits: 0, Base: 7},
+		{ExtraBits: 0, Base: 8}, {ExtraBits: 0, Base: 9}, {ExtraBits: 0, Base: 10}, {ExtraBits: 0, Base: 11},
+		{ExtraBits: 0, Base: 12}, {ExtraBits: 0, Base: 13}, {ExtraBits: 0, Base: 14}, {ExtraBits: 0, Base: 15},
+		{ExtraBits: 2, Base: 3}, {ExtraBits: 3, Base: 3}, {ExtraBits: 7, Base: 11}, // 16-18
+	},
+}
+
+// newTable returns the table of codes with the lookup of every value up to
+// maxValue. Codes are sorted by Base; a code reaching past maxValue, as 284
+// does, leaves the values beyond it to the next one.
+func newTable(firstSymbol int, codes []Code, maxValue int) *Table {
+	t := &Table{FirstSymbol: firstSymbol, Codes: codes, symbols: make([]uint16, maxValue+1)}
+	for index, code := range codes {
+		for value := code.Base; value < code.Base+1<<code.ExtraBits && value <= maxValue; value++ {
+			t.symbols[value] = uint16(firstSymbol + index)
+		}
+	}
+	return t
+}
+
+// Code returns the code of symbol, false when the symbol is outside of the
+// table (e.g. a literal of the literal/length alphabet)
+func (t *Table) Code(symbol int) (Code, bool) {
+	index := symbol - t.FirstSymbol
+	if index < 0 || index >= len(t.Codes) {
+		return Code{}, false
+	}
+	return t.Codes[index], true
+}
+
+// ExtraBits returns the number of extra bits that follow symbol, 0 for
+// symbols outside of the table
+func (t *Table) ExtraBits(symbol int) int {
+	code, _ := t.Code(symbol)
+	return code.ExtraBits
+}
+
+// Encode returns the symbol value is coded with and the offset that goes in
+// its extra bits, false for values no code stands for
+func (t *Table) Encode(value int) (symbol int, offset int, ok bool) {
+	if value >= len(t.symbols) || value < t.Codes[0].Base {
+		return 0, 0, false
+	}
+	symbol = int(t.symbols[value])
+	return symbol, value - t.Codes[symbol-t.FirstSymbol].Base, true
+}
+
+// Decode returns the value symbol stands for with the offset read from its
+// extra bits, false when symbol is outside of the table or the offset does
+// not fit its extra bits
+func (t *Table) Decode(symbol int, offset int) (value int, ok bool) {
+	code, ok := t.Code(symbol)
+	if !ok || offset < 0 || offset >= 1<<code.ExtraBits {
+		return 0, false
+	}
+	return code.Base + offset, true
+}
@@ -0,0 +1,80 @@
+package codetables
+
+import "testing"
+
+// TestCodeTables checks that the length, distance and code length tables
+// encode every value they cover to a code that decodes back to it
+func TestCodeTables(t *testing.T) {
+	for _, table := range []struct {
+		name     string
+		table    *Table
+		min, max int
+		symbols  int
+	}{
+		{"length", Length, MinLength, MaxLength, 29},
+		{"distance", Distance, 1, MaxDistance, 30},
+	} {
+		if len(table.table.Codes) != table.symbols {
+			t.Fatalf("%s: %d codes, want %d", table.name, len(table.table.Codes), table.symbols)
+		}
+		// every value encodes to a code that decodes back to it
+		for value := table.min; value <= table.max; value++ {
+			symbol, offset, ok := table.table.Encode(value)
+			if !ok {
+				t.Fatalf("%s %d did not encode", table.name, value)
+			}
+			if decoded, ok := table.table.Decode(symbol, offset); !ok || decoded != value {
+				t.Fatalf("%s %d encoded to %d+%d, which decodes to %d", table.name, value, symbol, offset, decoded)
+			}
+		}
+		for _, value := range []int{-1, table.min - 1, table.max + 1} {
+			if symbol, offset, ok := table.table.Encode(value); ok {
+				t.Errorf("%s %d encoded to %d+%d", table.name, value, symbol, offset)
+			}
+		}
+		// the codes cover the values without gaps, and every offset of
+		// their extra bits decodes to a value that encodes back to them
+		next := table.min
+		for index, code := range table.table.Codes {
+			symbol := table.table.FirstSymbol + index
+			if code.Base != next && !(symbol == 285 && code.Base == MaxLength) {
+				t.Errorf("%s code %d starts at %d, want %d", table.name, symbol, code.Base, next)
+			}
+			next = code.Base + 1<<code.ExtraBits
+			for offset := range 1 << code.ExtraBits {
+				value, ok := table.table.Decode(symbol, offset)
+				if !ok {
+					t.Fatalf("%s code %d+%d did not decode", table.name, symbol, offset)
+				}
+				if encoded, _, _ := table.table.Encode(value); encoded != symbol && !(symbol == 284 && value == MaxLength) {
+					t.Errorf("%s code %d+%d decodes to %d, which encodes to %d", table.name, symbol, offset, value, encoded)
+				}
+			}
+			if _, ok := table.table.Decode(symbol, 1<<code.ExtraBits); ok {
+				t.Errorf("%s code %d decoded an offset past its extra bits", table.name, symbol)
+			}
+		}
+		if _, ok := table.table.Decode(table.table.FirstSymbol+table.symbols, 0); ok {
+			t.Errorf("%s decoded the code past the table", table.name)
+		}
+	}
+	// 258 has a code of its own rather than the last offset of 284
+	if symbol, offset, _ := Length.Encode(MaxLength); symbol != 285 || offset != 0 {
+		t.Errorf("258 encoded to %d+%d, want 285+0", symbol, offset)
+	}
+
+	// the code lengths repeat 3-6 times, or emit 3-10 and 11-138 zeros
+	for _, repeat := range []struct{ symbol, offset, want int }{
+		{7, 0, 7}, {16, 0, 3}, {16, 3, 6}, {17, 0, 3}, {17, 7, 10}, {18, 0, 11}, {18, 127, 138},
+	} {
+		if got, ok := CodeLength.Decode(repeat.symbol, repeat.offset); !ok || got != repeat.want {
+			t.Errorf("code length %d+%d decoded to %d, want %d", repeat.symbol, repeat.offset, got, repeat.want)
+		}
+	}
+	if _, _, ok := CodeLength.Encode(5); ok {
+		t.Error("a code length was encoded without a table to look it up in")
+	}
+	if Length.ExtraBits(65) != 0 || Distance.ExtraBits(30) != 0 {
+		t.Error("symbols outside of the tables have extra bits")
+	}
+}
@@ -0,0 +1,101 @@
+package flate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnexpectedEOF is returned when the compressed data ends in the middle of
+// a block, it wraps io.ErrUnexpectedEOF
+var ErrUnexpectedEOF = fmt.Errorf("not enough bits to read from the compressed data: %w", io.ErrUnexpectedEOF)
+
+// BitReader reads LSB-first bit fields from a byte slice. It keeps up to 64
+// bits buffered and refills eight bytes at a time, so decoding never
+// allocates.
+type BitReader struct {
+	data  []byte
+	pos   int
+	bits  uint64
+	nbits uint
+}
+
+// NewBitReader returns a reader over data
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+// refill tops up the bit buffer from the input as far as it fits
+func (br *BitReader) refill() {
+	for br.nbits <= 56 && br.pos < len(br.data) {
+		br.bits |= uint64(br.data[br.pos]) << br.nbits
+		br.pos++
+		br.nbits += 8
+	}
+}
+
+// ReadBits returns the next nbits bits, first bit in the least significant position
+func (br *BitReader) ReadBits(nbits uint) (uint32, error) {
+	if nbits > 32 {
+		return 0, errors.New("cannot read more than 32 bits at once.")
+	}
+	if br.nbits < nbits {
+		br.refill()
+		if br.nbits < nbits {
+			return 0, ErrUnexpectedEOF
+		}
+	}
+	output := uint32(br.bits & (1<<nbits - 1))
+	br.bits >>= nbits
+	br.nbits -= nbits
+	return output, nil
+}
+
+// ReadBit returns the next single bit
+func (br *BitReader) ReadBit() (uint32, error) {
+	if br.nbits == 0 {
+		br.refill()
+		if br.nbits == 0 {
+			return 0, ErrUnexpectedEOF
+		}
+	}
+	bit := uint32(br.bits & 1)
+	br.bits >>= 1
+	br.nbits--
+	return bit, nil
+}
+
+// AlignToByte drops the bits left in the current byte
+func (br *BitReader) AlignToByte() {
+	drop := br.nbits % 8
+	br.bits >>= drop
+	br.nbits -= drop
+}
+
+// ReadByte returns the next whole byte; the reader must be byte aligned
+func (br *BitReader) ReadByte() (byte, error) {
+	if br.nbits >= 8 {
+		b := byte(br.bits)
+		br.bits >>= 8
+		br.nbits -= 8
+		return b, nil
+	}
+	if br.pos >= len(br.data) {
+		return 0, io.EOF
+	}
+	b := br.data[br.pos]
+	br.pos++
+	return b, nil
+}
+
+// Exhausted reports whether less than a byte of input is left, i.e. only the
+// padding of the last byte remains
+func (br *BitReader) Exhausted() bool {
+	return br.nbits < 8 && br.pos >= len(br.data)
+}
+
+// Offset returns the position of the first input byte that has not been
+// read, a partly read byte counts as read
+func (br *BitReader) Offset() int {
+	return br.pos - int(br.nbits/8)
+}
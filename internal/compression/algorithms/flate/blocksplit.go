@@ -0,0 +1,113 @@
+package flate
+
+import (
+	"math"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
+)
+
+// blockSplitWindow is the number of tokens inspected at a time when looking for
+// a shift in the symbol distribution
+const blockSplitWindow = 4096
+
+// estimatedBlockHeaderBits approximates the cost of the code-length header a
+// new dynamic block has to carry, a split must save more than this to pay off
+const estimatedBlockHeaderBits = 640
+
+// symbolHistogram counts the lit/length and distance symbols of a run of tokens
+type symbolHistogram struct {
+	litLen [286]int
+	dist   [30]int
+}
+
+func (h *symbolHistogram) add(token Token) {
+	if token.Kind == LiteralToken {
+		h.litLen[token.Value]++
+		return
+	}
+	if code, _, ok := codetables.Length.Encode(token.Length); ok {
+		h.litLen[code]++
+	}
+	if code, _, ok := codetables.Distance.Encode(token.Distance); ok {
+		h.dist[code]++
+	}
+}
+
+func (h *symbolHistogram) merge(other *symbolHistogram) {
+	for i := range h.litLen {
+		h.litLen[i] += other.litLen[i]
+	}
+	for i := range h.dist {
+		h.dist[i] += other.dist[i]
+	}
+}
+
+// cost estimates the number of bits needed to encode the counted symbols with
+// codes built from this histogram. Extra bits are left out since they do not
+// depend on how the tokens are split into blocks.
+func (h *symbolHistogram) cost() float64 {
+	litLen := h.litLen
+	litLen[256]++ // every block ends with an end-of-block symbol
+	return entropyBits(litLen[:]) + entropyBits(h.dist[:])
+}
+
+func entropyBits(counts []int) float64 {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	bits := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			bits += float64(count) * math.Log2(float64(total)/float64(count))
+		}
+	}
+	return bits
+}
+
+// splitBlocks partitions tokens into runs that are each worth encoding as their
+// own dynamic block. Tokens are scanned one window at a time and a new block is
+// started when coding the window with its own statistics is cheaper than coding
+// it together with the current block, even after paying for another header.
+// This keeps e.g. text followed by binary data from sharing one set of codes.
+func splitBlocks(tokens []Token) [][]Token {
+	var blocks [][]Token
+	var current symbolHistogram
+	start := 0
+	for windowStart := 0; windowStart < len(tokens); windowStart += blockSplitWindow {
+		windowEnd := min(windowStart+blockSplitWindow, len(tokens))
+		var window symbolHistogram
+		for _, token := range tokens[windowStart:windowEnd] {
+			window.add(token)
+		}
+		if windowStart > start {
+			merged := current
+			merged.merge(&window)
+			if current.cost()+window.cost()+estimatedBlockHeaderBits < merged.cost() {
+				blocks = append(blocks, tokens[start:windowStart])
+				start = windowStart
+				current = symbolHistogram{}
+			}
+		}
+		current.merge(&window)
+	}
+	return append(blocks, tokens[start:])
+}
+
+// limitBlocks cuts the blocks further wherever one would cover more than
+// size bytes of input
+func limitBlocks(blocks [][]Token, size int) [][]Token {
+	var limited [][]Token
+	for _, block := range blocks {
+		start, covered := 0, 0
+		for i, token := range block {
+			if covered > 0 && covered+tokenLength(token) > size {
+				limited = append(limited, block[start:i])
+				start, covered = i, 0
+			}
+			covered += tokenLength(token)
+		}
+		limited = append(limited, block[start:])
+	}
+	return limited
+}
@@ -1,14 +1,22 @@
 package flate
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
+	"unsafe"
 
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/lzss"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/profile"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TokenKind int
@@ -19,14 +27,6 @@ const (
 	EndOfBlockToken
 )
 
-type Rulebook struct {
-	Alphabets map[int]struct {
-		ExtraBits int
-		Base      int
-	}
-	KeyOrder []int
-}
-
 type LitLengthCode struct {
 	LitLengthHuffman []huffman.CanonicalHuffman
 	CanonicalRoot    *huffman.CanonicalHuffmanNode
@@ -55,43 +55,15 @@ type Token struct {
 	LengthOffset   int
 }
 
-var maxAllowedBackwardDistance int = 32768
-var maxAllowedMatchLength int = 258
-var lenAlphabets = Rulebook{
-	Alphabets: map[int]struct {
-		ExtraBits int
-		Base      int
-	}{
-		257: {ExtraBits: 0, Base: 3}, 258: {ExtraBits: 0, Base: 4}, 259: {ExtraBits: 0, Base: 5}, 260: {ExtraBits: 0, Base: 6}, 261: {ExtraBits: 0, Base: 7}, 262: {ExtraBits: 0, Base: 8}, 263: {ExtraBits: 0, Base: 9}, 264: {ExtraBits: 0, Base: 10}, 265: {ExtraBits: 1, Base: 11}, 266: {ExtraBits: 1, Base: 13}, 267: {ExtraBits: 1, Base: 15}, 268: {ExtraBits: 1, Base: 17}, 269: {ExtraBits: 2, Base: 19}, 270: {ExtraBits: 2, Base: 23}, 271: {ExtraBits: 2, Base: 27}, 272: {ExtraBits: 3, Base: 31}, 273: {ExtraBits: 3, Base: 35}, 274: {ExtraBits: 3, Base: 43}, 275: {ExtraBits: 3, Base: 51}, 276: {ExtraBits: 3, Base: 59}, 277: {ExtraBits: 4, Base: 67}, 278: {ExtraBits: 4, Base: 83}, 279: {ExtraBits: 4, Base: 99}, 280: {ExtraBits: 4, Base: 115}, 281: {ExtraBits: 5, Base: 131}, 282: {ExtraBits: 5, Base: 163}, 283: {ExtraBits: 5, Base: 195}, 284: {ExtraBits: 5, Base: 227}, 285: {ExtraBits: 0, Base: 258},
-	},
-	KeyOrder: []int{
-		257, 258, 259, 260, 261, 262, 263, 264, 265, 266, 267, 268, 269, 270, 271, 272, 273, 274, 275, 276, 277, 278, 279, 280, 281, 282, 283, 284, 285,
-	},
-}
-
-var distAlphabets = Rulebook{
-	Alphabets: map[int]struct {
-		ExtraBits int
-		Base      int
-	}{
-		0: {ExtraBits: 0, Base: 1}, 1: {ExtraBits: 0, Base: 2}, 2: {ExtraBits: 0, Base: 3}, 3: {ExtraBits: 0, Base: 4}, 4: {ExtraBits: 1, Base: 5}, 5: {ExtraBits: 1, Base: 7}, 6: {ExtraBits: 2, Base: 9}, 7: {ExtraBits: 2, Base: 13}, 8: {ExtraBits: 3, Base: 17}, 9: {ExtraBits: 3, Base: 25}, 10: {ExtraBits: 4, Base: 33}, 11: {ExtraBits: 4, Base: 49}, 12: {ExtraBits: 5, Base: 65}, 13: {ExtraBits: 5, Base: 97}, 14: {ExtraBits: 6, Base: 129}, 15: {ExtraBits: 6, Base: 193}, 16: {ExtraBits: 7, Base: 257}, 17: {ExtraBits: 7, Base: 385}, 18: {ExtraBits: 8, Base: 513}, 19: {ExtraBits: 8, Base: 769}, 20: {ExtraBits: 9, Base: 1025}, 21: {ExtraBits: 9, Base: 1537}, 22: {ExtraBits: 10, Base: 2049}, 23: {ExtraBits: 10, Base: 3073}, 24: {ExtraBits: 11, Base: 4097}, 25: {ExtraBits: 11, Base: 6145}, 26: {ExtraBits: 12, Base: 8193}, 27: {ExtraBits: 12, Base: 12289}, 28: {ExtraBits: 13, Base: 16385}, 29: {ExtraBits: 13, Base: 24577},
-	},
-	KeyOrder: []int{
-		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29,
-	},
-}
-
-var rleAlphabets = Rulebook{
-	Alphabets: map[int]struct {
-		ExtraBits int
-		Base      int
-	}{
-		16: {ExtraBits: 2, Base: 3}, 17: {ExtraBits: 3, Base: 3}, 18: {ExtraBits: 7, Base: 11}, 0: {ExtraBits: 0, Base: 0}, 8: {ExtraBits: 0, Base: 8}, 7: {ExtraBits: 0, Base: 7}, 9: {ExtraBits: 0, Base: 9}, 6: {ExtraBits: 0, Base: 6}, 10: {ExtraBits: 0, Base: 10}, 5: {ExtraBits: 0, Base: 5}, 11: {ExtraBits: 0, Base: 11}, 4: {ExtraBits: 0, Base: 4}, 12: {ExtraBits: 0, Base: 12}, 3: {ExtraBits: 0, Base: 3}, 13: {ExtraBits: 0, Base: 13}, 2: {ExtraBits: 0, Base: 2}, 14: {ExtraBits: 0, Base: 14}, 1: {ExtraBits: 0, Base: 1}, 15: {ExtraBits: 0, Base: 15},
-	},
-	KeyOrder: []int{
-		16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15,
-	},
-}
+// tokenSize is what a Token takes up in the slices holding a block
+const tokenSize = int(unsafe.Sizeof(Token{}))
+
+var maxAllowedBackwardDistance int = codetables.MaxDistance
+var maxAllowedMatchLength int = codetables.MaxLength
+
+// ioChunkSize is the size of the internal buffers used to batch reads from the
+// input and writes to the output instead of moving one byte at a time
+const ioChunkSize = 32 * 1024
 
 type CompressionWriter struct {
 	core *compressionCore
@@ -100,97 +72,205 @@ type CompressionReader struct {
 	core *compressionCore
 }
 type bitBuffer struct {
-	bitsHolder uint32
-	bitsCount  uint
+	bitsHolder  uint32
+	bitsCount   uint
+	bitsWritten int64 // over the whole stream, for the profile
 }
 
+// compressionCore compresses the input into a pipe as it is written, a
+// segment at a time, so the blocks are written as fast as the reader takes
+// them and Write and Close block while it lags behind. It holds the window
+// matches reach back into, the data of the block held back and the input
+// not coded yet, however long the input is.
 type compressionCore struct {
-	isInputBufferClosed bool
-	cond                *sync.Cond
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
-	bitBuffer           *bitBuffer
-	btype               uint32
-	bfinal              uint32
+	isWriterClosed       bool // Close was called, compressing may still be under way
+	lock                 sync.Mutex
+	err                  error // what coding failed with, Write and Close return it from then on
+	started              bool  // the window was set up, the dictionary can no longer change
+	window               []byte // the dictionary and the input, from the history a match may reach on
+	coded                int    // where in window the input not tokenised yet starts
+	blockStart           int    // where in window the data of the pending block starts
+	pending              []Token // the last block, held back until it is known whether more follow
+	pipeReader           *io.PipeReader
+	pipeWriter           *io.PipeWriter
+	bufferedOutput       *bufio.Writer // batches the bytes of the bit writer for the pipe
+	bitBuffer            *bitBuffer
+	btype                uint32
+	bfinal               uint32
+	ctx                  context.Context // parent of the spans of the stages
+	memory               *memory.Tracker
+	tinyInputSize        int // inputs shorter than this skip matching
+	blockSize            int // the most input a block covers, 0 for no limit
+	dictionary           []byte // preset data matches may reach back into
+	newMatcher           lzss.MatcherFunc
+	matcher              lzss.Matcher // made by newMatcher for the first segment, reset for the others
+	profile              profile.Profile
 }
 
+// Read returns the compressed data as Write and Close code it, and the
+// error coding failed with once it is all read
 func (cr *CompressionReader) Read(data []byte) (int, error) {
-	cr.core.lock.Lock()
-	defer cr.core.lock.Unlock()
-	for !cr.core.isInputBufferClosed {
-		cr.core.cond.Wait()
-	}
-	return cr.core.outputBuffer.Read(data)
+	return cr.core.pipeReader.Read(data)
 }
 
 func (cr *CompressionReader) Close() error {
+	// a Read still waiting for the writer gives up instead of blocking
+	// forever, and a Write or Close blocked on writing to the pipe fails; the
+	// lock is taken after that since they hold it while they write
+	cr.core.pipeReader.Close()
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if buf, ok := cr.core.inputBuffer.(*bytes.Buffer); ok {
-		buf.Reset()
-		cr.core.isInputBufferClosed = false
-		return nil
-	} else {
-		return errors.New("underlying io.ReadWriter is not *bytes.Buffer. Type assertion failed")
-	}
+	cr.core.window, cr.core.pending, cr.core.matcher = nil, nil, nil
+	return nil
 }
 
+// Write codes every whole segment of the input there is and hands the
+// blocks it completes to the reader. The last block is held back until the
+// next one or Close tells whether it is final.
 func (cw *CompressionWriter) Write(data []byte) (int, error) {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
-	if cw.core.isInputBufferClosed {
-		return 0, errors.New("reading from the original stream for the previous block has not completed yet!")
+	if cw.core.isWriterClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if cw.core.err != nil {
+		return 0, cw.core.err
+	}
+	cw.start()
+	// fmt.printf("[ flate.CompressionWriter.Write ] data written to window\n")
+	cw.core.window = append(cw.core.window, data...)
+	cw.core.memory.Set(memory.Input, cap(cw.core.window))
+	err := cw.encode(false)
+	if err == nil {
+		// what is coded goes out now rather than with the next segment
+		err = cw.core.bufferedOutput.Flush()
 	}
-	// fmt.printf("[ flate.CompressionWriter.Write ] data written to inputBuffer\n")
-	return cw.core.inputBuffer.Write(data)
+	if err != nil {
+		cw.core.err = err
+		return 0, err
+	}
+	return len(data), nil
 }
 
+// Close codes the rest of the input, with the final block, and ends the
+// output
 func (cw *CompressionWriter) Close() error {
 	cw.core.lock.Lock()
-	originalData, err := io.ReadAll(cw.core.inputBuffer)
-	cw.core.lock.Unlock()
-	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
-	if err != nil {
-		return err
+	defer cw.core.lock.Unlock()
+	if cw.core.isWriterClosed {
+		return io.ErrClosedPipe
 	}
-	if err = cw.compress(originalData); err != nil {
-		return err
-	} else {
-		cw.core.lock.Lock()
-		defer cw.core.lock.Unlock()
+	cw.core.isWriterClosed = true
+	err := cw.core.err
+	if err == nil {
+		cw.start()
+		if err = cw.encode(true); err == nil {
+			err = cw.finish()
+		}
+	}
+	cw.core.window, cw.core.pending = nil, nil
+	// the reader gets the error too, otherwise it would take what was
+	// written so far for the complete output
+	cw.core.pipeWriter.CloseWithError(err)
+	return err
+}
 
-		cw.core.isInputBufferClosed = true
-		cw.core.cond.Signal()
-		return nil
+// start puts the dictionary in front of the input the first time the
+// writer is written to or closed
+func (cw *CompressionWriter) start() {
+	if cw.core.started {
+		return
 	}
+	cw.core.started = true
+	cw.core.window = append([]byte(nil), cw.core.dictionary...)
+	cw.core.coded, cw.core.blockStart = len(cw.core.window), len(cw.core.window)
 }
 
+// AutoBlockType is the btype that has every block written as whichever of a
+// stored, fixed or dynamic block comes out smallest. RFC 1951 reserves
+// BTYPE 3, no block is ever written with it.
+const AutoBlockType = 3
+
 func NewCompressionReaderAndWriter(btype uint32, bfinal uint32) (io.ReadCloser, io.WriteCloser) {
 	newCompressionCore := new(compressionCore)
-	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
+	newCompressionCore.pipeReader, newCompressionCore.pipeWriter = io.Pipe()
+	newCompressionCore.bufferedOutput = bufio.NewWriterSize(newCompressionCore.pipeWriter, ioChunkSize)
 	newCompressionCore.bitBuffer = new(bitBuffer)
-	newCompressionCore.isInputBufferClosed = false
 	newCompressionCore.btype = btype
 	newCompressionCore.bfinal = bfinal
-	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
+	newCompressionCore.ctx = context.Background()
+	newCompressionCore.tinyInputSize = DefaultTinyInputSize
+	newCompressionCore.newMatcher = lzss.NewHashChainMatcher
+	newCompressionCore.memory = memory.NewTracker(maxAllowedBackwardDistance)
+	newCompressionCore.memory.Set(memory.Output, ioChunkSize)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
 	// fmt.printf("[ flate.NewCompressionReaderAndWriter ] newCompressionCore: %v\n", newCompressionCore)
 	return newCompressionReader, newCompressionWriter
 }
 
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (cw *CompressionWriter) MemoryUsage() memory.Usage {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	return cw.core.memory.Usage()
+}
+
+// Profile reports what the input was coded as, it is complete once Close has
+// returned
+func (cw *CompressionWriter) Profile() profile.Profile {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	return cw.core.profile
+}
+
+// SetContext sets the context the spans of the matching, Huffman table and
+// bit writing stages are started in, it has to be called before Write
+func (cw *CompressionWriter) SetContext(ctx context.Context) {
+	cw.core.ctx = ctx
+}
+
+// DefaultTinyInputSize is the size below which inputs skip matching unless
+// SetTinyInputSize says otherwise
+const DefaultTinyInputSize = 256
+
+// SetTinyInputSize sets the size below which inputs are not searched for
+// matches and go into a single stored or fixed Huffman block, whichever is
+// smaller. The code tables of a dynamic block take up more than matches in
+// a tiny input can save. 0 turns the fast path off, it has to be called
+// before Write.
+func (cw *CompressionWriter) SetTinyInputSize(size int) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.tinyInputSize = size
+}
+
+// SetDictionary sets the data the input is matched against before its own
+// start, as if it preceded it. Only the last 32 KiB are in reach, the
+// decompressor has to be given the same dictionary. It has to be called
+// before Write.
+func (cw *CompressionWriter) SetDictionary(dict []byte) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.dictionary = dict[max(len(dict)-maxAllowedBackwardDistance, 0):]
+}
+
+// SetMatcher sets how matches are searched for, lzss.NewHashChainMatcher
+// unless it is called. It has to be called before Write.
+func (cw *CompressionWriter) SetMatcher(newMatcher lzss.MatcherFunc) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.newMatcher = newMatcher
+	cw.core.matcher = nil
+}
+
 func (dc *DistanceCode) FindCode(value int) (code int, offset int, err error) {
-	if value < 1 || value > maxAllowedBackwardDistance {
+	code, offset, ok := codetables.Distance.Encode(value)
+	if !ok {
 		return 0, 0, errors.New("value is out of range to have a match with RFC distance code")
 	}
-	for i, info := range distAlphabets.Alphabets {
-		if value >= info.Base && (i+1 == len(distAlphabets.Alphabets) || value < distAlphabets.Alphabets[i+1].Base) {
-			offset := value - info.Base
-			return i, offset, nil
-		}
-	}
-	return 0, 0, fmt.Errorf("no distance code found for the distance value %v\n", value)
+	return code, offset, nil
 }
 
 func (dc *DistanceCode) Encode(items any) ([]int, error) {
@@ -198,18 +278,24 @@ func (dc *DistanceCode) Encode(items any) ([]int, error) {
 	if !ok {
 		return nil, errors.New("distance huffman tree cannot be generated without the type of Token slice")
 	}
-	symbolFreq := make([]int, 30)
-	for i := range tokens {
-		token := &tokens[i]
+	symbolFreq, err := countSymbols(tokens, 30, func(token *Token, symbolFreq []int) error {
 		if token.Kind == MatchToken {
 			if code, offset, err := dc.FindCode(token.Distance); err != nil {
-				return nil, err
+				return err
 			} else {
 				token.DistanceCode, token.DistanceOffset = code, offset
 				symbolFreq[token.DistanceCode]++
 				// fmt.printf("[ flate.DistanceCode.Encode ] Distance: %v --- DistanceCode: %v, DistanceOffset: %v\n", token.Distance, token.DistanceCode, token.DistanceOffset)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(symbolFreq, func(freq int) bool { return freq > 0 }) {
+		// a block without matches still needs a distance code, emit an unused one
+		symbolFreq[0] = 1
 	}
 	if distHuffmanCode, err := huffman.BuildCanonicalHuffmanEncoder(symbolFreq, 15); err != nil {
 		return nil, err
@@ -225,17 +311,11 @@ func (dc *DistanceCode) Encode(items any) ([]int, error) {
 }
 
 func (llc *LitLengthCode) FindCode(value int) (code int, offset int, err error) {
-	if value < 3 || value > maxAllowedMatchLength {
+	code, offset, ok := codetables.Length.Encode(value)
+	if !ok {
 		return 0, 0, errors.New("value is out of range to have a match with RFC length code")
 	}
-	for _, key := range lenAlphabets.KeyOrder {
-		if nextInfo, ok := lenAlphabets.Alphabets[key+1]; value >= lenAlphabets.Alphabets[key].Base && (!ok || value < nextInfo.Base) {
-			// fmt.Printf("[ flate.FindCode ] ")
-			offset := value - lenAlphabets.Alphabets[key].Base
-			return key, offset, nil
-		}
-	}
-	return 0, 0, fmt.Errorf("no length code found for the length value %v\n", value)
+	return code, offset, nil
 }
 
 func (llc *LitLengthCode) Encode(items any) ([]int, error) {
@@ -243,21 +323,23 @@ func (llc *LitLengthCode) Encode(items any) ([]int, error) {
 	if !ok {
 		return nil, errors.New("length huffman code cannot be generated without the type of Token slice")
 	}
-	symbolFreq := make([]int, 286)
-	for i := range tokens {
-		token := &tokens[i]
+	symbolFreq, err := countSymbols(tokens, 286, func(token *Token, symbolFreq []int) error {
 		if token.Kind == LiteralToken {
 			symbolFreq[token.Value]++
 			// fmt.printf("[ flate.LitLengthCode.Encode ] Literal: %v --- Code: %v\n", string(token.Value), token.Value)
 		} else {
 			if code, offset, err := llc.FindCode(token.Length); err != nil {
-				return nil, err
+				return err
 			} else {
 				token.LengthCode, token.LengthOffset = code, offset
 				symbolFreq[token.LengthCode]++
 				// fmt.printf("[ flate.LitLengthCode.Encode ] Length: %v --- LengthCode: %v, LengthOffset: %v\n", token.Length, token.LengthCode, token.LengthOffset)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	symbolFreq[256]++
 	if litLenHuffmanCode, err := huffman.BuildCanonicalHuffmanEncoder(symbolFreq, 15); err != nil {
@@ -420,7 +502,7 @@ func (clc *CodeLengthCode) Encode(items any) ([]int, error) {
 		codeLengthHuffmanCode = clc.shuffle(codeLengthHuffmanCode)
 		// for i, huffman := range codeLengthHuffmanCode {
 		// 	if huffman != nil {
-		// 		key := rleAlphabets.KeyOrder[i]
+		// 		key := codeLengthOrder[i]
 		// 		fmt.Printf("[ flate.CodeLengthCode.Encode ] RLECode: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", key, huffman.GetValue(), huffman.GetLength())
 		// 	}
 		// }
@@ -430,52 +512,302 @@ func (clc *CodeLengthCode) Encode(items any) ([]int, error) {
 
 func (clc *CodeLengthCode) shuffle(code []huffman.CanonicalHuffman) []huffman.CanonicalHuffman {
 	var huffmanLengths []huffman.CanonicalHuffman
-	for _, key := range rleAlphabets.KeyOrder {
+	for _, key := range codeLengthOrder {
 		huffmanLengths = append(huffmanLengths, code[key])
 	}
 	return huffmanLengths
 }
 
-func (cw *CompressionWriter) compress(content []byte) error {
-	contentRune := []rune(string(content))
-	// fmt.printf("[ flate.CompressionWriter.compress ] contentString %v\n", string(content))
-	refChannels := make([]chan lzss.Reference, len(contentRune))
-	lzss.FindMatch(refChannels, contentRune, maxAllowedBackwardDistance, maxAllowedMatchLength)
-	tokens, err := tokeniseLZSS(refChannels)
-	if err != nil {
+// matchSegmentSize is the number of input positions matched at a time. Every
+// position still searches the whole window behind it, reaching back into
+// earlier segments, so segments only bound the tokens that are alive at once.
+const matchSegmentSize = 64 * 1024
+
+// maxBlockTokens caps the size of a block, longer runs are emitted as several
+// blocks even if their statistics do not call for a split
+const maxBlockTokens = 64 * 1024
+
+// SetBlockSize caps the bytes of input a block covers, so a large input gets
+// a Huffman table per part of it and the tokens of no more than a block are
+// held back at a time. A match is not cut in two, so a size below the
+// longest match lets a block run past it. 0, the default, only caps blocks
+// at 64K tokens. It has to be called before Write.
+func (cw *CompressionWriter) SetBlockSize(size int) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.blockSize = size
+}
+
+// encode codes the input in window that is not coded yet. Until the input
+// is complete only whole segments are, with the longest match to spare
+// after them, and the last block is kept pending; the final call codes all
+// of it and writes the pending block as the last one.
+func (cw *CompressionWriter) encode(final bool) error {
+	core := cw.core
+	if core.btype > AutoBlockType {
+		return fmt.Errorf("invalid block type %v", core.btype)
+	}
+	// BTYPE 0 passes the content through as it is
+	if core.btype == 0 {
+		return cw.encodeStored(final)
+	}
+
+	// tiny inputs are what a dictionary helps most, they are matched against
+	// it. Nothing is coded before a whole segment came in, so a tiny input
+	// is all there when the writer is closed.
+	if final && core.coded == 0 && len(core.window) < core.tinyInputSize && len(core.dictionary) == 0 {
+		content := core.window[core.coded:]
+		core.coded = len(core.window)
+		return cw.writeTinyBlock(content, core.bfinal)
+	}
+
+	// writeBlock writes tokens as a block, the data it decodes to starts at
+	// blockStart
+	writeBlock := func(tokens []Token, bfinal uint32) error {
+		size := 0
+		for _, token := range tokens {
+			size += tokenLength(token)
+		}
+		core.blockStart += size
+		return cw.writeBlock(tokens, core.window[core.blockStart-size:core.blockStart], bfinal)
+	}
+	for {
+		available := len(core.window) - core.coded
+		if final && available == 0 || !final && available < matchSegmentSize+maxAllowedMatchLength {
+			break
+		}
+		end := min(core.coded+matchSegmentSize, len(core.window))
+		last := final && end == len(core.window)
+		if core.matcher == nil {
+			core.matcher = core.newMatcher(maxAllowedBackwardDistance, maxAllowedMatchLength)
+		}
+		matcher := core.matcher
+		matcher.Reset(core.window, core.coded)
+		_, span := telemetry.Start(core.ctx, "flate.match", attribute.Int("flate.segment_bytes", end-core.coded))
+		tokens, err := tokenise(matcher, end)
+		telemetry.End(span, err)
+		if err != nil {
+			return err
+		}
+		// the last match may reach past the end of the segment
+		core.coded = matcher.Position()
+		core.memory.Set(memory.Working, matcher.HeldBytes()+(len(core.pending)+len(tokens))*tokenSize)
+
+		blocks := [][]Token{append(core.pending, tokens...)}
+		// the fixed codes are the same in every block, splitting gains nothing
+		if core.btype != 1 {
+			blocks = splitBlocks(blocks[0])
+		}
+		if core.blockSize > 0 {
+			blocks = limitBlocks(blocks, core.blockSize)
+		}
+		core.pending = blocks[len(blocks)-1]
+		for _, block := range blocks[:len(blocks)-1] {
+			if err := writeBlock(block, 0); err != nil {
+				return err
+			}
+		}
+		if len(core.pending) >= maxBlockTokens && !last {
+			if err := writeBlock(core.pending, 0); err != nil {
+				return err
+			}
+			core.pending = nil
+		}
+	}
+	if !final {
+		cw.trimWindow()
+		return nil
+	}
+	// empty input still gets its one (empty) block
+	err := writeBlock(core.pending, core.bfinal)
+	core.pending = nil
+	return err
+}
+
+// encodeStored writes the input in window as stored blocks, holding back
+// what may be the last block until the input is complete
+func (cw *CompressionWriter) encodeStored(final bool) error {
+	core := cw.core
+	for len(core.window)-core.coded > cw.storedBlockSize() {
+		size := cw.storedBlockSize()
+		if err := cw.writeStoredBlock(core.window[core.coded:core.coded+size], 0); err != nil {
+			return err
+		}
+		core.coded += size
+	}
+	if final {
+		err := cw.writeStoredBlock(core.window[core.coded:], core.bfinal)
+		core.coded = len(core.window)
 		return err
 	}
-	newLitLengthCode := new(LitLengthCode)
-	litLenHuffmanLengths, err := newLitLengthCode.Encode(tokens)
-	// fmt.printf("[ flate.CompressionWriter.compress ] len(litLenHuffmanLengths): %v\n", len(litLenHuffmanLengths))
-	// fmt.printf("[ flate.CompressionWriter.compress ] litLenHuffmanLengths: %v\n", litLenHuffmanLengths)
+	core.blockStart = core.coded
+	cw.trimWindow()
+	return nil
+}
+
+// trimWindow drops the start of window that neither a match can reach back
+// into nor the pending block needs, once there is a segment of it
+func (cw *CompressionWriter) trimWindow() {
+	core := cw.core
+	cut := min(core.coded-maxAllowedBackwardDistance, core.blockStart)
+	if cut < matchSegmentSize {
+		return
+	}
+	core.window = core.window[:copy(core.window, core.window[cut:])]
+	core.coded -= cut
+	core.blockStart -= cut
+	core.memory.Set(memory.Input, cap(core.window))
+}
+
+// writeBlock encodes tokens, which decode to data, as a single block of the
+// block type the writer was created with. AutoBlockType estimates the size
+// of the block with each type and writes the smallest, as zlib does, so
+// incompressible data is stored instead of growing.
+func (cw *CompressionWriter) writeBlock(tokens []Token, data []byte, bfinal uint32) error {
+	if cw.core.btype == 1 {
+		_, span := telemetry.Start(cw.core.ctx, "flate.write_bits", attribute.Int("flate.block_tokens", len(tokens)))
+		defer span.End()
+		return cw.writeFixedBlock(tokens, bfinal)
+	}
+	codes, err := cw.buildDynamicCodes(tokens)
 	if err != nil {
 		return err
 	}
+	_, span := telemetry.Start(cw.core.ctx, "flate.write_bits", attribute.Int("flate.block_tokens", len(tokens)))
+	defer span.End()
+	if cw.core.btype == AutoBlockType {
+		dynamicBits := codes.bits(tokens)
+		fixedBits := fixedBlockBits(tokens)
+		storedBits := cw.storedBlockBits(len(data))
+		switch {
+		case storedBits < min(fixedBits, dynamicBits):
+			return cw.writeStoredBlock(data, bfinal)
+		case fixedBits <= dynamicBits:
+			return cw.writeFixedBlock(tokens, bfinal)
+		}
+	}
+	return cw.writeDynamicBlock(tokens, codes, bfinal)
+}
+
+// fixedBlockBits is the size of a block of tokens with the fixed Huffman
+// codes, header included. The tokens have their length and distance codes.
+func fixedBlockBits(tokens []Token) int64 {
+	bits := int64(3 + fixedLitLengthLengths[256])
+	for _, token := range tokens {
+		if token.Kind == LiteralToken {
+			bits += int64(fixedLitLengthLengths[token.Value])
+			continue
+		}
+		bits += int64(fixedLitLengthLengths[token.LengthCode]) + int64(codetables.Length.ExtraBits(token.LengthCode))
+		bits += int64(fixedDistanceLengths[token.DistanceCode]) + int64(codetables.Distance.ExtraBits(token.DistanceCode))
+	}
+	return bits
+}
+
+// storedBlockBits is the size of size bytes written as stored blocks from
+// where the output is, with the padding to the byte boundary after the
+// first header
+func (cw *CompressionWriter) storedBlockBits(size int) int64 {
+	padding := (8 - (cw.core.bitBuffer.bitsCount+3)%8) % 8
+	blocks := max((size+cw.storedBlockSize()-1)/cw.storedBlockSize(), 1)
+	// the blocks after the first start at a byte boundary, 5 bits pad them
+	return int64(padding) + int64(blocks)*(3+32) + int64(blocks-1)*5 + 8*int64(size)
+}
+
+// dynamicCodes are the Huffman codes of a dynamic block built for its tokens,
+// and the code lengths that go into its header
+type dynamicCodes struct {
+	litLength                *LitLengthCode
+	distance                 *DistanceCode
+	codeLength               *CodeLengthCode
+	litLengthLengths         []int
+	distanceLengths          []int
+	codeLengthHuffmanLengths []int
+}
+
+// buildDynamicCodes builds the codes of a dynamic block for tokens, which
+// get their length and distance codes filled in
+func (cw *CompressionWriter) buildDynamicCodes(tokens []Token) (*dynamicCodes, error) {
+	_, span := telemetry.Start(cw.core.ctx, "flate.huffman_build", attribute.Int("flate.block_tokens", len(tokens)))
+	newLitLengthCode := new(LitLengthCode)
 	newDistanceCode := new(DistanceCode)
-	distHuffmanLengths, err := newDistanceCode.Encode(tokens)
+	var litLenHuffmanLengths, distHuffmanLengths []int
+	var litLenErr, distErr error
+	if len(tokens) >= parallelTokenThreshold {
+		// the two tables touch disjoint token fields, so they can be built side by side
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			distHuffmanLengths, distErr = newDistanceCode.Encode(tokens)
+		}()
+		litLenHuffmanLengths, litLenErr = newLitLengthCode.Encode(tokens)
+		wg.Wait()
+	} else {
+		litLenHuffmanLengths, litLenErr = newLitLengthCode.Encode(tokens)
+		distHuffmanLengths, distErr = newDistanceCode.Encode(tokens)
+	}
+	// fmt.printf("[ flate.CompressionWriter.compress ] len(litLenHuffmanLengths): %v\n", len(litLenHuffmanLengths))
 	// fmt.printf("[ flate.CompressionWriter.compress ] len(distHuffmanLengths): %v\n", len(distHuffmanLengths))
-	// fmt.printf("[ flate.CompressionWriter.compress ] distHuffmanLengths: %v\n", distHuffmanLengths)
-	if err != nil {
-		return err
+	if litLenErr != nil {
+		telemetry.End(span, litLenErr)
+		return nil, litLenErr
+	}
+	if distErr != nil {
+		telemetry.End(span, distErr)
+		return nil, distErr
 	}
 	concatenatedHuffmanLengths := append(litLenHuffmanLengths, distHuffmanLengths...)
 	// fmt.printf("[ flate.CompressionWriter.compress ] len(concatenatedHuffmanLengths): %v\n", len(concatenatedHuffmanLengths))
 	// fmt.printf("[ flate.CompressionWriter.compress ] concatenatedHuffmanLengths: %v\n", concatenatedHuffmanLengths)
 	newCodeLengthCode := new(CodeLengthCode)
 	codeLengthHuffmanLengths, err := newCodeLengthCode.Encode(concatenatedHuffmanLengths)
+	telemetry.End(span, err)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &dynamicCodes{
+		litLength:                newLitLengthCode,
+		distance:                 newDistanceCode,
+		codeLength:               newCodeLengthCode,
+		litLengthLengths:         litLenHuffmanLengths,
+		distanceLengths:          distHuffmanLengths,
+		codeLengthHuffmanLengths: codeLengthHuffmanLengths,
+	}, nil
+}
+
+// bits is the size of a dynamic block of tokens with the codes, header
+// included
+func (codes *dynamicCodes) bits(tokens []Token) int64 {
+	bits := int64(17 + 3*len(codes.codeLengthHuffmanLengths))
+	for _, code := range codes.codeLength.HuffmanLengthCondensed {
+		bits += int64(codes.codeLength.CondensedHuffman[code.RLECode].GetLength()) + int64(codetables.CodeLength.ExtraBits(code.RLECode))
 	}
+	for _, token := range tokens {
+		if token.Kind == LiteralToken {
+			bits += int64(codes.litLength.LitLengthHuffman[token.Value].GetLength())
+			continue
+		}
+		bits += int64(codes.litLength.LitLengthHuffman[token.LengthCode].GetLength()) + int64(codetables.Length.ExtraBits(token.LengthCode))
+		bits += int64(codes.distance.DistanceHuffman[token.DistanceCode].GetLength()) + int64(codetables.Distance.ExtraBits(token.DistanceCode))
+	}
+	return bits + int64(codes.litLength.LitLengthHuffman[256].GetLength())
+}
+
+// writeDynamicBlock encodes tokens as a single block with the dynamic
+// Huffman codes built for them
+func (cw *CompressionWriter) writeDynamicBlock(tokens []Token, codes *dynamicCodes, bfinal uint32) error {
+	newLitLengthCode, newDistanceCode, newCodeLengthCode := codes.litLength, codes.distance, codes.codeLength
+	litLenHuffmanLengths, distHuffmanLengths, codeLengthHuffmanLengths := codes.litLengthLengths, codes.distanceLengths, codes.codeLengthHuffmanLengths
+	cw.core.profile.DynamicBlocks++
+	cw.core.profile.HeaderBits += 17 // BFINAL, BTYPE, HLIT, HDIST and HCLEN
+	tableStart := cw.core.bitBuffer.bitsWritten + 17
 	HLIT := len(litLenHuffmanLengths) - 257
 	HDIST := len(distHuffmanLengths) - 1
 	HCLEN := len(codeLengthHuffmanLengths) - 4
-	cw.core.lock.Lock()
-	defer cw.core.lock.Unlock()
-	// fmt.printf("[ flate.CompressionWriter.compress ] bfinal: %v, bits: %v\n", cw.core.bfinal, 1)
-	cw.writeCompressedContent(cw.core.bfinal, 1)
-	// fmt.printf("[ flate.CompressionWriter.compress ] btype: %v, bits: %v\n", cw.core.btype, 2)
-	cw.writeCompressedContent(cw.core.btype, 2)
+	// fmt.printf("[ flate.CompressionWriter.compress ] bfinal: %v, bits: %v\n", bfinal, 1)
+	cw.writeCompressedContent(bfinal, 1)
+	cw.writeCompressedContent(2, 2)
 	// fmt.printf("[ flate.CompressionWriter.compress ] HLIT: %v, bits: %v\n", uint32(HLIT), 5)
 	cw.writeCompressedContent(uint32(HLIT), 5)
 	// fmt.printf("[ flate.CompressionWriter.compress ] HDIST: %v, bits: %v\n", uint32(HDIST), 5)
@@ -495,37 +827,137 @@ func (cw *CompressionWriter) compress(content []byte) error {
 		condensedHuff := newCodeLengthCode.CondensedHuffman[code.RLECode]
 		// fmt.printf("[ flate.CompressionWriter.compress ] Condensed -- RLECode: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", code.RLECode, condensedHuff.GetValue(), condensedHuff.GetLength())
 		cw.writeCompressedContent(huffman.Reverse(uint32(condensedHuff.GetValue()), uint32(condensedHuff.GetLength())), uint(condensedHuff.GetLength()))
-		if rleAlphabets.Alphabets[code.RLECode].ExtraBits > 0 {
-			// fmt.printf("[ flate.CompressionWriter.compress ] Condensed -- RLECode: %v, Offset: %v --- bitlength: %v\n", code.RLECode, code.Offset, rleAlphabets.Alphabets[code.RLECode].ExtraBits)
-			cw.writeCompressedContent(uint32(code.Offset), uint(rleAlphabets.Alphabets[code.RLECode].ExtraBits))
+		if codetables.CodeLength.ExtraBits(code.RLECode) > 0 {
+			// fmt.printf("[ flate.CompressionWriter.compress ] Condensed -- RLECode: %v, Offset: %v --- bitlength: %v\n", code.RLECode, code.Offset, codetables.CodeLength.ExtraBits(code.RLECode))
+			cw.writeCompressedContent(uint32(code.Offset), uint(codetables.CodeLength.ExtraBits(code.RLECode)))
 		}
 	}
+	cw.core.profile.TableBits += cw.core.bitBuffer.bitsWritten - tableStart
 	for _, token := range tokens {
 		if token.Kind == LiteralToken {
+			cw.core.profile.AddLiteral()
 			litLenHuff := newLitLengthCode.LitLengthHuffman[token.Value]
 			// fmt.printf("[ flate.CompressionWriter.compress ] Literal: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", string(token.Value), litLenHuff.GetValue(), litLenHuff.GetLength())
 			cw.writeCompressedContent(huffman.Reverse(uint32(litLenHuff.GetValue()), uint32(litLenHuff.GetLength())), uint(litLenHuff.GetLength()))
 		} else {
+			cw.core.profile.AddMatch(token.Length, token.Distance)
 			litLenHuff := newLitLengthCode.LitLengthHuffman[token.LengthCode]
 			// fmt.printf("[ flate.CompressionWriter.compress ] Length: %v, LengthCode: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", token.Length, token.LengthCode, litLenHuff.GetValue(), litLenHuff.GetLength())
 			cw.writeCompressedContent(huffman.Reverse(uint32(litLenHuff.GetValue()), uint32(litLenHuff.GetLength())), uint(litLenHuff.GetLength()))
-			if lenAlphabets.Alphabets[token.LengthCode].ExtraBits > 0 {
-				// fmt.printf("[ flate.CompressionWriter.compress ] Length: %v, LengthCode: %v, Offset: %v --- bitLength: %v\n", token.Length, litLenHuff.GetValue(), token.LengthOffset, lenAlphabets.Alphabets[token.LengthCode].ExtraBits)
-				cw.writeCompressedContent(uint32(token.LengthOffset), uint(lenAlphabets.Alphabets[token.LengthCode].ExtraBits))
+			if codetables.Length.ExtraBits(token.LengthCode) > 0 {
+				// fmt.printf("[ flate.CompressionWriter.compress ] Length: %v, LengthCode: %v, Offset: %v --- bitLength: %v\n", token.Length, litLenHuff.GetValue(), token.LengthOffset, codetables.Length.ExtraBits(token.LengthCode))
+				cw.writeCompressedContent(uint32(token.LengthOffset), uint(codetables.Length.ExtraBits(token.LengthCode)))
 			}
 			distHuff := newDistanceCode.DistanceHuffman[token.DistanceCode]
 			// fmt.printf("[ flate.CompressionWriter.compress ] Distance: %v, DistanceCode: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", token.Distance, token.DistanceCode, distHuff.GetValue(), distHuff.GetLength())
 			cw.writeCompressedContent(huffman.Reverse(uint32(distHuff.GetValue()), uint32(distHuff.GetLength())), uint(distHuff.GetLength()))
-			if distAlphabets.Alphabets[token.DistanceCode].ExtraBits > 0 {
-				// fmt.printf("[ flate.CompressionWriter.compress ] Distance: %v, DistanceCode: %v, Offset: %v --- bitLength: %v\n", token.Distance, token.DistanceCode, token.DistanceOffset, distAlphabets.Alphabets[token.DistanceCode].ExtraBits)
-				cw.writeCompressedContent(uint32(token.DistanceOffset), uint(distAlphabets.Alphabets[token.DistanceCode].ExtraBits))
+			if codetables.Distance.ExtraBits(token.DistanceCode) > 0 {
+				// fmt.printf("[ flate.CompressionWriter.compress ] Distance: %v, DistanceCode: %v, Offset: %v --- bitLength: %v\n", token.Distance, token.DistanceCode, token.DistanceOffset, codetables.Distance.ExtraBits(token.DistanceCode))
+				cw.writeCompressedContent(uint32(token.DistanceOffset), uint(codetables.Distance.ExtraBits(token.DistanceCode)))
 			}
 		}
 	}
 	eobHuff := newLitLengthCode.LitLengthHuffman[256]
+	cw.core.profile.HeaderBits += int64(eobHuff.GetLength())
 	// fmt.printf("[ flate.CompressionWriter.compress ] EOB: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", 256, eobHuff.GetValue(), eobHuff.GetLength())
-	cw.writeCompressedContent(huffman.Reverse(uint32(eobHuff.GetValue()), uint32(eobHuff.GetLength())), uint(eobHuff.GetLength()))
-	return cw.flushAlign()
+	return cw.writeCompressedContent(huffman.Reverse(uint32(eobHuff.GetValue()), uint32(eobHuff.GetLength())), uint(eobHuff.GetLength()))
+}
+
+// writeTinyBlock writes content without looking for matches, as a stored
+// block or with the fixed Huffman codes depending on which comes out smaller
+func (cw *CompressionWriter) writeTinyBlock(content []byte, bfinal uint32) error {
+	_, span := telemetry.Start(cw.core.ctx, "flate.tiny_block", attribute.Int("flate.input_bytes", len(content)))
+	defer span.End()
+	fixedBits := int(fixedLitLengthLengths[256])
+	for _, b := range content {
+		fixedBits += int(fixedLitLengthLengths[b])
+	}
+	// a stored block is padded to a byte boundary after its header and
+	// starts with LEN and NLEN
+	padding := (8 - (cw.core.bitBuffer.bitsCount+3)%8) % 8
+	storedBits := int(padding) + 32 + 8*len(content)
+	if storedBits < fixedBits {
+		return cw.writeStoredBlock(content, bfinal)
+	}
+	tokens := make([]Token, len(content))
+	for i, b := range content {
+		tokens[i] = Token{Kind: LiteralToken, Value: b}
+	}
+	cw.core.memory.Set(memory.Working, len(tokens)*tokenSize)
+	return cw.writeFixedBlock(tokens, bfinal)
+}
+
+// maxStoredBlockSize is the most a stored block can hold, LEN has 16 bits
+const maxStoredBlockSize = 1<<16 - 1
+
+// storedBlockSize is the most a stored block of the writer holds
+func (cw *CompressionWriter) storedBlockSize() int {
+	if cw.core.blockSize > 0 {
+		return min(cw.core.blockSize, maxStoredBlockSize)
+	}
+	return maxStoredBlockSize
+}
+
+// writeStoredBlock writes data uncompressed, split into as many stored blocks
+// as it needs. Only the last one carries bfinal.
+func (cw *CompressionWriter) writeStoredBlock(data []byte, bfinal uint32) error {
+	for {
+		size := min(len(data), cw.storedBlockSize())
+		final := uint32(0)
+		if size == len(data) {
+			final = bfinal
+		}
+		headerStart := cw.core.bitBuffer.bitsWritten
+		cw.writeCompressedContent(final, 1)
+		cw.writeCompressedContent(0, 2)
+		cw.writeCompressedContent(0, (8-cw.core.bitBuffer.bitsCount)%8)
+		cw.writeCompressedContent(uint32(size), 16)
+		if err := cw.writeCompressedContent(^uint32(size)&0xffff, 16); err != nil {
+			return err
+		}
+		cw.core.profile.StoredBlocks++
+		cw.core.profile.StoredBytes += int64(size)
+		cw.core.profile.HeaderBits += cw.core.bitBuffer.bitsWritten - headerStart
+		// the bit buffer is empty at a byte boundary, so the bytes go straight out
+		if _, err := cw.core.bufferedOutput.Write(data[:size]); err != nil {
+			return err
+		}
+		cw.core.bitBuffer.bitsWritten += 8 * int64(size)
+		data = data[size:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeFixedBlock encodes tokens as a single block with the fixed Huffman
+// codes, which need no tables in the block
+func (cw *CompressionWriter) writeFixedBlock(tokens []Token, bfinal uint32) error {
+	cw.writeCompressedContent(bfinal, 1)
+	cw.writeCompressedContent(1, 2)
+	cw.core.profile.FixedBlocks++
+	cw.core.profile.HeaderBits += 3 + int64(fixedLitLengthLengths[256])
+	for _, token := range tokens {
+		if token.Kind == LiteralToken {
+			cw.core.profile.AddLiteral()
+			cw.writeCompressedContent(fixedLitLengthCodes[token.Value], uint(fixedLitLengthLengths[token.Value]))
+			continue
+		}
+		lengthCode, lengthOffset, err := new(LitLengthCode).FindCode(token.Length)
+		if err != nil {
+			return err
+		}
+		distanceCode, distanceOffset, err := new(DistanceCode).FindCode(token.Distance)
+		if err != nil {
+			return err
+		}
+		cw.core.profile.AddMatch(token.Length, token.Distance)
+		cw.writeCompressedContent(fixedLitLengthCodes[lengthCode], uint(fixedLitLengthLengths[lengthCode]))
+		cw.writeCompressedContent(uint32(lengthOffset), uint(codetables.Length.ExtraBits(lengthCode)))
+		cw.writeCompressedContent(fixedDistanceCodes[distanceCode], uint(fixedDistanceLengths[distanceCode]))
+		cw.writeCompressedContent(uint32(distanceOffset), uint(codetables.Distance.ExtraBits(distanceCode)))
+	}
+	return cw.writeCompressedContent(fixedLitLengthCodes[256], uint(fixedLitLengthLengths[256]))
 }
 
 func (cw *CompressionWriter) writeCompressedContent(value uint32, nbits uint) error {
@@ -535,11 +967,12 @@ func (cw *CompressionWriter) writeCompressedContent(value uint32, nbits uint) er
 	}
 	// fmt.printf("[ flate.CompressionWriter.writeCompressedContent ] value: %0*b, nbits: %v\n", nbits, value, nbits)
 	trimbits := min(nbits, 32-bb.bitsCount)
+	bb.bitsWritten += int64(trimbits)
 	bb.bitsHolder |= (value & ((1 << trimbits) - 1)) << uint32(bb.bitsCount)
 	bb.bitsCount += trimbits
 	for bb.bitsCount >= 8 {
 		lowestByte := byte(bb.bitsHolder & 0xFF)
-		if _, err := cw.core.outputBuffer.Write([]byte{lowestByte}); err != nil {
+		if err := cw.core.bufferedOutput.WriteByte(lowestByte); err != nil {
 			return err
 		}
 		// fmt.printf("[ flate.writeCompressedContent ] Emitted lowestByte: %08b\n", lowestByte)
@@ -550,53 +983,66 @@ func (cw *CompressionWriter) writeCompressedContent(value uint32, nbits uint) er
 	return cw.writeCompressedContent(value, nbits-trimbits)
 }
 
+// finish ends the output on a byte boundary. Output left without a final
+// block gets an empty stored block first, as a sync flush writes, so the
+// blocks of another stream can follow it directly.
+func (cw *CompressionWriter) finish() error {
+	if cw.core.bfinal == 0 {
+		if err := cw.writeStoredBlock(nil, 0); err != nil {
+			return err
+		}
+	}
+	return cw.flushAlign()
+}
+
 func (cw *CompressionWriter) flushAlign() error {
 	bb := cw.core.bitBuffer
 	if bb.bitsCount > 8 {
 		return errors.New("bits not written to the output buffer yet")
 	}
 	if bb.bitsCount > 0 {
+		cw.core.profile.HeaderBits += int64(8 - bb.bitsCount)
 		// fmt.printf("[ flate.bitBuffer.flushAlign ] pad with %v bits\n", 8-bb.bitsCount)
-		return cw.writeCompressedContent(0, 8-bb.bitsCount)
+		if err := cw.writeCompressedContent(0, 8-bb.bitsCount); err != nil {
+			return err
+		}
 	}
 	// fmt.printf("[ flate.bitBuffer.flushAlign ] no padding needed\n")
-	return nil
+	// the pipe holds nothing, what waits for the reader is in the bufio buffer
+	return cw.core.bufferedOutput.Flush()
 }
 
-func tokeniseLZSS(refChannels []chan lzss.Reference) ([]Token, error) {
+// tokenise turns the references matcher finds up to position end into
+// tokens. The last match may reach past end, the matcher then continues
+// after it.
+func tokenise(matcher lzss.Matcher, end int) ([]Token, error) {
 	var tokens []Token
-	nextRunesToIgnore := 0
-	for _, channel := range refChannels {
-		ref := <-channel
-		if nextRunesToIgnore > 0 {
-			nextRunesToIgnore--
-		} else if !ref.IsRef || ref.Size < 3 {
-			literalBytes := []byte(string(ref.Value[0]))
-			// fmt.printf("[ flate.tokeniseLZSS ] no match on index %v -- literal: %v\n", i, string(ref.Value[0]))
-			for _, literalByte := range literalBytes {
-				token := Token{
-					Kind:  LiteralToken,
-					Value: literalByte,
-				}
-				tokens = append(tokens, token)
+	for matcher.Position() < end {
+		ref, ok := matcher.NextToken()
+		if !ok {
+			break
+		}
+		if !ref.IsRef || ref.Size < 3 {
+			// fmt.printf("[ flate.tokenise ] no match on index %v -- literal: %v\n", i, string(ref.Value[0]))
+			token := Token{
+				Kind:  LiteralToken,
+				Value: ref.Value[0],
 			}
+			tokens = append(tokens, token)
 		} else {
-			if ref.Size > ref.NegativeOffset {
-				return nil, errors.New("token match overlapping with the reference")
-			}
 			if ref.Size > maxAllowedMatchLength {
 				return nil, fmt.Errorf("token match cannot be longer than %v\n", maxAllowedMatchLength)
 			}
 			if ref.NegativeOffset > maxAllowedBackwardDistance {
 				return nil, fmt.Errorf("token match cannot be farther backward than %v\n", maxAllowedBackwardDistance)
 			}
-			nextRunesToIgnore = ref.Size - 1
+			matcher.Skip(ref.Size - 1)
 			token := Token{
 				Kind:     MatchToken,
 				Length:   ref.Size,
 				Distance: ref.NegativeOffset,
 			}
-			// fmt.printf("[ flate.tokeniseLZSS ] match on index %v -- Length: %v, Distance: %v\n", i, ref.Size, ref.NegativeOffset)
+			// fmt.printf("[ flate.tokenise ] match on index %v -- Length: %v, Distance: %v\n", i, ref.Size, ref.NegativeOffset)
 			tokens = append(tokens, token)
 		}
 	}
@@ -2,129 +2,487 @@ package flate
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"sync"
 
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrInvalidHeader matches every HeaderError, so malformed input can be told
+// apart from truncated input with errors.Is
+var ErrInvalidHeader = errors.New("invalid dynamic block header")
+
+// ErrStoredLength is returned for a stored block whose NLEN is not the
+// complement of its LEN
+var ErrStoredLength = errors.New("stored block length does not match its complement")
+
+// HeaderError reports which part of a dynamic block header is malformed
+type HeaderError struct {
+	Field  string // HLIT, HDIST, code lengths, or the code that failed to build
+	Reason string
+	Err    error // underlying cause, e.g. huffman.ErrOversubscribed
+}
+
+func (e *HeaderError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v: %v", ErrInvalidHeader, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%v: %v: %v", ErrInvalidHeader, e.Field, e.Reason)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HeaderError) Is(target error) bool {
+	return target == ErrInvalidHeader
+}
+
 type DecompressionWriter struct {
 	core *decompressionCore
 }
 type DecompressionReader struct {
 	core *decompressionCore
 }
+// decompressionCore decodes the input a block at a time as it is written and
+// writes what every block decodes to into a pipe, so a Write blocks until
+// the reader has taken the output of the blocks it completed. Back
+// references are resolved against a window of the last 32 KiB of output, the
+// output itself is not held.
 type decompressionCore struct {
-	isInputBufferClosed bool
-	isEobReached        bool
-	cond                *sync.Cond
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
-	bitBuffer           *bitBuffer
-	btype               uint32
-	bfinal              uint32
-	readChannel         chan byte
+	isWriterClosed       bool // Close was called, decompressing may still be under way
+	lock                 sync.Mutex
+	err                  error // what decoding failed with, every later Write returns it
+	started              bool  // the window is primed, on the first Write or at Close
+	input                []byte // the input from the block being read on
+	waitFor              int    // how long input has to get before the block is read again
+	pipeReader           *io.PipeReader
+	pipeWriter           *io.PipeWriter
+	output               io.Writer // pipeWriter, or the buffer Inflate collects into
+	bitReader            *BitReader
+	window               *window
+	decoded              []byte // what the last block decoded to, reused by the next
+	btype                uint32
+	bfinal               uint32
+	blocks               int  // blocks read in full
+	done                 bool // the final block was read, the input after it is unused
+	unused               []byte // input that follows the final block
+	ctx                  context.Context
+	span                 trace.Span // the inflate span, from the first Write to Close
+	inputBytes           int
+	outputBytes          int
+	memory               *memory.Tracker
+	dictionary           []byte // preset data back references may reach into
+	flushes              []int  // the output offsets of the flushes, when recordFlushes is set
+	flushed              bool   // the last block was an empty stored block
+	recordFlushes        bool
 }
 
+// Read returns the decompressed data as Write and Close decode it, and the
+// error decoding failed with once it is all read. On truncated input that is
+// the data decoded up to the cut followed by the error.
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
-	dr.core.lock.Lock()
-	defer dr.core.lock.Unlock()
-	for !dr.core.isInputBufferClosed {
-		dr.core.cond.Wait()
-	}
-	return dr.core.outputBuffer.Read(data)
+	return dr.core.pipeReader.Read(data)
 }
 
 func (dr *DecompressionReader) Close() error {
+	// a Read still waiting for the writer gives up instead of blocking
+	// forever, and a Write or Close blocked on writing to the pipe fails; the
+	// lock is taken after that since they hold it while they write
+	dr.core.pipeReader.Close()
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
-	if buf, ok := dr.core.inputBuffer.(*bytes.Buffer); ok {
-		buf.Reset()
-		dr.core.isInputBufferClosed = false
-		return nil
-	} else {
-		return errors.New("underlying io.ReadWriter is not *bytes.Buffer. Type assertion failed")
-	}
+	dr.core.input = nil
+	return nil
 }
 
+// Write decodes the blocks data completes and writes their output to the
+// reader, a block that is not complete yet waits for the writes after it
 func (dw *DecompressionWriter) Write(data []byte) (int, error) {
-	dw.core.lock.Lock()
-	defer dw.core.lock.Unlock()
-	if dw.core.isInputBufferClosed {
-		return 0, errors.New("reading from the compression stream for the previous block has not completed yet!")
+	core := dw.core
+	core.lock.Lock()
+	defer core.lock.Unlock()
+	if core.isWriterClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if core.err != nil {
+		return 0, core.err
+	}
+	dw.start()
+	core.inputBytes += len(data)
+	if core.done {
+		// e.g. the trailer of a gzip member
+		core.unused = append(core.unused, data...)
+		return len(data), nil
+	}
+	core.input = append(core.input, data...)
+	core.bitReader.data = core.input
+	core.memory.Set(memory.Input, cap(core.input))
+	if len(core.input) < core.waitFor {
+		return len(data), nil
 	}
-	// fmt.Printf("[ flate.DecompressionWriter.Write ] data written to the inputBuffer\n")
-	return dw.core.inputBuffer.Write(data)
+	if err := core.decode(false); err != nil {
+		core.err = err
+		return 0, err
+	}
+	return len(data), nil
 }
 
+// Close decodes the rest of the input, which has to end the stream
 func (dw *DecompressionWriter) Close() error {
-	if err := dw.decompress(); err != nil {
-		return err
-	} else {
-		dw.core.lock.Lock()
-		defer dw.core.lock.Unlock()
+	err := dw.finish()
+	// the reader is released on failure too, otherwise it would wait forever;
+	// a second Close leaves the error of the first in place
+	dw.core.pipeWriter.CloseWithError(err)
+	return err
+}
+
+// finish decodes the rest of the input and ends the inflate span
+func (dw *DecompressionWriter) finish() error {
+	core := dw.core
+	core.lock.Lock()
+	defer core.lock.Unlock()
+	if core.isWriterClosed {
+		return io.ErrClosedPipe
+	}
+	core.isWriterClosed = true
+	dw.start()
+	err := core.err
+	if err == nil {
+		err = core.decode(true)
+	}
+	core.span.SetAttributes(attribute.Int("flate.input_bytes", core.inputBytes), attribute.Int("flate.output_bytes", core.outputBytes))
+	telemetry.End(core.span, err)
+	core.input, core.decoded = nil, nil
+	return err
+}
 
-		dw.core.isInputBufferClosed = true
-		dw.core.cond.Signal()
-		return nil
+// start starts the inflate span and primes the window, the lock has to be held
+func (dw *DecompressionWriter) start() {
+	if !dw.core.started {
+		_, dw.core.span = telemetry.Start(dw.core.ctx, "flate.inflate")
+		dw.core.start()
 	}
 }
 
 func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
-	newDecompressionCore := new(decompressionCore)
-	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
-	newDecompressionCore.bitBuffer = new(bitBuffer)
-	newDecompressionCore.isInputBufferClosed = false
-	newDecompressionCore.readChannel = make(chan byte)
-	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
+	newDecompressionCore := newDecompressionCore()
+	newDecompressionCore.pipeReader, newDecompressionCore.pipeWriter = io.Pipe()
+	newDecompressionCore.output = newDecompressionCore.pipeWriter
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	// fmt.Printf("[ flate.NewDecompressionReaderAndWriter ] newDecompressionCore: %v\n", newDecompressionCore)
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func (dw *DecompressionWriter) decompress() error {
+func newDecompressionCore() *decompressionCore {
+	return &decompressionCore{
+		ctx:       context.Background(),
+		memory:    memory.NewTracker(maxAllowedBackwardDistance),
+		bitReader: NewBitReader(nil),
+	}
+}
+
+// SetContext sets the context the inflate span is started in, it has to be
+// called before Write
+func (dw *DecompressionWriter) SetContext(ctx context.Context) {
+	dw.core.ctx = ctx
+}
+
+// SetDictionary sets the data the compressor was given as a dictionary, back
+// references may reach into it as if it preceded the output. It has to be
+// called before Write.
+func (dw *DecompressionWriter) SetDictionary(dict []byte) {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	dw.core.dictionary = dict[max(len(dict)-maxAllowedBackwardDistance, 0):]
+}
 
-	dataReader := func(nbits uint) (uint32, error) {
-		return readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, nbits)
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (dw *DecompressionWriter) MemoryUsage() memory.Usage {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.memory.Usage()
+}
+
+// Unused returns the input that followed the final block, e.g. the trailer
+// of a gzip member. It is empty for streams that end with their last block
+// and only complete once Close has returned.
+func (dw *DecompressionWriter) Unused() []byte {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.unused
+}
+
+// Inflate decodes the deflate stream at the start of input and returns it
+// together with the input that follows its final block. On truncated input
+// the data decoded up to the cut is returned along with the error.
+func Inflate(input []byte) ([]byte, []byte, error) {
+	core := newDecompressionCore()
+	return core.inflate(input)
+}
+
+// InflateSegments decodes a deflate stream like Inflate and splits what it
+// decodes where the stream was flushed: at the empty non-final stored blocks
+// a partial stream of this package ends with, and other encoders write on a
+// sync flush. Back references may still reach across the splits. A stream
+// without flushes is one segment, an empty segment after the last flush is
+// left out.
+func InflateSegments(input []byte) ([][]byte, error) {
+	core := newDecompressionCore()
+	core.recordFlushes = true
+	data, _, err := core.inflate(input)
+	if err != nil {
+		return nil, err
 	}
-	// bfinal
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 1); err != nil {
+	segments := make([][]byte, 0, len(core.flushes)+1)
+	start := 0
+	for _, flush := range core.flushes {
+		segments = append(segments, data[start:flush])
+		start = flush
+	}
+	if start < len(data) || len(segments) == 0 {
+		segments = append(segments, data[start:])
+	}
+	return segments, nil
+}
+
+// inflate decodes the whole of input at once into a buffer
+func (core *decompressionCore) inflate(input []byte) ([]byte, []byte, error) {
+	var output bytes.Buffer
+	core.output = &output
+	core.start()
+	core.input = input
+	core.bitReader.data = input
+	err := core.decode(true)
+	switch {
+	case errors.Is(err, ErrUnexpectedEOF):
+		// the stream was cut off, pass on what was decoded up to that point
+		return output.Bytes(), nil, err
+	case err != nil:
+		return nil, nil, err
+	}
+	return output.Bytes(), core.unused, nil
+}
+
+// start primes the window with the dictionary
+func (core *decompressionCore) start() {
+	core.started = true
+	core.window = new(window)
+	core.window.write(core.dictionary)
+	core.memory.Set(memory.Working, len(core.window.buf))
+}
+
+// decode reads the blocks input holds in full and writes what they decode
+// to to the output. A block the input ends in waits for more, unless final
+// is set: then the input is all there is, what was read of the block is
+// written and ErrUnexpectedEOF returned.
+func (core *decompressionCore) decode(final bool) error {
+	br := core.bitReader
+	for !core.done {
+		if final && br.Exhausted() {
+			if core.blocks == 0 && len(core.input) == 0 {
+				// even an empty input compresses to one block, so this is never valid
+				return errors.New("compressed data is empty")
+			}
+			if core.blocks > 0 {
+				// streams whose last block was written without BFINAL end
+				// where only padding is left
+				core.end()
+				break
+			}
+		}
+		saved := *br
+		tokens, err := core.readBlock()
+		core.memory.Set(memory.Working, len(core.window.buf)+cap(tokens)*tokenSize)
+		if errors.Is(err, ErrUnexpectedEOF) {
+			if !final {
+				// the block is read again from its start once the input grew
+				// by a quarter of what it holds of it, so a large block that
+				// arrives in small writes is not read over and over
+				*br = saved
+				core.waitFor = len(core.input) + max((len(core.input)-br.Offset())/4, 1)
+				return nil
+			}
+			// the stream was cut off, pass on what was decoded up to that point
+			core.emit(tokens)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if err := core.emit(tokens); err != nil {
+			return err
+		}
+		core.blocks++
+		if core.recordFlushes {
+			// an empty stored block marks a flush, a run of them only one
+			empty := core.btype == 0 && len(tokens) == 0
+			if empty && core.bfinal == 0 && !core.flushed {
+				core.flushes = append(core.flushes, core.outputBytes)
+			}
+			core.flushed = empty
+		}
+		if core.bfinal == 1 {
+			core.end()
+			break
+		}
+		if !final {
+			// the input before the bits the reader holds is done with
+			cut := br.Offset()
+			core.input = append(core.input[:0], core.input[cut:]...)
+			br.data, br.pos = core.input, br.pos-cut
+			core.memory.Set(memory.Input, cap(core.input))
+		}
+	}
+	return nil
+}
+
+// end keeps the input that follows the final block as unused
+func (core *decompressionCore) end() {
+	core.done = true
+	core.bitReader.AlignToByte()
+	core.unused = append(core.unused, core.input[core.bitReader.Offset():]...)
+	core.input = nil
+	*core.bitReader = BitReader{}
+}
+
+// emit decodes the tokens of a block and writes them to the output
+func (core *decompressionCore) emit(tokens []Token) error {
+	decoded, err := core.window.decode(core.decoded[:0], tokens)
+	core.decoded = decoded
+	core.memory.Set(memory.Result, cap(decoded))
+	if err != nil {
 		return err
+	}
+	core.outputBytes += len(decoded)
+	_, err = core.output.Write(decoded)
+	return err
+}
+
+// tokenLength is the number of bytes a token decodes to
+func tokenLength(token Token) int {
+	if token.Kind == MatchToken {
+		return token.Length
+	}
+	return 1
+}
+
+// readBlock reads the header and tokens of a single block
+func (core *decompressionCore) readBlock() ([]Token, error) {
+	br := core.bitReader
+	// bfinal
+	if input, err := br.ReadBits(1); err != nil {
+		return nil, err
 	} else {
-		dw.core.bfinal = input
+		core.bfinal = input
 	}
 
 	// btype
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 2); err != nil {
-		return err
+	if input, err := br.ReadBits(2); err != nil {
+		return nil, err
 	} else {
-		dw.core.btype = input
+		core.btype = input
+	}
+
+	switch core.btype {
+	case 0:
+		return readStoredBlock(br)
+	case 1:
+		return readFixedBlock(br)
+	case 2:
+		return readDynamicBlock(br)
+	default:
+		return nil, fmt.Errorf("invalid block type %v", core.btype)
+	}
+}
+
+// readStoredBlock reads the LEN/NLEN header of an uncompressed block and
+// returns its bytes as literals
+func readStoredBlock(br *BitReader) ([]Token, error) {
+	br.AlignToByte()
+	var header [4]byte
+	for i := range header {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, ErrUnexpectedEOF
+		}
+		header[i] = b
+	}
+	length := uint16(header[0]) | uint16(header[1])<<8
+	nlength := uint16(header[2]) | uint16(header[3])<<8
+	if length != ^nlength {
+		return nil, fmt.Errorf("%w: LEN %v, NLEN %v", ErrStoredLength, length, nlength)
+	}
+	tokens := make([]Token, 0, length)
+	for range length {
+		b, err := br.ReadByte()
+		if err != nil {
+			return tokens, ErrUnexpectedEOF
+		}
+		tokens = append(tokens, Token{Kind: LiteralToken, Value: b})
+	}
+	return tokens, nil
+}
+
+var (
+	fixedCodesOnce     sync.Once
+	fixedLitLengthCode *LitLengthCode
+	fixedDistanceCode  *DistanceCode
+	fixedCodesErr      error
+)
+
+// buildFixedCodes builds the decoding trees of the fixed Huffman codes on
+// first use, or by Warm
+func buildFixedCodes() error {
+	fixedCodesOnce.Do(func() {
+		fixedLitLengthCode, fixedDistanceCode = new(LitLengthCode), new(DistanceCode)
+		if fixedCodesErr = fixedLitLengthCode.BuildHuffmanTree(fixedLitLengthLengths); fixedCodesErr != nil {
+			return
+		}
+		fixedCodesErr = fixedDistanceCode.BuildHuffmanTree(fixedDistanceLengths)
+	})
+	return fixedCodesErr
+}
+
+// readFixedBlock reads the tokens of a block coded with the fixed Huffman codes
+func readFixedBlock(br *BitReader) ([]Token, error) {
+	if err := buildFixedCodes(); err != nil {
+		return nil, err
 	}
+	return ReadTokens(br, fixedLitLengthCode, fixedDistanceCode)
+}
 
+// readDynamicBlock reads the code tables of a dynamic Huffman block and then
+// its tokens
+func readDynamicBlock(br *BitReader) ([]Token, error) {
 	var HLIT, HDIST, HCLEN uint32
 
 	// HLIT
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 5); err != nil {
-		return err
+	if input, err := br.ReadBits(5); err != nil {
+		return nil, err
 	} else {
 		HLIT = input
 	}
 	// HDIST
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 5); err != nil {
-		return err
+	if input, err := br.ReadBits(5); err != nil {
+		return nil, err
 	} else {
 		HDIST = input
 	}
 
 	// HCLEN
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 4); err != nil {
-		return err
+	if input, err := br.ReadBits(4); err != nil {
+		return nil, err
 	} else {
 		HCLEN = input
 	}
@@ -133,88 +491,70 @@ func (dw *DecompressionWriter) decompress() error {
 	HDIST += 1
 	HCLEN += 4
 
+	// the fields can encode 288 and 32 codes but the alphabets only have 286
+	// and 30 (RFC 1951 section 3.2.7), HCLEN can never exceed 19
+	if HLIT > 286 {
+		return nil, &HeaderError{Field: "HLIT", Reason: fmt.Sprintf("%v literal/length codes, at most 286 exist", HLIT)}
+	}
+	if HDIST > 30 {
+		return nil, &HeaderError{Field: "HDIST", Reason: fmt.Sprintf("%v distance codes, at most 30 exist", HDIST)}
+	}
+
 	// fmt.Printf("[ flate.DecompressionWriter.decompress ] HLIT: %v, HDIST: %v, HCLEN: %v\n", HLIT, HDIST, HCLEN)
 
 	// Code-Length Huffman Length
 	var codeLengthHuffmanLengths []uint32
 	for range HCLEN {
-		if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 3); err != nil {
-			return err
+		if input, err := br.ReadBits(3); err != nil {
+			return nil, err
 		} else {
 			codeLengthHuffmanLengths = append(codeLengthHuffmanLengths, input)
 		}
 	}
 	// fmt.Printf("[ flate.DecompressionWriter.decompress ] codeLengthHuffmanLengths: %v\n", codeLengthHuffmanLengths)
 	newCodeLengthCode := new(CodeLengthCode)
-	newCodeLengthCode.BuildHuffmanTree(codeLengthHuffmanLengths)
+	if err := newCodeLengthCode.BuildHuffmanTree(codeLengthHuffmanLengths); err != nil {
+		return nil, &HeaderError{Field: "code length code", Err: err}
+	}
 
 	// Expanded Huffman Lengths
 	newLitLengthCode := new(LitLengthCode)
 	newDistanceCode := new(DistanceCode)
-	if litLenHuffmanLengths, distHuffmanLengths, err := newCodeLengthCode.ReadCondensedHuffman(dataReader, HLIT, HDIST); err != nil {
-		return err
+	if litLenHuffmanLengths, distHuffmanLengths, err := newCodeLengthCode.ReadCondensedHuffman(br, HLIT, HDIST); err != nil {
+		return nil, err
 	} else {
 		// fmt.printf("[ flate.DecompressionWriter.decompress ] len(litLenHuffmanLengths): %v, len(distHuffmanLengths): %v\n", len(litLenHuffmanLengths), len(distHuffmanLengths))
 		// fmt.printf("[ flate.DecompressionWriter.decompress ] litLenHuffmanLengths: %v, distHuffmanLengths: %v\n", litLenHuffmanLengths, distHuffmanLengths)
+		if litLenHuffmanLengths[256] == 0 {
+			return nil, &HeaderError{Field: "literal/length code", Reason: "the end-of-block symbol has no code"}
+		}
 		if err := newLitLengthCode.BuildHuffmanTree(litLenHuffmanLengths); err != nil {
-			return err
+			return nil, &HeaderError{Field: "literal/length code", Err: err}
 		}
 		if err := newDistanceCode.BuildHuffmanTree(distHuffmanLengths); err != nil {
-			return err
+			return nil, &HeaderError{Field: "distance code", Err: err}
 		}
 	}
 	// Now I have built all the huffman tree
 	// Read Token, the huffman code is decoded.
-	if tokens, err := ReadTokens(dataReader, newLitLengthCode, newDistanceCode); err != nil {
-		return err
-	} else {
-		// tokens should be converted into text as the decompressed data
-		data := DecodeTokens(tokens)
-		// fmt.printf("[ flate.DecompressionWriter.decompress ] decompressed data: %v\n", string(data))
-		if _, err := dw.core.outputBuffer.Write(data); err != nil {
-			return err
-		}
-	}
-	return nil
+	return ReadTokens(br, newLitLengthCode, newDistanceCode)
 }
 
-func DecodeTokens(tokens []Token) []byte {
-	var output []byte
-	findMatch := func(length, negOffset int) {
-		outputSoFarRune := []rune(string(output))
-		currentIdx := len(outputSoFarRune)
-		startIdx := currentIdx - negOffset
-		endIdx := startIdx + length
-		match := []byte(string(outputSoFarRune[startIdx:endIdx]))
-		// fmt.printf("[ flate.DecodeTokens.findMatch ] outputSoFar: %v\nmatch: %v\n", string(output), string(match))
-		output = append(output, match...)
-	}
-	for _, token := range tokens {
-		switch token.Kind {
-		case LiteralToken:
-			output = append(output, token.Value)
-		case MatchToken:
-			findMatch(token.Length, token.Distance)
-		}
-	}
-	return output
+// DecodeTokens expands literals and back references into the original data,
+// back references have to point into the data produced so far
+func DecodeTokens(tokens []Token) ([]byte, error) {
+	return decodeTokens(nil, tokens)
 }
 
-func readCompressedContent(bb *bitBuffer, inputBuffer io.ReadWriter, nbits uint) (uint32, error) {
-	if nbits > 32 {
-		return 0, errors.New("cannot read more than 32 bits at once.")
+// decodeTokens is DecodeTokens with back references reaching into dict as if
+// it preceded the data, which is left out of what is returned
+func decodeTokens(dict []byte, tokens []Token) ([]byte, error) {
+	w := new(window)
+	w.write(dict[max(len(dict)-maxAllowedBackwardDistance, 0):])
+	output, err := w.decode(nil, tokens)
+	if err != nil {
+		return nil, err
 	}
-	for bb.bitsCount < nbits {
-		newData := make([]byte, 1)
-		if _, err := inputBuffer.Read(newData); err != nil {
-			return 0, fmt.Errorf("not enough bits to read from the compressed data: %v\n", err)
-		}
-		bb.bitsHolder |= uint32(newData[0]) << uint32(bb.bitsCount)
-		bb.bitsCount += 8
-	}
-	output := bb.bitsHolder & ((1 << nbits) - 1)
-	bb.bitsHolder >>= uint32(nbits)
-	bb.bitsCount -= nbits
 	return output, nil
 }
 
@@ -252,7 +592,7 @@ func (dc *DistanceCode) BuildHuffmanTree(huffmanLengths []uint32) error {
 func (clc *CodeLengthCode) reshuffle(huffmanLengths []uint32) []uint32 {
 	lengths := make([]uint32, 19)
 	for i, length := range huffmanLengths {
-		key := rleAlphabets.KeyOrder[i]
+		key := codeLengthOrder[i]
 		lengths[key] = length
 	}
 	// for i, length := range lengths {
@@ -263,15 +603,15 @@ func (clc *CodeLengthCode) reshuffle(huffmanLengths []uint32) []uint32 {
 	return lengths
 }
 
-func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, error), HLIT, HDIST uint32) ([]uint32, []uint32, error) {
+func (clc *CodeLengthCode) ReadCondensedHuffman(br *BitReader, HLIT, HDIST uint32) ([]uint32, []uint32, error) {
 	total := HLIT + HDIST
 	var concatenatedHuffmanLengths []uint32
 	// fmt.printf("[ flate.CodeLengthCode.ReadCondensedHuffman.expandRule ] rule:\n")
 	expandRule := func(rule int) ([]uint32, error) {
-		extraBits := rleAlphabets.Alphabets[rule].ExtraBits
+		extraBits := codetables.CodeLength.ExtraBits(rule)
 		var offset int
 		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
+			if o, err := br.ReadBits(uint(extraBits)); err != nil {
 				return nil, err
 			} else {
 				offset = int(o)
@@ -284,16 +624,16 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 		} else if rule == 16 {
 			length := len(concatenatedHuffmanLengths)
 			if length == 0 {
-				return nil, errors.New("incorrectly condensed on empty slice")
+				return nil, &HeaderError{Field: "code lengths", Reason: "code 16 repeats the previous length before any length was given"}
 			} else {
-				n := rleAlphabets.Alphabets[rule].Base + offset
+				n, _ := codetables.CodeLength.Decode(rule, offset)
 				val := concatenatedHuffmanLengths[length-1]
 				for range n {
 					output = append(output, val)
 				}
 			}
 		} else if rule < 19 {
-			n := rleAlphabets.Alphabets[rule].Base + offset
+			n, _ := codetables.CodeLength.Decode(rule, offset)
 			for range n {
 				output = append(output, 0)
 			}
@@ -304,7 +644,7 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 	}
 	cnt := 0
 	for len(concatenatedHuffmanLengths) < int(total) {
-		if rule, err := TraverseHuffmanTree(dataReader, clc.CanonicalRoot); err != nil {
+		if rule, err := TraverseHuffmanTree(br, clc.CanonicalRoot); err != nil {
 			return nil, nil, err
 		} else if lengths, err := expandRule(int(rule)); err != nil {
 			return nil, nil, err
@@ -313,40 +653,42 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 			concatenatedHuffmanLengths = append(concatenatedHuffmanLengths, lengths...)
 		}
 	}
+	if len(concatenatedHuffmanLengths) > int(total) {
+		return nil, nil, &HeaderError{Field: "code lengths", Reason: fmt.Sprintf("a repeat runs past the %v lengths given by HLIT and HDIST", total)}
+	}
 	// fmt.printf("[ flate.CodeLengthCode.ReadCondensedHuffman ] len(Rules): %v\n", cnt)
 	// fmt.printf("[ flate.CodeLengthCode.ReadCondensedHuffman ] len(concatenatedHUffmanLengths): %v\n", len(concatenatedHuffmanLengths))
 	// fmt.printf("[ flate.CodeLengthCode.ReadCondensedHuffman ] concatenatedHUffmanLengths: %v\n", concatenatedHuffmanLengths)
 	return concatenatedHuffmanLengths[:HLIT], concatenatedHuffmanLengths[HLIT : HLIT+HDIST], nil
 }
 
-func TraverseHuffmanTree(dataReader func(uint) (uint32, error), node *huffman.CanonicalHuffmanNode) (uint32, error) {
-	if node.IsLeaf {
-		return uint32(node.Item.GetValue()), nil
-	}
-	if input, err := dataReader(1); err != nil {
-		return 0, err
-	} else if input == 0 {
-		if node.Left == nil {
-			return 0, errors.New("tree traversal failed due to absence of appropriate subtree")
+// TraverseHuffmanTree walks from node down to a leaf, one input bit per level,
+// and returns the symbol stored there
+func TraverseHuffmanTree(br *BitReader, node *huffman.CanonicalHuffmanNode) (uint32, error) {
+	for !node.IsLeaf {
+		input, err := br.ReadBit()
+		if err != nil {
+			return 0, err
+		}
+		if input == 0 {
+			node = node.Left
 		} else {
-			return TraverseHuffmanTree(dataReader, node.Left)
+			node = node.Right
 		}
-	} else {
-		if node.Right == nil {
+		if node == nil {
 			return 0, errors.New("tree traversal failed due to absence of appropriate subtree")
-		} else {
-			return TraverseHuffmanTree(dataReader, node.Right)
 		}
 	}
+	return uint32(node.Item.GetValue()), nil
 }
 
-func ReadTokens(dataReader func(uint) (uint32, error), newlitLenthCode *LitLengthCode, newDistanceCode *DistanceCode) ([]Token, error) {
+func ReadTokens(br *BitReader, newlitLenthCode *LitLengthCode, newDistanceCode *DistanceCode) ([]Token, error) {
 	var tokens []Token
 	decodeLitLenRule := func(rule int) (TokenKind, int, int, error) {
-		extraBits := lenAlphabets.Alphabets[rule].ExtraBits
+		extraBits := codetables.Length.ExtraBits(rule)
 		var offset int
 		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
+			if o, err := br.ReadBits(uint(extraBits)); err != nil {
 				return 0, 0, 0, err
 			} else {
 				offset = int(o)
@@ -356,33 +698,35 @@ func ReadTokens(dataReader func(uint) (uint32, error), newlitLenthCode *LitLengt
 			return LiteralToken, rule, 0, nil
 		} else if rule == 256 {
 			return EndOfBlockToken, rule, 0, nil
-		} else if rule < 286 {
-			length := lenAlphabets.Alphabets[rule].Base + offset
+		} else if length, ok := codetables.Length.Decode(rule, offset); ok {
 			return MatchToken, length, offset, nil
 		} else {
 			return 0, 0, 0, errors.New("no match found for the rule")
 		}
 	}
 	decodeDistRule := func(rule int) (int, int, error) {
-		extraBits := distAlphabets.Alphabets[rule].ExtraBits
+		extraBits := codetables.Distance.ExtraBits(rule)
 		var offset int
 		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
+			if o, err := br.ReadBits(uint(extraBits)); err != nil {
 				return 0, 0, err
 			} else {
 				offset = int(o)
 			}
 		}
-		distance := distAlphabets.Alphabets[rule].Base + offset
+		distance, ok := codetables.Distance.Decode(rule, offset)
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid distance code %v", rule)
+		}
 		return distance, offset, nil
 	}
 	for true {
-		if rule, err := TraverseHuffmanTree(dataReader, newlitLenthCode.CanonicalRoot); err != nil {
-			return nil, err
+		if rule, err := TraverseHuffmanTree(br, newlitLenthCode.CanonicalRoot); err != nil {
+			return tokens, err
 		} else {
 			var token Token
 			if tokenKind, value, lengthOffset, err := decodeLitLenRule(int(rule)); err != nil {
-				return nil, err
+				return tokens, err
 			} else if tokenKind == MatchToken {
 				token = Token{
 					Kind:         tokenKind,
@@ -390,11 +734,11 @@ func ReadTokens(dataReader func(uint) (uint32, error), newlitLenthCode *LitLengt
 					LengthCode:   int(rule),
 					LengthOffset: lengthOffset,
 				}
-				if rule, err := TraverseHuffmanTree(dataReader, newDistanceCode.CanonicalRoot); err != nil {
-					return nil, err
+				if rule, err := TraverseHuffmanTree(br, newDistanceCode.CanonicalRoot); err != nil {
+					return tokens, err
 				} else {
 					if distance, distanceOffset, err := decodeDistRule(int(rule)); err != nil {
-						return nil, err
+						return tokens, err
 					} else {
 						token.Distance = distance
 						token.DistanceCode = int(rule)
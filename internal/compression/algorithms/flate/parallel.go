@@ -0,0 +1,63 @@
+package flate
+
+import (
+	"runtime"
+	"sync"
+)
+
+// parallelTokenThreshold is the block size (in tokens) from which frequencies
+// are counted across shards and the Huffman tables are built concurrently
+const parallelTokenThreshold = 1 << 16
+
+// countSymbols runs count over every token and returns the summed symbol
+// frequencies. Large inputs are split into shards that are counted
+// concurrently into private tables and merged afterwards. count may update
+// the token it is given, since every token belongs to exactly one shard.
+func countSymbols(tokens []Token, alphabetSize int, count func(token *Token, symbolFreq []int) error) ([]int, error) {
+	shards := 1
+	if len(tokens) >= parallelTokenThreshold {
+		shards = min(runtime.GOMAXPROCS(0), len(tokens)/(parallelTokenThreshold/4))
+	}
+	if shards <= 1 {
+		symbolFreq := make([]int, alphabetSize)
+		for i := range tokens {
+			if err := count(&tokens[i], symbolFreq); err != nil {
+				return nil, err
+			}
+		}
+		return symbolFreq, nil
+	}
+
+	shardFreqs := make([][]int, shards)
+	errs := make([]error, shards)
+	shardSize := (len(tokens) + shards - 1) / shards
+	var wg sync.WaitGroup
+	for shard := range shards {
+		start := shard * shardSize
+		end := min(start+shardSize, len(tokens))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			symbolFreq := make([]int, alphabetSize)
+			for i := start; i < end; i++ {
+				if err := count(&tokens[i], symbolFreq); err != nil {
+					errs[shard] = err
+					return
+				}
+			}
+			shardFreqs[shard] = symbolFreq
+		}()
+	}
+	wg.Wait()
+
+	symbolFreq := make([]int, alphabetSize)
+	for shard := range shards {
+		if errs[shard] != nil {
+			return nil, errs[shard]
+		}
+		for symbol, freq := range shardFreqs[shard] {
+			symbolFreq[symbol] += freq
+		}
+	}
+	return symbolFreq, nil
+}
@@ -0,0 +1,65 @@
+package flate
+
+import (
+	"errors"
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
+)
+
+// TestCountSymbolsSharded checks that counting a block large enough to be
+// sharded gives the frequencies of counting it in one pass, and that every
+// token is updated by the shard it belongs to
+func TestCountSymbolsSharded(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	random := rand.New(rand.NewSource(1))
+	tokens := make([]Token, 3*parallelTokenThreshold+17)
+	for i := range tokens {
+		if random.Intn(3) == 0 {
+			tokens[i] = Token{Kind: MatchToken, Length: 3 + random.Intn(256), Distance: 1 + random.Intn(codetables.MaxDistance)}
+		} else {
+			tokens[i] = Token{Kind: LiteralToken, Value: byte(random.Intn(256))}
+		}
+	}
+	count := func(token *Token, symbolFreq []int) error {
+		if token.Kind == LiteralToken {
+			symbolFreq[token.Value]++
+			return nil
+		}
+		code, offset, _ := codetables.Length.Encode(token.Length)
+		token.LengthCode, token.LengthOffset = code, offset
+		symbolFreq[code]++
+		return nil
+	}
+
+	want := make([]int, 286)
+	serial := slices.Clone(tokens)
+	for i := range serial {
+		count(&serial[i], want)
+	}
+	got, err := countSymbols(tokens, 286, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("sharded frequencies differ from a single pass")
+	}
+	if !slices.Equal(tokens, serial) {
+		t.Errorf("sharded count left tokens without their length codes")
+	}
+
+	// an error in any shard is returned
+	failure := errors.New("count failed")
+	_, err = countSymbols(tokens, 286, func(token *Token, symbolFreq []int) error {
+		if token == &tokens[len(tokens)-1] {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("countSymbols = %v, want the error of the last shard", err)
+	}
+}
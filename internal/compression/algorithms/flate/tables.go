@@ -0,0 +1,71 @@
+package flate
+
+import (
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
+)
+
+// Warm builds the tables the package otherwise builds on first use, the
+// decoding trees of the fixed Huffman codes
+func Warm() error {
+	return buildFixedCodes()
+}
+
+// codeLengthOrder is the order in which the code-length code lengths are
+// transmitted in a dynamic block header (RFC 1951 section 3.2.7).
+var codeLengthOrder = []int{
+	16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15,
+}
+
+// fixedLitLengthLengths and fixedDistanceLengths are the code lengths of the
+// fixed Huffman codes used by BTYPE=1 blocks (RFC 1951 section 3.2.6).
+// Lit/length symbols 286-287 and distance codes 30-31 take part in the code
+// but never occur in valid data.
+var fixedLitLengthLengths, fixedDistanceLengths = func() ([]uint32, []uint32) {
+	litLen := make([]uint32, 288)
+	for symbol := range litLen {
+		switch {
+		case symbol < 144:
+			litLen[symbol] = 8
+		case symbol < 256:
+			litLen[symbol] = 9
+		case symbol < 280:
+			litLen[symbol] = 7
+		default:
+			litLen[symbol] = 8
+		}
+	}
+	dist := make([]uint32, 32)
+	for symbol := range dist {
+		dist[symbol] = 5
+	}
+	return litLen, dist
+}()
+
+// fixedLitLengthCodes and fixedDistanceCodes are the fixed Huffman codes
+// themselves, bit reversed so they can be written out least significant bit
+// first like every other code
+var fixedLitLengthCodes, fixedDistanceCodes = canonicalCodes(fixedLitLengthLengths), canonicalCodes(fixedDistanceLengths)
+
+// canonicalCodes assigns the canonical codes for the given code lengths
+// (RFC 1951 section 3.2.2) and returns them bit reversed
+func canonicalCodes(lengths []uint32) []uint32 {
+	var lengthCounts [16]uint32
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+	var nextCode [16]uint32
+	code := uint32(0)
+	for length := 1; length < len(nextCode); length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		nextCode[length] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = huffman.Reverse(nextCode[length], length)
+			nextCode[length]++
+		}
+	}
+	return codes
+}
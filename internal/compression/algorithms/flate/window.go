@@ -0,0 +1,61 @@
+package flate
+
+import (
+	"fmt"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
+)
+
+// window is a ring buffer of the last 32 KiB of output, as far as back
+// references reach, so decoding a stream holds no more of its output than
+// that however long it is
+type window struct {
+	buf  [codetables.MaxDistance]byte
+	next int // where the next byte goes
+	size int // bytes held, up to len(buf)
+}
+
+// write adds p to the window, of which only the last 32 KiB stay
+func (w *window) write(p []byte) {
+	for _, b := range p {
+		w.writeByte(b)
+	}
+}
+
+func (w *window) writeByte(b byte) {
+	w.buf[w.next] = b
+	if w.next++; w.next == len(w.buf) {
+		w.next = 0
+	}
+	w.size = min(w.size+1, len(w.buf))
+}
+
+// decode appends what tokens expand to to out and adds it to the window.
+// A match may overlap the bytes it produces, so it is copied a byte at a
+// time.
+func (w *window) decode(out []byte, tokens []Token) ([]byte, error) {
+	for _, token := range tokens {
+		switch token.Kind {
+		case LiteralToken:
+			out = append(out, token.Value)
+			w.writeByte(token.Value)
+		case MatchToken:
+			if token.Distance <= 0 || token.Distance > w.size {
+				return out, fmt.Errorf("distance %v reaches before the start of the data", token.Distance)
+			}
+			from := w.next - token.Distance
+			if from < 0 {
+				from += len(w.buf)
+			}
+			for range token.Length {
+				b := w.buf[from]
+				if from++; from == len(w.buf) {
+					from = 0
+				}
+				out = append(out, b)
+				w.writeByte(b)
+			}
+		}
+	}
+	return out, nil
+}
@@ -1,12 +1,24 @@
 package gzip
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"hash"
-	"hash/crc32"
 	"io"
-	"os"
+	"math"
 	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum/crc"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/profile"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CompressionCore struct {
@@ -16,7 +28,13 @@ type CompressionCore struct {
 	FlateWriter io.WriteCloser
 	FlateReader io.ReadCloser
 	Crc         hash.Hash32
-	Size        uint32
+	Size        uint64
+	Header      [headerSize]byte
+	ctx         context.Context
+	crcSpan     trace.Span // from the first Write until Close
+	started     bool       // the header went out and the deflate data is copied after it
+	closed      bool       // Close was called
+	drained     chan error // what copying the deflate data ended with
 }
 
 type CompressionReader struct {
@@ -33,66 +51,203 @@ func NewCompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.Wri
 	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
 	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 2\n")
 	newCompressionCore.FlateReader, newCompressionCore.FlateWriter = flateReader, flateWriter
-	newCompressionCore.Crc = crc32.NewIEEE()
+	newCompressionCore.Crc = checksum.NewCRC32()
+	newCompressionCore.Header = header
+	newCompressionCore.ctx = context.Background()
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
-	header := [10]byte{
-		0x1f, 0x8b, // ID1, ID2
-		0x08,       // CM = deflate
-		0x00,       // FLG
-		0, 0, 0, 0, // MTIME
-		0x00, // XFL
-		0xff, // OS = unknown
-	}
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 3\n")
-	go newCompressionCore.Writer.Write(header[:])
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 4\n")
 	return newCompressionReader, newCompressionWriter
 }
 
+// SetContext sets the context the checksum span and the spans of the flate
+// writer are started in, it has to be called before Write
+func (cw *CompressionWriter) SetContext(ctx context.Context) {
+	cw.core.ctx = ctx
+	if setter, ok := cw.core.FlateWriter.(interface{ SetContext(context.Context) }); ok {
+		setter.SetContext(ctx)
+	}
+}
+
+// MemoryUsage reports the buffers of the flate writer, the header and trailer
+// go straight into the pipe
+func (cw *CompressionWriter) MemoryUsage() memory.Usage {
+	if reporter, ok := cw.core.FlateWriter.(interface{ MemoryUsage() memory.Usage }); ok {
+		return reporter.MemoryUsage()
+	}
+	return memory.Usage{}
+}
+
+// Profile reports what the flate writer coded the input as, with the member
+// header and trailer counted as header bytes
+func (cw *CompressionWriter) Profile() profile.Profile {
+	var p profile.Profile
+	if reporter, ok := cw.core.FlateWriter.(interface{ Profile() profile.Profile }); ok {
+		p = reporter.Profile()
+	}
+	p.AddHeaderBytes(headerSize + trailerSize)
+	return p
+}
+
+// SetModTime writes t into the MTIME field of the header, the zero time and
+// times before 1970 or after 2106, which MTIME cannot hold, leave it unset.
+// It has to be called before Write.
+func (cw *CompressionWriter) SetModTime(t time.Time) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	var mtime uint32
+	if seconds := t.Unix(); !t.IsZero() && seconds > 0 && seconds <= math.MaxUint32 {
+		mtime = uint32(seconds)
+	}
+	binary.LittleEndian.PutUint32(cw.core.Header[4:8], mtime)
+}
+
+// ModTime returns the time in the MTIME field of the member header at the
+// start of data, or the zero time when it is unset or data is not gzip
+func ModTime(data []byte) time.Time {
+	if len(data) < headerSize || data[0] != 0x1f || data[1] != 0x8b {
+		return time.Time{}
+	}
+	if mtime := binary.LittleEndian.Uint32(data[4:8]); mtime != 0 {
+		return time.Unix(int64(mtime), 0).UTC()
+	}
+	return time.Time{}
+}
+
+// ContentLength returns the ISIZE field of the last member of data, the
+// length of its content modulo 2^32. It is the length of all of the content
+// only when data is a single member of less than 4 GiB, which the caller has
+// to know.
+func ContentLength(data []byte) (length int64, ok bool) {
+	if len(data) < headerSize+trailerSize || !bytes.HasPrefix(data, Magic) {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint32(data[len(data)-4:])), true
+}
+
+// headerSize is the size of the fixed member header, optional fields are never written
+const headerSize = 10
+
+// trailerSize is the size of the CRC32 and ISIZE trailer closing a member
+const trailerSize = 8
+
+// Magic starts every member: the ID bytes and the deflate method
+var Magic = []byte{0x1f, 0x8b, 0x08}
+
+var header = [headerSize]byte{
+	0x1f, 0x8b, // ID1, ID2
+	0x08,       // CM = deflate
+	0x00,       // FLG
+	0, 0, 0, 0, // MTIME
+	0x00, // XFL
+	0xff, // OS = unknown
+}
+
 func (cw *CompressionWriter) Write(p []byte) (int, error) {
 	// fmt.Printf("[ gzip.CompressionWriter.Write ] 1\n")
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
 	// fmt.Printf("[ gzip.CompressionWriter.Write ] 2\n")
-	if f, err := os.OpenFile("com.o", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
-		panic(err)
-	} else {
-		f.Write(p)
+	if cw.core.crcSpan == nil {
+		// the CRC is updated as the input streams in, the span covers that
+		_, cw.core.crcSpan = telemetry.Start(cw.core.ctx, "gzip.checksum",
+			attribute.String("gzip.crc32_implementation", crc.Implementation(crc.IEEE)))
 	}
 	cw.core.Crc.Write(p)
-	cw.core.Size += uint32(len(p))
-	return cw.core.FlateWriter.Write(p)
+	cw.core.Size += uint64(len(p))
+	cw.start()
+	var n int
+	err := guard(func() (err error) {
+		n, err = cw.core.FlateWriter.Write(p)
+		return err
+	})
+	return n, err
 }
 
 func (cw *CompressionWriter) Close() error {
-	// cw.core.lock.Lock()
-	// defer cw.core.lock.Unlock()
 	// fmt.Printf("[ gzip.CompressionWriter.Close ] 1\n")
-	go func() {
-		if err := cw.core.FlateWriter.Close(); err != nil {
-			panic(err)
-		}
-		// fmt.Printf("[ gzip.CompressionWriter.Close ] 2\n")
-	}()
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 3\n")
-	if _, err := io.Copy(cw.core.Writer, cw.core.FlateReader); err != nil {
+	cw.core.lock.Lock()
+	if cw.core.closed {
+		cw.core.lock.Unlock()
+		return io.ErrClosedPipe
+	}
+	cw.core.closed = true
+	if cw.core.crcSpan != nil {
+		cw.core.crcSpan.SetAttributes(attribute.Int64("gzip.input_bytes", int64(cw.core.Size)))
+		cw.core.crcSpan.End()
+	}
+	cw.start()
+	cw.core.lock.Unlock()
+	// fmt.Printf("[ gzip.CompressionWriter.Close ] 2\n")
+	if err := cw.finish(); err != nil {
+		cw.core.Writer.CloseWithError(err)
 		return err
 	}
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 4\n")
+	// fmt.Printf("[ gzip.CompressionWriter.Close ] 3\n")
 	if err := cw.core.FlateReader.Close(); err != nil {
+		cw.core.Writer.CloseWithError(err)
 		return err
 	}
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 5\n")
-	trailer := make([]byte, 8)
+	// fmt.Printf("[ gzip.CompressionWriter.Close ] 4\n")
+	trailer := make([]byte, trailerSize)
 	// fmt.Printf("[ gzip.CompressionWriter.Close ] crc: %v, size: %v\n", cw.core.Crc.Sum32(), cw.core.Size)
 	binary.LittleEndian.PutUint32(trailer[0:4], cw.core.Crc.Sum32())
-	binary.LittleEndian.PutUint32(trailer[4:8], cw.core.Size)
-	cw.core.Writer.Write(trailer)
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 6\n")
+	binary.LittleEndian.PutUint32(trailer[4:8], uint32(cw.core.Size)) // ISIZE is the size modulo 2^32
+	if _, err := cw.core.Writer.Write(trailer); err != nil {
+		return err
+	}
+	// fmt.Printf("[ gzip.CompressionWriter.Close ] 5\n")
 	return cw.core.Writer.Close()
 }
 
+// start writes the header and copies the deflate data after it as flate
+// codes it, from the first Write on; flate blocks until its output is read.
+// The header goes out right before the deflate data so nothing can overtake
+// it. It is called with the lock held.
+func (cw *CompressionWriter) start() {
+	if cw.core.started {
+		return
+	}
+	cw.core.started = true
+	cw.core.drained = make(chan error, 1)
+	header := cw.core.Header
+	go func() {
+		_, err := cw.core.Writer.Write(header[:])
+		if err == nil {
+			_, err = io.Copy(cw.core.Writer, cw.core.FlateReader)
+		}
+		if err != nil {
+			// a flate Write or Close waiting for its output to be read fails
+			cw.core.FlateReader.Close()
+		}
+		cw.core.drained <- err
+	}()
+}
+
+// finish closes the flate writer and waits for its output to be copied.
+// When the copy failed first flate only finds its output closed, so the
+// copy's error is returned instead.
+func (cw *CompressionWriter) finish() error {
+	err := guard(cw.core.FlateWriter.Close)
+	if err != nil {
+		// the copy is stopped in case flate left its output open
+		cw.core.FlateReader.Close()
+	}
+	if drainErr := <-cw.core.drained; drainErr != nil && (err == nil || errors.Is(err, io.ErrClosedPipe)) {
+		err = drainErr
+	}
+	return err
+}
+
+// guard calls f, a panic in flate comes back as an error
+func guard(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("flate panicked: %v", r)
+		}
+	}()
+	return f()
+}
+
 func (cr *CompressionReader) Read(p []byte) (int, error) {
 	// fmt.Printf("[ gzip.CompressionReader.Read ] 1\n")
 	// cr.core.lock.Lock()
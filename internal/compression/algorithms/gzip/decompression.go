@@ -1,24 +1,52 @@
 package gzip
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
-	"hash/crc32"
 	"io"
 	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
 )
 
 type DecompressionCore struct {
 	lock           sync.Mutex
 	Writer         *io.PipeWriter
 	Reader         *io.PipeReader
+	Header         []byte
 	IsHeaderParsed bool
 	Trailer        []byte
 	CurrentCrc     hash.Hash32
-	CurrentSize    uint32
+	CurrentSize    uint64
 	FlateWriter    io.WriteCloser
 	FlateReader    io.ReadCloser
+	started        bool       // the output of flate is being copied into the pipe
+	drained        chan error // what copying it ended with
+
+	IgnoreChecksums bool               // record mismatching trailers instead of failing
+	Mismatches      []ChecksumMismatch // the trailers that did not match
+	member          int                // index of the member being decoded
+}
+
+// ChecksumMismatch is a member whose trailer does not match its decoded
+// content. The content was passed on regardless, it may be damaged.
+type ChecksumMismatch struct {
+	Member       int    `json:"member"` // index of the member, from 0
+	ExpectedCRC  uint32 `json:"expected_crc"`
+	ActualCRC    uint32 `json:"actual_crc"`
+	ExpectedSize uint32 `json:"expected_size"` // ISIZE, the size modulo 2^32
+	ActualSize   uint32 `json:"actual_size"`
+}
+
+func (m ChecksumMismatch) Error() string {
+	return fmt.Sprintf("member %d: the trailer records crc %08x and %d bytes, the content has crc %08x and %d bytes",
+		m.Member, m.ExpectedCRC, m.ExpectedSize, m.ActualCRC, m.ActualSize)
 }
 
 type DecompressionWriter struct {
@@ -29,93 +57,349 @@ type DecompressionReader struct {
 	core *DecompressionCore
 }
 
+// SetContext passes ctx on to the flate writer for its inflate span, it has
+// to be called before Write
+func (dw *DecompressionWriter) SetContext(ctx context.Context) {
+	if setter, ok := dw.core.FlateWriter.(interface{ SetContext(context.Context) }); ok {
+		setter.SetContext(ctx)
+	}
+}
+
+// SetIgnoreChecksums makes trailers that do not match the decoded content
+// be recorded in ChecksumMismatches instead of failing the decompression, it
+// has to be called before Write
+func (dw *DecompressionWriter) SetIgnoreChecksums(ignore bool) {
+	dw.core.IgnoreChecksums = ignore
+}
+
+// ChecksumMismatches returns the members whose trailers did not match, once
+// the writer is closed
+func (dw *DecompressionWriter) ChecksumMismatches() []ChecksumMismatch {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.Mismatches
+}
+
+// MemoryUsage reports the buffers of the flate writer, which decodes the
+// first member. Members after it are decoded one at a time and passed on.
+func (dw *DecompressionWriter) MemoryUsage() memory.Usage {
+	if reporter, ok := dw.core.FlateWriter.(interface{ MemoryUsage() memory.Usage }); ok {
+		return reporter.MemoryUsage()
+	}
+	return memory.Usage{}
+}
+
 func NewDecompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(DecompressionCore)
 	newDecompressionCore.Reader, newDecompressionCore.Writer = io.Pipe()
 	newDecompressionCore.FlateReader, newDecompressionCore.FlateWriter = flateReader, flateWriter
-	newDecompressionCore.CurrentCrc = crc32.NewIEEE()
-	newDecompressionCore.Trailer = make([]byte, 8)
+	newDecompressionCore.CurrentCrc = checksum.NewCRC32()
+	newDecompressionCore.Header = make([]byte, 0, headerSize)
+	newDecompressionCore.Trailer = make([]byte, 0, trailerSize)
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	return newDecompressionReader, newDecompressionWriter
 }
 
+// gzip header flags (RFC 1952 section 2.3.1)
+const (
+	flagHcrc     = 1 << 1
+	flagExtra    = 1 << 2
+	flagName     = 1 << 3
+	flagComment  = 1 << 4
+	flagReserved = 0xe0
+)
+
+// headerLength returns the length of the member header at the start of buf,
+// including the optional extra field, name, comment and header CRC, or 0 if
+// buf does not hold the whole header yet
+func headerLength(buf []byte) (int, error) {
+	if len(buf) >= 2 && (buf[0] != 0x1f || buf[1] != 0x8b) {
+		return 0, errors.New("input is not gzip data")
+	}
+	if len(buf) >= 3 && buf[2] != 0x08 {
+		return 0, fmt.Errorf("unsupported gzip compression method %v", buf[2])
+	}
+	if len(buf) < headerSize {
+		return 0, nil
+	}
+	flags := buf[3]
+	if flags&flagReserved != 0 {
+		return 0, fmt.Errorf("reserved gzip header flags %08b are set", flags&flagReserved)
+	}
+	n := headerSize
+	if flags&flagExtra != 0 {
+		if len(buf) < n+2 {
+			return 0, nil
+		}
+		n += 2 + int(binary.LittleEndian.Uint16(buf[n:]))
+	}
+	// name and comment are zero-terminated
+	for _, flag := range []byte{flagName, flagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		if len(buf) < n {
+			return 0, nil
+		}
+		end := bytes.IndexByte(buf[n:], 0)
+		if end < 0 {
+			return 0, nil
+		}
+		n += end + 1
+	}
+	if flags&flagHcrc != 0 {
+		if len(buf) < n+2 {
+			return 0, nil
+		}
+		if binary.LittleEndian.Uint16(buf[n:]) != uint16(checksum.SumCRC32(buf[:n])) {
+			return 0, errors.New("gzip header crc did not match")
+		}
+		n += 2
+	}
+	if len(buf) < n {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// Write strips the member header and passes the deflate data on to flate.
+// The header may arrive split over several writes, and the last trailerSize
+// bytes seen so far are held back since they may turn out to be the trailer.
 func (dw *DecompressionWriter) Write(p []byte) (int, error) {
 	dw.core.lock.Lock()
-	// defer dw.core.lock.Unlock()
+	defer dw.core.lock.Unlock()
+	n := len(p)
 	if !dw.core.IsHeaderParsed {
-		// header := p[:10]
+		dw.core.Header = append(dw.core.Header, p...)
+		size, err := headerLength(dw.core.Header)
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			return n, nil
+		}
+		p = dw.core.Header[size:]
+		dw.core.Header = dw.core.Header[:size:size]
 		dw.core.IsHeaderParsed = true
-		p = p[10:]
 	}
-	dw.core.lock.Unlock()
-	// fmt.Printf("[ gzip.DecompressionWriter.Write ] 1\n")
-	if len(dw.core.Trailer)+len(p) < 8 {
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] 2\n")
-		dw.core.Trailer = append(dw.core.Trailer, p...)
-	} else if len(p) < 8 {
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] 3\n")
-		dw.core.Trailer = append(dw.core.Trailer[len(p):], p...)
-	} else {
-		copy(dw.core.Trailer, p[len(p)-8:])
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] len(Trailer): %v\n", len(dw.core.Trailer))
+	if len(p) == 0 {
+		return n, nil
+	}
+	pending := append(dw.core.Trailer, p...)
+	if len(pending) <= trailerSize {
+		dw.core.Trailer = pending
+		return n, nil
+	}
+	dw.core.Trailer = append(make([]byte, 0, trailerSize), pending[len(pending)-trailerSize:]...)
+	dw.start()
+	err := guard(func() error {
+		_, err := dw.core.FlateWriter.Write(pending[:len(pending)-trailerSize])
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
-	return dw.core.FlateWriter.Write(p)
+	return n, nil
 }
 
-func (dw *DecompressionWriter) Close() error {
-	dw.core.lock.Lock()
-	defer dw.core.lock.Unlock()
-
+// start copies what flate decodes into the pipe as it comes out, from the
+// first deflate data on, and checks it against the trailer on its way. The
+// lock has to be held.
+func (dw *DecompressionWriter) start() {
+	if dw.core.started {
+		return
+	}
+	dw.core.started = true
+	dw.core.drained = make(chan error, 1)
 	go func() {
-		if err := dw.core.FlateWriter.Close(); err != nil {
-			panic(err)
+		output := io.TeeReader(dw.core.FlateReader, memberChecksum{dw.core})
+		_, err := io.Copy(dw.core.Writer, output)
+		if err != nil {
+			// the reader is gone, a flate writer blocked on its pipe has to give up
+			dw.core.FlateReader.Close()
 		}
+		dw.core.drained <- err
 	}()
+}
 
-	if _, err := io.Copy(dw.core.Writer, dw.core.FlateReader); err != nil {
+func (dw *DecompressionWriter) Close() error {
+	dw.core.lock.Lock()
+	headerComplete := dw.core.IsHeaderParsed
+	if headerComplete {
+		dw.start()
+	}
+	dw.core.lock.Unlock()
+	if !headerComplete {
+		err := fmt.Errorf("gzip header is truncated: %w", io.ErrUnexpectedEOF)
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+
+	// no lock here: flate decodes the rest while its output is copied, which
+	// blocks until the reader drains it, and the reader has to be able to
+	// close in the meantime
+	if err := dw.finishFlate(); err != nil {
+		dw.core.Writer.CloseWithError(err)
 		return err
 	}
 	if err := dw.core.FlateReader.Close(); err != nil {
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+
+	// The last trailerSize bytes were held back as the trailer. If flate
+	// found its final block before the end of the input, the first member's
+	// trailer comes right after it and more members may follow.
+	rest := dw.core.Trailer
+	if unused, ok := dw.core.FlateWriter.(interface{ Unused() []byte }); ok && len(unused.Unused()) > 0 {
+		rest = append(append([]byte{}, unused.Unused()...), dw.core.Trailer...)
+	}
+	if err := dw.core.checkTrailer(rest); err != nil {
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+	if err := dw.decodeMembers(rest[trailerSize:]); err != nil {
+		dw.core.Writer.CloseWithError(err)
 		return err
 	}
 	return dw.core.Writer.Close()
 }
 
-func (dr *DecompressionReader) Read(p []byte) (int, error) {
-	// dr.core.lock.Lock()
-	// defer dr.core.lock.Unlock()
-
-	if n, err := dr.core.Reader.Read(p); err != nil {
-		return 0, err
-	} else {
-		dr.core.CurrentSize += uint32(n)
-		// if f, err := os.OpenFile("decom.o", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
-		// 	panic(err)
-		// } else {
-		// 	f.Write(p)
-		// }
-		dr.core.CurrentCrc.Write(p[:n])
-		return n, nil
+// finishFlate closes the flate writer and waits until its output is copied.
+// A reader that went away fails flate with io.ErrClosedPipe, the error of
+// the copy tells more then.
+func (dw *DecompressionWriter) finishFlate() error {
+	err := guard(dw.core.FlateWriter.Close)
+	if err != nil {
+		// a flate writer that panicked left its pipe open
+		dw.core.FlateReader.Close()
 	}
+	if drainErr := <-dw.core.drained; drainErr != nil && (err == nil || errors.Is(err, io.ErrClosedPipe)) {
+		err = drainErr
+	}
+	return err
 }
 
-func (dr *DecompressionReader) Close() error {
-	dr.core.lock.Lock()
-	defer dr.core.lock.Unlock()
+// memberChecksum adds what is written to it to the CRC and size of the
+// member being decoded
+type memberChecksum struct {
+	core *DecompressionCore
+}
+
+func (mc memberChecksum) Write(p []byte) (int, error) {
+	mc.core.CurrentSize += uint64(len(p))
+	mc.core.CurrentCrc.Write(p)
+	return len(p), nil
+}
 
-	if len(dr.core.Trailer) != 8 {
-		return errors.New("trailer data is not sufficient")
+// checkTrailer compares the trailer at the start of buf with the CRC and size
+// of the member that was just decoded and starts counting the next one. A
+// mismatch is recorded rather than returned when IgnoreChecksums is set.
+func (core *DecompressionCore) checkTrailer(buf []byte) error {
+	if len(buf) < trailerSize {
+		return fmt.Errorf("trailer data is not sufficient: %w", io.ErrUnexpectedEOF)
 	}
-	givenCrc := binary.LittleEndian.Uint32(dr.core.Trailer[0:4])
-	givenSize := binary.LittleEndian.Uint32(dr.core.Trailer[4:])
+	givenCrc := binary.LittleEndian.Uint32(buf[0:4])
+	givenSize := binary.LittleEndian.Uint32(buf[4:])
 	// fmt.Printf("[ gzip.DecompressionReader.Close ] givenCrc: %v, given Size: %v\n", givenCrc, givenSize)
-	// fmt.Printf("[ gzip.DecompressionReader.Close ] currentCrc: %v, currentSize: %v\n", dr.core.CurrentCrc.Sum32(), dr.core.CurrentSize)
-	if givenSize != dr.core.CurrentSize {
-		return errors.New("size did not match")
+	// fmt.Printf("[ gzip.DecompressionReader.Close ] currentCrc: %v, currentSize: %v\n", core.CurrentCrc.Sum32(), core.CurrentSize)
+	// ISIZE only holds the size modulo 2^32, members of 4GB and more wrap around
+	if givenSize != uint32(core.CurrentSize) || givenCrc != core.CurrentCrc.Sum32() {
+		if !core.IgnoreChecksums {
+			if givenSize != uint32(core.CurrentSize) {
+				return errors.New("size did not match")
+			}
+			return errors.New("crc did not match")
+		}
+		core.lock.Lock()
+		core.Mismatches = append(core.Mismatches, ChecksumMismatch{
+			Member:       core.member,
+			ExpectedCRC:  givenCrc,
+			ActualCRC:    core.CurrentCrc.Sum32(),
+			ExpectedSize: givenSize,
+			ActualSize:   uint32(core.CurrentSize),
+		})
+		core.lock.Unlock()
+	}
+	core.CurrentCrc.Reset()
+	core.CurrentSize = 0
+	core.member++
+	return nil
+}
+
+// decodeMembers decodes the members that follow the first one. Like gunzip,
+// it treats them as a continuation of the same stream, which is how
+// independently compressed pieces are appended to a .gz file. Each member
+// needs a final block, otherwise its end cannot be told from its trailer.
+func (dw *DecompressionWriter) decodeMembers(data []byte) error {
+	for len(data) > 0 {
+		size, err := headerLength(data)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return fmt.Errorf("gzip header is truncated: %w", io.ErrUnexpectedEOF)
+		}
+		output, unused, err := flate.Inflate(data[size:])
+		if _, writeErr := dw.core.Writer.Write(output); writeErr != nil {
+			return writeErr
+		}
+		if err != nil {
+			return err
+		}
+		memberChecksum{dw.core}.Write(output)
+		if err := dw.core.checkTrailer(unused); err != nil {
+			return err
+		}
+		data = unused[trailerSize:]
+	}
+	return nil
+}
+
+// DecodeMember decodes the gzip member at the start of data on its own and
+// returns its content and the number of bytes the member takes up, so the
+// next member starts at data[n:]. The content is checked against the
+// member's trailer.
+func DecodeMember(data []byte) (content []byte, n int, err error) {
+	size, err := headerLength(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size == 0 {
+		return nil, 0, fmt.Errorf("gzip header is truncated: %w", io.ErrUnexpectedEOF)
 	}
-	if givenCrc != dr.core.CurrentCrc.Sum32() {
-		return errors.New("crc did not match")
+	content, unused, err := flate.Inflate(data[size:])
+	if err != nil {
+		return nil, 0, err
 	}
+	if len(unused) < trailerSize {
+		return nil, 0, fmt.Errorf("trailer data is not sufficient: %w", io.ErrUnexpectedEOF)
+	}
+	if binary.LittleEndian.Uint32(unused[4:]) != uint32(len(content)) {
+		return nil, 0, errors.New("size did not match")
+	}
+	if binary.LittleEndian.Uint32(unused) != checksum.SumCRC32(content) {
+		return nil, 0, errors.New("crc did not match")
+	}
+	return content, len(data) - len(unused) + trailerSize, nil
+}
+
+func (dr *DecompressionReader) Read(p []byte) (int, error) {
+	// dr.core.lock.Lock()
+	// defer dr.core.lock.Unlock()
+
+	// if f, err := os.OpenFile("decom.o", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
+	// 	panic(err)
+	// } else {
+	// 	f.Write(p)
+	// }
+	return dr.core.Reader.Read(p)
+}
+
+func (dr *DecompressionReader) Close() error {
+	// closing the pipe releases a writer that is still copying into it, the
+	// trailers were checked by the writer, a mismatch ends the pipe with an
+	// error. No lock: a Write holds it while flate waits on the copy.
 	return dr.core.Reader.Close()
 }
\ No newline at end of file
@@ -2,14 +2,16 @@ package huffman
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
-	"os"
-	"slices"
-	"strconv"
-	"strings"
 	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type CompressionWriter struct {
@@ -20,17 +22,24 @@ type CompressionReader struct {
 }
 
 type compressionCore struct {
-	isInputBufferClosed bool
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
+	isInputBufferClosed  bool
+	isOutputBufferClosed bool
+	cond                 *sync.Cond
+	lock                 sync.Mutex
+	inputBuffer          io.ReadWriter
+	outputBuffer         io.ReadWriter
+	ctx                  context.Context // parent of the spans of the stages
+	memory               *memory.Tracker
 }
 
 func (cr *CompressionReader) Read(data []byte) (int, error) {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if !cr.core.isInputBufferClosed {
-		return 0, errors.New("input buffer not closed")
+	for !cr.core.isInputBufferClosed && !cr.core.isOutputBufferClosed {
+		cr.core.cond.Wait()
+	}
+	if cr.core.isOutputBufferClosed {
+		return 0, io.ErrClosedPipe
 	}
 	return cr.core.outputBuffer.Read(data)
 }
@@ -38,6 +47,9 @@ func (cr *CompressionReader) Read(data []byte) (int, error) {
 func (cr *CompressionReader) Close() error {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
+	// a Read still waiting for the writer gives up instead of blocking forever
+	cr.core.isOutputBufferClosed = true
+	cr.core.cond.Broadcast()
 	if buf, ok := cr.core.inputBuffer.(*bytes.Buffer); ok {
 		buf.Reset()
 		return nil
@@ -49,106 +61,163 @@ func (cr *CompressionReader) Close() error {
 func (cw *CompressionWriter) Write(data []byte) (int, error) {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
-	return cw.core.inputBuffer.Write(data)
+	if cw.core.isInputBufferClosed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := cw.core.inputBuffer.Write(data)
+	cw.core.memory.Set(memory.Input, memory.Capacity(cw.core.inputBuffer))
+	return n, err
 }
 
 func (cw *CompressionWriter) Close() error {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
+	if cw.core.isInputBufferClosed {
+		return io.ErrClosedPipe
+	}
+	// the reader is woken up even if processing fails, so it never waits forever
+	defer cw.core.cond.Broadcast()
 	cw.core.isInputBufferClosed = true
 	originalData, err := io.ReadAll(cw.core.inputBuffer)
 	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
 	if err != nil {
 		return err
 	}
-	compressedData := compress(originalData)
-	if _, err = cw.core.outputBuffer.Write(compressedData); err != nil {
-		return err
-	}
-	return nil
+	cw.core.memory.Set(memory.Copy, cap(originalData))
+	compressedData := compress(cw.core.ctx, originalData)
+	cw.core.memory.Set(memory.Result, cap(compressedData))
+	_, err = cw.core.outputBuffer.Write(compressedData)
+	cw.core.memory.Set(memory.Output, memory.Capacity(cw.core.outputBuffer))
+	return err
 }
 
 func NewCompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newCompressionCore := new(compressionCore)
 	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newCompressionCore.isInputBufferClosed = false
+	newCompressionCore.ctx = context.Background()
+	newCompressionCore.memory = memory.NewTracker(0)
+	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
 	return newCompressionReader, newCompressionWriter
 }
 
-func compress(content []byte) []byte {
-	contentString := string(content)
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (cw *CompressionWriter) MemoryUsage() memory.Usage {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	return cw.core.memory.Usage()
+}
+
+// SetContext sets the context the spans of the tree building and encoding
+// stages are started in, it has to be called before Write
+func (cw *CompressionWriter) SetContext(ctx context.Context) {
+	cw.core.ctx = ctx
+}
+
+// compress encodes content as
+//
+//	4 bytes  magic, 0x89 'H' 'U' 'F'
+//	byte     format version, formatVersion
+//	frame    frameHeader with a checksum, holding
+//	           uvarint  length of the original content
+//	           uvarint  number of distinct bytes, then for each of them in
+//	                    ascending byte order the byte itself and its uvarint
+//	                    frequency
+//	frame    frameCode with a checksum, holding the Huffman code of every
+//	         input byte, most significant bit first, zero padded to a whole
+//	         byte
+//
+// Frames are laid out as package framing writes them.
+// The frequency table is all the decoder needs to rebuild the same tree, and
+// since everything is counted in bytes any binary input round-trips. The
+// table order is fixed and buildTree breaks frequency ties by symbol, so the
+// same input always compresses to the same bytes.
+func compress(ctx context.Context, content []byte) []byte {
+	_, span := telemetry.Start(ctx, "huffman.build", attribute.Int("huffman.input_bytes", len(content)))
+	var symbolCounts [256]int
+	for _, symbol := range content {
+		symbolCounts[symbol]++
+	}
 	symbolFreq := make(map[rune]int)
-	for _, c := range contentString {
-		symbolFreq[c]++
+	for symbol, freq := range symbolCounts {
+		if freq > 0 {
+			symbolFreq[rune(symbol)] = freq
+		}
 	}
-	var compressionHeader strings.Builder
-	for key, val := range symbolFreq {
-		if key == 10 {
-			fmt.Fprintf(&compressionHeader, "%s|\\n", strconv.Itoa(val))
-		} else {
-			fmt.Fprintf(&compressionHeader, "%s|%s", strconv.Itoa(val), string(key))
+
+	header := binary.AppendUvarint(nil, uint64(len(content)))
+	header = binary.AppendUvarint(header, uint64(len(symbolFreq)))
+	for symbol, freq := range symbolCounts {
+		if freq > 0 {
+			header = append(header, byte(symbol))
+			header = binary.AppendUvarint(header, uint64(freq))
 		}
 	}
+	output := append(append([]byte{}, Magic...), formatVersion)
+	output = framing.Append(output, frameHeader, header, true)
+	if len(content) == 0 {
+		span.End()
+		return framing.Append(output, frameCode, nil, true)
+	}
 	tree := buildTree(symbolFreq)
-	compressed := encode(tree, contentString, compressionHeader)
-	return compressed
+	span.SetAttributes(attribute.Int("huffman.symbols", len(symbolFreq)))
+	span.End()
+
+	_, span = telemetry.Start(ctx, "huffman.encode")
+	defer span.End()
+	return framing.Append(output, frameCode, encode(tree, content, nil), true)
 }
 
-func getSymbolEncoding(tree huffmanTree, symbolEnc map[rune]string, currentPrefix []byte) {
+// symbolCode is the path to a leaf, one bit per level with the root's bit
+// the most significant. Depths stay far below 64 for any input that fits in
+// memory, a depth of d needs at least Fibonacci(d+2) bytes of input.
+type symbolCode struct {
+	bits   uint64
+	length uint
+}
+
+func getSymbolEncoding(tree huffmanTree, symbolEnc map[rune]symbolCode, currentPrefix symbolCode) {
 	switch node := tree.(type) {
 	case huffmanLeaf:
-		symbolEnc[node.symbol] = string(currentPrefix)
-		// b := bitString(string(currentPrefix))
-		// fmt.Printf("[ getSymbolEncoding ] symbol: %s, currentPrefix: %s, in bytes: %v\n", string(node.symbol), string(currentPrefix), b.asByteSlice())
+		symbolEnc[node.symbol] = currentPrefix
+		// fmt.Printf("[ getSymbolEncoding ] symbol: %v, code: %b, length: %v\n", node.symbol, currentPrefix.bits, currentPrefix.length)
 		return
 	case huffmanNode:
-		getSymbolEncoding(node.left, symbolEnc, append(currentPrefix, byte('0')))
-		getSymbolEncoding(node.right, symbolEnc, append(currentPrefix, byte('1')))
+		getSymbolEncoding(node.left, symbolEnc, symbolCode{bits: currentPrefix.bits << 1, length: currentPrefix.length + 1})
+		getSymbolEncoding(node.right, symbolEnc, symbolCode{bits: currentPrefix.bits<<1 | 1, length: currentPrefix.length + 1})
 		return
 	}
 }
 
-func (b bitString) asByteSlice() []byte {
-	var output []byte
-	for i := len(b); i > 0; i -= 8 {
-		var chunk string
-		if i < 8 {
-			chunk = string(b[:i])
-		} else {
-			chunk = string(b[i-8 : i])
-		}
-		chunkInt, err := strconv.ParseUint(chunk, 2, 8)
-		if err != nil {
-			fmt.Println("Error converting string to byte for compression")
-			os.Exit(1)
-		}
-		output = append(output, byte(chunkInt))
+// encode appends the code of every byte of input to output. A tree with a
+// single leaf has an empty code, the decoder repeats that symbol instead.
+func encode(tree huffmanTree, input []byte, output []byte) []byte {
+	symbolEnc := make(map[rune]symbolCode)
+	getSymbolEncoding(tree, symbolEnc, symbolCode{})
+	var codes [256]symbolCode
+	for symbol, code := range symbolEnc {
+		codes[symbol] = code
 	}
-	slices.Reverse(output)
-	return output
-}
 
-func encode(tree huffmanTree, input string, compressionHeader strings.Builder) []byte {
-	var output strings.Builder
-	symbolEnc := make(map[rune]string)
-	getSymbolEncoding(tree, symbolEnc, []byte{})
+	var bitsHolder uint64
+	var bitsCount uint
 	for _, symbol := range input {
-		encoding, ok := symbolEnc[symbol]
-		if !ok {
-			fmt.Println("Symbol does not exist in huffman tree.")
-			os.Exit(1)
+		code := codes[symbol]
+		for i := code.length; i > 0; i-- {
+			bitsHolder = bitsHolder<<1 | (code.bits>>(i-1))&1
+			bitsCount++
+			if bitsCount == 8 {
+				output = append(output, byte(bitsHolder))
+				bitsHolder, bitsCount = 0, 0
+			}
 		}
-		fmt.Fprintf(&output, "%s", encoding)
 	}
-	paddingBits := bitString(strconv.FormatInt(int64((8-len(output.String())%8)%8), 2))
-	paddingByte := paddingBits.asByteSlice()
-	// fmt.Printf("[ encode ] output: %v\n", output.String())
-	inputBitString := bitString(output.String())
-	inputBytes := inputBitString.asByteSlice()
-	// fmt.Printf("[ encode ] compressionHeader:%s\n\nlen(output.String()):%v\n\npaddingBits:%v\n\npaddingbyte:\n%v\n\ninputbytes:\n%v\n\n\n", compressionHeader.String(), len(output.String()), paddingBits, paddingByte, inputBytes)
-	out := append([]byte(compressionHeader.String()), append([]byte("\\\n"), append(paddingByte, inputBytes...)...)...)
-	// fmt.Printf("[ encode ] final out: %v\n", out)
-	return out
-}
\ No newline at end of file
+	if bitsCount > 0 {
+		output = append(output, byte(bitsHolder<<(8-bitsCount)))
+	}
+	// fmt.Printf("[ encode ] final out: %v\n", output)
+	return output
+}
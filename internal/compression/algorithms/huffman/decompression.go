@@ -2,13 +2,14 @@ package huffman
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
 	"sync"
-	"unicode"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
 )
 
 type DecompressionWriter struct {
@@ -19,17 +20,24 @@ type DecompressionReader struct {
 }
 
 type decompressionCore struct {
-	isInputBufferClosed bool
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
+	isInputBufferClosed  bool
+	isOutputBufferClosed bool
+	cond                 *sync.Cond
+	lock                 sync.Mutex
+	inputBuffer          io.ReadWriter
+	outputBuffer         io.ReadWriter
+	memory               *memory.Tracker
+	legacyFormat         string // the legacy format the input was in, if any
 }
 
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
-	if !dr.core.isInputBufferClosed {
-		return 0, errors.New("input buffer not closed")
+	for !dr.core.isInputBufferClosed && !dr.core.isOutputBufferClosed {
+		dr.core.cond.Wait()
+	}
+	if dr.core.isOutputBufferClosed {
+		return 0, io.ErrClosedPipe
 	}
 	return dr.core.outputBuffer.Read(data)
 }
@@ -37,6 +45,9 @@ func (dr *DecompressionReader) Read(data []byte) (int, error) {
 func (dr *DecompressionReader) Close() error {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
+	// a Read still waiting for the writer gives up instead of blocking forever
+	dr.core.isOutputBufferClosed = true
+	dr.core.cond.Broadcast()
 	if buf, ok := dr.core.inputBuffer.(*bytes.Buffer); ok {
 		buf.Reset()
 		return nil
@@ -48,128 +59,256 @@ func (dr *DecompressionReader) Close() error {
 func (dw *DecompressionWriter) Write(data []byte) (int, error) {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	if dw.core.isInputBufferClosed {
+		return 0, io.ErrClosedPipe
+	}
 	// fmt.Printf("[ DecompressionWriter.Write ] data: %v\n", data)
-	return dw.core.inputBuffer.Write(data)
+	n, err := dw.core.inputBuffer.Write(data)
+	dw.core.memory.Set(memory.Input, memory.Capacity(dw.core.inputBuffer))
+	return n, err
 }
 
 func (dw *DecompressionWriter) Close() error {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	if dw.core.isInputBufferClosed {
+		return io.ErrClosedPipe
+	}
+	// the reader is woken up even if processing fails, so it never waits forever
+	defer dw.core.cond.Broadcast()
 	dw.core.isInputBufferClosed = true
 	compressedData, err := io.ReadAll(dw.core.inputBuffer)
 	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
 	if err != nil {
 		return err
 	}
-	decompressedData := decompress(compressedData)
+	dw.core.memory.Set(memory.Copy, cap(compressedData))
+	decompressedData, legacyFormat, err := decompress(compressedData)
+	dw.core.legacyFormat = legacyFormat
+	dw.core.memory.Set(memory.Result, cap(decompressedData))
+	defer func() { dw.core.memory.Set(memory.Output, memory.Capacity(dw.core.outputBuffer)) }()
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the symbols decoded before the data ran out are still passed on
+		dw.core.outputBuffer.Write(decompressedData)
+	}
+	if err != nil {
+		return err
+	}
 	if _, err = dw.core.outputBuffer.Write(decompressedData); err != nil {
 		return err
 	}
 	return nil
 }
 
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (dw *DecompressionWriter) MemoryUsage() memory.Usage {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.memory.Usage()
+}
+
+// LegacyFormat names the format of an earlier version the input was in, or
+// returns "" when it was in the current one. It is set once Close has
+// returned.
+func (dw *DecompressionWriter) LegacyFormat() string {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.legacyFormat
+}
+
 func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(decompressionCore)
 	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newDecompressionCore.isInputBufferClosed = false
+	newDecompressionCore.memory = memory.NewTracker(0)
+	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func decompress(content []byte) []byte {
-	contentString := string(content)
-	compressionHeader := strings.SplitN(contentString, "\\\n", 2)[0]
-	// fmt.Printf("[ decompress ] compressionHeader: %v\n", compressionHeader)
-	headerRunes := []rune(compressionHeader)
-	symbolFreq := make(map[rune]int)
-	for i := range len(headerRunes) {
-		if headerRunes[i] == '|' && headerRunes[i-1] != '|' {
-			endFreq := i
-			startFreq := endFreq - 1
-			for startFreq > 0 && unicode.IsDigit(headerRunes[startFreq-1]) && (startFreq == 1 || headerRunes[startFreq-2] != rune('|')) {
-				startFreq--
-			}
-			freq, err := strconv.Atoi(string(headerRunes[startFreq:endFreq]))
-			if err != nil {
-				panic(err)
-			}
-			if headerRunes[i+1] != rune('\\') || i+2 >= len(headerRunes) || headerRunes[i+2] != 'n' {
-				symbolFreq[headerRunes[i+1]] = freq
-			} else {
-				symbolFreq[10] = freq
-			}
-		}
+// maxContentLength caps the length a header may claim. A single-symbol tree
+// is stored without any bits, so without a cap a few header bytes could ask
+// for an arbitrarily large allocation. Nothing larger than the service's
+// upload ceiling can have been compressed in the first place.
+const maxContentLength = 1 << 30
+
+// errHeaderTruncated is returned when the data ends inside the header, which
+// binary.Uvarint reports as n == 0
+var errHeaderTruncated = fmt.Errorf("huffman header is truncated: %w", io.ErrUnexpectedEOF)
+
+// The frames of the current format
+const (
+	frameHeader = 1
+	frameCode   = 2
+)
+
+// decompress reverses compress, see there for the layout of content. Input
+// without the magic was written before the format had one and goes to
+// decompressLegacy, which also returns the format it turned out to be in.
+func decompress(content []byte) ([]byte, string, error) {
+	if len(content) <= len(Magic) && bytes.HasPrefix(Magic, content) {
+		return nil, "", errHeaderTruncated
+	}
+	if !bytes.HasPrefix(content, Magic) {
+		return decompressLegacy(content)
+	}
+	switch version := content[len(Magic)]; version {
+	case formatVersion:
+		output, err := decompressFrames(content[len(Magic)+1:])
+		return output, "", err
+	case legacyBinaryVersion:
+		output, err := decompressBinary(content[len(Magic)+1:])
+		return output, FormatUnframed, err
+	default:
+		return nil, "", fmt.Errorf("unsupported huffman format version %d", version)
 	}
-	tree := buildTree(symbolFreq)
-	decompressedData := decode(tree, contentString)
-	return decompressedData
 }
 
-func getSymbolDecoded(root huffmanTree, huffmanCode string) *strings.Builder {
-	var data strings.Builder
-	switch node := root.(type) {
-	case huffmanLeaf:
-		fmt.Fprintf(&data, "%s", string(node.symbol))
-		return &data
-	case huffmanNode:
-		for index := 0; index < len(huffmanCode); index++ {
-			if huffmanCode[index] == '0' {
-				var err error
-				if index, err = getSymbol(node.left, huffmanCode, index, &data); err != nil {
-					panic(err)
-				}
-			} else {
-				var err error
-				if index, err = getSymbol(node.right, huffmanCode, index, &data); err != nil {
-					panic(err)
-				}
-			}
+// ContentLength returns the length of the original content the header of
+// data records, ok is false when data is not in the current or the unframed
+// format or its header is cut off
+func ContentLength(data []byte) (length int64, ok bool) {
+	if len(data) <= len(Magic) || !bytes.HasPrefix(data, Magic) {
+		return 0, false
+	}
+	header := data[len(Magic)+1:]
+	switch data[len(Magic)] {
+	case formatVersion:
+		frame, _, err := framing.Parse(header)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) || frame.Type != frameHeader {
+			return 0, false
 		}
+		header = frame.Payload
+	case legacyBinaryVersion:
+	default:
+		return 0, false
+	}
+	size, n := binary.Uvarint(header)
+	if n <= 0 || size > maxContentLength {
+		return 0, false
+	}
+	return int64(size), true
+}
+
+// decompressFrames checks the header and code frames and decodes them. A
+// truncated code frame returns the symbols decoded up to the cut.
+func decompressFrames(content []byte) ([]byte, error) {
+	header, n, err := framing.Parse(content)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errHeaderTruncated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("huffman header is corrupt: %w", err)
+	}
+	if header.Type != frameHeader {
+		return nil, fmt.Errorf("huffman header is corrupt: frame of type %d", header.Type)
+	}
+	content = content[n:]
+	code, n, frameErr := framing.Parse(content)
+	if frameErr != nil && !errors.Is(frameErr, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("huffman code is corrupt: %w", frameErr)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("huffman code is truncated: %w", frameErr)
 	}
-	return &data
+	if code.Type != frameCode {
+		return nil, fmt.Errorf("huffman code is corrupt: frame of type %d", code.Type)
+	}
+	if n < len(content) {
+		return nil, errors.New("huffman data continues past the code")
+	}
+	// the two payloads are the unframed format
+	output, err := decompressBinary(append(append(make([]byte, 0, len(header.Payload)+len(code.Payload)), header.Payload...), code.Payload...))
+	if err == nil && frameErr != nil {
+		// every symbol was decoded but the checksum is missing
+		return output, fmt.Errorf("huffman code is truncated: %w", frameErr)
+	}
+	return output, err
 }
 
-func getSymbol(currentNode huffmanTree, huffmanCode string, index int, data *strings.Builder) (int, error) {
-	switch node := currentNode.(type) {
-	case huffmanLeaf:
-		// fmt.Printf("[ getSymbol ] node.symbol %v\n", string(node.symbol))
-		fmt.Fprintf(data, "%s", string(node.symbol))
-		return index, nil
-	case huffmanNode:
-		index++
-		if index >= len(huffmanCode) {
-			return index, errors.New("[ getSymbol ] out of index error")
+// decompressBinary decodes the binary format that follows the version, it is
+// also all there is to the unversioned data of earlier versions
+func decompressBinary(content []byte) ([]byte, error) {
+	originalLength, n := binary.Uvarint(content)
+	if n == 0 {
+		return nil, errHeaderTruncated
+	}
+	if n < 0 || originalLength > maxContentLength {
+		return nil, errors.New("huffman header is corrupt: invalid content length")
+	}
+	content = content[n:]
+	symbolCount, n := binary.Uvarint(content)
+	if n == 0 {
+		return nil, errHeaderTruncated
+	}
+	if n < 0 || symbolCount > 256 {
+		return nil, errors.New("huffman header is corrupt: invalid symbol count")
+	}
+	content = content[n:]
+
+	symbolFreq := make(map[rune]int, symbolCount)
+	total := uint64(0)
+	previous := rune(-1)
+	for range symbolCount {
+		if len(content) == 0 {
+			return nil, errHeaderTruncated
 		}
-		if huffmanCode[index] == '0' {
-			return getSymbol(node.left, huffmanCode, index, data)
-		} else {
-			return getSymbol(node.right, huffmanCode, index, data)
+		symbol := rune(content[0])
+		freq, n := binary.Uvarint(content[1:])
+		if n == 0 {
+			return nil, errHeaderTruncated
 		}
-	default:
-		return -1, errors.New("[ getSymbol ] type unknown")
+		if n < 0 || freq == 0 || freq > originalLength-total {
+			return nil, errors.New("huffman header is corrupt: invalid symbol frequency")
+		}
+		// the table is canonical, which also rules out a symbol listed twice
+		if symbol <= previous {
+			return nil, fmt.Errorf("huffman header is corrupt: symbol %v is out of order", symbol)
+		}
+		previous = symbol
+		symbolFreq[symbol] = int(freq)
+		total += freq
+		content = content[1+n:]
+	}
+	if total != originalLength {
+		return nil, errors.New("huffman header is corrupt: frequencies do not add up to the content length")
 	}
+	if originalLength == 0 {
+		return []byte{}, nil
+	}
+	tree := buildTree(symbolFreq)
+	return decode(tree, content, int(originalLength))
 }
 
-func decode(tree huffmanTree, input string) []byte {
-	contentString := strings.SplitN(input, "\\\n", 2)[1]
-	contentBytes := []byte(contentString)
-	// fmt.Printf("[ decode ] contentString: %v\n", contentBytes)
-	var huffmanCodeBuilder strings.Builder
-	var offset int
-	for i, bait := range contentBytes {
-		if i > 0 {
-			binary := fmt.Sprintf("%08b", bait)
-			// fmt.Printf("[ decode ] bait: %v --- binary: %v\n", bait, binary)
-			fmt.Fprintf(&huffmanCodeBuilder, "%s", binary)
-		} else {
-			offset = int(bait)
+// decode walks the tree bit by bit until length symbols have been produced
+func decode(tree huffmanTree, input []byte, length int) ([]byte, error) {
+	if leaf, ok := tree.(huffmanLeaf); ok {
+		return bytes.Repeat([]byte{byte(leaf.symbol)}, length), nil
+	}
+	output := make([]byte, 0, min(length, len(input)*8))
+	node := tree
+	for i, bait := range input {
+		for bit := 7; bit >= 0; bit-- {
+			branch := node.(huffmanNode)
+			if bait>>bit&1 == 0 {
+				node = branch.left
+			} else {
+				node = branch.right
+			}
+			if leaf, ok := node.(huffmanLeaf); ok {
+				output = append(output, byte(leaf.symbol))
+				if len(output) == length {
+					if i != len(input)-1 {
+						return nil, errors.New("huffman data continues past the last symbol")
+					}
+					return output, nil
+				}
+				node = tree
+			}
 		}
 	}
-	// fmt.Printf("[ decode ] offset: %v\n", offset)
-	huffmanCode := huffmanCodeBuilder.String()[offset:]
-	// fmt.Printf("[ decode ] huffmanCode: %v\n", huffmanCode)
-	var decompressedData *strings.Builder = getSymbolDecoded(tree, huffmanCode)
-	// fmt.Printf("[ decode ] decompressedData: %v\n", decompressedData.String())
-	return []byte(decompressedData.String())
-}
\ No newline at end of file
+	// fmt.Printf("[ decode ] decoded %v of %v symbols\n", len(output), length)
+	return output, fmt.Errorf("huffman data ended after %v of %v symbols: %w", len(output), length, io.ErrUnexpectedEOF)
+}
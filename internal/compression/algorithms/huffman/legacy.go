@@ -0,0 +1,132 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Magic starts everything compress writes, the byte after it is the format
+// version. Earlier versions wrote no magic, their data is told apart by its
+// layout instead.
+var Magic = []byte{0x89, 'H', 'U', 'F'}
+
+// formatVersion is the version compress writes. Version 2 has the header
+// and code of the current format back to back instead of in frames, version
+// 1 is that without the magic and version, and version 0 the text format
+// that came before it.
+const formatVersion = 3
+
+// legacyBinaryVersion is the version of the unframed binary format
+const legacyBinaryVersion = 2
+
+// The names LegacyFormat reports for the formats of earlier versions
+const (
+	FormatUnframed    = "unframed binary (version 2)"
+	FormatUnversioned = "unversioned binary (version 1)"
+	FormatText        = "text (version 0)"
+)
+
+// textSeparator ends the frequency table of the text format
+var textSeparator = []byte("\\\n")
+
+// decompressLegacy decodes data written before the format had a magic. The
+// two formats cannot be mistaken for each other: text starts with the digits
+// of a frequency, which read as a binary header claim more symbols than the
+// content is long, so the binary decoder rejects it.
+func decompressLegacy(content []byte) ([]byte, string, error) {
+	if symbolFreq, code, ok := parseTextHeader(content); ok {
+		output, err := decodeText(symbolFreq, code)
+		return output, FormatText, err
+	}
+	output, err := decompressBinary(content)
+	return output, FormatUnversioned, err
+}
+
+// parseTextHeader reads the frequency table of the text format: pairs of a
+// decimal frequency, '|' and the symbol as UTF-8, with a newline written as
+// `\n`, followed by textSeparator. It returns the code after the table, ok is
+// false when content does not start with such a table.
+func parseTextHeader(content []byte) (symbolFreq map[rune]int, code []byte, ok bool) {
+	symbolFreq = make(map[rune]int)
+	for !bytes.HasPrefix(content, textSeparator) {
+		digits := 0
+		for digits < len(content) && content[digits] >= '0' && content[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits == len(content) || content[digits] != '|' {
+			return nil, nil, false
+		}
+		freq, err := strconv.Atoi(string(content[:digits]))
+		if err != nil || freq == 0 || freq > maxContentLength {
+			return nil, nil, false
+		}
+		content = content[digits+1:]
+		// a frequency follows every symbol, so a backslash before an n is
+		// always the escaped newline
+		symbol, size := utf8.DecodeRune(content)
+		if bytes.HasPrefix(content, []byte(`\n`)) {
+			symbol, size = '\n', 2
+		}
+		if _, listed := symbolFreq[symbol]; size == 0 || listed {
+			return nil, nil, false
+		}
+		symbolFreq[symbol] = freq
+		content = content[size:]
+	}
+	if len(symbolFreq) == 0 {
+		return nil, nil, false
+	}
+	return symbolFreq, content[len(textSeparator):], true
+}
+
+// decodeText decodes the code of the text format. Its first byte is the
+// number of zero bits the code was padded with at the front, the code runs
+// most significant bit first from there.
+func decodeText(symbolFreq map[rune]int, code []byte) ([]byte, error) {
+	length := 0
+	for _, freq := range symbolFreq {
+		length += freq
+	}
+	if length > maxContentLength {
+		return nil, errors.New("huffman header is corrupt: invalid content length")
+	}
+	if len(code) == 0 {
+		return nil, errHeaderTruncated
+	}
+	padding := int(code[0])
+	code = code[1:]
+	if padding > 7 || padding > 0 && len(code) == 0 {
+		return nil, errors.New("huffman data is corrupt: invalid padding")
+	}
+	tree := buildTree(symbolFreq)
+	if leaf, ok := tree.(huffmanLeaf); ok {
+		return bytes.Repeat(utf8.AppendRune(nil, leaf.symbol), length), nil
+	}
+	output := make([]byte, 0, min(length, len(code)*8))
+	decoded := 0
+	node := tree
+	for i := padding; i < len(code)*8; i++ {
+		branch := node.(huffmanNode)
+		if code[i/8]>>(7-i%8)&1 == 0 {
+			node = branch.left
+		} else {
+			node = branch.right
+		}
+		if leaf, ok := node.(huffmanLeaf); ok {
+			output = utf8.AppendRune(output, leaf.symbol)
+			decoded++
+			if decoded == length {
+				if i != len(code)*8-1 {
+					return nil, errors.New("huffman data continues past the last symbol")
+				}
+				return output, nil
+			}
+			node = tree
+		}
+	}
+	return output, fmt.Errorf("huffman data ended after %v of %v symbols: %w", decoded, length, io.ErrUnexpectedEOF)
+}
@@ -2,13 +2,12 @@ package huffman
 
 import (
 	"container/heap"
+	"errors"
 	"fmt"
 	"slices"
 	"sort"
 )
 
-type bitString string
-
 type CanonicalHuffmanCode struct {
 	Code   int
 	Length int
@@ -150,7 +149,8 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 		maxLength = max(maxLength, length)
 	}
 	if maxLength > lengthLimit {
-		return nil, fmt.Errorf("tree is longer than the limit %v\n", lengthLimit)
+		limitCodeLengths(lengths, symbolFreq, lengthLimit)
+		maxLength = lengthLimit
 	}
 	lengthCounts := make([]int, maxLength+1)
 	var order []struct{ symbol, length int }
@@ -190,11 +190,93 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 	return output, nil
 }
 
+// limitCodeLengths shortens the code lengths in place so none exceeds limit.
+// Codes that are too long are cut to the limit, which over-subscribes the
+// code space, and then leaves at the limit are traded for splitting the
+// deepest shorter code until the Kraft sum is exact again. The resulting
+// lengths are handed out again by frequency, shortest to the most frequent.
+func limitCodeLengths(lengths []int, symbolFreq []int, limit int) {
+	lengthCounts := make([]int, limit+1)
+	var symbols []int
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		lengthCounts[min(length, limit)]++
+		symbols = append(symbols, symbol)
+	}
+	total := 0
+	for length := 1; length <= limit; length++ {
+		total += lengthCounts[length] << (limit - length)
+	}
+	for total > 1<<limit {
+		lengthCounts[limit]--
+		for length := limit - 1; length > 0; length-- {
+			if lengthCounts[length] > 0 {
+				lengthCounts[length]--
+				lengthCounts[length+1] += 2
+				break
+			}
+		}
+		total--
+	}
+
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbolFreq[symbols[i]] > symbolFreq[symbols[j]]
+	})
+	length := 1
+	for _, symbol := range symbols {
+		for lengthCounts[length] == 0 {
+			length++
+		}
+		lengths[symbol] = length
+		lengthCounts[length]--
+	}
+}
+
+// ErrOversubscribed is returned for code lengths that claim more codes than
+// fit in the code space, no prefix-free code can be built from them
+var ErrOversubscribed = errors.New("huffman code lengths are over-subscribed")
+
+// ErrIncomplete is returned for code lengths that leave part of the code
+// space unused, which would let a decoder walk into a missing branch
+var ErrIncomplete = errors.New("huffman code lengths are incomplete")
+
+// maxDecoderCodeLength bounds the length of a single code so Reverse and the
+// code arithmetic stay within 32 bits
+const maxDecoderCodeLength = 31
+
+// validateCodeLengths checks the Kraft sum of the code lengths. The empty code
+// and a single code of length one are accepted even though they are
+// incomplete, RFC 1951 uses both for blocks without distances.
+func validateCodeLengths(lengthCounts []int) error {
+	left := 1
+	codes := 0
+	for length := 1; length < len(lengthCounts); length++ {
+		left <<= 1
+		left -= lengthCounts[length]
+		codes += lengthCounts[length]
+		if left < 0 {
+			return ErrOversubscribed
+		}
+	}
+	if left > 0 && codes > 1 {
+		return ErrIncomplete
+	}
+	if left > 0 && codes == 1 && lengthCounts[1] != 1 {
+		return ErrIncomplete
+	}
+	return nil
+}
+
 func BuildCanonicalHuffmanDecoder(lengths []uint32) (*CanonicalHuffmanNode, error) {
 	maxLength := uint32(0)
 	for _, length := range lengths {
 		maxLength = max(maxLength, length)
 	}
+	if maxLength > maxDecoderCodeLength {
+		return nil, fmt.Errorf("huffman code length %v is longer than %v", maxLength, maxDecoderCodeLength)
+	}
 	lengthCounts := make([]int, maxLength+1)
 	var order []struct {
 		symbol int
@@ -213,6 +295,9 @@ func BuildCanonicalHuffmanDecoder(lengths []uint32) (*CanonicalHuffmanNode, erro
 		})
 		lengthCounts[length]++
 	}
+	if err := validateCodeLengths(lengthCounts); err != nil {
+		return nil, err
+	}
 	sort.Slice(order, func(i, j int) bool {
 		if order[i].length == order[j].length {
 			return order[i].symbol < order[j].symbol
@@ -231,7 +316,9 @@ func BuildCanonicalHuffmanDecoder(lengths []uint32) (*CanonicalHuffmanNode, erro
 			Symbol: info.symbol,
 			Length: int(info.length),
 		}
-		buildCanonicalHuffmanTree(root, info.length, item, Reverse(nextBaseCode[info.length], info.length))
+		if err := buildCanonicalHuffmanTree(root, info.length, item, Reverse(nextBaseCode[info.length], info.length)); err != nil {
+			return nil, err
+		}
 		nextBaseCode[info.length]++
 	}
 	return root, nil
@@ -253,30 +340,34 @@ func (ch CanonicalHuffmanDecode) GetValue() int {
 	return ch.Symbol
 }
 
-func buildCanonicalHuffmanTree(node *CanonicalHuffmanNode, lengthRemaining uint32, item CanonicalHuffman, code uint32) {
-	if lengthRemaining == 0 {
-		node.Item = item
-		node.IsLeaf = true
-		// fmt.Printf("[ huffman.buildCanonicalHuffmanTree ] Leaf Item ---> Symbol: %v, Length: %v\n", item.GetValue(), item.GetLength())
-		return
-	}
-	if node.IsLeaf {
-		panic("nooooo leaf")
-	}
-	bit := code & 1
-	code >>= 1
-	lengthRemaining--
-	if bit == 0 {
-		if node.Left == nil {
-			node.Left = &CanonicalHuffmanNode{}
+// buildCanonicalHuffmanTree inserts item as a leaf at the path given by code,
+// least significant bit first
+func buildCanonicalHuffmanTree(node *CanonicalHuffmanNode, lengthRemaining uint32, item CanonicalHuffman, code uint32) error {
+	for ; lengthRemaining > 0; lengthRemaining-- {
+		if node.IsLeaf {
+			return errors.New("huffman code is a prefix of another code")
 		}
-		buildCanonicalHuffmanTree(node.Left, lengthRemaining, item, code)
-	} else {
-		if node.Right == nil {
-			node.Right = &CanonicalHuffmanNode{}
+		bit := code & 1
+		code >>= 1
+		if bit == 0 {
+			if node.Left == nil {
+				node.Left = &CanonicalHuffmanNode{}
+			}
+			node = node.Left
+		} else {
+			if node.Right == nil {
+				node.Right = &CanonicalHuffmanNode{}
+			}
+			node = node.Right
 		}
-		buildCanonicalHuffmanTree(node.Right, lengthRemaining, item, code)
 	}
+	if node.IsLeaf || node.Left != nil || node.Right != nil {
+		return errors.New("huffman code is a prefix of another code")
+	}
+	node.Item = item
+	node.IsLeaf = true
+	// fmt.Printf("[ huffman.buildCanonicalHuffmanTree ] Leaf Item ---> Symbol: %v, Length: %v\n", item.GetValue(), item.GetLength())
+	return nil
 }
 
 func Reverse(n uint32, length uint32) uint32 {
@@ -2,22 +2,30 @@ package lzss
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"io"
-	"slices"
-	"strconv"
 	"sync"
 
-	pb "github.com/cheggaaa/pb/v3"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type compressionCore struct {
-	isInputBufferClosed bool
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
-	maxMatchDistance    int
-	maxMatchLength      int
+	isInputBufferClosed  bool
+	isOutputBufferClosed bool
+	cond                 *sync.Cond
+	lock                 sync.Mutex
+	inputBuffer          io.ReadWriter
+	outputBuffer         io.ReadWriter
+	maxMatchDistance     int
+	maxMatchLength       int
+	newMatcher           MatcherFunc
+	ctx                  context.Context // parent of the matching span
+	memory               *memory.Tracker
 }
 
 type CompressionWriter struct {
@@ -31,29 +39,46 @@ type CompressionReader struct {
 func (cw *CompressionWriter) Write(data []byte) (int, error) {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
-	return cw.core.inputBuffer.Write(data)
+	if cw.core.isInputBufferClosed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := cw.core.inputBuffer.Write(data)
+	cw.core.memory.Set(memory.Input, memory.Capacity(cw.core.inputBuffer))
+	return n, err
 }
 
 func (cw *CompressionWriter) Close() error {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
+	if cw.core.isInputBufferClosed {
+		return io.ErrClosedPipe
+	}
+	// the reader is woken up even if processing fails, so it never waits forever
+	defer cw.core.cond.Broadcast()
 	cw.core.isInputBufferClosed = true
 	originalData, err := io.ReadAll(cw.core.inputBuffer)
 	if err != nil {
 		return err
 	}
-	compressedData := compress(originalData, cw.core.maxMatchDistance, cw.core.maxMatchLength)
-	if _, err = cw.core.outputBuffer.Write(compressedData); err != nil {
-		return err
-	}
-	return nil
+	cw.core.memory.Set(memory.Copy, cap(originalData))
+	_, span := telemetry.Start(cw.core.ctx, "lzss.match", attribute.Int("lzss.input_bytes", len(originalData)))
+	matcher := cw.core.newMatcher(cw.core.maxMatchDistance, cw.core.maxMatchLength)
+	compressedData := compress(originalData, matcher, cw.core.memory)
+	span.End()
+	cw.core.memory.Set(memory.Result, cap(compressedData))
+	_, err = cw.core.outputBuffer.Write(compressedData)
+	cw.core.memory.Set(memory.Output, memory.Capacity(cw.core.outputBuffer))
+	return err
 }
 
 func (cr *CompressionReader) Read(data []byte) (int, error) {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if !cr.core.isInputBufferClosed {
-		return 0, errors.New("compression failed because compression content upload has not been signaled as complete!")
+	for !cr.core.isInputBufferClosed && !cr.core.isOutputBufferClosed {
+		cr.core.cond.Wait()
+	}
+	if cr.core.isOutputBufferClosed {
+		return 0, io.ErrClosedPipe
 	}
 	return cr.core.outputBuffer.Read(data)
 }
@@ -61,6 +86,9 @@ func (cr *CompressionReader) Read(data []byte) (int, error) {
 func (cr *CompressionReader) Close() error {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
+	// a Read still waiting for the writer gives up instead of blocking forever
+	cr.core.isOutputBufferClosed = true
+	cr.core.cond.Broadcast()
 	if buf, ok := cr.core.inputBuffer.(*bytes.Buffer); ok {
 		buf.Reset()
 		return nil
@@ -73,63 +101,117 @@ func NewCompressionReaderAndWriter(matchDistance, matchLength int) (io.ReadClose
 	newCompressionCore := new(compressionCore)
 	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newCompressionCore.isInputBufferClosed = false
+	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
 	newCompressionCore.maxMatchDistance = matchDistance
 	newCompressionCore.maxMatchLength = min(matchLength, matchDistance)
+	newCompressionCore.newMatcher = NewKMPMatcher
+	newCompressionCore.ctx = context.Background()
+	newCompressionCore.memory = memory.NewTracker(matchDistance)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
 	return newCompressionReader, newCompressionWriter
 }
 
-func FindMatch(refChannels []chan Reference, content []rune, matchDistance, matchLength int) {
-	for i := range len(content) {
-		refChannels[i] = make(chan Reference, 1)
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (cw *CompressionWriter) MemoryUsage() memory.Usage {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	return cw.core.memory.Usage()
+}
+
+// SetContext sets the context the matching span is started in, it has to be
+// called before Write
+func (cw *CompressionWriter) SetContext(ctx context.Context) {
+	cw.core.ctx = ctx
+}
+
+// SetMatcher sets how matches are searched for, NewKMPMatcher unless it is
+// called. It has to be called before Close.
+func (cw *CompressionWriter) SetMatcher(newMatcher MatcherFunc) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.newMatcher = newMatcher
+}
+
+func FindMatch(refChannels []chan Reference, content []byte, matchDistance, matchLength int) {
+	FindMatchRange(refChannels, content, 0, len(content), matchDistance, matchLength)
+}
+
+// FindMatchRange is FindMatch for the positions start to end only, refChannels[0]
+// receives the reference for start. Matches are still searched for in up to
+// matchDistance bytes before each position and may run on past end, so a long
+// input can be matched a range at a time with the same result.
+func FindMatchRange(refChannels []chan Reference, content []byte, start, end, matchDistance, matchLength int) {
+	for i := start; i < end; i++ {
+		refChannels[i-start] = make(chan Reference, 1)
 		searchStartIdx := max(0, i-matchDistance)
 		nextEndIdx := min(len(content), i+matchLength)
 		// fmt.Printf("[ lzss - compress ] index %v\tsearchBuffer\n%v\n", i, string(content[searchStartIdx:i]))
 		// fmt.Printf("[ lzss - compress ] index %v\tpattern\n%v\n", i, string(content[i:nextEndIdx]))
-		go matchSearchBuffer(refChannels[i], content[searchStartIdx:i], []rune{content[i]}, content[i+1:nextEndIdx])
+		go matchSearchBuffer(refChannels[i-start], content[searchStartIdx:i], content[i:i+1], content[i+1:nextEndIdx])
 	}
 }
 
-func compress(content []byte, matchDistance, matchLength int) []byte {
-	contentString := string(content)
-	// fmt.Printf("[ lzss - compress ] contentString:%v\n", contentString)
-	contentRune := []rune(contentString)
-	contentRune = escapeConflictingSymbols(contentRune)
-
-	bar := pb.New(len(contentRune))
-	bar.Set(pb.Bytes, true)
-	bar.Start()
-
-	refChannels := make([]chan Reference, len(contentRune))
-	FindMatch(refChannels, contentRune, matchDistance, matchLength)
-	var compressedContentRune []rune
-	nextRunesToIgnore := 0
-	for _, channel := range refChannels {
-		ref := <-channel
-		if nextRunesToIgnore > 0 {
-			nextRunesToIgnore--
-		} else if ref.IsRef {
+// progress reports how far compress got
+type progress interface {
+	Increment()
+	Finish()
+}
+
+// compress encodes content as
+//
+//	4 bytes  magic, 0x89 'L' 'Z' 'S'
+//	byte     format version, formatVersion
+//	frame    frameHeader with a checksum, holding the uvarint length of the
+//	         original content
+//	frame    frameTokens with a checksum, holding the tokens in groups of up
+//	         to eight behind a flag byte, whose bits tell from the least
+//	         significant one up whether a token is a literal (0), the byte
+//	         itself, or a back reference (1), the uvarint distance back
+//	         followed by the uvarint length
+//
+// Frames are laid out as package framing writes them.
+// It records what matcher holds in tracker as working memory.
+func compress(content []byte, matcher Matcher, tracker *memory.Tracker) []byte {
+	// fmt.Printf("[ lzss - compress ] content:%v\n", string(content))
+	bar := startProgress(len(content))
+	defer bar.Finish()
+
+	matcher.Reset(content, 0)
+	var compressedContent []byte
+	flags, tokens := 0, 0
+	for {
+		ref, ok := matcher.NextToken()
+		if !ok {
+			break
+		}
+		tracker.Set(memory.Working, matcher.HeldBytes())
+		bar.Increment()
+		if tokens%8 == 0 {
+			flags = len(compressedContent)
+			compressedContent = append(compressedContent, 0)
+		}
+		if ref.IsRef && referenceSize(ref.NegativeOffset, ref.Size) < ref.Size {
 			// fmt.Printf("[ lzss - compress ] isRef at index %v for content: %v\n", i, string(ref.value))
-			encoding := getSymbolEncoded(ref.NegativeOffset, ref.Size)
-			if len(encoding) < ref.Size {
-				compressedContentRune = append(compressedContentRune, encoding...)
-				nextRunesToIgnore = ref.Size - 1
-			} else {
-				// fmt.Printf("[ lzss - compress ] ref not used at index: %v, content at loc: %v\n", i, string(ref.value[0]))
-				compressedContentRune = append(compressedContentRune, ref.Value[0])
+			compressedContent[flags] |= 1 << (tokens % 8)
+			compressedContent = appendReference(compressedContent, ref.NegativeOffset, ref.Size)
+			matcher.Skip(ref.Size - 1)
+			for range ref.Size - 1 {
+				bar.Increment()
 			}
 		} else {
-			compressedContentRune = append(compressedContentRune, ref.Value...)
+			compressedContent = append(compressedContent, ref.Value[0])
 		}
-		bar.Increment()
+		tokens++
 	}
-	// fmt.Printf("[ lzss - compress ] compressContent\n%v\n", string(compressedContentRune))
-	compressedContent := []byte(string(compressedContentRune))
-	return compressedContent
+	// fmt.Printf("[ lzss - compress ] compressContent\n%v\n", string(compressedContent))
+	output := append(append([]byte{}, Magic...), formatVersion)
+	output = framing.Append(output, frameHeader, binary.AppendUvarint(nil, uint64(len(content))), true)
+	return framing.Append(output, frameTokens, compressedContent, true)
 }
 
-func findPrefix(pattern []rune) []int {
+func findPrefix(pattern []byte) []int {
 	pi := make([]int, len(pattern))
 	for i := 1; i < len(pattern); i++ {
 		j := pi[i-1]
@@ -144,7 +226,7 @@ func findPrefix(pattern []rune) []int {
 	return pi
 }
 
-func kmp(searchBuffer []rune, pattern []rune) (int, int) {
+func kmp(searchBuffer []byte, pattern []byte) (int, int) {
 	pi := findPrefix(pattern)
 	best, k, bestIndex := 0, 0, 0
 	for i, b := range searchBuffer {
@@ -166,8 +248,8 @@ func kmp(searchBuffer []rune, pattern []rune) (int, int) {
 	return best, bestIndex
 }
 
-func matchSearchBuffer(refChannel chan<- Reference, searchBuffer []rune, scanRunes []rune, nextRunes []rune) {
-	pattern := append(scanRunes, nextRunes...)
+func matchSearchBuffer(refChannel chan<- Reference, searchBuffer []byte, scanBytes []byte, nextBytes []byte) {
+	pattern := append(append([]byte{}, scanBytes...), nextBytes...)
 	// fmt.Printf("[ lzss - matchSearchBuffer ] searchBuffer\n%v\n", string(searchBuffer))
 	// fmt.Printf("[ lzss - matchSearchBuffer ] pattern\n%v\n", string(pattern))
 	matchedLength, matchedAt := kmp(searchBuffer, pattern)
@@ -179,30 +261,8 @@ func matchSearchBuffer(refChannel chan<- Reference, searchBuffer []rune, scanRun
 		ref.NegativeOffset = len(searchBuffer) - matchedAt
 	} else {
 		ref.IsRef = false
-		ref.Value = scanRunes
-		ref.Size = len(scanRunes)
+		ref.Value = scanBytes
+		ref.Size = len(scanBytes)
 	}
 	refChannel <- ref
 }
-
-func escapeConflictingSymbols(content []rune) []rune {
-	filteredContent := make([]rune, 0)
-	for _, symbol := range content {
-		if slices.Contains(conflictingLiterals, symbol) {
-			filteredContent = append(filteredContent, []rune{Escape, symbol}...)
-		} else {
-			filteredContent = append(filteredContent, symbol)
-		}
-	}
-	return filteredContent
-}
-
-func getSymbolEncoded(negOffset int, length int) []rune {
-	var output []rune
-	output = append(output, Opening)
-	output = append(output, []rune(strconv.Itoa(negOffset))...)
-	output = append(output, Separator)
-	output = append(output, []rune(strconv.Itoa(length))...)
-	output = append(output, Closing)
-	return output
-}
\ No newline at end of file
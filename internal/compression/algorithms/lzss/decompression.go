@@ -3,17 +3,24 @@ package lzss
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"slices"
 	"strconv"
 	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
 )
 
 type decompressionCore struct {
-	isInputBufferClosed bool
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
+	isInputBufferClosed  bool
+	isOutputBufferClosed bool
+	cond                 *sync.Cond
+	lock                 sync.Mutex
+	inputBuffer          io.ReadWriter
+	outputBuffer         io.ReadWriter
+	memory               *memory.Tracker
+	legacyFormat         string // the legacy format the input was in, if any
 }
 
 type DecompressionWriter struct {
@@ -27,18 +34,36 @@ type DecompressionReader struct {
 func (dw *DecompressionWriter) Write(data []byte) (int, error) {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
-	return dw.core.inputBuffer.Write(data)
+	if dw.core.isInputBufferClosed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := dw.core.inputBuffer.Write(data)
+	dw.core.memory.Set(memory.Input, memory.Capacity(dw.core.inputBuffer))
+	return n, err
 }
 
 func (dw *DecompressionWriter) Close() error {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	if dw.core.isInputBufferClosed {
+		return io.ErrClosedPipe
+	}
+	// the reader is woken up even if processing fails, so it never waits forever
+	defer dw.core.cond.Broadcast()
 	dw.core.isInputBufferClosed = true
 	compressedData, err := io.ReadAll(dw.core.inputBuffer)
 	if err != nil {
 		return err
 	}
-	decompressedData, err := decompress(compressedData)
+	dw.core.memory.Set(memory.Copy, cap(compressedData))
+	decompressedData, legacyFormat, err := decompress(compressedData)
+	dw.core.legacyFormat = legacyFormat
+	dw.core.memory.Set(memory.Result, cap(decompressedData))
+	defer func() { dw.core.memory.Set(memory.Output, memory.Capacity(dw.core.outputBuffer)) }()
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the content decoded before the data ran out is still passed on
+		dw.core.outputBuffer.Write(decompressedData)
+	}
 	if err != nil {
 		return err
 	}
@@ -51,8 +76,11 @@ func (dw *DecompressionWriter) Close() error {
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
-	if !dr.core.isInputBufferClosed {
-		return 0, errors.New("decompression failed because compression content upload has not been signaled as complete!")
+	for !dr.core.isInputBufferClosed && !dr.core.isOutputBufferClosed {
+		dr.core.cond.Wait()
+	}
+	if dr.core.isOutputBufferClosed {
+		return 0, io.ErrClosedPipe
 	}
 	return dr.core.outputBuffer.Read(data)
 }
@@ -60,6 +88,9 @@ func (dr *DecompressionReader) Read(data []byte) (int, error) {
 func (dr *DecompressionReader) Close() error {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
+	// a Read still waiting for the writer gives up instead of blocking forever
+	dr.core.isOutputBufferClosed = true
+	dr.core.cond.Broadcast()
 	if buf, ok := dr.core.inputBuffer.(*bytes.Buffer); ok {
 		buf.Reset()
 		return nil
@@ -68,37 +99,67 @@ func (dr *DecompressionReader) Close() error {
 	}
 }
 
+// MemoryUsage reports the buffers the writer held, it is complete once Close
+// has returned
+func (dw *DecompressionWriter) MemoryUsage() memory.Usage {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.memory.Usage()
+}
+
+// LegacyFormat names the format of an earlier version the input was in, or
+// returns "" when it was in the current one. It is set once Close has
+// returned.
+func (dw *DecompressionWriter) LegacyFormat() string {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	return dw.core.legacyFormat
+}
+
 func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(decompressionCore)
 	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newDecompressionCore.isInputBufferClosed = false
+	newDecompressionCore.memory = memory.NewTracker(0)
+	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func decompress(content []byte) ([]byte, error) {
-	contentString := string(content)
-	contentRune := []rune(contentString)
-	var err error
-	if contentRune, err = decodeBackRefs(contentRune); err != nil {
+// decompressText decodes the text format of version 1
+func decompressText(content []byte) ([]byte, error) {
+	content, err := decodeBackRefs(content)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// an escape cut off from the literal it belongs to is dropped as well
+		if countEscapesInReverse(content, len(content)-1)%2 == 1 {
+			content = content[:len(content)-1]
+		}
+		if content, escapeErr := removeEscapes(content); escapeErr == nil {
+			return content, err
+		}
+		return nil, err
+	}
+	if err != nil {
 		return nil, err
 	}
-	if contentRune, err = removeEscapes(contentRune); err != nil {
+	if content, err = removeEscapes(content); err != nil {
 		return nil, err
 	}
-	decompressedContent := []byte(string(contentRune))
-	return decompressedContent, nil
+	return content, nil
 }
 
-func decodeBackRefs(refedContent []rune) ([]rune, error) {
+// decodeBackRefs resolves the <offset,length> references of the text format.
+// Offsets and lengths count bytes, so a reference may start or end inside a
+// multi-byte character.
+func decodeBackRefs(refedContent []byte) ([]byte, error) {
 	refOn := false
 	var currentNegOffset, currentLength, currentRefStart int
-	var refValue []rune
-	var derefedContent []rune
+	var refValue []byte
+	var derefedContent []byte
 	for i := range refedContent {
 		if refOn == false && refedContent[i] == Opening && countEscapesInReverse(refedContent, i-1)%2 == 0 {
-			refValue = []rune{}
+			refValue = []byte{}
 			currentRefStart = len(derefedContent)
 			refOn = true
 		} else if refOn == true {
@@ -108,14 +169,18 @@ func decodeBackRefs(refedContent []rune) ([]rune, error) {
 				if currentNegOffset, err = strconv.Atoi(string(refValue)); err != nil {
 					return nil, err
 				}
-				refValue = []rune{}
+				refValue = []byte{}
 			case Closing:
 				var err error
 				if currentLength, err = strconv.Atoi(string(refValue)); err != nil {
 					return nil, err
 				}
 				refOn = false
-				derefedContent = append(derefedContent, replaceRef(derefedContent, currentRefStart, currentNegOffset, currentLength)...)
+				ref, err := replaceRef(derefedContent, currentRefStart, currentNegOffset, currentLength)
+				if err != nil {
+					return nil, err
+				}
+				derefedContent = append(derefedContent, ref...)
 			default:
 				refValue = append(refValue, refedContent[i])
 			}
@@ -123,10 +188,16 @@ func decodeBackRefs(refedContent []rune) ([]rune, error) {
 			derefedContent = append(derefedContent, refedContent[i])
 		}
 	}
+	if refOn {
+		return derefedContent, fmt.Errorf("back reference is not terminated: %w", io.ErrUnexpectedEOF)
+	}
+	if countEscapesInReverse(derefedContent, len(derefedContent)-1)%2 == 1 {
+		return derefedContent, fmt.Errorf("escape at the end of the data: %w", io.ErrUnexpectedEOF)
+	}
 	return derefedContent, nil
 }
 
-func countEscapesInReverse(content []rune, endIdx int) int {
+func countEscapesInReverse(content []byte, endIdx int) int {
 	if endIdx < 0 {
 		return 0
 	}
@@ -140,14 +211,19 @@ func countEscapesInReverse(content []rune, endIdx int) int {
 	return count
 }
 
-func replaceRef(content []rune, refIdx, negOffset, length int) []rune {
+// replaceRef returns the bytes a back reference points at, which have to lie
+// entirely within the content decoded before it
+func replaceRef(content []byte, refIdx, negOffset, length int) ([]byte, error) {
+	if negOffset <= 0 || negOffset > refIdx || length < 0 || length > negOffset {
+		return nil, fmt.Errorf("back reference <%d,%d> points outside of the decoded content", negOffset, length)
+	}
 	startIdx := refIdx - negOffset
 	endIdx := startIdx + length
-	return content[startIdx:endIdx]
+	return content[startIdx:endIdx], nil
 }
 
-func removeEscapes(content []rune) ([]rune, error) {
-	var cleanedContent []rune
+func removeEscapes(content []byte) ([]byte, error) {
+	var cleanedContent []byte
 	for i := len(content) - 1; i >= 0; i-- {
 		if slices.Contains(conflictingLiterals, content[i]) {
 			if i == 0 || content[i-1] != Escape {
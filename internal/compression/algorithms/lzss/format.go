@@ -0,0 +1,192 @@
+package lzss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+)
+
+// Magic starts everything compress writes, the byte after it is the format
+// version. Version 1 was the text format, with escaped literals and back
+// references written out as <offset,length>; it had no magic, so data
+// without one is decoded as text. Version 2 had the length and tokens of the
+// current format back to back instead of in frames.
+var Magic = []byte{0x89, 'L', 'Z', 'S'}
+
+// formatVersion is the version compress writes
+const formatVersion = 3
+
+// legacyBinaryVersion is the version of the unframed binary format
+const legacyBinaryVersion = 2
+
+// The frames of the current format
+const (
+	frameHeader = 1
+	frameTokens = 2
+)
+
+// The names LegacyFormat reports for the formats of earlier versions
+const (
+	FormatUnframed = "unframed binary (version 2)"
+	FormatText     = "text (version 1)"
+)
+
+// maxContentLength caps the length a header may claim, a few bytes of back
+// references could otherwise ask for an arbitrarily large output
+const maxContentLength = 1 << 30
+
+var errHeaderTruncated = fmt.Errorf("lzss header is truncated: %w", io.ErrUnexpectedEOF)
+
+// decompress reverses compress and returns the legacy format content was in,
+// "" for the current one. Text that happens to start with the magic is taken
+// for the current format.
+func decompress(content []byte) ([]byte, string, error) {
+	if len(content) > 0 && len(content) <= len(Magic) && bytes.HasPrefix(Magic, content) {
+		return nil, "", errHeaderTruncated
+	}
+	if !bytes.HasPrefix(content, Magic) {
+		output, err := decompressText(content)
+		return output, FormatText, err
+	}
+	switch version := content[len(Magic)]; version {
+	case formatVersion:
+		output, err := decompressFrames(content[len(Magic)+1:])
+		return output, "", err
+	case legacyBinaryVersion:
+		output, err := decompressBinary(content[len(Magic)+1:])
+		return output, FormatUnframed, err
+	default:
+		return nil, "", fmt.Errorf("unsupported lzss format version %d", version)
+	}
+}
+
+// ContentLength returns the length of the original content the header of
+// data records, ok is false when data is not in the current or the unframed
+// format or its header is cut off
+func ContentLength(data []byte) (length int64, ok bool) {
+	if len(data) <= len(Magic) || !bytes.HasPrefix(data, Magic) {
+		return 0, false
+	}
+	header := data[len(Magic)+1:]
+	switch data[len(Magic)] {
+	case formatVersion:
+		frame, _, err := framing.Parse(header)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) || frame.Type != frameHeader {
+			return 0, false
+		}
+		header = frame.Payload
+	case legacyBinaryVersion:
+	default:
+		return 0, false
+	}
+	size, n := binary.Uvarint(header)
+	if n <= 0 || size > maxContentLength {
+		return 0, false
+	}
+	return int64(size), true
+}
+
+// decompressFrames checks the header and token frames and decodes them. A
+// truncated token frame returns what was decoded up to the cut.
+func decompressFrames(content []byte) ([]byte, error) {
+	header, n, err := framing.Parse(content)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errHeaderTruncated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lzss header is corrupt: %w", err)
+	}
+	if header.Type != frameHeader {
+		return nil, fmt.Errorf("lzss header is corrupt: frame of type %d", header.Type)
+	}
+	content = content[n:]
+	tokens, n, frameErr := framing.Parse(content)
+	if frameErr != nil && !errors.Is(frameErr, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("lzss data is corrupt: %w", frameErr)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("lzss data is truncated: %w", frameErr)
+	}
+	if tokens.Type != frameTokens {
+		return nil, fmt.Errorf("lzss data is corrupt: frame of type %d", tokens.Type)
+	}
+	if n < len(content) {
+		return nil, errors.New("lzss data continues past the tokens")
+	}
+	// the two payloads are the unframed format
+	output, err := decompressBinary(append(append(make([]byte, 0, len(header.Payload)+len(tokens.Payload)), header.Payload...), tokens.Payload...))
+	if err == nil && frameErr != nil {
+		// every token was decoded but the checksum is missing
+		return output, fmt.Errorf("lzss data is truncated: %w", frameErr)
+	}
+	return output, err
+}
+
+// appendReference appends a back reference the way compress writes it
+func appendReference(output []byte, negOffset, length int) []byte {
+	output = binary.AppendUvarint(output, uint64(negOffset))
+	return binary.AppendUvarint(output, uint64(length))
+}
+
+// referenceSize is the number of bytes appendReference takes for a reference
+func referenceSize(negOffset, length int) int {
+	return len(appendReference(make([]byte, 0, 2*binary.MaxVarintLen64), negOffset, length))
+}
+
+// decompressBinary decodes what follows the version, see compress for the
+// layout. A truncated input returns what was decoded up to the cut.
+func decompressBinary(content []byte) ([]byte, error) {
+	length, n := binary.Uvarint(content)
+	if n == 0 {
+		return nil, errHeaderTruncated
+	}
+	if n < 0 || length > maxContentLength {
+		return nil, errors.New("lzss header is corrupt: invalid content length")
+	}
+	content = content[n:]
+	output := make([]byte, 0, min(int(length), 4*len(content)))
+	truncated := func() ([]byte, error) {
+		return output, fmt.Errorf("lzss data ended after %d of %d bytes: %w", len(output), length, io.ErrUnexpectedEOF)
+	}
+	var flags byte
+	for token := 0; len(output) < int(length); token++ {
+		if token%8 == 0 {
+			if len(content) == 0 {
+				return truncated()
+			}
+			flags, content = content[0], content[1:]
+		}
+		if flags>>(token%8)&1 == 0 {
+			if len(content) == 0 {
+				return truncated()
+			}
+			output, content = append(output, content[0]), content[1:]
+			continue
+		}
+		negOffset, n := binary.Uvarint(content)
+		if n == 0 {
+			return truncated()
+		}
+		size, m := binary.Uvarint(content[max(n, 0):])
+		if m == 0 {
+			return truncated()
+		}
+		if n < 0 || m < 0 || negOffset == 0 || negOffset > uint64(len(output)) || size == 0 || size > length-uint64(len(output)) {
+			return nil, fmt.Errorf("back reference <%d,%d> points outside of the decoded content", negOffset, size)
+		}
+		content = content[n+m:]
+		// byte by byte, a reference may overlap the bytes it produces
+		start := len(output) - int(negOffset)
+		for i := range int(size) {
+			output = append(output, output[start+i])
+		}
+	}
+	if len(content) > 0 {
+		return nil, errors.New("lzss data continues past the end of the content")
+	}
+	return output, nil
+}
@@ -0,0 +1,143 @@
+package lzss
+
+const (
+	// hashBits is the size of the hash of the 3 bytes a match starts with
+	hashBits = 15
+
+	// hashChainMinMatch is the shortest match a hash chain finds, the bytes
+	// its positions are hashed by
+	hashChainMinMatch = 3
+
+	// maxChainLength is how many earlier positions a search looks at
+	// before it takes the longest match so far, as zlib does at its default
+	// level
+	maxChainLength = 128
+)
+
+// HashChainMatcher finds matches the way zlib does: every position is
+// chained to the previous one whose next 3 bytes hash the same, head holds
+// the latest position of each hash and prev the one before each position.
+// A search walks the chain of the current position back through the window
+// and compares the candidates byte by byte, the nearest of equally long
+// matches wins. It takes time in the input and the chain length, and holds
+// two arrays however much it searches.
+type HashChainMatcher struct {
+	data     []byte
+	distance int
+	length   int
+	position int
+	inserted int   // the positions before it are in the chains
+	head     []int // the latest position+1 of every hash, 0 for none
+	prev     []int // the previous position+1 of the same hash, by position modulo distance
+}
+
+// NewHashChainMatcher returns a HashChainMatcher for the window, it is a
+// MatcherFunc
+func NewHashChainMatcher(distance, length int) Matcher {
+	return &HashChainMatcher{
+		distance: distance,
+		length:   length,
+		head:     make([]int, 1<<hashBits),
+		prev:     make([]int, distance),
+	}
+}
+
+func (m *HashChainMatcher) Reset(data []byte, start int) {
+	m.data, m.position = data, start
+	clear(m.head)
+	// the bytes before start are only matched against, the ones further
+	// back than the window are not even that
+	m.inserted = max(start-m.distance, 0)
+	m.insert(start)
+}
+
+func (m *HashChainMatcher) NextToken() (Reference, bool) {
+	if m.position >= len(m.data) {
+		return Reference{}, false
+	}
+	// the positions a match skipped are chained as well
+	m.insert(m.position)
+	pos := m.position
+	size, offset := m.search(pos)
+	m.insert(pos + 1)
+	m.position++
+	if size < hashChainMinMatch {
+		return Reference{Value: m.data[pos : pos+1], Size: 1}, true
+	}
+	return Reference{
+		Value:          m.data[pos : pos+size],
+		IsRef:          true,
+		NegativeOffset: offset,
+		Size:           size,
+	}, true
+}
+
+// search returns the longest match at pos and how far back it is. A match
+// may overlap what it codes, a run of zeros is a zero and a match one byte
+// back; decoders copy it a byte at a time.
+func (m *HashChainMatcher) search(pos int) (size, offset int) {
+	if pos+hashChainMinMatch > len(m.data) {
+		return 0, 0
+	}
+	limit := min(m.length, len(m.data)-pos)
+	candidate := m.head[m.hash(pos)]
+	for range maxChainLength {
+		if candidate == 0 {
+			break
+		}
+		at := candidate - 1
+		back := pos - at
+		if back > m.distance {
+			break
+		}
+		// a candidate only beats the match so far if it agrees on the byte
+		// after it
+		if m.data[at+size] == m.data[pos+size] {
+			n := 0
+			for n < limit && m.data[at+n] == m.data[pos+n] {
+				n++
+			}
+			if n > size {
+				size, offset = n, back
+				if size == limit {
+					break
+				}
+			}
+		}
+		next := m.prev[at%m.distance]
+		if next >= candidate {
+			// the slot was taken over by a later position, the chain ends
+			break
+		}
+		candidate = next
+	}
+	return size, offset
+}
+
+// insert chains the positions up to end
+func (m *HashChainMatcher) insert(end int) {
+	end = min(end, len(m.data)-hashChainMinMatch+1)
+	for ; m.inserted < end; m.inserted++ {
+		h := m.hash(m.inserted)
+		m.prev[m.inserted%m.distance] = m.head[h]
+		m.head[h] = m.inserted + 1
+	}
+}
+
+// hash mixes the 3 bytes at pos into hashBits bits
+func (m *HashChainMatcher) hash(pos int) int {
+	v := uint32(m.data[pos]) | uint32(m.data[pos+1])<<8 | uint32(m.data[pos+2])<<16
+	return int(v * 0x9e3779b1 >> (32 - hashBits))
+}
+
+func (m *HashChainMatcher) Skip(n int) {
+	m.position = min(m.position+n, len(m.data))
+}
+
+func (m *HashChainMatcher) Position() int {
+	return m.position
+}
+
+func (m *HashChainMatcher) HeldBytes() int {
+	return (len(m.head) + len(m.prev)) * 8
+}
@@ -0,0 +1,89 @@
+package lzss
+
+// Matcher finds the back references of an LZ77 coder. It is walked over its
+// data a position at a time: NextToken returns the longest match at the
+// current position, or the byte there as a literal, and moves on by one.
+// Whether a match is worth taking is up to the coder, when it takes one it
+// skips the positions the match covers. lzss and flate both parse with
+// matchers, so either can be given one that searches differently.
+type Matcher interface {
+	// Reset starts over on data at position start. The bytes before start
+	// are not coded, only matched against, as a preset dictionary is.
+	Reset(data []byte, start int)
+
+	// NextToken returns the reference at the current position and moves to
+	// the next one, it returns false once the data is used up. A match
+	// reaches back at most the window distance and is at most the window
+	// length long.
+	NextToken() (Reference, bool)
+
+	// Skip moves past n positions without returning them
+	Skip(n int)
+
+	// Position is the position the next NextToken returns the reference of
+	Position() int
+
+	// HeldBytes is what the matcher holds to search with, for the memory
+	// stats of the coder
+	HeldBytes() int
+}
+
+// MatcherFunc returns a matcher for a window: matches reach back up to
+// distance bytes and are up to length bytes long
+type MatcherFunc func(distance, length int) Matcher
+
+// kmpSegmentSize is the number of positions KMPMatcher searches at a time.
+// Each one still searches the whole window behind it, segments only bound
+// the goroutines and references alive at once.
+const kmpSegmentSize = 64 * 1024
+
+// KMPMatcher searches the window behind every position with
+// Knuth-Morris-Pratt in a goroutine of its own, as FindMatchRange does. It
+// finds the longest match, the nearest of equally long ones, but takes time
+// in the product of the input and the window.
+type KMPMatcher struct {
+	data     []byte
+	distance int
+	length   int
+	position int
+	base     int              // the position refs[0] is the reference of
+	refs     []chan Reference // the searches under way
+}
+
+// NewKMPMatcher returns a KMPMatcher for the window, it is a MatcherFunc
+func NewKMPMatcher(distance, length int) Matcher {
+	return &KMPMatcher{distance: distance, length: length}
+}
+
+func (m *KMPMatcher) Reset(data []byte, start int) {
+	m.data, m.position = data, start
+	m.base, m.refs = start, nil
+}
+
+func (m *KMPMatcher) NextToken() (Reference, bool) {
+	if m.position >= len(m.data) {
+		return Reference{}, false
+	}
+	if m.position >= m.base+len(m.refs) {
+		end := min(m.position+kmpSegmentSize, len(m.data))
+		m.base, m.refs = m.position, make([]chan Reference, end-m.position)
+		FindMatchRange(m.refs, m.data, m.base, end, m.distance, m.length)
+	}
+	ref := <-m.refs[m.position-m.base]
+	m.position++
+	return ref, true
+}
+
+// Skip leaves the searches of the skipped positions to finish on their own,
+// their channels are buffered
+func (m *KMPMatcher) Skip(n int) {
+	m.position = min(m.position+n, len(m.data))
+}
+
+func (m *KMPMatcher) Position() int {
+	return m.position
+}
+
+func (m *KMPMatcher) HeldBytes() int {
+	return len(m.refs) * ReferenceSize
+}
@@ -0,0 +1,142 @@
+package lzss_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/lzss"
+)
+
+// literalMatcher is an lzss.Matcher that never finds a match
+type literalMatcher struct {
+	data     []byte
+	position int
+}
+
+func (m *literalMatcher) Reset(data []byte, start int) { m.data, m.position = data, start }
+func (m *literalMatcher) Skip(n int)                   { m.position = min(m.position+n, len(m.data)) }
+func (m *literalMatcher) Position() int                { return m.position }
+func (m *literalMatcher) HeldBytes() int               { return 0 }
+
+func (m *literalMatcher) NextToken() (lzss.Reference, bool) {
+	if m.position >= len(m.data) {
+		return lzss.Reference{}, false
+	}
+	m.position++
+	return lzss.Reference{Value: m.data[m.position-1 : m.position], Size: 1}, true
+}
+
+// TestMatcher plugs matchers into the coders: the default matcher given
+// explicitly changes nothing, one that finds no matches still round-trips
+func TestMatcher(t *testing.T) {
+	input := []byte(strings.Repeat("matchers are plugged into lzss and flate. ", 30))
+	defaults := map[string]lzss.MatcherFunc{
+		"lzss":  lzss.NewKMPMatcher,
+		"flate": lzss.NewHashChainMatcher,
+		"gzip":  lzss.NewHashChainMatcher,
+	}
+	for algorithm, newMatcher := range defaults {
+		options := compression.Options{Algorithm: algorithm, TinyInputSize: -1}
+		want, _, err := compression.Compress(input, options)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		windows := 0
+		options.Matcher = func(distance, length int) lzss.Matcher {
+			windows++
+			return newMatcher(distance, length)
+		}
+		if got, _, err := compression.Compress(input, options); err != nil || !bytes.Equal(got, want) || windows != 1 {
+			t.Errorf("%s: the default matcher compressed to %d bytes, want %d, %v", algorithm, len(got), len(want), err)
+		}
+
+		options.Matcher = func(int, int) lzss.Matcher { return new(literalMatcher) }
+		literal, _, err := compression.Compress(input, options)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if len(literal) <= len(want) {
+			t.Errorf("%s: without matches compressed to %d bytes, no more than the %d with them", algorithm, len(literal), len(want))
+		}
+		if decompressed, _, err := compression.Decompress(literal, options); err != nil || !bytes.Equal(decompressed, input) {
+			t.Errorf("%s: round trip without matches failed: %v", algorithm, err)
+		}
+	}
+}
+
+// TestHashChainMatcher checks that the hash chain finds matches at least as
+// long as the KMP matcher does where the chain is short enough to hold them
+// all, and that flate output of it decodes across segments and a dictionary.
+// KMP takes the first match in the window, the chain the nearest, so of
+// equally long ones the chain's may only be nearer. Unlike KMP's, the
+// chain's matches may overlap what they code, and be longer for it.
+func TestHashChainMatcher(t *testing.T) {
+	data := []byte(strings.Repeat("hash chains find the nearest of the longest matches. ", 20))
+	kmp, chain := lzss.NewKMPMatcher(4096, 258), lzss.NewHashChainMatcher(4096, 258)
+	kmp.Reset(data, 0)
+	chain.Reset(data, 0)
+	for {
+		want, ok := kmp.NextToken()
+		got, _ := chain.NextToken()
+		if !ok {
+			break
+		}
+		// KMP takes matches of 2 bytes, 3 is the shortest a chain finds
+		if want.IsRef && want.Size < 3 {
+			want = lzss.Reference{Value: want.Value[:1], Size: 1}
+		}
+		if got.IsRef != want.IsRef || got.Size < want.Size || got.Size == want.Size && got.NegativeOffset > want.NegativeOffset {
+			t.Fatalf("at %d: got a match of %d bytes %d back, want %d bytes %d back", chain.Position()-1, got.Size, got.NegativeOffset, want.Size, want.NegativeOffset)
+		}
+	}
+
+	// a run is a byte and a match that overlaps it, as long as a match goes
+	chain.Reset(make([]byte, 1000), 0)
+	if first, _ := chain.NextToken(); first.IsRef {
+		t.Errorf("the first byte of a run is a match %d back", first.NegativeOffset)
+	}
+	if run, _ := chain.NextToken(); !run.IsRef || run.NegativeOffset != 1 || run.Size != 258 {
+		t.Errorf("the rest of a run is a match of %d bytes %d back, want 258 bytes 1 back", run.Size, run.NegativeOffset)
+	}
+
+	// which makes runs and short repeats about as small as the standard
+	// library makes them
+	for name, repeated := range map[string][]byte{"zeros": make([]byte, 300000), "ab": bytes.Repeat([]byte("ab"), 150000)} {
+		if compressed, _, err := compression.Compress(repeated, compression.Options{Algorithm: "flate"}); err != nil || len(compressed) > 600 {
+			t.Errorf("%d bytes of %s compressed to %d, %v", len(repeated), name, len(compressed), err)
+		}
+	}
+
+	// a few hundred KiB, so segments start with the window behind them
+	chunk := make([]byte, 4<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	var input []byte
+	for i := 0; len(input) < 300<<10; i++ {
+		input = append(append(input, chunk[:i%len(chunk)]...), data...)
+	}
+	for _, dictionary := range [][]byte{nil, data} {
+		// one matcher is reset for every segment
+		matchers := 0
+		newMatcher := func(distance, length int) lzss.Matcher {
+			matchers++
+			return lzss.NewHashChainMatcher(distance, length)
+		}
+		options := compression.Options{Algorithm: "flate", TinyInputSize: -1, Dictionary: dictionary, Matcher: newMatcher}
+		compressed, _, err := compression.Compress(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matchers != 1 {
+			t.Errorf("%d matchers made for %d segments", matchers, len(input)/(64<<10)+1)
+		}
+		if len(compressed) > len(input)/2 {
+			t.Errorf("compressed %d bytes to %d", len(input), len(compressed))
+		}
+		if decompressed, _, err := compression.Decompress(compressed, options); err != nil || !bytes.Equal(decompressed, input) {
+			t.Errorf("round trip failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !js
+
+package lzss
+
+import pb "github.com/cheggaaa/pb/v3"
+
+// startProgress shows a progress bar over total bytes on the terminal
+func startProgress(total int) progress {
+	bar := pb.New(total)
+	bar.Set(pb.Bytes, true)
+	bar.Start()
+	return progressBar{bar}
+}
+
+type progressBar struct {
+	bar *pb.ProgressBar
+}
+
+func (p progressBar) Increment() { p.bar.Increment() }
+func (p progressBar) Finish()    { p.bar.Finish() }
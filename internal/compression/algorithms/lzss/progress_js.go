@@ -0,0 +1,13 @@
+//go:build js
+
+package lzss
+
+// startProgress shows nothing, there is no terminal in the browser
+func startProgress(total int) progress {
+	return noProgress{}
+}
+
+type noProgress struct{}
+
+func (noProgress) Increment() {}
+func (noProgress) Finish()    {}
@@ -1,5 +1,7 @@
 package lzss
 
+import "unsafe"
+
 const (
 	Opening   = '<'
 	Closing   = '>'
@@ -8,10 +10,14 @@ const (
 )
 
 type Reference struct {
-	Value          []rune
+	Value          []byte
 	IsRef          bool
 	NegativeOffset int
 	Size           int
 }
 
-var conflictingLiterals = []rune{'<', '>', ',', '\\'}
\ No newline at end of file
+// ReferenceSize approximates what a match search holds per input position:
+// the Reference and the buffered channel it is sent through
+const ReferenceSize = int(unsafe.Sizeof(Reference{})) + 96
+
+var conflictingLiterals = []byte{Opening, Closing, Separator, Escape}
\ No newline at end of file
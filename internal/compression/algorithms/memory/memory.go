@@ -0,0 +1,63 @@
+// Package memory follows how many bytes the codec cores hold in their
+// buffers, so callers can see the peak of a run and tune the options against
+// their memory budget. It is bookkeeping at the points where the cores
+// allocate, not a measurement of the heap: maps, trees and goroutine stacks
+// are not counted.
+package memory
+
+// Buffer names one of the buffers a core holds
+type Buffer int
+
+const (
+	Input   Buffer = iota // the writes collected until Close
+	Copy                  // the collected input read out in one piece
+	Working               // tokens, match references and similar
+	Result                // the encoded or decoded data
+	Output                // what waits for the reader
+	buffers
+)
+
+// Usage is what a core held over its run
+type Usage struct {
+	PeakBytes   int64 // most bytes held in buffers at the same time
+	Allocations int64 // times a buffer was allocated or grown
+	WindowSize  int   // how far back references may reach, 0 if the codec has none
+}
+
+// Tracker adds up the sizes of a core's buffers. It does no locking, the
+// cores update it under their own lock.
+type Tracker struct {
+	sizes [buffers]int
+	held  int64
+	usage Usage
+}
+
+// NewTracker returns a tracker for a codec with the given window size
+func NewTracker(windowSize int) *Tracker {
+	return &Tracker{usage: Usage{WindowSize: windowSize}}
+}
+
+// Set records that buffer now holds size bytes. A buffer that grew counts as
+// an allocation, one that shrank or was dropped frees what it held.
+func (t *Tracker) Set(buffer Buffer, size int) {
+	if size > t.sizes[buffer] {
+		t.usage.Allocations++
+	}
+	t.held += int64(size - t.sizes[buffer])
+	t.sizes[buffer] = size
+	t.usage.PeakBytes = max(t.usage.PeakBytes, t.held)
+}
+
+// Usage returns what was recorded so far
+func (t *Tracker) Usage() Usage {
+	return t.usage
+}
+
+// Capacity returns the capacity of a buffer such as *bytes.Buffer, or 0 for
+// buffers that do not report one
+func Capacity(buffer any) int {
+	if b, ok := buffer.(interface{ Cap() int }); ok {
+		return b.Cap()
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+// Package profile counts what the deflate based cores made of their input:
+// how much of it went into literals and matches, and how many bits went to
+// code tables and headers rather than to the data. It explains a poor
+// ratio where the sizes alone do not.
+package profile
+
+// Profile is what a core wrote over its run
+type Profile struct {
+	Literals      int64 // bytes coded as literals
+	Matches       int64 // back references
+	MatchedBytes  int64 // bytes the matches stand for
+	DistanceTotal int64 // the distances of the matches added up
+	StoredBytes   int64 // bytes passed through in stored blocks
+
+	StoredBlocks  int64
+	FixedBlocks   int64
+	DynamicBlocks int64
+
+	TableBits  int64 // the code tables of dynamic blocks
+	HeaderBits int64 // block headers, end-of-block codes, padding, and container headers and trailers
+}
+
+// AddLiteral counts a literal
+func (p *Profile) AddLiteral() {
+	p.Literals++
+}
+
+// AddMatch counts a match of length bytes reaching distance back
+func (p *Profile) AddMatch(length, distance int) {
+	p.Matches++
+	p.MatchedBytes += int64(length)
+	p.DistanceTotal += int64(distance)
+}
+
+// AddHeaderBytes counts the header and trailer a container wraps the
+// blocks in
+func (p *Profile) AddHeaderBytes(n int) {
+	p.HeaderBits += 8 * int64(n)
+}
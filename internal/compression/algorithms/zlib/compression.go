@@ -0,0 +1,208 @@
+package zlib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/profile"
+)
+
+// CompressionCore wraps the deflate data of a flate writer in the zlib
+// format of RFC 1950: a two byte header and the Adler-32 of the input
+type CompressionCore struct {
+	lock        sync.Mutex
+	Writer      *io.PipeWriter
+	Reader      *io.PipeReader
+	FlateWriter io.WriteCloser
+	FlateReader io.ReadCloser
+	Adler       hash.Hash32
+	Dictionary  []byte     // preset dictionary, its Adler-32 follows the header
+	started     bool       // the header went out and the deflate data is copied after it
+	closed      bool       // Close was called
+	drained     chan error // what copying the deflate data ended with
+}
+
+type CompressionReader struct {
+	core *CompressionCore
+}
+
+type CompressionWriter struct {
+	core *CompressionCore
+}
+
+func NewCompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
+	newCompressionCore := new(CompressionCore)
+	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
+	newCompressionCore.FlateReader, newCompressionCore.FlateWriter = flateReader, flateWriter
+	newCompressionCore.Adler = checksum.NewAdler32()
+	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
+	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
+	return newCompressionReader, newCompressionWriter
+}
+
+// SetContext passes ctx on to the flate writer for the spans of its stages,
+// it has to be called before Write
+func (cw *CompressionWriter) SetContext(ctx context.Context) {
+	if setter, ok := cw.core.FlateWriter.(interface{ SetContext(context.Context) }); ok {
+		setter.SetContext(ctx)
+	}
+}
+
+// SetDictionary primes the flate writer with dict and marks the stream as
+// needing it, by FDICT and the Adler-32 of dict after the header. It has to
+// be called before Write.
+func (cw *CompressionWriter) SetDictionary(dict []byte) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.Dictionary = dict
+	if setter, ok := cw.core.FlateWriter.(interface{ SetDictionary([]byte) }); ok {
+		setter.SetDictionary(dict)
+	}
+}
+
+// MemoryUsage reports the buffers of the flate writer, the header and trailer
+// go straight into the pipe
+func (cw *CompressionWriter) MemoryUsage() memory.Usage {
+	if reporter, ok := cw.core.FlateWriter.(interface{ MemoryUsage() memory.Usage }); ok {
+		return reporter.MemoryUsage()
+	}
+	return memory.Usage{}
+}
+
+// Profile reports what the flate writer coded the input as, with the header,
+// the dictionary id and the trailer counted as header bytes
+func (cw *CompressionWriter) Profile() profile.Profile {
+	var p profile.Profile
+	if reporter, ok := cw.core.FlateWriter.(interface{ Profile() profile.Profile }); ok {
+		p = reporter.Profile()
+	}
+	p.AddHeaderBytes(len(header) + trailerSize)
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.Dictionary != nil {
+		p.AddHeaderBytes(dictIDSize)
+	}
+	return p
+}
+
+// header is CMF, deflate with a 32K window, and FLG, the default level and
+// no preset dictionary, chosen so that CMF*256+FLG is a multiple of 31
+var header = [2]byte{0x78, 0x9c}
+
+// dictHeader is header with FDICT set and FCHECK made up for it
+var dictHeader = [2]byte{0x78, 0xbb}
+
+// dictIDSize is the size of the big-endian Adler-32 of the dictionary that
+// follows the header when FDICT is set
+const dictIDSize = 4
+
+// Magic is the header everything compress writes starts with. Other encoders
+// pick other levels and window sizes, IsHeader recognises all of them.
+var Magic = header[:]
+
+// trailerSize is the size of the big-endian Adler-32 closing the stream
+const trailerSize = 4
+
+func (cw *CompressionWriter) Write(p []byte) (int, error) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	cw.core.Adler.Write(p)
+	cw.start()
+	var n int
+	err := guard(func() (err error) {
+		n, err = cw.core.FlateWriter.Write(p)
+		return err
+	})
+	return n, err
+}
+
+func (cw *CompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	if cw.core.closed {
+		cw.core.lock.Unlock()
+		return io.ErrClosedPipe
+	}
+	cw.core.closed = true
+	cw.start()
+	cw.core.lock.Unlock()
+	if err := cw.finish(); err != nil {
+		cw.core.Writer.CloseWithError(err)
+		return err
+	}
+	if err := cw.core.FlateReader.Close(); err != nil {
+		cw.core.Writer.CloseWithError(err)
+		return err
+	}
+	trailer := binary.BigEndian.AppendUint32(make([]byte, 0, trailerSize), cw.core.Adler.Sum32())
+	if _, err := cw.core.Writer.Write(trailer); err != nil {
+		return err
+	}
+	return cw.core.Writer.Close()
+}
+
+// start writes the header and copies the deflate data after it as flate
+// codes it, from the first Write on; flate blocks until its output is read.
+// The header goes out right before the deflate data so nothing can overtake
+// it. It is called with the lock held.
+func (cw *CompressionWriter) start() {
+	if cw.core.started {
+		return
+	}
+	cw.core.started = true
+	cw.core.drained = make(chan error, 1)
+	start := header[:]
+	if cw.core.Dictionary != nil {
+		start = binary.BigEndian.AppendUint32(dictHeader[:], checksum.SumAdler32(cw.core.Dictionary))
+	}
+	go func() {
+		_, err := cw.core.Writer.Write(start)
+		if err == nil {
+			_, err = io.Copy(cw.core.Writer, cw.core.FlateReader)
+		}
+		if err != nil {
+			// a flate Write or Close waiting for its output to be read fails
+			cw.core.FlateReader.Close()
+		}
+		cw.core.drained <- err
+	}()
+}
+
+// finish closes the flate writer and waits for its output to be copied.
+// When the copy failed first flate only finds its output closed, so the
+// copy's error is returned instead.
+func (cw *CompressionWriter) finish() error {
+	err := guard(cw.core.FlateWriter.Close)
+	if err != nil {
+		// the copy is stopped in case flate left its output open
+		cw.core.FlateReader.Close()
+	}
+	if drainErr := <-cw.core.drained; drainErr != nil && (err == nil || errors.Is(err, io.ErrClosedPipe)) {
+		err = drainErr
+	}
+	return err
+}
+
+// guard calls f, a panic in flate comes back as an error
+func guard(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("flate panicked: %v", r)
+		}
+	}()
+	return f()
+}
+
+func (cr *CompressionReader) Read(p []byte) (int, error) {
+	return cr.core.Reader.Read(p)
+}
+
+func (cr *CompressionReader) Close() error {
+	return cr.core.Reader.Close()
+}
@@ -0,0 +1,277 @@
+package zlib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+)
+
+// DecompressionCore strips the zlib header, passes the deflate data on to a
+// flate writer and checks what comes out of it against the Adler-32 trailer
+type DecompressionCore struct {
+	lock           sync.Mutex
+	Writer         *io.PipeWriter
+	Reader         *io.PipeReader
+	Header         []byte
+	IsHeaderParsed bool
+	Trailer        []byte
+	Adler          hash.Hash32
+	FlateWriter    io.WriteCloser
+	FlateReader    io.ReadCloser
+	started        bool       // the output of flate is being copied into the pipe
+	drained        chan error // what copying it ended with
+	Dictionary     []byte     // preset dictionary, nil if none was given
+}
+
+type DecompressionWriter struct {
+	core *DecompressionCore
+}
+
+type DecompressionReader struct {
+	core *DecompressionCore
+}
+
+func NewDecompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
+	newDecompressionCore := new(DecompressionCore)
+	newDecompressionCore.Reader, newDecompressionCore.Writer = io.Pipe()
+	newDecompressionCore.FlateReader, newDecompressionCore.FlateWriter = flateReader, flateWriter
+	newDecompressionCore.Adler = checksum.NewAdler32()
+	newDecompressionCore.Header = make([]byte, 0, len(header))
+	newDecompressionCore.Trailer = make([]byte, 0, trailerSize)
+	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
+	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
+	return newDecompressionReader, newDecompressionWriter
+}
+
+// SetContext passes ctx on to the flate writer for its inflate span, it has
+// to be called before Write
+func (dw *DecompressionWriter) SetContext(ctx context.Context) {
+	if setter, ok := dw.core.FlateWriter.(interface{ SetContext(context.Context) }); ok {
+		setter.SetContext(ctx)
+	}
+}
+
+// SetDictionary gives the preset dictionary streams with FDICT set need and
+// primes the flate writer with it, it has to be called before Write. Streams
+// without FDICT decode as they do without one.
+func (dw *DecompressionWriter) SetDictionary(dict []byte) {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	dw.core.Dictionary = dict
+	if setter, ok := dw.core.FlateWriter.(interface{ SetDictionary([]byte) }); ok {
+		setter.SetDictionary(dict)
+	}
+}
+
+// MemoryUsage reports the buffers of the flate writer
+func (dw *DecompressionWriter) MemoryUsage() memory.Usage {
+	if reporter, ok := dw.core.FlateWriter.(interface{ MemoryUsage() memory.Usage }); ok {
+		return reporter.MemoryUsage()
+	}
+	return memory.Usage{}
+}
+
+// flagDict is the FDICT bit of FLG, set when the stream needs a preset
+// dictionary
+const flagDict = 1 << 5
+
+// DictionaryError is returned for streams compressed with a preset
+// dictionary when it is not given or a different one is
+type DictionaryError struct {
+	ID    uint32 // Adler-32 of the dictionary the stream needs
+	Given bool
+}
+
+func (e *DictionaryError) Error() string {
+	if !e.Given {
+		return fmt.Sprintf("zlib stream needs the preset dictionary with the id %08x", e.ID)
+	}
+	return fmt.Sprintf("zlib stream needs the preset dictionary with the id %08x, not the one given", e.ID)
+}
+
+// IsHeader reports whether buf starts with a zlib header: deflate with a
+// window of at most 32K and a valid FCHECK. A raw
+// deflate stream cannot start like that unless its first block is a stored
+// block with nonzero padding bits, which no encoder writes.
+func IsHeader(buf []byte) bool {
+	return len(buf) >= len(header) && checkHeader(buf[0], buf[1]) == nil
+}
+
+func checkHeader(cmf, flg byte) error {
+	if cmf&0x0f != 8 {
+		return fmt.Errorf("unsupported zlib compression method %v", cmf&0x0f)
+	}
+	if cmf>>4 > 7 {
+		return fmt.Errorf("invalid zlib window size %v", cmf>>4)
+	}
+	if (uint16(cmf)<<8|uint16(flg))%31 != 0 {
+		return errors.New("zlib header check failed")
+	}
+	return nil
+}
+
+// headerSize is the size of the header in h so far, 2 bytes and the
+// dictionary id if FDICT is set
+func headerSize(h []byte) int {
+	if len(h) >= len(header) && h[1]&flagDict != 0 {
+		return len(header) + dictIDSize
+	}
+	return len(header)
+}
+
+// Write strips the header and passes the deflate data on to flate. The last
+// trailerSize bytes seen so far are held back since they may turn out to be
+// the trailer.
+func (dw *DecompressionWriter) Write(p []byte) (int, error) {
+	dw.core.lock.Lock()
+	defer dw.core.lock.Unlock()
+	n := len(p)
+	if !dw.core.IsHeaderParsed {
+		for len(dw.core.Header) < headerSize(dw.core.Header) && len(p) > 0 {
+			missing := min(headerSize(dw.core.Header)-len(dw.core.Header), len(p))
+			dw.core.Header, p = append(dw.core.Header, p[:missing]...), p[missing:]
+		}
+		if len(dw.core.Header) < headerSize(dw.core.Header) {
+			return n, nil
+		}
+		if err := dw.core.checkHeader(); err != nil {
+			return 0, err
+		}
+		dw.core.IsHeaderParsed = true
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	pending := append(dw.core.Trailer, p...)
+	if len(pending) <= trailerSize {
+		dw.core.Trailer = pending
+		return n, nil
+	}
+	dw.core.Trailer = append(make([]byte, 0, trailerSize), pending[len(pending)-trailerSize:]...)
+	dw.start()
+	err := guard(func() error {
+		_, err := dw.core.FlateWriter.Write(pending[:len(pending)-trailerSize])
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// start copies what flate decodes into the pipe as it comes out, from the
+// first deflate data on, and checks it against the trailer on its way. The
+// lock has to be held.
+func (dw *DecompressionWriter) start() {
+	if dw.core.started {
+		return
+	}
+	dw.core.started = true
+	dw.core.drained = make(chan error, 1)
+	go func() {
+		output := io.TeeReader(dw.core.FlateReader, dw.core.Adler)
+		_, err := io.Copy(dw.core.Writer, output)
+		if err != nil {
+			// the reader is gone, a flate writer blocked on its pipe has to give up
+			dw.core.FlateReader.Close()
+		}
+		dw.core.drained <- err
+	}()
+}
+
+func (dw *DecompressionWriter) Close() error {
+	dw.core.lock.Lock()
+	headerComplete := dw.core.IsHeaderParsed
+	if headerComplete {
+		dw.start()
+	}
+	dw.core.lock.Unlock()
+	if !headerComplete {
+		err := fmt.Errorf("zlib header is truncated: %w", io.ErrUnexpectedEOF)
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+
+	// no lock here: flate decodes the rest while its output is copied, which
+	// blocks until the reader drains it, and the reader has to be able to
+	// close in the meantime
+	if err := dw.finishFlate(); err != nil {
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+	if err := dw.core.FlateReader.Close(); err != nil {
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+
+	// the trailer follows the final block, unlike gzip nothing may come after it
+	rest := dw.core.Trailer
+	if unused, ok := dw.core.FlateWriter.(interface{ Unused() []byte }); ok && len(unused.Unused()) > 0 {
+		rest = append(append([]byte{}, unused.Unused()...), dw.core.Trailer...)
+	}
+	if err := dw.core.checkTrailer(rest); err != nil {
+		dw.core.Writer.CloseWithError(err)
+		return err
+	}
+	return dw.core.Writer.Close()
+}
+
+// finishFlate closes the flate writer and waits until its output is copied.
+// A reader that went away fails flate with io.ErrClosedPipe, the error of
+// the copy tells more then.
+func (dw *DecompressionWriter) finishFlate() error {
+	err := guard(dw.core.FlateWriter.Close)
+	if err != nil {
+		// a flate writer that panicked left its pipe open
+		dw.core.FlateReader.Close()
+	}
+	if drainErr := <-dw.core.drained; drainErr != nil && (err == nil || errors.Is(err, io.ErrClosedPipe)) {
+		err = drainErr
+	}
+	return err
+}
+
+// checkHeader checks the complete header, and that the dictionary it names
+// is the one given
+func (core *DecompressionCore) checkHeader() error {
+	if err := checkHeader(core.Header[0], core.Header[1]); err != nil {
+		return err
+	}
+	if core.Header[1]&flagDict == 0 {
+		return nil
+	}
+	id := binary.BigEndian.Uint32(core.Header[len(header):])
+	if core.Dictionary == nil || checksum.SumAdler32(core.Dictionary) != id {
+		return &DictionaryError{ID: id, Given: core.Dictionary != nil}
+	}
+	return nil
+}
+
+func (core *DecompressionCore) checkTrailer(buf []byte) error {
+	if len(buf) < trailerSize {
+		return fmt.Errorf("zlib trailer is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	if len(buf) > trailerSize {
+		return errors.New("zlib stream continues past its trailer")
+	}
+	if binary.BigEndian.Uint32(buf) != core.Adler.Sum32() {
+		return errors.New("adler-32 did not match")
+	}
+	return nil
+}
+
+func (dr *DecompressionReader) Read(p []byte) (int, error) {
+	return dr.core.Reader.Read(p)
+}
+
+func (dr *DecompressionReader) Close() error {
+	// closing the pipe releases a writer that is still copying into it
+	return dr.core.Reader.Close()
+}
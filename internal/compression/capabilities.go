@@ -0,0 +1,132 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
+)
+
+// Capabilities describes what an algorithm's output looks like and what
+// input it can be trusted with
+type Capabilities struct {
+	Format        string `json:"format"`
+	BinarySafe    bool   `json:"binary_safe"`   // round-trips arbitrary byte sequences
+	Checksum      bool   `json:"checksum"`      // the output carries an integrity check
+	Interoperable bool   `json:"interoperable"` // standard tools can read the output
+	Verified      bool   `json:"verified"`      // passed the binary round-trip self-test
+	VerifyError   string `json:"verify_error,omitempty"`
+}
+
+var (
+	verifyOnce     sync.Once
+	verifiedMatrix map[string]Capabilities
+)
+
+// binarySamples returns the inputs every algorithm has to reproduce exactly
+// before it is advertised: random bytes, every byte value, long runs of NUL,
+// structured little-endian records and invalid UTF-8.
+func binarySamples() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	rnd.Read(random)
+
+	allBytes := make([]byte, 0, 512)
+	for i := range 256 {
+		allBytes = append(allBytes, byte(i))
+	}
+	for i := 255; i >= 0; i-- {
+		allBytes = append(allBytes, byte(i))
+	}
+
+	var records []byte
+	for i := range 512 {
+		records = binary.LittleEndian.AppendUint32(records, uint32(i*i))
+		records = binary.LittleEndian.AppendUint16(records, uint16(i))
+		records = append(records, 0, 0xff)
+	}
+
+	invalidUTF8 := bytes.Repeat([]byte{0xc3, 0x28, 0xa0, 0xa1, 0xe2, 0x28, 0xa1, 0xf0, 0x28, 0x8c, 0xbc, '\n', '|', '\\'}, 64)
+
+	return map[string][]byte{
+		"random":       random,
+		"all bytes":    allBytes,
+		"zeros":        make([]byte, 2048),
+		"records":      records,
+		"invalid utf8": invalidUTF8,
+	}
+}
+
+// verifyAlgorithm round-trips every binary sample through the algorithm
+func verifyAlgorithm(algorithm string) error {
+	options := Options{Algorithm: algorithm}
+	for name, sample := range binarySamples() {
+		compressed, _, err := Compress(sample, options)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		decompressed, _, err := Decompress(compressed, options)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !bytes.Equal(decompressed, sample) {
+			return fmt.Errorf("%s: decompressed output does not match the input", name)
+		}
+	}
+	return nil
+}
+
+// VerifyBinarySupport runs the binary round-trip self-test for every algorithm
+// once and returns the resulting capability matrix. Algorithms that fail are
+// kept in the matrix with the error but are no longer advertised.
+func VerifyBinarySupport() map[string]Capabilities {
+	verifyOnce.Do(func() {
+		verifiedMatrix = make(map[string]Capabilities, len(registry))
+		for _, entry := range registry {
+			capabilities := entry.Capabilities
+			if capabilities.BinarySafe {
+				if err := verifyAlgorithm(entry.Name); err != nil {
+					capabilities.VerifyError = err.Error()
+				} else {
+					capabilities.Verified = true
+				}
+			}
+			verifiedMatrix[entry.Name] = capabilities
+		}
+	})
+	return verifiedMatrix
+}
+
+// GetCapabilities returns the verified capabilities of an algorithm
+func GetCapabilities(algorithm string) (Capabilities, bool) {
+	capabilities, ok := VerifyBinarySupport()[algorithm]
+	return capabilities, ok
+}
+
+// GetAdvertisedAlgorithms returns the supported algorithms that passed the
+// binary self-test, in the order of SupportedAlgorithms
+func GetAdvertisedAlgorithms() []string {
+	matrix := VerifyBinarySupport()
+	var advertised []string
+	for _, algorithm := range SupportedAlgorithms {
+		if matrix[algorithm].Verified {
+			advertised = append(advertised, algorithm)
+		}
+	}
+	return advertised
+}
+
+// Warm does the one-time work the codecs otherwise do while the first
+// requests wait: it builds the fixed Huffman trees and the length and
+// distance lookups of flate, and runs the binary self-test, which takes every
+// algorithm through a round-trip. It returns the capabilities the self-test
+// found, calling it again returns them at once.
+func Warm() (map[string]Capabilities, error) {
+	if err := flate.Warm(); err != nil {
+		return nil, fmt.Errorf("failed to build the flate tables: %w", err)
+	}
+	return VerifyBinarySupport(), nil
+}
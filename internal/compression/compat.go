@@ -0,0 +1,154 @@
+package compression
+
+import (
+	"bytes"
+	stdflate "compress/flate"
+	stdgzip "compress/gzip"
+	stdzlib "compress/zlib"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// CompatResult is the outcome of decoding one stream produced by the
+// standard library
+type CompatResult struct {
+	Algorithm string `json:"algorithm"`
+	Sample    string `json:"sample"`
+	Level     int    `json:"level"`
+	Error     string `json:"error,omitempty"`
+}
+
+// compatLevels are all the compress/flate levels, each of them picks stored,
+// fixed and dynamic blocks differently
+var compatLevels = []int{
+	stdflate.HuffmanOnly, stdflate.NoCompression, stdflate.BestSpeed,
+	2, 3, 4, 5, 6, 7, 8, stdflate.BestCompression,
+}
+
+// compatSamples returns inputs that make compress/flate emit every kind of
+// block: tiny inputs end up in fixed blocks, incompressible ones in stored
+// blocks, and long ones are split into several blocks with matches reaching
+// back the full 32KB window
+func compatSamples() map[string][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100*1024)
+	rnd.Read(random)
+
+	window := make([]byte, 32768)
+	rnd.Read(window)
+	farMatches := append(append([]byte{}, window...), window...)
+
+	words := []string{"compress", "decompress", "stream", "block", "window", "huffman", "\n", " ", ", "}
+	var text strings.Builder
+	for text.Len() < 300*1024 {
+		text.WriteString(words[rnd.Intn(len(words))])
+	}
+
+	return map[string][]byte{
+		"empty":       {},
+		"one byte":    {'x'},
+		"short text":  []byte("hello, hello, hello world"),
+		"random":      random,
+		"far matches": farMatches,
+		"long text":   []byte(text.String()),
+		"zeros":       make([]byte, 200*1024),
+	}
+}
+
+// stdlibFlate compresses data with compress/flate at the given level
+func stdlibFlate(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := stdflate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	// a flush in between puts an empty stored block into the stream
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stdlibGzip compresses data with compress/gzip at the given level, filling in
+// every optional header field
+func stdlibGzip(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := stdgzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	writer.Name = "sample.txt"
+	writer.Comment = "written by compress/gzip"
+	writer.Extra = []byte("AB\x02\x00ok")
+	writer.ModTime = time.Unix(1700000000, 0)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// stdlibZlib compresses data with compress/zlib at the given level
+func stdlibZlib(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := stdzlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyCompat compresses a set of samples with compress/flate,
+// compress/gzip and compress/zlib at every level and checks that the flate,
+// gzip and zlib decoders reproduce them. It returns one result per stream, failures carry an error.
+func VerifyCompat() []CompatResult {
+	encoders := map[string]func([]byte, int) ([]byte, error){
+		"flate": stdlibFlate,
+		"gzip":  stdlibGzip,
+		"zlib":  stdlibZlib,
+	}
+	var results []CompatResult
+	for _, algorithm := range []string{"flate", "gzip", "zlib"} {
+		for name, sample := range compatSamples() {
+			for _, level := range compatLevels {
+				result := CompatResult{Algorithm: algorithm, Sample: name, Level: level}
+				if err := verifyCompatStream(algorithm, encoders[algorithm], sample, level); err != nil {
+					result.Error = err.Error()
+				}
+				results = append(results, result)
+			}
+		}
+	}
+	return results
+}
+
+func verifyCompatStream(algorithm string, encode func([]byte, int) ([]byte, error), sample []byte, level int) error {
+	compressed, err := encode(sample, level)
+	if err != nil {
+		return fmt.Errorf("standard library failed to compress: %w", err)
+	}
+	decompressed, _, err := Decompress(compressed, Options{Algorithm: algorithm})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(decompressed, sample) {
+		return fmt.Errorf("decoded %d bytes that do not match the %d bytes of input", len(decompressed), len(sample))
+	}
+	return nil
+}
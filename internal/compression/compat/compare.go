@@ -0,0 +1,153 @@
+package compat
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The structures a Divergence points at
+const (
+	StructBlockHeader   = "block header"
+	StructCodeLengths   = "code-length table"
+	StructLitLenTable   = "literal/length table"
+	StructDistanceTable = "distance table"
+	StructTokens        = "tokens"
+	StructDistanceCodes = "distance codes"
+	StructBlocks        = "blocks"
+	StructGzipHeader    = "gzip header"
+	StructTrailer       = "trailer"
+	StructMembers       = "members"
+	StructEncoding      = "encoding"
+)
+
+// Divergence is the first structure in which an encoding differs from the
+// reference. Block is -1 for the structures outside of deflate blocks.
+type Divergence struct {
+	Member    int
+	Block     int
+	Structure string
+	Detail    string
+}
+
+func (d *Divergence) Error() string {
+	where := ""
+	if d.Member > 0 {
+		where = fmt.Sprintf("member %d, ", d.Member)
+	}
+	if d.Block >= 0 {
+		where += fmt.Sprintf("block %d, ", d.Block)
+	}
+	return fmt.Sprintf("%s%s: %s", where, d.Structure, d.Detail)
+}
+
+// CompareDeflate returns where got first differs from want, block by block
+// and in every block from the header through the code tables to the tokens,
+// or nil when they have the same structure
+func CompareDeflate(got, want *Stream) *Divergence {
+	for i := range min(len(got.Blocks), len(want.Blocks)) {
+		if d := compareBlock(&got.Blocks[i], &want.Blocks[i]); d != nil {
+			d.Block = i
+			return d
+		}
+	}
+	if len(got.Blocks) != len(want.Blocks) {
+		return &Divergence{Block: -1, Structure: StructBlocks, Detail: fmt.Sprintf("%d blocks, want %d", len(got.Blocks), len(want.Blocks))}
+	}
+	return nil
+}
+
+func compareBlock(got, want *Block) *Divergence {
+	if got.Final != want.Final || got.Type != want.Type {
+		return &Divergence{Structure: StructBlockHeader, Detail: fmt.Sprintf("BFINAL %t and BTYPE %d, want BFINAL %t and BTYPE %d", got.Final, got.Type, want.Final, want.Type)}
+	}
+	if got.Type == 2 {
+		if got.HCLEN != want.HCLEN {
+			return &Divergence{Structure: StructCodeLengths, Detail: fmt.Sprintf("HCLEN %d, want %d", got.HCLEN, want.HCLEN)}
+		}
+		if detail := compareLengths(got.CodeLengthLengths, want.CodeLengthLengths); detail != "" {
+			return &Divergence{Structure: StructCodeLengths, Detail: detail}
+		}
+		if got.HLIT != want.HLIT {
+			return &Divergence{Structure: StructLitLenTable, Detail: fmt.Sprintf("HLIT %d, want %d", got.HLIT, want.HLIT)}
+		}
+		if detail := compareLengths(got.LitLenLengths, want.LitLenLengths); detail != "" {
+			return &Divergence{Structure: StructLitLenTable, Detail: detail}
+		}
+		if got.HDIST != want.HDIST {
+			return &Divergence{Structure: StructDistanceTable, Detail: fmt.Sprintf("HDIST %d, want %d", got.HDIST, want.HDIST)}
+		}
+		if detail := compareLengths(got.DistLengths, want.DistLengths); detail != "" {
+			return &Divergence{Structure: StructDistanceTable, Detail: detail}
+		}
+	}
+	for i := range min(len(got.Tokens), len(want.Tokens)) {
+		g, w := got.Tokens[i], want.Tokens[i]
+		if g == w {
+			continue
+		}
+		structure := StructTokens
+		if g.Length != 0 && g.Length == w.Length {
+			structure = StructDistanceCodes
+		}
+		return &Divergence{Structure: structure, Detail: fmt.Sprintf("token %d is a %v, want a %v", i, g, w)}
+	}
+	if len(got.Tokens) != len(want.Tokens) {
+		return &Divergence{Structure: StructTokens, Detail: fmt.Sprintf("%d tokens, want %d", len(got.Tokens), len(want.Tokens))}
+	}
+	return nil
+}
+
+// compareLengths describes the first symbol whose code length differs
+func compareLengths(got, want []int) string {
+	for symbol := range min(len(got), len(want)) {
+		if got[symbol] != want[symbol] {
+			return fmt.Sprintf("symbol %d has a %d-bit code, want %d", symbol, got[symbol], want[symbol])
+		}
+	}
+	if len(got) != len(want) {
+		return fmt.Sprintf("%d code lengths, want %d", len(got), len(want))
+	}
+	return ""
+}
+
+// CompareGzip returns where got first differs from want: in the header, the
+// deflate stream or the trailer of a member, or in the number of members
+func CompareGzip(got, want []Member) *Divergence {
+	for i := range min(len(got), len(want)) {
+		g, w := &got[i], &want[i]
+		if detail := compareHeaders(g, w); detail != "" {
+			return &Divergence{Member: i, Block: -1, Structure: StructGzipHeader, Detail: detail}
+		}
+		if d := CompareDeflate(g.Deflate, w.Deflate); d != nil {
+			d.Member = i
+			return d
+		}
+		if g.CRC32 != w.CRC32 || g.Size != w.Size {
+			return &Divergence{Member: i, Block: -1, Structure: StructTrailer, Detail: fmt.Sprintf("CRC-32 %08x and ISIZE %d, want %08x and %d", g.CRC32, g.Size, w.CRC32, w.Size)}
+		}
+	}
+	if len(got) != len(want) {
+		return &Divergence{Block: -1, Structure: StructMembers, Detail: fmt.Sprintf("%d members, want %d", len(got), len(want))}
+	}
+	return nil
+}
+
+func compareHeaders(got, want *Member) string {
+	switch {
+	case got.Flags != want.Flags:
+		return fmt.Sprintf("FLG %#02x, want %#02x", got.Flags, want.Flags)
+	case got.ModTime != want.ModTime:
+		return fmt.Sprintf("MTIME %d, want %d", got.ModTime, want.ModTime)
+	case got.ExtraFlags != want.ExtraFlags:
+		return fmt.Sprintf("XFL %d, want %d", got.ExtraFlags, want.ExtraFlags)
+	case got.OS != want.OS:
+		return fmt.Sprintf("OS %d, want %d", got.OS, want.OS)
+	case !bytes.Equal(got.Extra, want.Extra):
+		return fmt.Sprintf("FEXTRA %x, want %x", got.Extra, want.Extra)
+	case got.Name != want.Name:
+		return fmt.Sprintf("FNAME %q, want %q", got.Name, want.Name)
+	case got.Comment != want.Comment:
+		return fmt.Sprintf("FCOMMENT %q, want %q", got.Comment, want.Comment)
+	}
+	return ""
+}
@@ -0,0 +1,176 @@
+// Package compat checks deflate and gzip codecs against reference vectors:
+// streams built after RFC 1951 and RFC 1952 and encodings zlib produced,
+// embedded in vectors.json. Every vector is decoded with the codec, and the
+// canonical ones, whose encoding is the only sensible one for their input,
+// are encoded again and compared structure by structure, so a difference is
+// reported as the code-length table, distance code or trailer it is in
+// rather than as a byte offset.
+package compat
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Formats of the vectors
+const (
+	FormatDeflate = "deflate" // raw RFC 1951 stream
+	FormatGzip    = "gzip"    // RFC 1952 members
+)
+
+// Vector is an input with an encoding of it known to be right
+type Vector struct {
+	Name    string
+	Format  string
+	Input   []byte
+	Encoded []byte
+
+	// Encoding is the block type the vector is written with, "stored",
+	// "fixed" or "dynamic", for the encoder to use on canonical vectors
+	Encoding string
+	// Canonical vectors are expected back bit for bit from an encoder
+	// writing Encoding blocks: stored blocks, fixed Huffman literals and
+	// the gzip header and trailer leave no choice
+	Canonical bool
+	// Source says where the encoding comes from and what it covers
+	Source string
+}
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// Vectors returns the embedded reference vectors, each checked to decode
+// to its input with ParseDeflate or ParseGzip
+func Vectors() ([]Vector, error) {
+	var entries []struct {
+		Name      string `json:"name"`
+		Format    string `json:"format"`
+		Input     string `json:"input"`
+		InputHex  string `json:"input_hex"`
+		Encoded   string `json:"encoded"`
+		Encoding  string `json:"encoding"`
+		Canonical bool   `json:"canonical"`
+		Source    string `json:"source"`
+	}
+	if err := json.Unmarshal(vectorsJSON, &entries); err != nil {
+		return nil, err
+	}
+	vectors := make([]Vector, 0, len(entries))
+	for _, entry := range entries {
+		vector := Vector{
+			Name:      entry.Name,
+			Format:    entry.Format,
+			Input:     []byte(entry.Input),
+			Encoding:  entry.Encoding,
+			Canonical: entry.Canonical,
+			Source:    entry.Source,
+		}
+		var err error
+		if entry.InputHex != "" {
+			if vector.Input, err = hex.DecodeString(entry.InputHex); err != nil {
+				return nil, fmt.Errorf("vector %s: %w", entry.Name, err)
+			}
+		}
+		if vector.Encoded, err = hex.DecodeString(entry.Encoded); err != nil {
+			return nil, fmt.Errorf("vector %s: %w", entry.Name, err)
+		}
+		output, err := parse(vector.Format, vector.Encoded)
+		if err != nil {
+			return nil, fmt.Errorf("vector %s: %w", entry.Name, err)
+		}
+		if !bytes.Equal(output, vector.Input) {
+			return nil, fmt.Errorf("vector %s does not decode to its input", entry.Name)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+// parse decodes data in format with the reference parsers
+func parse(format string, data []byte) ([]byte, error) {
+	switch format {
+	case FormatDeflate:
+		stream, err := ParseDeflate(data)
+		if err != nil {
+			return nil, err
+		}
+		if stream.Size != len(data) {
+			return nil, fmt.Errorf("%d bytes follow the final block", len(data)-stream.Size)
+		}
+		return stream.Output, nil
+	case FormatGzip:
+		members, err := ParseGzip(data)
+		if err != nil {
+			return nil, err
+		}
+		var output []byte
+		for _, member := range members {
+			output = append(output, member.Deflate.Output...)
+		}
+		return output, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Codec is the implementation Check runs the vectors through
+type Codec struct {
+	Decode func(v Vector) ([]byte, error)
+	// Encode encodes v.Input in v.Format with v.Encoding blocks, it is only
+	// called for canonical vectors and may be nil to check decoding alone
+	Encode func(v Vector) ([]byte, error)
+}
+
+// Check decodes the encoding of v with codec and, for a canonical vector,
+// encodes its input again. A decoding that differs from the input is an
+// error naming the first byte that does, an encoding that differs from the
+// reference is a *Divergence.
+func Check(v Vector, codec Codec) error {
+	decoded, err := codec.Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding failed: %w", err)
+	}
+	if !bytes.Equal(decoded, v.Input) {
+		at := 0
+		for at < min(len(decoded), len(v.Input)) && decoded[at] == v.Input[at] {
+			at++
+		}
+		return fmt.Errorf("decoded %d bytes that differ from the %d of the input from byte %d on", len(decoded), len(v.Input), at)
+	}
+	if !v.Canonical || codec.Encode == nil {
+		return nil
+	}
+
+	encoded, err := codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("encoding failed: %w", err)
+	}
+	if bytes.Equal(encoded, v.Encoded) {
+		return nil
+	}
+	var d *Divergence
+	switch v.Format {
+	case FormatDeflate:
+		got, err := ParseDeflate(encoded)
+		if err != nil {
+			return fmt.Errorf("the encoding does not parse: %w", err)
+		}
+		want, _ := ParseDeflate(v.Encoded)
+		d = CompareDeflate(got, want)
+	case FormatGzip:
+		got, err := ParseGzip(encoded)
+		if err != nil {
+			return fmt.Errorf("the encoding does not parse: %w", err)
+		}
+		want, _ := ParseGzip(v.Encoded)
+		d = CompareGzip(got, want)
+	}
+	if d == nil {
+		// same structures, the padding bits or what follows the end differ
+		d = &Divergence{Block: -1, Structure: StructEncoding, Detail: fmt.Sprintf("%d bytes, want %d, with the same structure", len(encoded), len(v.Encoded))}
+	}
+	return d
+}
@@ -0,0 +1,111 @@
+package compat
+
+import (
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// algorithms are the algorithms of package compression for each format
+var algorithms = map[string]string{FormatDeflate: "flate", FormatGzip: "gzip"}
+
+// codec runs the vectors through the codecs of package compression
+var codec = Codec{
+	Decode: func(v Vector) ([]byte, error) {
+		data, _, err := compression.Decompress(v.Encoded, compression.Options{Algorithm: algorithms[v.Format]})
+		return data, err
+	},
+	Encode: func(v Vector) ([]byte, error) {
+		options := compression.Options{Algorithm: algorithms[v.Format]}
+		switch v.Encoding {
+		case "stored":
+			options.Store = true
+		case "fixed":
+			options.BType = 1
+		case "dynamic":
+			options.BType = 2
+		}
+		data, _, err := compression.Compress(v.Input, options)
+		return data, err
+	},
+}
+
+func TestVectors(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		if err := Check(v, codec); err != nil {
+			t.Errorf("%s: %v", v.Name, err)
+		}
+	}
+}
+
+// TestCompare changes one structure of a parsed reference at a time and
+// checks that the comparison points at it
+func TestCompare(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]Vector)
+	for _, v := range vectors {
+		byName[v.Name] = v
+	}
+
+	deflateTests := []struct {
+		vector    string
+		change    func(s *Stream)
+		structure string
+	}{
+		{"dynamic", func(s *Stream) { s.Blocks[0].Type = 1 }, StructBlockHeader},
+		{"dynamic", func(s *Stream) { s.Blocks[0].CodeLengthLengths[18]++ }, StructCodeLengths},
+		{"dynamic", func(s *Stream) { s.Blocks[0].LitLenLengths['e']++ }, StructLitLenTable},
+		{"dynamic", func(s *Stream) { s.Blocks[0].HDIST-- }, StructDistanceTable},
+		{"fixed-matches", func(s *Stream) { lastMatch(s).Distance++ }, StructDistanceCodes},
+		{"fixed-matches", func(s *Stream) { lastMatch(s).Length++ }, StructTokens},
+		{"sync-flush", func(s *Stream) { s.Blocks = s.Blocks[:2] }, StructBlocks},
+	}
+	for _, test := range deflateTests {
+		want, _ := ParseDeflate(byName[test.vector].Encoded)
+		got, _ := ParseDeflate(byName[test.vector].Encoded)
+		if d := CompareDeflate(got, want); d != nil {
+			t.Fatalf("%s differs from itself: %v", test.vector, d)
+		}
+		test.change(got)
+		if d := CompareDeflate(got, want); d == nil || d.Structure != test.structure {
+			t.Errorf("%s changed in the %s: compared as %v", test.vector, test.structure, d)
+		}
+	}
+
+	gzipTests := []struct {
+		change    func(m []Member) []Member
+		structure string
+	}{
+		{func(m []Member) []Member { m[1].OS = 3; return m }, StructGzipHeader},
+		{func(m []Member) []Member { m[1].Deflate.Blocks[0].Final = false; return m }, StructBlockHeader},
+		{func(m []Member) []Member { m[0].CRC32++; return m }, StructTrailer},
+		{func(m []Member) []Member { return m[:1] }, StructMembers},
+	}
+	for _, test := range gzipTests {
+		want, _ := ParseGzip(byName["gzip-members"].Encoded)
+		got, _ := ParseGzip(byName["gzip-members"].Encoded)
+		if d := CompareGzip(test.change(got), want); d == nil || d.Structure != test.structure {
+			t.Errorf("gzip-members changed in the %s: compared as %v", test.structure, d)
+		}
+	}
+}
+
+// lastMatch is the last match token of a stream
+func lastMatch(s *Stream) *Token {
+	for i := len(s.Blocks) - 1; i >= 0; i-- {
+		tokens := s.Blocks[i].Tokens
+		for j := len(tokens) - 1; j >= 0; j-- {
+			if tokens[j].Length != 0 {
+				return &tokens[j]
+			}
+		}
+	}
+	return nil
+}
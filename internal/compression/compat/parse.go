@@ -0,0 +1,461 @@
+package compat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Block is a deflate block as its bits spell it out
+type Block struct {
+	Offset int64 // bit offset of the block header in the stream
+	Final  bool
+	Type   int // BTYPE: 0 stored, 1 fixed Huffman, 2 dynamic Huffman
+
+	// The code tables of a dynamic block, lengths by symbol with 0 for
+	// symbols without a code. CodeLengthLengths has all 19 symbols of the
+	// code length alphabet, HCLEN says how many of them were sent.
+	HLIT, HDIST, HCLEN int
+	CodeLengthLengths  []int
+	LitLenLengths      []int
+	DistLengths        []int
+
+	Tokens []Token // the end of block is left out
+}
+
+// Token is a literal, or a match when Length is set
+type Token struct {
+	Literal  byte
+	Length   int
+	Distance int
+}
+
+func (t Token) String() string {
+	if t.Length == 0 {
+		return fmt.Sprintf("literal %q", t.Literal)
+	}
+	return fmt.Sprintf("match of length %d at distance %d", t.Length, t.Distance)
+}
+
+// Stream is a deflate stream parsed into its blocks
+type Stream struct {
+	Blocks []Block
+	Output []byte
+	Size   int // bytes the stream took up, up to the end of its final block
+}
+
+// Member is a gzip member parsed into its header, stream and trailer
+type Member struct {
+	Flags      byte
+	ModTime    uint32
+	ExtraFlags byte
+	OS         byte
+	Extra      []byte // FEXTRA, nil without
+	Name       string // FNAME
+	Comment    string // FCOMMENT
+	HeaderCRC  int    // FHCRC, -1 without
+
+	Deflate *Stream
+	CRC32   uint32 // the trailer as written, checked against the output
+	Size    uint32
+}
+
+// gzip header flags, RFC 1952 section 2.3.1
+const (
+	flagText    = 1 << 0
+	flagHCRC    = 1 << 1
+	flagExtra   = 1 << 2
+	flagName    = 1 << 3
+	flagComment = 1 << 4
+)
+
+// The base values and extra bits of the length and distance codes, RFC 1951
+// section 3.2.5
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]int{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// codeLengthOrder is the order the code length code lengths are sent in,
+// RFC 1951 section 3.2.7
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// ParseDeflate decodes the deflate stream at the start of data, keeping the
+// structure of every block. It is written after RFC 1951 alone and shares
+// no code with the flate package, so it can tell that package wrong.
+func ParseDeflate(data []byte) (*Stream, error) {
+	r := &bitReader{data: data}
+	stream := &Stream{}
+	for {
+		block, err := parseBlock(r, &stream.Output)
+		if err != nil {
+			return stream, fmt.Errorf("block %d: %w", len(stream.Blocks), err)
+		}
+		stream.Blocks = append(stream.Blocks, *block)
+		if block.Final {
+			break
+		}
+	}
+	r.align()
+	stream.Size = int(r.pos / 8)
+	return stream, nil
+}
+
+// ParseGzip decodes every gzip member of data, RFC 1952. A trailer that does
+// not match the output is an error.
+func ParseGzip(data []byte) ([]Member, error) {
+	var members []Member
+	for len(data) > 0 {
+		member, n, err := parseMember(data)
+		if err != nil {
+			return members, fmt.Errorf("member %d: %w", len(members), err)
+		}
+		members = append(members, *member)
+		data = data[n:]
+	}
+	if len(members) == 0 {
+		return nil, errors.New("no gzip member")
+	}
+	return members, nil
+}
+
+func parseMember(data []byte) (*Member, int, error) {
+	if len(data) < 10 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if data[0] != 0x1f || data[1] != 0x8b || data[2] != 8 {
+		return nil, 0, errors.New("not a gzip member with the deflate method")
+	}
+	member := &Member{
+		Flags:      data[3],
+		ModTime:    binary.LittleEndian.Uint32(data[4:8]),
+		ExtraFlags: data[8],
+		OS:         data[9],
+		HeaderCRC:  -1,
+	}
+	n := 10
+	if member.Flags&flagExtra != 0 {
+		if len(data) < n+2 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		size := int(binary.LittleEndian.Uint16(data[n:]))
+		n += 2
+		if len(data) < n+size {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		member.Extra = data[n : n+size]
+		n += size
+	}
+	for _, field := range []struct {
+		flag byte
+		dst  *string
+	}{{flagName, &member.Name}, {flagComment, &member.Comment}} {
+		if member.Flags&field.flag == 0 {
+			continue
+		}
+		end := n
+		for end < len(data) && data[end] != 0 {
+			end++
+		}
+		if end == len(data) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		*field.dst = string(data[n:end])
+		n = end + 1
+	}
+	if member.Flags&flagHCRC != 0 {
+		if len(data) < n+2 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		member.HeaderCRC = int(binary.LittleEndian.Uint16(data[n:]))
+		if want := int(crc32.ChecksumIEEE(data[:n]) & 0xffff); member.HeaderCRC != want {
+			return nil, 0, fmt.Errorf("header CRC %04x, the header sums to %04x", member.HeaderCRC, want)
+		}
+		n += 2
+	}
+
+	stream, err := ParseDeflate(data[n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	member.Deflate = stream
+	n += stream.Size
+	if len(data) < n+8 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	member.CRC32 = binary.LittleEndian.Uint32(data[n:])
+	member.Size = binary.LittleEndian.Uint32(data[n+4:])
+	if sum := crc32.ChecksumIEEE(stream.Output); member.CRC32 != sum {
+		return nil, 0, fmt.Errorf("trailer CRC-32 %08x, the output sums to %08x", member.CRC32, sum)
+	}
+	if size := uint32(len(stream.Output)); member.Size != size {
+		return nil, 0, fmt.Errorf("trailer ISIZE %d, the output is %d bytes", member.Size, size)
+	}
+	return member, n + 8, nil
+}
+
+func parseBlock(r *bitReader, output *[]byte) (*Block, error) {
+	block := &Block{Offset: r.pos}
+	header, err := r.bits(3)
+	if err != nil {
+		return nil, err
+	}
+	block.Final = header&1 == 1
+	block.Type = header >> 1
+
+	switch block.Type {
+	case 0:
+		r.align()
+		length, err := r.bits(16)
+		if err != nil {
+			return nil, err
+		}
+		nlength, err := r.bits(16)
+		if err != nil {
+			return nil, err
+		}
+		if length != ^nlength&0xffff {
+			return nil, fmt.Errorf("stored LEN %d does not match NLEN %d", length, nlength)
+		}
+		for range length {
+			b, err := r.bits(8)
+			if err != nil {
+				return nil, err
+			}
+			block.Tokens = append(block.Tokens, Token{Literal: byte(b)})
+			*output = append(*output, byte(b))
+		}
+		return block, nil
+	case 1:
+		return block, parseTokens(r, block, fixedLitLen, fixedDist, output)
+	case 2:
+		litLen, dist, err := parseTables(r, block)
+		if err != nil {
+			return nil, err
+		}
+		return block, parseTokens(r, block, litLen, dist, output)
+	default:
+		return nil, fmt.Errorf("reserved BTYPE %d", block.Type)
+	}
+}
+
+// parseTables reads the code tables at the start of a dynamic block
+func parseTables(r *bitReader, block *Block) (*huffman, *huffman, error) {
+	counts, err := r.bits(14)
+	if err != nil {
+		return nil, nil, err
+	}
+	block.HLIT = counts&0x1f + 257
+	block.HDIST = counts>>5&0x1f + 1
+	block.HCLEN = counts>>10 + 4
+	if block.HLIT > 286 || block.HDIST > 30 {
+		return nil, nil, fmt.Errorf("HLIT %d or HDIST %d is out of range", block.HLIT, block.HDIST)
+	}
+	block.CodeLengthLengths = make([]int, 19)
+	for i := range block.HCLEN {
+		if block.CodeLengthLengths[codeLengthOrder[i]], err = r.bits(3); err != nil {
+			return nil, nil, err
+		}
+	}
+	codeLengths, err := newHuffman(block.CodeLengthLengths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("code length code: %w", err)
+	}
+
+	lengths := make([]int, 0, block.HLIT+block.HDIST)
+	for len(lengths) < block.HLIT+block.HDIST {
+		symbol, err := codeLengths.decode(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("code lengths: %w", err)
+		}
+		value, repeat := 0, 1
+		switch symbol {
+		case 16:
+			if len(lengths) == 0 {
+				return nil, nil, errors.New("code lengths: repeat code 16 with nothing to repeat")
+			}
+			value = lengths[len(lengths)-1]
+			repeat, err = r.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = r.bits(3)
+			repeat += 3
+		case 18:
+			repeat, err = r.bits(7)
+			repeat += 11
+		default:
+			value = symbol
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(lengths)+repeat > block.HLIT+block.HDIST {
+			return nil, nil, errors.New("code lengths: a repeat runs past HLIT + HDIST")
+		}
+		for range repeat {
+			lengths = append(lengths, value)
+		}
+	}
+	block.LitLenLengths = lengths[:block.HLIT]
+	block.DistLengths = lengths[block.HLIT:]
+	if block.LitLenLengths[256] == 0 {
+		return nil, nil, errors.New("literal/length code has no end of block")
+	}
+	litLen, err := newHuffman(block.LitLenLengths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("literal/length code: %w", err)
+	}
+	dist, err := newHuffman(block.DistLengths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("distance code: %w", err)
+	}
+	return litLen, dist, nil
+}
+
+// parseTokens reads the compressed data of a block up to its end of block
+func parseTokens(r *bitReader, block *Block, litLen, dist *huffman, output *[]byte) error {
+	for {
+		symbol, err := litLen.decode(r)
+		if err != nil {
+			return fmt.Errorf("token %d: %w", len(block.Tokens), err)
+		}
+		if symbol < 256 {
+			block.Tokens = append(block.Tokens, Token{Literal: byte(symbol)})
+			*output = append(*output, byte(symbol))
+			continue
+		}
+		if symbol == 256 {
+			return nil
+		}
+		if symbol -= 257; symbol >= len(lengthBase) {
+			return fmt.Errorf("token %d: length code %d is invalid", len(block.Tokens), symbol+257)
+		}
+		extra, err := r.bits(lengthExtra[symbol])
+		if err != nil {
+			return err
+		}
+		length := lengthBase[symbol] + extra
+		symbol, err = dist.decode(r)
+		if err != nil {
+			return fmt.Errorf("token %d: %w", len(block.Tokens), err)
+		}
+		if symbol >= len(distBase) {
+			return fmt.Errorf("token %d: distance code %d is invalid", len(block.Tokens), symbol)
+		}
+		if extra, err = r.bits(distExtra[symbol]); err != nil {
+			return err
+		}
+		distance := distBase[symbol] + extra
+		if distance > len(*output) {
+			return fmt.Errorf("token %d: distance %d reaches before the start of the output", len(block.Tokens), distance)
+		}
+		block.Tokens = append(block.Tokens, Token{Length: length, Distance: distance})
+		for range length {
+			*output = append(*output, (*output)[len(*output)-distance])
+		}
+	}
+}
+
+// The codes of fixed Huffman blocks, RFC 1951 section 3.2.6
+var fixedLitLen, fixedDist = fixedCodes()
+
+func fixedCodes() (*huffman, *huffman) {
+	lengths := make([]int, 288)
+	for symbol := range lengths {
+		switch {
+		case symbol < 144:
+			lengths[symbol] = 8
+		case symbol < 256:
+			lengths[symbol] = 9
+		case symbol < 280:
+			lengths[symbol] = 7
+		default:
+			lengths[symbol] = 8
+		}
+	}
+	distances := make([]int, 30)
+	for symbol := range distances {
+		distances[symbol] = 5
+	}
+	litLen, _ := newHuffman(lengths)
+	dist, _ := newHuffman(distances)
+	return litLen, dist
+}
+
+// huffman decodes a canonical Huffman code by the number of codes of every
+// length, RFC 1951 section 3.2.2
+type huffman struct {
+	count  [16]int // codes of each length
+	symbol []int   // symbols ordered by code
+}
+
+// newHuffman builds a code from code lengths, which may not be
+// over-subscribed. Incomplete codes are taken, a code that is not assigned
+// fails to decode.
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{symbol: make([]int, 0, len(lengths))}
+	for _, length := range lengths {
+		h.count[length]++
+	}
+	left := 1
+	for length := 1; length < len(h.count); length++ {
+		left = left<<1 - h.count[length]
+		if left < 0 {
+			return nil, fmt.Errorf("the code is over-subscribed at length %d", length)
+		}
+	}
+	for length := 1; length < len(h.count); length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				h.symbol = append(h.symbol, symbol)
+			}
+		}
+	}
+	return h, nil
+}
+
+func (h *huffman) decode(r *bitReader) (int, error) {
+	code, first, index := 0, 0, 0
+	for length := 1; length < len(h.count); length++ {
+		bit, err := r.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit
+		count := h.count[length]
+		if code-first < count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errors.New("no symbol has this code")
+}
+
+// bitReader reads the bits of a deflate stream, the least significant bit
+// of a byte first
+type bitReader struct {
+	data []byte
+	pos  int64 // in bits
+}
+
+func (r *bitReader) bits(n int) (int, error) {
+	value := 0
+	for i := range n {
+		if r.pos >= int64(len(r.data))*8 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		value |= int(r.data[r.pos>>3]>>(r.pos&7)&1) << i
+		r.pos++
+	}
+	return value, nil
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
@@ -1,50 +1,314 @@
 package compression
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
+	"time"
 
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
 	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/lzss"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/zlib"
+	"github.com/adilg123/file-compression-decompression-tool/internal/mmap"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
 )
 
-// SupportedAlgorithms contains all supported compression algorithms
-var SupportedAlgorithms = []string{
-	"huffman",
-	"lzss", 
-	"flate",
-	"gzip",
-}
+// SupportedAlgorithms contains all supported compression algorithms, in the
+// order of the registry
+var SupportedAlgorithms = algorithmNames()
 
 // Options contains compression/decompression options
 type Options struct {
 	Algorithm string
 	BType     uint32 // For FLATE/GZIP
-	BFinal    uint32 // For FLATE/GZIP
+	Salvage   bool   // on truncated input, return what was decoded along with the error
+
+	// Recover goes on past damage when decompressing: gzip members that do
+	// not decode are skipped up to the next member that does, and
+	// Stats.Damage lists what was skipped. The other algorithms have no
+	// boundary to pick up at, they are salvaged as with Salvage.
+	Recover bool
+
+	// Concurrency is how many gzip members are decoded at once when
+	// decompressing, 0 or 1 decodes them one after another
+	Concurrency int
+
+	// TinyInputSize is the size below which flate and gzip skip matching and
+	// write a single stored or fixed Huffman block. 0 keeps
+	// flate.DefaultTinyInputSize, a negative size turns the fast path off.
+	TinyInputSize int
+
+	// BlockSize caps the bytes of input a flate, gzip or zlib block covers,
+	// so huge inputs get a Huffman table per part; 0 leaves blocks to the
+	// splitter, up to 64K tokens each
+	BlockSize int
+
+	// Store makes flate and gzip pass the input through as stored blocks
+	Store bool
+
+	// Partial leaves BFINAL off the last flate block, so the output is the
+	// start of a deflate stream that more blocks are appended to. Without
+	// it the last block is final, and only the last, however many blocks
+	// the input takes. gzip and zlib reject it, their trailer has to follow
+	// a final block.
+	Partial bool
+
+	// Filter is passed the input through before it is compressed, and the
+	// output after it is decompressed. FilterLineDelta is the only one.
+	Filter string
+
+	// Policy decides how AutoAlgorithm compresses an input, nil uses
+	// DefaultPolicy. Filename helps it tell the content type.
+	Policy   *Policy
+	Filename string
+
+	// ModTime is written into the MTIME field of gzip output, the zero time
+	// leaves it unset. Other algorithms have nowhere to keep it.
+	ModTime time.Time
+
+	// Dictionary primes flate and zlib with data the input is likely to
+	// share, matches reach back into its last 32 KiB as if it preceded the
+	// input. Decompressing needs the same dictionary. The other algorithms
+	// reject one.
+	Dictionary []byte
+
+	// StatsOnly runs the compression but discards the output, only the
+	// stats are returned. It is meant for estimating how well a dataset
+	// compresses without moving the results around.
+	StatsOnly bool
+
+	// MaxDecodedSize caps the output of a decompression, 0 for no cap.
+	// Decoding stops with a *SizeLimitError as soon as the output grows
+	// past it, so a small input that expands without end does not take the
+	// memory first. Gzip members decoded side by side are checked as each
+	// of them is done, past the limit they are decoded one after another
+	// again to stop at it.
+	MaxDecodedSize int64
+
+	// Profile fills in Stats.Profile, to see why an input compressed
+	// poorly. Counting the bytes of the input costs a little time.
+	Profile bool
+
+	// Matcher searches the matches of lzss, flate, gzip and zlib in place of
+	// lzss.NewKMPMatcher for lzss and lzss.NewHashChainMatcher for the
+	// others. The output decompresses the same whichever matcher found its
+	// matches, only its size differs.
+	Matcher lzss.MatcherFunc
+
+	// IgnoreChecksums lets gzip members whose CRC-32 or ISIZE does not match
+	// their content decompress anyway, to get at what is left of a damaged
+	// file. The mismatches are listed in Stats.ChecksumMismatches. Members
+	// are then decoded one after another whatever Concurrency says.
+	IgnoreChecksums bool
+
+	// Hashes names algorithms of package checksum, e.g. "crc32" or
+	// "sha256", to compute over the input of a compression while the codec
+	// is fed, without another pass over it. They end up in Stats.Hashes.
+	// Decompressions ignore them.
+	Hashes []string
+
+	decision *PolicyDecision // set once the policy resolved AutoAlgorithm
 }
 
 // Stats contains compression statistics
 type Stats struct {
-	OriginalSize     int
-	ProcessedSize    int
+	OriginalSize     int64
+	ProcessedSize    int64
 	CompressionRatio float64
 	Algorithm        string
+	Duration         time.Duration // how long the codec took
+
+	// What the algorithm held in its buffers, for tuning the options against
+	// a memory budget. It is counted where the codecs allocate, maps, trees
+	// and goroutine stacks are not included.
+	PeakBufferBytes int64 // most bytes held at the same time
+	Allocations     int64 // buffers allocated or grown
+	WindowSize      int   // how far back references reach, 0 for huffman
+
+	// How AutoAlgorithm went about the input, empty when the algorithm was
+	// given
+	ContentType string // the detected media type
+	Policy      string // the action of the rule that applied, e.g. "store"
+
+	// ModTime is the modification time gzip input carried in its MTIME
+	// field, the zero time when it had none
+	ModTime time.Time
+
+	// Warnings about the input that did not stop the operation, such as a
+	// deprecated format that is still decoded
+	Warnings []string
+
+	// Damage lists the ranges of the input Recover skipped
+	Damage []DamagedRange
+
+	// ChecksumMismatches lists the gzip members whose trailers did not match
+	// what they decoded to, with Options.IgnoreChecksums
+	ChecksumMismatches []ChecksumMismatch
+
+	// Profile is set by compressions with Options.Profile
+	Profile *Profile
+
+	// Hashes are the checksums of the input Options.Hashes asked for, in
+	// hex by algorithm
+	Hashes map[string]string
+}
+
+// setMemoryUsage copies what usage recorded into the stats
+func (s *Stats) setMemoryUsage(usage memory.Usage) {
+	s.PeakBufferBytes = usage.PeakBytes
+	s.Allocations = usage.Allocations
+	s.WindowSize = usage.WindowSize
+}
+
+// setDecision records what the policy made of the input
+func (s *Stats) setDecision(decision *PolicyDecision) {
+	if decision != nil {
+		s.ContentType = decision.ContentType
+		s.Policy = decision.Rule.Action
+	}
+}
+
+// writerMemoryUsage returns what the codec behind writer held in its
+// buffers, it is complete once the writer is closed
+func writerMemoryUsage(writer io.WriteCloser) memory.Usage {
+	if reporter, ok := writer.(interface{ MemoryUsage() memory.Usage }); ok {
+		return reporter.MemoryUsage()
+	}
+	return memory.Usage{}
+}
+
+// writerLegacyFormat returns the format of an earlier version the input of a
+// decompression writer was in, "" when it was in the current one or the
+// codec has only ever had one format
+func writerLegacyFormat(writer io.WriteCloser) string {
+	if reporter, ok := writer.(interface{ LegacyFormat() string }); ok {
+		return reporter.LegacyFormat()
+	}
+	return ""
+}
+
+// SizeLimitError is returned by Decompress when the output grows past
+// Options.MaxDecodedSize
+type SizeLimitError struct {
+	Limit int64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("the decompressed data exceeds the limit of %d bytes", e.Limit)
+}
+
+// limitedReader fails with a *SizeLimitError once more than limit bytes
+// were read from it
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, &SizeLimitError{Limit: r.limit}
+	}
+	// one byte past the limit is read to tell an output of exactly limit
+	// bytes from one that goes on
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n + int(r.remaining), &SizeLimitError{Limit: r.limit}
+	}
+	return n, err
+}
+
+// TruncatedError is returned by Decompress when the compressed data ends
+// before the stream is complete
+type TruncatedError struct {
+	Algorithm string
+	Decoded   int64 // bytes decoded before the data ran out
+	Err       error
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("decompression failed: %s data is truncated, %d bytes were decoded before it ended: %v", e.Algorithm, e.Decoded, e.Err)
+}
+
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// CorruptError is returned by Decompress when the compressed data does not
+// decode: a header, a code or a checksum does not check out
+type CorruptError struct {
+	Algorithm string
+	Err       error
+}
+
+func (e *CorruptError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
 }
 
-// AlgorithmFactory defines the interface for compression algorithms
+// ErrDictionaryUnsupported is returned when a preset dictionary is given to
+// an algorithm that does not take one
+var ErrDictionaryUnsupported = errors.New("the algorithm does not support a preset dictionary")
+
+// ErrPartialUnsupported is returned when Partial is set for an algorithm
+// whose output cannot stop short of a final block
+var ErrPartialUnsupported = errors.New("the algorithm cannot write a partial stream")
+
+// DictionaryError is returned by Decompress for zlib data compressed with a
+// preset dictionary that was not given, or a different one
+type DictionaryError = zlib.DictionaryError
+
+// ChecksumMismatch is a gzip member whose CRC-32 or ISIZE did not match,
+// with the values its trailer records and those of its decoded content
+type ChecksumMismatch = gzip.ChecksumMismatch
+
+// AlgorithmFactory defines the interface for compression algorithms.
+//
+// Factories are safe for concurrent use and every call returns a new pair.
+// A pair is single-use: everything written before the writer is closed comes
+// out of the reader, which blocks until then, so the two ends have to be
+// driven from different goroutines. Writing to or closing a closed writer and
+// reading from a closed reader fail with io.ErrClosedPipe, and closing the
+// reader releases a Read that is still waiting for the writer.
 type AlgorithmFactory interface {
 	NewCompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser)
 	NewDecompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser)
 }
 
-// factoryMap maps algorithm names to their factories
-var factoryMap = map[string]AlgorithmFactory{
-	"huffman": &HuffmanFactory{},
-	"lzss":    &LZSSFactory{},
-	"flate":   &FlateFactory{},
-	"gzip":    &GzipFactory{},
+// active counts the compressions and decompressions in progress per algorithm
+var active = func() map[string]*atomic.Int64 {
+	counts := make(map[string]*atomic.Int64, len(registry))
+	for _, entry := range registry {
+		counts[entry.Name] = new(atomic.Int64)
+	}
+	return counts
+}()
+
+// ActiveOperations returns the number of compressions and decompressions in
+// progress per algorithm. Each holds its input, output and the buffers of
+// its codec in memory.
+func ActiveOperations() map[string]int64 {
+	counts := make(map[string]int64, len(active))
+	for algorithm, count := range active {
+		counts[algorithm] = count.Load()
+	}
+	return counts
 }
 
 // Factory implementations
@@ -58,7 +322,11 @@ func (f *HuffmanFactory) NewDecompressionReaderAndWriter(options Options) (io.Re
 
 type LZSSFactory struct{}
 func (f *LZSSFactory) NewCompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
-	return lzss.NewCompressionReaderAndWriter(4096, 4096)
+	reader, writer := lzss.NewCompressionReaderAndWriter(4096, 4096)
+	if options.Matcher != nil {
+		writer.(*lzss.CompressionWriter).SetMatcher(options.Matcher)
+	}
+	return reader, writer
 }
 func (f *LZSSFactory) NewDecompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
 	return lzss.NewDecompressionReaderAndWriter()
@@ -66,11 +334,7 @@ func (f *LZSSFactory) NewDecompressionReaderAndWriter(options Options) (io.ReadC
 
 type FlateFactory struct{}
 func (f *FlateFactory) NewCompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
-	btype := options.BType
-	if btype == 0 {
-		btype = 2 // Default to dynamic Huffman
-	}
-	return flate.NewCompressionReaderAndWriter(btype, options.BFinal)
+	return newFlateCompression(options)
 }
 func (f *FlateFactory) NewDecompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
 	return flate.NewDecompressionReaderAndWriter()
@@ -78,21 +342,61 @@ func (f *FlateFactory) NewDecompressionReaderAndWriter(options Options) (io.Read
 
 type GzipFactory struct{}
 func (f *GzipFactory) NewCompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
-	btype := options.BType
-	if btype == 0 {
-		btype = 2 // Default to dynamic Huffman
-	}
-	flateReader, flateWriter := flate.NewCompressionReaderAndWriter(btype, options.BFinal)
-	return gzip.NewCompressionReaderAndWriter(flateReader, flateWriter)
+	flateReader, flateWriter := newFlateCompression(options)
+	reader, writer := gzip.NewCompressionReaderAndWriter(flateReader, flateWriter)
+	writer.(*gzip.CompressionWriter).SetModTime(options.ModTime)
+	return reader, writer
 }
 func (f *GzipFactory) NewDecompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
 	flateReader, flateWriter := flate.NewDecompressionReaderAndWriter()
-	return gzip.NewDecompressionReaderAndWriter(flateReader, flateWriter)
+	reader, writer := gzip.NewDecompressionReaderAndWriter(flateReader, flateWriter)
+	writer.(*gzip.DecompressionWriter).SetIgnoreChecksums(options.IgnoreChecksums)
+	return reader, writer
+}
+
+type ZlibFactory struct{}
+func (f *ZlibFactory) NewCompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
+	flateReader, flateWriter := newFlateCompression(options)
+	return zlib.NewCompressionReaderAndWriter(flateReader, flateWriter)
+}
+func (f *ZlibFactory) NewDecompressionReaderAndWriter(options Options) (io.ReadCloser, io.WriteCloser) {
+	flateReader, flateWriter := flate.NewDecompressionReaderAndWriter()
+	return zlib.NewDecompressionReaderAndWriter(flateReader, flateWriter)
+}
+
+// newFlateCompression returns the flate pair flate, gzip and zlib compress with
+func newFlateCompression(options Options) (io.ReadCloser, io.WriteCloser) {
+	btype := options.BType
+	if options.Store {
+		btype = 0
+	} else if btype == 0 {
+		btype = flate.AutoBlockType // whichever block comes out smallest
+	}
+	reader, writer := flate.NewCompressionReaderAndWriter(btype, finalBit(options))
+	if options.TinyInputSize != 0 {
+		writer.(*flate.CompressionWriter).SetTinyInputSize(max(options.TinyInputSize, 0))
+	}
+	if options.BlockSize > 0 {
+		writer.(*flate.CompressionWriter).SetBlockSize(options.BlockSize)
+	}
+	if options.Matcher != nil {
+		writer.(*flate.CompressionWriter).SetMatcher(options.Matcher)
+	}
+	return reader, writer
+}
+
+// finalBit is the BFINAL bit of the last block of flate output, the core
+// leaves it off the blocks before
+func finalBit(options Options) uint32 {
+	if options.Partial {
+		return 0
+	}
+	return 1
 }
 
 // IsValidAlgorithm checks if the provided algorithm is supported
 func IsValidAlgorithm(algorithm string) bool {
-	_, exists := factoryMap[algorithm]
+	_, exists := lookupAlgorithm(algorithm)
 	return exists
 }
 
@@ -103,103 +407,689 @@ func GetSupportedAlgorithms() []string {
 
 // Compress compresses data using the specified algorithm
 func Compress(data []byte, options Options) ([]byte, *Stats, error) {
-	if !IsValidAlgorithm(options.Algorithm) {
-		return nil, nil, fmt.Errorf("unsupported algorithm: %s", options.Algorithm)
+	return CompressContext(context.Background(), data, options)
+}
+
+// CompressContext is Compress with a context that stops feeding the
+// algorithm once it is cancelled
+func CompressContext(ctx context.Context, data []byte, options Options) ([]byte, *Stats, error) {
+	if options.StatsOnly {
+		// nothing is collected, the output is only counted
+		stats, err := CompressStream(ctx, io.Discard, bytes.NewReader(data), options)
+		return nil, stats, err
+	}
+	if options.Algorithm == AutoAlgorithm {
+		options = options.decide(data).Options(options)
+	}
+	factory, err := factoryFor(options.Algorithm)
+	if err != nil {
+		return nil, nil, err
 	}
+	if !isValidFilter(options.Filter) {
+		return nil, nil, fmt.Errorf("unsupported filter: %s", options.Filter)
+	}
+	if err := checkPartial(options); err != nil {
+		return nil, nil, err
+	}
+	if err := checkDictionary(options); err != nil {
+		return nil, nil, err
+	}
+	sums, err := newSums(options.Hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	active[options.Algorithm].Add(1)
+	defer active[options.Algorithm].Add(-1)
 
-	factory := factoryMap[options.Algorithm]
+	ctx = telemetry.WithAlgorithm(ctx, options.Algorithm)
+	ctx, span := telemetry.Start(ctx, "compression.Compress",
+		attribute.String("compression.algorithm", options.Algorithm),
+		attribute.Int("compression.input_bytes", len(data)))
 	reader, writer := factory.NewCompressionReaderAndWriter(options)
+	setContext(writer, ctx)
+	setDictionary(writer, options.Dictionary)
 	
 	// Perform compression
-	compressedData, err := processData(data, reader, writer)
+	start := time.Now()
+	var input io.WriteCloser = writer
+	if sums != nil && options.Filter != "" {
+		// the codec is fed the filtered data, the sums are of the input
+		sums.Write(data)
+	} else if sums != nil {
+		input = &hashingWriter{WriteCloser: writer, sums: sums}
+	}
+	compressedData, err := processData(ctx, applyFilter(options.Filter, data), reader, input, 0)
 	if err != nil {
+		telemetry.End(span, err)
 		return nil, nil, fmt.Errorf("compression failed: %w", err)
 	}
+	span.SetAttributes(attribute.Int("compression.output_bytes", len(compressedData)))
+	span.End()
 
 	// Calculate statistics
 	stats := &Stats{
-		OriginalSize:     len(data),
-		ProcessedSize:    len(compressedData),
+		OriginalSize:     int64(len(data)),
+		ProcessedSize:    int64(len(compressedData)),
 		Algorithm:        options.Algorithm,
+		Duration:         time.Since(start),
+	}
+	stats.setMemoryUsage(writerMemoryUsage(writer))
+	stats.setDecision(options.decision)
+	if options.Profile {
+		var histogram [256]int64
+		countBytes(&histogram, data)
+		stats.Profile = newProfile(&histogram, writer)
+	}
+	if sums != nil {
+		stats.Hashes = sums.Hex()
 	}
 	
 	if len(data) > 0 {
 		stats.CompressionRatio = float64(len(compressedData)) / float64(len(data)) * 100
 	}
+	telemetry.RecordSizes(ctx, options.Algorithm, "compress", len(data), stats.CompressionRatio)
 
 	return compressedData, stats, nil
 }
 
 // Decompress decompresses data using the specified algorithm
 func Decompress(data []byte, options Options) ([]byte, *Stats, error) {
-	if !IsValidAlgorithm(options.Algorithm) {
-		return nil, nil, fmt.Errorf("unsupported algorithm: %s", options.Algorithm)
+	return DecompressContext(context.Background(), data, options)
+}
+
+// DecompressContext is Decompress with a context that stops feeding the
+// algorithm once it is cancelled. AutoAlgorithm picks the algorithm from the
+// first bytes of data, and flate, gzip and zlib data is decoded in the
+// framing it turns out to have whichever of the three was asked for.
+func DecompressContext(ctx context.Context, data []byte, options Options) ([]byte, *Stats, error) {
+	requested := options.Algorithm
+	if options.Algorithm == AutoAlgorithm {
+		if options.Algorithm = sniff(data); options.Algorithm == "" {
+			return nil, nil, errors.New("the algorithm the data was compressed with could not be detected")
+		}
+	} else if framing := DetectFraming(data); deflateFramings[options.Algorithm] && framing != "" {
+		options.Algorithm = framing
+	}
+	factory, err := factoryFor(options.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isValidFilter(options.Filter) {
+		return nil, nil, fmt.Errorf("unsupported filter: %s", options.Filter)
+	}
+	if err := checkDictionary(options); err != nil {
+		return nil, nil, err
+	}
+	if options.Recover && options.Algorithm != "gzip" {
+		options.Salvage = true
 	}
 
-	factory := factoryMap[options.Algorithm]
-	reader, writer := factory.NewDecompressionReaderAndWriter(options)
-	
+	active[options.Algorithm].Add(1)
+	defer active[options.Algorithm].Add(-1)
+
+	ctx = telemetry.WithAlgorithm(ctx, options.Algorithm)
+	ctx, span := telemetry.Start(ctx, "compression.Decompress",
+		attribute.String("compression.algorithm", options.Algorithm),
+		attribute.Int("compression.input_bytes", len(data)))
 	// Perform decompression
-	decompressedData, err := processData(data, reader, writer)
-	if err != nil {
+	var decompressedData []byte
+	var usage memory.Usage
+	var legacyFormat string
+	var mismatches []ChecksumMismatch
+	start := time.Now()
+	parallel := false
+	if options.Algorithm == "gzip" && options.Concurrency > 1 && !options.IgnoreChecksums {
+		decompressedData, usage, parallel = decompressMembers(ctx, data, options.Concurrency, options.MaxDecodedSize)
+	}
+	if !parallel {
+		reader, writer := factory.NewDecompressionReaderAndWriter(options)
+		setContext(writer, ctx)
+		setDictionary(writer, options.Dictionary)
+		// the output is allocated at the size the headers record, if they do
+		// and it is one the input can plausibly expand to
+		sizeHint, _ := OriginalSize(data, options.Algorithm)
+		sizeHint = min(sizeHint, maxPreallocation, int64(len(data))*maxPreallocationRatio)
+		if options.MaxDecodedSize > 0 {
+			reader = &limitedReader{ReadCloser: reader, remaining: options.MaxDecodedSize, limit: options.MaxDecodedSize}
+			sizeHint = min(sizeHint, options.MaxDecodedSize)
+		}
+		decompressedData, err = processData(ctx, data, reader, writer, int(sizeHint))
+		if limited, ok := reader.(*limitedReader); ok && limited.remaining < 0 {
+			// the writer may fail first, on the pipe the limit closed
+			err = &SizeLimitError{Limit: limited.limit}
+		}
+		usage = writerMemoryUsage(writer)
+		legacyFormat = writerLegacyFormat(writer)
+		if reporter, ok := writer.(interface{ ChecksumMismatches() []ChecksumMismatch }); ok {
+			mismatches = reporter.ChecksumMismatches()
+		}
+	}
+	var damage []DamagedRange
+	var limited *SizeLimitError
+	if err != nil && options.Recover && options.Algorithm == "gzip" && ctx.Err() == nil && !errors.As(err, &limited) {
+		// members are decoded again, those that do not match are damage now
+		decompressedData, damage = recoverMembers(ctx, data)
+		mismatches, err = nil, nil
+		span.SetAttributes(attribute.Int("compression.damaged_ranges", len(damage)))
+		if options.MaxDecodedSize > 0 && int64(len(decompressedData)) > options.MaxDecodedSize {
+			decompressedData, damage = nil, nil
+			err = &SizeLimitError{Limit: options.MaxDecodedSize}
+		}
+	}
+	span.SetAttributes(attribute.Int("compression.output_bytes", len(decompressedData)))
+	telemetry.End(span, err)
+	var truncated *TruncatedError
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		truncated = &TruncatedError{Algorithm: options.Algorithm, Decoded: int64(len(decompressedData)), Err: err}
+		if !options.Salvage {
+			return nil, nil, truncated
+		}
+	} else if err != nil {
+		var dictErr *DictionaryError
+		if !errors.As(err, &limited) && !errors.As(err, &dictErr) && ctx.Err() == nil {
+			err = &CorruptError{Algorithm: options.Algorithm, Err: err}
+		}
 		return nil, nil, fmt.Errorf("decompression failed: %w", err)
 	}
+	// the partial output of a truncated input is unfiltered as far as it goes
+	if decompressedData, err = unfilter(options.Filter, decompressedData); err != nil {
+		return nil, nil, fmt.Errorf("decompression failed: %w", &CorruptError{Algorithm: options.Algorithm, Err: err})
+	}
+	if options.MaxDecodedSize > 0 && int64(len(decompressedData)) > options.MaxDecodedSize {
+		// the filter restores what the prefixes of the lines left out
+		return nil, nil, fmt.Errorf("decompression failed: %w", &SizeLimitError{Limit: options.MaxDecodedSize})
+	}
 
 	// Calculate statistics
 	stats := &Stats{
-		OriginalSize:     len(data),
-		ProcessedSize:    len(decompressedData),
+		OriginalSize:     int64(len(data)),
+		ProcessedSize:    int64(len(decompressedData)),
 		Algorithm:        options.Algorithm,
+		Duration:         time.Since(start),
+	}
+	stats.setMemoryUsage(usage)
+	if options.Algorithm == "gzip" {
+		stats.ModTime = gzip.ModTime(data)
+	}
+	if requested != AutoAlgorithm && requested != options.Algorithm {
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf("the data is %s rather than %s and was decoded as such", options.Algorithm, requested))
+	}
+	if legacyFormat != "" {
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf("the %s data is in the deprecated %s format, compress it again to upgrade it", options.Algorithm, legacyFormat))
+	}
+	if len(damage) > 0 {
+		stats.Damage = damage
+		skipped := int64(0)
+		for _, r := range damage {
+			skipped += r.Size
+		}
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf("%d damaged ranges of %d bytes in all were skipped, what is around them was recovered", len(damage), skipped))
+	}
+	if len(mismatches) > 0 {
+		stats.ChecksumMismatches = mismatches
+		for _, mismatch := range mismatches {
+			stats.Warnings = append(stats.Warnings, mismatch.Error()+", the output may be damaged")
+		}
 	}
 	
 	if len(data) > 0 {
 		stats.CompressionRatio = float64(len(data)) / float64(len(decompressedData)) * 100
 	}
 
+	telemetry.RecordSizes(ctx, options.Algorithm, "decompress", len(data), stats.CompressionRatio)
+
+	if truncated != nil {
+		// salvage mode: the partial output is returned together with the error
+		return decompressedData, stats, truncated
+	}
 	return decompressedData, stats, nil
 }
 
-// processData handles the common pattern of writing to writer and reading from reader
-func processData(inputData []byte, reader io.ReadCloser, writer io.WriteCloser) ([]byte, error) {
-	defer reader.Close()
-	defer writer.Close()
+// setContext hands ctx to codecs that start spans for their stages
+func setContext(writer io.WriteCloser, ctx context.Context) {
+	if setter, ok := writer.(interface{ SetContext(context.Context) }); ok {
+		setter.SetContext(ctx)
+	}
+}
 
-	// Channel to collect the result
-	resultCh := make(chan []byte, 1)
-	errorCh := make(chan error, 1)
+// setDictionary primes codecs that take a preset dictionary with dict
+func setDictionary(writer io.WriteCloser, dict []byte) {
+	if setter, ok := writer.(interface{ SetDictionary([]byte) }); ok && dict != nil {
+		setter.SetDictionary(dict)
+	}
+}
 
-	// Start reading in a goroutine
-	go func() {
-		defer close(resultCh)
-		defer close(errorCh)
-		
-		// Read all data from reader
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			errorCh <- err
-			return
+// checkDictionary rejects a dictionary for algorithms that have no use for
+// one, their output would not depend on it
+func checkDictionary(options Options) error {
+	if entry, ok := lookupAlgorithm(options.Algorithm); options.Dictionary != nil && (!ok || !entry.Features.Dictionary) {
+		return fmt.Errorf("%w: %s", ErrDictionaryUnsupported, options.Algorithm)
+	}
+	return nil
+}
+
+// checkPartial rejects Partial for algorithms whose output has to end with
+// a final block
+func checkPartial(options Options) error {
+	if entry, ok := lookupAlgorithm(options.Algorithm); options.Partial && (!ok || !entry.Features.Partial) {
+		return fmt.Errorf("%w: %s", ErrPartialUnsupported, options.Algorithm)
+	}
+	return nil
+}
+
+// CompressFile compresses a local file. The file is memory-mapped where the
+// platform supports it, so large inputs are not read into a Go buffer first.
+func CompressFile(path string, options Options) ([]byte, *Stats, error) {
+	file, err := mmap.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+	return Compress(file.Bytes(), options)
+}
+
+// DecompressFile decompresses a local file, memory-mapping it like CompressFile
+func DecompressFile(path string, options Options) ([]byte, *Stats, error) {
+	file, err := mmap.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer file.Close()
+	return Decompress(file.Bytes(), options)
+}
+
+// CompressStream compresses everything read from src into dst. Unlike
+// Compress neither the input nor the output is held in a buffer of its own,
+// what the algorithm keeps in memory until its writer is closed is all that
+// is needed. Every read from src is handed to the algorithm as it comes, so
+// input that trickles in is not held back. With Options.StatsOnly dst is
+// not written to.
+func CompressStream(ctx context.Context, dst io.Writer, src io.Reader, options Options) (*Stats, error) {
+	if options.StatsOnly {
+		dst = io.Discard
+	}
+	src, options = ApplyPolicy(src, options)
+	factory, err := factoryFor(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if !isValidFilter(options.Filter) {
+		return nil, fmt.Errorf("unsupported filter: %s", options.Filter)
+	}
+	if err := checkPartial(options); err != nil {
+		return nil, err
+	}
+	if err := checkDictionary(options); err != nil {
+		return nil, err
+	}
+	sums, err := newSums(options.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{reader: src, sums: sums}
+	if options.Profile {
+		counter.histogram = new([256]int64)
+	}
+	if options.Filter != "" {
+		src = newLineDeltaReader(counter)
+	} else {
+		src = counter
+	}
+
+	active[options.Algorithm].Add(1)
+	defer active[options.Algorithm].Add(-1)
+
+	ctx = telemetry.WithAlgorithm(ctx, options.Algorithm)
+	ctx, span := telemetry.Start(ctx, "compression.CompressStream",
+		attribute.String("compression.algorithm", options.Algorithm))
+	reader, writer := factory.NewCompressionReaderAndWriter(options)
+	setContext(writer, ctx)
+	setDictionary(writer, options.Dictionary)
+
+	start := time.Now()
+	g, gctx := errgroup.WithContext(ctx)
+	var written int64
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		n, copyErr := io.Copy(dst, reader)
+		written = n
+		closeErr := reader.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to write output: %w", copyErr)
 		}
-		resultCh <- data
-	}()
+		if closeErr != nil {
+			return fmt.Errorf("failed to close reader: %w", closeErr)
+		}
+		return nil
+	})
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		writeErr := copyChunks(gctx, writer, src)
+		closeErr := writer.Close()
+		if writeErr != nil {
+			return writeErr
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to close writer: %w", closeErr)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		telemetry.End(span, err)
+		return nil, fmt.Errorf("compression failed: %w", err)
+	}
+	read := counter.n
+	span.SetAttributes(attribute.Int64("compression.input_bytes", read), attribute.Int64("compression.output_bytes", written))
+	span.End()
 
-	// Write input data and close writer
-	if _, err := writer.Write(inputData); err != nil {
-		return nil, fmt.Errorf("failed to write data: %w", err)
+	stats := &Stats{
+		OriginalSize:  read,
+		ProcessedSize: written,
+		Algorithm:     options.Algorithm,
+		Duration:      time.Since(start),
 	}
-	
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close writer: %w", err)
+	stats.setMemoryUsage(writerMemoryUsage(writer))
+	stats.setDecision(options.decision)
+	if options.Profile {
+		stats.Profile = newProfile(counter.histogram, writer)
+	}
+	if sums != nil {
+		stats.Hashes = sums.Hex()
+	}
+	if read > 0 {
+		stats.CompressionRatio = float64(written) / float64(read) * 100
 	}
+	telemetry.RecordSizes(ctx, options.Algorithm, "compress", int(read), stats.CompressionRatio)
+	return stats, nil
+}
 
-	// Wait for result or error
-	select {
-	case err := <-errorCh:
+// DecompressStream decompresses everything read from src into dst as the
+// algorithm decodes it, with neither side held in a buffer of its own. It
+// decodes the algorithm asked for: detecting another one, filtering and
+// salvaging need the whole data, so AutoAlgorithm, Options.Filter and
+// Options.Salvage are rejected or ignored. Output past
+// Options.MaxDecodedSize fails with a *SizeLimitError, what was written to
+// dst up to the limit stays written.
+func DecompressStream(ctx context.Context, dst io.Writer, src io.Reader, options Options) (*Stats, error) {
+	factory, err := factoryFor(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if options.Filter != "" {
+		return nil, fmt.Errorf("the %s filter cannot be undone on a stream", options.Filter)
+	}
+	if err := checkDictionary(options); err != nil {
+		return nil, err
+	}
+	counter := &countingReader{reader: src}
+
+	active[options.Algorithm].Add(1)
+	defer active[options.Algorithm].Add(-1)
+
+	ctx = telemetry.WithAlgorithm(ctx, options.Algorithm)
+	ctx, span := telemetry.Start(ctx, "compression.DecompressStream",
+		attribute.String("compression.algorithm", options.Algorithm))
+	reader, writer := factory.NewDecompressionReaderAndWriter(options)
+	setContext(writer, ctx)
+	setDictionary(writer, options.Dictionary)
+	if options.MaxDecodedSize > 0 {
+		reader = &limitedReader{ReadCloser: reader, remaining: options.MaxDecodedSize, limit: options.MaxDecodedSize}
+	}
+
+	start := time.Now()
+	g, gctx := errgroup.WithContext(ctx)
+	// failing to write the output or to read the input is not the data's
+	// fault, the codec failing is
+	output := &errorWriter{writer: dst}
+	var written int64
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		n, copyErr := io.Copy(output, reader)
+		written = n
+		closeErr := reader.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+		return closeErr
+	})
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		writeErr := copyChunks(gctx, writer, counter)
+		closeErr := writer.Close()
+		if writeErr != nil {
+			return writeErr
+		}
+		return closeErr
+	})
+	err = g.Wait()
+	if limited, ok := reader.(*limitedReader); ok && limited.remaining < 0 {
+		// the writer may fail first, on the pipe the limit closed
+		err = &SizeLimitError{Limit: limited.limit}
+	}
+	telemetry.End(span, err)
+	var limited *SizeLimitError
+	var dictErr *DictionaryError
+	switch {
+	case err == nil:
+	case output.err != nil, counter.err != nil, ctx.Err() != nil, errors.As(err, &limited), errors.As(err, &dictErr):
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, &TruncatedError{Algorithm: options.Algorithm, Decoded: written, Err: err}
+	default:
+		return nil, fmt.Errorf("decompression failed: %w", &CorruptError{Algorithm: options.Algorithm, Err: err})
+	}
+
+	read := counter.n
+	stats := &Stats{
+		OriginalSize:  read,
+		ProcessedSize: written,
+		Algorithm:     options.Algorithm,
+		Duration:      time.Since(start),
+	}
+	stats.setMemoryUsage(writerMemoryUsage(writer))
+	if written > 0 {
+		stats.CompressionRatio = float64(read) / float64(written) * 100
+	}
+	telemetry.RecordSizes(ctx, options.Algorithm, "decompress", int(read), stats.CompressionRatio)
+	return stats, nil
+}
+
+// countingReader counts the bytes read through it, every byte value with a
+// histogram and their checksums with sums
+type countingReader struct {
+	reader    io.Reader
+	n         int64
+	err       error // what reading failed with, io.EOF aside
+	histogram *[256]int64
+	sums      *checksum.Sums
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	if r.histogram != nil {
+		countBytes(r.histogram, p[:n])
+	}
+	if r.sums != nil {
+		r.sums.Write(p[:n])
+	}
+	return n, err
+}
+
+// errorWriter remembers what writing to writer failed with
+type errorWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// hashingWriter feeds sums what is written to the codec, chunk by chunk
+// while it is still in the cache
+type hashingWriter struct {
+	io.WriteCloser
+	sums *checksum.Sums
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	w.sums.Write(p)
+	return w.WriteCloser.Write(p)
+}
+
+// newSums returns the hashes Options.Hashes names, nil for none
+func newSums(algorithms []string) (*checksum.Sums, error) {
+	if len(algorithms) == 0 {
+		return nil, nil
+	}
+	return checksum.NewSums(algorithms)
+}
+
+// processChunkSize is how much input is handed to a writer at a time, the
+// context is checked in between so a cancelled request stops feeding it
+const processChunkSize = 32 * 1024
+
+// maxPreallocation caps the output allocated up front for the size a header
+// records, a damaged or hostile header cannot claim more memory than this
+// before anything is decoded
+const maxPreallocation = 64 << 20
+
+// maxPreallocationRatio caps the output allocated up front at this many
+// times the input. Inputs that expand further exist, a header that claims
+// they do is not trusted with the memory before the output shows it.
+const maxPreallocationRatio = 100
+
+// processData writes inputData through writer and collects what comes out of
+// reader. When it fails, whatever the reader produced up to then is returned
+// along with the error. Both sides run in an errgroup: the writer is always closed, even
+// after a failed write, so a reader waiting for the end of the input is
+// released, and the reader is always closed, which unblocks a writer that is
+// still pushing output into a pipe nobody reads anymore. Panics in a codec are
+// turned into errors instead of taking the process down. The output starts
+// out with room for sizeHint bytes.
+func processData(ctx context.Context, inputData []byte, reader io.ReadCloser, writer io.WriteCloser, sizeHint int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	var output []byte
+
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		data, readErr := readAll(reader, sizeHint)
+		output = data
+		closeErr := reader.Close()
+		if readErr != nil {
+			return fmt.Errorf("failed to read data: %w", readErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to close reader: %w", closeErr)
+		}
+		return nil
+	})
+
+	g.Go(func() (err error) {
+		defer recoverCodecPanic(&err)
+		writeErr := writeChunks(ctx, writer, inputData)
+		closeErr := writer.Close()
+		if writeErr != nil {
+			return writeErr
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to close writer: %w", closeErr)
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	return output, err
+}
+
+// readAll is io.ReadAll into a buffer of sizeHint bytes, which is only grown
+// when more than that comes out of r
+func readAll(r io.Reader, sizeHint int) ([]byte, error) {
+	if sizeHint <= 0 {
+		return io.ReadAll(r)
+	}
+	b := make([]byte, 0, sizeHint)
+	for {
+		if len(b) == cap(b) {
+			// the end is most likely reached, which a small read tells
+			// without growing the buffer
+			var probe [512]byte
+			n, err := r.Read(probe[:])
+			b = append(b, probe[:n]...)
+			if err == io.EOF {
+				return b, nil
+			}
+			if err != nil {
+				return b, err
+			}
+			continue
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			return b, nil
+		}
 		if err != nil {
-			return nil, err
+			return b, err
 		}
-	case result := <-resultCh:
-		return result, nil
 	}
+}
+
+// writeChunks feeds data to writer in processChunkSize pieces until it is
+// done or the context is cancelled
+func writeChunks(ctx context.Context, writer io.Writer, data []byte) (err error) {
+	// recovered here already so the writer still gets closed afterwards
+	defer recoverCodecPanic(&err)
+	for len(data) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk := data[:min(processChunkSize, len(data))]
+		if _, err := writer.Write(chunk); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+		data = data[len(chunk):]
+	}
+	return nil
+}
 
-	return nil, fmt.Errorf("unexpected error during processing")
-}
\ No newline at end of file
+// copyChunks feeds writer every read from src as it comes, in pieces of at
+// most processChunkSize, until src ends or the context is cancelled
+func copyChunks(ctx context.Context, writer io.Writer, src io.Reader) error {
+	buf := make([]byte, processChunkSize)
+	for {
+		n, readErr := src.Read(buf)
+		if err := writeChunks(ctx, writer, buf[:n]); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read input: %w", readErr)
+		}
+	}
+}
+
+// errCodecPanic marks errors that were recovered from a panicking codec
+var errCodecPanic = errors.New("codec panicked")
+
+func recoverCodecPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%w: %v", errCodecPanic, r)
+	}
+}
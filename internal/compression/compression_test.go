@@ -0,0 +1,1878 @@
+package compression
+
+import (
+	"bytes"
+	stdflate "compress/flate"
+	stdgzip "compress/gzip"
+	stdzlib "compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/codetables"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// checkNoLeaks fails the test if goroutines started while it ran are still
+// alive shortly after it finished
+func checkNoLeaks(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if after := runtime.NumGoroutine(); after > before {
+			buf := make([]byte, 1<<16)
+			t.Errorf("%d goroutines leaked:\n%s", after-before, buf[:runtime.Stack(buf, true)])
+		}
+	})
+}
+
+func TestProcessDataDoesNotLeak(t *testing.T) {
+	input := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog. ", 200))
+	garbage := []byte("this was never compressed with anything at all")
+
+	for _, algorithm := range SupportedAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			checkNoLeaks(t)
+			options := Options{Algorithm: algorithm}
+
+			compressed, _, err := Compress(input, options)
+			if err != nil {
+				t.Fatalf("Compress: %v", err)
+			}
+			decompressed, _, err := Decompress(compressed, options)
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			if !bytes.Equal(decompressed, input) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(decompressed), len(input))
+			}
+
+			// corrupt input may or may not be rejected, but must not hang or leak
+			Decompress(garbage, options)
+		})
+	}
+}
+
+// blockingWriter pushes output into a pipe on Close, which blocks until the
+// other end is read from or closed
+type blockingWriter struct {
+	pipe *io.PipeWriter
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *blockingWriter) Close() error {
+	if _, err := w.pipe.Write(make([]byte, 1024)); err != nil {
+		return err
+	}
+	return w.pipe.Close()
+}
+
+// failingReader gives up before reading anything from its pipe
+type failingReader struct {
+	pipe *io.PipeReader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) { return 0, errors.New("read failed") }
+func (r *failingReader) Close() error               { return r.pipe.Close() }
+
+func TestProcessDataReleasesBlockedWriter(t *testing.T) {
+	checkNoLeaks(t)
+	pipeReader, pipeWriter := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := processData(context.Background(), []byte("data"), &failingReader{pipeReader}, &blockingWriter{pipeWriter}, 0)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the read error to be returned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processData did not return after the reader failed")
+	}
+}
+
+func TestProcessDataCancelled(t *testing.T) {
+	checkNoLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, algorithm := range SupportedAlgorithms {
+		if _, _, err := CompressContext(ctx, []byte("data"), Options{Algorithm: algorithm}); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want context.Canceled", algorithm, err)
+		}
+	}
+}
+
+func TestBinarySupport(t *testing.T) {
+	matrix := VerifyBinarySupport()
+	for _, algorithm := range SupportedAlgorithms {
+		capabilities, ok := matrix[algorithm]
+		if !ok {
+			t.Errorf("%s has no entry in the capability matrix", algorithm)
+			continue
+		}
+		if !capabilities.Verified {
+			t.Errorf("%s failed the binary self-test: %s", algorithm, capabilities.VerifyError)
+		}
+	}
+	if advertised := GetAdvertisedAlgorithms(); len(advertised) != len(SupportedAlgorithms) {
+		t.Errorf("advertised %v, want all of %v", advertised, SupportedAlgorithms)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	matrix, err := Warm()
+	if err != nil {
+		t.Fatalf("Warm: %v", err)
+	}
+	if len(matrix) != len(registry) {
+		t.Errorf("Warm returned %d algorithms, want %d", len(matrix), len(registry))
+	}
+	if again, err := Warm(); err != nil || len(again) != len(matrix) {
+		t.Errorf("Warm again returned %d algorithms, %v", len(again), err)
+	}
+}
+
+func TestTinyInputs(t *testing.T) {
+	inputs := [][]byte{{}, {0}, {'a'}, {'a', 'a'}, {'a', 'b'}, {0, 0xff, 0}}
+	for _, algorithm := range SupportedAlgorithms {
+		for _, input := range inputs {
+			options := Options{Algorithm: algorithm}
+			compressed, _, err := Compress(input, options)
+			if err != nil {
+				t.Errorf("%s: Compress(%x): %v", algorithm, input, err)
+				continue
+			}
+			decompressed, _, err := Decompress(compressed, options)
+			if err != nil {
+				t.Errorf("%s: Decompress(Compress(%x)): %v", algorithm, input, err)
+				continue
+			}
+			if !bytes.Equal(decompressed, input) {
+				t.Errorf("%s: round trip of %x gave %x", algorithm, input, decompressed)
+			}
+		}
+	}
+}
+
+// TestHuffmanDeterministic pins the huffman output for a fixed input, so a
+// change in table order or tie breaking shows up as a format change
+func TestHuffmanDeterministic(t *testing.T) {
+	want := []byte{
+		0x89, 'H', 'U', 'F', 0x03, // magic and format version
+		0x0c, 0x81, // header frame of 12 bytes with a checksum
+		0x0b,                                                        // content length
+		0x05, 'a', 0x05, 'b', 0x02, 'c', 0x01, 'd', 0x01, 'r', 0x02, // table, ascending
+		0xb2, 0x76, 0x72, 0xff,
+		0x03, 0x82, // code frame of 3 bytes with a checksum
+		0x6e, 0x8a, 0xdc,
+		0x06, 0xd1, 0x01, 0xf3,
+	}
+	for range 10 {
+		got, _, err := Compress([]byte("abracadabra"), Options{Algorithm: "huffman"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Compress(abracadabra) = %x, want %x", got, want)
+		}
+	}
+
+	// the same table listed out of order is rejected
+	reordered := []byte{0x89, 'H', 'U', 'F', 0x02, 0x0b, 0x05, 'b', 0x02, 'a', 0x05, 'c', 0x01, 'd', 0x01, 'r', 0x02, 0x6e, 0x8a, 0xdc}
+	if _, _, err := Decompress(reordered, Options{Algorithm: "huffman"}); err == nil {
+		t.Error("Decompress accepted a frequency table out of byte order")
+	}
+
+	// so is a flipped bit in the code, which the checksum of its frame catches
+	corrupt := bytes.Clone(want)
+	corrupt[len(corrupt)-6] ^= 0x01
+	if _, _, err := Decompress(corrupt, Options{Algorithm: "huffman"}); !errors.Is(err, framing.ErrChecksum) {
+		t.Errorf("Decompress of a corrupt code returned %v", err)
+	}
+}
+
+// TestLegacyFormats decodes data written by earlier versions, before huffman
+// and lzss had a magic and a version, and checks that it is flagged as
+// deprecated
+func TestLegacyFormats(t *testing.T) {
+	legacy := []struct {
+		algorithm string
+		data      string
+		want      string
+	}{
+		// huffman text
+		{"huffman", "2|r1|c1|d5|a2|b\\\n\x017En", "abracadabra"},
+		{"huffman", "2|o1|t1|w3|n3|e2|\\n2|l2|i2| \\\n\x00k\xe3>\r|j\xe0", "line one\nline two\n"},
+		{"huffman", "1|n2||1|12|\\\\\n\x04\f\xe6", "|1|\\n\\"},
+		{"huffman", "1|é1|o1|w1|ö1|r1|h3|l1| 1|d\\\n\x06\x03\x95~\x16-", "héllo wörld"},
+		// huffman binary without a magic
+		{"huffman", "\x0b\x05a\x05b\x02c\x01d\x01r\x02\x6e\x8a\xdc", "abracadabra"},
+		// huffman and lzss binary without frames
+		{"huffman", "\x89HUF\x02\x0b\x05a\x05b\x02c\x01d\x01r\x02\x6e\x8a\xdc", "abracadabra"},
+		{"lzss", "\x89LZS\x02\x0b\x80abracad\x07\x04", "abracadabra"},
+		// lzss text
+		{"lzss", "\\<tag\\>a\\,b\\\\\\</tag\\> <22,22>again and again", "<tag>a,b\\</tag> <tag>a,b\\</tag> again and again"},
+		// offsets count bytes, a reference may start inside a multi-byte character
+		{"lzss", "héllo x<6,4>", "héllo x\xa9llo"},
+	}
+	for _, test := range legacy {
+		got, stats, err := Decompress([]byte(test.data), Options{Algorithm: test.algorithm})
+		if err != nil {
+			t.Errorf("%s: Decompress(%q): %v", test.algorithm, test.data, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: Decompress(%q) = %q, want %q", test.algorithm, test.data, got, test.want)
+		}
+		if len(stats.Warnings) != 1 || !strings.Contains(stats.Warnings[0], "deprecated") {
+			t.Errorf("%s: legacy data gave the warnings %q", test.algorithm, stats.Warnings)
+		}
+	}
+
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress([]byte(legacy[7].want), Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, stats, err := Decompress(compressed, Options{Algorithm: algorithm}); err != nil || len(stats.Warnings) != 0 {
+			t.Errorf("%s: current output decoded with %v and the warnings %q", algorithm, err, stats.Warnings)
+		}
+	}
+	if _, _, err := Decompress([]byte{0x89, 'L', 'Z', 'S', 0x07, 0x00}, Options{Algorithm: "lzss"}); err == nil {
+		t.Error("Decompress accepted an unknown lzss format version")
+	}
+}
+
+func TestLZSSByteOffsets(t *testing.T) {
+	// repeats that start and end inside multi-byte characters and invalid UTF-8
+	inputs := []string{
+		strings.Repeat("日本語のテキスト", 20),
+		strings.Repeat("ö\xffé\x80", 30) + "é" + strings.Repeat("\xa9é", 25),
+		"\xc3" + strings.Repeat("\xa9\xc3", 40),
+	}
+	for _, input := range inputs {
+		compressed, _, err := Compress([]byte(input), Options{Algorithm: "lzss"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) >= len(input) {
+			t.Errorf("lzss did not find the repeats in %q", input[:12])
+		}
+		output, _, err := Decompress(compressed, Options{Algorithm: "lzss"})
+		if err != nil {
+			t.Fatalf("Decompress of %q: %v", input[:12], err)
+		}
+		if string(output) != input {
+			t.Errorf("lzss did not round trip %q", input[:12])
+		}
+	}
+}
+
+func TestGzipModTime(t *testing.T) {
+	data := []byte(strings.Repeat("stamped with a time, ", 20))
+	modTime := time.Date(2024, 2, 29, 12, 30, 15, 0, time.UTC)
+	compressed, _, err := Compress(data, Options{Algorithm: "gzip", ModTime: modTime.Add(500 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := stdgzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reader.ModTime.Equal(modTime) {
+		t.Errorf("compress/gzip read the MTIME %v, want %v", reader.ModTime, modTime)
+	}
+	for _, concurrency := range []int{0, 4} {
+		_, stats, err := Decompress(compressed, Options{Algorithm: "gzip", Concurrency: concurrency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stats.ModTime.Equal(modTime) {
+			t.Errorf("concurrency %d: Decompress reported the MTIME %v, want %v", concurrency, stats.ModTime, modTime)
+		}
+	}
+
+	// without a time, or one MTIME cannot hold, the field stays unset
+	for _, unset := range []time.Time{{}, time.Unix(-1, 0)} {
+		compressed, _, err := Compress(data, Options{Algorithm: "gzip", ModTime: unset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, stats, err := Decompress(compressed, Options{Algorithm: "gzip"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stats.ModTime.IsZero() {
+			t.Errorf("ModTime %v was decoded as %v", unset, stats.ModTime)
+		}
+	}
+}
+
+func TestStatsOnly(t *testing.T) {
+	data := []byte(strings.Repeat("only the sizes are wanted, ", 50))
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress(data, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, stats, err := Compress(data, Options{Algorithm: algorithm, StatsOnly: true})
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if output != nil || stats.ProcessedSize != int64(len(compressed)) || stats.OriginalSize != int64(len(data)) {
+			t.Errorf("%s: stats only gave %d bytes of output and sizes %d/%d, want none and %d/%d",
+				algorithm, len(output), stats.ProcessedSize, stats.OriginalSize, len(compressed), len(data))
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	data := []byte(strings.Repeat("abababab, ", 300))
+	for _, algorithm := range SupportedAlgorithms {
+		_, stats, err := Compress(data, Options{Algorithm: algorithm, Profile: true})
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		profile := stats.Profile
+		if profile.ByteHistogram['a'] != 1200 || profile.ByteHistogram[' '] != 300 || math.Abs(profile.Entropy-1.7219) > 0.001 {
+			t.Errorf("%s: histogram a=%d ' '=%d, entropy %.4f", algorithm, profile.ByteHistogram['a'], profile.ByteHistogram[' '], profile.Entropy)
+		}
+		if algorithm == "huffman" || algorithm == "lzss" {
+			if profile.Literals != 0 || profile.HeaderBytes != 0 {
+				t.Errorf("%s: match statistics for an algorithm that has none: %+v", algorithm, profile)
+			}
+			continue
+		}
+		// every coded byte is a literal or covered by a match, and the
+		// blocks are accounted for bit by bit
+		matched := int64(math.Round(profile.AverageMatchLength * float64(profile.Matches)))
+		blocks := profile.FixedBlocks + profile.DynamicBlocks
+		if profile.Literals+matched != int64(len(data)) || blocks != 1 || profile.AverageMatchDistance < 1 {
+			t.Errorf("%s: %d literals and %d matched bytes in %d blocks, want %d bytes in 1", algorithm, profile.Literals, matched, blocks, len(data))
+		}
+		if profile.HeaderBytes+profile.HuffmanTableBytes >= stats.ProcessedSize {
+			t.Errorf("%s: %d header and %d table bytes of %d", algorithm, profile.HeaderBytes, profile.HuffmanTableBytes, stats.ProcessedSize)
+		}
+	}
+
+	// a stored block is all header and passed through bytes
+	_, stats, err := Compress(data, Options{Algorithm: "gzip", Store: true, Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := stats.Profile; profile.StoredBlocks != 1 || profile.LiteralRatio != 0 || profile.HeaderBytes+int64(len(data)) != stats.ProcessedSize {
+		t.Errorf("stored: %+v of %d bytes", profile, stats.ProcessedSize)
+	}
+}
+
+// TestLongInputWithinSpec compresses an input that is larger than the 32KB
+// window and repeats itself from just inside the maximum distance, and checks
+// that compress/flate reads the output back
+func TestLongInputWithinSpec(t *testing.T) {
+	block := make([]byte, 32760)
+	rand.New(rand.NewSource(1)).Read(block)
+	input := bytes.Repeat(block, 3)
+
+	compressed, _, err := Compress(input, Options{Algorithm: "flate"})
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	if len(compressed) > len(block)*3/2 {
+		t.Errorf("compressed %d bytes to %d, the repeats were not matched", len(input), len(compressed))
+	}
+	decompressed, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatalf("compress/flate rejected the output: %v", err)
+	}
+	if !bytes.Equal(decompressed, input) {
+		t.Fatalf("compress/flate decoded %d bytes, want the %d bytes of input", len(decompressed), len(input))
+	}
+	if decompressed, _, err = Decompress(compressed, Options{Algorithm: "flate"}); err != nil || !bytes.Equal(decompressed, input) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+}
+
+func TestVerifyCompat(t *testing.T) {
+	for _, result := range VerifyCompat() {
+		if result.Error != "" {
+			t.Errorf("%s of %q at level %d: %s", result.Algorithm, result.Sample, result.Level, result.Error)
+		}
+	}
+}
+
+// TestGzipMembers decodes gzip files made of several members, as they are
+// produced by appending to a .gz, and checks every member's trailer
+func TestGzipMembers(t *testing.T) {
+	// the stored member of compress/gzip holds a lookalike of a member header
+	parts := [][]byte{[]byte("first member\n"), []byte(strings.Repeat("second member\n", 20)), {}, []byte("last \x1f\x8b\x08\x00")}
+	var ours, stdlib []byte
+	for _, part := range parts {
+		member, _, err := Compress(part, Options{Algorithm: "gzip"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ours = append(ours, member...)
+		member, err = stdlibGzip(part, stdflate.NoCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdlib = append(stdlib, member...)
+	}
+	want := bytes.Join(parts, nil)
+	for name, data := range map[string][]byte{"ours": ours, "compress/gzip": stdlib} {
+		for _, concurrency := range []int{0, 4} {
+			got, _, err := Decompress(data, Options{Algorithm: "gzip", Concurrency: concurrency})
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%s: Decompress with concurrency %d = %q, %v, want %q", name, concurrency, got, err, want)
+			}
+		}
+		// the members were decoded in parallel, not one after another
+		if got, _, ok := decompressMembers(context.Background(), data, 4, 0); !ok || !bytes.Equal(got, want) {
+			t.Errorf("%s: decompressMembers = %q, %v", name, got, ok)
+		}
+	}
+
+	for _, concurrency := range []int{0, 4} {
+		// a damaged trailer of a middle member is caught
+		firstLength := len(ours) - len(ours[bytes.Index(ours[1:], []byte{0x1f, 0x8b})+1:])
+		damaged := bytes.Clone(ours)
+		damaged[firstLength-5] ^= 1
+		if _, _, err := Decompress(damaged, Options{Algorithm: "gzip", Concurrency: concurrency}); err == nil || !strings.Contains(err.Error(), "crc did not match") {
+			t.Errorf("Decompress with a damaged first trailer and concurrency %d = %v, want a crc mismatch", concurrency, err)
+		}
+		// trailing bytes that are not a member are rejected
+		if _, _, err := Decompress(append(bytes.Clone(ours), 0, 0), Options{Algorithm: "gzip", Concurrency: concurrency}); err == nil {
+			t.Errorf("Decompress with concurrency %d accepted trailing garbage", concurrency)
+		}
+		// and a truncated last member is reported as such
+		var truncated *TruncatedError
+		if _, _, err := Decompress(ours[:len(ours)-3], Options{Algorithm: "gzip", Concurrency: concurrency}); !errors.As(err, &truncated) {
+			t.Errorf("Decompress of a truncated member with concurrency %d = %v, want a TruncatedError", concurrency, err)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	payloads := [][]byte{
+		[]byte(strings.Repeat("first batch of log lines\n", 20)),
+		{},
+		[]byte("second batch\n"),
+		[]byte(strings.Repeat("third batch, compressed on its own\n", 15)),
+	}
+	for _, algorithm := range []string{"gzip", "flate"} {
+		var output bytes.Buffer
+		writer, err := NewConcatWriter(&output, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		for _, payload := range payloads {
+			if _, err := writer.Append(context.Background(), payload); err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+		}
+		// a stream not closed yet is continued by another writer
+		split, err := SplitPayloads(context.Background(), output.Bytes(), Options{Algorithm: algorithm})
+		if err != nil || len(split) != len(payloads) {
+			t.Fatalf("%s: split the open stream into %d payloads, %v", algorithm, len(split), err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		split, err = SplitPayloads(context.Background(), output.Bytes(), Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if len(split) != len(payloads) {
+			t.Fatalf("%s: split into %d payloads, want %d", algorithm, len(split), len(payloads))
+		}
+		for i := range payloads {
+			if !bytes.Equal(split[i], payloads[i]) {
+				t.Errorf("%s: payload %d is %q, want %q", algorithm, i, split[i], payloads[i])
+			}
+		}
+
+		// other decoders read the payloads one after another
+		var reader io.Reader = stdflate.NewReader(bytes.NewReader(output.Bytes()))
+		if algorithm == "gzip" {
+			if reader, err = stdgzip.NewReader(bytes.NewReader(output.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+		}
+		joined, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(joined, bytes.Join(payloads, nil)) {
+			t.Errorf("%s: the standard library decoded %d bytes, %v", algorithm, len(joined), err)
+		}
+		if _, err := SplitPayloads(context.Background(), output.Bytes(), Options{Algorithm: algorithm, MaxDecodedSize: 100}); !errors.As(err, new(*SizeLimitError)) {
+			t.Errorf("%s: splitting past the limit returned %v", algorithm, err)
+		}
+	}
+	if _, err := NewConcatWriter(io.Discard, Options{Algorithm: "lzss"}); !errors.Is(err, ErrConcatUnsupported) {
+		t.Errorf("lzss concatenated with %v", err)
+	}
+}
+
+func TestTruncatedInput(t *testing.T) {
+	input := []byte(strings.Repeat("truncated <streams> \\ still decode up to the cut. ", 40))
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatalf("%s: Compress: %v", algorithm, err)
+		}
+		for _, cut := range []int{1, len(compressed) / 3, len(compressed) / 2, len(compressed) - 1} {
+			var truncated *TruncatedError
+			_, _, err := Decompress(compressed[:cut], Options{Algorithm: algorithm})
+			if !errors.As(err, &truncated) {
+				t.Errorf("%s cut at %d: got %v, want a TruncatedError", algorithm, cut, err)
+				continue
+			}
+
+			partial, _, err := Decompress(compressed[:cut], Options{Algorithm: algorithm, Salvage: true})
+			if !errors.As(err, &truncated) {
+				t.Errorf("%s cut at %d with salvage: got %v, want a TruncatedError", algorithm, cut, err)
+				continue
+			}
+			if truncated.Decoded != int64(len(partial)) || !bytes.HasPrefix(input, partial) {
+				t.Errorf("%s cut at %d: salvaged %d bytes (reported %d) that are not a prefix of the input", algorithm, cut, len(partial), truncated.Decoded)
+			}
+		}
+	}
+}
+
+func TestRecoverDamagedMembers(t *testing.T) {
+	var data []byte
+	var members [][]byte
+	for _, part := range []string{"first member, ", "second member, ", "third member"} {
+		member, _, err := Compress([]byte(strings.Repeat(part, 20)), Options{Algorithm: "gzip"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, member)
+		data = append(data, member...)
+	}
+	// the trailer of the second member no longer matches its content
+	second := len(members[0])
+	data[second+len(members[1])-5] ^= 0xff
+
+	if _, _, err := Decompress(data, Options{Algorithm: "gzip"}); err == nil {
+		t.Fatal("damaged input decoded without recover")
+	}
+	got, stats, err := Decompress(data, Options{Algorithm: "gzip", Recover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("first member, ", 20) + strings.Repeat("third member", 20); string(got) != want {
+		t.Errorf("recovered %q", got)
+	}
+	if len(stats.Damage) != 1 || stats.Damage[0].Offset != int64(second) || stats.Damage[0].Size != int64(len(members[1])) {
+		t.Errorf("damage %+v, want the second member at %d", stats.Damage, second)
+	}
+
+	// damage up to the end is skipped as well
+	got, stats, err = Decompress(append(bytes.Clone(members[0]), "trailing garbage"...), Options{Algorithm: "gzip", Recover: true})
+	if err != nil || string(got) != strings.Repeat("first member, ", 20) || len(stats.Damage) != 1 || stats.Damage[0].Size != 16 {
+		t.Errorf("trailing garbage recovered %q with the damage %+v: %v", got, stats.Damage, err)
+	}
+
+	// the other algorithms are salvaged
+	compressed, _, err := Compress([]byte(strings.Repeat("salvaged ", 50)), Options{Algorithm: "lzss"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var truncated *TruncatedError
+	if partial, _, err := Decompress(compressed[:len(compressed)/2], Options{Algorithm: "lzss", Recover: true}); !errors.As(err, &truncated) || len(partial) == 0 {
+		t.Errorf("lzss recovered %d bytes: %v", len(partial), err)
+	}
+}
+
+func TestIgnoreChecksums(t *testing.T) {
+	var data []byte
+	var want string
+	var first int
+	for _, part := range []string{"first member, ", "second member, "} {
+		first = len(data)
+		member, _, err := Compress([]byte(strings.Repeat(part, 20)), Options{Algorithm: "gzip"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, member...)
+		want += strings.Repeat(part, 20)
+	}
+	// the CRC of the second member, then the ISIZE of the first
+	data[len(data)-5] ^= 0xff
+	data[first-4]++
+
+	if _, _, err := Decompress(data, Options{Algorithm: "gzip"}); err == nil {
+		t.Fatal("mismatching trailers decoded without ignoring them")
+	}
+	got, stats, err := Decompress(data, Options{Algorithm: "gzip", IgnoreChecksums: true, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("decoded %q", got)
+	}
+	mismatches := stats.ChecksumMismatches
+	if len(mismatches) != 2 || len(stats.Warnings) != 2 {
+		t.Fatalf("mismatches %+v, warnings %q", mismatches, stats.Warnings)
+	}
+	if m := mismatches[0]; m.Member != 0 || m.ExpectedSize != m.ActualSize+1 || m.ExpectedCRC != m.ActualCRC {
+		t.Errorf("first member %+v, want the size off by one", m)
+	}
+	if m := mismatches[1]; m.Member != 1 || m.ExpectedSize != m.ActualSize || m.ExpectedCRC^m.ActualCRC != 0xff000000 {
+		t.Errorf("second member %+v, want the top byte of the CRC flipped", m)
+	}
+}
+
+// deflateBits packs fields LSB first the way deflate headers are written,
+// each entry is a value followed by its width in bits
+func deflateBits(fields ...uint32) []byte {
+	var out []byte
+	var bits, nbits uint32
+	for i := 0; i < len(fields); i += 2 {
+		bits |= fields[i] << nbits
+		nbits += fields[i+1]
+		for nbits >= 8 {
+			out = append(out, byte(bits))
+			bits >>= 8
+			nbits -= 8
+		}
+	}
+	return append(out, byte(bits), 0, 0, 0, 0)
+}
+
+func TestMalformedDeflateHeaders(t *testing.T) {
+	// BFINAL=1, BTYPE=2 (dynamic) and HLIT, HDIST, HCLEN as given
+	header := func(hlit, hdist, hclen uint32) []uint32 {
+		return []uint32{1, 1, 2, 2, hlit, 5, hdist, 5, hclen, 4}
+	}
+	cases := []struct {
+		name   string
+		fields []uint32
+		want   error
+	}{
+		{"HLIT beyond 286", header(30, 0, 0), flate.ErrInvalidHeader},
+		{"HDIST beyond 30", header(0, 30, 0), flate.ErrInvalidHeader},
+		{
+			// lengths for 16, 17, 18, 0: all 1 claim four codes of one bit
+			"over-subscribed code length code",
+			append(header(0, 0, 0), 1, 3, 1, 3, 1, 3, 1, 3),
+			huffman.ErrOversubscribed,
+		},
+		{
+			// 16 and 0 get one bit each, the first code is 16
+			"repeat before any length",
+			append(header(0, 0, 0), 1, 3, 0, 3, 0, 3, 1, 3, 1, 1, 0, 2),
+			flate.ErrInvalidHeader,
+		},
+		{
+			// 18 and 0 get one bit each, two runs of 138 zeros overshoot the 258 lengths
+			"run past HLIT+HDIST",
+			append(header(0, 0, 0), 0, 3, 0, 3, 1, 3, 1, 3, 1, 1, 127, 7, 1, 1, 127, 7),
+			flate.ErrInvalidHeader,
+		},
+		{
+			// 138 + 120 zeros leave the end-of-block symbol without a code
+			"no end-of-block code",
+			append(header(0, 0, 0), 0, 3, 0, 3, 1, 3, 1, 3, 1, 1, 127, 7, 1, 1, 109, 7),
+			flate.ErrInvalidHeader,
+		},
+	}
+	for _, c := range cases {
+		_, _, err := Decompress(deflateBits(c.fields...), Options{Algorithm: "flate"})
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+// TestPairMisuse checks that the concurrency contract of AlgorithmFactory is
+// enforced with errors instead of deadlocks
+func TestPairMisuse(t *testing.T) {
+	within := func(t *testing.T, what string, f func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is still blocked", what)
+		}
+	}
+
+	for _, algorithm := range SupportedAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			checkNoLeaks(t)
+			factory, err := factoryFor(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the writer is closed twice and written to afterwards
+			reader, writer := factory.NewCompressionReaderAndWriter(Options{Algorithm: algorithm})
+			go io.Copy(io.Discard, reader)
+			writer.Write([]byte("data"))
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			within(t, "second Close", func() {
+				if err := writer.Close(); err == nil {
+					t.Error("second Close succeeded")
+				}
+			})
+			within(t, "Write after Close", func() {
+				if _, err := writer.Write([]byte("more")); err == nil {
+					t.Error("Write after Close succeeded")
+				}
+			})
+			reader.Close()
+
+			// the reader is closed while a Read waits for a writer that never closes
+			reader, writer = factory.NewDecompressionReaderAndWriter(Options{Algorithm: algorithm})
+			readErr := make(chan error, 1)
+			go func() {
+				_, err := reader.Read(make([]byte, 16))
+				readErr <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+			reader.Close()
+			within(t, "Read on a closed reader", func() {
+				if err := <-readErr; err == nil {
+					t.Error("Read on a closed reader succeeded")
+				}
+			})
+			within(t, "Close of the writer", func() { writer.Close() })
+		})
+	}
+}
+
+// TestFlateBackpressure checks that the flate writer hands its output over as
+// the reader takes it, instead of buffering all of it before Close returns
+func TestFlateBackpressure(t *testing.T) {
+	checkNoLeaks(t)
+	input := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(input)
+	reader, writer := flate.NewCompressionReaderAndWriter(0, 1)
+	closed := make(chan error, 1)
+	go func() {
+		writer.Write(input)
+		closed <- writer.Close()
+	}()
+
+	// stored blocks are larger than the input, so Close cannot finish
+	// before most of them are read
+	first := make([]byte, 1024)
+	if _, err := io.ReadFull(reader, first); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned %v before the output was read", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(append(first, rest...))))
+	if err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("output does not decode to the input: %v", err)
+	}
+	reader.Close()
+
+	// a reader that gives up releases a writer blocked on it
+	reader, writer = flate.NewCompressionReaderAndWriter(0, 1)
+	go func() {
+		writer.Write(input)
+		closed <- writer.Close()
+	}()
+	reader.Read(first)
+	reader.Close()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Error("Close succeeded although the reader was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close is still blocked on a closed reader")
+	}
+}
+
+// TestFlateStreaming checks that the flate writer codes its input as it is
+// written, holding no more than a window of it however long it is, and that
+// matches across the segments it codes at a time decode
+func TestFlateStreaming(t *testing.T) {
+	checkNoLeaks(t)
+	chunk := make([]byte, 16<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	text := []byte(strings.Repeat("segments are coded as the writes come in. ", 4000))
+	for _, test := range []struct {
+		name   string
+		btype  uint32
+		chunk  []byte
+		writes int
+		peak   int64 // the most the writer may hold, 0 for no limit
+	}{
+		// 4 MiB of input, held a window at a time
+		{"stored", 0, chunk, 256, 1 << 20},
+		// the matcher holds its chains and the tokens of a segment
+		{"dynamic", flate.AutoBlockType, text[:1000], len(text) / 1000, 1 << 20},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reader, writer := flate.NewCompressionReaderAndWriter(test.btype, 1)
+			var output []byte
+			read := make(chan error, 1)
+			go func() {
+				var err error
+				output, err = io.ReadAll(reader)
+				read <- err
+			}()
+			var input []byte
+			for i := range test.writes {
+				// writes of every size, so segments end anywhere in them
+				part := test.chunk[:len(test.chunk)-i%7]
+				if _, err := writer.Write(part); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				input = append(input, part...)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if err := <-read; err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(output)))
+			if err != nil || !bytes.Equal(decoded, input) {
+				t.Fatalf("output does not decode to the input: %v", err)
+			}
+			if peak := writer.(*flate.CompressionWriter).MemoryUsage().PeakBytes; test.peak > 0 && peak > test.peak {
+				t.Errorf("held %d bytes for %d bytes of input", peak, len(input))
+			}
+		})
+	}
+}
+
+// TestInflateStreaming checks that the decompression writer decodes blocks as
+// the writes complete them, holding a window of the output and a block at a
+// time, so it holds no more for a stream eight times as long
+func TestInflateStreaming(t *testing.T) {
+	checkNoLeaks(t)
+	chunk := make([]byte, 16<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	text := []byte(strings.Repeat("blocks are decoded as the writes complete them. ", 400))
+	var input []byte
+	for len(input) < 8<<20 {
+		input = append(append(input, chunk...), text...)
+	}
+	for _, test := range []struct {
+		name  string
+		level int
+	}{
+		{"stored", stdflate.NoCompression},
+		{"dynamic", stdflate.DefaultCompression},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var peaks []int64
+			for _, size := range []int{1 << 20, 8 << 20} {
+				compressed, err := stdlibFlate(input[:size], test.level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader, writer := flate.NewDecompressionReaderAndWriter()
+				var output []byte
+				read := make(chan error, 1)
+				go func() {
+					var err error
+					output, err = io.ReadAll(reader)
+					read <- err
+				}()
+				for i := 0; len(compressed) > 0; i++ {
+					// writes of every size, so blocks end anywhere in them
+					n := min(4096-i%7, len(compressed))
+					if _, err := writer.Write(compressed[:n]); err != nil {
+						t.Fatalf("Write: %v", err)
+					}
+					compressed = compressed[n:]
+				}
+				if err := writer.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+				if err := <-read; err != nil || !bytes.Equal(output, input[:size]) {
+					t.Fatalf("output does not match the input: %v", err)
+				}
+				peaks = append(peaks, writer.(*flate.DecompressionWriter).MemoryUsage().PeakBytes)
+			}
+			if peaks[1] > peaks[0]+codetables.MaxDistance {
+				t.Errorf("held %d bytes for 8 MiB of output, %d for 1 MiB", peaks[1], peaks[0])
+			}
+		})
+	}
+}
+
+// TestStreamingFeature checks that the algorithms /algorithms lists as
+// streaming hand output on while the input is still being written
+func TestStreamingFeature(t *testing.T) {
+	chunk := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	for _, info := range Algorithms() {
+		if !info.Features.Streaming {
+			continue
+		}
+		t.Run(info.Name, func(t *testing.T) {
+			checkNoLeaks(t)
+			factory, err := factoryFor(info.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, writer := factory.NewCompressionReaderAndWriter(Options{Algorithm: info.Name})
+			// closed once a read returned bytes, a read that failed first
+			// only drains the reader
+			output := make(chan struct{})
+			go func() {
+				buf := make([]byte, 4096)
+				for {
+					n, err := reader.Read(buf)
+					if n > 0 {
+						close(output)
+						break
+					}
+					if err != nil {
+						break
+					}
+				}
+				io.Copy(io.Discard, reader)
+			}()
+			streamed := false
+			for range 64 {
+				if _, err := writer.Write(chunk); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				select {
+				case <-output:
+					streamed = true
+				default:
+				}
+				if streamed {
+					break
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if !streamed {
+				t.Errorf("no output came out of %d MiB of input before Close", 64*len(chunk)>>20)
+			}
+		})
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	input := []byte(strings.Repeat("many requests share the factories. ", 50))
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(SupportedAlgorithms))
+	for range 4 {
+		for _, algorithm := range SupportedAlgorithms {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				options := Options{Algorithm: algorithm}
+				compressed, _, err := Compress(input, options)
+				if err == nil {
+					var decompressed []byte
+					decompressed, _, err = Decompress(compressed, options)
+					if err == nil && !bytes.Equal(decompressed, input) {
+						err = errors.New("round trip mismatch")
+					}
+				}
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", algorithm, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestStageSpans checks Compress and Decompress trace the stages of the
+// codecs under their own span
+func TestStageSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	data := []byte(strings.Repeat("stages of a traced compression, ", 50))
+	compressed, _, err := Compress(data, Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Compress(data, Options{Algorithm: "huffman"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decompress(compressed, Options{Algorithm: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+
+	parents := make(map[string]string)
+	names := make(map[[8]byte]string)
+	for _, span := range recorder.Ended() {
+		names[span.SpanContext().SpanID()] = span.Name()
+	}
+	for _, span := range recorder.Ended() {
+		parents[span.Name()] = names[span.Parent().SpanID()]
+	}
+	want := map[string]string{
+		"compression.Compress":   "",
+		"compression.Decompress": "",
+		"gzip.checksum":          "compression.Compress",
+		"flate.match":            "compression.Compress",
+		"flate.huffman_build":    "compression.Compress",
+		"flate.write_bits":       "compression.Compress",
+		"huffman.build":          "compression.Compress",
+		"huffman.encode":         "compression.Compress",
+		"flate.inflate":          "compression.Decompress",
+	}
+	for name, parent := range want {
+		got, ok := parents[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+		} else if got != parent {
+			t.Errorf("%s span has parent %q, want %q", name, got, parent)
+		}
+	}
+}
+
+// TestHistograms checks a compression records its ratio, input size and the
+// durations of its stages under its algorithm
+func TestHistograms(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	data := []byte(strings.Repeat("histograms per algorithm, ", 40))
+	if _, _, err := Compress(data, Options{Algorithm: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatal(err)
+	}
+
+	stages := make(map[string]bool)
+	found := make(map[string]bool)
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			found[m.Name] = true
+			if h, ok := m.Data.(metricdata.Histogram[float64]); ok && m.Name == "compression.stage.duration" {
+				for _, point := range h.DataPoints {
+					if algorithm, _ := point.Attributes.Value("algorithm"); algorithm.AsString() != "gzip" {
+						t.Errorf("stage timed under algorithm %q, want gzip", algorithm.AsString())
+					}
+					stage, _ := point.Attributes.Value("stage")
+					stages[stage.AsString()] = true
+				}
+			}
+		}
+	}
+	for _, name := range []string{"compression.ratio", "compression.input.size", "compression.stage.duration"} {
+		if !found[name] {
+			t.Errorf("no %s histogram", name)
+		}
+	}
+	for _, stage := range []string{"compression.Compress", "flate.match", "flate.huffman_build", "flate.write_bits", "gzip.checksum"} {
+		if !stages[stage] {
+			t.Errorf("stage %s not timed", stage)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	data := []byte(strings.Repeat("which algorithm made this? ", 20))
+	for _, algorithm := range []string{"gzip", "huffman", "flate", "lzss", "zlib"} {
+		for _, input := range [][]byte{data, []byte("aaaa")} {
+			compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Detect(compressed); got != algorithm {
+				t.Errorf("Detect of %s output of %q = %q, want %q", algorithm, input[:4], got, algorithm)
+			}
+		}
+	}
+	for _, plain := range [][]byte{nil, data, []byte("{\"json\": true}")} {
+		if got := Detect(plain); got != "" {
+			t.Errorf("Detect(%q) = %q, want nothing", plain[:min(len(plain), 10)], got)
+		}
+	}
+}
+
+func TestDeflateFramings(t *testing.T) {
+	data := []byte(strings.Repeat("framed or not framed? ", 30))
+	for _, framing := range []string{"flate", "gzip", "zlib"} {
+		compressed, _, err := Compress(data, Options{Algorithm: framing})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DetectFraming(compressed); got != framing {
+			t.Errorf("DetectFraming of %s output = %q", framing, got)
+		}
+		for _, requested := range []string{"flate", "gzip", "zlib", AutoAlgorithm} {
+			output, stats, err := Decompress(compressed, Options{Algorithm: requested})
+			if err != nil {
+				t.Fatalf("%s output decompressed as %s: %v", framing, requested, err)
+			}
+			if !bytes.Equal(output, data) {
+				t.Errorf("%s output decompressed as %s did not round trip", framing, requested)
+			}
+			if warned := len(stats.Warnings) > 0; warned != (requested != framing && requested != AutoAlgorithm) {
+				t.Errorf("%s output decompressed as %s warned %v", framing, requested, stats.Warnings)
+			}
+		}
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	data := []byte(strings.Repeat("how much memory does this take? ", 40))
+	windows := map[string]int{"huffman": 0, "lzss": 4096, "flate": 32768, "gzip": 32768, "zlib": 32768}
+	for _, algorithm := range SupportedAlgorithms {
+		options := Options{Algorithm: algorithm}
+		compressed, stats, err := Compress(data, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// at the very least the input and its copy are held at once
+		if stats.PeakBufferBytes < 2*int64(len(data)) || stats.Allocations == 0 || stats.WindowSize != windows[algorithm] {
+			t.Errorf("%s: compressing %d bytes reported %+v", algorithm, len(data), stats)
+		}
+		_, stats, err = Decompress(compressed, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.PeakBufferBytes < int64(len(data)) || stats.Allocations == 0 {
+			t.Errorf("%s: decompressing to %d bytes reported %+v", algorithm, len(data), stats)
+		}
+	}
+}
+
+// TestTinyInputFastPath checks that tiny inputs skip the dynamic tables,
+// which would take up more than the matches can save
+func TestTinyInputFastPath(t *testing.T) {
+	text := []byte("The quick brown fox jumps over the lazy dog")
+	random := rand.New(rand.NewSource(1))
+	binary := make([]byte, 100)
+	for i := range binary {
+		binary[i] = byte(0x90 + random.Intn(0x70)) // only bytes with 9 bit fixed codes
+	}
+	for _, test := range []struct {
+		input []byte
+		btype byte
+	}{{text, 1}, {binary, 0}, {nil, 1}} {
+		fast, _, err := Compress(test.input, Options{Algorithm: "flate"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if btype := fast[0] >> 1 & 3; btype != test.btype {
+			t.Errorf("%d bytes went into a block of type %d, want %d", len(test.input), btype, test.btype)
+		}
+		decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(fast)))
+		if err != nil || !bytes.Equal(decoded, test.input) {
+			t.Errorf("compress/flate decoded %d bytes to %q, %v", len(test.input), decoded, err)
+		}
+		if decoded, _, err := Decompress(fast, Options{Algorithm: "flate"}); err != nil || !bytes.Equal(decoded, test.input) {
+			t.Errorf("%d bytes decompressed to %q, %v", len(test.input), decoded, err)
+		}
+		// matching could pick the same blocks, only dynamic codes are sure to cost more
+		slow, _, err := Compress(test.input, Options{Algorithm: "flate", BType: 2, TinyInputSize: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fast) >= len(slow) {
+			t.Errorf("%d bytes compressed to %d with the fast path and %d with a dynamic block", len(test.input), len(fast), len(slow))
+		}
+	}
+}
+
+func TestBFinal(t *testing.T) {
+	text := []byte(strings.Repeat("only the last block is final. ", 100))
+	stored := make([]byte, 150000) // three stored blocks
+	rand.New(rand.NewSource(1)).Read(stored)
+	for _, test := range []struct {
+		options Options
+		input   []byte
+	}{
+		{Options{Algorithm: "flate"}, text},
+		{Options{Algorithm: "flate", TinyInputSize: -1}, text},
+		{Options{Algorithm: "flate", Store: true}, stored},
+	} {
+		compressed, _, err := Compress(test.input, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// compress/flate stops at the first final block and fails without one
+		decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(decoded, test.input) {
+			t.Errorf("%+v: compress/flate decoded %d of %d bytes, %v", test.options, len(decoded), len(test.input), err)
+		}
+	}
+
+	// a partial stream ends on an empty stored block, another stream follows it
+	first, _, err := Compress(text, Options{Algorithm: "flate", Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(first, []byte{0, 0, 0xff, 0xff}) {
+		t.Errorf("partial stream ends on %x", first[len(first)-4:])
+	}
+	if _, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(first))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("compress/flate read a partial stream with %v", err)
+	}
+	rest, _, _ := Compress([]byte("and this one"), Options{Algorithm: "flate"})
+	want := string(text) + "and this one"
+	decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(append(first, rest...))))
+	if err != nil || string(decoded) != want {
+		t.Errorf("compress/flate decoded the streams to %d bytes, %v", len(decoded), err)
+	}
+	if decoded, _, err := Decompress(append(first, rest...), Options{Algorithm: "flate"}); err != nil || string(decoded) != want {
+		t.Errorf("the streams decompressed to %d bytes, %v", len(decoded), err)
+	}
+
+	for _, algorithm := range []string{"gzip", "zlib"} {
+		if _, _, err := Compress(text, Options{Algorithm: algorithm, Partial: true}); !errors.Is(err, ErrPartialUnsupported) {
+			t.Errorf("%s with Partial: %v", algorithm, err)
+		}
+	}
+	var options Options
+	if err := ApplySetting(&options, "bfinal", "0"); err != nil || !options.Partial {
+		t.Errorf("bfinal=0 set Partial to %v, %v", options.Partial, err)
+	}
+	if err := ApplySetting(&options, "bfinal", "2"); err == nil {
+		t.Error("bfinal=2 was accepted")
+	}
+}
+
+func TestStoredBlocks(t *testing.T) {
+	input := make([]byte, 100000) // two stored blocks from compress/flate
+	rand.New(rand.NewSource(2)).Read(input)
+	var stored bytes.Buffer
+	w, _ := stdflate.NewWriter(&stored, stdflate.NoCompression)
+	w.Write(input)
+	w.Close()
+	decoded, _, err := Decompress(stored.Bytes(), Options{Algorithm: "flate"})
+	if err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("stored blocks of compress/flate decompressed to %d of %d bytes, %v", len(decoded), len(input), err)
+	}
+
+	// BFINAL=1, BTYPE=0, then LEN 3 and NLEN, its complement
+	block := []byte{1, 3, 0, 0xfc, 0xff, 'a', 'b', 'c'}
+	if decoded, _, err := Decompress(block, Options{Algorithm: "flate"}); err != nil || string(decoded) != "abc" {
+		t.Errorf("stored block decompressed to %q, %v", decoded, err)
+	}
+	block[4] = 0xfe
+	if _, _, err := Decompress(block, Options{Algorithm: "flate"}); !errors.Is(err, flate.ErrStoredLength) {
+		t.Errorf("stored block with NLEN %x: %v", block[3:5], err)
+	}
+}
+
+// TestFixedBlocks checks that BType 1 codes matches with the fixed Huffman
+// codes too, in a single block that compress/flate reads back
+func TestFixedBlocks(t *testing.T) {
+	input := []byte(strings.Repeat("fixed codes need no tables. ", 200))
+	compressed, stats, err := Compress(input, Options{Algorithm: "flate", BType: 1, Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := stats.Profile; profile.FixedBlocks != 1 || profile.DynamicBlocks != 0 || profile.Matches == 0 {
+		t.Errorf("%d fixed and %d dynamic blocks with %d matches, want 1 fixed block with matches", profile.FixedBlocks, profile.DynamicBlocks, profile.Matches)
+	}
+	decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("compress/flate decoded %d of %d bytes, %v", len(decoded), len(input), err)
+	}
+	if decoded, _, err := Decompress(compressed, Options{Algorithm: "flate"}); err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("round trip decoded %d of %d bytes, %v", len(decoded), len(input), err)
+	}
+}
+
+// TestBlockSize checks that BlockSize cuts the output into blocks of at most
+// that much input, whatever their type, with only the last one final
+func TestBlockSize(t *testing.T) {
+	input := []byte(strings.Repeat("every block gets codes of its own. ", 600))
+	for _, options := range []Options{
+		{Algorithm: "flate"},
+		{Algorithm: "flate", BType: 1},
+		{Algorithm: "flate", Store: true},
+		{Algorithm: "gzip", BType: 2},
+	} {
+		options.BlockSize, options.Profile = 4096, true
+		compressed, stats, err := Compress(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile := stats.Profile
+		blocks := profile.StoredBlocks + profile.FixedBlocks + profile.DynamicBlocks
+		if want := int64((len(input) + 4095) / 4096); blocks < want {
+			t.Errorf("%s btype %d store %v: %d blocks, want at least %d", options.Algorithm, options.BType, options.Store, blocks, want)
+		}
+		if decoded, _, err := Decompress(compressed, Options{Algorithm: options.Algorithm}); err != nil || !bytes.Equal(decoded, input) {
+			t.Errorf("%s btype %d store %v: decoded %d of %d bytes, %v", options.Algorithm, options.BType, options.Store, len(decoded), len(input), err)
+		}
+		if options.Algorithm == "flate" {
+			decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(compressed)))
+			if err != nil || !bytes.Equal(decoded, input) {
+				t.Errorf("btype %d store %v: compress/flate decoded %d of %d bytes, %v", options.BType, options.Store, len(decoded), len(input), err)
+			}
+		}
+	}
+	if _, err := ParsePipelines("huge: flate block=-1"); err == nil {
+		t.Error("a negative block size was accepted")
+	}
+}
+
+// TestAutoBlockType checks that blocks are written as whichever type comes
+// out smallest: incompressible data is stored instead of growing, text is
+// coded with Huffman codes
+func TestAutoBlockType(t *testing.T) {
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(3)).Read(random)
+	text := []byte(strings.Repeat("blocks are chosen one by one. ", 1000))
+	// a stored block costs 5 bytes on top of the data
+	storedSize := len(random) + 5
+	for _, test := range []struct {
+		name    string
+		input   []byte
+		stored  bool
+		maxSize int
+	}{
+		{"random", random, true, storedSize},
+		{"text", text, false, len(text) / 20},
+	} {
+		compressed, stats, err := Compress(test.input, Options{Algorithm: "flate", Profile: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile := stats.Profile
+		if stored := profile.StoredBlocks > 0; stored != test.stored {
+			t.Errorf("%s: %d stored, %d fixed and %d dynamic blocks", test.name, profile.StoredBlocks, profile.FixedBlocks, profile.DynamicBlocks)
+		}
+		if len(compressed) > test.maxSize {
+			t.Errorf("%s: %d bytes grew to %d", test.name, len(test.input), len(compressed))
+		}
+		decoded, err := io.ReadAll(stdflate.NewReader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(decoded, test.input) {
+			t.Errorf("%s: compress/flate decoded %d of %d bytes, %v", test.name, len(decoded), len(test.input), err)
+		}
+	}
+}
+
+func TestCorruptError(t *testing.T) {
+	input := []byte(strings.Repeat("corrupt me. ", 50))
+	for _, algorithm := range []string{"huffman", "lzss", "flate", "gzip", "zlib"} {
+		compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var corrupt *CorruptError
+		var truncated *TruncatedError
+		damaged := bytes.Clone(compressed)
+		switch algorithm {
+		case "flate":
+			damaged[0] |= 0b110 // the reserved block type
+		case "gzip", "zlib":
+			damaged[len(damaged)-1] ^= 0xff // the checksum in the trailer
+		default:
+			damaged = append(damaged, 0xff, 0xff)
+		}
+		if _, _, err := Decompress(damaged, Options{Algorithm: algorithm}); !errors.As(err, &corrupt) || corrupt.Algorithm != algorithm {
+			t.Errorf("%s: damaged data failed with %v", algorithm, err)
+		}
+		if _, _, err := Decompress(compressed[:len(compressed)/2], Options{Algorithm: algorithm}); errors.As(err, &corrupt) || !errors.As(err, &truncated) {
+			t.Errorf("%s: truncated data failed with %v", algorithm, err)
+		}
+	}
+}
+
+func TestCompressionPolicy(t *testing.T) {
+	var csv bytes.Buffer
+	for i := range 200 {
+		fmt.Fprintf(&csv, "2024-01-%02d,sensor-%d,%d\n", i%28+1, i%5, i*7%100)
+	}
+	jpeg := append([]byte{0xff, 0xd8, 0xff, 0xe0}, make([]byte, 1000)...)
+	rand.New(rand.NewSource(1)).Read(jpeg[4:])
+	for _, test := range []struct {
+		name, contentType, policy, algorithm string
+		input                                []byte
+	}{
+		{"rows.csv", "text/csv", "delta+flate", "flate", csv.Bytes()},
+		{"photo.jpg", "image/jpeg", PolicyStore, "flate", jpeg},
+		{"notes.txt", "text/plain", "flate", "flate", []byte("plain text, plain text")},
+		{"data", "application/octet-stream", "gzip", "gzip", []byte{0, 1, 2, 3}},
+	} {
+		options := Options{Algorithm: AutoAlgorithm, Filename: test.name}
+		compressed, stats, err := Compress(test.input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.ContentType != test.contentType || stats.Policy != test.policy || stats.Algorithm != test.algorithm {
+			t.Errorf("%s: got %s, %s, %s, want %s, %s, %s", test.name, stats.ContentType, stats.Policy, stats.Algorithm, test.contentType, test.policy, test.algorithm)
+		}
+		var streamed bytes.Buffer
+		if _, err := CompressStream(context.Background(), &streamed, bytes.NewReader(test.input), options); err != nil || !bytes.Equal(streamed.Bytes(), compressed) {
+			t.Errorf("%s: CompressStream differs from Compress, %v", test.name, err)
+		}
+		filter := ""
+		if test.policy == "delta+flate" {
+			filter = FilterLineDelta
+		}
+		decompressed, _, err := Decompress(compressed, Options{Algorithm: stats.Algorithm, Filter: filter})
+		if err != nil || !bytes.Equal(decompressed, test.input) {
+			t.Errorf("%s: round trip failed, %v", test.name, err)
+		}
+	}
+
+	if _, err := ParsePolicy("image/*=store,text/csv=delta+gzip,*=lzss"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParsePolicy("image/*=zip"); err == nil {
+		t.Error("a rule with an unknown action was accepted")
+	}
+
+	// without flate, text falls through to the catch-all rule
+	excluded := DefaultPolicy.Excluding([]string{"flate"})
+	if decision := excluded.Decide("notes.txt", []byte("plain text")); decision.Rule.Action != "gzip" {
+		t.Errorf("with flate excluded text is compressed with %s, want gzip", decision.Rule.Action)
+	}
+	if _, err := ParseAlgorithms("gzip, lzss"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseAlgorithms("gzip,zip"); err == nil {
+		t.Error("an unknown algorithm was accepted")
+	}
+}
+
+func TestDetectSignature(t *testing.T) {
+	parquet, err := ParseSignatures("application/x-parquet=50415231, application/x-tar=7573746172@257")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+	for _, test := range []struct {
+		name, contentType, signature string
+		head                         []byte
+	}{
+		{"app", "application/x-elf", "ELF", []byte("\x7fELF\x02\x01\x01")},
+		{"report", "application/pdf", "PDF", []byte("%PDF-1.7\n")},
+		{"data.parquet", "application/x-parquet", "application/x-parquet", []byte("PAR1\x15\x04")},
+		{"backup", "application/x-tar", "application/x-tar", tar},
+		{"export", "text/csv", "delimited text", []byte("id,name,\"city, state\"\n1,a,\"b, c\"\n2,d,e\n3,f")},
+		{"export", "text/tab-separated-values", "delimited text", []byte("id\tname\n1\ta\n2\tb\n")},
+		{"notes", "text/plain", "", []byte("one, two\nthree\nfour, five\n")},
+		{"rows.csv", "text/csv", "", []byte("a")},
+	} {
+		detection := DetectSignature(parquet, test.name, test.head)
+		if detection.ContentType != test.contentType || detection.Signature != test.signature {
+			t.Errorf("%s: detected %+v, want %s by %q", test.name, detection, test.contentType, test.signature)
+		}
+	}
+
+	// the policy detects with its signatures
+	policy := DefaultPolicy
+	policy.Signatures = parquet
+	if decision := policy.Decide("", []byte("PAR1")); decision.ContentType != "application/x-parquet" || decision.Rule.Action != "gzip" {
+		t.Errorf("parquet decided %+v", decision)
+	}
+	if decision := DefaultPolicy.Decide("", []byte("\xfd7zXZ\x00\x00")); decision.Rule.Action != PolicyStore {
+		t.Errorf("xz decided %+v", decision)
+	}
+	for _, spec := range []string{"", "parquet=50415231", "application/x=zz", "application/x=50@512"} {
+		if _, err := ParseSignatures(spec); err == nil {
+			t.Errorf("ParseSignatures(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestPipelines(t *testing.T) {
+	pipelines, err := ParsePipelines("logs: delta+flate btype=1; images: store ;small: gzip tiny=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := PipelineNames(pipelines); !slices.Equal(names, []string{"images", "logs", "small"}) {
+		t.Errorf("names = %v", names)
+	}
+	logs := pipelines["logs"].Options(Options{Filename: "app.log"})
+	if logs.Algorithm != "flate" || logs.Filter != FilterLineDelta || logs.BType != 1 || logs.Filename != "app.log" {
+		t.Errorf("logs gave %+v", logs)
+	}
+	if images := pipelines["images"].Options(Options{}); images.Algorithm != "flate" || !images.Store {
+		t.Errorf("images gave %+v", images)
+	}
+	if definition := pipelines["small"].String(); definition != "gzip tiny=-1" {
+		t.Errorf("small is written as %q", definition)
+	}
+
+	// what the data went through is undone by decompressing with the same pipeline
+	input := []byte(strings.Repeat("2025-01-01,host-1,ok\n", 40))
+	compressed, _, err := Compress(input, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, _, err := Decompress(compressed, pipelines["logs"].Options(Options{})); err != nil || !bytes.Equal(output, input) {
+		t.Errorf("round trip through logs: %v", err)
+	}
+
+	for _, spec := range []string{"logs", "logs: zip", "Logs: flate", "a: flate; a: gzip", "a: flate level=9", "a: flate btype"} {
+		if _, err := ParsePipelines(spec); err == nil {
+			t.Errorf("ParsePipelines(%q) accepted it", spec)
+		}
+	}
+}
+
+func TestAlgorithmRegistry(t *testing.T) {
+	algorithms := Algorithms()
+	if !slices.Equal(SupportedAlgorithms, algorithmNames()) || len(algorithms) != len(registry) {
+		t.Fatalf("the registry lists %d algorithms, %v are supported", len(algorithms), SupportedAlgorithms)
+	}
+	for i, info := range algorithms {
+		if registry[i].factory == nil {
+			t.Errorf("%s is listed without a factory", info.Name)
+		}
+		if slices.IndexFunc(registry, func(entry registeredAlgorithm) bool { return entry.Name == info.Name }) != i {
+			t.Errorf("%s is listed twice", info.Name)
+		}
+		if info.Description == "" || info.Extension == "" || info.Capabilities.Format == "" {
+			t.Errorf("%s is missing metadata: %+v", info.Name, info)
+		}
+		for _, option := range info.Options {
+			if option.Min != nil && option.Max != nil && *option.Min > *option.Max {
+				t.Errorf("%s option %s has an empty range", info.Name, option.Name)
+			}
+		}
+	}
+	if ext := Extension("gzip"); ext != "gz" {
+		t.Errorf("gzip output got the extension %q", ext)
+	}
+	if mime := MIMEType("gzip"); mime != "application/gzip" {
+		t.Errorf("gzip output got the media type %q", mime)
+	}
+	for _, ext := range []string{"gz", ".gz", ".GZ"} {
+		if info, ok := AlgorithmByExtension(ext); !ok || info.Name != "gzip" {
+			t.Errorf("AlgorithmByExtension(%q) = %q, %v", ext, info.Name, ok)
+		}
+	}
+	if _, ok := AlgorithmByExtension(""); ok {
+		t.Error("an empty extension names an algorithm")
+	}
+
+	// names the registry does not know are refused rather than looked up
+	// as the zero entry
+	if _, err := factoryFor("rot13"); err == nil {
+		t.Error("factoryFor found a factory for rot13")
+	}
+	if err := checkPartial(Options{Algorithm: "rot13", Partial: true}); !errors.Is(err, ErrPartialUnsupported) {
+		t.Errorf("checkPartial of rot13 = %v", err)
+	}
+	if err := checkDictionary(Options{Algorithm: "rot13", Dictionary: []byte("dict")}); !errors.Is(err, ErrDictionaryUnsupported) {
+		t.Errorf("checkDictionary of rot13 = %v", err)
+	}
+	if _, err := NewConcatWriter(io.Discard, Options{Algorithm: "rot13"}); !errors.Is(err, ErrConcatUnsupported) {
+		t.Errorf("NewConcatWriter of rot13 = %v", err)
+	}
+}
+
+func TestOriginalSize(t *testing.T) {
+	input := []byte(strings.Repeat("the size is in the header ", 30))
+	recorded := map[string]bool{"huffman": true, "lzss": true, "gzip": true}
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, ok := OriginalSize(compressed, algorithm)
+		if ok != recorded[algorithm] || ok && size != int64(len(input)) {
+			t.Errorf("%s: OriginalSize = %d, %v", algorithm, size, ok)
+		}
+		if recorded[algorithm] {
+			decompressed, _, err := Decompress(compressed, Options{Algorithm: algorithm})
+			if err != nil || cap(decompressed) != len(input) {
+				t.Errorf("%s: the output was allocated with %d bytes for %d: %v", algorithm, cap(decompressed), len(input), err)
+			}
+		}
+	}
+
+	// the unframed format records it too, several gzip members do not
+	if size, ok := OriginalSize([]byte("\x89HUF\x02\x0b\x05a\x05b\x02c\x01d\x01r\x02\x6e\x8a\xdc"), "huffman"); !ok || size != 11 {
+		t.Errorf("unframed huffman: OriginalSize = %d, %v", size, ok)
+	}
+	member, _, _ := Compress(input, Options{Algorithm: "gzip"})
+	if _, ok := OriginalSize(append(bytes.Clone(member), member...), "gzip"); ok {
+		t.Error("OriginalSize took the ISIZE of the last of two members for the whole")
+	}
+
+	// a size no input of its length expands to is not allocated, nor one
+	// past the limit of the output
+	claimed := bytes.Clone(member)
+	binary.LittleEndian.PutUint32(claimed[len(claimed)-4:], 60<<20)
+	for _, options := range []Options{
+		{Algorithm: "gzip", IgnoreChecksums: true},
+		{Algorithm: "gzip", IgnoreChecksums: true, MaxDecodedSize: int64(len(input))},
+	} {
+		decompressed, _, err := Decompress(claimed, options)
+		if err != nil || cap(decompressed) > len(claimed)*maxPreallocationRatio {
+			t.Errorf("a claimed 60 MiB was allocated with %d bytes for %d bytes of input: %v", cap(decompressed), len(claimed), err)
+		}
+		if options.MaxDecodedSize > 0 && int64(cap(decompressed)) > options.MaxDecodedSize+512 {
+			t.Errorf("allocated %d bytes past a limit of %d", cap(decompressed), options.MaxDecodedSize)
+		}
+	}
+}
+
+func TestMaxDecodedSize(t *testing.T) {
+	input := []byte(strings.Repeat("a small input that expands a lot. ", 2000))
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = Decompress(compressed, Options{Algorithm: algorithm, MaxDecodedSize: 16 << 10, Salvage: true})
+		var limited *SizeLimitError
+		if !errors.As(err, &limited) || limited.Limit != 16<<10 {
+			t.Errorf("%s: decompressing past the limit gave %v", algorithm, err)
+		}
+		// exactly the limit is allowed
+		if output, _, err := Decompress(compressed, Options{Algorithm: algorithm, MaxDecodedSize: int64(len(input))}); err != nil || len(output) != len(input) {
+			t.Errorf("%s: decompressing up to the limit gave %d bytes: %v", algorithm, len(output), err)
+		}
+	}
+
+	// members decoded side by side or recovered stop at the limit as well
+	member, _, _ := Compress(input, Options{Algorithm: "gzip"})
+	members := append(bytes.Clone(member), member...)
+	for _, options := range []Options{{Concurrency: 4}, {Recover: true}} {
+		options.Algorithm, options.MaxDecodedSize = "gzip", int64(len(input))
+		var limited *SizeLimitError
+		if _, _, err := Decompress(members, options); !errors.As(err, &limited) {
+			t.Errorf("%+v: %v", options, err)
+		}
+	}
+}
+
+func TestAlgorithmMagic(t *testing.T) {
+	input := []byte("magic bytes start the output of every algorithm with a header")
+	for _, info := range Algorithms() {
+		compressed, _, err := Compress(input, Options{Algorithm: info.Name})
+		if err != nil {
+			t.Fatalf("%s: %v", info.Name, err)
+		}
+		if !bytes.HasPrefix(compressed, info.Magic) {
+			t.Errorf("%s output starts with % x, the registry has % x", info.Name, compressed[:min(len(compressed), 4)], []byte(info.Magic))
+		}
+		if detected := Detect(compressed); detected != info.Name {
+			t.Errorf("%s output was detected as %q", info.Name, detected)
+		}
+	}
+}
+
+func TestPresetDictionary(t *testing.T) {
+	dict := []byte(`{"status": "ok", "service": "compression", "algorithm": "flate", "ratio": `)
+	input := []byte(`{"status": "ok", "service": "compression", "algorithm": "zlib", "ratio": 42.5}`)
+	for _, algorithm := range []string{"flate", "zlib"} {
+		plain, _, err := Compress(input, Options{Algorithm: algorithm, TinyInputSize: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		primed, _, err := Compress(input, Options{Algorithm: algorithm, Dictionary: dict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(primed) >= len(plain) {
+			t.Errorf("%s: %d bytes with the dictionary, %d without", algorithm, len(primed), len(plain))
+		}
+		decompressed, _, err := Decompress(primed, Options{Algorithm: algorithm, Dictionary: dict})
+		if err != nil || !bytes.Equal(decompressed, input) {
+			t.Errorf("%s: round trip with the dictionary gave %q, %v", algorithm, decompressed, err)
+		}
+	}
+
+	// zlib names the dictionary it needs and reads like any other encoder's
+	primed, _, _ := Compress(input, Options{Algorithm: "zlib", Dictionary: dict})
+	var dictErr *DictionaryError
+	if _, _, err := Decompress(primed, Options{Algorithm: "zlib"}); !errors.As(err, &dictErr) || dictErr.Given {
+		t.Errorf("decompressing without the dictionary: %v", err)
+	}
+	if _, _, err := Decompress(primed, Options{Algorithm: "zlib", Dictionary: []byte("another")}); !errors.As(err, &dictErr) || !dictErr.Given {
+		t.Errorf("decompressing with another dictionary: %v", err)
+	}
+	if Detect(primed) != "zlib" {
+		t.Errorf("zlib with a dictionary detected as %q", Detect(primed))
+	}
+	reader, err := stdzlib.NewReaderDict(bytes.NewReader(primed), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := io.ReadAll(reader); err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("compress/zlib decoded %q, %v", decoded, err)
+	}
+
+	for _, algorithm := range []string{"huffman", "lzss", "gzip"} {
+		if _, _, err := Compress(input, Options{Algorithm: algorithm, Dictionary: dict}); !errors.Is(err, ErrDictionaryUnsupported) {
+			t.Errorf("%s took a dictionary", algorithm)
+		}
+	}
+}
+
+// TestHashes checks the checksums computed while compressing against those
+// of a separate pass, through every way a compression is fed
+func TestHashes(t *testing.T) {
+	data := []byte(strings.Repeat("a line hashed on its way into the codec\n", 3000))
+	algorithms := []string{"crc32", "sha256"}
+	want, _, err := checksum.Compute(bytes.NewReader(data), algorithms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range []Options{
+		{Algorithm: "gzip"},
+		{Algorithm: "flate", Filter: "delta"},
+		{Algorithm: "lzss", StatsOnly: true},
+	} {
+		options.Hashes = algorithms
+		_, stats, err := Compress(data, options)
+		if err != nil {
+			t.Fatalf("%s: %v", options.Algorithm, err)
+		}
+		if !reflect.DeepEqual(stats.Hashes, want) {
+			t.Errorf("%s: hashes %v, want %v", options.Algorithm, stats.Hashes, want)
+		}
+		stats, err = CompressStream(context.Background(), io.Discard, bytes.NewReader(data), options)
+		if err != nil {
+			t.Fatalf("%s streamed: %v", options.Algorithm, err)
+		}
+		if !reflect.DeepEqual(stats.Hashes, want) {
+			t.Errorf("%s: streamed hashes %v, want %v", options.Algorithm, stats.Hashes, want)
+		}
+	}
+	if _, _, err := Compress(data, Options{Algorithm: "gzip", Hashes: []string{"md5"}}); err == nil {
+		t.Error("an unknown hash was accepted")
+	}
+}
+
+func TestStatsRecord(t *testing.T) {
+	data := bytes.Repeat([]byte("a record of the stats "), 100)
+	compressed, stats, err := Compress(data, Options{Algorithm: "gzip", ModTime: time.Unix(1700000000, 0), Hashes: []string{"crc32"}, Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["schema"] != float64(StatsSchemaVersion) || fields["processed_size"] != float64(len(compressed)) {
+		t.Errorf("record %s", encoded)
+	}
+	parsed, err := ParseStatsRecord(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Record(), stats.Record()) {
+		t.Errorf("parsed %+v, want %+v", parsed.Record(), stats.Record())
+	}
+
+	_, stats, err = Decompress(compressed, Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ = json.Marshal(stats)
+	if parsed, err = ParseStatsRecord(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.ModTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("decompression record %s parsed with mod time %v", encoded, parsed.ModTime)
+	}
+
+	for _, record := range []string{`{"algorithm":"gzip"}`, `{"schema":2,"algorithm":"gzip"}`} {
+		if _, err := ParseStatsRecord([]byte(record)); !errors.Is(err, ErrStatsSchema) {
+			t.Errorf("%s: %v, want ErrStatsSchema", record, err)
+		}
+	}
+}
+
+func TestDecompressStream(t *testing.T) {
+	input := []byte(strings.Repeat("streamed back as it decodes. ", 500))
+	for _, algorithm := range SupportedAlgorithms {
+		compressed, _, err := Compress(input, Options{Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		stats, err := DecompressStream(context.Background(), &output, iotest.HalfReader(bytes.NewReader(compressed)), Options{Algorithm: algorithm})
+		if err != nil || !bytes.Equal(output.Bytes(), input) {
+			t.Errorf("%s: decoded %d bytes: %v", algorithm, output.Len(), err)
+			continue
+		}
+		if stats.OriginalSize != int64(len(compressed)) || stats.ProcessedSize != int64(len(input)) {
+			t.Errorf("%s: stats record %d and %d bytes", algorithm, stats.OriginalSize, stats.ProcessedSize)
+		}
+
+		var limited *SizeLimitError
+		_, err = DecompressStream(context.Background(), io.Discard, bytes.NewReader(compressed), Options{Algorithm: algorithm, MaxDecodedSize: 1024})
+		if !errors.As(err, &limited) {
+			t.Errorf("%s: decompressing past the limit gave %v", algorithm, err)
+		}
+	}
+
+	compressed, _, _ := Compress(input, Options{Algorithm: "gzip"})
+	var truncated *TruncatedError
+	if _, err := DecompressStream(context.Background(), io.Discard, bytes.NewReader(compressed[:len(compressed)/2]), Options{Algorithm: "gzip"}); !errors.As(err, &truncated) {
+		t.Errorf("truncated input gave %v", err)
+	}
+	var corrupt *CorruptError
+	if _, err := DecompressStream(context.Background(), io.Discard, strings.NewReader("not gzip at all"), Options{Algorithm: "gzip"}); !errors.As(err, &corrupt) {
+		t.Errorf("corrupt input gave %v", err)
+	}
+	if _, err := DecompressStream(context.Background(), io.Discard, bytes.NewReader(compressed), Options{Algorithm: AutoAlgorithm}); err == nil {
+		t.Error("the algorithm was detected on a stream")
+	}
+}
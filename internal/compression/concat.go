@@ -0,0 +1,133 @@
+package compression
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+)
+
+// ErrConcatUnsupported is returned for algorithms whose output cannot be
+// appended to and split into its payloads again
+var ErrConcatUnsupported = errors.New("algorithm does not support concatenated payloads")
+
+// finalEmptyBlock is a final fixed Huffman block holding only the
+// end-of-block code, it ends a flate stream that more could have followed
+var finalEmptyBlock = []byte{0x03, 0x00}
+
+// ConcatWriter appends payloads compressed one at a time to a single
+// output, e.g. log lines shipped to a file over the day. gzip writes a
+// member per payload, as concatenated .gz files are, flate the blocks of a
+// partial stream ending in an empty stored block. Each payload is
+// compressed on its own, its matches do not reach into the ones before, so
+// output that w already holds is continued whichever writer wrote it.
+type ConcatWriter struct {
+	w        io.Writer
+	options  Options
+	payloads int
+	closed   bool
+}
+
+// NewConcatWriter returns a ConcatWriter appending to w. The options apply
+// to every payload, they may not carry a dictionary: SplitPayloads could not
+// tell which payloads it primes.
+func NewConcatWriter(w io.Writer, options Options) (*ConcatWriter, error) {
+	features, err := checkConcat(options)
+	if err != nil {
+		return nil, err
+	}
+	options.Partial = features.Partial
+	return &ConcatWriter{w: w, options: options}, nil
+}
+
+// checkConcat rejects options ConcatWriter and SplitPayloads cannot work with,
+// it returns the features of the algorithm otherwise
+func checkConcat(options Options) (Features, error) {
+	entry, ok := lookupAlgorithm(options.Algorithm)
+	if !ok || !entry.Features.Concat {
+		return Features{}, fmt.Errorf("%w: %s", ErrConcatUnsupported, options.Algorithm)
+	}
+	if options.Dictionary != nil {
+		return Features{}, fmt.Errorf("%w: payloads cannot share a dictionary", ErrConcatUnsupported)
+	}
+	return entry.Features, nil
+}
+
+// Append compresses payload and writes it after the payloads before it
+func (cw *ConcatWriter) Append(ctx context.Context, payload []byte) (*Stats, error) {
+	if cw.closed {
+		return nil, io.ErrClosedPipe
+	}
+	compressed, stats, err := CompressContext(ctx, payload, cw.options)
+	if err != nil {
+		return stats, err
+	}
+	if _, err := cw.w.Write(compressed); err != nil {
+		return stats, err
+	}
+	cw.payloads++
+	return stats, nil
+}
+
+// Payloads is the number of payloads appended so far
+func (cw *ConcatWriter) Payloads() int {
+	return cw.payloads
+}
+
+// Close ends flate output with an empty final block, so decoders that stop
+// at a final block, e.g. compress/flate, read it to the end. Nothing can be
+// appended after it. gzip output is complete after every member.
+func (cw *ConcatWriter) Close() error {
+	if cw.closed {
+		return io.ErrClosedPipe
+	}
+	cw.closed = true
+	if !cw.options.Partial {
+		return nil
+	}
+	_, err := cw.w.Write(finalEmptyBlock)
+	return err
+}
+
+// SplitPayloads decodes the output of a ConcatWriter into its payloads, in
+// the order they were appended. gzip data splits at its members and flate
+// data where it was flushed, so concatenated .gz files and deflate streams
+// with sync flushes from other tools split as well. The payloads add up to
+// at most options.MaxDecodedSize, unless it is 0.
+func SplitPayloads(ctx context.Context, data []byte, options Options) (payloads [][]byte, err error) {
+	if _, err := checkConcat(options); err != nil {
+		return nil, err
+	}
+	defer recoverCodecPanic(&err)
+
+	if options.Algorithm == "gzip" {
+		for offset := 0; offset < len(data); {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			content, n, err := gzip.DecodeMember(data[offset:])
+			if err != nil {
+				return nil, fmt.Errorf("payload %d at offset %d: %w", len(payloads), offset, err)
+			}
+			payloads = append(payloads, content)
+			offset += n
+		}
+	} else if payloads, err = flate.InflateSegments(data); err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for i, payload := range payloads {
+		if payloads[i], err = unfilter(options.Filter, payload); err != nil {
+			return nil, err
+		}
+		size += int64(len(payloads[i]))
+		if options.MaxDecodedSize > 0 && size > options.MaxDecodedSize {
+			return nil, &SizeLimitError{Limit: options.MaxDecodedSize}
+		}
+	}
+	return payloads, nil
+}
@@ -0,0 +1,125 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/huffman"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/lzss"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/zlib"
+)
+
+// deflateFramings are the algorithms that wrap the same deflate data, they
+// are told apart by DetectFraming
+var deflateFramings = map[string]bool{"flate": true, "gzip": true, "zlib": true}
+
+// Detect returns the algorithm data was most likely compressed with, or ""
+// when it is not recognised. Data starting with the magic of an algorithm in
+// the registry is taken for its output, zlib is also recognised by its header
+// check and the unversioned huffman data of earlier versions by its symbol
+// table; flate has no header, so data is taken for flate when it inflates
+// without errors. The text format of earlier lzss versions is never detected.
+func Detect(data []byte) string {
+	algorithm := sniff(data)
+	if algorithm == "flate" && !isFlate(data) {
+		return ""
+	}
+	return algorithm
+}
+
+// DetectFraming tells gzip, zlib and raw deflate data apart by its first
+// bytes. Anything without a gzip or zlib header is taken for raw deflate,
+// except data too short to tell, which could be any of the three cut off
+// and gives "".
+func DetectFraming(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzip.Magic):
+		return "gzip"
+	case zlib.IsHeader(data):
+		return "zlib"
+	// no deflate stream is shorter than two bytes
+	case len(data) < 2 || bytes.HasPrefix(gzip.Magic, data):
+		return ""
+	}
+	return "flate"
+}
+
+// sniff is Detect from the first bytes alone: data that could start a
+// deflate block is taken for flate without inflating it
+func sniff(data []byte) string {
+	for _, entry := range registry {
+		if len(entry.Magic) > 0 && bytes.HasPrefix(data, entry.Magic) {
+			return entry.Name
+		}
+	}
+	switch {
+	case isHuffman(data):
+		return "huffman"
+	case zlib.IsHeader(data):
+		return "zlib"
+	// BTYPE 3 is reserved, no deflate stream starts with it
+	case len(data) > 0 && data[0]>>1&3 != 3:
+		return "flate"
+	}
+	return ""
+}
+
+// isHuffman checks the header unversioned huffman data starts with: the
+// content length, the number of symbols and the symbols in ascending order
+// with their counts, which have to add up to the length
+func isHuffman(data []byte) bool {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return false
+	}
+	data = data[n:]
+	symbols, n := binary.Uvarint(data)
+	if n <= 0 || symbols > 256 || (symbols == 0) != (length == 0) {
+		return false
+	}
+	data = data[n:]
+	total, previous := uint64(0), -1
+	for i := uint64(0); i < symbols; i++ {
+		if len(data) == 0 || int(data[0]) <= previous {
+			return false
+		}
+		previous = int(data[0])
+		count, n := binary.Uvarint(data[1:])
+		if n <= 0 || count == 0 || count > length-total {
+			return false
+		}
+		total += count
+		data = data[1+n:]
+	}
+	// a single symbol needs no bits, anything else needs some
+	return total == length && (symbols > 1) == (len(data) > 0)
+}
+
+func isFlate(data []byte) bool {
+	_, _, err := DecompressContext(context.Background(), data, Options{Algorithm: "flate"})
+	return err == nil
+}
+
+// OriginalSize returns the length of the content data decompresses to as
+// the headers of data record it, without decoding anything. ok is false for
+// flate and zlib, which do not record it, for data in a legacy format, and
+// for gzip data that may hold several members, whose last ISIZE only counts
+// the last one.
+func OriginalSize(data []byte, algorithm string) (size int64, ok bool) {
+	switch algorithm {
+	case "huffman":
+		return huffman.ContentLength(data)
+	case "lzss":
+		return lzss.ContentLength(data)
+	case "gzip":
+		// a lookalike of a member header inside compressed data only costs
+		// the size
+		if len(data) > 0 && bytes.Contains(data[1:], gzip.Magic) {
+			return 0, false
+		}
+		return gzip.ContentLength(data)
+	}
+	return 0, false
+}
@@ -0,0 +1,116 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FilterLineDelta codes every line as the length of the prefix it shares
+// with the line before and the bytes after that prefix. Rows of a CSV file
+// often start alike, with the same date or an increasing id, which the
+// filter turns into a byte or two before the algorithm sees them.
+const FilterLineDelta = "delta"
+
+var errLineDelta = errors.New("line delta data is corrupt")
+
+// isValidFilter reports whether filter is "" or a filter the input can be
+// passed through
+func isValidFilter(filter string) bool {
+	return filter == "" || filter == FilterLineDelta
+}
+
+// lineDeltaReader applies FilterLineDelta to what it reads from its source.
+// A line is coded as a uvarint prefix length followed by the rest of the
+// line up to and including its newline. The prefix never covers the newline,
+// so every coded line but an unterminated last one ends with exactly one.
+type lineDeltaReader struct {
+	source   *bufio.Reader
+	previous []byte
+	pending  []byte
+	err      error
+}
+
+func newLineDeltaReader(source io.Reader) *lineDeltaReader {
+	return &lineDeltaReader{source: bufio.NewReader(source)}
+}
+
+func (r *lineDeltaReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.source.ReadBytes('\n')
+		r.err = err
+		if len(line) == 0 {
+			continue
+		}
+		shared := commonPrefix(r.previous, line)
+		if shared == len(line) && line[len(line)-1] == '\n' {
+			shared--
+		}
+		r.pending = binary.AppendUvarint(r.pending[:0], uint64(shared))
+		r.pending = append(r.pending, line[shared:]...)
+		r.previous = line
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// decodeLineDelta reverses FilterLineDelta
+func decodeLineDelta(data []byte) ([]byte, error) {
+	output := make([]byte, 0, len(data))
+	var previous []byte
+	for len(data) > 0 {
+		shared, n := binary.Uvarint(data)
+		if n <= 0 || shared > uint64(len(previous)) {
+			return nil, errLineDelta
+		}
+		data = data[n:]
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		start := len(output)
+		output = append(output, previous[:shared]...)
+		output = append(output, data[:end]...)
+		previous = output[start:]
+		data = data[end:]
+	}
+	return output, nil
+}
+
+// unfilter reverses the filter the data was passed through before it was
+// compressed
+func unfilter(filter string, data []byte) ([]byte, error) {
+	switch filter {
+	case "":
+		return data, nil
+	case FilterLineDelta:
+		return decodeLineDelta(data)
+	}
+	return nil, fmt.Errorf("unsupported filter: %s", filter)
+}
+
+// applyFilter passes data through filter, which was checked to be valid
+func applyFilter(filter string, data []byte) []byte {
+	if filter == "" {
+		return data
+	}
+	filtered, _ := io.ReadAll(newLineDeltaReader(bytes.NewReader(data)))
+	return filtered
+}
@@ -0,0 +1,39 @@
+package compression
+
+import (
+	"errors"
+	"testing"
+)
+
+// fuzzSeeds are compressed with every algorithm to give the fuzzer valid
+// streams to mutate, on top of the corpus in testdata/fuzz
+var fuzzSeeds = [][]byte{
+	{},
+	{0},
+	[]byte("a"),
+	[]byte("abababababababababab"),
+	[]byte("the quick brown fox jumps over the lazy dog, the quick brown fox <jumps>, \\over\\ the lazy dog"),
+	{0, 1, 2, 3, 0xff, 0xfe, 0, 1, 2, 3, 0xff, 0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0},
+}
+
+// fuzzDecompress feeds arbitrary input to an algorithm's decoder. Rejecting
+// the input is fine, panicking is not.
+func fuzzDecompress(f *testing.F, algorithm string) {
+	options := Options{Algorithm: algorithm}
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+		if compressed, _, err := Compress(seed, options); err == nil {
+			f.Add(compressed)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, _, err := Decompress(data, options); errors.Is(err, errCodecPanic) {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzFlate(f *testing.F)   { fuzzDecompress(f, "flate") }
+func FuzzGzip(f *testing.F)    { fuzzDecompress(f, "gzip") }
+func FuzzHuffman(f *testing.F) { fuzzDecompress(f, "huffman") }
+func FuzzLZSS(f *testing.F)    { fuzzDecompress(f, "lzss") }
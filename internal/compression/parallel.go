@@ -0,0 +1,107 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/memory"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxSpeculativeMembers bounds the member headers decoded speculatively,
+// inputs with more lookalikes are decoded one member after another
+const maxSpeculativeMembers = 1 << 16
+
+// decompressMembers decodes multi-member gzip data, e.g. concatenated .gz
+// files or a seekable stream, with up to concurrency members at a time.
+// Where a member ends is only known once it is decoded, so every offset that
+// starts like a member is decoded speculatively and the members are chained
+// from the start afterwards: each has to begin where the one before ended,
+// anything else was a lookalike inside compressed data. The content comes
+// out in member order whichever finished first.
+//
+// ok is false when data has a single member or the chain breaks, the caller
+// then decodes data the usual way, which also reports what is wrong with it.
+// It is false as well once the members decoded so far add up to more than
+// limit bytes, unless limit is 0: the usual way stops right at the limit.
+// usage counts the content of every candidate, since all of it is held until
+// the chain is known, and the output it is copied into.
+func decompressMembers(ctx context.Context, data []byte, concurrency int, limit int64) (output []byte, usage memory.Usage, ok bool) {
+	var starts []int
+	for offset := 0; len(starts) <= maxSpeculativeMembers; {
+		i := bytes.Index(data[offset:], gzip.Magic)
+		if i < 0 {
+			break
+		}
+		starts = append(starts, offset+i)
+		offset += i + 1
+	}
+	if len(starts) < 2 || starts[0] != 0 || len(starts) > maxSpeculativeMembers {
+		return nil, usage, false
+	}
+
+	ctx, span := telemetry.Start(ctx, "gzip.members", attribute.Int("gzip.candidates", len(starts)))
+	defer span.End()
+
+	type member struct {
+		content []byte
+		end     int
+		err     error
+	}
+	members := make([]member, len(starts))
+	var decoded atomic.Int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, start := range starts {
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			defer recoverCodecPanic(&members[i].err)
+			content, n, err := gzip.DecodeMember(data[start:])
+			members[i] = member{content: content, end: start + n, err: err}
+			if limit > 0 && decoded.Add(int64(len(content))) > limit {
+				return &SizeLimitError{Limit: limit}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, usage, false
+	}
+
+	byStart := make(map[int]int, len(starts))
+	for i, start := range starts {
+		byStart[start] = i
+	}
+	var chain []int
+	size := 0
+	for offset := 0; offset < len(data); {
+		i, found := byStart[offset]
+		if !found || members[i].err != nil {
+			return nil, usage, false
+		}
+		chain = append(chain, i)
+		size += len(members[i].content)
+		offset = members[i].end
+	}
+	span.SetAttributes(attribute.Int("gzip.members", len(chain)))
+
+	// members reach back as far as deflate's 32KB window
+	usage = memory.Usage{PeakBytes: int64(size), Allocations: 1, WindowSize: 32 << 10}
+	for _, m := range members {
+		if m.content != nil {
+			usage.PeakBytes += int64(cap(m.content))
+			usage.Allocations++
+		}
+	}
+	output = make([]byte, 0, size)
+	for _, i := range chain {
+		output = append(output, members[i].content...)
+	}
+	return output, usage, true
+}
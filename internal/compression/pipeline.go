@@ -0,0 +1,133 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validPipelineName is what pipelines may be called
+var validPipelineName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Pipeline is a way of compressing the operator gave a name, so clients ask
+// for "logs" instead of an algorithm, a filter and a block type. Action is
+// written as in a PolicyRule, Settings as for ApplySetting.
+type Pipeline struct {
+	Name     string
+	Action   string
+	Settings []string // "key=value"
+}
+
+// ParsePipelines reads pipelines written as "name: action key=value ..."
+// and separated by semicolons, e.g. "logs: delta+flate btype=2; images: store"
+func ParsePipelines(spec string) (map[string]Pipeline, error) {
+	pipelines := make(map[string]Pipeline)
+	for _, definition := range strings.Split(spec, ";") {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+		name, rest, found := strings.Cut(definition, ":")
+		fields := strings.Fields(rest)
+		if !found || len(fields) == 0 {
+			return nil, fmt.Errorf("pipeline %q is not written as name: action", definition)
+		}
+		pipeline := Pipeline{Name: strings.TrimSpace(name), Action: fields[0], Settings: fields[1:]}
+		if !validPipelineName.MatchString(pipeline.Name) {
+			return nil, fmt.Errorf("pipeline name %q must be lowercase letters, digits, '-' and '_'", pipeline.Name)
+		}
+		if _, ok := pipelines[pipeline.Name]; ok {
+			return nil, fmt.Errorf("pipeline %q is defined twice", pipeline.Name)
+		}
+		if _, err := pipeline.options(Options{}); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %w", pipeline.Name, err)
+		}
+		pipelines[pipeline.Name] = pipeline
+	}
+	return pipelines, nil
+}
+
+// Options returns options set up for the pipeline, the rest of options is
+// kept
+func (p Pipeline) Options(options Options) Options {
+	// the pipeline was checked when it was parsed
+	options, _ = p.options(options)
+	return options
+}
+
+func (p Pipeline) options(options Options) (Options, error) {
+	options, err := PolicyRule{Action: p.Action}.options(options)
+	if err != nil {
+		return options, err
+	}
+	for _, setting := range p.Settings {
+		key, value, found := strings.Cut(setting, "=")
+		if !found {
+			return options, fmt.Errorf("setting %q is not written as key=value", setting)
+		}
+		if err := ApplySetting(&options, key, value); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// String writes the pipeline the way it is defined, without its name
+func (p Pipeline) String() string {
+	return strings.Join(append([]string{p.Action}, p.Settings...), " ")
+}
+
+// settingNames lists the keys ApplySetting knows
+var settingNames = []string{"btype", "bfinal", "tiny", "block", "store", "filter"}
+
+// ApplySetting sets the option named by key from its value as text: btype,
+// bfinal, tiny for TinyInputSize, block for BlockSize, store or filter
+func ApplySetting(options *Options, key, value string) error {
+	var err error
+	switch key {
+	case "btype":
+		var n uint64
+		if n, err = strconv.ParseUint(value, 10, 32); err == nil {
+			options.BType = uint32(n)
+		}
+	case "bfinal":
+		switch value {
+		case "0", "1":
+			options.Partial = value == "0"
+		default:
+			err = errors.New("bfinal is 0, for a partial stream, or 1")
+		}
+	case "tiny":
+		options.TinyInputSize, err = strconv.Atoi(value)
+	case "block":
+		if options.BlockSize, err = strconv.Atoi(value); err == nil && options.BlockSize < 0 {
+			err = errors.New("block is a size in bytes, or 0 for no limit")
+		}
+	case "store":
+		options.Store, err = strconv.ParseBool(value)
+	case "filter":
+		if !isValidFilter(value) {
+			err = fmt.Errorf("the only filter is %s", FilterLineDelta)
+		}
+		options.Filter = value
+	default:
+		return fmt.Errorf("unknown setting %q, use one of %v", key, settingNames)
+	}
+	if err != nil {
+		return fmt.Errorf("setting %s=%s: %w", key, value, err)
+	}
+	return nil
+}
+
+// PipelineNames returns the names of pipelines in order
+func PipelineNames(pipelines map[string]Pipeline) []string {
+	names := make([]string, 0, len(pipelines))
+	for name := range pipelines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,227 @@
+package compression
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AutoAlgorithm lets the policy pick the algorithm from the content type of
+// the input
+const AutoAlgorithm = "auto"
+
+// PolicyStore passes the input through as stored deflate blocks, for
+// formats that are compressed already
+const PolicyStore = "store"
+
+// deltaPrefix marks actions that pass the input through FilterLineDelta
+// before the algorithm that follows it
+const deltaPrefix = FilterLineDelta + "+"
+
+// sniffLen is how much of the input the content type is detected from
+const sniffLen = 512
+
+// PolicyRule applies Action to inputs whose content type matches
+// ContentType, which is a type such as "image/png", a family such as
+// "image/*" or "*" for everything. Action is PolicyStore, an algorithm, or
+// an algorithm after "delta+" such as "delta+flate".
+type PolicyRule struct {
+	ContentType string
+	Action      string
+}
+
+// Policy decides how inputs compressed with AutoAlgorithm are compressed,
+// the first rule that matches the content type applies. Signatures are
+// checked before DefaultSignatures to detect the content type.
+type Policy struct {
+	Rules      []PolicyRule
+	Signatures []Signature
+}
+
+// DefaultPolicy stores formats that are compressed already, passes CSV
+// through the line delta filter before flate and compresses other text with
+// flate. Anything else is compressed with gzip.
+var DefaultPolicy = Policy{Rules: []PolicyRule{
+	{ContentType: "image/jpeg", Action: PolicyStore},
+	{ContentType: "image/png", Action: PolicyStore},
+	{ContentType: "image/gif", Action: PolicyStore},
+	{ContentType: "image/webp", Action: PolicyStore},
+	{ContentType: "video/*", Action: PolicyStore},
+	{ContentType: "audio/*", Action: PolicyStore},
+	{ContentType: "application/zip", Action: PolicyStore},
+	{ContentType: "application/x-gzip", Action: PolicyStore},
+	{ContentType: "application/x-rar-compressed", Action: PolicyStore},
+	{ContentType: "application/x-xz", Action: PolicyStore},
+	{ContentType: "application/x-bzip2", Action: PolicyStore},
+	{ContentType: "application/zstd", Action: PolicyStore},
+	{ContentType: "application/x-7z-compressed", Action: PolicyStore},
+	{ContentType: "text/csv", Action: "delta+flate"},
+	{ContentType: "text/tab-separated-values", Action: "delta+flate"},
+	{ContentType: "text/*", Action: "flate"},
+	{ContentType: "*", Action: "gzip"},
+}}
+
+// ParsePolicy reads rules written as "type=action" pairs separated by
+// commas, e.g. "image/*=store,text/csv=delta+flate,*=gzip"
+func ParsePolicy(spec string) (Policy, error) {
+	var policy Policy
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		contentType, action, found := strings.Cut(pair, "=")
+		rule := PolicyRule{ContentType: strings.ToLower(strings.TrimSpace(contentType)), Action: strings.TrimSpace(action)}
+		if !found || rule.ContentType == "" {
+			return Policy{}, fmt.Errorf("policy rule %q is not written as type=action", pair)
+		}
+		if _, err := rule.options(Options{}); err != nil {
+			return Policy{}, fmt.Errorf("policy rule %q: %w", pair, err)
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	if len(policy.Rules) == 0 {
+		return Policy{}, fmt.Errorf("policy %q has no rules", spec)
+	}
+	return policy, nil
+}
+
+// Excluding returns the policy without the rules whose action compresses
+// with one of algorithms, such as the ones a server has disabled. Inputs
+// those rules matched fall through to the rules after them.
+func (p Policy) Excluding(algorithms []string) Policy {
+	excluded := Policy{Signatures: p.Signatures}
+	for _, rule := range p.Rules {
+		// the rules were checked when the policy was parsed
+		options, _ := rule.options(Options{})
+		if !slices.Contains(algorithms, options.Algorithm) {
+			excluded.Rules = append(excluded.Rules, rule)
+		}
+	}
+	return excluded
+}
+
+// PolicyDecision is what a policy made of an input
+type PolicyDecision struct {
+	ContentType string
+	Signature   string     // what the content type was told by, see Detection
+	Rule        PolicyRule // the rule that matched
+}
+
+// fallbackRule applies to inputs none of the rules of a policy match
+var fallbackRule = PolicyRule{ContentType: "*", Action: "gzip"}
+
+// Decide detects the content type of an input from its first bytes and its
+// name, with DetectSignature, and picks the rule that applies to it. An
+// input none of the rules match is compressed with gzip.
+func (p Policy) Decide(name string, head []byte) PolicyDecision {
+	detection := DetectSignature(p.Signatures, name, head)
+	decision := PolicyDecision{ContentType: detection.ContentType, Signature: detection.Signature, Rule: fallbackRule}
+	for _, rule := range p.Rules {
+		if matchContentType(rule.ContentType, decision.ContentType) {
+			decision.Rule = rule
+			break
+		}
+	}
+	return decision
+}
+
+// Options returns options set up for the decision, the rest of options is
+// kept. The stats of a compression with them report the decision.
+func (d PolicyDecision) Options(options Options) Options {
+	// the rules were checked when the policy was parsed
+	options, _ = d.Rule.options(options)
+	options.decision = &d
+	return options
+}
+
+// ApplyPolicy decides how an input read from src is compressed when options
+// ask for AutoAlgorithm, for callers that need to know the algorithm before
+// the input is compressed. The returned reader reads the whole input again.
+func ApplyPolicy(src io.Reader, options Options) (io.Reader, Options) {
+	if options.Algorithm != AutoAlgorithm {
+		return src, options
+	}
+	buffered := bufio.NewReaderSize(src, sniffLen)
+	// a read error comes up again once the input is read
+	head, _ := buffered.Peek(sniffLen)
+	return buffered, options.decide(head).Options(options)
+}
+
+// Decision returns what the policy decided for options that ApplyPolicy or
+// PolicyDecision.Options set up, or nil
+func (o Options) Decision() *PolicyDecision {
+	return o.decision
+}
+
+// options sets the algorithm, filter and block type for the action of r
+func (r PolicyRule) options(options Options) (Options, error) {
+	action := r.Action
+	if rest, found := strings.CutPrefix(action, deltaPrefix); found {
+		options.Filter = FilterLineDelta
+		action = rest
+	}
+	if action == PolicyStore {
+		options.Algorithm = "flate"
+		options.Store = true
+		return options, nil
+	}
+	if !IsValidAlgorithm(action) {
+		return options, fmt.Errorf("action %q is not %s, an algorithm or %salgorithm", r.Action, PolicyStore, deltaPrefix)
+	}
+	options.Algorithm = action
+	return options, nil
+}
+
+// extensionTypes are types mime.TypeByExtension does not know everywhere
+var extensionTypes = map[string]string{
+	".csv": "text/csv",
+	".tsv": "text/tab-separated-values",
+}
+
+// DetectContentType returns the media type of an input, without parameters.
+// The first bytes decide for binary formats. Text and unknown data are
+// told apart by the extension of name, since CSV and plain text look alike.
+func DetectContentType(name string, head []byte) string {
+	contentType := mediaType(http.DetectContentType(head[:min(len(head), sniffLen)]))
+	if contentType != "text/plain" && contentType != "application/octet-stream" {
+		return contentType
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if byExtension, ok := extensionTypes[ext]; ok {
+		return byExtension
+	}
+	if byExtension := mediaType(mime.TypeByExtension(ext)); byExtension != "" {
+		return byExtension
+	}
+	return contentType
+}
+
+func mediaType(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// matchContentType reports whether contentType falls under pattern
+func matchContentType(pattern, contentType string) bool {
+	if pattern == "*" || pattern == contentType {
+		return true
+	}
+	family, found := strings.CutSuffix(pattern, "/*")
+	return found && strings.HasPrefix(contentType, family+"/")
+}
+
+// decide runs the policy of options over the first bytes of the input
+func (o Options) decide(head []byte) *PolicyDecision {
+	policy := DefaultPolicy
+	if o.Policy != nil {
+		policy = *o.Policy
+	}
+	decision := policy.Decide(o.Filename, head)
+	return &decision
+}
@@ -0,0 +1,76 @@
+package compression
+
+import (
+	"io"
+	"math"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/profile"
+)
+
+// Profile explains how an input compressed in more detail than its sizes,
+// it is collected with Options.Profile
+type Profile struct {
+	// ByteHistogram counts every byte value of the input, Entropy is what
+	// they come to in bits per byte: the best a coder without matches, such
+	// as huffman, can do
+	ByteHistogram [256]int64 `json:"byte_histogram"`
+	Entropy       float64    `json:"entropy"`
+
+	// The rest is filled in for flate, gzip and zlib. LiteralRatio is the
+	// percentage of the coded bytes that went out as literals, matches and
+	// stored blocks make up the others.
+	Literals             int64   `json:"literals"`
+	Matches              int64   `json:"matches"`
+	LiteralRatio         float64 `json:"literal_ratio"`
+	AverageMatchLength   float64 `json:"average_match_length"`
+	AverageMatchDistance float64 `json:"average_match_distance"`
+	StoredBlocks         int64   `json:"stored_blocks"`
+	FixedBlocks          int64   `json:"fixed_blocks"`
+	DynamicBlocks        int64   `json:"dynamic_blocks"`
+	HuffmanTableBytes    int64   `json:"huffman_table_bytes"` // the code tables of the dynamic blocks
+	HeaderBytes          int64   `json:"header_bytes"`        // block and container headers, trailers and padding
+}
+
+// newProfile puts the histogram of the input together with what the codec
+// behind writer counted, once the writer is closed
+func newProfile(histogram *[256]int64, writer io.WriteCloser) *Profile {
+	p := &Profile{ByteHistogram: *histogram}
+	var total int64
+	for _, count := range histogram {
+		total += count
+	}
+	for _, count := range histogram {
+		if count > 0 {
+			share := float64(count) / float64(total)
+			p.Entropy -= share * math.Log2(share)
+		}
+	}
+
+	reporter, ok := writer.(interface{ Profile() profile.Profile })
+	if !ok {
+		return p
+	}
+	counts := reporter.Profile()
+	p.Literals = counts.Literals
+	p.Matches = counts.Matches
+	if coded := counts.Literals + counts.MatchedBytes + counts.StoredBytes; coded > 0 {
+		p.LiteralRatio = float64(counts.Literals) / float64(coded) * 100
+	}
+	if counts.Matches > 0 {
+		p.AverageMatchLength = float64(counts.MatchedBytes) / float64(counts.Matches)
+		p.AverageMatchDistance = float64(counts.DistanceTotal) / float64(counts.Matches)
+	}
+	p.StoredBlocks = counts.StoredBlocks
+	p.FixedBlocks = counts.FixedBlocks
+	p.DynamicBlocks = counts.DynamicBlocks
+	p.HuffmanTableBytes = (counts.TableBits + 7) / 8
+	p.HeaderBytes = (counts.HeaderBits + 7) / 8
+	return p
+}
+
+// countBytes adds the bytes of data to histogram
+func countBytes(histogram *[256]int64, data []byte) {
+	for _, b := range data {
+		histogram[b]++
+	}
+}
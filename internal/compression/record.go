@@ -0,0 +1,114 @@
+package compression
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StatsSchemaVersion is the version of the StatsRecord schema. It goes up
+// when a field changes its meaning or goes away; fields are added without
+// it, so readers ignore the fields they do not know.
+const StatsSchemaVersion = 1
+
+// ErrStatsSchema is returned by ParseStatsRecord for records of a schema
+// version this build does not know
+var ErrStatsSchema = errors.New("compression: unknown stats schema version")
+
+// StatsRecord is Stats as it is serialized wherever it leaves the process:
+// the X-Compression-Stats header and the JSON answers of the API, the -json
+// output of the CLI and the result cache. Sizes are in bytes, durations in
+// milliseconds.
+type StatsRecord struct {
+	Schema           int     `json:"schema"` // StatsSchemaVersion
+	Algorithm        string  `json:"algorithm"`
+	OriginalSize     int64   `json:"original_size"`
+	ProcessedSize    int64   `json:"processed_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	DurationMs       float64 `json:"duration_ms"`
+
+	PeakBufferBytes int64 `json:"peak_buffer_bytes,omitempty"`
+	Allocations     int64 `json:"allocations,omitempty"`
+	WindowSize      int   `json:"window_size,omitempty"`
+
+	ContentType string `json:"content_type,omitempty"` // set by algorithm=auto
+	Policy      string `json:"policy,omitempty"`       // set by algorithm=auto
+
+	ModTime            *time.Time         `json:"mod_time,omitempty"`
+	Warnings           []string           `json:"warnings,omitempty"`
+	Damage             []DamagedRange     `json:"damage,omitempty"`
+	ChecksumMismatches []ChecksumMismatch `json:"checksum_mismatches,omitempty"`
+	Profile            *Profile           `json:"profile,omitempty"`
+	Checksums          map[string]string  `json:"checksums,omitempty"` // Stats.Hashes
+}
+
+// Record returns the stats as they are serialized
+func (s *Stats) Record() StatsRecord {
+	record := StatsRecord{
+		Schema:             StatsSchemaVersion,
+		Algorithm:          s.Algorithm,
+		OriginalSize:       s.OriginalSize,
+		ProcessedSize:      s.ProcessedSize,
+		CompressionRatio:   s.CompressionRatio,
+		DurationMs:         float64(s.Duration) / float64(time.Millisecond),
+		PeakBufferBytes:    s.PeakBufferBytes,
+		Allocations:        s.Allocations,
+		WindowSize:         s.WindowSize,
+		ContentType:        s.ContentType,
+		Policy:             s.Policy,
+		Warnings:           s.Warnings,
+		Damage:             s.Damage,
+		ChecksumMismatches: s.ChecksumMismatches,
+		Profile:            s.Profile,
+		Checksums:          s.Hashes,
+	}
+	if !s.ModTime.IsZero() {
+		record.ModTime = &s.ModTime
+	}
+	return record
+}
+
+// Stats returns the stats the record was made of
+func (r StatsRecord) Stats() *Stats {
+	stats := &Stats{
+		OriginalSize:       r.OriginalSize,
+		ProcessedSize:      r.ProcessedSize,
+		CompressionRatio:   r.CompressionRatio,
+		Algorithm:          r.Algorithm,
+		Duration:           time.Duration(r.DurationMs * float64(time.Millisecond)),
+		PeakBufferBytes:    r.PeakBufferBytes,
+		Allocations:        r.Allocations,
+		WindowSize:         r.WindowSize,
+		ContentType:        r.ContentType,
+		Policy:             r.Policy,
+		Warnings:           r.Warnings,
+		Damage:             r.Damage,
+		ChecksumMismatches: r.ChecksumMismatches,
+		Profile:            r.Profile,
+		Hashes:             r.Checksums,
+	}
+	if r.ModTime != nil {
+		stats.ModTime = *r.ModTime
+	}
+	return stats
+}
+
+// MarshalJSON serializes the stats as their StatsRecord
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Record())
+}
+
+// ParseStatsRecord reads stats serialized as a StatsRecord. Records of a
+// later schema version fail with ErrStatsSchema, rather than being read
+// with fields that changed their meaning.
+func ParseStatsRecord(data []byte) (*Stats, error) {
+	var record StatsRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if record.Schema < 1 || record.Schema > StatsSchemaVersion {
+		return nil, fmt.Errorf("%w: %d, this build reads up to %d", ErrStatsSchema, record.Schema, StatsSchemaVersion)
+	}
+	return record.Stats(), nil
+}
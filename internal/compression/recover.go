@@ -0,0 +1,55 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+)
+
+// DamagedRange is a run of input that Recover skipped because it did not
+// decode, Offset and Size are in bytes of the compressed input
+type DamagedRange struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error"` // why decoding at Offset failed
+}
+
+// recoverMembers decodes the gzip members of data one after another. A
+// member that does not decode is skipped along with everything up to the
+// next offset a member decodes from, which is reported as a DamagedRange.
+// Like decompressMembers it tries at most maxSpeculativeMembers lookalikes.
+func recoverMembers(ctx context.Context, data []byte) (output []byte, damage []DamagedRange) {
+	tries := 0
+	for offset := 0; offset < len(data); {
+		if err := ctx.Err(); err != nil {
+			return output, append(damage, DamagedRange{Offset: int64(offset), Size: int64(len(data) - offset), Error: err.Error()})
+		}
+		content, n, err := gzip.DecodeMember(data[offset:])
+		if err == nil {
+			output = append(output, content...)
+			offset += n
+			continue
+		}
+		// the damage goes on up to the next member that decodes
+		next, found := offset+1, false
+		for ; tries < maxSpeculativeMembers; tries++ {
+			i := bytes.Index(data[next:], gzip.Magic)
+			if i < 0 {
+				break
+			}
+			next += i
+			if _, _, memberErr := gzip.DecodeMember(data[next:]); memberErr == nil {
+				found = true
+				break
+			}
+			next++
+		}
+		if !found {
+			next = len(data)
+		}
+		damage = append(damage, DamagedRange{Offset: int64(offset), Size: int64(next - offset), Error: err.Error()})
+		offset = next
+	}
+	return output, damage
+}
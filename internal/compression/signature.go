@@ -0,0 +1,160 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Signature recognises a file format by the bytes at Offset, its magic
+type Signature struct {
+	Name        string
+	ContentType string
+	Offset      int
+	Magic       []byte
+}
+
+// Matches reports whether head starts with the signature
+func (s Signature) Matches(head []byte) bool {
+	return len(head) >= s.Offset+len(s.Magic) && bytes.Equal(head[s.Offset:s.Offset+len(s.Magic)], s.Magic)
+}
+
+// DefaultSignatures are the formats recognised on top of those
+// http.DetectContentType knows, which covers images, archives, PDF and
+// others. PNG and PDF are listed to name them in an analysis.
+var DefaultSignatures = []Signature{
+	{Name: "ELF", ContentType: "application/x-elf", Magic: []byte("\x7fELF")},
+	{Name: "PDF", ContentType: "application/pdf", Magic: []byte("%PDF-")},
+	{Name: "PNG", ContentType: "image/png", Magic: []byte("\x89PNG\r\n\x1a\n")},
+	{Name: "xz", ContentType: "application/x-xz", Magic: []byte("\xfd7zXZ\x00")},
+	{Name: "bzip2", ContentType: "application/x-bzip2", Magic: []byte("BZh")},
+	{Name: "zstd", ContentType: "application/zstd", Magic: []byte("\x28\xb5\x2f\xfd")},
+	{Name: "7z", ContentType: "application/x-7z-compressed", Magic: []byte("7z\xbc\xaf\x27\x1c")},
+	{Name: "SQLite", ContentType: "application/vnd.sqlite3", Magic: []byte("SQLite format 3\x00")},
+}
+
+// ParseSignatures reads signatures written as "type=hex" or
+// "type=hex@offset" and separated by commas, e.g.
+// "application/x-parquet=50415231,application/x-tar=7573746172@257". They
+// are named after their content type.
+func ParseSignatures(spec string) ([]Signature, error) {
+	var signatures []Signature
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		contentType, magic, found := strings.Cut(pair, "=")
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		if !found || !strings.Contains(contentType, "/") {
+			return nil, fmt.Errorf("signature %q is not written as type=hex", pair)
+		}
+		signature := Signature{Name: contentType, ContentType: contentType}
+		magic, offset, found := strings.Cut(magic, "@")
+		if found {
+			n, err := strconv.Atoi(strings.TrimSpace(offset))
+			if err != nil || n < 0 || n >= sniffLen {
+				return nil, fmt.Errorf("signature %q: the offset has to be between 0 and %d", pair, sniffLen-1)
+			}
+			signature.Offset = n
+		}
+		decoded, err := hex.DecodeString(strings.TrimSpace(magic))
+		if err != nil || len(decoded) == 0 {
+			return nil, fmt.Errorf("signature %q: the magic has to be hex bytes", pair)
+		}
+		if signature.Offset+len(decoded) > sniffLen {
+			return nil, fmt.Errorf("signature %q ends past the first %d bytes the input is detected from", pair, sniffLen)
+		}
+		signature.Magic = decoded
+		signatures = append(signatures, signature)
+	}
+	if len(signatures) == 0 {
+		return nil, fmt.Errorf("signatures %q has none", spec)
+	}
+	return signatures, nil
+}
+
+// Detection is what DetectSignature made of an input
+type Detection struct {
+	ContentType string
+	Signature   string // the name of the signature or heuristic that matched, empty for none
+}
+
+// DetectSignature detects the content type of an input as DetectContentType
+// does, checking signatures before the default ones, and tells which of
+// them matched. Text that is not known by its extension is taken for CSV or
+// TSV when its lines split into the same number of fields.
+func DetectSignature(signatures []Signature, name string, head []byte) Detection {
+	head = head[:min(len(head), sniffLen)]
+	for _, list := range [][]Signature{signatures, DefaultSignatures} {
+		for _, signature := range list {
+			if signature.Matches(head) {
+				return Detection{ContentType: signature.ContentType, Signature: signature.Name}
+			}
+		}
+	}
+	contentType := DetectContentType(name, head)
+	if contentType == "text/plain" {
+		if delimited := detectDelimited(head); delimited != "" {
+			return Detection{ContentType: delimited, Signature: "delimited text"}
+		}
+	}
+	return Detection{ContentType: contentType}
+}
+
+// delimiterTypes are the content types of text split by each delimiter
+var delimiterTypes = []struct {
+	delimiter   byte
+	contentType string
+}{
+	{',', "text/csv"},
+	{'\t', "text/tab-separated-values"},
+	{';', "text/csv"},
+}
+
+// minDelimitedLines is how many whole lines have to agree before text is
+// taken for CSV
+const minDelimitedLines = 3
+
+// detectDelimited returns the content type of text whose whole lines all
+// have as many fields, more than one, split by a delimiter, or "". The last
+// line of head may be cut off and is left out. Quoted fields may hold the
+// delimiter but not line breaks.
+func detectDelimited(head []byte) string {
+	lines := bytes.Split(head, []byte("\n"))
+	lines = lines[:len(lines)-1]
+	if len(lines) < minDelimitedLines {
+		return ""
+	}
+	for _, candidate := range delimiterTypes {
+		fields := -1
+		for _, line := range lines {
+			n := countFields(bytes.TrimSuffix(line, []byte("\r")), candidate.delimiter)
+			if n < 2 || fields != -1 && n != fields {
+				fields = -1
+				break
+			}
+			fields = n
+		}
+		if fields > 1 {
+			return candidate.contentType
+		}
+	}
+	return ""
+}
+
+// countFields counts the fields of a line split by delimiter outside quotes
+func countFields(line []byte, delimiter byte) int {
+	fields, quoted := 1, false
+	for _, b := range line {
+		switch {
+		case b == '"':
+			quoted = !quoted
+		case b == delimiter && !quoted:
+			fields++
+		}
+	}
+	return fields
+}
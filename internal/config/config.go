@@ -1,31 +1,434 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/experiment"
+)
+
+// defaultMaxDecodedSize is how large the output of a decompression may grow
+// unless MAX_DECODED_SIZE says otherwise
+const defaultMaxDecodedSize = 1024 * 1024 * 1024 // 1GB
+
+// maxAllowedFileSize is the hard ceiling for MAX_FILE_SIZE since uploads are held in memory
+const maxAllowedFileSize = 1024 * 1024 * 1024 // 1GB
+
+// knownEnvironments lists the accepted values for GO_ENV
+var knownEnvironments = []string{"development", "test", "staging", "production"}
+
+// requestLogs are the accepted values for REQUEST_LOG, and the default of
+// each environment
+var (
+	requestLogs        = []string{"all", "errors", "none"}
+	defaultRequestLogs = map[string]string{"development": "all", "test": "none", "staging": "errors", "production": "errors"}
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port        string
-	Environment string
-	MaxFileSize int64 // in bytes
+	Port             string
+	Environment      string
+	MaxFileSize      int64  // in bytes
+	MaxDecodedSize   int64  // largest output of a decompression, in bytes
+	DefaultAlgorithm string // used when a compress request omits the algorithm
+	TLSCertFile      string
+	TLSKeyFile       string
+	DebugEndpoints   bool          // serve /debug/pprof and /debug/runtime, by default in development only
+	RequestLog       string        // which requests are logged: all, errors or none
+	StatsDB          string        // file the stats of every job are kept in, none when empty
+	SessionMaxSize   int64         // largest input of an upload session, in bytes
+	SessionTTL       time.Duration // upload sessions unused for this long are deleted
+	ExportDir        string        // directory /api/v1/export serves tar.gz exports of, none when empty
+	ImportDir        string        // directory /api/v1/import extracts uploaded tar.gz archives into, none when empty
+	DictionaryDir    string        // directory uploaded dictionaries are kept in, memory only when empty
+
+	// ResultCacheSize is how many bytes of decompressed output are kept for
+	// inputs that are decompressed again, 0 for none. They are kept in
+	// ResultCacheDir when it is set and in memory otherwise.
+	ResultCacheSize int64
+	ResultCacheDir  string
+
+	// ResultStoreSize is how many bytes of the latest outputs are kept in
+	// memory to be downloaded again by their X-Result-Token, 0 for none
+	ResultStoreSize int64
+
+	// Every API key keeps its kept outputs and dictionaries in a namespace of
+	// its own, without API keys they are shared. ResultStoreQuota is how many
+	// bytes of kept outputs a namespace holds before its least recently used
+	// are dropped, DictionaryQuota how many bytes of dictionaries it may
+	// upload, 0 for no quota.
+	ResultStoreQuota int64
+	DictionaryQuota  int64
+
+	// The janitor sweeps the temporary objects every JanitorInterval. Cached
+	// and kept outputs unused for ResultCacheTTL and ResultStoreTTL are
+	// dropped, 0 keeps them for as long as there is room. Idle sessions are
+	// deleted, the least recently used first, while their data takes up more
+	// than SpillQuota bytes, 0 for no quota. Session files no session holds
+	// are deleted after SessionTTL.
+	JanitorInterval time.Duration
+	ResultCacheTTL  time.Duration
+	ResultStoreTTL  time.Duration
+	SpillQuota      int64
+
+	// CompressionPolicy holds the rules algorithm=auto compresses by, such as
+	// "image/*=store,text/*=flate", compression.DefaultPolicy when empty
+	CompressionPolicy string
+
+	// Signatures recognise file formats for algorithm=auto and /analyze on
+	// top of the built-in ones, such as "application/x-parquet=50415231"
+	Signatures string
+
+	// DisabledAlgorithms are the algorithms requests may not compress or
+	// decompress with, such as "lzss,huffman". They are left out of /info
+	// and of the algorithm=auto policy.
+	DisabledAlgorithms string
+
+	// Checksums are the checksum algorithms the X-Checksum of a compression
+	// lists, such as "crc32,sha256", computed while the input is compressed.
+	// "crc32" when empty.
+	Checksums string
+
+	// Pipelines defines ways of compressing that requests select by name,
+	// such as "logs: delta+flate btype=2; images: store"
+	Pipelines string
+
+	// ExperimentCandidate is compressed with again, in the background, for
+	// ExperimentPercent of the compressions, to compare with what the
+	// requests asked for. At most ExperimentConcurrency of them run at once.
+	ExperimentCandidate   string
+	ExperimentPercent     float64
+	ExperimentConcurrency int64
+
+	// Bandwidth limits for compressed and decompressed downloads, in bytes
+	// per second, 0 for none. ThrottleRate is shared by all responses,
+	// ThrottleRequestRate applies to each one.
+	ThrottleRate        int64
+	ThrottleRequestRate int64
+
+	// Workers is how many compressions and decompressions run at once, 0
+	// for no limit. Jobs beyond it wait, interactive ones before batch ones.
+	Workers int64
+
+	// ProxyTarget turns the server into a reverse proxy for this URL that
+	// compresses its responses, none when empty. Responses of at least
+	// ProxyMinSize bytes whose media types are in ProxyContentTypes, a comma
+	// separated list, are compressed, httpgzip's defaults when empty.
+	ProxyTarget       string
+	ProxyMinSize      int64
+	ProxyContentTypes string
+
+	// problems collects errors found while parsing environment variables
+	problems []string
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Problems))
+	for _, problem := range e.Problems {
+		fmt.Fprintf(&b, "\n  - %s", problem)
+	}
+	return b.String()
 }
 
 // Load loads configuration from environment variables with defaults
 func Load() *Config {
 	cfg := &Config{
-		Port:        getEnv("PORT", "8080"),
-		Environment: getEnv("GO_ENV", "development"),
-		MaxFileSize: 50 * 1024 * 1024, // 50MB default
+		Port:             getEnv("PORT", "8080"),
+		Environment:      getEnv("GO_ENV", "development"),
+		DefaultAlgorithm: getEnv("DEFAULT_ALGORITHM", ""),
+		TLSCertFile:      getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:       getEnv("TLS_KEY_FILE", ""),
+		StatsDB:          getEnv("STATS_DB", ""),
+		ExportDir:        getEnv("EXPORT_DIR", ""),
+		ImportDir:        getEnv("IMPORT_DIR", ""),
+		DictionaryDir:    getEnv("DICTIONARY_DIR", ""),
+		ResultCacheDir:   getEnv("RESULT_CACHE_DIR", ""),
+
+		CompressionPolicy:   getEnv("COMPRESSION_POLICY", ""),
+		Signatures:          getEnv("SIGNATURES", ""),
+		Checksums:           getEnv("CHECKSUMS", ""),
+		DisabledAlgorithms:  getEnv("DISABLED_ALGORITHMS", ""),
+		ExperimentCandidate: getEnv("EXPERIMENT_CANDIDATE", ""),
+		Pipelines:           getEnv("PIPELINES", ""),
+		ProxyTarget:         getEnv("PROXY_TARGET", ""),
+		ProxyContentTypes:   getEnv("PROXY_CONTENT_TYPES", ""),
 	}
+	cfg.MaxFileSize = cfg.getEnvInt64("MAX_FILE_SIZE", 50*1024*1024) // 50MB default
+	cfg.MaxDecodedSize = cfg.getEnvInt64("MAX_DECODED_SIZE", defaultMaxDecodedSize)
+	cfg.DebugEndpoints = cfg.getEnvBool("DEBUG_ENDPOINTS", cfg.Environment == "development")
+	cfg.RequestLog = getEnv("REQUEST_LOG", defaultRequestLogs[cfg.Environment])
+	cfg.SessionMaxSize = cfg.getEnvInt64("MAX_SESSION_SIZE", 1024*1024*1024) // 1GB default
+	cfg.SessionTTL = cfg.getEnvDuration("SESSION_TTL", 30*time.Minute)
+	cfg.ResultCacheSize = cfg.getEnvInt64("RESULT_CACHE_SIZE", 0)
+	cfg.ResultStoreSize = cfg.getEnvInt64("RESULT_STORE_SIZE", 0)
+	cfg.ResultStoreQuota = cfg.getEnvInt64("RESULT_STORE_QUOTA", 0)
+	cfg.DictionaryQuota = cfg.getEnvInt64("DICTIONARY_QUOTA", 0)
+	cfg.JanitorInterval = cfg.getEnvDuration("JANITOR_INTERVAL", time.Minute)
+	cfg.ResultCacheTTL = cfg.getEnvDuration("RESULT_CACHE_TTL", 0)
+	cfg.ResultStoreTTL = cfg.getEnvDuration("RESULT_STORE_TTL", 0)
+	cfg.SpillQuota = cfg.getEnvInt64("SPILL_QUOTA", 0)
+	cfg.ExperimentPercent = cfg.getEnvFloat64("EXPERIMENT_PERCENT", 1)
+	cfg.ExperimentConcurrency = cfg.getEnvInt64("EXPERIMENT_CONCURRENCY", 1)
+	cfg.ThrottleRate = cfg.getEnvInt64("THROTTLE_RATE", 0)
+	cfg.ThrottleRequestRate = cfg.getEnvInt64("THROTTLE_REQUEST_RATE", 0)
+	cfg.ProxyMinSize = cfg.getEnvInt64("PROXY_MIN_SIZE", 0)
+	cfg.Workers = cfg.getEnvInt64("WORKERS", 0)
 
 	return cfg
 }
 
+// TLSEnabled reports whether the server should serve HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem found, or nil when the configuration is usable
+func (c *Config) Validate() error {
+	problems := append([]string{}, c.problems...)
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be a number between 1 and 65535, got %q", c.Port))
+	}
+
+	if !slices.Contains(knownEnvironments, c.Environment) {
+		problems = append(problems, fmt.Sprintf("GO_ENV must be one of %v, got %q", knownEnvironments, c.Environment))
+	}
+	if !slices.Contains(requestLogs, c.RequestLog) {
+		problems = append(problems, fmt.Sprintf("REQUEST_LOG must be one of %v, got %q", requestLogs, c.RequestLog))
+	}
+
+	if c.MaxFileSize <= 0 {
+		problems = append(problems, fmt.Sprintf("MAX_FILE_SIZE must be positive, got %d", c.MaxFileSize))
+	} else if c.MaxFileSize > maxAllowedFileSize {
+		problems = append(problems, fmt.Sprintf("MAX_FILE_SIZE must not exceed %d bytes, got %d", maxAllowedFileSize, c.MaxFileSize))
+	}
+
+	if c.MaxDecodedSize <= 0 {
+		problems = append(problems, fmt.Sprintf("MAX_DECODED_SIZE must be positive, got %d", c.MaxDecodedSize))
+	}
+
+	if c.SessionMaxSize <= 0 {
+		problems = append(problems, fmt.Sprintf("MAX_SESSION_SIZE must be positive, got %d", c.SessionMaxSize))
+	}
+	if c.SessionTTL <= 0 {
+		problems = append(problems, fmt.Sprintf("SESSION_TTL must be positive, got %s", c.SessionTTL))
+	}
+
+	if c.DefaultAlgorithm != "" && !compression.IsValidAlgorithm(c.DefaultAlgorithm) {
+		problems = append(problems, fmt.Sprintf("DEFAULT_ALGORITHM %q is not supported, use one of %v", c.DefaultAlgorithm, compression.GetSupportedAlgorithms()))
+	}
+
+	if c.DisabledAlgorithms != "" {
+		if disabled, err := compression.ParseAlgorithms(c.DisabledAlgorithms); err != nil {
+			problems = append(problems, fmt.Sprintf("DISABLED_ALGORITHMS is invalid: %v", err))
+		} else if slices.Contains(disabled, c.DefaultAlgorithm) {
+			problems = append(problems, fmt.Sprintf("DEFAULT_ALGORITHM %q is disabled by DISABLED_ALGORITHMS", c.DefaultAlgorithm))
+		} else if !slices.ContainsFunc(compression.GetSupportedAlgorithms(), func(algorithm string) bool { return !slices.Contains(disabled, algorithm) }) {
+			problems = append(problems, "DISABLED_ALGORITHMS disables every algorithm")
+		}
+	}
+
+	if c.ResultCacheSize < 0 {
+		problems = append(problems, fmt.Sprintf("RESULT_CACHE_SIZE must not be negative, got %d", c.ResultCacheSize))
+	}
+	if c.ResultStoreSize < 0 {
+		problems = append(problems, fmt.Sprintf("RESULT_STORE_SIZE must not be negative, got %d", c.ResultStoreSize))
+	}
+	if c.ResultStoreQuota < 0 {
+		problems = append(problems, fmt.Sprintf("RESULT_STORE_QUOTA must not be negative, got %d", c.ResultStoreQuota))
+	}
+	if c.DictionaryQuota < 0 {
+		problems = append(problems, fmt.Sprintf("DICTIONARY_QUOTA must not be negative, got %d", c.DictionaryQuota))
+	}
+
+	if c.JanitorInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("JANITOR_INTERVAL must be positive, got %s", c.JanitorInterval))
+	}
+	if c.ResultCacheTTL < 0 {
+		problems = append(problems, fmt.Sprintf("RESULT_CACHE_TTL must not be negative, got %s", c.ResultCacheTTL))
+	}
+	if c.ResultStoreTTL < 0 {
+		problems = append(problems, fmt.Sprintf("RESULT_STORE_TTL must not be negative, got %s", c.ResultStoreTTL))
+	}
+	if c.SpillQuota < 0 {
+		problems = append(problems, fmt.Sprintf("SPILL_QUOTA must not be negative, got %d", c.SpillQuota))
+	}
+
+	if c.ThrottleRate < 0 {
+		problems = append(problems, fmt.Sprintf("THROTTLE_RATE must not be negative, got %d", c.ThrottleRate))
+	}
+	if c.ThrottleRequestRate < 0 {
+		problems = append(problems, fmt.Sprintf("THROTTLE_REQUEST_RATE must not be negative, got %d", c.ThrottleRequestRate))
+	}
+
+	if c.Workers < 0 {
+		problems = append(problems, fmt.Sprintf("WORKERS must not be negative, got %d", c.Workers))
+	}
+
+	if c.CompressionPolicy != "" {
+		if _, err := compression.ParsePolicy(c.CompressionPolicy); err != nil {
+			problems = append(problems, fmt.Sprintf("COMPRESSION_POLICY is invalid: %v", err))
+		}
+	}
+
+	if c.Signatures != "" {
+		if _, err := compression.ParseSignatures(c.Signatures); err != nil {
+			problems = append(problems, fmt.Sprintf("SIGNATURES is invalid: %v", err))
+		}
+	}
+
+	if c.Checksums != "" {
+		if _, err := checksum.ParseAlgorithms(c.Checksums); err != nil {
+			problems = append(problems, fmt.Sprintf("CHECKSUMS is invalid: %v", err))
+		}
+	}
+
+	if c.Pipelines != "" {
+		if _, err := compression.ParsePipelines(c.Pipelines); err != nil {
+			problems = append(problems, fmt.Sprintf("PIPELINES is invalid: %v", err))
+		}
+	}
+
+	if c.ExperimentCandidate != "" {
+		if _, err := experiment.ParseCandidate(c.ExperimentCandidate); err != nil {
+			problems = append(problems, fmt.Sprintf("EXPERIMENT_CANDIDATE is invalid: %v", err))
+		}
+		if c.ExperimentPercent < 0 || c.ExperimentPercent > 100 {
+			problems = append(problems, fmt.Sprintf("EXPERIMENT_PERCENT must be between 0 and 100, got %g", c.ExperimentPercent))
+		}
+		if c.ExperimentConcurrency < 1 {
+			problems = append(problems, fmt.Sprintf("EXPERIMENT_CONCURRENCY must be positive, got %d", c.ExperimentConcurrency))
+		}
+	}
+
+	if c.ProxyTarget != "" {
+		if target, err := url.Parse(c.ProxyTarget); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			problems = append(problems, fmt.Sprintf("PROXY_TARGET must be an http or https URL, got %q", c.ProxyTarget))
+		}
+	}
+	if c.ProxyMinSize < 0 {
+		problems = append(problems, fmt.Sprintf("PROXY_MIN_SIZE must not be negative, got %d", c.ProxyMinSize))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for _, file := range []struct{ key, path string }{{"TLS_CERT_FILE", c.TLSCertFile}, {"TLS_KEY_FILE", c.TLSKeyFile}} {
+		key, path := file.key, file.path
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not readable: %v", key, path, err))
+		} else if info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s %q is a directory", key, path))
+		}
+	}
+
+	if c.StatsDB != "" {
+		if info, err := os.Stat(filepath.Dir(c.StatsDB)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("STATS_DB %q is not in an existing directory", c.StatsDB))
+		}
+	}
+
+	if c.ExportDir != "" {
+		if info, err := os.Stat(c.ExportDir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("EXPORT_DIR %q is not an existing directory", c.ExportDir))
+		}
+	}
+
+	if c.ImportDir != "" {
+		if info, err := os.Stat(c.ImportDir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("IMPORT_DIR %q is not an existing directory", c.ImportDir))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvInt64 gets an integer environment variable or returns a default value,
+// recording a problem if the variable is set but cannot be parsed
+func (c *Config) getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be an integer, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvFloat64 gets a number environment variable or returns a default
+// value, recording a problem if the variable is set but cannot be parsed
+func (c *Config) getEnvFloat64(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be a number, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvBool gets a boolean environment variable or returns a default value,
+// recording a problem if the variable is set but cannot be parsed
+func (c *Config) getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be true or false, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvDuration gets a duration environment variable such as "30m" or
+// returns a default value, recording a problem if the variable is set but
+// cannot be parsed
+func (c *Config) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("%s must be a duration such as 30m, got %q", key, value))
+		return defaultValue
+	}
+	return parsed
+}
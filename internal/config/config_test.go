@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// valid returns a configuration Validate accepts, as Load returns it
+// without environment variables
+func valid() *Config {
+	return &Config{
+		Port:                  "8080",
+		Environment:           "development",
+		MaxFileSize:           50 << 20,
+		MaxDecodedSize:        defaultMaxDecodedSize,
+		RequestLog:            "all",
+		SessionMaxSize:        1 << 30,
+		SessionTTL:            30 * time.Minute,
+		JanitorInterval:       time.Minute,
+		ExperimentPercent:     1,
+		ExperimentConcurrency: 1,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid configuration: %v", err)
+	}
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name   string
+		modify func(*Config)
+		want   string // what the problem mentions
+	}{
+		{"port out of range", func(c *Config) { c.Port = "70000" }, "PORT"},
+		{"port not a number", func(c *Config) { c.Port = "http" }, "PORT"},
+		{"unknown environment", func(c *Config) { c.Environment = "prod" }, "GO_ENV"},
+		{"file size zero", func(c *Config) { c.MaxFileSize = 0 }, "MAX_FILE_SIZE"},
+		{"file size over 1 GB", func(c *Config) { c.MaxFileSize = maxAllowedFileSize + 1 }, "MAX_FILE_SIZE"},
+		{"unknown default algorithm", func(c *Config) { c.DefaultAlgorithm = "brotli" }, "DEFAULT_ALGORITHM"},
+		{"certificate without key", func(c *Config) { c.TLSCertFile = filepath.Join(dir, "cert.pem") }, "TLS_CERT_FILE and TLS_KEY_FILE"},
+		{"missing key file", func(c *Config) {
+			c.TLSCertFile, c.TLSKeyFile = dir, filepath.Join(dir, "key.pem")
+		}, "TLS_KEY_FILE"},
+		{"certificate is a directory", func(c *Config) {
+			c.TLSCertFile, c.TLSKeyFile = dir, filepath.Join(dir, "key.pem")
+		}, "is a directory"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := valid()
+			test.modify(c)
+			var validation *ValidationError
+			if err := c.Validate(); !errors.As(err, &validation) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if !strings.Contains(validation.Error(), test.want) {
+				t.Errorf("%v does not mention %s", validation, test.want)
+			}
+		})
+	}
+}
+
+// TestValidateReportsEveryProblem checks that the problems of every field
+// come in one error, so a deployment is fixed in one go
+func TestValidateReportsEveryProblem(t *testing.T) {
+	t.Setenv("MAX_DECODED_SIZE", "lots")
+	c := Load()
+	c.Port = "0"
+	c.Environment = "prod"
+	c.MaxFileSize = maxAllowedFileSize + 1
+	c.DefaultAlgorithm = "brotli"
+	c.TLSCertFile = filepath.Join(t.TempDir(), "cert.pem")
+	c.TLSKeyFile = ""
+
+	var validation *ValidationError
+	if err := c.Validate(); !errors.As(err, &validation) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	for _, want := range []string{"MAX_DECODED_SIZE", "PORT", "GO_ENV", "MAX_FILE_SIZE", "DEFAULT_ALGORITHM", "TLS_CERT_FILE and TLS_KEY_FILE", "TLS_CERT_FILE \""} {
+		if !slices.ContainsFunc(validation.Problems, func(problem string) bool { return strings.Contains(problem, want) }) {
+			t.Errorf("no problem mentions %s: %q", want, validation.Problems)
+		}
+	}
+	if !strings.HasPrefix(validation.Error(), "invalid configuration (7 problems):") {
+		t.Errorf("Error() = %q", validation.Error())
+	}
+}
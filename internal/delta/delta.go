@@ -0,0 +1,253 @@
+package delta
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// A delta is laid out as
+//
+//	magic         "CFDD"
+//	version       1 byte
+//	old size      uvarint
+//	new size      uvarint
+//	old CRC-32    uint32, to refuse patching the wrong file
+//	new CRC-32    uint32, to check the result
+//	encoding      1 byte, 0 for raw instructions, 1 for Huffman coded ones
+//	instructions  until the end
+//
+// An instruction is an insert, byte 0, a uvarint length and that many bytes
+// of the new file, or a copy, byte 1, a varint offset into the old file
+// relative to where the previous copy ended and a uvarint length. Relative
+// offsets keep copies from mostly unchanged files short. Fixed width
+// integers are little-endian.
+
+const (
+	magic   = "CFDD"
+	version = 1
+
+	opInsert = 0
+	opCopy   = 1
+
+	encodingRaw     = 0
+	encodingHuffman = 1
+
+	// hashLength bytes of the old file are hashed to find match candidates
+	hashLength = 8
+	// minMatch is the shortest copy, shorter matches cost about as much as
+	// inserting the bytes and break up the inserts around them
+	minMatch = 16
+	// maxTableBits bounds the hash table to 16MB however large the old file
+	maxTableBits = 22
+)
+
+// Errors returned by Diff and Patch
+var (
+	ErrNotDelta           = errors.New("delta: not a delta")
+	ErrUnsupportedVersion = errors.New("delta: unsupported version")
+	ErrBaseMismatch       = errors.New("delta: the delta was made from a different old file")
+	ErrCorrupt            = errors.New("delta: corrupt delta")
+	ErrChecksumMismatch   = errors.New("delta: patched file does not match the checksum")
+)
+
+// Diff returns a delta that turns oldData into newData. Runs of newData that
+// also occur in oldData become copies, the rest is inserted, and the
+// instructions are Huffman coded when that makes them smaller.
+func Diff(ctx context.Context, oldData, newData []byte) ([]byte, error) {
+	instructions, err := diff(ctx, oldData, newData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte(magic)
+	out = append(out, version)
+	out = binary.AppendUvarint(out, uint64(len(oldData)))
+	out = binary.AppendUvarint(out, uint64(len(newData)))
+	out = binary.LittleEndian.AppendUint32(out, checksum.SumCRC32(oldData))
+	out = binary.LittleEndian.AppendUint32(out, checksum.SumCRC32(newData))
+	if len(instructions) > 0 {
+		coded, _, err := compression.CompressContext(ctx, instructions, compression.Options{Algorithm: "huffman"})
+		if err != nil {
+			return nil, err
+		}
+		if len(coded) < len(instructions) {
+			return append(append(out, encodingHuffman), coded...), nil
+		}
+	}
+	return append(append(out, encodingRaw), instructions...), nil
+}
+
+// diff finds the copies and inserts greedily: at each position of newData
+// it looks up the last position of oldData with the same hash, extends the
+// match in both directions and takes it if it is long enough
+func diff(ctx context.Context, oldData, newData []byte) ([]byte, error) {
+	bits := tableBits(len(oldData))
+	table := make([]int32, 1<<bits) // positions + 1, 0 is empty
+	for p := 0; p+hashLength <= len(oldData); p++ {
+		table[hash(oldData[p:], bits)] = int32(p + 1)
+	}
+
+	var out []byte
+	insertStart, copyEnd := 0, 0
+	for i, steps := 0, 0; i+hashLength <= len(newData); steps++ {
+		if steps%(1<<20) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		candidate := int(table[hash(newData[i:], bits)]) - 1
+		if candidate < 0 {
+			i++
+			continue
+		}
+		forward := matchLength(oldData[candidate:], newData[i:])
+		backward := 0
+		for backward < i-insertStart && backward < candidate && oldData[candidate-backward-1] == newData[i-backward-1] {
+			backward++
+		}
+		if forward+backward < minMatch {
+			i++
+			continue
+		}
+
+		start := candidate - backward
+		out = appendInsert(out, newData[insertStart:i-backward])
+		out = append(out, opCopy)
+		out = binary.AppendVarint(out, int64(start-copyEnd))
+		out = binary.AppendUvarint(out, uint64(forward+backward))
+		copyEnd = start + forward + backward
+		i += forward
+		insertStart = i
+	}
+	return appendInsert(out, newData[insertStart:]), nil
+}
+
+func appendInsert(out, data []byte) []byte {
+	if len(data) == 0 {
+		return out
+	}
+	out = append(out, opInsert)
+	out = binary.AppendUvarint(out, uint64(len(data)))
+	return append(out, data...)
+}
+
+// tableBits sizes the hash table to about one slot per position of the
+// old file
+func tableBits(n int) uint {
+	bits := uint(10)
+	for bits < maxTableBits && 1<<bits < n {
+		bits++
+	}
+	return bits
+}
+
+func hash(b []byte, bits uint) uint32 {
+	return uint32((binary.LittleEndian.Uint64(b) * 0x9e3779b97f4a7c15) >> (64 - bits))
+}
+
+func matchLength(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Patch applies a delta made by Diff to oldData and returns the new file. A
+// delta made from another old file is refused with ErrBaseMismatch before
+// anything is applied. A new file of more than maxSize bytes, 0 for no
+// limit, is refused with a *compression.SizeLimitError before anything is
+// decoded.
+func Patch(ctx context.Context, oldData, delta []byte, maxSize int64) ([]byte, error) {
+	if !bytes.HasPrefix(delta, []byte(magic)) || len(delta) <= len(magic) {
+		return nil, ErrNotDelta
+	}
+	if v := delta[len(magic)]; v != version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	rest := delta[len(magic)+1:]
+	oldSize, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: header", ErrCorrupt)
+	}
+	rest = rest[n:]
+	newSize, n := binary.Uvarint(rest)
+	if n <= 0 || len(rest[n:]) < 4+4+1 {
+		return nil, fmt.Errorf("%w: header", ErrCorrupt)
+	}
+	rest = rest[n:]
+	oldCRC, newCRC := binary.LittleEndian.Uint32(rest), binary.LittleEndian.Uint32(rest[4:])
+	encoding, instructions := rest[8], rest[9:]
+	if oldSize != uint64(len(oldData)) || oldCRC != checksum.SumCRC32(oldData) {
+		return nil, ErrBaseMismatch
+	}
+	// copies may read the same bytes of the old file any number of times, so
+	// a small delta can make a file far larger than both of its inputs
+	if maxSize > 0 && newSize > uint64(maxSize) {
+		return nil, &compression.SizeLimitError{Limit: maxSize}
+	}
+
+	switch encoding {
+	case encodingRaw:
+	case encodingHuffman:
+		decoded, _, err := compression.DecompressContext(ctx, instructions, compression.Options{Algorithm: "huffman"})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		instructions = decoded
+	default:
+		return nil, fmt.Errorf("%w: unknown encoding %d", ErrCorrupt, encoding)
+	}
+
+	// the size is checked against what the instructions produce, it is not
+	// trusted for the allocation
+	out := make([]byte, 0, min(newSize, uint64(len(oldData))+uint64(len(instructions))))
+	copyEnd := int64(0)
+	for len(instructions) > 0 {
+		op := instructions[0]
+		instructions = instructions[1:]
+		switch op {
+		case opInsert:
+			length, n := binary.Uvarint(instructions)
+			if n <= 0 || length > uint64(len(instructions)-n) {
+				return nil, fmt.Errorf("%w: insert runs past the end", ErrCorrupt)
+			}
+			out = append(out, instructions[n:n+int(length)]...)
+			instructions = instructions[n+int(length):]
+		case opCopy:
+			relative, n := binary.Varint(instructions)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: copy offset", ErrCorrupt)
+			}
+			instructions = instructions[n:]
+			length, n := binary.Uvarint(instructions)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: copy length", ErrCorrupt)
+			}
+			instructions = instructions[n:]
+			start := copyEnd + relative
+			if start < 0 || start > int64(len(oldData)) || length > uint64(int64(len(oldData))-start) {
+				return nil, fmt.Errorf("%w: copy of %d bytes at %d is outside the old file", ErrCorrupt, length, start)
+			}
+			out = append(out, oldData[start:start+int64(length)]...)
+			copyEnd = start + int64(length)
+		default:
+			return nil, fmt.Errorf("%w: unknown instruction %d", ErrCorrupt, op)
+		}
+		if uint64(len(out)) > newSize {
+			return nil, fmt.Errorf("%w: output is longer than %d bytes", ErrCorrupt, newSize)
+		}
+	}
+	if uint64(len(out)) != newSize || checksum.SumCRC32(out) != newCRC {
+		return nil, ErrChecksumMismatch
+	}
+	return out, nil
+}
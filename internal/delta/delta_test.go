@@ -0,0 +1,106 @@
+package delta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// TestDiffPatch edits a file in the ways updates do and checks the delta
+// rebuilds the new file while being much smaller than it
+func TestDiffPatch(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	old := make([]byte, 64*1024)
+	rng.Read(old)
+
+	edited := bytes.Clone(old[:10000])
+	edited = append(edited, []byte("a few inserted bytes")...)
+	edited = append(edited, old[10000:30000]...)
+	edited = append(edited, old[31000:50000]...) // 1000 bytes deleted
+	edited = append(edited, old[55000:]...)
+	edited = append(edited, old[:2000]...) // a block moved to the end
+	copy(edited[40000:], "overwritten")
+
+	tests := []struct {
+		name     string
+		old, new []byte
+		maxSize  int
+	}{
+		{"edited", old, edited, 200},
+		{"identical", old, old, 40},
+		{"from empty", nil, []byte("all of it is new"), 40},
+		{"to empty", old, nil, 40},
+	}
+	for _, test := range tests {
+		delta, err := Diff(ctx, test.old, test.new)
+		if err != nil {
+			t.Fatalf("%s: Diff: %v", test.name, err)
+		}
+		if len(delta) > test.maxSize {
+			t.Errorf("%s: delta has %d bytes, want at most %d", test.name, len(delta), test.maxSize)
+		}
+		patched, err := Patch(ctx, test.old, delta, 0)
+		if err != nil {
+			t.Fatalf("%s: Patch: %v", test.name, err)
+		}
+		if !bytes.Equal(patched, test.new) {
+			t.Errorf("%s: patched file does not match the new one", test.name)
+		}
+	}
+
+	delta, _ := Diff(ctx, old, edited)
+	if _, err := Patch(ctx, edited, delta, 0); !errors.Is(err, ErrBaseMismatch) {
+		t.Errorf("Patch of the wrong file = %v, want ErrBaseMismatch", err)
+	}
+	if _, err := Patch(ctx, old, []byte("not a delta"), 0); !errors.Is(err, ErrNotDelta) {
+		t.Errorf("Patch with garbage = %v, want ErrNotDelta", err)
+	}
+}
+
+// TestPatchLimit copies the old file over and over, a delta far smaller than
+// the file it makes
+func TestPatchLimit(t *testing.T) {
+	ctx := context.Background()
+	old := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(old)
+	delta, err := Diff(ctx, old, bytes.Repeat(old, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limitErr *compression.SizeLimitError
+	if _, err := Patch(ctx, old, delta, 16*4096); !errors.As(err, &limitErr) || limitErr.Limit != 16*4096 {
+		t.Errorf("Patch past the limit = %v, want a *compression.SizeLimitError", err)
+	}
+	if patched, err := Patch(ctx, old, delta, 64*4096); err != nil || len(patched) != 64*4096 {
+		t.Errorf("Patch at the limit = %d bytes, %v", len(patched), err)
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	var old, new bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&old, "config_%d = %d\n", i, i*7)
+		if i%250 == 0 {
+			fmt.Fprintf(&new, "config_%d = changed\n", i)
+			continue
+		}
+		fmt.Fprintf(&new, "config_%d = %d\n", i, i*7)
+	}
+	delta, err := Diff(context.Background(), old.Bytes(), new.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := Patch(context.Background(), old.Bytes(), delta, 0)
+	if err != nil || !bytes.Equal(patched, new.Bytes()) {
+		t.Fatalf("Patch = %v, or the result does not match", err)
+	}
+	if len(delta) > new.Len()/20 {
+		t.Errorf("delta of 8 changed lines has %d bytes", len(delta))
+	}
+}
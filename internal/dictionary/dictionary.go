@@ -0,0 +1,358 @@
+// Package dictionary keeps the named preset dictionaries flate and zlib are
+// primed with. Dictionaries are held in memory, so a request that names one
+// does not read it from anywhere, and are written to a directory as well
+// when the store has one, so they outlive a restart. Every dictionary lives
+// in a namespace, the names of one do not clash with those of another, and
+// a namespace may take up no more than the quota of the store. A few
+// dictionaries for common kinds of input are built into the binary and seen
+// in every namespace, so they can be named without uploading anything.
+package dictionary
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+)
+
+// MaxSize is the largest dictionary, deflate matches cannot reach further
+// back than its 32 KiB window
+const MaxSize = 32 << 10
+
+// fileSuffix is the extension of the files dictionaries are kept in
+const fileSuffix = ".dict"
+
+var (
+	// ErrNotFound is returned for names no dictionary is stored under
+	ErrNotFound = errors.New("dictionary: not found")
+
+	// ErrInvalidName is returned by Put for names that are empty, longer
+	// than 64 bytes or have characters other than letters, digits, '.', '_'
+	// and '-'
+	ErrInvalidName = errors.New("dictionary: invalid name")
+
+	// ErrTooLarge is returned by Put for dictionaries over MaxSize
+	ErrTooLarge = errors.New("dictionary: larger than 32 KiB")
+
+	// ErrEmpty is returned by Put for an empty dictionary
+	ErrEmpty = errors.New("dictionary: empty")
+
+	// ErrInvalidNamespace is returned for namespaces longer than 64 bytes or
+	// with characters other than letters, digits, '_' and '-'
+	ErrInvalidNamespace = errors.New("dictionary: invalid namespace")
+
+	// ErrQuotaExceeded is returned by Put when the dictionary would take the
+	// namespace past the quota
+	ErrQuotaExceeded = errors.New("dictionary: namespace quota exceeded")
+
+	// ErrBuiltin is returned by Put and Delete for the names of built-in
+	// dictionaries, which cannot be replaced or deleted
+	ErrBuiltin = errors.New("dictionary: built in")
+)
+
+// The built-in dictionaries, json, html and logs, hold the keys, tags and
+// line prefixes common in each kind of input, the most common last where
+// matches reach them with the shortest distances
+//
+//go:embed builtin/*.dict
+var builtinFiles embed.FS
+
+// builtinDir is where builtinFiles holds the dictionaries
+const builtinDir = "builtin"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// validNamespace is what the directories of namespaces are named, without
+// the '.' of the files dictionaries are kept in. The empty namespace is kept
+// in the directory of the store itself.
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9_-]{0,64}$`)
+
+// Info describes a stored dictionary
+type Info struct {
+	Name    string    `json:"name"`
+	Size    int       `json:"size"`
+	ID      string    `json:"id"` // Adler-32 in hex, the DICTID of zlib streams primed with it
+	Created time.Time `json:"created"`
+	Builtin bool      `json:"builtin,omitempty"` // built into the binary, in every namespace
+}
+
+// Usage is what a namespace takes up of its quota
+type Usage struct {
+	Dictionaries int   `json:"dictionaries"`
+	Bytes        int64 `json:"bytes"`
+	Quota        int64 `json:"quota,omitempty"` // 0 for none
+}
+
+type entry struct {
+	info Info
+	data []byte
+}
+
+// Store holds the dictionaries by namespace and name
+type Store struct {
+	dir string
+
+	builtin map[string]*entry // read only, so not under lock
+
+	lock       sync.RWMutex
+	quota      int64
+	namespaces map[string]map[string]*entry
+}
+
+// Open returns a store keeping its dictionaries in dir and loads the ones
+// already there, those of a namespace from the directory named after it.
+// With an empty dir the store is in memory only. The built-in dictionaries
+// are there either way.
+func Open(dir string) (*Store, error) {
+	builtin, err := loadBuiltin()
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, builtin: builtin, namespaces: make(map[string]map[string]*entry)}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("dictionary: %w", err)
+	}
+	if err := s.load(""); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("dictionary: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() && file.Name() != "" && validNamespace.MatchString(file.Name()) {
+			if err := s.load(file.Name()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// load reads the dictionaries of namespace from its directory
+func (s *Store) load(namespace string) error {
+	files, err := os.ReadDir(s.path(namespace))
+	if err != nil {
+		return fmt.Errorf("dictionary: %w", err)
+	}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), fileSuffix)
+		if !ok || !file.Type().IsRegular() || !validName.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(s.path(namespace), file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("dictionary: %w", err)
+		}
+		if len(data) == 0 || len(data) > MaxSize {
+			return fmt.Errorf("dictionary: %s has %d bytes, it has to have 1 to %d", path, len(data), MaxSize)
+		}
+		created := time.Now()
+		if fileInfo, err := file.Info(); err == nil {
+			created = fileInfo.ModTime()
+		}
+		if s.namespaces[namespace] == nil {
+			s.namespaces[namespace] = make(map[string]*entry)
+		}
+		s.namespaces[namespace][name] = newEntry(name, data, created)
+	}
+	return nil
+}
+
+// loadBuiltin reads the dictionaries embedded in builtinFiles
+func loadBuiltin() (map[string]*entry, error) {
+	files, err := builtinFiles.ReadDir(builtinDir)
+	if err != nil {
+		return nil, fmt.Errorf("dictionary: %w", err)
+	}
+	created := time.Now()
+	builtin := make(map[string]*entry, len(files))
+	for _, file := range files {
+		data, err := builtinFiles.ReadFile(builtinDir + "/" + file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("dictionary: %w", err)
+		}
+		name := strings.TrimSuffix(file.Name(), fileSuffix)
+		e := newEntry(name, data, created)
+		e.info.Builtin = true
+		builtin[name] = e
+	}
+	return builtin, nil
+}
+
+// SetQuota limits the bytes the dictionaries of each namespace may take up
+// together, 0 for no limit. Namespaces already past it keep what they have
+// but cannot add to it.
+func (s *Store) SetQuota(quota int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.quota = quota
+}
+
+func newEntry(name string, data []byte, created time.Time) *entry {
+	return &entry{
+		info: Info{Name: name, Size: len(data), ID: fmt.Sprintf("%08x", checksum.SumAdler32(data)), Created: created},
+		data: data,
+	}
+}
+
+// Put stores data under name in namespace, replacing the dictionary stored
+// under it before. Data compressed with the old one no longer decompresses
+// with the name then. The names of built-in dictionaries are taken.
+func (s *Store) Put(namespace, name string, data []byte) (Info, error) {
+	switch {
+	case !validNamespace.MatchString(namespace):
+		return Info{}, fmt.Errorf("%w: %q", ErrInvalidNamespace, namespace)
+	case !validName.MatchString(name):
+		return Info{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	case s.builtin[name] != nil:
+		return Info{}, fmt.Errorf("%w: %q", ErrBuiltin, name)
+	case len(data) == 0:
+		return Info{}, ErrEmpty
+	case len(data) > MaxSize:
+		return Info{}, ErrTooLarge
+	}
+	e := newEntry(name, slices.Clone(data), time.Now())
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.quota > 0 {
+		used := s.usage(namespace).Bytes
+		if old, ok := s.namespaces[namespace][name]; ok {
+			used -= int64(old.info.Size)
+		}
+		if used+int64(len(data)) > s.quota {
+			return Info{}, fmt.Errorf("%w: %d of %d bytes are used, the dictionary has %d", ErrQuotaExceeded, used, s.quota, len(data))
+		}
+	}
+	if s.dir != "" {
+		if err := s.write(namespace, name, e.data); err != nil {
+			return Info{}, err
+		}
+	}
+	if s.namespaces[namespace] == nil {
+		s.namespaces[namespace] = make(map[string]*entry)
+	}
+	s.namespaces[namespace][name] = e
+	return e.info, nil
+}
+
+// write replaces the file of name through a temporary file, so a failed
+// write leaves the old dictionary in place
+func (s *Store) write(namespace, name string, data []byte) error {
+	dir := s.path(namespace)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("dictionary: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return fmt.Errorf("dictionary: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, name+fileSuffix))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("dictionary: %w", err)
+	}
+	return nil
+}
+
+// Get returns the dictionary stored under name in namespace, or the
+// built-in one of that name. The slice is shared with every other caller and
+// must not be modified.
+func (s *Store) Get(namespace, name string) ([]byte, Info, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	e, ok := s.namespaces[namespace][name]
+	if !ok {
+		// one stored before the name was built in comes first
+		if e, ok = s.builtin[name]; !ok {
+			return nil, Info{}, ErrNotFound
+		}
+	}
+	return e.data, e.info, nil
+}
+
+// List returns every dictionary stored in namespace and the built-in ones
+// ordered by name
+func (s *Store) List(namespace string) []Info {
+	s.lock.RLock()
+	infos := make([]Info, 0, len(s.namespaces[namespace])+len(s.builtin))
+	for _, e := range s.namespaces[namespace] {
+		infos = append(infos, e.info)
+	}
+	for name, e := range s.builtin {
+		if s.namespaces[namespace][name] == nil {
+			infos = append(infos, e.info)
+		}
+	}
+	s.lock.RUnlock()
+	slices.SortFunc(infos, func(a, b Info) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// Usage returns how many dictionaries namespace holds and what they take up
+// of the quota, the built-in ones do not count
+func (s *Store) Usage(namespace string) Usage {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.usage(namespace)
+}
+
+// usage is Usage with the lock held
+func (s *Store) usage(namespace string) Usage {
+	usage := Usage{Dictionaries: len(s.namespaces[namespace]), Quota: s.quota}
+	for _, e := range s.namespaces[namespace] {
+		usage.Bytes += int64(e.info.Size)
+	}
+	return usage
+}
+
+// Delete removes the dictionary stored under name in namespace
+func (s *Store) Delete(namespace, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := s.namespaces[namespace]
+	if _, ok := entries[name]; !ok {
+		if s.builtin[name] != nil {
+			return fmt.Errorf("%w: %q", ErrBuiltin, name)
+		}
+		return ErrNotFound
+	}
+	if s.dir != "" {
+		if err := os.Remove(filepath.Join(s.path(namespace), name+fileSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("dictionary: %w", err)
+		}
+	}
+	delete(entries, name)
+	if len(entries) == 0 {
+		delete(s.namespaces, namespace)
+		if s.dir != "" && namespace != "" {
+			// left when a temporary file is still in it
+			os.Remove(s.path(namespace))
+		}
+	}
+	return nil
+}
+
+// path is the directory the dictionaries of namespace are kept in
+func (s *Store) path(namespace string) string {
+	return filepath.Join(s.dir, namespace)
+}
@@ -0,0 +1,177 @@
+package dictionary
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// uploaded leaves the built-in dictionaries out of a List
+func uploaded(infos []Info) []Info {
+	return slices.DeleteFunc(infos, func(info Info) bool { return info.Builtin })
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Put("", "json-v1", []byte(`{"status": "ok"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Adler-32 zlib puts into DICTID
+	if info.Size != 16 || info.ID != "2fdf0559" {
+		t.Errorf("Put = %+v", info)
+	}
+	if _, err := s.Put("", "b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if list := uploaded(s.List("")); len(list) != 2 || list[0].Name != "b" || list[1].Name != "json-v1" {
+		t.Errorf("List = %+v", list)
+	}
+
+	// what is in the directory is loaded again
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, err := reopened.Get("", "json-v1"); err != nil || !bytes.Equal(data, []byte(`{"status": "ok"}`)) {
+		t.Errorf("Get after reopening = %q, %v", data, err)
+	}
+
+	if err := s.Delete("", "json-v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get("", "json-v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "json-v1"+fileSuffix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the file of a deleted dictionary is left: %v", err)
+	}
+	if err := s.Delete("", "json-v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice: %v", err)
+	}
+}
+
+func TestStoreRejects(t *testing.T) {
+	s, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "../escape", "a/b", "with space", string(bytes.Repeat([]byte("n"), 65))} {
+		if _, err := s.Put("", name, []byte("data")); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put(%q): %v", name, err)
+		}
+	}
+	if _, err := s.Put("", "empty", nil); !errors.Is(err, ErrEmpty) {
+		t.Errorf("empty dictionary: %v", err)
+	}
+	if _, err := s.Put("", "large", make([]byte, MaxSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("large dictionary: %v", err)
+	}
+	if _, err := s.Put("", "exact", make([]byte, MaxSize)); err != nil {
+		t.Errorf("dictionary of MaxSize: %v", err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetQuota(10)
+	if _, err := s.Put("key-a", "shared", []byte("aaaaaa")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put("key-b", "shared", []byte("bbbbbbbb")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _, err := s.Get("key-a", "shared"); err != nil || string(data) != "aaaaaa" {
+		t.Errorf("Get in key-a = %q, %v", data, err)
+	}
+	if _, _, err := s.Get("", "shared"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get in another namespace: %v", err)
+	}
+
+	// the quota counts the namespace alone, and a replaced dictionary not twice
+	if _, err := s.Put("key-a", "more", []byte("aaaaa")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Put past the quota: %v", err)
+	}
+	if _, err := s.Put("key-a", "shared", []byte("aaaaaaaaaa")); err != nil {
+		t.Errorf("replacing within the quota: %v", err)
+	}
+	if usage := s.Usage("key-a"); usage.Dictionaries != 1 || usage.Bytes != 10 || usage.Quota != 10 {
+		t.Errorf("Usage = %+v", usage)
+	}
+	if _, err := s.Put("../escape", "name", []byte("x")); !errors.Is(err, ErrInvalidNamespace) {
+		t.Errorf("Put with a path for a namespace: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := uploaded(reopened.List("key-b")); len(list) != 1 || list[0].Name != "shared" || list[0].Size != 8 {
+		t.Errorf("List after reopening = %+v", list)
+	}
+	if err := reopened.Delete("key-b", "shared"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key-b")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the directory of an emptied namespace is left: %v", err)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"json", "html", "logs"} {
+		data, info, err := s.Get("key-a", name)
+		if err != nil || len(data) == 0 || len(data) > MaxSize || !info.Builtin {
+			t.Errorf("Get(%q) = %d bytes, %+v, %v", name, len(data), info, err)
+		}
+		if _, err := s.Put("key-a", name, []byte("data")); !errors.Is(err, ErrBuiltin) {
+			t.Errorf("Put(%q): %v", name, err)
+		}
+		if err := s.Delete("key-a", name); !errors.Is(err, ErrBuiltin) {
+			t.Errorf("Delete(%q): %v", name, err)
+		}
+	}
+	if list := s.List("key-a"); len(list) != 3 || list[0].Name != "html" || list[1].Name != "json" || list[2].Name != "logs" {
+		t.Errorf("List = %+v", list)
+	}
+	// they take up no quota and are not written to the directory
+	if usage := s.Usage("key-a"); usage.Dictionaries != 0 || usage.Bytes != 0 {
+		t.Errorf("Usage = %+v", usage)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("the directory holds %d files", len(files))
+	}
+
+	// one uploaded before the name was built in stays in front of it
+	if err := os.WriteFile(filepath.Join(dir, "json"+fileSuffix), []byte(`{"own": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, info, err := reopened.Get("", "json"); err != nil || string(data) != `{"own": true}` || info.Builtin {
+		t.Errorf("Get of a stored json = %q, %+v, %v", data, info, err)
+	}
+	if list := reopened.List(""); len(list) != 3 || list[1].Builtin {
+		t.Errorf("List = %+v", list)
+	}
+	if err := reopened.Delete("", "json"); err != nil {
+		t.Errorf("Delete of a stored json: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Sealed data is laid out as
+//
+//	magic    "CFDE"
+//	version  1 byte
+//	argon2   uint32 time, uint32 memory in KiB, 1 byte threads
+//	salt     16 bytes
+//	nonce    12 bytes
+//	payload  AES-256-GCM ciphertext and its 16 byte tag
+//
+// Everything before the payload is passed to GCM as additional data, so a
+// changed header fails authentication just like a changed payload, and
+// nothing is decompressed before the whole input has been authenticated.
+
+const (
+	magic        = "CFDE"
+	version      = 1
+	saltLength   = 16
+	keyLength    = 32 // AES-256
+	headerLength = len(magic) + 1 + 4 + 4 + 1 + saltLength + 12
+)
+
+// Params are the Argon2id cost parameters used to derive the key
+type Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// DefaultParams follow the second recommendation of RFC 9106 for memory
+// constrained environments
+var DefaultParams = Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// maxParams bounds what a header may ask for, so a crafted file cannot make
+// the server spend much more memory on deriving a key than files it sealed
+// itself need: every request decrypting at once holds this much
+var maxParams = Params{Time: 16, Memory: 2 * DefaultParams.Memory, Threads: 64}
+
+// Errors returned by Seal and Open
+var (
+	ErrPasswordRequired   = errors.New("data is encrypted, a password is required")
+	ErrEmptyPassword      = errors.New("password is empty")
+	ErrDecryptionFailed   = errors.New("decryption failed: wrong password or the data was modified")
+	ErrUnsupportedVersion = errors.New("unsupported encryption version")
+	ErrInvalidHeader      = errors.New("invalid encryption header")
+)
+
+// IsEncrypted reports whether data starts like the output of Seal
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Seal encrypts data with a key derived from password using DefaultParams
+func Seal(password string, data []byte) ([]byte, error) {
+	return SealWithParams(password, data, DefaultParams)
+}
+
+// SealWithParams is Seal with explicit Argon2id parameters
+func SealWithParams(password string, data []byte, params Params) ([]byte, error) {
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+	if err := checkParams(params); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, headerLength)
+	header = append(header, magic...)
+	header = append(header, version)
+	header = binary.LittleEndian.AppendUint32(header, params.Time)
+	header = binary.LittleEndian.AppendUint32(header, params.Memory)
+	header = append(header, params.Threads)
+	saltAndNonce := make([]byte, saltLength+12)
+	if _, err := rand.Read(saltAndNonce); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	header = append(header, saltAndNonce...)
+
+	aead, err := newAEAD(password, header[headerLength-12-saltLength:headerLength-12], params)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[headerLength-12:], data, header), nil
+}
+
+// Open authenticates and decrypts the output of Seal. A wrong password and
+// modified data both fail with ErrDecryptionFailed, GCM cannot tell them apart.
+func Open(password string, sealed []byte) ([]byte, error) {
+	if !IsEncrypted(sealed) {
+		return nil, fmt.Errorf("%w: not encrypted data", ErrInvalidHeader)
+	}
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if len(sealed) < headerLength+16 {
+		return nil, fmt.Errorf("%w: data is too short", ErrInvalidHeader)
+	}
+	if sealed[len(magic)] != version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, sealed[len(magic)])
+	}
+	header := sealed[:headerLength]
+	params := Params{
+		Time:    binary.LittleEndian.Uint32(header[5:]),
+		Memory:  binary.LittleEndian.Uint32(header[9:]),
+		Threads: header[13],
+	}
+	if err := checkParams(params); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(password, header[14:14+saltLength], params)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, header[14+saltLength:], sealed[headerLength:], header)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return data, nil
+}
+
+func checkParams(params Params) error {
+	if params.Time == 0 || params.Time > maxParams.Time ||
+		params.Memory < 8*uint32(params.Threads) || params.Memory > maxParams.Memory ||
+		params.Threads == 0 || params.Threads > maxParams.Threads {
+		return fmt.Errorf("%w: argon2 parameters t=%d m=%d p=%d are out of range", ErrInvalidHeader, params.Time, params.Memory, params.Threads)
+	}
+	return nil
+}
+
+func newAEAD(password string, salt []byte, params Params) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, keyLength)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
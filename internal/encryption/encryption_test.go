@@ -0,0 +1,52 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// cheapParams keep the key derivation fast, the format does not depend on them
+var cheapParams = Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestSealOpen(t *testing.T) {
+	data := []byte("compressed payload")
+	sealed, err := SealWithParams("secret", data, cheapParams)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsEncrypted(sealed) || bytes.Contains(sealed, data) {
+		t.Fatalf("sealed data is not encrypted")
+	}
+	opened, err := Open("secret", sealed)
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+
+	if _, err := Open("wrong", sealed); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Open with a wrong password = %v, want ErrDecryptionFailed", err)
+	}
+	if _, err := Open("", sealed); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("Open without a password = %v, want ErrPasswordRequired", err)
+	}
+	// the salt is part of the header, the last byte is part of the tag
+	for _, i := range []int{len(magic) + 10, len(sealed) - 1} {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := Open("secret", tampered); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("Open with byte %d changed = %v, want ErrDecryptionFailed", i, err)
+		}
+	}
+	// a header asking for absurd key derivation costs is refused up front
+	tampered := bytes.Clone(sealed)
+	tampered[len(magic)+1+4+3] = 0xff
+	if _, err := Open("secret", tampered); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Open with a huge memory parameter = %v, want ErrInvalidHeader", err)
+	}
+	if _, err := SealWithParams("secret", data, Params{Time: 1, Memory: 4 * DefaultParams.Memory, Threads: 1}); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Seal with 4 times the default memory = %v, want ErrInvalidHeader", err)
+	}
+	if _, err := Seal("", data); !errors.Is(err, ErrEmptyPassword) {
+		t.Errorf("Seal without a password = %v, want ErrEmptyPassword", err)
+	}
+}
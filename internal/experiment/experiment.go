@@ -0,0 +1,165 @@
+// Package experiment compresses a share of the inputs the service handles a
+// second time with a candidate configuration, in the background, and
+// compares the results with what the request was compressed with. It is
+// meant for trying out changes to the matcher or to how blocks are split on
+// real traffic before any response depends on them.
+package experiment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+)
+
+// ErrInvalidCandidate is wrapped by the errors of ParseCandidate
+var ErrInvalidCandidate = errors.New("invalid experiment candidate")
+
+// Candidate is the configuration inputs are compressed with again
+type Candidate struct {
+	Spec    string // as it was parsed, to name the candidate in logs and stats
+	Options compression.Options
+}
+
+// ParseCandidate reads a candidate written as an algorithm followed by
+// options, separated by commas, e.g. "flate,btype=1,tiny=-1". The options
+// are those of compression.ApplySetting.
+func ParseCandidate(spec string) (Candidate, error) {
+	fields := strings.Split(spec, ",")
+	candidate := Candidate{Spec: spec, Options: compression.Options{Algorithm: strings.TrimSpace(fields[0])}}
+	if !compression.IsValidAlgorithm(candidate.Options.Algorithm) {
+		return Candidate{}, fmt.Errorf("%w: %q is not one of %v", ErrInvalidCandidate, candidate.Options.Algorithm, compression.GetSupportedAlgorithms())
+	}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return Candidate{}, fmt.Errorf("%w: option %q is not written as key=value", ErrInvalidCandidate, field)
+		}
+		if err := compression.ApplySetting(&candidate.Options, key, value); err != nil {
+			return Candidate{}, fmt.Errorf("%w: %v", ErrInvalidCandidate, err)
+		}
+	}
+	return candidate, nil
+}
+
+// Stats tells how the candidate did against the configurations the sampled
+// inputs were compressed with
+type Stats struct {
+	Candidate string  `json:"candidate"`
+	Percent   float64 `json:"percent"`
+	Sampled   int64   `json:"sampled"`
+	Skipped   int64   `json:"skipped"` // sampled while the experiment was busy
+	Failed    int64   `json:"failed"`
+	Compared  int64   `json:"compared"`
+	Smaller   int64   `json:"smaller"` // the candidate output was smaller
+	Larger    int64   `json:"larger"`
+
+	// Totals over the compared inputs
+	InputBytes        int64         `json:"input_bytes"`
+	BaselineBytes     int64         `json:"baseline_bytes"`
+	CandidateBytes    int64         `json:"candidate_bytes"`
+	BaselineDuration  time.Duration `json:"baseline_duration"`
+	CandidateDuration time.Duration `json:"candidate_duration"`
+}
+
+// Experiment runs a candidate over a share of the inputs
+type Experiment struct {
+	candidate Candidate
+	percent   float64
+	slots     chan struct{} // one per compression that may run at once
+	running   sync.WaitGroup
+
+	lock  sync.Mutex
+	stats Stats
+}
+
+// New returns an experiment sampling percent of the inputs, from 0 to 100,
+// of which up to concurrency are compressed with the candidate at once
+func New(candidate Candidate, percent float64, concurrency int) *Experiment {
+	return &Experiment{
+		candidate: candidate,
+		percent:   percent,
+		slots:     make(chan struct{}, max(concurrency, 1)),
+		stats:     Stats{Candidate: candidate.Spec, Percent: percent},
+	}
+}
+
+// Sample reports whether the next input takes part, so the caller knows to
+// keep a copy of it. A nil experiment samples nothing.
+func (e *Experiment) Sample() bool {
+	return e != nil && rand.Float64()*100 < e.percent
+}
+
+// Run compresses a sampled input with the candidate in the background and
+// compares the result with baseline, the stats of the compression the
+// request made. While as many compressions as the experiment allows are
+// running the input is skipped, the experiment never holds up requests.
+func (e *Experiment) Run(input []byte, baseline *compression.Stats) {
+	e.lock.Lock()
+	e.stats.Sampled++
+	e.lock.Unlock()
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		e.lock.Lock()
+		e.stats.Skipped++
+		e.lock.Unlock()
+		return
+	}
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		defer func() { <-e.slots }()
+		e.compare(input, *baseline)
+	}()
+}
+
+// compare compresses input with the candidate, it runs with a slot taken
+func (e *Experiment) compare(input []byte, baseline compression.Stats) {
+	options := e.candidate.Options
+	options.StatsOnly = true
+	_, stats, err := compression.CompressContext(context.Background(), input, options)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err != nil {
+		e.stats.Failed++
+		log.Printf("Experiment %s failed on %d bytes: %v", e.candidate.Spec, len(input), err)
+		return
+	}
+	e.stats.Compared++
+	switch {
+	case stats.ProcessedSize < baseline.ProcessedSize:
+		e.stats.Smaller++
+	case stats.ProcessedSize > baseline.ProcessedSize:
+		e.stats.Larger++
+	}
+	e.stats.InputBytes += baseline.OriginalSize
+	e.stats.BaselineBytes += baseline.ProcessedSize
+	e.stats.CandidateBytes += stats.ProcessedSize
+	e.stats.BaselineDuration += baseline.Duration
+	e.stats.CandidateDuration += stats.Duration
+	log.Printf("Experiment %s: %d bytes to %d with %s in %s, to %d with the candidate in %s",
+		e.candidate.Spec, baseline.OriginalSize, baseline.ProcessedSize, baseline.Algorithm, baseline.Duration,
+		stats.ProcessedSize, stats.Duration)
+	telemetry.RecordExperiment(context.Background(), baseline.Algorithm, e.candidate.Spec,
+		stats.CompressionRatio-baseline.CompressionRatio, stats.Duration-baseline.Duration)
+}
+
+// Wait waits for the compressions that are running to finish
+func (e *Experiment) Wait() {
+	e.running.Wait()
+}
+
+// Stats returns how the candidate did so far
+func (e *Experiment) Stats() Stats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.stats
+}
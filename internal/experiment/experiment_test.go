@@ -0,0 +1,51 @@
+package experiment
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+func TestParseCandidate(t *testing.T) {
+	candidate, err := ParseCandidate("gzip,btype=1,tiny=-1,filter=delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := candidate.Options; options.Algorithm != "gzip" || options.BType != 1 || options.TinyInputSize != -1 || options.Filter != compression.FilterLineDelta {
+		t.Errorf("options = %+v", options)
+	}
+	for _, spec := range []string{"", "brotli", "flate,btype", "flate,level=9", "flate,tiny=x", "flate,filter=rle"} {
+		if _, err := ParseCandidate(spec); !errors.Is(err, ErrInvalidCandidate) {
+			t.Errorf("ParseCandidate(%q): %v", spec, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	input := bytes.Repeat([]byte("the candidate compresses this again. "), 200)
+	_, baseline, err := compression.Compress(input, compression.Options{Algorithm: "flate", Store: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate, _ := ParseCandidate("flate")
+	e := New(candidate, 100, 1)
+	if !e.Sample() {
+		t.Fatal("an experiment at 100 percent did not sample")
+	}
+	e.Run(input, baseline)
+	e.Wait()
+	stats := e.Stats()
+	if stats.Sampled != 1 || stats.Compared != 1 || stats.Smaller != 1 || stats.InputBytes != int64(len(input)) {
+		t.Errorf("Stats = %+v", stats)
+	}
+	if stats.CandidateBytes >= stats.BaselineBytes {
+		t.Errorf("matching gave %d bytes, storing %d", stats.CandidateBytes, stats.BaselineBytes)
+	}
+
+	var none *Experiment
+	if none.Sample() || New(candidate, 0, 1).Sample() {
+		t.Error("an experiment that is off sampled")
+	}
+}
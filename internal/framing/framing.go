@@ -0,0 +1,178 @@
+// Package framing reads and writes the frames the service's own formats are
+// made of. A frame is
+//
+//	uvarint  length of the payload
+//	byte     type, with the high bit set when a checksum follows
+//	bytes    payload
+//	4 bytes  IEEE CRC-32 of the payload, little endian, when flagged
+//
+// The huffman and lzss formats are a magic and a version followed by
+// frames, and sessions keep their chunks in frames on disk.
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+)
+
+// crcFlag marks a type byte whose frame ends with a checksum
+const crcFlag = 0x80
+
+// MaxType is the largest frame type, the high bit of the type byte is the
+// checksum flag
+const MaxType = crcFlag - 1
+
+var (
+	// ErrChecksum is returned for a frame whose payload does not match its
+	// checksum
+	ErrChecksum = errors.New("framing: checksum mismatch")
+
+	// ErrTooLarge is returned by Reader.Next for a frame that claims a
+	// payload longer than the reader takes
+	ErrTooLarge = errors.New("framing: frame too large")
+
+	errHeaderTruncated = fmt.Errorf("framing: frame header is truncated: %w", io.ErrUnexpectedEOF)
+)
+
+// Frame is a frame with its checksum, if it had one, verified
+type Frame struct {
+	Type    byte
+	Payload []byte
+	CRC     bool // whether the frame carried a checksum
+}
+
+// Append appends a frame to dst, with a checksum of the payload when crc is
+// set. typ has to be at most MaxType.
+func Append(dst []byte, typ byte, payload []byte, crc bool) []byte {
+	if typ > MaxType {
+		panic(fmt.Sprintf("framing: invalid frame type %d", typ))
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(payload)))
+	if crc {
+		typ |= crcFlag
+	}
+	dst = append(append(dst, typ), payload...)
+	if crc {
+		dst = binary.LittleEndian.AppendUint32(dst, checksum.SumCRC32(payload))
+	}
+	return dst
+}
+
+// Parse reads the frame data starts with and returns it along with the
+// number of bytes it took. The payload is a slice of data. When data ends
+// inside the payload or its checksum the frame holds what there is of the
+// payload, unverified, and the error wraps io.ErrUnexpectedEOF, so formats
+// can decode as far as their data goes.
+func Parse(data []byte) (Frame, int, error) {
+	length, n := binary.Uvarint(data)
+	if n == 0 || n == len(data) {
+		return Frame{}, 0, errHeaderTruncated
+	}
+	if n < 0 {
+		return Frame{}, 0, errors.New("framing: invalid payload length")
+	}
+	frame := Frame{Type: data[n] &^ crcFlag, CRC: data[n]&crcFlag != 0}
+	data = data[n+1:]
+	size := n + 1
+	if length > uint64(len(data)) {
+		frame.Payload = data
+		return frame, size + len(data), fmt.Errorf("framing: payload ended after %d of %d bytes: %w", len(data), length, io.ErrUnexpectedEOF)
+	}
+	frame.Payload = data[:length]
+	size += int(length)
+	if !frame.CRC {
+		return frame, size, nil
+	}
+	data = data[length:]
+	if len(data) < 4 {
+		return frame, size + len(data), fmt.Errorf("framing: checksum is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	if binary.LittleEndian.Uint32(data) != checksum.SumCRC32(frame.Payload) {
+		return frame, size + 4, ErrChecksum
+	}
+	return frame, size + 4, nil
+}
+
+// Writer writes frames to an io.Writer
+type Writer struct {
+	w   io.Writer
+	crc bool
+	buf []byte
+}
+
+// NewWriter returns a Writer adding a checksum to every frame when crc is
+// set
+func NewWriter(w io.Writer, crc bool) *Writer {
+	return &Writer{w: w, crc: crc}
+}
+
+// WriteFrame writes a frame of the type typ, at most MaxType
+func (fw *Writer) WriteFrame(typ byte, payload []byte) error {
+	if typ > MaxType {
+		return fmt.Errorf("framing: invalid frame type %d", typ)
+	}
+	// the header and checksum go out with the payload in one write
+	fw.buf = Append(fw.buf[:0], typ, payload, fw.crc)
+	_, err := fw.w.Write(fw.buf)
+	return err
+}
+
+// Reader reads frames from an io.Reader
+type Reader struct {
+	r       *bufio.Reader
+	maxSize int
+	buf     []byte
+}
+
+// NewReader returns a Reader of frames with payloads of up to maxSize bytes
+func NewReader(r io.Reader, maxSize int) *Reader {
+	return &Reader{r: bufio.NewReader(r), maxSize: maxSize}
+}
+
+// Next returns the next frame, its payload is valid until the next call. It
+// returns io.EOF when the input ends between frames and an error wrapping
+// io.ErrUnexpectedEOF when it ends inside one.
+func (fr *Reader) Next() (Frame, error) {
+	length, err := binary.ReadUvarint(fr.r)
+	if err == io.EOF {
+		return Frame{}, io.EOF
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return Frame{}, errHeaderTruncated
+	}
+	if err != nil {
+		return Frame{}, fmt.Errorf("framing: invalid payload length: %w", err)
+	}
+	if length > uint64(fr.maxSize) {
+		return Frame{}, fmt.Errorf("%w: %d bytes", ErrTooLarge, length)
+	}
+	typ, err := fr.r.ReadByte()
+	if err != nil {
+		return Frame{}, errHeaderTruncated
+	}
+	frame := Frame{Type: typ &^ crcFlag, CRC: typ&crcFlag != 0}
+	size := int(length)
+	if frame.CRC {
+		size += 4
+	}
+	if cap(fr.buf) < size {
+		fr.buf = make([]byte, size)
+	}
+	fr.buf = fr.buf[:size]
+	if n, err := io.ReadFull(fr.r, fr.buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Frame{}, fmt.Errorf("framing: frame ended after %d of %d bytes: %w", n, size, io.ErrUnexpectedEOF)
+		}
+		return Frame{}, err
+	}
+	frame.Payload = fr.buf[:length]
+	if frame.CRC && binary.LittleEndian.Uint32(fr.buf[length:]) != checksum.SumCRC32(frame.Payload) {
+		return Frame{}, ErrChecksum
+	}
+	return frame, nil
+}
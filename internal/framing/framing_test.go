@@ -0,0 +1,80 @@
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	frames := []Frame{
+		{Type: 1, Payload: []byte("with a checksum"), CRC: true},
+		{Type: 2, Payload: []byte{}},
+		{Type: MaxType, Payload: bytes.Repeat([]byte("x"), 300), CRC: true},
+	}
+	var data []byte
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		data = Append(data, frame.Type, frame.Payload, frame.CRC)
+		if err := NewWriter(&buf, frame.CRC).WriteFrame(frame.Type, frame.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("Writer and Append disagree")
+	}
+
+	rest := data
+	fr := NewReader(bytes.NewReader(data), 300)
+	for i, want := range frames {
+		got, n, err := Parse(rest)
+		if err != nil || got.Type != want.Type || got.CRC != want.CRC || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("Parse of frame %d = %+v, %v", i, got, err)
+		}
+		rest = rest[n:]
+		got, err = fr.Next()
+		if err != nil || got.Type != want.Type || got.CRC != want.CRC || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("Next of frame %d = %+v, %v", i, got, err)
+		}
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left after the last frame", len(rest))
+	}
+	if _, err := fr.Next(); err != io.EOF {
+		t.Errorf("Next after the last frame = %v, want io.EOF", err)
+	}
+	if _, err := NewReader(bytes.NewReader(data), 299).Next(); err != nil {
+		t.Errorf("a frame within the limit failed: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(Append(nil, 1, make([]byte, 300), false)), 299).Next(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("a frame over the limit returned %v", err)
+	}
+}
+
+func TestFrameDamage(t *testing.T) {
+	frame := Append(nil, 3, []byte("payload"), true)
+
+	// cut anywhere, what there is of the payload comes back
+	for cut := range len(frame) {
+		got, _, err := Parse(frame[:cut])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Parse cut at %d returned %v", cut, err)
+		}
+		if want := "payload"[:max(0, min(cut-2, 7))]; string(got.Payload) != want {
+			t.Errorf("Parse cut at %d returned the payload %q, want %q", cut, got.Payload, want)
+		}
+		if _, err := NewReader(bytes.NewReader(frame[:cut]), 100).Next(); cut > 0 && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Next cut at %d returned %v", cut, err)
+		}
+	}
+
+	corrupt := bytes.Clone(frame)
+	corrupt[4] ^= 0x20
+	if _, _, err := Parse(corrupt); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Parse of a corrupt payload returned %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(corrupt), 100).Next(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Next of a corrupt payload returned %v", err)
+	}
+}
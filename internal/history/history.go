@@ -0,0 +1,182 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// jobsBucket holds one JSON encoded Job per key. Keys are the big-endian
+// UnixNano of the job followed by a big-endian sequence number, so they sort
+// by time and a time range is a single cursor scan.
+var jobsBucket = []byte("jobs")
+
+// ErrClosed is returned by Add and Query once the store is closed
+var ErrClosed = errors.New("history: store is closed")
+
+// Job is the record of one compression or decompression
+type Job struct {
+	Time             time.Time     `json:"time"`
+	Operation        string        `json:"operation"` // "compress" or "decompress"
+	Algorithm        string        `json:"algorithm"`
+	OriginalSize     int64         `json:"original_size"`  // size of the input
+	ProcessedSize    int64         `json:"processed_size"` // size of the output
+	CompressionRatio float64       `json:"compression_ratio"`
+	Duration         time.Duration `json:"duration"`
+
+	// Schema is the compression.StatsSchemaVersion the job was recorded
+	// under, 0 for jobs from before there was one
+	Schema int `json:"schema,omitempty"`
+}
+
+// saved is how much smaller the compressed side of the job is than the
+// content, negative when compression made the data larger
+func (j Job) saved() int64 {
+	if j.Operation == "decompress" {
+		return j.ProcessedSize - j.OriginalSize
+	}
+	return j.OriginalSize - j.ProcessedSize
+}
+
+// Filter selects the jobs Query aggregates. A zero From or To leaves that
+// end of the range open, an empty Algorithm matches all of them.
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Algorithm string
+}
+
+// Aggregate summarises the jobs matching a Filter
+type Aggregate struct {
+	Jobs         int
+	InputBytes   int64
+	OutputBytes  int64
+	BytesSaved   int64
+	AverageRatio float64 // mean of the jobs' ratios, in percent
+	AverageTime  time.Duration
+	P95Time      time.Duration
+	MaxTime      time.Duration
+	ByAlgorithm  map[string]int // number of jobs
+}
+
+// Store keeps the jobs in a bolt database file
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store in the file at path, creating it if needed. Only one
+// process can have a store open at a time.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add records a job. Concurrent calls are written in one transaction, so
+// they share the cost of syncing the file.
+func (s *Store) Add(job Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	err = s.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(nil, uint64(job.Time.UnixNano()))
+		key = binary.BigEndian.AppendUint64(key, seq)
+		return bucket.Put(key, value)
+	})
+	if errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		return ErrClosed
+	}
+	return err
+}
+
+// Query aggregates the jobs matching filter
+func (s *Store) Query(filter Filter) (*Aggregate, error) {
+	aggregate := &Aggregate{ByAlgorithm: make(map[string]int)}
+	var durations []time.Duration
+	ratioSum := 0.0
+	ratios := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(jobsBucket).Cursor()
+		k, v := cursor.First()
+		if !filter.From.IsZero() {
+			k, v = cursor.Seek(binary.BigEndian.AppendUint64(nil, uint64(filter.From.UnixNano())))
+		}
+		end := uint64(math.MaxUint64)
+		if !filter.To.IsZero() {
+			end = uint64(filter.To.UnixNano())
+		}
+		for ; k != nil && binary.BigEndian.Uint64(k) <= end; k, v = cursor.Next() {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if filter.Algorithm != "" && job.Algorithm != filter.Algorithm {
+				continue
+			}
+			aggregate.Jobs++
+			aggregate.InputBytes += job.OriginalSize
+			aggregate.OutputBytes += job.ProcessedSize
+			aggregate.BytesSaved += job.saved()
+			aggregate.ByAlgorithm[job.Algorithm]++
+			if job.CompressionRatio > 0 && !math.IsInf(job.CompressionRatio, 0) {
+				ratioSum += job.CompressionRatio
+				ratios++
+			}
+			durations = append(durations, job.Duration)
+		}
+		return nil
+	})
+	if errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		return nil, ErrClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ratios > 0 {
+		aggregate.AverageRatio = ratioSum / float64(ratios)
+	}
+	if len(durations) > 0 {
+		slices.Sort(durations)
+		total := time.Duration(0)
+		for _, d := range durations {
+			total += d
+		}
+		aggregate.AverageTime = total / time.Duration(len(durations))
+		aggregate.P95Time = percentile(durations, 95)
+		aggregate.MaxTime = durations[len(durations)-1]
+	}
+	return aggregate, nil
+}
+
+// percentile returns the nearest-rank pth percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,70 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		job := Job{
+			Time:             start.Add(time.Duration(i) * time.Minute),
+			Operation:        "compress",
+			Algorithm:        "gzip",
+			OriginalSize:     1000,
+			ProcessedSize:    400,
+			CompressionRatio: 40,
+			Duration:         time.Duration(i+1) * time.Millisecond,
+		}
+		if i%2 == 1 {
+			job.Algorithm = "huffman"
+			job.ProcessedSize, job.CompressionRatio = 600, 60
+		}
+		if err := store.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a decompression saves the difference the other way round
+	if err := store.Add(Job{Time: start.Add(time.Hour), Operation: "decompress", Algorithm: "gzip", OriginalSize: 400, ProcessedSize: 1000, CompressionRatio: 40}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := store.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Jobs != 101 || all.BytesSaved != 50*600+50*400+600 || all.ByAlgorithm["gzip"] != 51 {
+		t.Errorf("all jobs: %+v", all)
+	}
+	if all.P95Time != 95*time.Millisecond || all.MaxTime != 100*time.Millisecond {
+		t.Errorf("p95 = %v, max = %v, want 95ms and 100ms", all.P95Time, all.MaxTime)
+	}
+
+	// the first 10 minutes, both ends included
+	first, err := store.Query(Filter{From: start, To: start.Add(9 * time.Minute), Algorithm: "huffman"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Jobs != 5 || first.AverageRatio != 60 || first.InputBytes != 5000 {
+		t.Errorf("huffman jobs of the first 10 minutes: %+v", first)
+	}
+
+	none, err := store.Query(Filter{From: start.Add(2 * time.Hour)})
+	if err != nil || none.Jobs != 0 || none.P95Time != 0 {
+		t.Errorf("Query after the last job = %+v, %v", none, err)
+	}
+
+	store.Close()
+	if err := store.Add(Job{Time: start}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Add after Close = %v, want ErrClosed", err)
+	}
+}
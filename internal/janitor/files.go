@@ -0,0 +1,51 @@
+package janitor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Files is a Source of the files in Dir whose names match Pattern, as
+// filepath.Match has it, such as the spill files a process that did not shut
+// down cleanly left behind. Files that Held claims, with their paths, are in
+// use and left out.
+type Files struct {
+	Dir     string
+	Pattern string
+	Held    func(path string) bool
+}
+
+// Objects lists the files, named by their paths
+func (f Files) Objects() ([]Object, error) {
+	entries, err := os.ReadDir(f.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, entry := range entries {
+		if matched, _ := filepath.Match(f.Pattern, entry.Name()); !matched {
+			continue
+		}
+		path := filepath.Join(f.Dir, entry.Name())
+		if f.Held != nil && f.Held(path) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			// removed since it was listed, or no file of ours
+			continue
+		}
+		objects = append(objects, Object{Name: path, Size: info.Size(), LastUsed: info.ModTime()})
+	}
+	return objects, nil
+}
+
+// Remove deletes the file at path
+func (f Files) Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
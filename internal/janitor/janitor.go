@@ -0,0 +1,184 @@
+// Package janitor keeps the temporary objects of a long running server, such
+// as spill files, cached results and kept job outputs, from slowly filling
+// its disk. Every kind of object is a Source registered with a Policy: a
+// sweep removes the objects that went unused for the TTL, then the least
+// recently used ones until those left fit the quota.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
+)
+
+// Object is a temporary object of a Source
+type Object struct {
+	Name     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// Source lists the objects of a kind and removes them. Objects that are in
+// use are left out of Objects; removing an object that went in the meantime,
+// or came into use, is no error.
+type Source interface {
+	Objects() ([]Object, error)
+	Remove(name string) error
+}
+
+// Policy is how long the objects of a source are kept and how many bytes
+// they may take up together
+type Policy struct {
+	TTL      time.Duration // objects unused for this long are removed, 0 for no limit
+	MaxBytes int64         // the least recently used objects are removed beyond it, 0 for no quota
+}
+
+// Stats tells what the janitor found of a source on its last sweep and what
+// it removed of it since it was registered
+type Stats struct {
+	Source       string    `json:"source"`
+	Objects      int       `json:"objects"`
+	Bytes        int64     `json:"bytes"`
+	MaxBytes     int64     `json:"max_bytes,omitempty"`
+	TTLSeconds   float64   `json:"ttl_seconds,omitempty"`
+	Expired      int64     `json:"expired"` // objects removed for the TTL
+	Evicted      int64     `json:"evicted"` // objects removed for the quota
+	RemovedBytes int64     `json:"removed_bytes"`
+	Errors       int64     `json:"errors"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastSweep    time.Time `json:"last_sweep"`
+}
+
+// registered is a source with its policy and what was done to it
+type registered struct {
+	source Source
+	policy Policy
+	stats  Stats
+}
+
+// Janitor sweeps the sources registered with it every interval
+type Janitor struct {
+	interval time.Duration
+
+	lock    sync.Mutex // held for a whole sweep, sweeps do not overlap
+	sources []*registered
+	closed  chan struct{}
+	close   sync.Once
+}
+
+// New returns a janitor sweeping every interval until it is closed
+func New(interval time.Duration) *Janitor {
+	j := &Janitor{interval: interval, closed: make(chan struct{})}
+	go j.run()
+	return j
+}
+
+// Register has the objects of source kept as policy says, name tells the
+// source apart in Stats and in the metrics
+func (j *Janitor) Register(name string, source Source, policy Policy) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.sources = append(j.sources, &registered{
+		source: source,
+		policy: policy,
+		stats:  Stats{Source: name, MaxBytes: policy.MaxBytes, TTLSeconds: policy.TTL.Seconds()},
+	})
+}
+
+// Sweep goes over every source once, it returns what failed
+func (j *Janitor) Sweep(ctx context.Context) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var errs []error
+	for _, r := range j.sources {
+		if err := r.sweep(ctx, time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("janitor: %s: %w", r.stats.Source, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the stats of every source in the order they were registered
+func (j *Janitor) Stats() []Stats {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	stats := make([]Stats, len(j.sources))
+	for i, r := range j.sources {
+		stats[i] = r.stats
+	}
+	return stats
+}
+
+// Close stops sweeping, the objects left stay where they are
+func (j *Janitor) Close() {
+	j.close.Do(func() { close(j.closed) })
+}
+
+func (j *Janitor) run() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.closed:
+			return
+		case <-ticker.C:
+			// failures are counted in the stats, the next sweep tries again
+			j.Sweep(context.Background())
+		}
+	}
+}
+
+// sweep removes the objects of the source that expired, then the least
+// recently used ones while the rest is over the quota
+func (r *registered) sweep(ctx context.Context, now time.Time) error {
+	objects, err := r.source.Objects()
+	if err != nil {
+		r.failed(err)
+		return err
+	}
+	r.stats.LastSweep = now
+	slices.SortFunc(objects, func(a, b Object) int { return a.LastUsed.Compare(b.LastUsed) })
+	var total int64
+	for _, object := range objects {
+		total += object.Size
+	}
+
+	var errs []error
+	kept := objects[:0]
+	for _, object := range objects {
+		expired := r.policy.TTL > 0 && now.Sub(object.LastUsed) > r.policy.TTL
+		over := r.policy.MaxBytes > 0 && total > r.policy.MaxBytes
+		if !expired && !over {
+			kept = append(kept, object)
+			continue
+		}
+		if err := r.source.Remove(object.Name); err != nil {
+			r.failed(err)
+			errs = append(errs, err)
+			kept = append(kept, object)
+			continue
+		}
+		total -= object.Size
+		reason := "quota"
+		if expired {
+			reason = "ttl"
+			r.stats.Expired++
+		} else {
+			r.stats.Evicted++
+		}
+		r.stats.RemovedBytes += object.Size
+		telemetry.RecordCleanup(ctx, r.stats.Source, reason, object.Size)
+	}
+	r.stats.Objects, r.stats.Bytes = len(kept), total
+	return errors.Join(errs...)
+}
+
+func (r *registered) failed(err error) {
+	r.stats.Errors++
+	r.stats.LastError = err.Error()
+}
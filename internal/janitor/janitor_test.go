@@ -0,0 +1,67 @@
+package janitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	// name, size and age; held is in use and never removed
+	for _, file := range []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"spill-expired", 10, 3 * time.Hour},
+		{"spill-old", 40, 50 * time.Minute},
+		{"spill-new", 40, time.Minute},
+		{"spill-held", 100, 5 * time.Hour},
+		{"other", 10, 5 * time.Hour},
+	} {
+		path := filepath.Join(dir, file.name)
+		if err := os.WriteFile(path, make([]byte, file.size), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-file.age), now.Add(-file.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := New(time.Hour)
+	defer j.Close()
+	held := func(path string) bool { return filepath.Base(path) == "spill-held" }
+	j.Register("spill", Files{Dir: dir, Pattern: "spill-*", Held: held}, Policy{TTL: 2 * time.Hour, MaxBytes: 50})
+	if err := j.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expired file goes for the TTL, the older of the other two for the quota
+	var left []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if want := []string{"other", "spill-held", "spill-new"}; !slices.Equal(left, want) {
+		t.Errorf("left %v, want %v", left, want)
+	}
+	stats := j.Stats()
+	if len(stats) != 1 || stats[0].Expired != 1 || stats[0].Evicted != 1 || stats[0].RemovedBytes != 50 ||
+		stats[0].Objects != 1 || stats[0].Bytes != 40 || stats[0].Errors != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// a source that cannot be listed is counted as failing and tried again
+	j.Register("missing", Files{Dir: filepath.Join(dir, "missing"), Pattern: "*"}, Policy{})
+	if err := j.Sweep(context.Background()); err == nil {
+		t.Error("sweeping a missing directory did not fail")
+	}
+	if stats := j.Stats(); stats[1].Errors != 1 || stats[1].LastError == "" || stats[0].Expired != 1 {
+		t.Errorf("stats after a failed sweep %+v", stats)
+	}
+}
@@ -0,0 +1,58 @@
+// Package mmap gives read-only access to local files as byte slices. Where the
+// platform supports it the file is memory-mapped, so multi-GB inputs can be
+// scanned without being copied into Go buffers first; elsewhere the file is
+// read into memory.
+package mmap
+
+import (
+	"errors"
+	"io"
+)
+
+// File is a read-only view of a file's content
+type File struct {
+	data   []byte
+	mapped bool
+}
+
+// Bytes returns the content of the file. The slice must not be modified and
+// must not be used after Close.
+func (f *File) Bytes() []byte {
+	return f.data
+}
+
+// Len returns the size of the file in bytes
+func (f *File) Len() int {
+	return len(f.data)
+}
+
+// Mapped reports whether the content is backed by a memory mapping
+func (f *File) Mapped() bool {
+	return f.mapped
+}
+
+// ReadAt implements io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("mmap: negative offset")
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close releases the mapping. It is safe to call more than once.
+func (f *File) Close() error {
+	data := f.data
+	f.data = nil
+	if !f.mapped || data == nil {
+		return nil
+	}
+	f.mapped = false
+	return unmap(data)
+}
@@ -0,0 +1,143 @@
+// Package naming names output files after templates such as
+// "{name}.{date}.{algext}", whose fields are filled in from the input file,
+// the algorithm and the time of the request.
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Templates the API and the command line name their output by when none is given
+const (
+	DefaultCompress   = "{name}_compressed.{algext}"
+	DefaultDecompress = "{name}_decompressed.txt"
+)
+
+// maxLength caps the names a template produces, in bytes
+const maxLength = 255
+
+// fieldNames lists the fields a template may use
+var fieldNames = map[string]func(Fields) string{
+	"name":   func(f Fields) string { return strings.TrimSuffix(base(f.Filename), path.Ext(base(f.Filename))) },
+	"ext":    func(f Fields) string { return strings.TrimPrefix(path.Ext(base(f.Filename)), ".") },
+	"alg":    func(f Fields) string { return f.Algorithm },
+	"algext": func(f Fields) string { return f.Extension },
+	"op":     func(f Fields) string { return f.Operation },
+	"date":   func(f Fields) string { return f.Time.UTC().Format("2006-01-02") },
+	"time":   func(f Fields) string { return f.Time.UTC().Format("150405") },
+}
+
+// ErrInvalidTemplate is wrapped by the errors of Parse
+var ErrInvalidTemplate = errors.New("invalid name template")
+
+// Fields are what a template is filled in with
+type Fields struct {
+	Filename  string // of the input, "file" when it has none
+	Algorithm string
+	Extension string // of the output of the algorithm
+	Operation string // "compress" or "decompress"
+	Time      time.Time
+}
+
+// Template is a parsed name template: text with fields in braces
+type Template struct {
+	parts []part
+}
+
+type part struct {
+	text  string
+	field func(Fields) string // nil for text
+}
+
+// Parse parses a template. Fields are {name} and {ext}, the name of the input
+// file without and its extension, {alg} and {algext}, the algorithm and the
+// extension of its output, {op}, the operation, and {date} and {time}, when
+// the request came in as UTC YYYY-MM-DD and HHMMSS. "{{" and "}}" stand for
+// braces.
+func Parse(template string) (Template, error) {
+	var t Template
+	var text strings.Builder
+	for i := 0; i < len(template); i++ {
+		switch c := template[i]; {
+		case (c == '{' || c == '}') && i+1 < len(template) && template[i+1] == c:
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return Template{}, fmt.Errorf("%w: unclosed { at %d", ErrInvalidTemplate, i)
+			}
+			name := template[i+1 : i+end]
+			field, ok := fieldNames[name]
+			if !ok {
+				return Template{}, fmt.Errorf("%w: unknown field {%s}", ErrInvalidTemplate, name)
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, part{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, part{field: field})
+			i += end
+		case c == '}':
+			return Template{}, fmt.Errorf("%w: unopened } at %d", ErrInvalidTemplate, i)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, part{text: text.String()})
+	}
+	if len(t.parts) == 0 {
+		return Template{}, fmt.Errorf("%w: empty", ErrInvalidTemplate)
+	}
+	return t, nil
+}
+
+// MustParse is Parse for templates known to be valid
+func MustParse(template string) Template {
+	t, err := Parse(template)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Execute fills in the template. The name is made safe to use as a file
+// name and a header value: separators and control characters become '_',
+// and a name that comes out empty or as "." or ".." is "file".
+func (t Template) Execute(f Fields) string {
+	if f.Filename == "" {
+		f.Filename = "file"
+	}
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field != nil {
+			b.WriteString(p.field(f))
+		} else {
+			b.WriteString(p.text)
+		}
+	}
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\"`, r) {
+			return '_'
+		}
+		return r
+	}, b.String())
+	if len(name) > maxLength {
+		name = strings.ToValidUTF8(name[:maxLength], "")
+	}
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// base is the last element of a file name that may come with a path, from
+// either kind of separator
+func base(filename string) string {
+	return filename[strings.LastIndexAny(filename, `/\`)+1:]
+}
@@ -0,0 +1,48 @@
+package naming
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecute(t *testing.T) {
+	fields := Fields{
+		Filename:  "reports/q3.csv",
+		Algorithm: "gzip",
+		Extension: "gz",
+		Operation: "compress",
+		Time:      time.Date(2025, 3, 14, 15, 9, 26, 0, time.FixedZone("CET", 3600)),
+	}
+	tests := []struct {
+		template string
+		fields   Fields
+		want     string
+	}{
+		{DefaultCompress, fields, "q3_compressed.gz"},
+		{DefaultDecompress, Fields{}, "file_decompressed.txt"},
+		{"{name}.{date}.{alg}", fields, "q3.2025-03-14.gzip"},
+		{"{op}-{name}-{time}.{ext}.{algext}", fields, "compress-q3-140926.csv.gz"},
+		{"{{literal}}-{name}", fields, "{literal}-q3"},
+		// what would escape the directory or break the header is replaced
+		{"../{name}\n", fields, ".._q3_"},
+		{"{ext}", Fields{Filename: "noext"}, "file"},
+	}
+	for _, test := range tests {
+		template, err := Parse(test.template)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.template, err)
+		}
+		if got := template.Execute(test.fields); got != test.want {
+			t.Errorf("%q gave %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, template := range []string{"", "{name", "name}", "{size}", "{}"} {
+		if _, err := Parse(template); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("Parse(%q): %v", template, err)
+		}
+	}
+}
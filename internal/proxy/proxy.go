@@ -0,0 +1,156 @@
+// Package proxy is a reverse proxy that compresses the responses of an
+// upstream server with this module's codecs, for the clients that accept
+// them, and decompresses the request bodies clients send compressed before
+// they go upstream. Neither the clients nor the upstream have to support
+// compression themselves.
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/pkg/httpgzip"
+)
+
+// Options configure New, zero values select the defaults
+type Options struct {
+	MaxBodySize    int64 // largest request body as it arrives, 0 for no limit
+	MaxDecodedSize int64 // largest request body once decompressed, 0 for no limit
+
+	// Compression chooses the responses that are compressed
+	Compression httpgzip.Options
+}
+
+// requestCodings maps the content codings request bodies are decompressed
+// from to the algorithms that decode them. "deflate" is zlib wrapped
+// deflate, RFC 9110 section 8.4.1.2.
+var requestCodings = map[string]string{
+	"gzip":    "gzip",
+	"x-gzip":  "gzip",
+	"deflate": "zlib",
+}
+
+// New returns a handler that forwards every request to target. Responses
+// are fetched uncompressed and compressed here, as httpgzip.Wrap does,
+// unless the upstream encoded them anyway. Only server-sent events are
+// flushed as they arrive, other responses are held back until httpgzip has
+// seen enough of them to decide.
+func New(target *url.URL, options Options) http.Handler {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// the transport would otherwise ask for gzip and decode it with the standard library
+	transport.DisableCompression = true
+	upstream := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Header.Del("Accept-Encoding")
+		},
+		Transport: transport,
+	}
+	compressed := httpgzip.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.ServeHTTP(&flushFilter{ResponseWriter: w}, r)
+	}), options.Compression)
+	return decodeRequests(compressed, options)
+}
+
+// flushFilter drops the flushes of ReverseProxy, which flushes responses
+// without a Content-Length right after their headers: httpgzip would send
+// them before seeing a byte of the body, and never compress them. Event
+// streams are flushed, they are no use held back, and are exempt from the
+// server's write timeout, they stay open for as long as the upstream sends.
+type flushFilter struct {
+	http.ResponseWriter
+	stream bool
+}
+
+func (f *flushFilter) WriteHeader(status int) {
+	mediaType, _, _ := mime.ParseMediaType(f.Header().Get("Content-Type"))
+	f.stream = mediaType == "text/event-stream"
+	if f.stream {
+		http.NewResponseController(f.ResponseWriter).SetWriteDeadline(time.Time{})
+	}
+	f.ResponseWriter.WriteHeader(status)
+}
+
+func (f *flushFilter) Flush() {
+	if f.stream {
+		http.NewResponseController(f.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController hijack upgraded connections
+func (f *flushFilter) Unwrap() http.ResponseWriter {
+	return f.ResponseWriter
+}
+
+// decodeRequests decompresses the bodies of requests that carry a
+// Content-Encoding before passing them to next. Codings applied one after
+// another are undone in reverse. Bodies in a coding it cannot decode are
+// refused with 415 and the codings it can decode, RFC 7694.
+func decodeRequests(next http.Handler, options Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var codings []string
+		for _, value := range r.Header.Values("Content-Encoding") {
+			for _, coding := range strings.Split(value, ",") {
+				if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+					codings = append(codings, coding)
+				}
+			}
+		}
+		if len(codings) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, coding := range codings {
+			if _, ok := requestCodings[coding]; !ok {
+				w.Header().Set("Accept-Encoding", "gzip, deflate")
+				http.Error(w, fmt.Sprintf("content coding %q is not supported", coding), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		body := r.Body
+		if options.MaxBodySize > 0 {
+			body = http.MaxBytesReader(w, r.Body, options.MaxBodySize)
+		}
+		data, err := io.ReadAll(body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read the request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		for i := len(codings) - 1; i >= 0; i-- {
+			decodeOptions := compression.Options{Algorithm: requestCodings[codings[i]], MaxDecodedSize: options.MaxDecodedSize}
+			data, _, err = compression.DecompressContext(r.Context(), data, decodeOptions)
+			var limit *compression.SizeLimitError
+			if errors.As(err, &limit) {
+				http.Error(w, fmt.Sprintf("decompressed request body is larger than %d bytes", limit.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("request body is not valid %s data: %v", codings[i], err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+func TestProxy(t *testing.T) {
+	page := strings.Repeat("<li>a line the upstream sends uncompressed</li>\n", 100)
+	var received []byte
+	var receivedEncoding, acceptEncoding string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		receivedEncoding = r.Header.Get("Content-Encoding")
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, page)
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	server := httptest.NewServer(New(target, Options{MaxBodySize: 1 << 16, MaxDecodedSize: 1 << 12}))
+	defer server.Close()
+
+	// responses are compressed for clients that accept it
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/page", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("response is encoded as %q, want gzip", resp.Header.Get("Content-Encoding"))
+	}
+	reader, err := stdgzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	resp.Body.Close()
+	if err != nil || string(body) != page {
+		t.Errorf("response decoded to %d bytes, %v", len(body), err)
+	}
+	if acceptEncoding != "" {
+		t.Errorf("the upstream was sent Accept-Encoding %q", acceptEncoding)
+	}
+
+	// request bodies arrive upstream decompressed
+	content := []byte(strings.Repeat("a request body sent compressed\n", 40))
+	compressed, _, err := compression.Compress(content, compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"gzip", compressed, http.StatusOK},
+		{"", content, http.StatusOK},
+		{"br", compressed, http.StatusUnsupportedMediaType},
+		{"gzip", content, http.StatusBadRequest},
+		{"gzip", mustCompress(t, bytes.Repeat([]byte{'x'}, 1<<13)), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		received, receivedEncoding = nil, ""
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/upload", bytes.NewReader(test.body))
+		req.Header.Set("Content-Encoding", test.encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%q body: status %d, want %d", test.encoding, resp.StatusCode, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && (!bytes.Equal(received, content) || receivedEncoding != "") {
+			t.Errorf("%q body: the upstream received %d bytes encoded as %q", test.encoding, len(received), receivedEncoding)
+		}
+	}
+}
+
+func mustCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	compressed, _, err := compression.Compress(data, compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed
+}
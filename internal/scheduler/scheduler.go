@@ -0,0 +1,193 @@
+// Package scheduler bounds how many compression jobs run at once and
+// decides which waiting job runs next. Jobs carry a priority: interactive
+// ones, a user waiting on an API call, always run before batch ones, so a
+// client sending bulk work cannot keep the workers from them.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority orders the jobs waiting for a worker, lower values run first
+type Priority int
+
+const (
+	Interactive Priority = iota
+	Batch
+
+	numPriorities = iota
+)
+
+// priorityNames are the names of the priorities, as requests give them
+var priorityNames = [numPriorities]string{"interactive", "batch"}
+
+// ErrInvalidPriority is wrapped by the errors of ParsePriority
+var ErrInvalidPriority = errors.New("invalid priority")
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority reads the name of a priority, an empty one is Interactive
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return Interactive, nil
+	}
+	for p, n := range priorityNames {
+		if n == name {
+			return Priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q, use one of %v", ErrInvalidPriority, name, priorityNames)
+}
+
+// QueueStats describe the jobs of a priority
+type QueueStats struct {
+	Priority  string        `json:"priority"`
+	Waiting   int           `json:"waiting"`
+	Running   int           `json:"running"`
+	Admitted  int64         `json:"admitted"`  // jobs that got a worker
+	Abandoned int64         `json:"abandoned"` // jobs whose request ended while they waited
+	WaitTime  time.Duration `json:"wait_time"` // spent waiting by the admitted jobs together
+}
+
+// Pool hands out a fixed number of workers. A job waits for one in a queue
+// of its priority, first in first out, and a worker that is released goes
+// to the first job of the highest priority that is waiting.
+type Pool struct {
+	workers int
+
+	lock   sync.Mutex
+	free   int
+	queues [numPriorities][]*waiter
+	stats  [numPriorities]QueueStats
+}
+
+// waiter is a job waiting for a worker
+type waiter struct {
+	priority Priority
+	since    time.Time
+	admitted chan struct{} // closed when the job got a worker
+}
+
+// New returns a pool of workers, or nil when workers is not positive. A nil
+// pool runs every job right away.
+func New(workers int) *Pool {
+	if workers <= 0 {
+		return nil
+	}
+	p := &Pool{workers: workers, free: workers}
+	for i := range p.stats {
+		p.stats[i].Priority = Priority(i).String()
+	}
+	return p
+}
+
+// Workers returns the size of the pool, 0 for a nil pool
+func (p *Pool) Workers() int {
+	if p == nil {
+		return 0
+	}
+	return p.workers
+}
+
+// Acquire waits for a worker for a job of priority and returns the function
+// that gives it back, which has to be called once the job is done. When ctx
+// ends first the job leaves the queue and ctx's error is returned.
+func (p *Pool) Acquire(ctx context.Context, priority Priority) (release func(), err error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	if priority < 0 || priority >= numPriorities {
+		return nil, fmt.Errorf("%w %d", ErrInvalidPriority, priority)
+	}
+	p.lock.Lock()
+	if p.free > 0 {
+		// workers are only free when nobody waits for them
+		p.free--
+		p.stats[priority].Running++
+		p.stats[priority].Admitted++
+		p.lock.Unlock()
+		return p.releaser(priority), nil
+	}
+	w := &waiter{priority: priority, since: time.Now(), admitted: make(chan struct{})}
+	p.queues[priority] = append(p.queues[priority], w)
+	p.stats[priority].Waiting++
+	p.lock.Unlock()
+
+	select {
+	case <-w.admitted:
+		return p.releaser(priority), nil
+	case <-ctx.Done():
+	}
+	p.lock.Lock()
+	select {
+	case <-w.admitted:
+		// a worker came at the same time, pass it on
+		p.lock.Unlock()
+		p.releaser(priority)()
+	default:
+		queue := p.queues[priority]
+		for i := range queue {
+			if queue[i] == w {
+				p.queues[priority] = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		p.stats[priority].Waiting--
+		p.stats[priority].Abandoned++
+		p.lock.Unlock()
+	}
+	return nil, ctx.Err()
+}
+
+// releaser returns the release function of a job of priority, calling it
+// more than once does nothing
+func (p *Pool) releaser(priority Priority) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { p.release(priority) })
+	}
+}
+
+// release gives the worker of a job of priority to the next waiting job
+func (p *Pool) release(priority Priority) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats[priority].Running--
+	for next := range p.queues {
+		queue := p.queues[next]
+		if len(queue) == 0 {
+			continue
+		}
+		w := queue[0]
+		queue[0] = nil
+		p.queues[next] = queue[1:]
+		stats := &p.stats[next]
+		stats.Waiting--
+		stats.Running++
+		stats.Admitted++
+		stats.WaitTime += time.Since(w.since)
+		close(w.admitted)
+		return
+	}
+	p.free++
+}
+
+// Stats returns the queues of every priority, highest first, nil for a nil
+// pool
+func (p *Pool) Stats() []QueueStats {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]QueueStats(nil), p.stats[:]...)
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	for name, want := range map[string]Priority{"": Interactive, "interactive": Interactive, "batch": Batch} {
+		if p, err := ParsePriority(name); err != nil || p != want {
+			t.Errorf("ParsePriority(%q) = %v, %v", name, p, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); !errors.Is(err, ErrInvalidPriority) {
+		t.Errorf("ParsePriority(urgent): %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := New(1)
+	release, err := p.Acquire(context.Background(), Batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a batch job queues before an interactive one, which still runs first
+	order := make(chan Priority, 2)
+	wait := func(priority Priority) {
+		done, err := p.Acquire(context.Background(), priority)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- priority
+		done()
+	}
+	go wait(Batch)
+	waitFor(t, p, Batch, 1)
+	go wait(Interactive)
+	waitFor(t, p, Interactive, 1)
+
+	// a job whose request ends leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := make(chan error)
+	go func() {
+		_, err := p.Acquire(ctx, Batch)
+		abandoned <- err
+	}()
+	waitFor(t, p, Batch, 2)
+	cancel()
+	if err := <-abandoned; !errors.Is(err, context.Canceled) {
+		t.Errorf("abandoned job: %v", err)
+	}
+
+	release()
+	release()
+	if first, second := <-order, <-order; first != Interactive || second != Batch {
+		t.Errorf("jobs ran in the order %v, %v", first, second)
+	}
+	stats := p.Stats()
+	if stats[Interactive].Admitted != 1 || stats[Batch].Admitted != 2 || stats[Batch].Abandoned != 1 || stats[Batch].Running != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// the worker is free again
+	if release, err = p.Acquire(context.Background(), Interactive); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	var none *Pool
+	if release, err := none.Acquire(context.Background(), Batch); err != nil || none.Stats() != nil {
+		t.Errorf("nil pool: %v", err)
+	} else {
+		release()
+	}
+}
+
+// waitFor waits until n jobs of priority are queued
+func waitFor(t *testing.T, p *Pool, priority Priority, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if p.Stats()[priority].Waiting == n {
+			return
+		}
+	}
+	t.Fatalf("%d %v jobs never queued", n, priority)
+}
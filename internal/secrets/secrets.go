@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Kind identifies a family of secrets
+type Kind string
+
+const (
+	// APIKeys authenticate requests to /api/v1, see api.APIKeyAuth
+	APIKeys Kind = "API_KEYS"
+
+	// WebhookSigningSecrets and URLSigningKeys are loaded and rotated with
+	// the API keys, but nothing reads them yet: they are there for the
+	// webhook and signed URL callers to come, so deployments can provision
+	// them ahead of time
+	WebhookSigningSecrets Kind = "WEBHOOK_SIGNING_SECRETS"
+	URLSigningKeys        Kind = "URL_SIGNING_KEYS"
+)
+
+// kinds lists every secret family the store manages
+var kinds = []Kind{APIKeys, WebhookSigningSecrets, URLSigningKeys}
+
+// Store holds the currently active secrets. Each kind may hold several values
+// to support rotation: the first value is the current one (used for signing),
+// and every value is accepted when verifying.
+//
+// Secrets are read from the environment variable named after the kind
+// (comma separated), or from the file named by <KIND>_FILE (one value per
+// line, blank lines and lines starting with '#' ignored). The file takes
+// precedence so that secrets never have to appear in the process environment,
+// and must hold at least one value.
+type Store struct {
+	lock   sync.RWMutex
+	values map[Kind][]string
+}
+
+// Load reads all secrets from the environment and their files
+func Load() (*Store, error) {
+	store := &Store{values: make(map[Kind][]string)}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads every secret source. On failure the previous secrets stay
+// active, so a half-written rotation file never locks clients out.
+func (s *Store) Reload() error {
+	values := make(map[Kind][]string, len(kinds))
+	for _, kind := range kinds {
+		loaded, err := load(kind)
+		if err != nil {
+			return err
+		}
+		values[kind] = loaded
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values = values
+	return nil
+}
+
+// Values returns every active secret of the given kind, current first
+func (s *Store) Values(kind Kind) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]string{}, s.values[kind]...)
+}
+
+// Current returns the secret of the given kind used for signing
+func (s *Store) Current(kind Kind) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.values[kind]) == 0 {
+		return "", false
+	}
+	return s.values[kind][0], true
+}
+
+// Configured reports whether any secret of the given kind is set
+func (s *Store) Configured(kind Kind) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.values[kind]) > 0
+}
+
+// Matches reports whether candidate equals any active secret of the given
+// kind. Every value is compared in constant time.
+func (s *Store) Matches(kind Kind, candidate string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	matched := 0
+	for _, value := range s.values[kind] {
+		matched |= subtle.ConstantTimeCompare([]byte(value), []byte(candidate))
+	}
+	return matched == 1
+}
+
+func load(kind Kind) ([]string, error) {
+	if path := os.Getenv(string(kind) + "_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s_FILE: %w", kind, err)
+		}
+		var values []string
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			values = append(values, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to parse %s_FILE: %w", kind, err)
+		}
+		// a file set but emptied would otherwise turn authentication off
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%s_FILE %s holds no values", kind, path)
+		}
+		return values, nil
+	}
+	var values []string
+	for _, value := range strings.Split(os.Getenv(string(kind)), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// setFile writes content to a file that kind is read from
+func setFile(t *testing.T, kind Kind, content string) string {
+	path := filepath.Join(t.TempDir(), string(kind))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(string(kind)+"_FILE", path)
+	return path
+}
+
+func TestFileBeforeEnvironment(t *testing.T) {
+	t.Setenv(string(APIKeys), "from-env, second-env")
+	t.Setenv(string(URLSigningKeys), "url-key")
+	setFile(t, APIKeys, "# rotated 2024-01-01\nfrom-file\n\n  spaced  \n")
+	store, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := store.Values(APIKeys); !slices.Equal(values, []string{"from-file", "spaced"}) {
+		t.Errorf("Values(APIKeys) = %q, want those of the file", values)
+	}
+	if store.Matches(APIKeys, "from-env") {
+		t.Error("a key of the environment matched while the file is set")
+	}
+	// the kinds without a file still come from the environment
+	if current, ok := store.Current(URLSigningKeys); !ok || current != "url-key" {
+		t.Errorf("Current(URLSigningKeys) = %q, %v", current, ok)
+	}
+	if store.Configured(WebhookSigningSecrets) {
+		t.Error("WebhookSigningSecrets configured without being set")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := setFile(t, APIKeys, "old\n")
+	store, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a rotation adds the new key in front and keeps the old one for a while
+	if err := os.WriteFile(path, []byte("new\nold\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := store.Current(APIKeys); current != "new" {
+		t.Errorf("Current after rotating = %q, want new", current)
+	}
+	for _, key := range []string{"new", "old"} {
+		if !store.Matches(APIKeys, key) {
+			t.Errorf("%s does not match after rotating", key)
+		}
+	}
+	if store.Matches(APIKeys, "ne") || store.Matches(APIKeys, "") {
+		t.Error("a prefix or an empty key matched")
+	}
+
+	// a file that cannot be read leaves the keys as they were
+	os.Remove(path)
+	if err := store.Reload(); err == nil {
+		t.Error("Reload of a missing file succeeded")
+	}
+	if !store.Matches(APIKeys, "old") {
+		t.Error("a failed Reload dropped the keys")
+	}
+
+	// and so does one emptied of its keys
+	if err := os.WriteFile(path, []byte("# rotated away\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Error("Reload of a file without keys succeeded")
+	}
+	if !store.Matches(APIKeys, "old") {
+		t.Error("a Reload of a file without keys dropped the keys")
+	}
+}
+
+// TestEmptyFile refuses to start with a file that is set but holds no keys,
+// where an empty environment variable just leaves the kind unset
+func TestEmptyFile(t *testing.T) {
+	for _, content := range []string{"", "\n  \n", "# no keys yet\n"} {
+		setFile(t, APIKeys, content)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load of a file holding %q succeeded", content)
+		}
+	}
+	t.Setenv(string(APIKeys)+"_FILE", "")
+	t.Setenv(string(APIKeys), " , ")
+	store, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Configured(APIKeys) {
+		t.Error("APIKeys configured from an empty variable")
+	}
+}
@@ -0,0 +1,381 @@
+package seekable
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"golang.org/x/sync/errgroup"
+)
+
+// A seekable stream is the input cut into frames of FrameSize bytes, each
+// compressed on its own as a complete flate or gzip stream, followed by a
+// seek table:
+//
+//	entries  per frame: uint32 compressed size, uint32 size, uint32 CRC-32 of the frame's input
+//	footer   uint32 frame count, algorithm byte (0 flate, 1 gzip), "CFDS"
+//
+// Integers are little-endian. Frames do not refer to each other, so any byte
+// range is read by decompressing only the frames it overlaps. For gzip the
+// seek table is carried in the extra field (subfield "SK") of a last, empty
+// gzip member, so the whole stream stays a multi-member gzip file that gunzip
+// decompresses as usual. For flate it is appended as it is, and such a stream
+// is only read by this package.
+
+const (
+	footerMagic  = "CFDS"
+	footerLength = 4 + 1 + len(footerMagic)
+	entryLength  = 4 + 4 + 4
+
+	// DefaultFrameSize trades the ratio, frames share no history, against
+	// how much has to be decompressed to read a few bytes
+	DefaultFrameSize = 1 << 20
+	// MaxFrameSize keeps frame sizes within the uint32 of the seek table
+	MaxFrameSize = 1 << 30
+)
+
+const (
+	algorithmFlate byte = iota
+	algorithmGzip
+)
+
+// indexMember is the part of the empty gzip member that holds the seek table
+// before and after it: the gzip header with FEXTRA set, the extra field
+// length, the subfield id and length, and after the table a final empty
+// fixed Huffman block and a zero CRC and size
+var (
+	indexMemberHeader  = []byte{0x1f, 0x8b, 8, 1 << 2, 0, 0, 0, 0, 0, 255}
+	indexMemberTrailer = []byte{0x03, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+)
+
+const indexMemberHeaderLength = 10 + 2 + 2 + 2
+
+// maxGzipFrames is what fits in the 64KB extra field of the index member
+const maxGzipFrames = (0xffff - 4 - footerLength) / entryLength
+
+// Errors returned by the seekable Writer and Reader
+var (
+	ErrUnsupportedAlgorithm = errors.New("seekable: only flate and gzip streams can be seekable")
+	ErrInvalidFrameSize     = errors.New("seekable: frame size is out of range")
+	ErrTooManyFrames        = errors.New("seekable: too many frames for the gzip seek table, use a larger frame size")
+	ErrNotSeekable          = errors.New("seekable: no seek table found")
+	ErrCorrupt              = errors.New("seekable: corrupt seek table")
+	ErrChecksumMismatch     = errors.New("seekable: frame checksum mismatch")
+	ErrWriterClosed         = errors.New("seekable: writer is closed")
+)
+
+// Writer compresses what is written to it into a seekable stream. Close has
+// to be called to write the last frame and the seek table.
+type Writer struct {
+	ctx       context.Context
+	w         io.Writer
+	algorithm string
+	frameSize int
+	pending   []byte
+	table     []byte
+	frames    int
+	closed    bool
+}
+
+// NewWriter returns a Writer that writes frames of frameSize bytes of input,
+// compressed with algorithm, to w. A frameSize of 0 means DefaultFrameSize.
+func NewWriter(ctx context.Context, w io.Writer, algorithm string, frameSize int) (*Writer, error) {
+	if algorithm != "flate" && algorithm != "gzip" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	if frameSize == 0 {
+		frameSize = DefaultFrameSize
+	}
+	if frameSize < 0 || frameSize > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidFrameSize, frameSize)
+	}
+	return &Writer{ctx: ctx, w: w, algorithm: algorithm, frameSize: frameSize}, nil
+}
+
+// Write buffers p and compresses every frame that is complete
+func (sw *Writer) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrWriterClosed
+	}
+	sw.pending = append(sw.pending, p...)
+	for len(sw.pending) >= sw.frameSize {
+		if err := sw.writeFrame(sw.pending[:sw.frameSize]); err != nil {
+			return 0, err
+		}
+		sw.pending = append(sw.pending[:0], sw.pending[sw.frameSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close writes the last, shorter frame and the seek table. It does not close
+// the underlying writer.
+func (sw *Writer) Close() error {
+	if sw.closed {
+		return ErrWriterClosed
+	}
+	sw.closed = true
+	if len(sw.pending) > 0 {
+		if err := sw.writeFrame(sw.pending); err != nil {
+			return err
+		}
+		sw.pending = nil
+	}
+
+	algorithm := algorithmFlate
+	if sw.algorithm == "gzip" {
+		algorithm = algorithmGzip
+	}
+	table := binary.LittleEndian.AppendUint32(sw.table, uint32(sw.frames))
+	table = append(table, algorithm)
+	table = append(table, footerMagic...)
+	if algorithm == algorithmFlate {
+		_, err := sw.w.Write(table)
+		return err
+	}
+
+	member := append([]byte{}, indexMemberHeader...)
+	member = binary.LittleEndian.AppendUint16(member, uint16(4+len(table)))
+	member = append(member, 'S', 'K')
+	member = binary.LittleEndian.AppendUint16(member, uint16(len(table)))
+	member = append(member, table...)
+	member = append(member, indexMemberTrailer...)
+	_, err := sw.w.Write(member)
+	return err
+}
+
+func (sw *Writer) writeFrame(data []byte) error {
+	if sw.algorithm == "gzip" && sw.frames == maxGzipFrames {
+		return ErrTooManyFrames
+	}
+	compressed, _, err := compression.CompressContext(sw.ctx, data, compression.Options{Algorithm: sw.algorithm})
+	if err != nil {
+		return err
+	}
+	if _, err := sw.w.Write(compressed); err != nil {
+		return err
+	}
+	sw.table = binary.LittleEndian.AppendUint32(sw.table, uint32(len(compressed)))
+	sw.table = binary.LittleEndian.AppendUint32(sw.table, uint32(len(data)))
+	sw.table = binary.LittleEndian.AppendUint32(sw.table, checksum.SumCRC32(data))
+	sw.frames++
+	return nil
+}
+
+// Compress returns data as a seekable stream
+func Compress(ctx context.Context, data []byte, algorithm string, frameSize int) ([]byte, error) {
+	var buf bytes.Buffer
+	sw, err := NewWriter(ctx, &buf, algorithm, frameSize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type frame struct {
+	offset         int64 // of the compressed frame in the stream
+	compressedSize int64
+	start          int64 // of the frame's data in the decompressed stream
+	size           int64
+	crc            uint32
+}
+
+// Reader gives random access to the decompressed content of a seekable
+// stream. It is safe for concurrent use.
+type Reader struct {
+	r         io.ReaderAt
+	algorithm string
+	frames    []frame
+	size      int64
+
+	concurrency int // frames decompressed at once
+
+	mu          sync.Mutex
+	cachedFrame int
+	cachedData  []byte
+}
+
+// NewReader reads the seek table of the seekable stream of the given size in
+// r, no frame is decompressed until it is read
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	tail := make([]byte, min(size, int64(footerLength+len(indexMemberTrailer))))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+
+	// the footer ends the stream, or the index member that ends it
+	footerEnd := size
+	if !bytes.HasSuffix(tail, []byte(footerMagic)) {
+		if !bytes.HasSuffix(tail, indexMemberTrailer) || len(tail) < footerLength+len(indexMemberTrailer) {
+			return nil, ErrNotSeekable
+		}
+		footerEnd -= int64(len(indexMemberTrailer))
+		tail = tail[:len(tail)-len(indexMemberTrailer)]
+		if !bytes.HasSuffix(tail, []byte(footerMagic)) {
+			return nil, ErrNotSeekable
+		}
+	}
+	footer := tail[len(tail)-footerLength:]
+	count := int64(binary.LittleEndian.Uint32(footer))
+	algorithm := footer[4]
+	if (algorithm == algorithmFlate) != (footerEnd == size) || algorithm > algorithmGzip {
+		return nil, fmt.Errorf("%w: algorithm %d does not match the layout", ErrCorrupt, algorithm)
+	}
+
+	tableStart := footerEnd - int64(footerLength) - count*entryLength
+	dataEnd := tableStart
+	if algorithm == algorithmGzip {
+		dataEnd -= indexMemberHeaderLength
+	}
+	if dataEnd < 0 {
+		return nil, fmt.Errorf("%w: %d frames do not fit in %d bytes", ErrCorrupt, count, size)
+	}
+	table := make([]byte, footerEnd-dataEnd)
+	if _, err := r.ReadAt(table, dataEnd); err != nil {
+		return nil, err
+	}
+	if algorithm == algorithmGzip {
+		header := table[:indexMemberHeaderLength]
+		if !bytes.HasPrefix(header, indexMemberHeader[:4]) || string(header[12:14]) != "SK" ||
+			int(binary.LittleEndian.Uint16(header[14:])) != len(table)-indexMemberHeaderLength {
+			return nil, fmt.Errorf("%w: the gzip member holding it is damaged", ErrCorrupt)
+		}
+		table = table[indexMemberHeaderLength:]
+	}
+
+	sr := &Reader{r: r, algorithm: "flate", frames: make([]frame, count), concurrency: 1, cachedFrame: -1}
+	if algorithm == algorithmGzip {
+		sr.algorithm = "gzip"
+	}
+	var offset int64
+	for i := range sr.frames {
+		entry := table[i*entryLength:]
+		f := frame{
+			offset:         offset,
+			compressedSize: int64(binary.LittleEndian.Uint32(entry)),
+			start:          sr.size,
+			size:           int64(binary.LittleEndian.Uint32(entry[4:])),
+			crc:            binary.LittleEndian.Uint32(entry[8:]),
+		}
+		sr.frames[i] = f
+		offset += f.compressedSize
+		sr.size += f.size
+	}
+	if offset != dataEnd {
+		return nil, fmt.Errorf("%w: frames add up to %d bytes, the data has %d", ErrCorrupt, offset, dataEnd)
+	}
+	return sr, nil
+}
+
+// Size returns the length of the decompressed content
+func (sr *Reader) Size() int64 {
+	return sr.size
+}
+
+// Algorithm returns the algorithm the frames are compressed with
+func (sr *Reader) Algorithm() string {
+	return sr.algorithm
+}
+
+// Frames returns the number of frames
+func (sr *Reader) Frames() int {
+	return len(sr.frames)
+}
+
+// SetConcurrency sets how many frames a read decompresses at once, 1 by
+// default. It has to be called before the reader is used.
+func (sr *Reader) SetConcurrency(n int) {
+	sr.concurrency = max(n, 1)
+}
+
+// ReadAt reads len(p) bytes of decompressed content starting at off,
+// decompressing only the frames the range overlaps
+func (sr *Reader) ReadAt(p []byte, off int64) (int, error) {
+	return sr.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is ReadAt with a context for the decompression
+func (sr *Reader) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("seekable: negative offset %d", off)
+	}
+	// the first frame that ends after off
+	i := sort.Search(len(sr.frames), func(i int) bool {
+		return sr.frames[i].start+sr.frames[i].size > off
+	})
+	// and the frames up to the end of the range, decompressed concurrently
+	// and copied in order
+	last := i
+	for last+1 < len(sr.frames) && sr.frames[last+1].start < off+int64(len(p)) {
+		last++
+	}
+	var data [][]byte
+	var errs []error
+	if i < len(sr.frames) && len(p) > 0 {
+		data, errs = make([][]byte, last-i+1), make([]error, last-i+1)
+	}
+	// not cancelled on the first error, the frames before it are still read
+	var g errgroup.Group
+	g.SetLimit(sr.concurrency)
+	for j := range data {
+		g.Go(func() error {
+			data[j], errs[j] = sr.frame(ctx, i+j)
+			return nil
+		})
+	}
+	g.Wait()
+
+	n := 0
+	for j := 0; n < len(p) && j < len(data); j++ {
+		if errs[j] != nil {
+			return n, errs[j]
+		}
+		n += copy(p[n:], data[j][off+int64(n)-sr.frames[i+j].start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// frame returns the decompressed data of frame i, the last one is kept for
+// reads that continue where the previous one stopped
+func (sr *Reader) frame(ctx context.Context, i int) ([]byte, error) {
+	sr.mu.Lock()
+	if sr.cachedFrame == i {
+		data := sr.cachedData
+		sr.mu.Unlock()
+		return data, nil
+	}
+	sr.mu.Unlock()
+
+	f := sr.frames[i]
+	compressed := make([]byte, f.compressedSize)
+	if _, err := sr.r.ReadAt(compressed, f.offset); err != nil {
+		return nil, err
+	}
+	data, _, err := compression.DecompressContext(ctx, compressed, compression.Options{Algorithm: sr.algorithm})
+	if err != nil {
+		return nil, fmt.Errorf("seekable: frame %d: %w", i, err)
+	}
+	if int64(len(data)) != f.size || checksum.SumCRC32(data) != f.crc {
+		return nil, fmt.Errorf("%w: frame %d", ErrChecksumMismatch, i)
+	}
+
+	sr.mu.Lock()
+	sr.cachedFrame, sr.cachedData = i, data
+	sr.mu.Unlock()
+	return data, nil
+}
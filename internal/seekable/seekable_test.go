@@ -0,0 +1,83 @@
+package seekable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+)
+
+// TestSeekableRoundTrip reads ranges that start, end and cross frame
+// boundaries and checks that a gzip stream is still plain gzip
+func TestSeekableRoundTrip(t *testing.T) {
+	var content bytes.Buffer
+	for i := 0; content.Len() < 10000; i++ {
+		fmt.Fprintf(&content, "line %d of the seekable test\n", i)
+	}
+	data := content.Bytes()
+
+	for _, algorithm := range []string{"flate", "gzip"} {
+		stream, err := Compress(context.Background(), data, algorithm, 1000)
+		if err != nil {
+			t.Fatalf("%s: Compress: %v", algorithm, err)
+		}
+		sr, err := NewReader(bytes.NewReader(stream), int64(len(stream)))
+		if err != nil {
+			t.Fatalf("%s: NewReader: %v", algorithm, err)
+		}
+		if sr.Size() != int64(len(data)) || sr.Frames() != (len(data)+999)/1000 || sr.Algorithm() != algorithm {
+			t.Fatalf("%s: reader has size %d, %d frames and algorithm %s", algorithm, sr.Size(), sr.Frames(), sr.Algorithm())
+		}
+		for _, concurrency := range []int{1, 4} {
+			sr.SetConcurrency(concurrency)
+			for _, r := range [][2]int{{0, 10}, {995, 10}, {1000, 1000}, {2500, 4000}, {9990, len(data) - 9990}, {0, len(data)}} {
+				p := make([]byte, r[1])
+				if n, err := sr.ReadAt(p, int64(r[0])); err != nil || !bytes.Equal(p[:n], data[r[0]:r[0]+r[1]]) {
+					t.Errorf("%s: ReadAt(%d, %d) with concurrency %d = %d, %v", algorithm, r[0], r[1], concurrency, n, err)
+				}
+			}
+		}
+		// reading past the end returns what there is
+		p := make([]byte, 100)
+		if n, err := sr.ReadAt(p, int64(len(data)-10)); n != 10 || err != io.EOF {
+			t.Errorf("%s: ReadAt past the end = %d, %v, want 10, EOF", algorithm, n, err)
+		}
+
+		if algorithm == "gzip" {
+			gz, err := gzip.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatalf("compress/gzip rejected the stream: %v", err)
+			}
+			if all, err := io.ReadAll(gz); err != nil || !bytes.Equal(all, data) {
+				t.Errorf("compress/gzip read %d bytes, %v", len(all), err)
+			}
+			for _, concurrency := range []int{0, 4} {
+				all, _, err := compression.DecompressContext(context.Background(), stream, compression.Options{Algorithm: "gzip", Concurrency: concurrency})
+				if err != nil || !bytes.Equal(all, data) {
+					t.Errorf("the gzip algorithm with concurrency %d read %d bytes, %v", concurrency, len(all), err)
+				}
+			}
+		}
+
+		// a damaged frame fails only the reads that touch it
+		damaged := bytes.Clone(stream)
+		damaged[sr.frames[3].offset+sr.frames[3].compressedSize/2] ^= 0x40
+		sr, _ = NewReader(bytes.NewReader(damaged), int64(len(damaged)))
+		if _, err := sr.ReadAt(make([]byte, 10), 3500); err == nil {
+			t.Errorf("%s: ReadAt of a damaged frame succeeded", algorithm)
+		}
+		if _, err := sr.ReadAt(make([]byte, 10), 500); err != nil {
+			t.Errorf("%s: ReadAt of an intact frame: %v", algorithm, err)
+		}
+	}
+
+	plain := []byte("not a seekable stream at all")
+	if _, err := NewReader(bytes.NewReader(plain), int64(len(plain))); !errors.Is(err, ErrNotSeekable) {
+		t.Errorf("NewReader of plain data = %v, want ErrNotSeekable", err)
+	}
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"errors"
+	"os"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+)
+
+// Dir returns the directory the data of the sessions is kept in
+func (s *Store) Dir() string {
+	if s.dir == "" {
+		return os.TempDir()
+	}
+	return s.dir
+}
+
+// Holds reports whether the file at path keeps the data of a session of the
+// store, files of its directory that no session holds are left over
+func (s *Store) Holds(path string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, session := range s.sessions {
+		if session.file.Name() == path {
+			return true
+		}
+		session.partsLock.Lock()
+		for _, p := range session.parts {
+			if p.path == path {
+				session.partsLock.Unlock()
+				return true
+			}
+		}
+		session.partsLock.Unlock()
+	}
+	return false
+}
+
+// Objects lists the sessions no request is using, with the bytes they keep
+// on disk, so a janitor.Janitor can hold them to a quota
+func (s *Store) Objects() ([]janitor.Object, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var objects []janitor.Object
+	for id, session := range s.sessions {
+		if !session.busy.TryLock() {
+			continue
+		}
+		size := session.stored
+		session.partsLock.Lock()
+		for _, p := range session.parts {
+			size += p.size
+		}
+		session.partsLock.Unlock()
+		session.busy.Unlock()
+		objects = append(objects, janitor.Object{Name: id, Size: size, LastUsed: session.lastUsed})
+	}
+	return objects, nil
+}
+
+// Remove deletes the session with the id unless a request is using it
+func (s *Store) Remove(id string) error {
+	err := s.Delete(id)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrBusy) {
+		return nil
+	}
+	return err
+}
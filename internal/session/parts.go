@@ -0,0 +1,271 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+)
+
+// MaxParts is the highest part number an input can be uploaded in
+const MaxParts = 10000
+
+var (
+	// ErrPartNumber is returned for part numbers outside 1 to MaxParts
+	ErrPartNumber = fmt.Errorf("session: part numbers run from 1 to %d", MaxParts)
+
+	// ErrMixedUpload is returned when a session that was appended to is
+	// sent parts, or the other way round
+	ErrMixedUpload = errors.New("session: an input is uploaded either in chunks or in parts")
+)
+
+// PartsError is returned when a session is completed while parts up to the
+// number of parts are Missing, or parts past it were uploaded
+type PartsError struct {
+	Count   int
+	Missing []int
+	Extra   []int
+}
+
+func (e *PartsError) Error() string {
+	if len(e.Missing) > 0 {
+		return fmt.Sprintf("session: parts %v of %d are missing", e.Missing, e.Count)
+	}
+	return fmt.Sprintf("session: parts %v are past the %d parts", e.Extra, e.Count)
+}
+
+// Part is a part uploaded to a session
+type Part struct {
+	Number int   `json:"number"`
+	Size   int64 `json:"size"`
+}
+
+// part is where a part is kept, in frames as the chunks of a session are.
+// The file is only open while the part is written and read back, a session
+// of MaxParts parts would otherwise hold as many descriptors.
+type part struct {
+	path string
+	size int64
+}
+
+// UploadPart stores what is read from r as the part with the number, and
+// returns its size. Parts are uploaded in any order and at the same time,
+// each to a file of its own; a part uploaded again replaces the one before.
+// Parts that would take the session past the maximum size are dropped.
+func (s *Store) UploadPart(id string, number int, r io.Reader) (int64, error) {
+	if number < 1 || number > MaxParts {
+		return 0, ErrPartNumber
+	}
+	session, err := s.acquireShared(id)
+	if err != nil {
+		return 0, err
+	}
+	defer s.releaseShared(session)
+	// chunks are only appended while the session is held exclusively
+	if session.size > 0 {
+		return 0, ErrMixedUpload
+	}
+
+	// the part may take up what the other parts leave of the maximum size,
+	// so one that does not fit stops being written once it is past that
+	file, err := os.CreateTemp(s.dir, "compression-session-part-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create part file: %w", err)
+	}
+	size, err := writeFrames(file, r, s.maxSize-session.partsSize(number))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+
+	session.partsLock.Lock()
+	defer session.partsLock.Unlock()
+	// parts uploaded at the same time may have taken up the room since
+	switch {
+	case session.removed:
+		err = ErrNotFound
+	case size+session.partsSizeLocked(number) > s.maxSize:
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if replaced := session.parts[number]; replaced != nil {
+		replaced.remove()
+	}
+	if session.parts == nil {
+		session.parts = make(map[int]*part)
+	}
+	session.parts[number] = &part{path: file.Name(), size: size}
+	return size, nil
+}
+
+// partsSize is how much the parts of the session other than the one with
+// the number take up
+func (s *Session) partsSize(number int) int64 {
+	s.partsLock.Lock()
+	defer s.partsLock.Unlock()
+	return s.partsSizeLocked(number)
+}
+
+func (s *Session) partsSizeLocked(number int) int64 {
+	total := int64(0)
+	for n, p := range s.parts {
+		if n != number {
+			total += p.size
+		}
+	}
+	return total
+}
+
+// Parts lists the parts uploaded to the session in the order of their
+// numbers
+func (s *Store) Parts(id string) ([]Part, error) {
+	session, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	session.partsLock.Lock()
+	defer session.partsLock.Unlock()
+	parts := make([]Part, 0, len(session.parts))
+	for number, p := range session.parts {
+		parts = append(parts, Part{Number: number, Size: p.size})
+	}
+	slices.SortFunc(parts, func(a, b Part) int { return a.Number - b.Number })
+	return parts, nil
+}
+
+// Complete compresses the parts of the session into dst, in the order of
+// their numbers, and deletes the session. count is the number of parts the
+// input was split into, 0 to take the highest part uploaded. Parts missing
+// up to it, or uploaded past it, fail with a *PartsError and keep the
+// session, so the parts can be put right and completing retried. So does
+// failing to compress.
+func (s *Store) Complete(ctx context.Context, id string, count int, dst io.Writer) (*compression.Stats, error) {
+	session, err := s.acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.size > 0 {
+		s.release(session)
+		return nil, ErrMixedUpload
+	}
+	return s.completeParts(ctx, session, count, dst)
+}
+
+// completeParts is Complete for a session acquired already, which it
+// releases. Parts are only uploaded while the session is shared, so they
+// are read without the lock.
+func (s *Store) completeParts(ctx context.Context, session *Session, count int, dst io.Writer) (*compression.Stats, error) {
+	if count == 0 {
+		for number := range session.parts {
+			count = max(count, number)
+		}
+	}
+	partsErr := &PartsError{Count: count}
+	var numbers []int
+	for number := 1; number <= count; number++ {
+		if session.parts[number] == nil {
+			partsErr.Missing = append(partsErr.Missing, number)
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	for number := range session.parts {
+		if number > count {
+			partsErr.Extra = append(partsErr.Extra, number)
+		}
+	}
+	slices.Sort(partsErr.Extra)
+	if count == 0 {
+		// nothing was uploaded, the first part is what is missing
+		partsErr.Missing = []int{1}
+	}
+	if len(partsErr.Missing) > 0 || len(partsErr.Extra) > 0 {
+		s.release(session)
+		return nil, partsErr
+	}
+	// each part is opened when the one before it is read to the end
+	parts := &partsReader{session: session, numbers: numbers}
+	defer parts.close()
+	return s.finish(ctx, session, parts, dst)
+}
+
+// partsReader reads the parts with the numbers one after the other, with
+// only the part being read open
+type partsReader struct {
+	session *Session
+	numbers []int
+	file    *os.File
+	chunks  *chunkReader
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.chunks == nil {
+			if len(pr.numbers) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(pr.session.parts[pr.numbers[0]].path)
+			if err != nil {
+				return 0, err
+			}
+			pr.numbers = pr.numbers[1:]
+			pr.file = file
+			pr.chunks = &chunkReader{frames: framing.NewReader(file, chunkFrameSize)}
+		}
+		n, err := pr.chunks.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		pr.close()
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// close closes the part being read, if any
+func (pr *partsReader) close() {
+	if pr.file != nil {
+		pr.file.Close()
+		pr.file, pr.chunks = nil, nil
+	}
+}
+
+// acquireShared looks the session up and locks it for one of the requests
+// uploading its parts at the same time
+func (s *Store) acquireShared(id string) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !session.busy.TryRLock() {
+		return nil, ErrBusy
+	}
+	session.lastUsed = time.Now()
+	return session, nil
+}
+
+func (s *Store) releaseShared(session *Session) {
+	s.lock.Lock()
+	session.lastUsed = time.Now()
+	s.lock.Unlock()
+	session.busy.RUnlock()
+}
+
+func (p *part) remove() error {
+	return os.Remove(p.path)
+}
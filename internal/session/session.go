@@ -0,0 +1,375 @@
+// Package session keeps compression sessions: inputs that are uploaded in
+// chunks over several requests and compressed once the last one is in.
+// Chunks are appended to a temporary file in checksummed frames, so an input
+// only has to fit on disk while it is being uploaded and is checked when it
+// is read back. Inputs can also be uploaded in numbered parts, several at a
+// time and in any order, each kept in a file of its own until the parts are
+// read back in order.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+)
+
+// frameChunk is the type of the frames a session's data is stored in
+const frameChunk = 1
+
+// FilePattern matches the names of the temporary files of sessions in the
+// directory of the store: their data, their parts and their outputs
+const FilePattern = "compression-session-*"
+
+// chunkFrameSize is the largest payload of a frame, chunks are split into
+// frames of up to this size
+const chunkFrameSize = 64 << 10
+
+var (
+	// ErrNotFound is returned for sessions that never existed, were finished
+	// or deleted, or expired
+	ErrNotFound = errors.New("session: not found")
+
+	// ErrTooLarge is returned by Append when the chunk would take the
+	// session past the maximum size, the chunk is not kept
+	ErrTooLarge = errors.New("session: maximum size exceeded")
+
+	// ErrBusy is returned when the session is being appended to or
+	// finished by another request
+	ErrBusy = errors.New("session: busy with another request")
+)
+
+// OffsetError is returned by Append when the chunk does not start where the
+// session's data ends, typically because an earlier chunk was lost or is
+// being retried. Size is where the next chunk has to start.
+type OffsetError struct {
+	Offset int64
+	Size   int64
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("session: chunk starts at %d but the session has %d bytes", e.Offset, e.Size)
+}
+
+// Session is an input being uploaded
+type Session struct {
+	ID      string
+	Name    string // of the file being uploaded, may be empty
+	Options compression.Options
+	Created time.Time
+
+	// NameTemplate names the output, it is up to the caller of Create to
+	// set it before the session is handed out
+	NameTemplate string
+
+	busy     sync.RWMutex // held while appending or finishing, shared while uploading parts
+	file     *os.File
+	size     int64     // of the data
+	stored   int64     // of the file, the data in frames
+	lastUsed time.Time // guarded by the store's lock
+
+	partsLock sync.Mutex
+	parts     map[int]*part // by number
+	removed   bool          // the session was deleted, parts uploading are dropped
+}
+
+// Store holds the open sessions. Sessions that are not used for the TTL
+// are deleted along with their data.
+type Store struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+
+	lock     sync.Mutex
+	sessions map[string]*Session
+	closed   chan struct{}
+}
+
+// NewStore returns a store keeping the data of its sessions in dir, the
+// system's temporary directory when empty
+func NewStore(dir string, maxSize int64, ttl time.Duration) *Store {
+	s := &Store{
+		dir:      dir,
+		maxSize:  maxSize,
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+		closed:   make(chan struct{}),
+	}
+	go s.expire()
+	return s
+}
+
+// MaxSize returns the largest input a session takes
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// TTL returns how long a session is kept without being used
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create opens a session for the file name, compressing with options
+func (s *Store) Create(name string, options compression.Options) (*Session, error) {
+	if !compression.IsValidAlgorithm(options.Algorithm) {
+		return nil, fmt.Errorf("unsupported algorithm: %s", options.Algorithm)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(s.dir, "compression-session-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session file: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{ID: hex.EncodeToString(id), Name: name, Options: options, Created: now, file: file, lastUsed: now}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions == nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, errors.New("session: store is closed")
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+// Get returns the session with the id. Its exported fields do not change
+// once it is created.
+func (s *Store) Get(id string) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// Append adds what is read from r to the end of the session's data and
+// returns the new size. offset is where the chunk starts, or -1 to append
+// without checking. A chunk that fails part way is dropped, so it can be
+// sent again.
+func (s *Store) Append(id string, offset int64, r io.Reader) (int64, error) {
+	session, err := s.acquire(id)
+	if err != nil {
+		return 0, err
+	}
+	defer s.release(session)
+
+	if len(session.parts) > 0 {
+		return 0, ErrMixedUpload
+	}
+	if offset >= 0 && offset != session.size {
+		return session.size, &OffsetError{Offset: offset, Size: session.size}
+	}
+	n, err := writeFrames(session.file, r, s.maxSize-session.size)
+	var stored int64
+	if err == nil {
+		stored, err = session.file.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		if truncErr := session.file.Truncate(session.stored); truncErr != nil {
+			return session.size, errors.Join(err, truncErr)
+		}
+		_, seekErr := session.file.Seek(session.stored, io.SeekStart)
+		return session.size, errors.Join(err, seekErr)
+	}
+	session.size += n
+	session.stored = stored
+	return session.size, nil
+}
+
+// writeFrames writes what is read from r to file in checksummed frames and
+// returns how much it read. Reading more than limit bytes fails with
+// ErrTooLarge.
+func writeFrames(file *os.File, r io.Reader, limit int64) (int64, error) {
+	// one byte more than allowed tells a chunk that is too large from one
+	// that fits exactly
+	r = io.LimitReader(r, limit+1)
+	frames := framing.NewWriter(file, true)
+	buf := make([]byte, chunkFrameSize)
+	n := int64(0)
+	var err error
+	for err == nil {
+		m, readErr := io.ReadFull(r, buf)
+		n += int64(m)
+		switch {
+		case n > limit:
+			err = ErrTooLarge
+		case m > 0:
+			err = frames.WriteFrame(frameChunk, buf[:m])
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if err == nil {
+			err = readErr
+		}
+	}
+	return n, err
+}
+
+// Finish compresses the session's data into dst and deletes the session.
+// When compressing fails the session is kept, so finishing can be retried.
+// A session uploaded in parts is completed with as many parts as were
+// uploaded, see Complete.
+func (s *Store) Finish(ctx context.Context, id string, dst io.Writer) (*compression.Stats, error) {
+	session, err := s.acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(session.parts) > 0 {
+		return s.completeParts(ctx, session, 0, dst)
+	}
+	if _, err := session.file.Seek(0, io.SeekStart); err != nil {
+		s.release(session)
+		return nil, err
+	}
+	return s.finish(ctx, session, &chunkReader{frames: framing.NewReader(session.file, chunkFrameSize)}, dst)
+}
+
+// finish compresses what is read from src, the data of the session which
+// is held by the caller, into dst and deletes the session. When compressing
+// fails the session is released and kept.
+func (s *Store) finish(ctx context.Context, session *Session, src io.Reader, dst io.Writer) (*compression.Stats, error) {
+	stats, err := compression.CompressStream(ctx, dst, src, session.Options)
+	if err != nil {
+		session.file.Seek(session.stored, io.SeekStart)
+		s.release(session)
+		return nil, err
+	}
+
+	s.lock.Lock()
+	delete(s.sessions, session.ID)
+	s.lock.Unlock()
+	session.busy.Unlock()
+	return stats, session.remove()
+}
+
+// Delete deletes the session and its data
+func (s *Store) Delete(id string) error {
+	session, err := s.acquire(id)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	delete(s.sessions, id)
+	s.lock.Unlock()
+	session.busy.Unlock()
+	return session.remove()
+}
+
+// Close deletes all sessions and stops expiring them
+func (s *Store) Close() error {
+	s.lock.Lock()
+	sessions := s.sessions
+	s.sessions = nil
+	s.lock.Unlock()
+	if sessions == nil {
+		return nil
+	}
+	close(s.closed)
+
+	var errs []error
+	for _, session := range sessions {
+		// requests still using the session fail on the closed file
+		errs = append(errs, session.remove())
+	}
+	return errors.Join(errs...)
+}
+
+// acquire looks the session up and locks it for one request
+func (s *Store) acquire(id string) (*Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !session.busy.TryLock() {
+		return nil, ErrBusy
+	}
+	session.lastUsed = time.Now()
+	return session, nil
+}
+
+func (s *Store) release(session *Session) {
+	s.lock.Lock()
+	session.lastUsed = time.Now()
+	s.lock.Unlock()
+	session.busy.Unlock()
+}
+
+// expire deletes the sessions that were not used for the TTL, every tenth
+// of it
+func (s *Store) expire() {
+	ticker := time.NewTicker(max(s.ttl/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			var expired []*Session
+			s.lock.Lock()
+			for id, session := range s.sessions {
+				if now.Sub(session.lastUsed) > s.ttl && session.busy.TryLock() {
+					delete(s.sessions, id)
+					expired = append(expired, session)
+				}
+			}
+			s.lock.Unlock()
+			for _, session := range expired {
+				session.remove()
+				session.busy.Unlock()
+			}
+		}
+	}
+}
+
+// chunkReader reads the data of a session back out of its frames
+type chunkReader struct {
+	frames *framing.Reader
+	rest   []byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.rest) == 0 {
+		frame, err := cr.frames.Next()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("session: stored data is corrupt: %w", err)
+		}
+		if frame.Type != frameChunk {
+			return 0, fmt.Errorf("session: stored data is corrupt: frame of type %d", frame.Type)
+		}
+		cr.rest = frame.Payload
+	}
+	n := copy(p, cr.rest)
+	cr.rest = cr.rest[n:]
+	return n, nil
+}
+
+func (s *Session) remove() error {
+	s.partsLock.Lock()
+	defer s.partsLock.Unlock()
+	s.removed = true
+	errs := []error{s.file.Close(), os.Remove(s.file.Name())}
+	for _, p := range s.parts {
+		errs = append(errs, p.remove())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,165 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/framing"
+)
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, 100, time.Minute)
+	defer store.Close()
+
+	session, err := store.Create("chunks.txt", compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := []string{"the first chunk, ", "the second chunk, ", "the last chunk"}
+	for _, chunk := range chunks {
+		if _, err := store.Append(session.ID, -1, strings.NewReader(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := int64(len(strings.Join(chunks, "")))
+
+	// a retried chunk is refused and says where to continue
+	var offsetErr *OffsetError
+	if _, err := store.Append(session.ID, 0, strings.NewReader(chunks[0])); !errors.As(err, &offsetErr) || offsetErr.Size != size {
+		t.Fatalf("Append at 0 = %v, want an OffsetError at %d", err, size)
+	}
+	// a chunk past the maximum size is dropped
+	if n, err := store.Append(session.ID, size, strings.NewReader(strings.Repeat("x", 100))); !errors.Is(err, ErrTooLarge) || n != size {
+		t.Fatalf("Append of a large chunk = %d, %v, want %d, ErrTooLarge", n, err, size)
+	}
+
+	var compressed bytes.Buffer
+	stats, err := store.Finish(context.Background(), session.ID, &compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.OriginalSize != size || stats.ProcessedSize != int64(compressed.Len()) {
+		t.Errorf("stats = %+v for %d bytes compressed to %d", stats, size, compressed.Len())
+	}
+	content, _, err := compression.Decompress(compressed.Bytes(), compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != strings.Join(chunks, "") {
+		t.Errorf("decompressed to %q", content)
+	}
+
+	if _, err := store.Append(session.ID, -1, strings.NewReader("more")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Append after Finish = %v, want ErrNotFound", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left behind", len(entries))
+	}
+}
+
+func TestSessionCorruptData(t *testing.T) {
+	store := NewStore(t.TempDir(), 1<<20, time.Minute)
+	defer store.Close()
+
+	session, err := store.Create("", compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// several frames, one byte short of a second full one
+	if _, err := store.Append(session.ID, 0, bytes.NewReader(bytes.Repeat([]byte("z"), 2*chunkFrameSize-1))); err != nil {
+		t.Fatal(err)
+	}
+	// a byte of the stored data goes bad on disk
+	data, err := os.ReadFile(session.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(session.file.Name(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Finish(context.Background(), session.ID, io.Discard); !errors.Is(err, framing.ErrChecksum) {
+		t.Errorf("Finish of corrupt data = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestSessionParts(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, 100, time.Minute)
+	defer store.Close()
+
+	session, err := store.Create("parts.txt", compression.Options{Algorithm: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []string{"the first part, ", "the second part, ", "the last part"}
+	// uploaded at the same time and out of order, the second one twice
+	var wg sync.WaitGroup
+	for _, number := range []int{3, 1, 2, 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.UploadPart(session.ID, number, strings.NewReader(parts[number-1])); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := store.Append(session.ID, -1, strings.NewReader("chunk")); !errors.Is(err, ErrMixedUpload) {
+		t.Errorf("Append to a session of parts = %v, want ErrMixedUpload", err)
+	}
+	// a part too large is only read for as long as the room left
+	oversized := &countingReader{r: strings.NewReader(strings.Repeat("x", 10000))}
+	if _, err := store.UploadPart(session.ID, 4, oversized); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("UploadPart past the maximum size = %v, want ErrTooLarge", err)
+	}
+	if left := 100 - len(strings.Join(parts, "")); oversized.n > int64(left)+1 {
+		t.Errorf("UploadPart read %d bytes of a part with %d bytes of room left", oversized.n, left)
+	}
+	var partsErr *PartsError
+	if _, err := store.Complete(context.Background(), session.ID, 5, io.Discard); !errors.As(err, &partsErr) || !slices.Equal(partsErr.Missing, []int{4, 5}) {
+		t.Fatalf("Complete of 5 parts = %v, want parts 4 and 5 missing", err)
+	}
+	if _, err := store.Complete(context.Background(), session.ID, 2, io.Discard); !errors.As(err, &partsErr) || !slices.Equal(partsErr.Extra, []int{3}) {
+		t.Fatalf("Complete of 2 parts = %v, want part 3 past them", err)
+	}
+	uploaded, err := store.Parts(session.ID)
+	if err != nil || len(uploaded) != 3 || uploaded[1] != (Part{Number: 2, Size: int64(len(parts[1]))}) {
+		t.Fatalf("Parts = %v, %v", uploaded, err)
+	}
+
+	var compressed bytes.Buffer
+	if _, err := store.Complete(context.Background(), session.ID, 3, &compressed); err != nil {
+		t.Fatal(err)
+	}
+	content, _, err := compression.Decompress(compressed.Bytes(), compression.Options{Algorithm: "gzip"})
+	if err != nil || string(content) != strings.Join(parts, "") {
+		t.Errorf("decompressed to %q, %v", content, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left behind", len(entries))
+	}
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
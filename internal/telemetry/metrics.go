@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Histograms of the compression service, all of them carry the algorithm
+type instruments struct {
+	ratio         metric.Float64Histogram // compressed size as a percentage of the original
+	inputSize     metric.Int64Histogram
+	stageDuration metric.Float64Histogram // per span started with Start
+}
+
+var (
+	instrumentsOnce sync.Once
+	histograms      instruments
+)
+
+// getInstruments creates the histograms on first use. They are created from
+// the global provider, which forwards to the one Setup installs even when
+// that happens later.
+func getInstruments() *instruments {
+	instrumentsOnce.Do(func() {
+		meter := otel.Meter(instrumentationName)
+		// errors only come from invalid names or units, the instruments
+		// returned with them are no-ops
+		histograms.ratio, _ = meter.Float64Histogram("compression.ratio",
+			metric.WithDescription("Compressed size as a percentage of the original size"),
+			metric.WithUnit("%"),
+			metric.WithExplicitBucketBoundaries(10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 125, 150, 200))
+		histograms.inputSize, _ = meter.Int64Histogram("compression.input.size",
+			metric.WithDescription("Size of the data handed to an algorithm"),
+			metric.WithUnit("By"),
+			metric.WithExplicitBucketBoundaries(1<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20, 16<<20, 64<<20, 256<<20, 1<<30))
+		histograms.stageDuration, _ = meter.Float64Histogram("compression.stage.duration",
+			metric.WithDescription("Time spent in a stage of an algorithm"),
+			metric.WithUnit("s"),
+			metric.WithExplicitBucketBoundaries(0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30))
+	})
+	return &histograms
+}
+
+type algorithmKey struct{}
+
+// WithAlgorithm returns a copy of ctx in which the spans started with Start
+// time a stage of algorithm. Spans in contexts without an algorithm, such as
+// those of HTTP requests, are not timed.
+func WithAlgorithm(ctx context.Context, algorithm string) context.Context {
+	return context.WithValue(ctx, algorithmKey{}, algorithm)
+}
+
+// RecordSizes records the input size and the ratio of an operation
+// ("compress" or "decompress"). ratio is a percentage as in
+// compression.Stats, it is left out when there was no input or no output.
+func RecordSizes(ctx context.Context, algorithm, operation string, inputSize int, ratio float64) {
+	attributes := metric.WithAttributes(attribute.String("algorithm", algorithm), attribute.String("operation", operation))
+	instruments := getInstruments()
+	instruments.inputSize.Record(ctx, int64(inputSize), attributes)
+	if inputSize > 0 && !math.IsInf(ratio, 0) {
+		instruments.ratio.Record(ctx, ratio, attributes)
+	}
+}
+
+// stageSpan records how long it was open in the stage duration histogram
+// when it ends
+type stageSpan struct {
+	trace.Span
+	ctx       context.Context
+	algorithm string
+	stage     string
+	start     time.Time
+}
+
+func (s *stageSpan) End(options ...trace.SpanEndOption) {
+	s.Span.End(options...)
+	getInstruments().stageDuration.Record(s.ctx, time.Since(s.start).Seconds(),
+		metric.WithAttributes(attribute.String("algorithm", s.algorithm), attribute.String("stage", s.stage)))
+}
+
+var (
+	experimentOnce     sync.Once
+	experimentRatio    metric.Float64Histogram
+	experimentDuration metric.Float64Histogram
+)
+
+// RecordExperiment records how a candidate configuration compressed an input
+// compared with the algorithm the request used: the difference in ratio, in
+// percentage points, and in time, negative when the candidate did better
+func RecordExperiment(ctx context.Context, algorithm, candidate string, ratioDelta float64, durationDelta time.Duration) {
+	experimentOnce.Do(func() {
+		meter := otel.Meter(instrumentationName)
+		experimentRatio, _ = meter.Float64Histogram("compression.experiment.ratio.delta",
+			metric.WithDescription("Compression ratio of the candidate minus that of the request"),
+			metric.WithUnit("%"),
+			metric.WithExplicitBucketBoundaries(-20, -10, -5, -2, -1, -0.5, 0, 0.5, 1, 2, 5, 10, 20))
+		experimentDuration, _ = meter.Float64Histogram("compression.experiment.duration.delta",
+			metric.WithDescription("Compression time of the candidate minus that of the request"),
+			metric.WithUnit("s"),
+			metric.WithExplicitBucketBoundaries(-1, -0.1, -0.01, -0.001, 0, 0.001, 0.01, 0.1, 1))
+	})
+	attributes := metric.WithAttributes(attribute.String("algorithm", algorithm), attribute.String("candidate", candidate))
+	experimentRatio.Record(ctx, ratioDelta, attributes)
+	experimentDuration.Record(ctx, durationDelta.Seconds(), attributes)
+}
+
+var (
+	queueWaitOnce sync.Once
+	queueWait     metric.Float64Histogram
+)
+
+// RecordQueueWait records how long a job of priority waited for a worker
+func RecordQueueWait(ctx context.Context, priority string, wait time.Duration) {
+	queueWaitOnce.Do(func() {
+		meter := otel.Meter(instrumentationName)
+		queueWait, _ = meter.Float64Histogram("compression.queue.wait",
+			metric.WithDescription("Time a job waited for a worker"),
+			metric.WithUnit("s"),
+			metric.WithExplicitBucketBoundaries(0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30))
+	})
+	queueWait.Record(ctx, wait.Seconds(), metric.WithAttributes(attribute.String("priority", priority)))
+}
+
+var (
+	cleanupOnce    sync.Once
+	cleanupObjects metric.Int64Counter
+	cleanupBytes   metric.Int64Counter
+)
+
+// RecordCleanup records a temporary object of source that was removed, for
+// reason "ttl" when it went unused too long or "quota" to make room
+func RecordCleanup(ctx context.Context, source, reason string, size int64) {
+	cleanupOnce.Do(func() {
+		meter := otel.Meter(instrumentationName)
+		cleanupObjects, _ = meter.Int64Counter("janitor.removed.objects",
+			metric.WithDescription("Temporary objects removed by the janitor"))
+		cleanupBytes, _ = meter.Int64Counter("janitor.removed.size",
+			metric.WithDescription("Bytes of the temporary objects removed by the janitor"),
+			metric.WithUnit("By"))
+	})
+	attributes := metric.WithAttributes(attribute.String("source", source), attribute.String("reason", reason))
+	cleanupObjects.Add(ctx, 1, attributes)
+	cleanupBytes.Add(ctx, size, attributes)
+}
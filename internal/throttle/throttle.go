@@ -0,0 +1,114 @@
+// Package throttle limits how fast responses are written, so one large
+// transfer cannot take all of the bandwidth from other clients. A Limiter
+// is a token bucket that can be shared: one for the whole server and one
+// per request make up the limits a response is written with.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most written at a time, smaller chunks keep a response
+// that shares a limiter from holding it up for long
+const chunkSize = 16 * 1024
+
+// Limiter lets through Rate bytes per second on average, and up to a
+// second's worth at once after it was idle. It is safe for concurrent use,
+// waiters are served in the order they asked.
+type Limiter struct {
+	rate float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter for rate bytes per second, or nil when rate
+// is not positive. A nil limiter does not limit.
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// Rate returns the bytes per second l lets through, 0 for a nil limiter
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// Wait blocks until n more bytes may be written. The bytes are reserved
+// right away, so a waiter that gives up when ctx is done still counts them.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Writer writes to w no faster than every one of its limiters allows
+type Writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+	chunk    int
+}
+
+// NewWriter returns a writer that waits for limiters before it writes to w,
+// nil limiters are left out. Waiting stops when ctx is done.
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) *Writer {
+	writer := &Writer{ctx: ctx, w: w, chunk: chunkSize}
+	for _, limiter := range limiters {
+		if limiter != nil {
+			writer.limiters = append(writer.limiters, limiter)
+			// a chunk has to fit into a second's worth of every limiter
+			writer.chunk = min(writer.chunk, max(int(limiter.rate), 1))
+		}
+	}
+	return writer
+}
+
+func (tw *Writer) Write(p []byte) (int, error) {
+	if len(tw.limiters) == 0 {
+		return tw.w.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), tw.chunk)]
+		for _, limiter := range tw.limiters {
+			if err := limiter.Wait(tw.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
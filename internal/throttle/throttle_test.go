@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWriterRate(t *testing.T) {
+	// the first second's worth goes out at once, the next at the rate
+	var out bytes.Buffer
+	writer := NewWriter(context.Background(), &out, NewLimiter(20_000))
+	start := time.Now()
+	if _, err := writer.Write(make([]byte, 30_000)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("30000 bytes at 20000 bytes per second took %v, want about 500ms", elapsed)
+	}
+	if out.Len() != 30_000 {
+		t.Errorf("wrote %d bytes, want 30000", out.Len())
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	// two writers sharing a limiter get its rate together
+	shared := NewLimiter(10_000)
+	done := make(chan time.Duration, 2)
+	start := time.Now()
+	for range 2 {
+		go func() {
+			var out bytes.Buffer
+			NewWriter(context.Background(), &out, shared, nil).Write(make([]byte, 10_000))
+			done <- time.Since(start)
+		}()
+	}
+	last := max(<-done, <-done)
+	if last < 800*time.Millisecond {
+		t.Errorf("20000 bytes through a shared 10000 bytes per second limiter took %v, want about 1s", last)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	writer := NewWriter(ctx, &out, NewLimiter(1000))
+	if _, err := writer.Write(make([]byte, 5000)); err != context.Canceled {
+		t.Errorf("writing past the limit with a cancelled context returned %v", err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	if NewLimiter(0) != nil {
+		t.Error("a limiter without a rate was not nil")
+	}
+	var out bytes.Buffer
+	if n, err := NewWriter(context.Background(), &out, nil).Write(make([]byte, 1<<20)); n != 1<<20 || err != nil {
+		t.Errorf("an unlimited writer wrote %d, %v", n, err)
+	}
+}
@@ -10,26 +10,127 @@ import (
 	"time"
 
 	"github.com/adilg123/file-compression-decompression-tool/internal/api"
+	"github.com/adilg123/file-compression-decompression-tool/internal/cache"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
 	"github.com/adilg123/file-compression-decompression-tool/internal/config"
-	"github.com/gin-gonic/gin"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/adilg123/file-compression-decompression-tool/internal/history"
+	"github.com/adilg123/file-compression-decompression-tool/internal/janitor"
+	"github.com/adilg123/file-compression-decompression-tool/internal/secrets"
+	"github.com/adilg123/file-compression-decompression-tool/internal/session"
+	"github.com/adilg123/file-compression-decompression-tool/internal/telemetry"
 )
 
 func main() {
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "compress" {
+		os.Exit(runCompress(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decompress" {
+		os.Exit(runDecompress(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "regress" {
+		os.Exit(runRegress(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		os.Exit(runArchive(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tar" {
+		os.Exit(runTar(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "untar" {
+		os.Exit(runUntar(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "container" {
+		os.Exit(runContainer(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seekable" {
+		os.Exit(runSeekable(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "delta" {
+		os.Exit(runDelta(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		os.Exit(runPatch(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "checksum" {
+		os.Exit(runChecksum(os.Args[2:]))
+	}
+
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	if cfg.ProxyTarget != "" {
+		os.Exit(runProxy(cfg))
+	}
+
+	// Load API keys and signing secrets
+	keys, err := secrets.Load()
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
 
-	// Set Gin mode based on environment
-	if cfg.Environment == "production" {
-		gin.SetMode(gin.ReleaseMode)
+	// Open the store the stats of every job are kept in
+	var jobs *history.Store
+	if cfg.StatsDB != "" {
+		jobs, err = history.Open(cfg.StatsDB)
+		if err != nil {
+			log.Fatalf("Refusing to start: failed to open STATS_DB: %v", err)
+		}
+		defer jobs.Close()
 	}
 
-	// Create router
-	router := gin.New()
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	// Inputs uploaded in chunks are kept in temporary files until finished
+	sessions := session.NewStore("", cfg.SessionMaxSize, cfg.SessionTTL)
+	defer sessions.Close()
+
+	// Dictionaries are held in memory, and in DICTIONARY_DIR when it is set
+	dictionaries, err := dictionary.Open(cfg.DictionaryDir)
+	if err != nil {
+		log.Fatalf("Refusing to start: failed to open DICTIONARY_DIR: %v", err)
+	}
+	dictionaries.SetQuota(cfg.DictionaryQuota)
+
+	// Decompressed output is kept for inputs that come again, when enabled
+	var results *cache.Cache
+	if cfg.ResultCacheSize > 0 {
+		results, err = cache.New(cfg.ResultCacheSize, cfg.ResultCacheDir)
+		if err != nil {
+			log.Fatalf("Refusing to start: failed to open RESULT_CACHE_DIR: %v", err)
+		}
+	}
+
+	// Temporary objects are swept so a long running server does not slowly
+	// fill its disk
+	cleanup := janitor.New(cfg.JanitorInterval)
+	defer cleanup.Close()
+	cleanup.Register("sessions", sessions, janitor.Policy{MaxBytes: cfg.SpillQuota})
+	cleanup.Register("spill-files", janitor.Files{Dir: sessions.Dir(), Pattern: session.FilePattern, Held: sessions.Holds}, janitor.Policy{TTL: cfg.SessionTTL})
+	if results != nil {
+		cleanup.Register("result-cache", results, janitor.Policy{TTL: cfg.ResultCacheTTL})
+	}
+
+	// Profiles expose memory contents, keep them private in production
+	if cfg.DebugEndpoints && cfg.Environment == "production" && !keys.Configured(secrets.APIKeys) {
+		log.Fatalf("Refusing to start: DEBUG_ENDPOINTS requires API_KEYS in production")
+	}
+
+	// Export traces when an OTLP endpoint is configured
+	shutdownTracing, err := telemetry.Setup(context.Background(), "compression-service", "1.0.0")
+	if err != nil {
+		log.Fatalf("Refusing to start: failed to set up tracing: %v", err)
+	}
+
+	// Gin mode and request logging follow the environment
+	router := api.NewRouter(cfg)
 
 	// Setup API routes
-	api.SetupRoutes(router)
+	api.SetupRoutes(router, cfg, keys, jobs, sessions, dictionaries, results, cleanup)
 
 	// Create server
 	server := &http.Server{
@@ -42,12 +143,51 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled() {
+			log.Printf("Server starting on port %s (TLS)", cfg.Port)
+			err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Server starting on port %s", cfg.Port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
 
+	// Build the codec tables and check that every algorithm round-trips binary
+	// data before advertising it, /ready answers 503 until this is done
+	go func() {
+		start := time.Now()
+		matrix, err := compression.Warm()
+		if err != nil {
+			log.Printf("Warm-up failed, the tables are built by the first requests instead: %v", err)
+			api.MarkReady()
+			return
+		}
+		for algorithm, capabilities := range matrix {
+			if capabilities.BinarySafe && !capabilities.Verified {
+				log.Printf("Algorithm %s failed the binary self-test and is not advertised: %s", algorithm, capabilities.VerifyError)
+			}
+		}
+		api.MarkReady()
+		log.Printf("Warm-up finished in %s, ready", time.Since(start).Round(time.Millisecond))
+	}()
+
+	// Reload secrets on SIGHUP so keys can be rotated without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := keys.Reload(); err != nil {
+				log.Printf("Failed to reload secrets, keeping previous ones: %v", err)
+			} else {
+				log.Println("Secrets reloaded")
+			}
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -61,6 +201,9 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 
 	log.Println("Server exited")
 }
\ No newline at end of file
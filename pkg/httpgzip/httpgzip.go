@@ -0,0 +1,318 @@
+// Package httpgzip compresses the responses of any http.Handler with the
+// gzip and zlib writers of this module, for clients that accept them.
+//
+//	http.ListenAndServe(":8080", httpgzip.Handler(mux))
+package httpgzip
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/flate"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/gzip"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression/algorithms/zlib"
+)
+
+// DefaultMinSize is the smallest response compressed by default, below about
+// a packet compression saves no round trips
+const DefaultMinSize = 1400
+
+// DefaultContentTypes are the media types compressed by default, types
+// ending in "/" match every subtype. Event streams are never compressed.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Options configure Wrap, zero values select the defaults
+type Options struct {
+	MinSize      int      // responses shorter than this are sent as they are
+	ContentTypes []string // media types that are compressed
+}
+
+// encodings maps the content codings that can be negotiated to a function
+// returning a fresh writer pair, preferred first
+var encodings = []struct {
+	name string
+	new  func() (io.ReadCloser, io.WriteCloser)
+}{
+	{"gzip", func() (io.ReadCloser, io.WriteCloser) {
+		return gzip.NewCompressionReaderAndWriter(flate.NewCompressionReaderAndWriter(flate.AutoBlockType, 1))
+	}},
+	// "deflate" is zlib wrapped deflate, RFC 9110 section 8.4.1.2
+	{"deflate", func() (io.ReadCloser, io.WriteCloser) {
+		return zlib.NewCompressionReaderAndWriter(flate.NewCompressionReaderAndWriter(flate.AutoBlockType, 1))
+	}},
+}
+
+// Handler is Wrap with the default options
+func Handler(h http.Handler) http.Handler {
+	return Wrap(h, Options{})
+}
+
+// Wrap returns a handler that compresses the responses of h when the
+// request accepts gzip or deflate, the response has one of the content
+// types and is at least MinSize bytes long, and h did not encode it itself.
+// Responses are sent with Vary: Accept-Encoding either way.
+//
+// The writers send each block as soon as it is coded, so a long response
+// streams while the handler writes it. Flush sends the headers and the
+// blocks coded so far; the input of the block not yet finished stays behind
+// until more follows or the handler returns. A failure to compress
+// aborts the response with http.ErrAbortHandler, as nothing can be reported
+// once the headers are out.
+func Wrap(h http.Handler, options Options) http.Handler {
+	if options.MinSize <= 0 {
+		options.MinSize = DefaultMinSize
+	}
+	if options.ContentTypes == nil {
+		options.ContentTypes = DefaultContentTypes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding < 0 || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		rw := responseWriterPool.Get().(*responseWriter)
+		*rw = responseWriter{w: w, ctx: r.Context(), options: &options, encoding: encoding, buf: rw.buf}
+		defer func() {
+			rw.buf.Reset()
+			*rw = responseWriter{buf: rw.buf}
+			responseWriterPool.Put(rw)
+		}()
+		h.ServeHTTP(rw, r)
+		if err := rw.close(); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// responseWriterPool keeps the writers and the buffers they hold the start
+// of a response in, the compression writers themselves are single use
+var responseWriterPool = sync.Pool{
+	New: func() any { return &responseWriter{buf: new(bytes.Buffer)} },
+}
+
+// negotiate returns the index in encodings of the coding to use for the
+// Accept-Encoding header value, or -1 when none is acceptable. The highest
+// q-value wins, ties go to the order of encodings.
+func negotiate(accept string) int {
+	best, bestQ := -1, 0.0
+	for i, encoding := range encodings {
+		q, wildcard := -1.0, -1.0
+		for _, part := range strings.Split(accept, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			value := 1.0
+			if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(qs, 64)
+				if err != nil {
+					continue
+				}
+				value = parsed
+			}
+			switch name = strings.ToLower(strings.TrimSpace(name)); {
+			case name == encoding.name || name == "x-"+encoding.name:
+				q = value
+			case name == "*":
+				wildcard = value
+			}
+		}
+		if q < 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best
+}
+
+// responseWriter holds back the start of the response until it knows
+// whether to compress it: when it reaches MinSize bytes, or the handler
+// flushes or returns
+type responseWriter struct {
+	w        http.ResponseWriter
+	ctx      context.Context
+	options  *Options
+	encoding int
+
+	status  int           // passed to WriteHeader, 0 until then
+	buf     *bytes.Buffer // the start of the response
+	decided bool          // whether the headers are out
+
+	writer io.WriteCloser // the compression writer, nil when sending as is
+	copied chan error     // result of copying its output to w
+	lock   sync.Mutex     // held while the copy writes to w or Flush flushes it
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 && !rw.decided {
+		rw.status = status
+	}
+	if status < 200 && status != http.StatusSwitchingProtocols {
+		// informational responses go out right away and do not count
+		rw.w.WriteHeader(status)
+		rw.status = 0
+	}
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if !rw.decided {
+		// hold back no more than MinSize bytes
+		held := min(len(p), rw.options.MinSize-rw.buf.Len())
+		rw.buf.Write(p[:held])
+		if rw.buf.Len() < rw.options.MinSize {
+			return len(p), nil
+		}
+		if err := rw.decide(); err != nil {
+			return 0, err
+		}
+		n, err := rw.Write(p[held:])
+		return held + n, err
+	}
+	if rw.writer != nil {
+		return rw.writer.Write(p)
+	}
+	return rw.w.Write(p)
+}
+
+// Flush sends the headers, and what there is of an uncompressed response
+func (rw *responseWriter) Flush() {
+	if !rw.decided {
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		rw.decide()
+	}
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	http.NewResponseController(rw.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.w
+}
+
+// decide writes the headers, compressing when the response qualifies, and
+// sends on what was held back
+func (rw *responseWriter) decide() error {
+	rw.decided = true
+	if rw.compressible() {
+		header := rw.w.Header()
+		header.Set("Content-Encoding", encodings[rw.encoding].name)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		if etag := header.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			// the compressed body is a different representation
+			header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encodings[rw.encoding].name+`"`)
+		}
+		rw.w.WriteHeader(rw.status)
+
+		reader, writer := encodings[rw.encoding].new()
+		if setter, ok := writer.(interface{ SetContext(context.Context) }); ok {
+			setter.SetContext(rw.ctx)
+		}
+		rw.writer = writer
+		rw.copied = make(chan error, 1)
+		go func() {
+			_, err := io.Copy(lockedWriter{rw}, reader)
+			reader.Close()
+			rw.copied <- err
+		}()
+		_, err := rw.writer.Write(rw.buf.Bytes())
+		return err
+	}
+
+	rw.w.WriteHeader(rw.status)
+	_, err := rw.w.Write(rw.buf.Bytes())
+	return err
+}
+
+// lockedWriter writes the compressed output to the underlying writer, which
+// the handler may flush at the same time
+type lockedWriter struct {
+	rw *responseWriter
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.rw.lock.Lock()
+	defer l.rw.lock.Unlock()
+	return l.rw.w.Write(p)
+}
+
+// compressible reports whether the response is worth compressing, it is
+// asked once the headers are final
+func (rw *responseWriter) compressible() bool {
+	header := rw.w.Header()
+	if rw.buf.Len() < rw.options.MinSize || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if rw.status < 200 || rw.status == http.StatusNoContent || rw.status == http.StatusNotModified || rw.status == http.StatusPartialContent {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// net/http would sniff it from the same bytes
+		contentType = http.DetectContentType(rw.buf.Bytes())
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	// events are flushed as they happen, and a flush does not end the block
+	// the last of them is coded in, so the client would not see it
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	for _, t := range rw.options.ContentTypes {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			if header.Get("Content-Type") == "" {
+				header.Set("Content-Type", contentType)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// close finishes the response once the handler has returned
+func (rw *responseWriter) close() error {
+	if !rw.decided {
+		if rw.status == 0 && rw.buf.Len() == 0 {
+			// nothing was written, leave the defaults to net/http
+			return nil
+		}
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if err := rw.decide(); err != nil {
+			return err
+		}
+	}
+	if rw.writer == nil {
+		return nil
+	}
+	err := rw.writer.Close()
+	if copyErr := <-rw.copied; err == nil {
+		err = copyErr
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package httpgzip
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	page := strings.Repeat("<li>a line of a long listing</li>\n", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		// written in pieces, the first ones are held back
+		for i := 0; i < len(page); i += 100 {
+			io.WriteString(w, page[i:min(i+100, len(page))])
+		}
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "short")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte{0x89}, 4096))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, page)
+	})
+	mux.HandleFunc("/encoded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, page)
+	})
+	server := httptest.NewServer(Handler(mux))
+	defer server.Close()
+
+	tests := []struct {
+		path, accept string
+		encoding     string
+	}{
+		{"/page", "gzip", "gzip"},
+		{"/page", "deflate", "deflate"},
+		{"/page", "deflate, gzip;q=0.5", "deflate"},
+		{"/page", "gzip;q=0, *", "deflate"},
+		{"/page", "br", ""},
+		{"/page", "", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+		{"/events", "gzip", ""},
+		{"/encoded", "gzip", "br"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+test.path, nil)
+		// set explicitly, so the transport does not decode gzip itself
+		req.Header.Set("Accept-Encoding", test.accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		encoding := resp.Header.Get("Content-Encoding")
+		if encoding != test.encoding {
+			t.Errorf("%s with %q: Content-Encoding %q, want %q", test.path, test.accept, encoding, test.encoding)
+			continue
+		}
+		if resp.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s with %q: Vary is %q", test.path, test.accept, resp.Header.Get("Vary"))
+		}
+		var decoded io.Reader = bytes.NewReader(body)
+		switch encoding {
+		case "gzip":
+			decoded, err = stdgzip.NewReader(decoded)
+		case "deflate":
+			decoded, err = zlib.NewReader(decoded)
+		}
+		if err != nil {
+			t.Fatalf("%s with %q: %v", test.path, test.accept, err)
+		}
+		plain, err := io.ReadAll(decoded)
+		if err != nil {
+			t.Fatalf("%s with %q: decoding: %v", test.path, test.accept, err)
+		}
+		if test.path == "/page" && string(plain) != page {
+			t.Errorf("%s with %q: body does not match", test.path, test.accept)
+		}
+		if encoding == "gzip" || encoding == "deflate" {
+			if len(body) >= len(page)/2 {
+				t.Errorf("%s with %q: %d compressed bytes for %d", test.path, test.accept, len(body), len(page))
+			}
+		}
+	}
+}
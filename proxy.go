@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+	"github.com/adilg123/file-compression-decompression-tool/internal/proxy"
+	"github.com/adilg123/file-compression-decompression-tool/pkg/httpgzip"
+)
+
+// runProxy serves the reverse proxy of PROXY_TARGET instead of the API, until
+// SIGINT or SIGTERM, and returns the process exit code
+func runProxy(cfg *config.Config) int {
+	// Validate has checked the URL
+	target, _ := url.Parse(cfg.ProxyTarget)
+	options := proxy.Options{
+		MaxBodySize:    cfg.MaxFileSize,
+		MaxDecodedSize: cfg.MaxDecodedSize,
+		Compression:    httpgzip.Options{MinSize: int(cfg.ProxyMinSize)},
+	}
+	if cfg.ProxyContentTypes != "" {
+		for _, t := range strings.Split(cfg.ProxyContentTypes, ",") {
+			options.Compression.ContentTypes = append(options.Compression.ContentTypes, strings.TrimSpace(t))
+		}
+	}
+
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      proxy.New(target, options),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		var err error
+		if cfg.TLSEnabled() {
+			log.Printf("Proxy for %s starting on port %s (TLS)", target, cfg.Port)
+			err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Proxy for %s starting on port %s", target, cfg.Port)
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			failed <- err
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-failed:
+		log.Printf("Failed to start proxy: %v", err)
+		return 1
+	case <-quit:
+	}
+	log.Println("Shutting down proxy...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Proxy forced to shutdown: %v", err)
+		return 1
+	}
+	log.Println("Proxy exited")
+	return 0
+}
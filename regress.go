@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/bench"
+)
+
+// runRegress implements the "regress" command, it returns the process exit
+// code: 1 when any result regressed
+func runRegress(args []string) int {
+	flags := flag.NewFlagSet("regress", flag.ContinueOnError)
+	algorithms := flags.String("algorithms", "", "comma-separated algorithms to run, all supported ones when empty")
+	sizeTolerance := flags.Float64("size-tolerance", bench.DefaultTolerance.Size, "fraction the output may grow by on its baseline")
+	timeTolerance := flags.Float64("time-tolerance", bench.DefaultTolerance.Time, "fraction the durations may grow by on their baseline")
+	minTime := flags.Duration("min-time", bench.DefaultTolerance.MinTime, "growth of a duration below which it is never a regression")
+	update := flags.String("update-baselines", "", "record the results as the new baselines in this file, e.g. "+bench.BaselinesPath)
+	format := flags.String("format", "table", "output format: table or json")
+	output := flags.String("o", "", "write the results to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format: %s\n", *format)
+		return 2
+	}
+
+	tolerance := bench.Tolerance{Size: *sizeTolerance, Time: *timeTolerance, MinTime: *minTime}
+	report, err := bench.RunRegression(splitList(*algorithms), tolerance)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to run the regression corpus: %v\n", err)
+		return 1
+	}
+
+	if *update != "" {
+		if len(report.Report.Failed()) > 0 {
+			fmt.Fprintln(os.Stderr, "not updating the baselines, some results do not round-trip")
+			return 1
+		}
+		file, err := os.Create(*update)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *update, err)
+			return 1
+		}
+		defer file.Close()
+		if err := bench.NewBaselines(report.Report).WriteJSON(file); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the baselines: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "recorded %d baselines in %s\n", len(report.Report.Results), *update)
+		return 0
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if *format == "json" {
+		err = report.WriteJSON(out)
+	} else {
+		err = report.WriteTable(out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write results: %v\n", err)
+		return 1
+	}
+	if report.Regressions > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/seekable"
+)
+
+// runSeekable implements the "seekable" command with its create and read
+// subcommands, it returns the process exit code
+func runSeekable(args []string) int {
+	usage := "usage: seekable create [-o out] [-algorithm flate|gzip] [-frame-size KB] file\n" +
+		"       seekable read [-offset N] [-length N] file"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "create":
+		return runSeekableCreate(args[1:])
+	case "read":
+		return runSeekableRead(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func runSeekableCreate(args []string) int {
+	flags := flag.NewFlagSet("seekable create", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the stream to, the input name with .gz or .flate by default")
+	algorithm := flags.String("algorithm", "gzip", "algorithm of the frames, flate or gzip")
+	frameSize := flags.Int("frame-size", seekable.DefaultFrameSize/1024, "KB of input per frame")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: seekable create [-o out] [-algorithm flate|gzip] [-frame-size KB] file")
+		return 2
+	}
+	if *output == "" {
+		*output = flags.Arg(0) + "." + compression.Extension(*algorithm)
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	defer in.Close()
+	out, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	defer out.Close()
+	writer, err := seekable.NewWriter(context.Background(), out, *algorithm, *frameSize*1024)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to compress %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to compress %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// runSeekableRead writes a byte range of the content of a seekable stream to
+// stdout, reading only the frames that hold it
+func runSeekableRead(args []string) int {
+	flags := flag.NewFlagSet("seekable read", flag.ContinueOnError)
+	offset := flags.Int64("offset", 0, "first byte of the content to read")
+	length := flags.Int64("length", -1, "bytes to read, the rest of the content by default")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *offset < 0 {
+		fmt.Fprintln(os.Stderr, "usage: seekable read [-offset N] [-length N] file")
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	reader, err := seekable.NewReader(file, info.Size())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	if *length < 0 || *length > reader.Size()-*offset {
+		*length = max(reader.Size()-*offset, 0)
+	}
+	if _, err := io.Copy(os.Stdout, io.NewSectionReader(reader, *offset, *length)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
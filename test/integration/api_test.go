@@ -1,7 +1,219 @@
 package integration_test
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
 
-func TestPlaceholder(t *testing.T) {
-	t.Skip("TODO: implement integration tests")
+	"github.com/adilg123/file-compression-decompression-tool/internal/api"
+	"github.com/adilg123/file-compression-decompression-tool/internal/checksum"
+	"github.com/adilg123/file-compression-decompression-tool/internal/compression"
+	"github.com/adilg123/file-compression-decompression-tool/internal/config"
+	"github.com/adilg123/file-compression-decompression-tool/internal/dictionary"
+	"github.com/adilg123/file-compression-decompression-tool/internal/secrets"
+)
+
+// startServer serves the API as main sets it up for GO_ENV=test, with the
+// settings of the environment, which tests set with t.Setenv
+func startServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Setenv("GO_ENV", "test")
+	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := secrets.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionaries, err := dictionary.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := api.NewRouter(cfg)
+	api.SetupRoutes(router, cfg, keys, nil, nil, dictionaries, nil, nil)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postFile uploads data as the file of a form with fields, which come first
+// as the streaming endpoints want them
+func postFile(t *testing.T, url string, header http.Header, data []byte, fields ...string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for i := 0; i+1 < len(fields); i += 2 {
+		writer.WriteField(fields[i], fields[i+1])
+	}
+	part, _ := writer.CreateFormFile("file", "sample.txt")
+	part.Write(data)
+	writer.Close()
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// readBody drains and closes the body of resp, so its trailers can be read
+func readBody(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHealth(t *testing.T) {
+	server := startServer(t)
+	for _, path := range []string{"/health", "/api/v1/health", "/info"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := readBody(t, resp); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s answered %d: %s", path, resp.StatusCode, body)
+		}
+	}
+}
+
+// TestRoundTrip compresses an empty, a text and a random input with every
+// algorithm /api/v1/algorithms lists and has the output detected and
+// decompressed
+func TestRoundTrip(t *testing.T) {
+	server := startServer(t)
+	resp, err := http.Get(server.URL + "/api/v1/algorithms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listing struct {
+		Algorithms []struct {
+			Name  string `json:"name"`
+			Magic string `json:"magic"`
+		} `json:"algorithms"`
+	}
+	if err := json.Unmarshal(readBody(t, resp), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Algorithms) != len(compression.GetSupportedAlgorithms()) {
+		t.Fatalf("/api/v1/algorithms lists %d algorithms, want %d", len(listing.Algorithms), len(compression.GetSupportedAlgorithms()))
+	}
+
+	var text bytes.Buffer
+	for i := 0; text.Len() < 64<<10; i++ {
+		fmt.Fprintf(&text, "%d: the request took %dms and sent %d bytes\n", i, i%97, i*31%4096)
+	}
+	random := make([]byte, 16<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	for sample, original := range map[string][]byte{"empty": nil, "text": text.Bytes(), "random": random} {
+		for _, algorithm := range listing.Algorithms {
+			resp := postFile(t, server.URL+"/api/v1/compress", nil, original, "algorithm", algorithm.Name)
+			compressed := readBody(t, resp)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s of %s: compress answered %d: %s", algorithm.Name, sample, resp.StatusCode, compressed)
+				continue
+			}
+			if sum, want := resp.Trailer.Get("X-Checksum"), fmt.Sprintf("crc32=%08x", checksum.SumCRC32(original)); sum != want {
+				t.Errorf("%s of %s: X-Checksum = %q, want %q", algorithm.Name, sample, sum, want)
+			}
+
+			// flate has no header to be detected by
+			detect := "auto"
+			if len(algorithm.Magic) == 0 {
+				detect = algorithm.Name
+			}
+			resp = postFile(t, server.URL+"/api/v1/decompress", nil, compressed, "algorithm", detect)
+			decompressed := readBody(t, resp)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s of %s: decompress answered %d: %s", algorithm.Name, sample, resp.StatusCode, decompressed)
+			} else if !bytes.Equal(decompressed, original) {
+				t.Errorf("%s of %s: decompressed %d bytes, want the %d bytes compressed", algorithm.Name, sample, len(decompressed), len(original))
+			}
+		}
+	}
+}
+
+// TestAPIKeys checks that the API needs a key once API_KEYS is set, in
+// either header, while the health checks stay open
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("API_KEYS", "integration-key")
+	server := startServer(t)
+	data := []byte("data behind a key")
+
+	resp := postFile(t, server.URL+"/api/v1/compress", nil, data, "algorithm", "gzip")
+	if body := readBody(t, resp); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a key compress answered %d: %s", resp.StatusCode, body)
+	}
+	resp = postFile(t, server.URL+"/api/v1/compress", http.Header{"X-Api-Key": {"wrong-key"}}, data, "algorithm", "gzip")
+	if body := readBody(t, resp); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("with a wrong key compress answered %d: %s", resp.StatusCode, body)
+	}
+	for _, header := range []http.Header{
+		{"X-Api-Key": {"integration-key"}},
+		{"Authorization": {"Bearer integration-key"}},
+	} {
+		resp := postFile(t, server.URL+"/api/v1/compress", header, data, "algorithm", "gzip")
+		if body := readBody(t, resp); resp.StatusCode != http.StatusOK {
+			t.Errorf("with %v compress answered %d: %s", header, resp.StatusCode, body)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(t, resp); resp.StatusCode != http.StatusOK {
+		t.Errorf("/health needs a key, answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+// TestErrorResponses checks the status and the error code of requests the
+// API refuses
+func TestErrorResponses(t *testing.T) {
+	t.Setenv("MAX_FILE_SIZE", "1024")
+	server := startServer(t)
+	for _, test := range []struct {
+		name      string
+		path      string
+		data      []byte
+		fields    []string
+		status    int
+		errorCode api.ErrorCode
+	}{
+		{"unknown algorithm", "/api/v1/compress", []byte("data"), []string{"algorithm", "rot13"}, http.StatusBadRequest, api.ErrCodeAlgoUnsupported},
+		{"oversized file", "/api/v1/compress", make([]byte, 1025), []string{"algorithm", "gzip"}, http.StatusRequestEntityTooLarge, api.ErrCodeLimitExceeded},
+		{"missing algorithm", "/api/v1/decompress", []byte("data"), nil, http.StatusBadRequest, api.ErrCodeInvalidRequest},
+	} {
+		resp := postFile(t, server.URL+test.path, nil, test.data, test.fields...)
+		body := readBody(t, resp)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s answered %d, want %d: %s", test.name, resp.StatusCode, test.status, body)
+			continue
+		}
+		var response api.ErrorResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Errorf("%s: the response is not an ErrorResponse: %v", test.name, err)
+			continue
+		}
+		if response.ErrorCode != test.errorCode {
+			t.Errorf("%s: error code %s, want %s", test.name, response.ErrorCode, test.errorCode)
+		}
+	}
 }